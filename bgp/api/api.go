@@ -32,6 +32,7 @@ type ApiLayer struct {
 	bfdCh   chan config.BfdInfo
 	intfCh  chan config.IntfStateInfo
 	routeCh chan *config.RouteCh
+	macCh   chan *config.EvpnMacCh
 	//routeCh chan []*config.RouteInfo
 }
 
@@ -50,11 +51,13 @@ func getInstance() *ApiLayer {
 /*  Initialize bgp api layer with the channels that will be used for communicating
  *  with the server
  */
-func Init(bfdCh chan config.BfdInfo, intfCh chan config.IntfStateInfo, rCh chan *config.RouteCh) {
+func Init(bfdCh chan config.BfdInfo, intfCh chan config.IntfStateInfo, rCh chan *config.RouteCh,
+	macCh chan *config.EvpnMacCh) {
 	bgpapi = getInstance()
 	bgpapi.bfdCh = bfdCh
 	bgpapi.intfCh = intfCh
 	bgpapi.routeCh = rCh
+	bgpapi.macCh = macCh
 }
 
 /*  Send bfd state information from bfd manager to server
@@ -85,3 +88,12 @@ func SendRouteNotification(add []*config.RouteInfo, remove []*config.RouteInfo)
 		Remove: remove,
 	}
 }
+
+/*  Send locally learnt MACs on a VXLAN segment to server
+ */
+func SendEvpnMacNotification(add []*config.EvpnMacInfo, remove []*config.EvpnMacInfo) {
+	bgpapi.macCh <- &config.EvpnMacCh{
+		Add:    add,
+		Remove: remove,
+	}
+}
@@ -36,28 +36,52 @@ import (
 const IgnoreBfdFaultsDefaultTime uint32 = 300 // seconds
 
 type NeighborConf struct {
-	logger               *logging.Writer
-	Global               *config.GlobalConfig
-	Group                *config.PeerGroupConfig
-	Neighbor             *config.Neighbor
-	RunningConf          config.NeighborConfig
-	BGPId                net.IP
-	ASSize               uint8
-	AfiSafiMap           map[uint32]bool
-	MaxPrefixesThreshold uint32
-	ignoreBfdFaultsTimer *time.Timer
+	logger                *logging.Writer
+	Global                *config.GlobalConfig
+	Group                 *config.PeerGroupConfig
+	Neighbor              *config.Neighbor
+	RunningConf           config.NeighborConfig
+	BGPId                 net.IP
+	ASSize                uint8
+	AfiSafiMap            map[uint32]bool
+	PeerAfiSafiMap        map[uint32]bool
+	MaxPrefixesThreshold  uint32
+	PrefixLimits          map[uint32]config.PrefixLimit
+	AddPathsTxModes       map[uint32]string
+	AddPathsTxFamilies    map[uint32]bool
+	PrefixCounts          map[uint32]uint32
+	prefixLimitWarned     map[uint32]bool
+	prefixThresholdWarned map[uint32]bool
+	prefixLimitEvents     []config.PrefixLimitEvent
+	prefixLimitExceeded   bool
+	exceededFamily        uint32
+	exceededTotalLimit    bool
+	GracefulRestartCap    *packet.BGPCapGracefulRestart
+	Restarting            bool
+	RouteRefreshCap       bool
+	EnhancedRRCap         bool
+	ExtendedNextHopCap    bool
+	PrefixORFTxFamilies   map[uint32]bool
+	PrefixORFRxFamilies   map[uint32]bool
+	DefaultRouteFamilies  map[uint32]bool
+	IntfName              string
+	ignoreBfdFaultsTimer  *time.Timer
 }
 
 func NewNeighborConf(logger *logging.Writer, globalConf *config.GlobalConfig, peerGroup *config.PeerGroupConfig,
 	peerConf config.NeighborConfig) *NeighborConf {
 	conf := NeighborConf{
-		logger:               logger,
-		Global:               globalConf,
-		Group:                peerGroup,
-		AfiSafiMap:           make(map[uint32]bool),
-		BGPId:                net.IP{},
-		MaxPrefixesThreshold: 0,
-		RunningConf:          config.NeighborConfig{},
+		logger:                logger,
+		Global:                globalConf,
+		Group:                 peerGroup,
+		AfiSafiMap:            make(map[uint32]bool),
+		PeerAfiSafiMap:        make(map[uint32]bool),
+		BGPId:                 net.IP{},
+		MaxPrefixesThreshold:  0,
+		PrefixCounts:          make(map[uint32]uint32),
+		prefixLimitWarned:     make(map[uint32]bool),
+		prefixThresholdWarned: make(map[uint32]bool),
+		RunningConf:           config.NeighborConfig{},
 		Neighbor: &config.Neighbor{
 			NeighborAddress: peerConf.NeighborAddress,
 			Config:          peerConf,
@@ -67,17 +91,14 @@ func NewNeighborConf(logger *logging.Writer, globalConf *config.GlobalConfig, pe
 	conf.SetRunningConf(peerGroup, &conf.RunningConf)
 	conf.SetNeighborState(&conf.RunningConf)
 
-	if conf.RunningConf.LocalAS == conf.RunningConf.PeerAS {
-		conf.Neighbor.State.PeerType = config.PeerTypeInternal
-	} else {
-		conf.Neighbor.State.PeerType = config.PeerTypeExternal
-	}
+	conf.setPeerType()
 	if conf.RunningConf.BfdEnable {
 		conf.Neighbor.State.BfdNeighborState = "up"
 	} else {
 		conf.Neighbor.State.BfdNeighborState = "down"
 	}
 
+	conf.Neighbor.AfiSafis = conf.RunningConf.AfiSafis
 	conf.AfiSafiMap, _ = packet.GetProtocolFromConfig(&conf.Neighbor.AfiSafis)
 	return &conf
 }
@@ -95,6 +116,7 @@ func (n *NeighborConf) SetNeighborState(peerConf *config.NeighborConfig) {
 		RouteReflectorClient:    peerConf.RouteReflectorClient,
 		MultiHopEnable:          peerConf.MultiHopEnable,
 		MultiHopTTL:             peerConf.MultiHopTTL,
+		TtlSecurityHops:         peerConf.TtlSecurityHops,
 		ConnectRetryTime:        peerConf.ConnectRetryTime,
 		HoldTime:                peerConf.HoldTime,
 		KeepaliveTime:           peerConf.KeepaliveTime,
@@ -106,8 +128,28 @@ func (n *NeighborConf) SetNeighborState(peerConf *config.NeighborConfig) {
 		MaxPrefixesDisconnect:   peerConf.MaxPrefixesDisconnect,
 		MaxPrefixesRestartTimer: peerConf.MaxPrefixesRestartTimer,
 		TotalPrefixes:           0,
+		ImportPolicy:            peerConf.ImportPolicy,
+		ExportPolicy:            peerConf.ExportPolicy,
+		SoftReconfigInbound:     peerConf.SoftReconfigInbound,
+		PrefixListORF:           peerConf.PrefixListORF,
+		AllowAsIn:               peerConf.AllowAsIn,
+		LocalASNoPrepend:        peerConf.LocalASNoPrepend,
+		LocalASReplaceAS:        peerConf.LocalASReplaceAS,
+		RemovePrivateAS:         peerConf.RemovePrivateAS,
+		ASOverride:              peerConf.ASOverride,
+		AcceptOwn:               peerConf.AcceptOwn,
+		DefaultOriginatePolicy:  peerConf.DefaultOriginatePolicy,
+		AdvertisePolicy:         peerConf.AdvertisePolicy,
+		AdvertiseExistPolicy:    peerConf.AdvertiseExistPolicy,
+		AdvertiseNonExistPolicy: peerConf.AdvertiseNonExistPolicy,
+		RouteServerClient:       peerConf.RouteServerClient,
+		Dynamic:                 peerConf.Dynamic,
+		Draining:                n.Neighbor.State.Draining,
 	}
-	n.MaxPrefixesThreshold = uint32(float64(peerConf.MaxPrefixes*uint32(peerConf.MaxPrefixesThresholdPct)) / 100)
+	n.MaxPrefixesThreshold = getPrefixThreshold(peerConf.MaxPrefixes, peerConf.MaxPrefixesThresholdPct)
+	n.PrefixLimits = packet.GetPrefixLimitsFromConfig(&peerConf.AfiSafis)
+	n.AddPathsTxModes = packet.GetAddPathsTxModesFromConfig(peerConf.AddPathsTxMode, &peerConf.AfiSafis)
+	n.DefaultRouteFamilies = packet.GetDefaultRouteFamiliesFromConfig(&peerConf.AfiSafis)
 }
 
 func (n *NeighborConf) UpdateNeighborConf(nConf config.NeighborConfig, bgp *config.Bgp) {
@@ -125,6 +167,15 @@ func (n *NeighborConf) UpdateNeighborConf(nConf config.NeighborConfig, bgp *conf
 	n.SetNeighborState(&n.RunningConf)
 }
 
+func (n *NeighborConf) UpdateAuthPassword(nConf config.NeighborConfig) {
+	n.Neighbor.Config = nConf
+	n.RunningConf.AuthPassword = nConf.AuthPassword
+	if nConf.AuthPassword == "" && n.Group != nil {
+		n.RunningConf.AuthPassword = n.Group.AuthPassword
+	}
+	n.Neighbor.State.AuthPassword = n.RunningConf.AuthPassword
+}
+
 func (n *NeighborConf) UpdatePeerGroup(peerGroup *config.PeerGroupConfig) {
 	n.Group = peerGroup
 	n.RunningConf = config.NeighborConfig{}
@@ -189,6 +240,10 @@ func (n *NeighborConf) GetConfFromNeighbor(inConf *config.NeighborConfig, outCon
 		outConf.MultiHopTTL = inConf.MultiHopTTL
 	}
 
+	if inConf.TtlSecurityHops != 0 {
+		outConf.TtlSecurityHops = inConf.TtlSecurityHops
+	}
+
 	if inConf.ConnectRetryTime != 0 {
 		outConf.ConnectRetryTime = inConf.ConnectRetryTime
 	}
@@ -209,6 +264,10 @@ func (n *NeighborConf) GetConfFromNeighbor(inConf *config.NeighborConfig, outCon
 		outConf.AddPathsMaxTx = inConf.AddPathsMaxTx
 	}
 
+	if inConf.AddPathsTxMode != "" {
+		outConf.AddPathsTxMode = inConf.AddPathsTxMode
+	}
+
 	if inConf.BfdEnable != false {
 		outConf.BfdEnable = inConf.BfdEnable
 	}
@@ -229,79 +288,495 @@ func (n *NeighborConf) GetConfFromNeighbor(inConf *config.NeighborConfig, outCon
 		outConf.MaxPrefixesRestartTimer = inConf.MaxPrefixesRestartTimer
 	}
 
+	if inConf.ImportPolicy != "" {
+		outConf.ImportPolicy = inConf.ImportPolicy
+	}
+
+	if inConf.ExportPolicy != "" {
+		outConf.ExportPolicy = inConf.ExportPolicy
+	}
+
+	if len(inConf.AfiSafis) > 0 {
+		outConf.AfiSafis = inConf.AfiSafis
+	}
+
+	if inConf.SoftReconfigInbound != false {
+		outConf.SoftReconfigInbound = inConf.SoftReconfigInbound
+	}
+
+	if inConf.ExtendedNextHop != false {
+		outConf.ExtendedNextHop = inConf.ExtendedNextHop
+	}
+
+	if inConf.PrefixListORF != "" {
+		outConf.PrefixListORF = inConf.PrefixListORF
+	}
+
+	if inConf.AllowAsIn != 0 {
+		outConf.AllowAsIn = inConf.AllowAsIn
+	}
+
+	if inConf.LocalASNoPrepend != false {
+		outConf.LocalASNoPrepend = inConf.LocalASNoPrepend
+	}
+
+	if inConf.LocalASReplaceAS != false {
+		outConf.LocalASReplaceAS = inConf.LocalASReplaceAS
+	}
+
+	if inConf.RemovePrivateAS != "" {
+		outConf.RemovePrivateAS = inConf.RemovePrivateAS
+	}
+
+	if inConf.ASOverride != false {
+		outConf.ASOverride = inConf.ASOverride
+	}
+
+	if inConf.AcceptOwn != false {
+		outConf.AcceptOwn = inConf.AcceptOwn
+	}
+
+	if inConf.DefaultOriginatePolicy != "" {
+		outConf.DefaultOriginatePolicy = inConf.DefaultOriginatePolicy
+	}
+
+	if inConf.AdvertisePolicy != "" {
+		outConf.AdvertisePolicy = inConf.AdvertisePolicy
+	}
+
+	if inConf.AdvertiseExistPolicy != "" {
+		outConf.AdvertiseExistPolicy = inConf.AdvertiseExistPolicy
+	}
+
+	if inConf.AdvertiseNonExistPolicy != "" {
+		outConf.AdvertiseNonExistPolicy = inConf.AdvertiseNonExistPolicy
+	}
+
+	if inConf.RouteServerClient != false {
+		outConf.RouteServerClient = inConf.RouteServerClient
+	}
+
 	outConf.NeighborAddress = inConf.NeighborAddress
 	outConf.IfIndex = inConf.IfIndex
 	outConf.PeerGroup = inConf.PeerGroup
+	outConf.Dynamic = inConf.Dynamic
 }
 
 func (n *NeighborConf) IsInternal() bool {
 	return n.RunningConf.PeerAS == n.RunningConf.LocalAS
 }
 
+// IsExternal returns true for the peers outside the local AS and outside the confederation
 func (n *NeighborConf) IsExternal() bool {
-	return n.RunningConf.LocalAS != n.RunningConf.PeerAS
+	return n.RunningConf.LocalAS != n.RunningConf.PeerAS && !n.IsConfedExternal()
+}
+
+// IsConfedExternal returns true for the peers in another member AS of the confederation (RFC 5065)
+func (n *NeighborConf) IsConfedExternal() bool {
+	return n.RunningConf.LocalAS != n.RunningConf.PeerAS && n.Global != nil &&
+		n.Global.IsConfedMember(n.RunningConf.PeerAS)
+}
+
+/*  Returns the AS that is sent to the peer in the OPEN message and prepended to the AS path.
+ *  The peers outside the confederation see the confederation identifier as the local AS.
+ */
+func (n *NeighborConf) GetAdvertisedAS() uint32 {
+	if n.IsExternal() && n.Global != nil && n.Global.ConfederationId != 0 &&
+		n.RunningConf.LocalAS == n.Global.AS {
+		return n.Global.ConfederationId
+	}
+	return n.RunningConf.LocalAS
+}
+
+/*  Returns the AS of the global config that the peers outside the confederation see, the
+ *  confederation identifier in a confederation.
+ */
+func (n *NeighborConf) GetGlobalAS() uint32 {
+	if n.Global == nil {
+		return n.RunningConf.LocalAS
+	}
+	if n.Global.ConfederationId != 0 {
+		return n.Global.ConfederationId
+	}
+	return n.Global.AS
+}
+
+/*  Returns true when the neighbor uses a local AS different from the global AS (local-as).
+ *  The local AS is prepended to the AS path of the routes received from the neighbor unless
+ *  LocalASNoPrepend is set and the global AS is prepended to the AS path of the routes
+ *  advertised to the neighbor after the local AS unless LocalASReplaceAS is set.
+ */
+func (n *NeighborConf) IsLocalASConfigured() bool {
+	return n.IsExternal() && n.RunningConf.LocalAS != n.GetGlobalAS() && n.Global != nil &&
+		n.RunningConf.LocalAS != n.Global.AS
+}
+
+// IsLocalASPrependedIn returns true when the local AS is prepended to the AS path of the received routes
+func (n *NeighborConf) IsLocalASPrependedIn() bool {
+	return n.IsLocalASConfigured() && !n.RunningConf.LocalASNoPrepend
+}
+
+// IsLocalASPrependedOut returns true when the global AS is prepended to the AS path of the advertised routes
+func (n *NeighborConf) IsLocalASPrependedOut() bool {
+	return n.IsLocalASConfigured() && !n.RunningConf.LocalASReplaceAS
+}
+
+/*  Returns the TTL of the packets sent to the peer and the min TTL of the packets accepted
+ *  from the peer. With TTL security (RFC 5082) the packets are sent with TTL 255 and the
+ *  packets from more than TtlSecurityHops hops away are dropped by the kernel. Otherwise
+ *  the directly connected external peers use TTL 1 and the min TTL is not checked.
+ */
+func (n *NeighborConf) GetTTL() (ttl uint8, minTTL uint8) {
+	if n.RunningConf.TtlSecurityHops != 0 {
+		return config.BGPMaxTTL, config.BGPMaxTTL - n.RunningConf.TtlSecurityHops + 1
+	}
+
+	if n.RunningConf.MultiHopEnable && n.RunningConf.MultiHopTTL != 0 {
+		return n.RunningConf.MultiHopTTL, 0
+	}
+
+	if n.IsInternal() || n.RunningConf.MultiHopEnable {
+		return config.BGPMaxTTL, 0
+	}
+	return config.BGPDirectlyConnectedTTL, 0
+}
+
+func (n *NeighborConf) IsDynamic() bool {
+	return n.RunningConf.Dynamic
+}
+
+/*  Dynamic neighbors are accepted from the peer AS or the allowed AS list of the peer group.
+ *  The AS of the configured neighbors is not checked.
+ */
+func (n *NeighborConf) IsPeerASAllowed(peerAS uint32) bool {
+	if !n.IsDynamic() || n.RunningConf.PeerAS == peerAS {
+		return true
+	}
+
+	if n.Group != nil {
+		for _, as := range n.Group.AllowedAS {
+			if as == peerAS {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (n *NeighborConf) SetDynamicPeerAS(peerAS uint32) {
+	n.RunningConf.PeerAS = peerAS
+	n.Neighbor.State.PeerAS = peerAS
+	n.setPeerType()
+}
+
+func (n *NeighborConf) setPeerType() {
+	if n.IsInternal() {
+		n.Neighbor.State.PeerType = config.PeerTypeInternal
+	} else if n.IsConfedExternal() {
+		n.Neighbor.State.PeerType = config.PeerTypeConfedExternal
+	} else {
+		n.Neighbor.State.PeerType = config.PeerTypeExternal
+	}
 }
 
 func (n *NeighborConf) IsRouteReflectorClient() bool {
 	return n.RunningConf.RouteReflectorClient
 }
 
-func (n *NeighborConf) IncrPrefixCount() {
+// IsRouteServerClient returns true for the external peers that are clients of the route server (RFC 7947)
+func (n *NeighborConf) IsRouteServerClient() bool {
+	return n.IsExternal() && (n.RunningConf.RouteServerClient || (n.Global != nil && n.Global.RouteServer))
+}
+
+func (n *NeighborConf) IncrPrefixCount(protoFamily uint32) {
 	n.Neighbor.State.TotalPrefixes++
+	n.PrefixCounts[protoFamily]++
 }
 
-func (n *NeighborConf) DecrPrefixCount() {
+func (n *NeighborConf) DecrPrefixCount(protoFamily uint32) {
 	n.Neighbor.State.TotalPrefixes--
+	if n.PrefixCounts[protoFamily] > 0 {
+		n.PrefixCounts[protoFamily]--
+	}
+
+	// The prefix limit events are raised again after the count drops below the limit
+	limit := n.PrefixLimits[protoFamily]
+	if n.PrefixCounts[protoFamily] < limit.MaxPrefixes {
+		n.prefixLimitWarned[protoFamily] = false
+	}
+	if n.PrefixCounts[protoFamily] < getPrefixThreshold(limit.MaxPrefixes, limit.ShutdownThresholdPct) {
+		n.prefixThresholdWarned[protoFamily] = false
+	}
+	if n.Neighbor.State.TotalPrefixes < n.RunningConf.MaxPrefixes {
+		n.prefixLimitWarned[0] = false
+	}
+	if n.Neighbor.State.TotalPrefixes < n.MaxPrefixesThreshold {
+		n.prefixThresholdWarned[0] = false
+	}
 }
 
 func (n *NeighborConf) SetPrefixCount(count uint32) {
 	n.Neighbor.State.TotalPrefixes = 0
+	n.PrefixCounts = make(map[uint32]uint32)
+	n.prefixLimitWarned = make(map[uint32]bool)
+	n.prefixThresholdWarned = make(map[uint32]bool)
+	n.prefixLimitExceeded = false
+}
+
+// The threshold is computed in uint64 so that a large max prefixes limit can't overflow
+func getPrefixThreshold(maxPrefixes uint32, thresholdPct uint8) uint32 {
+	return uint32(uint64(maxPrefixes) * uint64(thresholdPct) / 100)
+}
+
+/*  Logs the prefix limit event and queues it for the server to publish. The protoFamily
+ *  is 0 for the total prefix limit of the neighbor.
+ */
+func (n *NeighborConf) addPrefixLimitEvent(protoFamily uint32, eventType config.PrefixLimitEventType,
+	count uint32, limit uint32, teardown bool) {
+	family := "all families"
+	if protoFamily != 0 {
+		afi, safi := packet.GetAfiSafi(protoFamily)
+		family = fmt.Sprintf("AFI %d SAFI %d", afi, safi)
+	}
+
+	if eventType == config.PrefixLimitThresholdReached {
+		n.logger.Warning(fmt.Sprintf("Neighbor %s %s Number of prefixes received %d reached the threshold "+
+			"limit %d", n.RunningConf.NeighborAddress, family, count, limit))
+	} else if teardown {
+		n.logger.Warning(fmt.Sprintf("Neighbor %s %s Number of prefixes received %d exceeds the max prefix "+
+			"limit %d", n.RunningConf.NeighborAddress, family, count, limit))
+	} else {
+		n.logger.Warning(fmt.Sprintf("Neighbor %s %s Number of prefixes received %d exceeds the max prefix "+
+			"limit %d, warning only", n.RunningConf.NeighborAddress, family, count, limit))
+	}
+
+	n.prefixLimitEvents = append(n.prefixLimitEvents, config.PrefixLimitEvent{
+		NeighborAddress: n.RunningConf.NeighborAddress,
+		ProtoFamily:     protoFamily,
+		EventType:       eventType,
+		PrefixCount:     count,
+		Limit:           limit,
+		Teardown:        teardown,
+		TimeStamp:       time.Now(),
+	})
+}
+
+// GetPrefixLimitEvents returns the prefix limit events raised since the last call
+func (n *NeighborConf) GetPrefixLimitEvents() []config.PrefixLimitEvent {
+	events := n.prefixLimitEvents
+	n.prefixLimitEvents = nil
+	return events
 }
 
-func (n *NeighborConf) CanAcceptNewPrefix() bool {
+/*  The threshold and the max prefix limit events are raised once when the count crosses
+ *  them and not for every prefix received after that.
+ */
+func (n *NeighborConf) canAcceptNewPrefixForFamily(protoFamily uint32) bool {
+	limit, ok := n.PrefixLimits[protoFamily]
+	if !ok || limit.MaxPrefixes == 0 {
+		return true
+	}
+
+	count := n.PrefixCounts[protoFamily]
+	if count >= limit.MaxPrefixes {
+		if !n.prefixLimitWarned[protoFamily] {
+			n.prefixLimitWarned[protoFamily] = true
+			n.addPrefixLimitEvent(protoFamily, config.PrefixLimitExceeded, count, limit.MaxPrefixes,
+				!limit.WarningOnly)
+		}
+		if limit.WarningOnly {
+			return true
+		}
+
+		n.prefixLimitExceeded = true
+		n.exceededFamily = protoFamily
+		n.exceededTotalLimit = false
+		return false
+	}
+
+	threshold := getPrefixThreshold(limit.MaxPrefixes, limit.ShutdownThresholdPct)
+	if limit.ShutdownThresholdPct != 0 && count >= threshold && !n.prefixThresholdWarned[protoFamily] {
+		n.prefixThresholdWarned[protoFamily] = true
+		n.addPrefixLimitEvent(protoFamily, config.PrefixLimitThresholdReached, count, threshold, false)
+	}
+	return true
+}
+
+func (n *NeighborConf) CanAcceptNewPrefix(protoFamily uint32) bool {
+	if !n.canAcceptNewPrefixForFamily(protoFamily) {
+		return false
+	}
+
 	if n.RunningConf.MaxPrefixes > 0 {
-		if n.Neighbor.State.TotalPrefixes >= n.RunningConf.MaxPrefixes {
-			n.logger.Warning(fmt.Sprintf("Neighbor %s Number of prefixes received %d exceeds the max prefix limit %d",
-				n.RunningConf.NeighborAddress, n.Neighbor.State.TotalPrefixes, n.MaxPrefixesThreshold))
+		totalPrefixes := n.Neighbor.State.TotalPrefixes
+		if totalPrefixes >= n.RunningConf.MaxPrefixes {
+			if !n.prefixLimitWarned[0] {
+				n.prefixLimitWarned[0] = true
+				n.addPrefixLimitEvent(0, config.PrefixLimitExceeded, totalPrefixes, n.RunningConf.MaxPrefixes,
+					n.RunningConf.MaxPrefixesDisconnect)
+			}
+			n.prefixLimitExceeded = true
+			n.exceededFamily = protoFamily
+			n.exceededTotalLimit = true
 			return false
 		}
 
-		if n.Neighbor.State.TotalPrefixes >= n.MaxPrefixesThreshold {
-			n.logger.Warning(fmt.Sprintf("Neighbor %s Number of prefixes received %d reached the threshold limit %d",
-				n.RunningConf.NeighborAddress, n.Neighbor.State.TotalPrefixes, n.MaxPrefixesThreshold))
+		if n.RunningConf.MaxPrefixesThresholdPct != 0 && totalPrefixes >= n.MaxPrefixesThreshold &&
+			!n.prefixThresholdWarned[0] {
+			n.prefixThresholdWarned[0] = true
+			n.addPrefixLimitEvent(0, config.PrefixLimitThresholdReached, totalPrefixes, n.MaxPrefixesThreshold,
+				false)
 		}
 	}
 
 	return true
 }
 
+/*  Returns the prefix limit exceeded by the last prefix rejected from the neighbor. The
+ *  limit is used in the Cease NOTIFICATION and the restart time is the time after which
+ *  the session is started again. The family is the family of the rejected prefix when the
+ *  total limit of the neighbor is exceeded.
+ */
+func (n *NeighborConf) GetExceededPrefixLimit() (protoFamily uint32, maxPrefixes uint32, restartTime uint32,
+	disconnect bool) {
+	if !n.prefixLimitExceeded {
+		return 0, 0, 0, false
+	}
+
+	n.prefixLimitExceeded = false
+	if !n.exceededTotalLimit {
+		limit := n.PrefixLimits[n.exceededFamily]
+		return n.exceededFamily, limit.MaxPrefixes, uint32(limit.RestartTimer), true
+	}
+
+	return n.exceededFamily, n.RunningConf.MaxPrefixes, uint32(n.RunningConf.MaxPrefixesRestartTimer),
+		n.RunningConf.MaxPrefixesDisconnect
+}
+
+func (n *NeighborConf) GetMaxPrefixesOut(protoFamily uint32) (uint32, bool) {
+	limit := n.PrefixLimits[protoFamily]
+	return limit.MaxPrefixesOut, limit.WarningOnly
+}
+
 func (n *NeighborConf) FSMStateChange(state uint32) {
 	n.logger.Info(fmt.Sprintf("Neighbor %s: FSMStateChange %d", n.Neighbor.NeighborAddress, state))
 	n.Neighbor.State.SessionState = uint32(state)
 }
 
 func (n *NeighborConf) SetPeerAttrs(bgpId net.IP, asSize uint8, holdTime uint32, keepaliveTime uint32,
-	addPathFamily map[packet.AFI]map[packet.SAFI]uint8) {
+	addPathFamily map[packet.AFI]map[packet.SAFI]uint8, grCap *packet.BGPCapGracefulRestart, routeRefresh bool,
+	enhancedRR bool, extendedNextHop bool, prefixORFFamily map[uint32]uint8, afiSafiMap map[uint32]bool) {
 	n.BGPId = bgpId
+	n.PeerAfiSafiMap = make(map[uint32]bool)
+	for protoFamily, _ := range afiSafiMap {
+		n.PeerAfiSafiMap[protoFamily] = true
+	}
 	n.ASSize = asSize
 	n.Neighbor.State.HoldTime = holdTime
 	n.Neighbor.State.KeepaliveTime = keepaliveTime
+	n.RouteRefreshCap = routeRefresh
+	n.EnhancedRRCap = routeRefresh && enhancedRR
+	n.Neighbor.State.RouteRefresh = n.RouteRefreshCap
+	n.Neighbor.State.EnhancedRouteRefresh = n.EnhancedRRCap
+	n.ExtendedNextHopCap = extendedNextHop
+	n.Neighbor.State.ExtendedNextHop = extendedNextHop
+	n.GracefulRestartCap = grCap
+	n.Neighbor.State.GracefulRestart = n.IsGracefulRestartNegotiated()
+	if grCap != nil {
+		n.Neighbor.State.PeerRestartTime = grCap.RestartTime
+	} else {
+		n.Neighbor.State.PeerRestartTime = 0
+	}
+	n.AddPathsTxFamilies = make(map[uint32]bool)
 	for afi, safiMap := range addPathFamily {
-		if afi == packet.AfiIP {
-			for _, val := range safiMap {
-				if (val & packet.BGPCapAddPathRx) != 0 {
-					n.logger.Info(fmt.Sprintf("SetPeerAttrs - Neighbor %s set add paths maxtx to %d\n",
-						n.Neighbor.NeighborAddress, n.RunningConf.AddPathsMaxTx))
-					n.Neighbor.State.AddPathsMaxTx = n.RunningConf.AddPathsMaxTx
-				}
-				if (val & packet.BGPCapAddPathTx) != 0 {
-					n.logger.Info(fmt.Sprintf("SetPeerAttrs - Neighbor %s set add paths rx to %s\n",
-						n.Neighbor.NeighborAddress, n.RunningConf.AddPathsRx))
-					n.Neighbor.State.AddPathsRx = true
-				}
+		for safi, val := range safiMap {
+			if !packet.IsAddPathsFamily(afi, safi) {
+				continue
+			}
+			if (val&packet.BGPCapAddPathRx) != 0 && n.RunningConf.AddPathsMaxTx > 0 {
+				n.logger.Info(fmt.Sprintf("SetPeerAttrs - Neighbor %s AFI %d SAFI %d set add paths maxtx to %d\n",
+					n.Neighbor.NeighborAddress, afi, safi, n.RunningConf.AddPathsMaxTx))
+				n.AddPathsTxFamilies[packet.GetProtocolFamily(afi, safi)] = true
+				n.Neighbor.State.AddPathsMaxTx = n.RunningConf.AddPathsMaxTx
+			}
+			if (val&packet.BGPCapAddPathTx) != 0 && n.RunningConf.AddPathsRx {
+				n.logger.Info(fmt.Sprintf("SetPeerAttrs - Neighbor %s AFI %d SAFI %d set add paths rx to %t\n",
+					n.Neighbor.NeighborAddress, afi, safi, n.RunningConf.AddPathsRx))
+				n.Neighbor.State.AddPathsRx = true
 			}
 		}
 	}
+
+	// The prefix ORF is sent when the neighbor can receive it and applied when the neighbor can send it
+	n.PrefixORFTxFamilies = make(map[uint32]bool)
+	n.PrefixORFRxFamilies = make(map[uint32]bool)
+	orfFlags := n.GetPrefixORFCapFlags()
+	for protoFamily, flags := range prefixORFFamily {
+		afi, safi := packet.GetAfiSafi(protoFamily)
+		if !packet.IsPrefixORFFamily(afi, safi) || !n.PeerAfiSafiMap[protoFamily] {
+			continue
+		}
+		if orfFlags&packet.BGPORFCapSend != 0 && flags&packet.BGPORFCapReceive != 0 {
+			n.PrefixORFTxFamilies[protoFamily] = true
+		}
+		if orfFlags&packet.BGPORFCapReceive != 0 && flags&packet.BGPORFCapSend != 0 {
+			n.PrefixORFRxFamilies[protoFamily] = true
+		}
+	}
+	n.Neighbor.State.PrefixORFSend = len(n.PrefixORFTxFamilies) > 0
+	n.Neighbor.State.PrefixORFReceive = len(n.PrefixORFRxFamilies) > 0
+}
+
+// GetPrefixORFCapFlags returns the Send/Receive flags of the address prefix ORF capability
+func (n *NeighborConf) GetPrefixORFCapFlags() uint8 {
+	switch n.RunningConf.PrefixListORF {
+	case config.PrefixListORFSend:
+		return packet.BGPORFCapSend
+	case config.PrefixListORFReceive:
+		return packet.BGPORFCapReceive
+	case config.PrefixListORFBoth:
+		return packet.BGPORFCapSend | packet.BGPORFCapReceive
+	}
+	return 0
+}
+
+// IsPrefixORFSendNegotiated returns true when the prefix ORF of the family can be sent to the neighbor
+func (n *NeighborConf) IsPrefixORFSendNegotiated(protoFamily uint32) bool {
+	return n.PrefixORFTxFamilies[protoFamily]
+}
+
+// IsPrefixORFReceiveNegotiated returns true when the prefix ORF of the family received from the neighbor is applied
+func (n *NeighborConf) IsPrefixORFReceiveNegotiated(protoFamily uint32) bool {
+	return n.PrefixORFRxFamilies[protoFamily]
+}
+
+// IsAddPathsTxNegotiated returns true when the paths of the family are sent with path ids
+func (n *NeighborConf) IsAddPathsTxNegotiated(protoFamily uint32) bool {
+	return n.AddPathsTxFamilies[protoFamily]
+}
+
+// GetAddPathsTxMode returns the add paths transmit mode of the family
+func (n *NeighborConf) GetAddPathsTxMode(protoFamily uint32) string {
+	if txMode, ok := n.AddPathsTxModes[protoFamily]; ok {
+		return txMode
+	}
+	return config.AddPathsTxModeBestN
+}
+
+func (n *NeighborConf) IsProtocolFamilyNegotiated(afi packet.AFI, safi packet.SAFI) bool {
+	return n.PeerAfiSafiMap[packet.GetProtocolFamily(afi, safi)]
+}
+
+func (n *NeighborConf) IsExtendedNextHopEnabled() bool {
+	return n.RunningConf.ExtendedNextHop || n.IsLinkLocal()
+}
+
+func (n *NeighborConf) IsLinkLocal() bool {
+	return n.RunningConf.IfIndex != 0 && n.RunningConf.NeighborAddress.IsLinkLocalUnicast()
+}
+
+func (n *NeighborConf) IsGracefulRestartNegotiated() bool {
+	return n.Global.GracefulRestart && n.GracefulRestartCap != nil
 }
 
 func (n *NeighborConf) BfdFaultSet() {
@@ -334,5 +809,17 @@ func (n *NeighborConf) PeerConnBroken() {
 	n.Neighbor.State.KeepaliveTime = n.RunningConf.KeepaliveTime
 	n.Neighbor.State.AddPathsRx = false
 	n.Neighbor.State.AddPathsMaxTx = 0
+	n.AddPathsTxFamilies = make(map[uint32]bool)
 	n.Neighbor.State.TotalPrefixes = 0
+	n.RouteRefreshCap = false
+	n.EnhancedRRCap = false
+	n.Neighbor.State.RouteRefresh = false
+	n.Neighbor.State.EnhancedRouteRefresh = false
+	n.ExtendedNextHopCap = false
+	n.Neighbor.State.ExtendedNextHop = false
+	n.PrefixORFTxFamilies = make(map[uint32]bool)
+	n.PrefixORFRxFamilies = make(map[uint32]bool)
+	n.Neighbor.State.PrefixORFSend = false
+	n.Neighbor.State.PrefixORFReceive = false
+	n.PeerAfiSafiMap = make(map[uint32]bool)
 }
@@ -26,6 +26,7 @@ package config
 
 import (
 	"net"
+	"time"
 )
 
 type SourcePolicyMap struct {
@@ -33,24 +34,58 @@ type SourcePolicyMap struct {
 	Policy  string
 }
 type GlobalConfig struct {
-	AS                  uint32
-	RouterId            net.IP
-	UseMultiplePaths    bool
-	EBGPMaxPaths        uint32
-	EBGPAllowMultipleAS bool
-	IBGPMaxPaths        uint32
-	Redistribution      []SourcePolicyMap
+	AS                           uint32
+	RouterId                     net.IP
+	UseMultiplePaths             bool
+	EBGPMaxPaths                 uint32
+	EBGPAllowMultipleAS          bool
+	IBGPMaxPaths                 uint32
+	Redistribution               []SourcePolicyMap
+	GracefulRestart              bool
+	GracefulRestartTime          uint16
+	GracefulRestartStalePathTime uint32
+	GracefulRestartDeferralTime  uint32
+	RpkiPreferValid              bool
+	Dampening                    bool
+	DampeningHalfLife            uint32
+	DampeningReuse               uint32
+	DampeningSuppress            uint32
+	DampeningMaxSuppressTime     uint32
+	ConfederationId              uint32
+	ConfederationMembers         []uint32
+	AlwaysCompareMED             bool
+	DeterministicMED             bool
+	MEDMissingAsWorst            bool
+	GracefulShutdownTime         uint32
+	RouteServer                  bool
 }
 
 type GlobalState struct {
-	AS                  uint32
-	RouterId            net.IP
-	UseMultiplePaths    bool
-	EBGPMaxPaths        uint32
-	EBGPAllowMultipleAS bool
-	IBGPMaxPaths        uint32
-	TotalPaths          uint32
-	TotalPrefixes       uint32
+	AS                           uint32
+	RouterId                     net.IP
+	UseMultiplePaths             bool
+	EBGPMaxPaths                 uint32
+	EBGPAllowMultipleAS          bool
+	IBGPMaxPaths                 uint32
+	TotalPaths                   uint32
+	TotalPrefixes                uint32
+	GracefulRestart              bool
+	GracefulRestartTime          uint16
+	GracefulRestartStalePathTime uint32
+	GracefulRestartDeferralTime  uint32
+	RpkiPreferValid              bool
+	Dampening                    bool
+	DampeningHalfLife            uint32
+	DampeningReuse               uint32
+	DampeningSuppress            uint32
+	DampeningMaxSuppressTime     uint32
+	ConfederationId              uint32
+	ConfederationMembers         []uint32
+	AlwaysCompareMED             bool
+	DeterministicMED             bool
+	MEDMissingAsWorst            bool
+	GracefulShutdownTime         uint32
+	RouteServer                  bool
 }
 
 type Global struct {
@@ -63,11 +98,13 @@ type PeerType int
 const (
 	PeerTypeInternal PeerType = iota
 	PeerTypeExternal
+	PeerTypeConfedExternal
 )
 
 type BgpCounters struct {
 	Update       uint64
 	Notification uint64
+	RouteRefresh uint64
 }
 
 type Messages struct {
@@ -90,6 +127,7 @@ type BaseConfig struct {
 	RouteReflectorClient    bool
 	MultiHopEnable          bool
 	MultiHopTTL             uint8
+	TtlSecurityHops         uint8
 	ConnectRetryTime        uint32
 	HoldTime                uint32
 	KeepaliveTime           uint32
@@ -97,17 +135,57 @@ type BaseConfig struct {
 	BfdSessionParam         string
 	AddPathsRx              bool
 	AddPathsMaxTx           uint8
+	AddPathsTxMode          string
 	MaxPrefixes             uint32
 	MaxPrefixesThresholdPct uint8
 	MaxPrefixesDisconnect   bool
 	MaxPrefixesRestartTimer uint8
+	ImportPolicy            string
+	ExportPolicy            string
+	SoftReconfigInbound     bool
+	ExtendedNextHop         bool
+	PrefixListORF           string
+	AllowAsIn               uint8
+	LocalASNoPrepend        bool
+	LocalASReplaceAS        bool
+	RemovePrivateAS         string
+	ASOverride              bool
+	AcceptOwn               bool
+	DefaultOriginatePolicy  string
+	AdvertisePolicy         string
+	AdvertiseExistPolicy    string
+	AdvertiseNonExistPolicy string
+	RouteServerClient       bool
+	AfiSafis                []AfiSafiConfig
 }
 
+// IfIndex is the index of the local interface for neighbors configured on an
+// interface. When the NeighborAddress is not set the neighbor is unnumbered
+// and its IPv6 link-local address is discovered on the interface.
 type NeighborConfig struct {
 	BaseConfig
 	NeighborAddress net.IP
 	IfIndex         int32
 	PeerGroup       string
+	Dynamic         bool
+}
+
+// IsConfedMember returns true when the AS is another member AS of the local confederation
+func (g *GlobalConfig) IsConfedMember(as uint32) bool {
+	if g.ConfederationId == 0 {
+		return false
+	}
+
+	for _, member := range g.ConfederationMembers {
+		if member == as {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *NeighborConfig) IsUnnumbered() bool {
+	return n.IfIndex != 0 && (n.NeighborAddress == nil || n.NeighborAddress.IsUnspecified())
 }
 
 type NeighborState struct {
@@ -126,6 +204,7 @@ type NeighborState struct {
 	RouteReflectorClient    bool
 	MultiHopEnable          bool
 	MultiHopTTL             uint8
+	TtlSecurityHops         uint8
 	ConnectRetryTime        uint32
 	HoldTime                uint32
 	KeepaliveTime           uint32
@@ -139,6 +218,43 @@ type NeighborState struct {
 	MaxPrefixesDisconnect   bool
 	MaxPrefixesRestartTimer uint8
 	TotalPrefixes           uint32
+	ImportPolicy            string
+	ExportPolicy            string
+	GracefulRestart         bool
+	PeerRestartTime         uint16
+	StalePaths              bool
+	SoftReconfigInbound     bool
+	RouteRefresh            bool
+	EnhancedRouteRefresh    bool
+	ExtendedNextHop         bool
+	PrefixListORF           string
+	PrefixORFSend           bool
+	PrefixORFReceive        bool
+	AllowAsIn               uint8
+	LocalASNoPrepend        bool
+	LocalASReplaceAS        bool
+	RemovePrivateAS         string
+	ASOverride              bool
+	AcceptOwn               bool
+	DefaultOriginatePolicy  string
+	AdvertisePolicy         string
+	AdvertiseExistPolicy    string
+	AdvertiseNonExistPolicy string
+	RouteServerClient       bool
+	Dynamic                 bool
+	Draining                bool
+}
+
+// RouteAttrsState has the attrs of a route in the Loc-RIB that are not in the BGP route state model,
+// the communities of the path and its origin validation state.
+type RouteAttrsState struct {
+	Network             string
+	CIDRLen             int16
+	PathId              int32
+	Communities         []string
+	ExtendedCommunities []string
+	LargeCommunities    []string
+	ValidationState     string
 }
 
 type TransportConfig struct {
@@ -163,12 +279,39 @@ type Transport struct {
 	State  TransportState
 }
 
+// PrefixLimit of an address family. When WarningOnly is set the prefixes over the
+// limit are accepted and only a warning is logged. MaxPrefixesOut limits the number
+// of prefixes advertised to the neighbor.
 type PrefixLimit struct {
 	MaxPrefixes          uint32
 	ShutdownThresholdPct uint8
 	RestartTimer         float64
+	WarningOnly          bool
+	MaxPrefixesOut       uint32
+}
+
+type PrefixLimitEventType uint8
+
+const (
+	PrefixLimitThresholdReached PrefixLimitEventType = iota + 1
+	PrefixLimitExceeded
+)
+
+// PrefixLimitEvent is raised once when the number of prefixes received from a neighbor
+// crosses the threshold or the max prefix limit. ProtoFamily is 0 for the total limit
+// of the neighbor. Teardown is false when the session is kept up (warning only).
+type PrefixLimitEvent struct {
+	NeighborAddress net.IP
+	ProtoFamily     uint32
+	EventType       PrefixLimitEventType
+	PrefixCount     uint32
+	Limit           uint32
+	Teardown        bool
+	TimeStamp       time.Time
 }
 
+// SendDefaultRoute advertises the default route of the family to the neighbor (default-originate),
+// while a route in the Loc-RIB is accepted by the DefaultOriginatePolicy of the neighbor when set.
 type IPUnicast struct {
 	PrefixLimit      PrefixLimit
 	SendDefaultRoute bool
@@ -189,9 +332,45 @@ type UseMultiplePaths struct {
 	IBGPMaximumPaths    uint32
 }
 
+// Add paths transmit modes, the paths advertised to a neighbor that negotiated to receive
+// add paths:
+// all - All the paths with a unique next hop
+// best-n - The best path and the next best paths up to AddPathsMaxTx paths
+// ecmp - The best path and the paths installed as ECMP paths
+// best-external - The best path and the best path received from an external neighbor
+// diverse-path - The best path and the next best path with a different next hop, for
+// route reflectors to advertise a backup path to their clients
+const (
+	AddPathsTxModeAll          = "all"
+	AddPathsTxModeBestN        = "best-n"
+	AddPathsTxModeECMP         = "ecmp"
+	AddPathsTxModeBestExternal = "best-external"
+	AddPathsTxModeDiversePath  = "diverse-path"
+)
+
+// Address prefix ORF modes of a neighbor:
+// send - The prefix set conditions of the import policy are sent to the neighbor as ORF
+// receive - The prefix ORF received from the neighbor filters the routes advertised to it
+// both - Send and receive the prefix ORF
+const (
+	PrefixListORFSend    = "send"
+	PrefixListORFReceive = "receive"
+	PrefixListORFBoth    = "both"
+)
+
+// RemovePrivateAS modes of a neighbor:
+// all - Remove all the private ASes from the AS path of the routes advertised to the neighbor
+// replace - Replace the private ASes in the AS path with the local AS
+const (
+	RemovePrivateASAll     = "all"
+	RemovePrivateASReplace = "replace"
+)
+
+// AddPathsTxMode of an address family overrides the AddPathsTxMode of the neighbor
 type AfiSafiConfig struct {
 	AfiSafiName         string
 	AfiSafiEnabled      bool
+	AddPathsTxMode      string
 	IPv4Unicast         IPUnicast
 	IPv6Unicast         IPUnicast
 	IPv4LabelledUnicast IPLabelledUnicast
@@ -205,9 +384,22 @@ type AfiSafiConfig struct {
 	UseMultiplePaths    UseMultiplePaths
 }
 
+// Peer commands that are not FSM events. The FSM events are passed in
+// the Command field as is. The drain commands apply to all the neighbors
+// when IP is nil, Message is the shutdown communication sent when the
+// drained session is brought down.
+const (
+	PeerCommandSoftResetIn int = iota + 101
+	PeerCommandSoftResetOut
+	PeerCommandSoftReset
+	PeerCommandDrain
+	PeerCommandUndrain
+)
+
 type PeerCommand struct {
 	IP      net.IP
 	Command int
+	Message string
 }
 
 type Neighbor struct {
@@ -218,9 +410,15 @@ type Neighbor struct {
 	AfiSafis        []AfiSafiConfig
 }
 
+// Connections from the addresses in the ListenRanges of a peer group create dynamic
+// neighbors in the group. The AS of a dynamic neighbor must be the PeerAS of the group
+// or one of the AllowedAS.
 type PeerGroupConfig struct {
 	BaseConfig
-	Name string
+	Name                string
+	ListenRanges        []string
+	AllowedAS           []uint32
+	MaxDynamicNeighbors uint32
 }
 
 type PeerGroup struct {
@@ -229,6 +427,71 @@ type PeerGroup struct {
 	AfiSafis []AfiSafiConfig
 }
 
+const (
+	BmpRouteMonitoringPrePolicy  = "pre-policy"
+	BmpRouteMonitoringPostPolicy = "post-policy"
+	BmpRouteMonitoringAll        = "all"
+)
+
+// BMP collector the BGP Monitoring Protocol messages are streamed to. StatsInterval
+// is the interval of the Statistics Report messages in seconds, 0 disables them.
+type BmpCollectorConfig struct {
+	Address         string
+	Port            uint16
+	StatsInterval   uint32
+	RouteMonitoring string
+}
+
+// Members of an update group, the updates of the group are built with the config of
+// the first member, the leader of the group.
+type UpdateGroupState struct {
+	Id      uint32
+	Members []string
+}
+
+// RPKI cache the VRPs are received from with the RPKI to Router protocol. The intervals
+// are in seconds, 0 uses the intervals sent by the cache.
+type RpkiCacheConfig struct {
+	Address         string
+	Port            uint16
+	RefreshInterval uint32
+	RetryInterval   uint32
+	ExpireInterval  uint32
+}
+
+// Route flap dampening state of a path received from a neighbor. ReuseTime is the time
+// left until a suppressed path is used again.
+type DampenedRouteState struct {
+	Network    string
+	CIDRLen    uint8
+	Neighbor   string
+	PathId     uint32
+	Penalty    uint32
+	Flaps      uint32
+	Suppressed bool
+	History    bool
+	ReuseTime  string
+}
+
+// VRF the L3VPN routes are imported into. The routes received with any of the import
+// route targets are installed in the VRF, the routes of the VRF are advertised with the
+// route distinguisher, the export route targets and the label of the VRF.
+type VrfConfig struct {
+	Name               string
+	RouteDistinguisher string
+	ImportRouteTargets []string
+	ExportRouteTargets []string
+	Label              uint32
+	L3VNI              uint32
+}
+
+type EvpnConfig struct {
+	VNI                uint32
+	RouteDistinguisher string
+	ImportRouteTargets []string
+	ExportRouteTargets []string
+}
+
 type BGPAggregate struct {
 	IPPrefix
 	GenerateASSet   bool
@@ -256,4 +519,6 @@ type RouteConfig struct {
 	NetworkMask       string
 	DestinationNw     string
 	OutgoingInterface string
+	Vrf               string
+	Labels            []uint32
 }
@@ -24,6 +24,10 @@
 // conn.go
 package config
 
+import (
+	"net"
+)
+
 type ReachabilityInfo struct {
 	IP          string
 	ReachableCh chan bool
@@ -80,6 +84,7 @@ type RouteInfo struct {
 	Prototype        int
 	NetworkStatement bool
 	RouteOrigin      string
+	Vrf              string
 }
 
 type RouteCh struct {
@@ -87,6 +92,24 @@ type RouteCh struct {
 	Remove []*RouteInfo
 }
 
+type EvpnMacInfo struct {
+	VNI    uint32
+	MAC    net.HardwareAddr
+	IP     net.IP
+	VtepIP net.IP
+}
+
+type EvpnMacCh struct {
+	Add    []*EvpnMacInfo
+	Remove []*EvpnMacInfo
+}
+
+type VtepInfo struct {
+	VNI    uint32
+	SrcIP  net.IP
+	VtepIP net.IP
+}
+
 type NextHopInfo struct {
 	IPAddr         string
 	Mask           string
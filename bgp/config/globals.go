@@ -25,3 +25,26 @@
 package config
 
 const BGPPort string = "179"
+
+const (
+	BGPDirectlyConnectedTTL uint8 = 1
+	BGPMaxTTL               uint8 = 255
+)
+
+const (
+	BGPGracefulRestartTimeDefault          uint16 = 120 // seconds
+	BGPGracefulRestartStalePathTimeDefault uint32 = 360 // seconds
+	BGPGracefulRestartDeferralTimeDefault  uint32 = 360 // seconds
+)
+
+const (
+	BGPDampeningHalfLifeDefault        uint32 = 900 // seconds
+	BGPDampeningReuseDefault           uint32 = 750
+	BGPDampeningSuppressDefault        uint32 = 2000
+	BGPDampeningMaxSuppressTimeDefault uint32 = 3600 // seconds
+)
+
+const (
+	BGPGracefulShutdownTimeDefault uint32 = 60 // seconds
+	BGPGracefulShutdownLocalPref   uint32 = 0
+)
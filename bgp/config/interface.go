@@ -31,6 +31,7 @@ type IntfStateMgrIntf interface {
 	GetIPv4Intfs() []*IntfStateInfo
 	GetIPv4Information(ifIndex int32) (string, error)
 	GetIfIndex(int, int) int32
+	GetIntfName(ifIndex int32) (string, error)
 }
 
 /*  Adding routes to rib/switch/linux interface
@@ -43,6 +44,7 @@ type RouteMgrIntf interface {
 	UpdateRoute(cfg *RouteConfig , op string)
 	ApplyPolicy(protocol string, policy string, action string, conditions []*ConditionInfo)
 	GetRoutes() ([]*RouteInfo, []*RouteInfo)
+	GetInstalledRoutes() []*RouteConfig
 }
 
 /*  Interface for handling policy related operations
@@ -58,3 +60,13 @@ type BfdMgrIntf interface {
 	CreateBfdSession(ipAddr string, sessionParam string) (bool, error)
 	DeleteBfdSession(ipAddr string) (bool, error)
 }
+
+/*  Interface for programming VXLAN tunnel endpoints and remote MACs learnt via EVPN
+ */
+type VtepMgrIntf interface {
+	Start()
+	CreateVtep(*VtepInfo) (bool, error)
+	DeleteVtep(*VtepInfo) (bool, error)
+	AddRemoteMac(*EvpnMacInfo) (bool, error)
+	RemoveRemoteMac(*EvpnMacInfo) (bool, error)
+}
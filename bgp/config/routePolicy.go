@@ -0,0 +1,145 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// routePolicy.go
+package config
+
+type RoutePolicyConditionType int
+
+const (
+	RoutePolicyConditionTypeCommunity RoutePolicyConditionType = iota + 1
+	RoutePolicyConditionTypeRpkiValidation
+	RoutePolicyConditionTypePrefixSet
+	RoutePolicyConditionTypeASPathSet
+	RoutePolicyConditionTypeASPathLength
+	RoutePolicyConditionTypeNeighborSet
+	RoutePolicyConditionTypeNextHopSet
+)
+
+type MatchSetOption int
+
+const (
+	MatchSetOptionAny MatchSetOption = iota
+	MatchSetOptionAll
+	MatchSetOptionInvert
+)
+
+type RoutePolicyComparison int
+
+const (
+	RoutePolicyCompareEq RoutePolicyComparison = iota
+	RoutePolicyCompareGe
+	RoutePolicyCompareLe
+)
+
+type RoutePolicyActionType int
+
+const (
+	RoutePolicyActionTypeSetCommunity RoutePolicyActionType = iota + 1
+	RoutePolicyActionTypeAddCommunity
+	RoutePolicyActionTypeRemoveCommunity
+	RoutePolicyActionTypeSetLocalPref
+	RoutePolicyActionTypePrependASPath
+	RoutePolicyActionTypeSetMED
+	RoutePolicyActionTypeSetNextHop
+)
+
+type MEDActionOption int
+
+const (
+	MEDActionSet MEDActionOption = iota
+	MEDActionAdd
+	MEDActionSubtract
+	MEDActionIGPCost
+)
+
+// The next hop is set to the local address of the session (self) or to
+// the address of the neighbor (peer-address).
+type NextHopActionOption int
+
+const (
+	NextHopActionSelf NextHopActionOption = iota
+	NextHopActionPeerAddress
+)
+
+type RoutePolicyResult int
+
+const (
+	RoutePolicyResultNext RoutePolicyResult = iota
+	RoutePolicyResultAccept
+	RoutePolicyResultReject
+)
+
+// RoutePolicyPrefix matches the routes covered by the prefix with a length in the mask
+// length range. The range "24..32" matches the lengths 24 to 32, an empty range or "exact"
+// matches only the prefix itself.
+type RoutePolicyPrefix struct {
+	IpPrefix        string
+	MasklengthRange string
+}
+
+// The AS path regexes use the Cisco syntax, "_" matches the start or the end of the AS path
+// or the delimiter between two ASes. The neighbors and next hops are addresses or prefixes.
+// The AS path length is compared to the number of ASes in the path, an AS_SET counts as one.
+type RoutePolicyConditionConfig struct {
+	Name             string
+	ConditionType    RoutePolicyConditionType
+	MatchSetOption   MatchSetOption
+	Communities      []string
+	ValidationStates []string
+	Prefixes         []RoutePolicyPrefix
+	ASPathRegexes    []string
+	ASPathLength     uint32
+	Comparison       RoutePolicyComparison
+	Neighbors        []string
+	NextHops         []string
+}
+
+// The AS is prepended PrependCount times, once when the count is not set. The
+// neighbor AS, the first AS of the AS path, is prepended with PrependLastAS.
+type RoutePolicyActionConfig struct {
+	Name          string
+	ActionType    RoutePolicyActionType
+	Communities   []string
+	LocalPref     uint32
+	PrependAS     uint32
+	PrependCount  uint8
+	PrependLastAS bool
+	MED           uint32
+	MEDOption     MEDActionOption
+	NextHopOption NextHopActionOption
+}
+
+type RoutePolicyStmtConfig struct {
+	Name            string
+	MatchConditions string
+	Conditions      []string
+	Actions         []string
+	Result          RoutePolicyResult
+}
+
+type RoutePolicyConfig struct {
+	Name          string
+	Statements    []string
+	DefaultResult RoutePolicyResult
+}
@@ -29,6 +29,7 @@ import (
 	nanomsg "github.com/op/go-nanomsg"
 	"ribd"
 	"utils/logging"
+	"vxland"
 )
 
 /*  Router manager will handle all the communication with ribd
@@ -68,6 +69,17 @@ type FSBfdMgr struct {
 	bfdSubSocket *nanomsg.SubSocket
 }
 
+/*  VTEP manager will handle all the communication with vxlan daemon
+ */
+type FSVtepMgr struct {
+	plugin       string
+	logger       *logging.Writer
+	vxlandClient *vxland.VXLANDServicesClient
+	vtepIds      map[string]int32
+	nextVtepId   int32
+	localMacs    map[string]bool
+}
+
 func (mgr *FSIntfMgr) PortStateChange() {
 
 }
\ No newline at end of file
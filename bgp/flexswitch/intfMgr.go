@@ -33,6 +33,7 @@ import (
 	"l3/bgp/config"
 	"l3/bgp/rpc"
 	"strconv"
+	"utils/commonDefs"
 	"utils/logging"
 
 	nanomsg "github.com/op/go-nanomsg"
@@ -200,3 +201,41 @@ func (mgr *FSIntfMgr) GetIPv4Information(ifIndex int32) (string, error) {
 func (mgr *FSIntfMgr) GetIfIndex(ifIndex, ifType int) int32 {
 	return asicdCommonDefs.GetIfIndexFromIntfIdAndIntfType(ifIndex, ifType)
 }
+
+func (mgr *FSIntfMgr) GetIntfName(ifIndex int32) (string, error) {
+	var currMarker asicdServices.Int
+	var count asicdServices.Int = 100
+	ifType := asicdCommonDefs.GetIntfTypeFromIfIndex(ifIndex)
+	for {
+		if ifType == commonDefs.IfTypeVlan {
+			getBulkInfo, err := mgr.AsicdClient.GetBulkVlanState(currMarker, count)
+			if err != nil {
+				return "", err
+			}
+			for _, vlanState := range getBulkInfo.VlanStateList {
+				if vlanState.IfIndex == ifIndex {
+					return vlanState.VlanName, nil
+				}
+			}
+			if getBulkInfo.Count == 0 || getBulkInfo.More == false {
+				break
+			}
+			currMarker = getBulkInfo.EndIdx
+		} else {
+			getBulkInfo, err := mgr.AsicdClient.GetBulkPortState(currMarker, count)
+			if err != nil {
+				return "", err
+			}
+			for _, portState := range getBulkInfo.PortStateList {
+				if portState.IfIndex == ifIndex {
+					return portState.Name, nil
+				}
+			}
+			if getBulkInfo.Count == 0 || getBulkInfo.More == false {
+				break
+			}
+			currMarker = getBulkInfo.EndIdx
+		}
+	}
+	return "", errors.New(fmt.Sprintf("Interface with ifIndex %d not found", ifIndex))
+}
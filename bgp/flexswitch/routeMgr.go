@@ -32,6 +32,7 @@ import (
 	"l3/bgp/config"
 	"l3/bgp/rpc"
 	"l3/rib/ribdCommonDefs"
+	"net"
 	"ribd"
 	"ribdInt"
 	"utils/logging"
@@ -204,31 +205,77 @@ func (mgr *FSRouteMgr) createRibdIPv4RouteCfg(cfg *config.RouteConfig,
 	return &rCfg
 }
 
+func (mgr *FSRouteMgr) createRibdIPv6RouteCfg(cfg *config.RouteConfig,
+	create bool) *ribd.IPv6Route {
+	rCfg := ribd.IPv6Route{
+		Cost:              cfg.Cost,
+		Protocol:          cfg.Protocol,
+		NetworkMask:       cfg.NetworkMask,
+		DestinationNw:     cfg.DestinationNw,
+	}
+	nextHop := ribd.NextHopInfo { 
+		NextHopIp : cfg.NextHopIp,
+		NextHopIntRef:cfg.OutgoingInterface,
+	}
+	rCfg.NextHop = make([]*ribd.NextHopInfo,0)
+	rCfg.NextHop = append(rCfg.NextHop,&nextHop)
+	return &rCfg
+}
+
+func isIPv6Route(cfg *config.RouteConfig) bool {
+	ip := net.ParseIP(cfg.DestinationNw)
+	return ip != nil && ip.To4() == nil
+}
+
+// The RIB daemon doesn't have VRFs and MPLS labels, the routes of the VRFs are not installed
+func (mgr *FSRouteMgr) isVrfRoute(cfg *config.RouteConfig) bool {
+	if cfg.Vrf == "" {
+		return false
+	}
+
+	mgr.logger.Info(fmt.Sprintln("Route", cfg.DestinationNw, cfg.NetworkMask, "in VRF", cfg.Vrf,
+		"labels", cfg.Labels, "is not installed in RIB"))
+	return true
+}
+
 func (mgr *FSRouteMgr) CreateRoute(cfg *config.RouteConfig) {
+	if mgr.isVrfRoute(cfg) {
+		return
+	}
+	if isIPv6Route(cfg) {
+		mgr.ribdClient.OnewayCreateIPv6Route(mgr.createRibdIPv6RouteCfg(cfg,
+			true /*create*/))
+		return
+	}
 	mgr.ribdClient.OnewayCreateIPv4Route(mgr.createRibdIPv4RouteCfg(cfg,
 		true /*create*/))
 }
 
 func (mgr *FSRouteMgr) DeleteRoute(cfg *config.RouteConfig) {
+	if mgr.isVrfRoute(cfg) {
+		return
+	}
+	if isIPv6Route(cfg) {
+		mgr.ribdClient.OnewayDeleteIPv6Route(mgr.createRibdIPv6RouteCfg(cfg,
+			false /*delete*/))
+		return
+	}
 	mgr.ribdClient.OnewayDeleteIPv4Route(mgr.createRibdIPv4RouteCfg(cfg,
 		false /*delete*/))
 }
 func (mgr *FSRouteMgr) UpdateRoute(cfg *config.RouteConfig, op string) {
-	rCfg := ribd.IPv4Route{
-		Cost:              cfg.Cost,
-		Protocol:          cfg.Protocol,
-		NetworkMask:       cfg.NetworkMask,
-		DestinationNw:     cfg.DestinationNw,
+	if mgr.isVrfRoute(cfg) {
+		return
 	}
 	nextHop := ribd.NextHopInfo { 
 		NextHopIp : cfg.NextHopIp,
 		NextHopIntRef:cfg.OutgoingInterface,
 	}
-    rCfg.NextHop = make([]*ribd.NextHopInfo,0)
-    rCfg.NextHop = append(rCfg.NextHop,&nextHop)
-    value,err := json.Marshal(rCfg.NextHop)
+    nextHops := make([]*ribd.NextHopInfo,0)
+    nextHops = append(nextHops,&nextHop)
+    value,err := json.Marshal(nextHops)
 	if err != nil {
-		mgr.logger.Err(fmt.Sprintln("Err:", err, " while marshalling nexthop : ", rCfg.NextHop))
+		mgr.logger.Err(fmt.Sprintln("Err:", err, " while marshalling nexthop : ", nextHops))
 		return
 	}
     patchOp := make([]*ribd.PatchOpInfo,0)
@@ -237,6 +284,24 @@ func (mgr *FSRouteMgr) UpdateRoute(cfg *config.RouteConfig, op string) {
                      Path:"NextHop",
                      Value : string(value),
                      })
+	if isIPv6Route(cfg) {
+		rCfg := ribd.IPv6Route{
+			Cost:              cfg.Cost,
+			Protocol:          cfg.Protocol,
+			NetworkMask:       cfg.NetworkMask,
+			DestinationNw:     cfg.DestinationNw,
+			NextHop:           nextHops,
+		}
+		mgr.ribdClient.UpdateIPv6Route(&rCfg, &rCfg, nil,patchOp)
+		return
+	}
+	rCfg := ribd.IPv4Route{
+		Cost:              cfg.Cost,
+		Protocol:          cfg.Protocol,
+		NetworkMask:       cfg.NetworkMask,
+		DestinationNw:     cfg.DestinationNw,
+		NextHop:           nextHops,
+	}
 	mgr.ribdClient.UpdateIPv4Route(&rCfg, &rCfg, nil,patchOp)
 }
 func (mgr *FSRouteMgr) ApplyPolicy(protocol string, policy string, action string, conditions []*config.ConditionInfo) {
@@ -288,3 +353,46 @@ func (mgr *FSRouteMgr) GetRoutes() ([]*config.RouteInfo, []*config.RouteInfo) {
 
 	return routes, (make([]*config.RouteInfo, 0))
 }
+
+/*  Returns the BGP routes installed in the RIB manager. The RIB manager keeps the routes
+ *  across a restart of bgpd.
+ */
+func (mgr *FSRouteMgr) GetInstalledRoutes() []*config.RouteConfig {
+	var currMarker ribd.Int
+	var count ribd.Int
+	routes := make([]*config.RouteConfig, 0)
+	count = 100
+	for {
+		getBulkInfo, err := mgr.ribdClient.GetBulkIPv4RouteState(currMarker, count)
+		if err != nil {
+			mgr.logger.Info(fmt.Sprintln("GetBulkIPv4RouteState with err ", err))
+			break
+		}
+
+		for _, state := range getBulkInfo.IPv4RouteStateList {
+			if state.Protocol != "EBGP" && state.Protocol != "IBGP" {
+				continue
+			}
+
+			ip, ipNet, err := net.ParseCIDR(state.DestinationNw)
+			if err != nil {
+				mgr.logger.Info(fmt.Sprintln("Failed to parse route", state.DestinationNw, "err", err))
+				continue
+			}
+			for _, nextHop := range state.NextHopList {
+				routes = append(routes, &config.RouteConfig{
+					Protocol:          state.Protocol,
+					NextHopIp:         nextHop.NextHopIp,
+					NetworkMask:       net.IP(ipNet.Mask).String(),
+					DestinationNw:     ip.String(),
+					OutgoingInterface: nextHop.NextHopIntRef,
+				})
+			}
+		}
+		if getBulkInfo.More == false {
+			break
+		}
+		currMarker = ribd.Int(getBulkInfo.EndIdx)
+	}
+	return routes
+}
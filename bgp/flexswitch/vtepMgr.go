@@ -0,0 +1,278 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+package FSMgr
+
+import (
+	"errors"
+	"fmt"
+	"github.com/vishvananda/netlink"
+	"l3/bgp/api"
+	"l3/bgp/config"
+	"l3/bgp/rpc"
+	"strings"
+	"syscall"
+	"utils/logging"
+	"vxland"
+)
+
+const (
+	vtepNamePrefix   = "bgp-vtep"
+	bridgeNamePrefix = "br"
+)
+
+/*  Init vtep manager with vxland client as its core
+ */
+func NewFSVtepMgr(logger *logging.Writer, fileName string) (*FSVtepMgr, error) {
+	var vxlandClient *vxland.VXLANDServicesClient = nil
+	vxlandClientChan := make(chan *vxland.VXLANDServicesClient)
+
+	logger.Info("Connecting to VXLANd")
+	go rpc.StartVxlandClient(logger, fileName, vxlandClientChan)
+	vxlandClient = <-vxlandClientChan
+	if vxlandClient == nil {
+		logger.Err("Failed to connect to VXLANd\n")
+		return nil, errors.New("Failed to connect to VXLANd")
+	} else {
+		logger.Info("Connected to VXLANd")
+	}
+	mgr := &FSVtepMgr{
+		plugin:       "flexswitch",
+		logger:       logger,
+		vxlandClient: vxlandClient,
+		vtepIds:      make(map[string]int32),
+		nextVtepId:   1,
+		localMacs:    make(map[string]bool),
+	}
+
+	return mgr, nil
+}
+
+/*  Do any necessary init. Called from server..
+ *  The MACs learnt on the ports of the VXLAN bridges are sent to the server to be
+ *  advertised as EVPN MAC/IP advertisement routes.
+ */
+func (mgr *FSVtepMgr) Start() {
+	updateCh := make(chan netlink.NeighUpdate)
+	if err := netlink.NeighSubscribe(updateCh, nil); err != nil {
+		mgr.logger.Err(fmt.Sprintln("Failed to subscribe to the bridge FDB updates, error:", err))
+		return
+	}
+
+	go mgr.listenForFDBUpdates(updateCh)
+}
+
+func getLocalMacKey(mac *config.EvpnMacInfo) string {
+	return fmt.Sprintf("%d-%s", mac.VNI, mac.MAC.String())
+}
+
+func (mgr *FSVtepMgr) addLocalMac(mac *config.EvpnMacInfo) bool {
+	key := getLocalMacKey(mac)
+	if mgr.localMacs[key] {
+		return false
+	}
+	mgr.localMacs[key] = true
+	return true
+}
+
+func (mgr *FSVtepMgr) removeLocalMac(mac *config.EvpnMacInfo) bool {
+	key := getLocalMacKey(mac)
+	if !mgr.localMacs[key] {
+		return false
+	}
+	delete(mgr.localMacs, key)
+	return true
+}
+
+/*  Returns the MAC of a bridge FDB entry learnt on a local port of a VXLAN bridge.
+ *  The entries of the VTEPs and the permanent entries of the ports are not local MACs.
+ */
+func (mgr *FSVtepMgr) getLocalMac(neigh *netlink.Neigh) *config.EvpnMacInfo {
+	if neigh.Family != syscall.AF_BRIDGE || neigh.HardwareAddr == nil ||
+		neigh.State&netlink.NUD_PERMANENT != 0 {
+		return nil
+	}
+
+	link, err := netlink.LinkByIndex(neigh.LinkIndex)
+	if err != nil || link.Type() == "vxlan" || link.Attrs().MasterIndex == 0 {
+		return nil
+	}
+
+	bridge, err := netlink.LinkByIndex(link.Attrs().MasterIndex)
+	if err != nil || !strings.HasPrefix(bridge.Attrs().Name, bridgeNamePrefix) {
+		return nil
+	}
+
+	var vni uint32
+	if _, err := fmt.Sscanf(bridge.Attrs().Name, bridgeNamePrefix+"%d", &vni); err != nil {
+		return nil
+	}
+
+	return &config.EvpnMacInfo{
+		VNI: vni,
+		MAC: neigh.HardwareAddr,
+	}
+}
+
+/*  The MACs already learnt are sent before listening for the FDB updates.
+ */
+func (mgr *FSVtepMgr) listenForFDBUpdates(updateCh chan netlink.NeighUpdate) {
+	neighs, err := netlink.NeighList(0, syscall.AF_BRIDGE)
+	if err != nil {
+		mgr.logger.Err(fmt.Sprintln("Failed to get the bridge FDB entries, error:", err))
+	}
+	add := make([]*config.EvpnMacInfo, 0)
+	for idx := range neighs {
+		if mac := mgr.getLocalMac(&neighs[idx]); mac != nil && mgr.addLocalMac(mac) {
+			add = append(add, mac)
+		}
+	}
+	if len(add) > 0 {
+		api.SendEvpnMacNotification(add, nil)
+	}
+
+	for update := range updateCh {
+		mac := mgr.getLocalMac(&update.Neigh)
+		if mac == nil {
+			continue
+		}
+
+		switch update.Type {
+		case syscall.RTM_NEWNEIGH:
+			if mgr.addLocalMac(mac) {
+				mgr.logger.Info(fmt.Sprintln("Local MAC", mac.MAC, "learnt on VNI", mac.VNI))
+				api.SendEvpnMacNotification([]*config.EvpnMacInfo{mac}, nil)
+			}
+
+		case syscall.RTM_DELNEIGH:
+			if mgr.removeLocalMac(mac) {
+				mgr.logger.Info(fmt.Sprintln("Local MAC", mac.MAC, "aged out on VNI", mac.VNI))
+				api.SendEvpnMacNotification(nil, []*config.EvpnMacInfo{mac})
+			}
+		}
+	}
+}
+
+func getVtepKey(vtep *config.VtepInfo) string {
+	return fmt.Sprintf("%d-%s", vtep.VNI, vtep.VtepIP.String())
+}
+
+func (mgr *FSVtepMgr) newVtepInstance(vtep *config.VtepInfo, vtepId int32) *vxland.VxlanVtepInstances {
+	vtepInst := vxland.NewVxlanVtepInstances()
+	vtepInst.VtepId = vtepId
+	vtepInst.VxlanId = int32(vtep.VNI)
+	vtepInst.VtepName = fmt.Sprintf("%s%d", vtepNamePrefix, vtepId)
+	vtepInst.DstIp = vtep.VtepIP.String()
+	return vtepInst
+}
+
+func (mgr *FSVtepMgr) CreateVtep(vtep *config.VtepInfo) (bool, error) {
+	key := getVtepKey(vtep)
+	if _, ok := mgr.vtepIds[key]; ok {
+		mgr.logger.Info(fmt.Sprintln("VTEP", key, "already created"))
+		return true, nil
+	}
+
+	vtepId := mgr.nextVtepId
+	vtepInst := mgr.newVtepInstance(vtep, vtepId)
+	mgr.logger.Info(fmt.Sprintln("Creating VTEP:", vtepInst))
+	ret, err := mgr.vxlandClient.CreateVxlanVtepInstances(vtepInst)
+	if err != nil || !ret {
+		mgr.logger.Err(fmt.Sprintln("Failed to create VTEP", key, "error:", err))
+		return ret, err
+	}
+
+	mgr.vtepIds[key] = vtepId
+	mgr.nextVtepId++
+	return ret, err
+}
+
+func (mgr *FSVtepMgr) DeleteVtep(vtep *config.VtepInfo) (bool, error) {
+	key := getVtepKey(vtep)
+	vtepId, ok := mgr.vtepIds[key]
+	if !ok {
+		mgr.logger.Info(fmt.Sprintln("VTEP", key, "not found"))
+		return false, errors.New(fmt.Sprintf("VTEP %s not found", key))
+	}
+
+	vtepInst := mgr.newVtepInstance(vtep, vtepId)
+	mgr.logger.Info(fmt.Sprintln("Deleting VTEP:", vtepInst))
+	delete(mgr.vtepIds, key)
+	return mgr.vxlandClient.DeleteVxlanVtepInstances(vtepInst)
+}
+
+/*  VXLANd does not have an API to program MAC entries. The remote MACs are added as static
+ *  entries to the FDB of the VXLAN bridge, on the VTEP created for the remote VTEP IP.
+ */
+func (mgr *FSVtepMgr) getRemoteMacNeigh(mac *config.EvpnMacInfo) (*netlink.Neigh, error) {
+	key := getVtepKey(&config.VtepInfo{VNI: mac.VNI, VtepIP: mac.VtepIP})
+	vtepId, ok := mgr.vtepIds[key]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("VTEP %s not found", key))
+	}
+
+	link, err := netlink.LinkByName(fmt.Sprintf("%s%d", vtepNamePrefix, vtepId))
+	if err != nil {
+		return nil, err
+	}
+
+	return &netlink.Neigh{
+		LinkIndex:    link.Attrs().Index,
+		Family:       syscall.AF_BRIDGE,
+		State:        netlink.NUD_NOARP | netlink.NUD_PERMANENT,
+		Flags:        netlink.NTF_MASTER,
+		HardwareAddr: mac.MAC,
+	}, nil
+}
+
+func (mgr *FSVtepMgr) AddRemoteMac(mac *config.EvpnMacInfo) (bool, error) {
+	neigh, err := mgr.getRemoteMacNeigh(mac)
+	if err != nil {
+		mgr.logger.Err(fmt.Sprintln("Failed to add remote MAC", mac.MAC, "VNI", mac.VNI, "behind VTEP", mac.VtepIP,
+			"error:", err))
+		return false, err
+	}
+
+	mgr.logger.Info(fmt.Sprintln("Adding remote MAC", mac.MAC, "VNI", mac.VNI, "behind VTEP", mac.VtepIP))
+	if err = netlink.NeighSet(neigh); err != nil {
+		mgr.logger.Err(fmt.Sprintln("Failed to add remote MAC", mac.MAC, "VNI", mac.VNI, "error:", err))
+		return false, err
+	}
+	return true, nil
+}
+
+func (mgr *FSVtepMgr) RemoveRemoteMac(mac *config.EvpnMacInfo) (bool, error) {
+	neigh, err := mgr.getRemoteMacNeigh(mac)
+	if err != nil {
+		mgr.logger.Err(fmt.Sprintln("Failed to remove remote MAC", mac.MAC, "VNI", mac.VNI, "behind VTEP",
+			mac.VtepIP, "error:", err))
+		return false, err
+	}
+
+	mgr.logger.Info(fmt.Sprintln("Removing remote MAC", mac.MAC, "VNI", mac.VNI, "behind VTEP", mac.VtepIP))
+	if err = netlink.NeighDel(neigh); err != nil {
+		mgr.logger.Err(fmt.Sprintln("Failed to remove remote MAC", mac.MAC, "VNI", mac.VNI, "error:", err))
+		return false, err
+	}
+	return true, nil
+}
@@ -36,8 +36,6 @@ import (
 	"time"
 	"utils/logging"
 	"utils/netUtils"
-
-	"golang.org/x/net/ipv4"
 )
 
 type OutTCPConn struct {
@@ -146,6 +144,16 @@ func (o *OutTCPConn) Connect(seconds uint32, remote, local string, connCh chan n
 		}
 	}
 
+	// Set the TTL before connecting so that the SYN is sent with the TTL of the peer too
+	ttl, minTTL := o.fsm.neighborConf.GetTTL()
+	err = utils.SetSocketTTL(socket, net.ParseIP(remoteIP).To4() == nil, ttl, minTTL)
+	if err != nil {
+		o.logger.Info(fmt.Sprintln("Neighbor:", o.fsm.pConf.NeighborAddress, "FSM", o.fsm.id,
+			"Set TTL on the socket failed with error", err))
+		errCh <- err
+		return
+	}
+
 	err = netUtils.Connect(socket, "tcp", remote, local, time.Duration(seconds)*time.Second)
 	if err != nil {
 		o.logger.Info(fmt.Sprintln("Neighbor:", o.fsm.pConf.NeighborAddress, "FSM", o.fsm.id,
@@ -158,16 +166,6 @@ func (o *OutTCPConn) Connect(seconds uint32, remote, local string, connCh chan n
 	if err != nil {
 		errCh <- err
 	} else {
-		packetConn := ipv4.NewConn(conn)
-		ttl := 1
-		if o.fsm.pConf.MultiHopEnable {
-			ttl = int(o.fsm.pConf.MultiHopTTL)
-		}
-		if err = packetConn.SetTTL(ttl); err != nil {
-			conn.Close()
-			errCh <- err
-			return
-		}
 		connCh <- conn
 	}
 }
@@ -348,6 +346,13 @@ func (p *PeerConn) DecodeMessage(header *packet.BGPHeader, buf []byte) (*packet.
 			p.logger.Info(fmt.Sprintln("Neighbor:", p.fsm.pConf.NeighborAddress,
 				"negotiated to recieve add paths from far end"))
 		}
+		p.peerAttrs.AddPathsRxFamilies = make(map[uint32]bool)
+		if p.fsm.pConf.AddPathsRx && packet.IsAddPathsEnabled(p.peerAttrs.AddPathFamily, packet.AfiIP6,
+			packet.SafiUnicast, packet.BGPCapAddPathTx) {
+			p.peerAttrs.AddPathsRxFamilies[packet.GetProtocolFamily(packet.AfiIP6, packet.SafiUnicast)] = true
+			p.logger.Info(fmt.Sprintln("Neighbor:", p.fsm.pConf.NeighborAddress,
+				"negotiated to recieve IPv6 add paths from far end"))
+		}
 	}
 
 	return msg, msgErr, msgOk
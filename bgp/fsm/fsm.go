@@ -96,6 +96,8 @@ const (
 	BGPEventKeepAliveMsg
 	BGPEventUpdateMsg
 	BGPEventUpdateMsgErr
+	BGPEventRouteRefreshMsg
+	BGPEventRouteRefreshMsgErr
 )
 
 var BGPEventTypeToStr = map[BGPFSMEvent]string{
@@ -127,6 +129,8 @@ var BGPEventTypeToStr = map[BGPFSMEvent]string{
 	BGPEventKeepAliveMsg:                    "KeepAliveMsg",
 	BGPEventUpdateMsg:                       "UpdateMsg",
 	BGPEventUpdateMsgErr:                    "UpdateMsgErr",
+	BGPEventRouteRefreshMsg:                 "RouteRefreshMsg",
+	BGPEventRouteRefreshMsgErr:              "RouteRefreshMsgErr",
 }
 
 type BaseStateIface interface {
@@ -299,7 +303,8 @@ func (st *ConnectState) processEvent(event BGPFSMEvent, data interface{}) {
 
 	case BGPEventAutoStop, BGPEventHoldTimerExp, BGPEventKeepAliveTimerExp, BGPEventIdleHoldTimerExp,
 		BGPEventBGPOpen, BGPEventOpenCollisionDump, BGPEventNotifMsg, BGPEventKeepAliveMsg,
-		BGPEventUpdateMsg, BGPEventUpdateMsgErr: // 8, 10, 11, 13, 19, 23, 25-28
+		BGPEventUpdateMsg, BGPEventUpdateMsgErr, BGPEventRouteRefreshMsg,
+		BGPEventRouteRefreshMsgErr: // 8, 10, 11, 13, 19, 23, 25-28
 		st.fsm.StopConnectRetryTimer()
 		st.fsm.StopConnToPeer()
 		st.fsm.IncrConnectRetryCounter()
@@ -402,7 +407,8 @@ func (st *ActiveState) processEvent(event BGPFSMEvent, data interface{}) {
 
 	case BGPEventAutoStop, BGPEventHoldTimerExp, BGPEventKeepAliveTimerExp, BGPEventIdleHoldTimerExp,
 		BGPEventBGPOpen, BGPEventOpenCollisionDump, BGPEventNotifMsg, BGPEventKeepAliveMsg,
-		BGPEventUpdateMsg, BGPEventUpdateMsgErr: // 8, 10, 11, 13, 19, 23, 25-28
+		BGPEventUpdateMsg, BGPEventUpdateMsgErr, BGPEventRouteRefreshMsg,
+		BGPEventRouteRefreshMsgErr: // 8, 10, 11, 13, 19, 23, 25-28
 		st.fsm.StopConnectRetryTimer()
 		st.fsm.ClearPeerConn()
 		st.fsm.StopConnToPeer()
@@ -447,7 +453,7 @@ func (st *OpenSentState) processEvent(event BGPFSMEvent, data interface{}) {
 
 	switch event {
 	case BGPEventManualStop:
-		st.fsm.SendNotificationMessage(packet.BGPCease, 0, nil)
+		st.fsm.SendNotificationMessage(packet.BGPCease, st.fsm.ceaseSubCode, st.fsm.ceaseData)
 		st.fsm.StopConnectRetryTimer()
 		st.fsm.ClearPeerConn()
 		st.fsm.StopConnToPeer()
@@ -455,7 +461,7 @@ func (st *OpenSentState) processEvent(event BGPFSMEvent, data interface{}) {
 		st.fsm.ChangeState(NewIdleState(st.fsm))
 
 	case BGPEventAutoStop:
-		st.fsm.SendNotificationMessage(packet.BGPCease, 0, nil)
+		st.fsm.SendNotificationMessage(packet.BGPCease, st.fsm.ceaseSubCode, st.fsm.ceaseData)
 		st.fsm.StopConnectRetryTimer()
 		st.fsm.ClearPeerConn()
 		st.fsm.StopConnToPeer()
@@ -515,7 +521,8 @@ func (st *OpenSentState) processEvent(event BGPFSMEvent, data interface{}) {
 
 	case BGPEventConnRetryTimerExp, BGPEventKeepAliveTimerExp, BGPEventDelayOpenTimerExp,
 		BGPEventIdleHoldTimerExp, BGPEventBGPOpenDelayOpenTimer, BGPEventNotifMsg,
-		BGPEventKeepAliveMsg, BGPEventUpdateMsg, BGPEventUpdateMsgErr: // 9, 11, 12, 13, 20, 25-28
+		BGPEventKeepAliveMsg, BGPEventUpdateMsg, BGPEventUpdateMsgErr, BGPEventRouteRefreshMsg,
+		BGPEventRouteRefreshMsgErr: // 9, 11, 12, 13, 20, 25-28
 		st.fsm.SendNotificationMessage(packet.BGPFSMError, 0, nil)
 		st.fsm.StopConnectRetryTimer()
 		st.fsm.ClearPeerConn()
@@ -561,7 +568,7 @@ func (st *OpenConfirmState) processEvent(event BGPFSMEvent, data interface{}) {
 
 	switch event {
 	case BGPEventManualStop:
-		st.fsm.SendNotificationMessage(packet.BGPCease, 0, nil)
+		st.fsm.SendNotificationMessage(packet.BGPCease, st.fsm.ceaseSubCode, st.fsm.ceaseData)
 		st.fsm.ClearPeerConn()
 		st.fsm.StopConnToPeer()
 		st.fsm.StopConnectRetryTimer()
@@ -569,7 +576,7 @@ func (st *OpenConfirmState) processEvent(event BGPFSMEvent, data interface{}) {
 		st.fsm.ChangeState(NewIdleState(st.fsm))
 
 	case BGPEventAutoStop:
-		st.fsm.SendNotificationMessage(packet.BGPCease, 0, nil)
+		st.fsm.SendNotificationMessage(packet.BGPCease, st.fsm.ceaseSubCode, st.fsm.ceaseData)
 		st.fsm.StopConnectRetryTimer()
 		st.fsm.ClearPeerConn()
 		st.fsm.StopConnToPeer()
@@ -629,7 +636,8 @@ func (st *OpenConfirmState) processEvent(event BGPFSMEvent, data interface{}) {
 		st.fsm.ChangeState(NewEstablishedState(st.fsm))
 
 	case BGPEventConnRetryTimerExp, BGPEventDelayOpenTimerExp, BGPEventIdleHoldTimerExp,
-		BGPEventBGPOpenDelayOpenTimer, BGPEventUpdateMsg, BGPEventUpdateMsgErr: // 9, 12, 13, 20, 27, 28
+		BGPEventBGPOpenDelayOpenTimer, BGPEventUpdateMsg, BGPEventUpdateMsgErr, BGPEventRouteRefreshMsg,
+		BGPEventRouteRefreshMsgErr: // 9, 12, 13, 20, 27, 28
 		st.fsm.SendNotificationMessage(packet.BGPCease, 0, nil)
 		st.fsm.StopConnectRetryTimer()
 		st.fsm.ClearPeerConn()
@@ -678,7 +686,7 @@ func (st *EstablishedState) processEvent(event BGPFSMEvent, data interface{}) {
 
 	switch event {
 	case BGPEventManualStop:
-		st.fsm.SendNotificationMessage(packet.BGPCease, 0, nil)
+		st.fsm.SendNotificationMessage(packet.BGPCease, st.fsm.ceaseSubCode, st.fsm.ceaseData)
 		st.fsm.StopConnectRetryTimer()
 		st.fsm.ClearPeerConn()
 		st.fsm.StopConnToPeer()
@@ -686,7 +694,7 @@ func (st *EstablishedState) processEvent(event BGPFSMEvent, data interface{}) {
 		st.fsm.ChangeState(NewIdleState(st.fsm))
 
 	case BGPEventAutoStop:
-		st.fsm.SendNotificationMessage(packet.BGPCease, 0, nil)
+		st.fsm.SendNotificationMessage(packet.BGPCease, st.fsm.ceaseSubCode, st.fsm.ceaseData)
 		st.fsm.StopConnectRetryTimer()
 		st.fsm.ClearPeerConn()
 		st.fsm.StopConnToPeer()
@@ -736,7 +744,12 @@ func (st *EstablishedState) processEvent(event BGPFSMEvent, data interface{}) {
 		bgpMsg := data.(*packet.BGPMessage)
 		st.fsm.ProcessUpdateMessage(bgpMsg)
 
-	case BGPEventUpdateMsgErr:
+	case BGPEventRouteRefreshMsg:
+		st.fsm.StartHoldTimer()
+		bgpMsg := data.(*packet.BGPMessage)
+		st.fsm.ProcessRouteRefreshMessage(bgpMsg)
+
+	case BGPEventUpdateMsgErr, BGPEventRouteRefreshMsgErr:
 		bgpMsgErr := data.(*packet.BGPMessageError)
 		st.fsm.SendNotificationMessage(bgpMsgErr.TypeCode, bgpMsgErr.SubTypeCode, bgpMsgErr.Data)
 		st.fsm.StopConnectRetryTimer()
@@ -796,8 +809,10 @@ type PeerFSMConnState struct {
 }
 
 type PeerFSMEvent struct {
-	event  BGPFSMEvent
-	reason int
+	event       BGPFSMEvent
+	reason      int
+	ceaseData   []byte
+	restartTime uint32
 }
 
 type FSM struct {
@@ -832,6 +847,14 @@ type FSM struct {
 
 	restartTime  uint32
 	restartTimer *time.Timer
+	ceaseSubCode uint8
+	ceaseData    []byte
+
+	// OPEN messages and the NOTIFICATION message of the peer connection
+	sentOpenMsg  []byte
+	rcvdOpenMsg  []byte
+	notifMsg     []byte
+	notifMsgSent bool
 
 	autoStart       bool
 	autoStop        bool
@@ -845,11 +868,12 @@ type FSM struct {
 	delayOpenTime  uint16
 	delayOpenTimer *time.Timer
 
-	afiSafiMap map[uint32]bool
-	pktTxCh    chan *packet.BGPMessage
-	pktRxCh    chan *packet.BGPPktInfo
-	eventRxCh  chan PeerFSMEvent
-	rxPktsFlag bool
+	afiSafiMap     map[uint32]bool
+	pktTxCh        chan *packet.BGPMessage
+	encodedPktTxCh chan [][]byte
+	pktRxCh        chan *packet.BGPPktInfo
+	eventRxCh      chan PeerFSMEvent
+	rxPktsFlag     bool
 
 	cleanup bool
 }
@@ -884,6 +908,7 @@ func NewFSM(fsmManager *FSMManager, id uint8, neighborConf *base.NeighborConf) *
 	}
 
 	fsm.pktTxCh = make(chan *packet.BGPMessage)
+	fsm.encodedPktTxCh = make(chan [][]byte)
 	fsm.pktRxCh = make(chan *packet.BGPPktInfo, 2)
 	fsm.eventRxCh = make(chan PeerFSMEvent, 5)
 	fsm.connectRetryTimer = time.NewTimer(time.Duration(fsm.connectRetryTime) * time.Second)
@@ -956,10 +981,22 @@ func (fsm *FSM) StartFSM() {
 		case bgpMsg := <-fsm.pktTxCh:
 			if fsm.State.state() != BGPFSMEstablished {
 				fsm.logger.Info(fmt.Sprintln("Neighbor:", fsm.pConf.NeighborAddress, "FSM", fsm.id,
-					"is not in Established state, can't send the UPDATE message"))
+					"is not in Established state, can't send message type", bgpMsg.Header.Type))
+				continue
+			}
+			if bgpMsg.Header.Type == packet.BGPMsgTypeRouteRefresh {
+				fsm.sendRouteRefreshMessage(bgpMsg)
+			} else {
+				fsm.sendUpdateMessage(bgpMsg)
+			}
+
+		case pkts := <-fsm.encodedPktTxCh:
+			if fsm.State.state() != BGPFSMEstablished {
+				fsm.logger.Info(fmt.Sprintln("Neighbor:", fsm.pConf.NeighborAddress, "FSM", fsm.id,
+					"is not in Established state, can't send encoded Update messages"))
 				continue
 			}
-			fsm.sendUpdateMessage(bgpMsg)
+			fsm.sendEncodedUpdateMessages(pkts)
 
 		case bgpPktInfo := <-fsm.pktRxCh:
 			fsm.ProcessPacket(bgpPktInfo.Msg, bgpPktInfo.MsgError)
@@ -968,11 +1005,18 @@ func (fsm *FSM) StartFSM() {
 			fsm.logger.Info(fmt.Sprintln("Neighbor:", fsm.pConf.NeighborAddress, "FSM", fsm.id,
 				"Received event", fsmEvent.event, "reason", fsmEvent.reason))
 			if fsmEvent.reason == BGPCmdReasonMaxPrefixExceeded {
-				fsm.restartTime = uint32(fsm.neighborConf.RunningConf.MaxPrefixesRestartTimer)
+				fsm.restartTime = fsmEvent.restartTime
+				fsm.ceaseSubCode = packet.BGPCeaseMaxPrefixesReached
+				fsm.ceaseData = fsmEvent.ceaseData
+			} else if fsmEvent.reason == BGPCmdReasonAdminShutdown {
+				fsm.ceaseSubCode = packet.BGPCeaseAdminShutdown
+				fsm.ceaseData = fsmEvent.ceaseData
 			}
 			fsm.ProcessEvent(fsmEvent.event, nil)
 			if fsmEvent.reason != BGPCmdReasonNone {
 				fsm.restartTime = 0
+				fsm.ceaseSubCode = 0
+				fsm.ceaseData = nil
 			}
 
 		case <-fsm.connectRetryTimer.C:
@@ -1013,12 +1057,23 @@ func (fsm *FSM) ProcessPacket(msg *packet.BGPMessage, msgErr *packet.BGPMessageE
 
 		case packet.BGPUpdateMsgError:
 			event = BGPEventUpdateMsgErr
+
+		case packet.BGPRouteRefreshMsgError:
+			event = BGPEventRouteRefreshMsgErr
 		}
 	} else {
 		data = msg
 		switch msg.Header.Type {
 		case packet.BGPMsgTypeOpen:
 			event = BGPEventBGPOpen
+			peerAS := packet.GetPeerAS(msg.Body.(*packet.BGPOpen))
+			if !fsm.neighborConf.IsPeerASAllowed(peerAS) {
+				fsm.logger.Info(fmt.Sprintln("Neighbor:", fsm.pConf.NeighborAddress, "FSM", fsm.id,
+					"Peer AS", peerAS, "is not allowed"))
+				event = BGPEventOpenMsgErr
+				data = &packet.BGPMessageError{TypeCode: packet.BGPOpenMsgError, SubTypeCode: packet.BGPBadPeerAS,
+					Message: fmt.Sprintf("Peer AS %d is not allowed", peerAS)}
+			}
 
 		case packet.BGPMsgTypeUpdate:
 			event = BGPEventUpdateMsg
@@ -1027,11 +1082,21 @@ func (fsm *FSM) ProcessPacket(msg *packet.BGPMessage, msgErr *packet.BGPMessageE
 			fsm.neighborConf.Neighbor.State.Messages.Received.Notification++
 			event = BGPEventNotifMsg
 			notifyMsg := msg.Body.(*packet.BGPNotification)
+			fsm.notifMsg, _ = msg.Encode()
+			fsm.notifMsgSent = false
 			fsm.logger.Info(fmt.Sprintln("Neighbor:", fsm.pConf.NeighborAddress, "FSM", fsm.id,
 				"Received notification message:", notifyMsg.ErrorCode, notifyMsg.ErrorSubcode, notifyMsg.Data))
+			if notifyMsg.ErrorCode == packet.BGPCease && notifyMsg.ErrorSubcode == packet.BGPCeaseAdminShutdown {
+				fsm.logger.Info(fmt.Sprintln("Neighbor:", fsm.pConf.NeighborAddress, "FSM", fsm.id,
+					"Shutdown communication:", packet.GetShutdownCommunication(notifyMsg.Data)))
+			}
 
 		case packet.BGPMsgTypeKeepAlive:
 			event = BGPEventKeepAliveMsg
+
+		case packet.BGPMsgTypeRouteRefresh:
+			fsm.neighborConf.Neighbor.State.Messages.Received.RouteRefresh++
+			event = BGPEventRouteRefreshMsg
 		}
 	}
 	if event != BGPEventKeepAliveMsg {
@@ -1176,11 +1241,19 @@ func (fsm *FSM) ProcessOpenMessage(pkt *packet.BGPMessage) bool {
 	}
 	if body.MyAS == fsm.Manager.gConf.AS {
 		fsm.peerType = config.PeerTypeInternal
+	} else if fsm.Manager.gConf.IsConfedMember(body.MyAS) {
+		fsm.peerType = config.PeerTypeConfedExternal
 	} else {
 		fsm.peerType = config.PeerTypeExternal
 	}
 
 	afiSafiMap := packet.GetProtocolFromOpenMsg(body)
+	if len(afiSafiMap) == 0 {
+		// A peer that does not advertise the multiprotocol capability only supports IPv4 unicast
+		afiSafiMap[packet.ProtocolFamilyMap["ipv4-unicast"]] = true
+	}
+	fsm.rcvdOpenMsg, _ = pkt.Encode()
+	fsm.afiSafiMap = make(map[uint32]bool)
 	for protoFamily, _ := range afiSafiMap {
 		if fsm.neighborConf.AfiSafiMap[protoFamily] {
 			fsm.afiSafiMap[protoFamily] = true
@@ -1209,6 +1282,30 @@ func (fsm *FSM) ProcessUpdateMessage(pkt *packet.BGPMessage) {
 	}()
 }
 
+func (fsm *FSM) ProcessRouteRefreshMessage(pkt *packet.BGPMessage) {
+	routeRefresh := pkt.Body.(*packet.BGPRouteRefresh)
+	fsm.logger.Info(fmt.Sprintln("Neighbor:", fsm.pConf.NeighborAddress, "FSM", fsm.id,
+		"ProcessRouteRefreshMessage: AFI", routeRefresh.AFI, "SAFI", routeRefresh.SAFI, "subtype",
+		routeRefresh.SubType, "send message to server"))
+	go func() {
+		fsm.Manager.bgpPktSrcCh <- packet.NewBGPPktSrc(fsm.Manager.neighborConf.Neighbor.NeighborAddress.String(), pkt)
+	}()
+}
+
+func (fsm *FSM) sendRouteRefreshMessage(bgpMsg *packet.BGPMessage) {
+	packet, _ := bgpMsg.Encode()
+	num, err := (*fsm.peerConn.conn).Write(packet)
+	if err != nil {
+		fsm.logger.Info(fmt.Sprintln("Neighbor:", fsm.pConf.NeighborAddress, "FSM", fsm.id,
+			"Conn.Write failed to send Route Refresh message with error:", err))
+		return
+	}
+	fsm.neighborConf.Neighbor.State.Messages.Sent.RouteRefresh++
+	fsm.logger.Info(fmt.Sprintln("Neighbor:", fsm.pConf.NeighborAddress, "FSM", fsm.id,
+		"Conn.Write succeeded. sent Route Refresh message of", num, "bytes"))
+	fsm.StartKeepAliveTimer()
+}
+
 func (fsm *FSM) sendUpdateMessage(bgpMsg *packet.BGPMessage) {
 	updateMsgs := packet.ConstructMaxSizedUpdatePackets(bgpMsg)
 	atomic.AddUint32(&fsm.neighborConf.Neighbor.State.Queues.Output, ^uint32(0))
@@ -1228,13 +1325,35 @@ func (fsm *FSM) sendUpdateMessage(bgpMsg *packet.BGPMessage) {
 	fsm.StartKeepAliveTimer()
 }
 
+// Sends the Update messages that are already encoded, they are shared by all the peers of an update group
+func (fsm *FSM) sendEncodedUpdateMessages(pkts [][]byte) {
+	atomic.AddUint32(&fsm.neighborConf.Neighbor.State.Queues.Output, ^uint32(0))
+
+	for _, pkt := range pkts {
+		num, err := (*fsm.peerConn.conn).Write(pkt)
+		if err != nil {
+			fsm.logger.Info(fmt.Sprintln("Neighbor:", fsm.pConf.NeighborAddress, "FSM", fsm.id,
+				"Conn.Write failed to send Update message with error:", err))
+			return
+		}
+		fsm.neighborConf.Neighbor.State.Messages.Sent.Update++
+		fsm.logger.Info(fmt.Sprintln("Neighbor:", fsm.pConf.NeighborAddress, "FSM", fsm.id,
+			"Conn.Write succeeded. sent Update message of", num, "bytes"))
+	}
+	fsm.StartKeepAliveTimer()
+}
+
 func (fsm *FSM) sendOpenMessage() {
 	fsm.logger.Info(fmt.Sprintln("Neighbor:", fsm.pConf.NeighborAddress, "FSM", fsm.id,
 		"sendOpenMessage: send address family", fsm.neighborConf.AfiSafiMap))
-	optParams := packet.ConstructOptParams(uint32(fsm.pConf.LocalAS), fsm.neighborConf.AfiSafiMap,
-		fsm.neighborConf.RunningConf.AddPathsRx, fsm.neighborConf.RunningConf.AddPathsMaxTx)
-	bgpOpenMsg := packet.NewBGPOpenMessage(fsm.pConf.LocalAS, uint16(fsm.holdTime), fsm.gConf.RouterId.To4().String(), optParams)
+	localAS := fsm.neighborConf.GetAdvertisedAS()
+	optParams := packet.ConstructOptParams(localAS, fsm.neighborConf.AfiSafiMap,
+		fsm.neighborConf.RunningConf.AddPathsRx, fsm.neighborConf.RunningConf.AddPathsMaxTx,
+		fsm.gConf.GracefulRestart, fsm.gConf.GracefulRestartTime, fsm.neighborConf.Restarting,
+		fsm.neighborConf.IsExtendedNextHopEnabled(), fsm.neighborConf.GetPrefixORFCapFlags())
+	bgpOpenMsg := packet.NewBGPOpenMessage(localAS, uint16(fsm.holdTime), fsm.gConf.RouterId.To4().String(), optParams)
 	packet, _ := bgpOpenMsg.Encode()
+	fsm.sentOpenMsg = packet
 	num, err := (*fsm.peerConn.conn).Write(packet)
 	if err != nil {
 		fsm.logger.Info(fmt.Sprintln("Neighbor:", fsm.pConf.NeighborAddress, "FSM", fsm.id,
@@ -1266,6 +1385,8 @@ func (fsm *FSM) SendNotificationMessage(code uint8, subCode uint8, data []byte)
 		return
 	}
 	fsm.neighborConf.Neighbor.State.Messages.Sent.Notification++
+	fsm.notifMsg = packet
+	fsm.notifMsgSent = true
 	fsm.logger.Info(fmt.Sprintln("Neighbor:", fsm.pConf.NeighborAddress, "FSM", fsm.id,
 		"Conn.Write succeeded. sent Notification message with", num, "bytes"))
 }
@@ -1278,6 +1399,10 @@ func (fsm *FSM) SetPeerConn(data interface{}) {
 		return
 	}
 	pConnDir := data.(PeerConnDir)
+	fsm.sentOpenMsg = nil
+	fsm.rcvdOpenMsg = nil
+	fsm.notifMsg = nil
+	fsm.notifMsgSent = false
 	fsm.peerConn = NewPeerConn(fsm, pConnDir.connDir, pConnDir.conn)
 	go fsm.peerConn.StartReading()
 }
@@ -1321,7 +1446,7 @@ func (fsm *FSM) ConnEstablished() {
 
 func (fsm *FSM) ConnBroken() {
 	fsm.logger.Info(fmt.Sprintln("Neighbor:", fsm.pConf.NeighborAddress, "FSM", fsm.id, "ConnBroken - start"))
-	fsm.Manager.fsmBroken(fsm.id, false)
+	fsm.Manager.fsmBroken(fsm.id, false, fsm.notifMsg, fsm.notifMsgSent)
 	fsm.logger.Info(fmt.Sprintln("Neighbor:", fsm.pConf.NeighborAddress, "FSM", fsm.id, "ConnBroken - end"))
 }
 
@@ -1341,7 +1466,16 @@ func (fsm *FSM) InitiateConnToPeer() {
 		fsm.logger.Info("Unknown neighbor address")
 		return
 	}
-	remote := net.JoinHostPort(fsm.pConf.NeighborAddress.String(), config.BGPPort)
+	if fsm.neighborConf.IsDynamic() {
+		// Dynamic neighbors only accept connections from the peer
+		return
+	}
+	remoteHost := fsm.pConf.NeighborAddress.String()
+	if fsm.pConf.NeighborAddress.IsLinkLocalUnicast() && fsm.neighborConf.IntfName != "" {
+		// Link-local addresses need the zone of the interface the neighbor is on
+		remoteHost = remoteHost + "%" + fsm.neighborConf.IntfName
+	}
+	remote := net.JoinHostPort(remoteHost, config.BGPPort)
 	local := ""
 
 	fsm.logger.Info(fmt.Sprintln("Neighbor:", fsm.pConf.NeighborAddress, "FSM", fsm.id, "InitiateConnToPeer - source =",
@@ -34,10 +34,18 @@ import (
 	"utils/logging"
 )
 
+// SentOpen and RcvdOpen are the OPEN messages of the established connection.
+// Notification is the NOTIFICATION message sent or received when the connection
+// is broken and Deconfigured is set when the neighbor is removed.
 type PeerFSMConn struct {
-	PeerIP      string
-	Established bool
-	Conn        *net.Conn
+	PeerIP           string
+	Established      bool
+	Conn             *net.Conn
+	SentOpen         []byte
+	RcvdOpen         []byte
+	Notification     []byte
+	NotificationSent bool
+	Deconfigured     bool
 }
 
 type PeerFSMState struct {
@@ -57,11 +65,16 @@ type PeerAttrs struct {
 const (
 	BGPCmdReasonNone int = iota
 	BGPCmdReasonMaxPrefixExceeded
+	BGPCmdReasonAdminShutdown
 )
 
+// CeaseData and RestartTime are set for the BGPCmdReasonMaxPrefixExceeded reason
+// CeaseData carries the shutdown communication for the BGPCmdReasonAdminShutdown reason
 type PeerFSMCommand struct {
-	Command int
-	Reason  int
+	Command     int
+	Reason      int
+	CeaseData   []byte
+	RestartTime uint32
 }
 
 type FSMManager struct {
@@ -117,6 +130,10 @@ func (mgr *FSMManager) Init() {
 	go fsm.StartFSM()
 	mgr.fsms[fsmId] = fsm
 	fsm.passiveTcpEstCh <- true
+	if mgr.neighborConf.IsDynamic() {
+		// Dynamic neighbors are created for a connection from the peer, accept it right away
+		mgr.acceptConn = true
+	}
 
 	for {
 		select {
@@ -174,7 +191,8 @@ func (mgr *FSMManager) Init() {
 					if fsm != nil {
 						mgr.logger.Info(fmt.Sprintf("FSMManager: Neighbor %s: FSM %d Send command %d",
 							mgr.pConf.NeighborAddress, id, event))
-						fsm.eventRxCh <- PeerFSMEvent{event, fsmCommand.Reason}
+						fsm.eventRxCh <- PeerFSMEvent{event, fsmCommand.Reason, fsmCommand.CeaseData,
+							fsmCommand.RestartTime}
 					}
 				}
 			}
@@ -204,7 +222,7 @@ func (mgr *FSMManager) fsmClose(id uint8) {
 	if closeFSM, ok := mgr.fsms[id]; ok {
 		mgr.logger.Info(fmt.Sprintf("FSMManager: Peer %s, close FSM %d", mgr.pConf.NeighborAddress.String(), id))
 		closeFSM.closeCh <- true
-		mgr.fsmBroken(id, false)
+		mgr.fsmBroken(id, false, nil, false)
 		mgr.fsms[id] = nil
 		delete(mgr.fsms, id)
 		mgr.logger.Info(fmt.Sprintf("FSMManager: Peer %s, closed FSM %d", mgr.pConf.NeighborAddress.String(), id))
@@ -216,20 +234,35 @@ func (mgr *FSMManager) fsmClose(id uint8) {
 
 func (mgr *FSMManager) fsmEstablished(id uint8, conn *net.Conn) {
 	mgr.logger.Info(fmt.Sprintf("FSMManager: Peer %s FSM %d connection established", mgr.pConf.NeighborAddress.String(), id))
-	if _, ok := mgr.fsms[id]; ok {
+	if fsm, ok := mgr.fsms[id]; ok {
 		mgr.activeFSM = id
-		mgr.fsmConnCh <- PeerFSMConn{mgr.neighborConf.Neighbor.NeighborAddress.String(), true, conn}
+		mgr.fsmConnCh <- PeerFSMConn{
+			PeerIP:      mgr.neighborConf.Neighbor.NeighborAddress.String(),
+			Established: true,
+			Conn:        conn,
+			SentOpen:    fsm.sentOpenMsg,
+			RcvdOpen:    fsm.rcvdOpenMsg,
+		}
 	} else {
 		mgr.logger.Info(fmt.Sprintf("FSMManager: Peer %s FSM %d not found in fsms dict %v", mgr.pConf.NeighborAddress.String(), id, mgr.fsms))
 	}
 	//mgr.Peer.PeerConnEstablished(conn)
 }
 
-func (mgr *FSMManager) fsmBroken(id uint8, fsmDelete bool) {
+/*  The notification sent or received on the broken connection is passed by the FSM, the FSM is
+ *  not read from the manager. The connections closed by the manager have no notification.
+ */
+func (mgr *FSMManager) fsmBroken(id uint8, fsmDelete bool, notification []byte, notificationSent bool) {
 	mgr.logger.Info(fmt.Sprintf("FSMManager: Peer %s FSM %d connection broken", mgr.pConf.NeighborAddress.String(), id))
 	if mgr.activeFSM == id {
 		mgr.activeFSM = uint8(config.ConnDirInvalid)
-		mgr.fsmConnCh <- PeerFSMConn{mgr.neighborConf.Neighbor.NeighborAddress.String(), false, nil}
+		mgr.fsmConnCh <- PeerFSMConn{
+			PeerIP:           mgr.neighborConf.Neighbor.NeighborAddress.String(),
+			Established:      false,
+			Deconfigured:     fsmDelete,
+			Notification:     notification,
+			NotificationSent: notificationSent,
+		}
 		//mgr.Peer.PeerConnBroken(fsmDelete)
 	}
 }
@@ -252,6 +285,46 @@ func (mgr *FSMManager) SendUpdateMsg(bgpMsg *packet.BGPMessage) {
 	mgr.fsms[mgr.activeFSM].pktTxCh <- bgpMsg
 }
 
+func (mgr *FSMManager) SendEncodedUpdateMsgs(pkts [][]byte) {
+	defer mgr.fsmMutex.RUnlock()
+	mgr.fsmMutex.RLock()
+
+	if mgr.activeFSM == uint8(config.ConnDirInvalid) {
+		mgr.logger.Info(fmt.Sprintf("FSMManager: Neighbor %s FSM is not in ESTABLISHED state", mgr.pConf.NeighborAddress))
+		return
+	}
+	mgr.logger.Info(fmt.Sprintf("FSMManager: Neighbor %s FSM %d - send %d encoded updates", mgr.pConf.NeighborAddress,
+		mgr.activeFSM, len(pkts)))
+	mgr.fsms[mgr.activeFSM].encodedPktTxCh <- pkts
+}
+
+func (mgr *FSMManager) SendRouteRefreshMsg(afi packet.AFI, safi packet.SAFI, subType uint8) {
+	defer mgr.fsmMutex.RUnlock()
+	mgr.fsmMutex.RLock()
+
+	if mgr.activeFSM == uint8(config.ConnDirInvalid) {
+		mgr.logger.Info(fmt.Sprintf("FSMManager: Neighbor %s FSM is not in ESTABLISHED state", mgr.pConf.NeighborAddress))
+		return
+	}
+	mgr.logger.Info(fmt.Sprintf("FSMManager: Neighbor %s FSM %d - send route refresh, AFI %d SAFI %d subtype %d",
+		mgr.pConf.NeighborAddress, mgr.activeFSM, afi, safi, subType))
+	mgr.fsms[mgr.activeFSM].pktTxCh <- packet.NewBGPRouteRefreshMessage(afi, safi, subType)
+}
+
+func (mgr *FSMManager) SendRouteRefreshORFMsg(afi packet.AFI, safi packet.SAFI, whenToRefresh uint8,
+	prefixORF []*packet.AddressPrefixORFEntry) {
+	defer mgr.fsmMutex.RUnlock()
+	mgr.fsmMutex.RLock()
+
+	if mgr.activeFSM == uint8(config.ConnDirInvalid) {
+		mgr.logger.Info(fmt.Sprintf("FSMManager: Neighbor %s FSM is not in ESTABLISHED state", mgr.pConf.NeighborAddress))
+		return
+	}
+	mgr.logger.Info(fmt.Sprintf("FSMManager: Neighbor %s FSM %d - send route refresh with prefix ORF, AFI %d "+
+		"SAFI %d entries %v", mgr.pConf.NeighborAddress, mgr.activeFSM, afi, safi, prefixORF))
+	mgr.fsms[mgr.activeFSM].pktTxCh <- packet.NewBGPRouteRefreshORFMessage(afi, safi, whenToRefresh, prefixORF)
+}
+
 func (mgr *FSMManager) Cleanup() {
 	defer mgr.fsmMutex.Unlock()
 	mgr.fsmMutex.Lock()
@@ -261,7 +334,7 @@ func (mgr *FSMManager) Cleanup() {
 			mgr.logger.Info(fmt.Sprintf("FSMManager: Neighbor %s FSM %d - cleanup FSM", mgr.pConf.NeighborAddress, id))
 			fsm.closeCh <- true
 			fsm = nil
-			mgr.fsmBroken(id, true)
+			mgr.fsmBroken(id, true, nil, false)
 			mgr.fsms[id] = nil
 			delete(mgr.fsms, id)
 		}
@@ -275,8 +348,8 @@ func (mgr *FSMManager) StopFSM(stopMsg string) {
 	for id, fsm := range mgr.fsms {
 		if fsm != nil {
 			mgr.logger.Info(fmt.Sprintf("FSMManager: Neighbor %s FSM %d - Stop FSM", mgr.pConf.NeighborAddress, id))
-			fsm.eventRxCh <- PeerFSMEvent{BGPEventTcpConnFails, BGPCmdReasonNone}
-			mgr.fsmBroken(id, false)
+			fsm.eventRxCh <- PeerFSMEvent{BGPEventTcpConnFails, BGPCmdReasonNone, nil, 0}
+			mgr.fsmBroken(id, false, nil, false)
 		}
 	}
 }
@@ -351,9 +424,24 @@ func (mgr *FSMManager) receivedBGPOpenMessage(id uint8, connDir config.ConnDir,
 		}
 	}
 	if closeConnDir == config.ConnDirInvalid || closeConnDir != connDir {
+		if mgr.neighborConf.IsDynamic() {
+			mgr.neighborConf.SetDynamicPeerAS(packet.GetPeerAS(openMsg))
+		}
 		asSize := packet.GetASSize(openMsg)
 		addPathFamily := packet.GetAddPathFamily(openMsg)
-		mgr.neighborConf.SetPeerAttrs(openMsg.BGPId, asSize, mgr.fsms[id].holdTime, mgr.fsms[id].keepAliveTime, addPathFamily)
+		grCap := packet.GetGracefulRestartCap(openMsg)
+		routeRefresh := packet.HasCapability(openMsg, packet.BGPCapTypeRouteRefresh)
+		enhancedRR := packet.HasCapability(openMsg, packet.BGPCapTypeEnhancedRouteRefresh)
+		extendedNextHop := false
+		if mgr.neighborConf.IsExtendedNextHopEnabled() {
+			extNHCap := packet.GetExtendedNextHopCap(openMsg)
+			extendedNextHop = extNHCap != nil &&
+				extNHCap.IsNextHopAFISupported(packet.AfiIP, packet.SafiUnicast, packet.AfiIP6)
+		}
+		prefixORFFamily := packet.GetPrefixORFFamily(openMsg)
+		mgr.neighborConf.SetPeerAttrs(openMsg.BGPId, asSize, mgr.fsms[id].holdTime, mgr.fsms[id].keepAliveTime,
+			addPathFamily, grCap, routeRefresh, enhancedRR, extendedNextHop, prefixORFFamily,
+			mgr.fsms[id].afiSafiMap)
 	}
 
 	if closeConnDir == connDir {
@@ -86,6 +86,7 @@ func main() {
 		pMgr := ovsMgr.NewOvsPolicyMgr()
 		iMgr := ovsMgr.NewOvsIntfMgr()
 		bMgr := ovsMgr.NewOvsBfdMgr()
+		vMgr := ovsMgr.NewOvsVtepMgr()
 
 	    // starting bgp policy engine...
 	    logger.Info(fmt.Sprintln("Starting BGP policy engine..."))
@@ -93,7 +94,7 @@ func main() {
 	    go bgpPolicyEng.StartPolicyEngine()
 
 		bgpServer := server.NewBGPServer(logger, bgpPolicyEng, iMgr,
-			rMgr, bMgr)
+			rMgr, bMgr, vMgr)
 		go bgpServer.StartServer()
 
 		logger.Info(fmt.Sprintln("Starting config listener..."))
@@ -128,6 +129,10 @@ func main() {
 		if err != nil {
 			return
 		}
+		vMgr, err := FSMgr.NewFSVtepMgr(logger, fileName)
+		if err != nil {
+			return
+		}
 		pMgr := FSMgr.NewFSPolicyMgr(logger, fileName)
 		if err != nil {
 			return
@@ -140,7 +145,7 @@ func main() {
 		logger.Info(fmt.Sprintln("Starting BGP Server..."))
 
 		bgpServer := server.NewBGPServer(logger, bgpPolicyEng, iMgr,
-			rMgr, bMgr)
+			rMgr, bMgr, vMgr)
 		go bgpServer.StartServer()
 
 		// Start keepalive routine
@@ -150,6 +155,7 @@ func main() {
 		confIface := rpc.NewBGPHandler(bgpServer, bgpPolicyEng, logger, dbUtil, fileName)
 		dbUtil.Disconnect()
 
+		go rpc.StartConfigServer(logger, confIface, fileName)
 		rpc.StartServer(logger, confIface, fileName)
 	}
 }
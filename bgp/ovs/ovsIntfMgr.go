@@ -25,6 +25,7 @@ package ovsMgr
 
 import (
 	"l3/bgp/config"
+	"net"
 )
 
 /*  Constructor for interface manager
@@ -53,6 +54,14 @@ func (mgr *OvsIntfMgr) GetIfIndex(ifIndex, ifType int) int32 {
 	return 1
 }
 
+func (mgr *OvsIntfMgr) GetIntfName(ifIndex int32) (string, error) {
+	intf, err := net.InterfaceByIndex(int(ifIndex))
+	if err != nil {
+		return "", err
+	}
+	return intf.Name, nil
+}
+
 func (mgr *OvsIntfMgr) PortStateChange() {
 
 }
@@ -38,3 +38,7 @@ type OvsPolicyMgr struct {
 type OvsBfdMgr struct {
 	plugin string
 }
+
+type OvsVtepMgr struct {
+	plugin string
+}
@@ -63,3 +63,7 @@ func (mgr *OvsRouteMgr) ApplyPolicy(protocol string,policy string,action string,
 func (mgr *OvsRouteMgr) GetRoutes() ([]*config.RouteInfo, []*config.RouteInfo) {
 	return nil, nil
 }
+
+func (mgr *OvsRouteMgr) GetInstalledRoutes() []*config.RouteConfig {
+	return nil
+}
@@ -0,0 +1,58 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+package ovsMgr
+
+import (
+	"l3/bgp/config"
+)
+
+/*  Constructor for vtep manager
+ */
+func NewOvsVtepMgr() *OvsVtepMgr {
+	mgr := &OvsVtepMgr{
+		plugin: "ovsdb",
+	}
+
+	return mgr
+}
+
+func (mgr *OvsVtepMgr) Start() {
+
+}
+
+func (mgr *OvsVtepMgr) CreateVtep(vtep *config.VtepInfo) (bool, error) {
+	return true, nil
+}
+
+func (mgr *OvsVtepMgr) DeleteVtep(vtep *config.VtepInfo) (bool, error) {
+	return true, nil
+}
+
+func (mgr *OvsVtepMgr) AddRemoteMac(mac *config.EvpnMacInfo) (bool, error) {
+	return true, nil
+}
+
+func (mgr *OvsVtepMgr) RemoveRemoteMac(mac *config.EvpnMacInfo) (bool, error) {
+	return true, nil
+}
@@ -25,7 +25,9 @@
 package packet
 
 import (
+	"fmt"
 	"l3/bgp/config"
+	"l3/bgp/utils"
 )
 
 type AFI uint16
@@ -36,16 +38,29 @@ const (
 	AfiIP6
 )
 
+const AfiL2VPN AFI = 25
+
 const (
 	SafiUnicast SAFI = iota + 1
 	SafiMulticast
 )
 
+const (
+	SafiLabeledUnicast SAFI = 4
+	SafiEVPN           SAFI = 70
+	SafiMPLSVPN        SAFI = 128
+)
+
 var ProtocolFamilyMap = map[string]uint32{
-	"ipv4-unicast":   GetProtocolFamily(AfiIP, SafiUnicast),
-	"ipv6-unicast":   GetProtocolFamily(AfiIP6, SafiUnicast),
-	"ipv4-multicast": GetProtocolFamily(AfiIP, SafiMulticast),
-	"ipv6-multicast": GetProtocolFamily(AfiIP6, SafiMulticast),
+	"ipv4-unicast":          GetProtocolFamily(AfiIP, SafiUnicast),
+	"ipv6-unicast":          GetProtocolFamily(AfiIP6, SafiUnicast),
+	"ipv4-multicast":        GetProtocolFamily(AfiIP, SafiMulticast),
+	"ipv6-multicast":        GetProtocolFamily(AfiIP6, SafiMulticast),
+	"ipv4-labelled-unicast": GetProtocolFamily(AfiIP, SafiLabeledUnicast),
+	"ipv6-labelled-unicast": GetProtocolFamily(AfiIP6, SafiLabeledUnicast),
+	"l3vpn-ipv4-unicast":    GetProtocolFamily(AfiIP, SafiMPLSVPN),
+	"l3vpn-ipv6-unicast":    GetProtocolFamily(AfiIP6, SafiMPLSVPN),
+	"l2vpn-evpn":            GetProtocolFamily(AfiL2VPN, SafiEVPN),
 }
 
 func GetProtocolFromConfig(afiSafis *[]config.AfiSafiConfig) (map[uint32]bool, bool) {
@@ -66,10 +81,112 @@ func GetProtocolFromConfig(afiSafis *[]config.AfiSafiConfig) (map[uint32]bool, b
 	return afiSafiMap, rv
 }
 
+func GetPrefixLimitsFromConfig(afiSafis *[]config.AfiSafiConfig) map[uint32]config.PrefixLimit {
+	prefixLimits := make(map[uint32]config.PrefixLimit)
+	for _, afiSafi := range *afiSafis {
+		switch afiSafi.AfiSafiName {
+		case "ipv4-unicast":
+			prefixLimits[ProtocolFamilyMap[afiSafi.AfiSafiName]] = afiSafi.IPv4Unicast.PrefixLimit
+		case "ipv6-unicast":
+			prefixLimits[ProtocolFamilyMap[afiSafi.AfiSafiName]] = afiSafi.IPv6Unicast.PrefixLimit
+		case "ipv4-labelled-unicast":
+			prefixLimits[ProtocolFamilyMap[afiSafi.AfiSafiName]] = afiSafi.IPv4LabelledUnicast.PrefixLimit
+		case "ipv6-labelled-unicast":
+			prefixLimits[ProtocolFamilyMap[afiSafi.AfiSafiName]] = afiSafi.IPv6LabelledUnicast.PrefixLimit
+		case "l3vpn-ipv4-unicast":
+			prefixLimits[ProtocolFamilyMap[afiSafi.AfiSafiName]] = afiSafi.L3VPNIPv4Unicast.PrefixLimit
+		case "l3vpn-ipv6-unicast":
+			prefixLimits[ProtocolFamilyMap[afiSafi.AfiSafiName]] = afiSafi.L3VPNIPv6Unicast.PrefixLimit
+		case "l2vpn-evpn":
+			prefixLimits[ProtocolFamilyMap[afiSafi.AfiSafiName]] = afiSafi.L2VPNEVPN.PrefixLimit
+		}
+	}
+	return prefixLimits
+}
+
+// GetDefaultRouteFamiliesFromConfig returns the families that advertise the default route to the neighbor
+func GetDefaultRouteFamiliesFromConfig(afiSafis *[]config.AfiSafiConfig) map[uint32]bool {
+	families := make(map[uint32]bool)
+	for _, afiSafi := range *afiSafis {
+		switch afiSafi.AfiSafiName {
+		case "ipv4-unicast":
+			if afiSafi.IPv4Unicast.SendDefaultRoute {
+				families[ProtocolFamilyMap[afiSafi.AfiSafiName]] = true
+			}
+		case "ipv6-unicast":
+			if afiSafi.IPv6Unicast.SendDefaultRoute {
+				families[ProtocolFamilyMap[afiSafi.AfiSafiName]] = true
+			}
+		}
+	}
+	return families
+}
+
+func isAddPathsTxMode(txMode string) bool {
+	switch txMode {
+	case config.AddPathsTxModeAll, config.AddPathsTxModeBestN, config.AddPathsTxModeECMP,
+		config.AddPathsTxModeBestExternal, config.AddPathsTxModeDiversePath:
+		return true
+	}
+	return false
+}
+
+// GetAddPathsTxModesFromConfig returns the add paths transmit mode of the add paths families.
+// The families that don't set a mode use the mode of the neighbor, best-n by default.
+func GetAddPathsTxModesFromConfig(txMode string, afiSafis *[]config.AfiSafiConfig) map[uint32]string {
+	if !isAddPathsTxMode(txMode) {
+		if txMode != "" {
+			utils.Logger.Err(fmt.Sprintf("Add paths transmit mode %s is not valid, use %s", txMode,
+				config.AddPathsTxModeBestN))
+		}
+		txMode = config.AddPathsTxModeBestN
+	}
+
+	txModes := map[uint32]string{
+		GetProtocolFamily(AfiIP, SafiUnicast):  txMode,
+		GetProtocolFamily(AfiIP6, SafiUnicast): txMode,
+	}
+	for _, afiSafi := range *afiSafis {
+		protoFamily, ok := ProtocolFamilyMap[afiSafi.AfiSafiName]
+		if !ok || afiSafi.AddPathsTxMode == "" {
+			continue
+		}
+
+		afi, safi := GetAfiSafi(protoFamily)
+		if !IsAddPathsFamily(afi, safi) {
+			utils.Logger.Err(fmt.Sprintf("Add paths are not supported for family %s", afiSafi.AfiSafiName))
+			continue
+		}
+		if !isAddPathsTxMode(afiSafi.AddPathsTxMode) {
+			utils.Logger.Err(fmt.Sprintf("Add paths transmit mode %s of family %s is not valid",
+				afiSafi.AddPathsTxMode, afiSafi.AfiSafiName))
+			continue
+		}
+		txModes[protoFamily] = afiSafi.AddPathsTxMode
+	}
+	return txModes
+}
+
 func GetProtocolFamily(afi AFI, safi SAFI) uint32 {
 	return uint32(afi<<8) | uint32(safi)
 }
 
+func GetNLRIProtocolFamily(nlri NLRI) uint32 {
+	if _, ok := nlri.(*EVPNNLRI); ok {
+		return GetProtocolFamily(AfiL2VPN, SafiEVPN)
+	}
+
+	afi := nlri.GetPrefix().GetAFI()
+
+	switch nlri.(type) {
+	case *LabeledPrefix:
+		return GetProtocolFamily(afi, SafiLabeledUnicast)
+	case *VPNPrefix:
+		return GetProtocolFamily(afi, SafiMPLSVPN)
+	}
+	return GetProtocolFamily(afi, SafiUnicast)
+}
+
 func GetAfiSafi(protocolFamily uint32) (AFI, SAFI) {
 	return AFI(protocolFamily >> 8), SAFI(protocolFamily & 0xFF)
 }
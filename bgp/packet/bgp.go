@@ -51,9 +51,19 @@ func NewBGPPktSrc(src string, msg *BGPMessage) *BGPPktSrc {
 }
 
 type BGPPeerAttrs struct {
-	ASSize           uint8
-	AddPathFamily    map[AFI]map[SAFI]uint8
-	AddPathsRxActual bool
+	ASSize             uint8
+	AddPathFamily      map[AFI]map[SAFI]uint8
+	AddPathsRxActual   bool
+	AddPathsRxFamilies map[uint32]bool
+}
+
+// IsAddPathsRx returns true when the NLRIs of the family are received with path ids. The add
+// paths receive of IPv4 is set in AddPathsRxActual, the other families in AddPathsRxFamilies.
+func (p BGPPeerAttrs) IsAddPathsRx(afi AFI, safi SAFI) bool {
+	if afi == AfiIP && (safi == SafiUnicast || safi == SafiMulticast) {
+		return p.AddPathsRxActual
+	}
+	return p.AddPathsRxFamilies[GetProtocolFamily(afi, safi)]
 }
 
 const BGPASTrans uint16 = 23456
@@ -66,6 +76,7 @@ const (
 	BGPMsgTypeUpdate
 	BGPMsgTypeNotification
 	BGPMsgTypeKeepAlive
+	BGPMsgTypeRouteRefresh
 )
 
 const (
@@ -84,6 +95,7 @@ const (
 	BGPHoldTimerExpired
 	BGPFSMError
 	BGPCease
+	BGPRouteRefreshMsgError
 )
 
 const (
@@ -104,6 +116,23 @@ const (
 	BGPUnsupportedCapability
 )
 
+const (
+	_ uint8 = iota
+	BGPRouteRefreshInvalidMsgLen
+)
+
+const (
+	_ uint8 = iota
+	BGPCeaseMaxPrefixesReached
+	BGPCeaseAdminShutdown
+	BGPCeasePeerDeconfigured
+	BGPCeaseAdminReset
+	BGPCeaseConnRejected
+	BGPCeaseOtherConfigChange
+	BGPCeaseConnCollisionResolution
+	BGPCeaseOutOfResources
+)
+
 const (
 	_ uint8 = iota
 	BGPMalformedAttrList
@@ -136,14 +165,24 @@ type BGPCapabilityType uint8
 const (
 	_ BGPCapabilityType = iota
 	BGPCapTypeMPExt
-	BGPCapTypeAS4Path BGPCapabilityType = 65
-	BGPCapTypeAddPath BGPCapabilityType = 69
+	BGPCapTypeRouteRefresh
+	BGPCapTypeOutboundRouteFilter
+	BGPCapTypeExtendedNextHop      BGPCapabilityType = 5
+	BGPCapTypeGracefulRestart      BGPCapabilityType = 64
+	BGPCapTypeAS4Path              BGPCapabilityType = 65
+	BGPCapTypeAddPath              BGPCapabilityType = 69
+	BGPCapTypeEnhancedRouteRefresh BGPCapabilityType = 70
 )
 
 var BGPCapTypeToStruct = map[BGPCapabilityType]BGPCapability{
-	BGPCapTypeMPExt:   &BGPCapMPExt{},
-	BGPCapTypeAS4Path: &BGPCapAS4Path{},
-	BGPCapTypeAddPath: &BGPCapAddPath{},
+	BGPCapTypeMPExt:                &BGPCapMPExt{},
+	BGPCapTypeRouteRefresh:         &BGPCapRouteRefresh{},
+	BGPCapTypeOutboundRouteFilter:  &BGPCapORF{},
+	BGPCapTypeExtendedNextHop:      &BGPCapExtendedNextHop{},
+	BGPCapTypeGracefulRestart:      &BGPCapGracefulRestart{},
+	BGPCapTypeAS4Path:              &BGPCapAS4Path{},
+	BGPCapTypeAddPath:              &BGPCapAddPath{},
+	BGPCapTypeEnhancedRouteRefresh: &BGPCapEnhancedRouteRefresh{},
 }
 
 const (
@@ -151,6 +190,12 @@ const (
 	BGPCapAddPathTx
 )
 
+const (
+	BGPCapGracefulRestartFlagRestart    uint8  = 0x8
+	BGPCapGracefulRestartFlagForwarding uint8  = 0x80
+	BGPCapGracefulRestartMaxTime        uint16 = 0xFFF
+)
+
 type BGPPathAttrFlag uint8
 
 const (
@@ -175,7 +220,7 @@ const (
 	BGPPathAttrTypeLocalPref
 	BGPPathAttrTypeAtomicAggregate
 	BGPPathAttrTypeAggregator
-	_
+	BGPPathAttrTypeCommunities
 	BGPPathAttrTypeOriginatorId
 	BGPPathAttrTypeClusterList
 	_
@@ -183,12 +228,14 @@ const (
 	_
 	BGPPathAttrTypeMPReachNLRI
 	BGPPathAttrTypeMPUnreachNLRI
-	_
+	BGPPathAttrTypeExtCommunities
 	BGPPathAttrTypeAS4Path
 	BGPPathAttrTypeAS4Aggregator
 	BGPPathAttrTypeUnknown
 )
 
+const BGPPathAttrTypeLargeCommunities BGPPathAttrType = 32
+
 type BGPPathAttrOriginType uint8
 
 const (
@@ -203,26 +250,71 @@ type BGPASPathSegmentType uint8
 const (
 	BGPASPathSegmentSet BGPASPathSegmentType = iota + 1
 	BGPASPathSegmentSequence
+	BGPASPathSegmentConfedSequence
+	BGPASPathSegmentConfedSet
 	BGPASPathSegmentUnknown
 )
 
+// IsConfed returns true for the AS_CONFED_SEQUENCE and AS_CONFED_SET segment types (RFC 5065)
+func (t BGPASPathSegmentType) IsConfed() bool {
+	return t == BGPASPathSegmentConfedSequence || t == BGPASPathSegmentConfedSet
+}
+
+const (
+	BGPCommunityGracefulShutdown  uint32 = 0xFFFF0000
+	BGPCommunityAcceptOwn         uint32 = 0xFFFF0001
+	BGPCommunityNoExport          uint32 = 0xFFFFFF01
+	BGPCommunityNoAdvertise       uint32 = 0xFFFFFF02
+	BGPCommunityNoExportSubconfed uint32 = 0xFFFFFF03
+)
+
+var BGPWellKnownCommunityToStr = map[uint32]string{
+	BGPCommunityGracefulShutdown:  "graceful-shutdown",
+	BGPCommunityAcceptOwn:         "accept-own",
+	BGPCommunityNoExport:          "no-export",
+	BGPCommunityNoAdvertise:       "no-advertise",
+	BGPCommunityNoExportSubconfed: "no-export-subconfed",
+}
+
+const (
+	BGPExtCommunityTypeTwoOctetAS  uint8 = 0x00
+	BGPExtCommunityTypeIPv4Addr    uint8 = 0x01
+	BGPExtCommunityTypeFourOctetAS uint8 = 0x02
+	BGPExtCommunityTypeOpaque      uint8 = 0x03
+	BGPExtCommunityNonTransitive   uint8 = 0x40
+)
+
+const (
+	BGPExtCommunitySubTypeRouteTarget uint8 = 0x02
+	BGPExtCommunitySubTypeRouteOrigin uint8 = 0x03
+)
+
+var BGPExtCommunitySubTypeToStr = map[uint8]string{
+	BGPExtCommunitySubTypeRouteTarget: "rt",
+	BGPExtCommunitySubTypeRouteOrigin: "ro",
+}
+
 var BGPPathAttrWellKnownMandatory = []BGPPathAttrType{
 	BGPPathAttrTypeOrigin, BGPPathAttrTypeASPath, BGPPathAttrTypeNextHop}
 
 var BGPPathAttrTypeToStructMap = map[BGPPathAttrType]BGPPathAttr{
-	BGPPathAttrTypeOrigin:          &BGPPathAttrOrigin{},
-	BGPPathAttrTypeASPath:          &BGPPathAttrASPath{},
-	BGPPathAttrTypeNextHop:         &BGPPathAttrNextHop{},
-	BGPPathAttrTypeMultiExitDisc:   &BGPPathAttrMultiExitDisc{},
-	BGPPathAttrTypeLocalPref:       &BGPPathAttrLocalPref{},
-	BGPPathAttrTypeAtomicAggregate: &BGPPathAttrAtomicAggregate{},
-	BGPPathAttrTypeAggregator:      &BGPPathAttrAggregator{},
-	BGPPathAttrTypeOriginatorId:    &BGPPathAttrOriginatorId{},
-	BGPPathAttrTypeClusterList:     &BGPPathAttrClusterList{},
-	BGPPathAttrTypeMPReachNLRI:     &BGPPathAttrMPReachNLRI{},
-	BGPPathAttrTypeMPUnreachNLRI:   &BGPPathAttrMPUnreachNLRI{},
-	BGPPathAttrTypeAS4Path:         &BGPPathAttrAS4Path{},
-	BGPPathAttrTypeAS4Aggregator:   &BGPPathAttrAS4Aggregator{},
+	BGPPathAttrTypeOrigin:           &BGPPathAttrOrigin{},
+	BGPPathAttrTypeASPath:           &BGPPathAttrASPath{},
+	BGPPathAttrTypeNextHop:          &BGPPathAttrNextHop{},
+	BGPPathAttrTypeMultiExitDisc:    &BGPPathAttrMultiExitDisc{},
+	BGPPathAttrTypeLocalPref:        &BGPPathAttrLocalPref{},
+	BGPPathAttrTypeAtomicAggregate:  &BGPPathAttrAtomicAggregate{},
+	BGPPathAttrTypeAggregator:       &BGPPathAttrAggregator{},
+	BGPPathAttrTypeCommunities:      &BGPPathAttrCommunities{},
+	BGPPathAttrTypeOriginatorId:     &BGPPathAttrOriginatorId{},
+	BGPPathAttrTypeClusterList:      &BGPPathAttrClusterList{},
+	BGPPathAttrTypeMPReachNLRI:      &BGPPathAttrMPReachNLRI{},
+	BGPPathAttrTypeMPUnreachNLRI:    &BGPPathAttrMPUnreachNLRI{},
+	BGPPathAttrTypeExtCommunities:   &BGPPathAttrExtCommunities{},
+	BGPPathAttrTypeAS4Path:          &BGPPathAttrAS4Path{},
+	BGPPathAttrTypeAS4Aggregator:    &BGPPathAttrAS4Aggregator{},
+	BGPPathAttrTypeLargeCommunities: &BGPPathAttrLargeCommunities{},
+	BGPPathAttrTypePMSITunnel:       &BGPPathAttrPMSITunnel{},
 }
 
 var BGPPathAttrTypeFlagsMap = map[BGPPathAttrType][]BGPPathAttrFlag{
@@ -233,12 +325,18 @@ var BGPPathAttrTypeFlagsMap = map[BGPPathAttrType][]BGPPathAttrFlag{
 	BGPPathAttrTypeLocalPref:       []BGPPathAttrFlag{BGPPathAttrFlagTransitive, BGPPathAttrFlagAllMinusExtendedLen},
 	BGPPathAttrTypeAtomicAggregate: []BGPPathAttrFlag{BGPPathAttrFlagTransitive, BGPPathAttrFlagAllMinusExtendedLen},
 	BGPPathAttrTypeAggregator:      []BGPPathAttrFlag{BGPPathAttrFlagOptional & BGPPathAttrFlagTransitive, BGPPathAttrFlagAllMinusExtendedLen},
+	BGPPathAttrTypeCommunities:     []BGPPathAttrFlag{BGPPathAttrFlagOptional | BGPPathAttrFlagTransitive, BGPPathAttrFlagAllMinusExtendedLen},
 	BGPPathAttrTypeOriginatorId:    []BGPPathAttrFlag{BGPPathAttrFlagOptional, BGPPathAttrFlagAllMinusExtendedLen},
 	BGPPathAttrTypeClusterList:     []BGPPathAttrFlag{BGPPathAttrFlagOptional, BGPPathAttrFlagAllMinusExtendedLen},
 	BGPPathAttrTypeMPReachNLRI:     []BGPPathAttrFlag{BGPPathAttrFlagOptional, BGPPathAttrFlagAllMinusExtendedLen},
 	BGPPathAttrTypeMPUnreachNLRI:   []BGPPathAttrFlag{BGPPathAttrFlagOptional, BGPPathAttrFlagAllMinusExtendedLen},
 	BGPPathAttrTypeAS4Path:         []BGPPathAttrFlag{BGPPathAttrFlagOptional & BGPPathAttrFlagTransitive, BGPPathAttrFlagAllMinusExtendedLen},
 	BGPPathAttrTypeAS4Aggregator:   []BGPPathAttrFlag{BGPPathAttrFlagOptional & BGPPathAttrFlagTransitive, BGPPathAttrFlagAllMinusExtendedLen},
+	BGPPathAttrTypeExtCommunities:  []BGPPathAttrFlag{BGPPathAttrFlagOptional | BGPPathAttrFlagTransitive, BGPPathAttrFlagAllMinusExtendedLen},
+	BGPPathAttrTypeLargeCommunities: []BGPPathAttrFlag{BGPPathAttrFlagOptional | BGPPathAttrFlagTransitive,
+		BGPPathAttrFlagAllMinusExtendedLen},
+	BGPPathAttrTypePMSITunnel: []BGPPathAttrFlag{BGPPathAttrFlagOptional | BGPPathAttrFlagTransitive,
+		BGPPathAttrFlagAllMinusExtendedLen},
 }
 
 var BGPPathAttrTypeLenMap = map[BGPPathAttrType]uint16{
@@ -392,6 +490,40 @@ func NewBGPCapMPExt(afi AFI, safi SAFI) *BGPCapMPExt {
 	}
 }
 
+type BGPCapRouteRefresh struct {
+	BGPCapabilityBase
+}
+
+func (msg *BGPCapRouteRefresh) New() BGPCapability {
+	return &BGPCapRouteRefresh{}
+}
+
+func NewBGPCapRouteRefresh() *BGPCapRouteRefresh {
+	return &BGPCapRouteRefresh{
+		BGPCapabilityBase: BGPCapabilityBase{
+			Type: BGPCapTypeRouteRefresh,
+			Len:  0,
+		},
+	}
+}
+
+type BGPCapEnhancedRouteRefresh struct {
+	BGPCapabilityBase
+}
+
+func (msg *BGPCapEnhancedRouteRefresh) New() BGPCapability {
+	return &BGPCapEnhancedRouteRefresh{}
+}
+
+func NewBGPCapEnhancedRouteRefresh() *BGPCapEnhancedRouteRefresh {
+	return &BGPCapEnhancedRouteRefresh{
+		BGPCapabilityBase: BGPCapabilityBase{
+			Type: BGPCapTypeEnhancedRouteRefresh,
+			Len:  0,
+		},
+	}
+}
+
 type BGPCapAS4Path struct {
 	BGPCapabilityBase
 	Value uint32
@@ -431,6 +563,232 @@ func NewBGPCap4ByteASPath(as uint32) *BGPCapAS4Path {
 	}
 }
 
+type GracefulRestartAFISAFI struct {
+	AFI   AFI
+	SAFI  SAFI
+	Flags uint8
+}
+
+func (g *GracefulRestartAFISAFI) Encode(pkt []byte) error {
+	binary.BigEndian.PutUint16(pkt, uint16(g.AFI))
+	pkt[2] = uint8(g.SAFI)
+	pkt[3] = g.Flags
+	return nil
+}
+
+func (g *GracefulRestartAFISAFI) Decode(pkt []byte) error {
+	if len(pkt) < 4 {
+		return BGPMessageError{BGPOpenMsgError, BGPUnspecific, nil, "Not enough data to decode graceful restart capability"}
+	}
+
+	g.AFI = AFI(binary.BigEndian.Uint16(pkt))
+	g.SAFI = SAFI(pkt[2])
+	g.Flags = pkt[3]
+	return nil
+}
+
+func (g *GracefulRestartAFISAFI) Len() uint8 {
+	return 4
+}
+
+func NewGracefulRestartAFISAFI(afi AFI, safi SAFI, flags uint8) *GracefulRestartAFISAFI {
+	return &GracefulRestartAFISAFI{
+		AFI:   afi,
+		SAFI:  safi,
+		Flags: flags,
+	}
+}
+
+type BGPCapGracefulRestart struct {
+	BGPCapabilityBase
+	RestartFlags uint8
+	RestartTime  uint16
+	Value        []GracefulRestartAFISAFI
+}
+
+func (msg *BGPCapGracefulRestart) New() BGPCapability {
+	return &BGPCapGracefulRestart{}
+}
+
+func (msg *BGPCapGracefulRestart) Encode() ([]byte, error) {
+	pkt, err := msg.BGPCapabilityBase.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	binary.BigEndian.PutUint16(pkt[2:], uint16(msg.RestartFlags&0xF)<<12|
+		(msg.RestartTime&BGPCapGracefulRestartMaxTime))
+	offset := uint8(4)
+	for _, val := range msg.Value {
+		val.Encode(pkt[offset:])
+		offset += val.Len()
+	}
+	return pkt, nil
+}
+
+func (msg *BGPCapGracefulRestart) Decode(pkt []byte) error {
+	err := msg.BGPCapabilityBase.Decode(pkt)
+	if err != nil {
+		return err
+	}
+
+	if msg.Len < 2 {
+		return BGPMessageError{BGPOpenMsgError, BGPUnspecific, nil, "Not enough data to decode graceful restart capability"}
+	}
+
+	restartFlagsAndTime := binary.BigEndian.Uint16(pkt[2:])
+	msg.RestartFlags = uint8(restartFlagsAndTime >> 12)
+	msg.RestartTime = restartFlagsAndTime & BGPCapGracefulRestartMaxTime
+
+	msg.Value = make([]GracefulRestartAFISAFI, 0)
+	offset := uint16(4)
+	for offset < msg.TotalLen() {
+		grAFISAFI := GracefulRestartAFISAFI{}
+		err := grAFISAFI.Decode(pkt[offset:msg.TotalLen()])
+		if err != nil {
+			return err
+		}
+		msg.Value = append(msg.Value, grAFISAFI)
+		offset += uint16(grAFISAFI.Len())
+	}
+	return nil
+}
+
+func (msg *BGPCapGracefulRestart) AddGracefulRestartAFISAFI(grAFISAFI *GracefulRestartAFISAFI) {
+	msg.Value = append(msg.Value, *grAFISAFI)
+	msg.Len += grAFISAFI.Len()
+}
+
+func (msg *BGPCapGracefulRestart) IsRestarting() bool {
+	return msg.RestartFlags&BGPCapGracefulRestartFlagRestart != 0
+}
+
+func (msg *BGPCapGracefulRestart) IsForwardingPreserved(afi AFI, safi SAFI) bool {
+	for _, val := range msg.Value {
+		if val.AFI == afi && val.SAFI == safi {
+			return val.Flags&BGPCapGracefulRestartFlagForwarding != 0
+		}
+	}
+	return false
+}
+
+func NewBGPCapGracefulRestart(restarting bool, restartTime uint16) *BGPCapGracefulRestart {
+	restartFlags := uint8(0)
+	if restarting {
+		restartFlags |= BGPCapGracefulRestartFlagRestart
+	}
+	if restartTime > BGPCapGracefulRestartMaxTime {
+		restartTime = BGPCapGracefulRestartMaxTime
+	}
+
+	return &BGPCapGracefulRestart{
+		BGPCapabilityBase: BGPCapabilityBase{
+			Type: BGPCapTypeGracefulRestart,
+			Len:  2,
+		},
+		RestartFlags: restartFlags,
+		RestartTime:  restartTime,
+		Value:        make([]GracefulRestartAFISAFI, 0),
+	}
+}
+
+type ExtendedNextHopAFISAFI struct {
+	AFI        AFI
+	SAFI       SAFI
+	NextHopAFI AFI
+}
+
+func (e *ExtendedNextHopAFISAFI) Encode(pkt []byte) error {
+	binary.BigEndian.PutUint16(pkt, uint16(e.AFI))
+	binary.BigEndian.PutUint16(pkt[2:], uint16(e.SAFI))
+	binary.BigEndian.PutUint16(pkt[4:], uint16(e.NextHopAFI))
+	return nil
+}
+
+func (e *ExtendedNextHopAFISAFI) Decode(pkt []byte) error {
+	if len(pkt) < 6 {
+		return BGPMessageError{BGPOpenMsgError, BGPUnspecific, nil,
+			"Not enough data to decode Extended next hop capability"}
+	}
+
+	e.AFI = AFI(binary.BigEndian.Uint16(pkt))
+	e.SAFI = SAFI(binary.BigEndian.Uint16(pkt[2:]))
+	e.NextHopAFI = AFI(binary.BigEndian.Uint16(pkt[4:]))
+	return nil
+}
+
+func (e *ExtendedNextHopAFISAFI) Len() uint8 {
+	return 6
+}
+
+type BGPCapExtendedNextHop struct {
+	BGPCapabilityBase
+	Value []ExtendedNextHopAFISAFI
+}
+
+func (msg *BGPCapExtendedNextHop) New() BGPCapability {
+	return &BGPCapExtendedNextHop{}
+}
+
+func (msg *BGPCapExtendedNextHop) Encode() ([]byte, error) {
+	pkt, err := msg.BGPCapabilityBase.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	offset := uint8(2)
+	for _, val := range msg.Value {
+		val.Encode(pkt[offset:])
+		offset += val.Len()
+	}
+	return pkt, nil
+}
+
+func (msg *BGPCapExtendedNextHop) Decode(pkt []byte) error {
+	err := msg.BGPCapabilityBase.Decode(pkt)
+	if err != nil {
+		return err
+	}
+
+	msg.Value = make([]ExtendedNextHopAFISAFI, 0)
+	offset := uint16(2)
+	for offset < msg.TotalLen() {
+		extNextHop := ExtendedNextHopAFISAFI{}
+		err := extNextHop.Decode(pkt[offset:msg.TotalLen()])
+		if err != nil {
+			return err
+		}
+		msg.Value = append(msg.Value, extNextHop)
+		offset += uint16(extNextHop.Len())
+	}
+	return nil
+}
+
+func (msg *BGPCapExtendedNextHop) AddExtendedNextHopAFISAFI(afi AFI, safi SAFI, nextHopAFI AFI) {
+	extNextHop := ExtendedNextHopAFISAFI{afi, safi, nextHopAFI}
+	msg.Value = append(msg.Value, extNextHop)
+	msg.Len += extNextHop.Len()
+}
+
+func (msg *BGPCapExtendedNextHop) IsNextHopAFISupported(afi AFI, safi SAFI, nextHopAFI AFI) bool {
+	for _, val := range msg.Value {
+		if val.AFI == afi && val.SAFI == safi && val.NextHopAFI == nextHopAFI {
+			return true
+		}
+	}
+	return false
+}
+
+func NewBGPCapExtendedNextHop() *BGPCapExtendedNextHop {
+	return &BGPCapExtendedNextHop{
+		BGPCapabilityBase: BGPCapabilityBase{
+			Type: BGPCapTypeExtendedNextHop,
+			Len:  0,
+		},
+		Value: make([]ExtendedNextHopAFISAFI, 0),
+	}
+}
+
 type AddPathAFISAFI struct {
 	AFI   AFI
 	SAFI  SAFI
@@ -854,6 +1212,101 @@ func NewBGPNotificationMessage(errorCode uint8, errorSubCode uint8, data []byte)
 	}
 }
 
+const (
+	BGPRouteRefreshNormal uint8 = iota
+	BGPRouteRefreshBoRR
+	BGPRouteRefreshEoRR
+)
+
+/*  A normal ROUTE-REFRESH message carries the ORF entries after the When-to-refresh field
+ *  when it is not zero (RFC 5291). Only the address prefix ORF entries are kept.
+ */
+type BGPRouteRefresh struct {
+	AFI           AFI
+	SubType       uint8
+	SAFI          SAFI
+	WhenToRefresh uint8
+	PrefixORF     []*AddressPrefixORFEntry
+}
+
+func (msg *BGPRouteRefresh) Clone() BGPBody {
+	x := *msg
+	if msg.PrefixORF != nil {
+		x.PrefixORF = make([]*AddressPrefixORFEntry, 0, len(msg.PrefixORF))
+		for _, entry := range msg.PrefixORF {
+			orfEntry := *entry
+			x.PrefixORF = append(x.PrefixORF, &orfEntry)
+		}
+	}
+	return &x
+}
+
+func (msg *BGPRouteRefresh) Encode() ([]byte, error) {
+	pkt := make([]byte, 4)
+	binary.BigEndian.PutUint16(pkt, uint16(msg.AFI))
+	pkt[2] = msg.SubType
+	pkt[3] = uint8(msg.SAFI)
+	if msg.SubType != BGPRouteRefreshNormal || msg.WhenToRefresh == 0 {
+		return pkt, nil
+	}
+
+	pkt = append(pkt, msg.WhenToRefresh)
+	if len(msg.PrefixORF) > 0 {
+		orfPkt, err := encodeAddressPrefixORF(msg.PrefixORF)
+		if err != nil {
+			return nil, err
+		}
+		pkt = append(pkt, orfPkt...)
+	}
+	return pkt, nil
+}
+
+func (msg *BGPRouteRefresh) Decode(header *BGPHeader, pkt []byte, data interface{}) error {
+	if len(pkt) < 4 {
+		return BGPMessageError{BGPRouteRefreshMsgError, BGPRouteRefreshInvalidMsgLen, pkt,
+			"Not enough data to decode route refresh message"}
+	}
+
+	msg.AFI = AFI(binary.BigEndian.Uint16(pkt))
+	msg.SubType = pkt[2]
+	msg.SAFI = SAFI(pkt[3])
+	if msg.SubType != BGPRouteRefreshNormal && len(pkt) != 4 {
+		return BGPMessageError{BGPRouteRefreshMsgError, BGPRouteRefreshInvalidMsgLen, pkt,
+			fmt.Sprintf("Route refresh message with subtype %d has length %d", msg.SubType, len(pkt))}
+	}
+
+	if len(pkt) > 4 {
+		msg.WhenToRefresh = pkt[4]
+		prefixORF, err := decodeAddressPrefixORF(pkt[5:], msg.AFI)
+		if err != nil {
+			return err
+		}
+		msg.PrefixORF = prefixORF
+	}
+	return nil
+}
+
+func NewBGPRouteRefreshMessage(afi AFI, safi SAFI, subType uint8) *BGPMessage {
+	return &BGPMessage{
+		Header: BGPHeader{Type: BGPMsgTypeRouteRefresh},
+		Body:   &BGPRouteRefresh{AFI: afi, SubType: subType, SAFI: safi},
+	}
+}
+
+func NewBGPRouteRefreshORFMessage(afi AFI, safi SAFI, whenToRefresh uint8,
+	prefixORF []*AddressPrefixORFEntry) *BGPMessage {
+	return &BGPMessage{
+		Header: BGPHeader{Type: BGPMsgTypeRouteRefresh},
+		Body: &BGPRouteRefresh{
+			AFI:           afi,
+			SubType:       BGPRouteRefreshNormal,
+			SAFI:          safi,
+			WhenToRefresh: whenToRefresh,
+			PrefixORF:     prefixORF,
+		},
+	}
+}
+
 type NLRI interface {
 	Clone() NLRI
 	Encode() ([]byte, error)
@@ -863,9 +1316,43 @@ type NLRI interface {
 	GetPathId() uint32
 }
 
+/*  The prefixes decoded from a message keep the AFI of the message, an IPv4-mapped IPv6
+ *  prefix can't be told from an IPv4 prefix by its address.
+ */
 type IPPrefix struct {
 	Length uint8
 	Prefix net.IP
+	afi    AFI
+}
+
+// GetAFI returns the AFI of the prefix, the prefixes without an AFI set are classified by their address
+func (ip *IPPrefix) GetAFI() AFI {
+	if ip.afi != 0 {
+		return ip.afi
+	}
+
+	if ip.Prefix.To4() != nil {
+		return AfiIP
+	}
+	return AfiIP6
+}
+
+func (ip *IPPrefix) SetAFI(afi AFI) {
+	ip.afi = afi
+}
+
+// getPrefixBytes returns the address of the prefix in the length of its AFI
+func (ip *IPPrefix) getPrefixBytes() ([]byte, error) {
+	prefix := ip.Prefix.To16()
+	if ip.GetAFI() == AfiIP {
+		prefix = ip.Prefix.To4()
+	}
+
+	if int(ip.Length+7)/8 > len(prefix) {
+		return nil, BGPMessageError{BGPUpdateMsgError, BGPInvalidNetworkField, nil,
+			fmt.Sprintf("Prefix length %d is invalid for prefix %s", ip.Length, ip.Prefix)}
+	}
+	return prefix, nil
 }
 
 func (ip *IPPrefix) Clone() NLRI {
@@ -876,29 +1363,47 @@ func (ip *IPPrefix) Clone() NLRI {
 }
 
 func (ip *IPPrefix) Encode() ([]byte, error) {
+	prefix, err := ip.getPrefixBytes()
+	if err != nil {
+		return nil, err
+	}
+
 	pkt := make([]byte, ip.Len())
 	pkt[0] = ip.Length
-	ipBytesStart := uint8(cap(ip.Prefix) - 4)
-	copy(pkt[1:], ip.Prefix[ipBytesStart:ipBytesStart+((ip.Length+7)/8)])
+	copy(pkt[1:], prefix[:(ip.Length+7)/8])
 	return pkt, nil
 }
 
 func (ip *IPPrefix) Decode(pkt []byte) error {
+	return ip.decodeIPPrefix(pkt, AfiIP)
+}
+
+func (ip *IPPrefix) decodeIPPrefix(pkt []byte, afi AFI) error {
 	if len(pkt) < 1 {
 		return BGPMessageError{BGPUpdateMsgError, BGPInvalidNetworkField, nil, "NLRI does not contain prefix lenght"}
 	}
 
+	ipLen := net.IPv4len
+	if afi == AfiIP6 {
+		ipLen = net.IPv6len
+	}
+
 	ip.Length = pkt[0]
-	if ip.Length > 32 {
-		return BGPMessageError{BGPUpdateMsgError, BGPInvalidNetworkField, nil, fmt.Sprintf("Prefix length is greater than 32, lenght:%d", ip.Length)}
+	if int(ip.Length) > ipLen*8 {
+		return BGPMessageError{BGPUpdateMsgError, BGPInvalidNetworkField, nil,
+			fmt.Sprintf("Prefix length is greater than %d, lenght:%d", ipLen*8, ip.Length)}
 	}
 
 	bytes := (ip.Length + 7) / 8
 	if len(pkt) < (int(bytes) + 1) {
 		return BGPMessageError{BGPUpdateMsgError, BGPInvalidNetworkField, nil, "Prefix length invalid"}
 	}
-	ip.Prefix = make(net.IP, 4)
+	ip.Prefix = make(net.IP, ipLen)
 	copy(ip.Prefix, pkt[1:bytes+1])
+	ip.afi = AfiIP
+	if afi == AfiIP6 {
+		ip.afi = AfiIP6
+	}
 	return nil
 }
 
@@ -949,13 +1454,17 @@ func (n *ExtNLRI) Encode() ([]byte, error) {
 }
 
 func (n *ExtNLRI) Decode(pkt []byte) error {
+	return n.decodeExtNLRI(pkt, AfiIP)
+}
+
+func (n *ExtNLRI) decodeExtNLRI(pkt []byte, afi AFI) error {
 	if len(pkt) < 5 {
 		return BGPMessageError{BGPUpdateMsgError, BGPInvalidNetworkField, nil, "NLRI does not contain path id or prefix lenght"}
 	}
 	n.PathId = binary.BigEndian.Uint32(pkt[:4])
 
 	n.IPPrefix = IPPrefix{}
-	err := n.IPPrefix.Decode(pkt[4:])
+	err := n.IPPrefix.decodeIPPrefix(pkt[4:], afi)
 	return err
 }
 
@@ -1150,6 +1659,7 @@ type BGPASPathSegment interface {
 	GetType() BGPASPathSegmentType
 	GetLen() uint8
 	GetNumASes() uint8
+	UpdateASes(update func(uint32) (uint32, bool))
 }
 
 type BGPASPathSegmentBase struct {
@@ -1267,6 +1777,23 @@ func (ps *BGPAS2PathSegment) AppendAS(as uint32) bool {
 	return true
 }
 
+// UpdateASes replaces the ASes of the segment with the AS returned by update and removes the
+// ASes for which update returns false
+func (ps *BGPAS2PathSegment) UpdateASes(update func(uint32) (uint32, bool)) {
+	ases := make([]uint16, 0, len(ps.AS))
+	for _, as := range ps.AS {
+		if newAS, ok := update(uint32(as)); ok {
+			if newAS > math.MaxUint16 {
+				newAS = uint32(BGPASTrans)
+			}
+			ases = append(ases, uint16(newAS))
+		}
+	}
+	ps.AS = ases
+	ps.Length = uint8(len(ases))
+	ps.BGPASPathSegmentLen = uint16(ps.Length)*2 + 2
+}
+
 func NewBGPAS2PathSegment(segType BGPASPathSegmentType) *BGPAS2PathSegment {
 	as := make([]uint16, 0)
 	return &BGPAS2PathSegment{
@@ -1382,6 +1909,20 @@ func (ps *BGPAS4PathSegment) AppendAS(as uint32) bool {
 	return true
 }
 
+// UpdateASes replaces the ASes of the segment with the AS returned by update and removes the
+// ASes for which update returns false
+func (ps *BGPAS4PathSegment) UpdateASes(update func(uint32) (uint32, bool)) {
+	ases := make([]uint32, 0, len(ps.AS))
+	for _, as := range ps.AS {
+		if newAS, ok := update(as); ok {
+			ases = append(ases, newAS)
+		}
+	}
+	ps.AS = ases
+	ps.Length = uint8(len(ases))
+	ps.BGPASPathSegmentLen = uint16(ps.Length)*4 + 2
+}
+
 func NewBGPAS4PathSegment(segType BGPASPathSegmentType) *BGPAS4PathSegment {
 	as := make([]uint32, 0)
 	return &BGPAS4PathSegment{
@@ -1495,8 +2036,36 @@ func (as *BGPPathAttrASPath) AppendASPathSegment(pathSeg BGPASPathSegment) {
 	as.BGPPathAttrBase.Length += pathSeg.TotalLen()
 }
 
-func (o *BGPPathAttrASPath) New() BGPPathAttr {
-	return &BGPPathAttrASPath{}
+// RemoveConfedSegments removes the AS_CONFED_SEQUENCE and AS_CONFED_SET segments from the AS path
+func (as *BGPPathAttrASPath) RemoveConfedSegments() {
+	segments := make([]BGPASPathSegment, 0, len(as.Value))
+	for _, seg := range as.Value {
+		if seg.GetType().IsConfed() {
+			as.BGPPathAttrBase.Length -= seg.TotalLen()
+			continue
+		}
+		segments = append(segments, seg)
+	}
+	as.Value = segments
+}
+
+// UpdateASes applies update to the ASes of all the segments and removes the segments left empty
+func (as *BGPPathAttrASPath) UpdateASes(update func(uint32) (uint32, bool)) {
+	segments := make([]BGPASPathSegment, 0, len(as.Value))
+	for _, seg := range as.Value {
+		as.BGPPathAttrBase.Length -= seg.TotalLen()
+		seg.UpdateASes(update)
+		if seg.GetLen() == 0 {
+			continue
+		}
+		as.BGPPathAttrBase.Length += seg.TotalLen()
+		segments = append(segments, seg)
+	}
+	as.Value = segments
+}
+
+func (o *BGPPathAttrASPath) New() BGPPathAttr {
+	return &BGPPathAttrASPath{}
 }
 
 func NewBGPPathAttrASPath() *BGPPathAttrASPath {
@@ -1580,6 +2149,21 @@ func (as *BGPPathAttrAS4Path) AddASPathSegment(pathSeg *BGPAS4PathSegment) {
 	as.BGPPathAttrBase.Length += pathSeg.TotalLen()
 }
 
+// UpdateASes applies update to the ASes of all the segments and removes the segments left empty
+func (as *BGPPathAttrAS4Path) UpdateASes(update func(uint32) (uint32, bool)) {
+	segments := make([]*BGPAS4PathSegment, 0, len(as.Value))
+	for _, seg := range as.Value {
+		as.BGPPathAttrBase.Length -= seg.TotalLen()
+		seg.UpdateASes(update)
+		if seg.GetLen() == 0 {
+			continue
+		}
+		as.BGPPathAttrBase.Length += seg.TotalLen()
+		segments = append(segments, seg)
+	}
+	as.Value = segments
+}
+
 func (o *BGPPathAttrAS4Path) New() BGPPathAttr {
 	return &BGPPathAttrAS4Path{}
 }
@@ -1682,6 +2266,17 @@ func (o *BGPPathAttrMultiExitDisc) New() BGPPathAttr {
 	return &BGPPathAttrMultiExitDisc{}
 }
 
+func NewBGPPathAttrMultiExitDisc() *BGPPathAttrMultiExitDisc {
+	return &BGPPathAttrMultiExitDisc{
+		BGPPathAttrBase: BGPPathAttrBase{
+			Flags:          BGPPathAttrFlagOptional,
+			Code:           BGPPathAttrTypeMultiExitDisc,
+			Length:         4,
+			BGPPathAttrLen: 3,
+		},
+	}
+}
+
 type BGPPathAttrLocalPref struct {
 	BGPPathAttrBase
 	Value uint32
@@ -1974,14 +2569,401 @@ func NewBGPPathAttrClusterList() *BGPPathAttrClusterList {
 	}
 }
 
+type BGPPathAttrCommunities struct {
+	BGPPathAttrBase
+	Value []uint32
+}
+
+func (c *BGPPathAttrCommunities) Clone() BGPPathAttr {
+	x := *c
+	x.BGPPathAttrBase = c.BGPPathAttrBase.Clone()
+	x.Value = make([]uint32, len(c.Value))
+	copy(x.Value, c.Value)
+	return &x
+}
+
+func (c *BGPPathAttrCommunities) Encode() ([]byte, error) {
+	pkt, err := c.BGPPathAttrBase.Encode()
+	if err != nil {
+		return pkt, err
+	}
+
+	for i := 0; i < len(c.Value); i++ {
+		binary.BigEndian.PutUint32(pkt[int(c.BGPPathAttrLen)+(4*i):], c.Value[i])
+	}
+	return pkt, nil
+}
+
+func (c *BGPPathAttrCommunities) Decode(pkt []byte, data interface{}) error {
+	err := c.BGPPathAttrBase.Decode(pkt, data)
+	if err != nil {
+		return err
+	}
+
+	if c.Length%4 != 0 {
+		return BGPMessageError{BGPUpdateMsgError, BGPOptionalAttrError, pkt[:c.TotalLen()],
+			"Communities attribute length is not a multiple of 4"}
+	}
+
+	c.Value = make([]uint32, c.Length/4)
+	for i := 0; i < len(c.Value); i++ {
+		idx := int(c.BGPPathAttrLen) + (4 * i)
+		c.Value[i] = binary.BigEndian.Uint32(pkt[idx : idx+4])
+	}
+	return nil
+}
+
+func (c *BGPPathAttrCommunities) setLength() {
+	c.Length = uint16(len(c.Value) * 4)
+	if c.Length > math.MaxUint8 {
+		c.Flags |= BGPPathAttrFlagExtendedLen
+		c.BGPPathAttrLen = 4
+	} else {
+		c.Flags &^= BGPPathAttrFlagExtendedLen
+		c.BGPPathAttrLen = 3
+	}
+}
+
+func (c *BGPPathAttrCommunities) HasCommunity(community uint32) bool {
+	for _, val := range c.Value {
+		if val == community {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *BGPPathAttrCommunities) AddCommunity(community uint32) {
+	if c.HasCommunity(community) {
+		return
+	}
+	c.Value = append(c.Value, community)
+	c.setLength()
+}
+
+func (c *BGPPathAttrCommunities) RemoveCommunity(community uint32) {
+	for idx, val := range c.Value {
+		if val == community {
+			c.Value = append(c.Value[:idx], c.Value[idx+1:]...)
+			c.setLength()
+			return
+		}
+	}
+}
+
+func (c *BGPPathAttrCommunities) SetCommunities(communities []uint32) {
+	c.Value = make([]uint32, 0, len(communities))
+	for _, community := range communities {
+		if !c.HasCommunity(community) {
+			c.Value = append(c.Value, community)
+		}
+	}
+	c.setLength()
+}
+
+func (o *BGPPathAttrCommunities) New() BGPPathAttr {
+	return &BGPPathAttrCommunities{}
+}
+
+func NewBGPPathAttrCommunities() *BGPPathAttrCommunities {
+	return &BGPPathAttrCommunities{
+		BGPPathAttrBase: BGPPathAttrBase{
+			Flags:          BGPPathAttrFlagOptional | BGPPathAttrFlagTransitive,
+			Code:           BGPPathAttrTypeCommunities,
+			Length:         0,
+			BGPPathAttrLen: 3,
+		},
+		Value: make([]uint32, 0),
+	}
+}
+
+type BGPPathAttrExtCommunities struct {
+	BGPPathAttrBase
+	Value []uint64
+}
+
+func (e *BGPPathAttrExtCommunities) Clone() BGPPathAttr {
+	x := *e
+	x.BGPPathAttrBase = e.BGPPathAttrBase.Clone()
+	x.Value = make([]uint64, len(e.Value))
+	copy(x.Value, e.Value)
+	return &x
+}
+
+func (e *BGPPathAttrExtCommunities) Encode() ([]byte, error) {
+	pkt, err := e.BGPPathAttrBase.Encode()
+	if err != nil {
+		return pkt, err
+	}
+
+	for i := 0; i < len(e.Value); i++ {
+		binary.BigEndian.PutUint64(pkt[int(e.BGPPathAttrLen)+(8*i):], e.Value[i])
+	}
+	return pkt, nil
+}
+
+func (e *BGPPathAttrExtCommunities) Decode(pkt []byte, data interface{}) error {
+	err := e.BGPPathAttrBase.Decode(pkt, data)
+	if err != nil {
+		return err
+	}
+
+	if e.Length%8 != 0 {
+		return BGPMessageError{BGPUpdateMsgError, BGPOptionalAttrError, pkt[:e.TotalLen()],
+			"Extended communities attribute length is not a multiple of 8"}
+	}
+
+	e.Value = make([]uint64, e.Length/8)
+	for i := 0; i < len(e.Value); i++ {
+		idx := int(e.BGPPathAttrLen) + (8 * i)
+		e.Value[i] = binary.BigEndian.Uint64(pkt[idx : idx+8])
+	}
+	return nil
+}
+
+func (e *BGPPathAttrExtCommunities) setLength() {
+	e.Length = uint16(len(e.Value) * 8)
+	if e.Length > math.MaxUint8 {
+		e.Flags |= BGPPathAttrFlagExtendedLen
+		e.BGPPathAttrLen = 4
+	} else {
+		e.Flags &^= BGPPathAttrFlagExtendedLen
+		e.BGPPathAttrLen = 3
+	}
+}
+
+func (e *BGPPathAttrExtCommunities) HasExtCommunity(extCommunity uint64) bool {
+	for _, val := range e.Value {
+		if val == extCommunity {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *BGPPathAttrExtCommunities) AddExtCommunity(extCommunity uint64) {
+	if e.HasExtCommunity(extCommunity) {
+		return
+	}
+	e.Value = append(e.Value, extCommunity)
+	e.setLength()
+}
+
+func (e *BGPPathAttrExtCommunities) RemoveExtCommunity(extCommunity uint64) {
+	for idx, val := range e.Value {
+		if val == extCommunity {
+			e.Value = append(e.Value[:idx], e.Value[idx+1:]...)
+			e.setLength()
+			return
+		}
+	}
+}
+
+func (e *BGPPathAttrExtCommunities) SetExtCommunities(extCommunities []uint64) {
+	e.Value = make([]uint64, 0, len(extCommunities))
+	for _, extCommunity := range extCommunities {
+		if !e.HasExtCommunity(extCommunity) {
+			e.Value = append(e.Value, extCommunity)
+		}
+	}
+	e.setLength()
+}
+
+func (e *BGPPathAttrExtCommunities) New() BGPPathAttr {
+	return &BGPPathAttrExtCommunities{}
+}
+
+func NewBGPPathAttrExtCommunities() *BGPPathAttrExtCommunities {
+	return &BGPPathAttrExtCommunities{
+		BGPPathAttrBase: BGPPathAttrBase{
+			Flags:          BGPPathAttrFlagOptional | BGPPathAttrFlagTransitive,
+			Code:           BGPPathAttrTypeExtCommunities,
+			Length:         0,
+			BGPPathAttrLen: 3,
+		},
+		Value: make([]uint64, 0),
+	}
+}
+
+type BGPLargeCommunity struct {
+	GlobalAdmin uint32
+	LocalData1  uint32
+	LocalData2  uint32
+}
+
+type BGPPathAttrLargeCommunities struct {
+	BGPPathAttrBase
+	Value []BGPLargeCommunity
+}
+
+func (l *BGPPathAttrLargeCommunities) Clone() BGPPathAttr {
+	x := *l
+	x.BGPPathAttrBase = l.BGPPathAttrBase.Clone()
+	x.Value = make([]BGPLargeCommunity, len(l.Value))
+	copy(x.Value, l.Value)
+	return &x
+}
+
+func (l *BGPPathAttrLargeCommunities) Encode() ([]byte, error) {
+	pkt, err := l.BGPPathAttrBase.Encode()
+	if err != nil {
+		return pkt, err
+	}
+
+	for i := 0; i < len(l.Value); i++ {
+		idx := int(l.BGPPathAttrLen) + (12 * i)
+		binary.BigEndian.PutUint32(pkt[idx:], l.Value[i].GlobalAdmin)
+		binary.BigEndian.PutUint32(pkt[idx+4:], l.Value[i].LocalData1)
+		binary.BigEndian.PutUint32(pkt[idx+8:], l.Value[i].LocalData2)
+	}
+	return pkt, nil
+}
+
+func (l *BGPPathAttrLargeCommunities) Decode(pkt []byte, data interface{}) error {
+	err := l.BGPPathAttrBase.Decode(pkt, data)
+	if err != nil {
+		return err
+	}
+
+	if l.Length%12 != 0 {
+		return BGPMessageError{BGPUpdateMsgError, BGPOptionalAttrError, pkt[:l.TotalLen()],
+			"Large communities attribute length is not a multiple of 12"}
+	}
+
+	l.Value = make([]BGPLargeCommunity, l.Length/12)
+	for i := 0; i < len(l.Value); i++ {
+		idx := int(l.BGPPathAttrLen) + (12 * i)
+		l.Value[i].GlobalAdmin = binary.BigEndian.Uint32(pkt[idx : idx+4])
+		l.Value[i].LocalData1 = binary.BigEndian.Uint32(pkt[idx+4 : idx+8])
+		l.Value[i].LocalData2 = binary.BigEndian.Uint32(pkt[idx+8 : idx+12])
+	}
+	return nil
+}
+
+func (l *BGPPathAttrLargeCommunities) setLength() {
+	l.Length = uint16(len(l.Value) * 12)
+	if l.Length > math.MaxUint8 {
+		l.Flags |= BGPPathAttrFlagExtendedLen
+		l.BGPPathAttrLen = 4
+	} else {
+		l.Flags &^= BGPPathAttrFlagExtendedLen
+		l.BGPPathAttrLen = 3
+	}
+}
+
+func (l *BGPPathAttrLargeCommunities) HasLargeCommunity(largeCommunity BGPLargeCommunity) bool {
+	for _, val := range l.Value {
+		if val == largeCommunity {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *BGPPathAttrLargeCommunities) AddLargeCommunity(largeCommunity BGPLargeCommunity) {
+	if l.HasLargeCommunity(largeCommunity) {
+		return
+	}
+	l.Value = append(l.Value, largeCommunity)
+	l.setLength()
+}
+
+func (l *BGPPathAttrLargeCommunities) RemoveLargeCommunity(largeCommunity BGPLargeCommunity) {
+	for idx, val := range l.Value {
+		if val == largeCommunity {
+			l.Value = append(l.Value[:idx], l.Value[idx+1:]...)
+			l.setLength()
+			return
+		}
+	}
+}
+
+func (l *BGPPathAttrLargeCommunities) SetLargeCommunities(largeCommunities []BGPLargeCommunity) {
+	l.Value = make([]BGPLargeCommunity, 0, len(largeCommunities))
+	for _, largeCommunity := range largeCommunities {
+		if !l.HasLargeCommunity(largeCommunity) {
+			l.Value = append(l.Value, largeCommunity)
+		}
+	}
+	l.setLength()
+}
+
+func (l *BGPPathAttrLargeCommunities) New() BGPPathAttr {
+	return &BGPPathAttrLargeCommunities{}
+}
+
+func NewBGPPathAttrLargeCommunities() *BGPPathAttrLargeCommunities {
+	return &BGPPathAttrLargeCommunities{
+		BGPPathAttrBase: BGPPathAttrBase{
+			Flags:          BGPPathAttrFlagOptional | BGPPathAttrFlagTransitive,
+			Code:           BGPPathAttrTypeLargeCommunities,
+			Length:         0,
+			BGPPathAttrLen: 3,
+		},
+		Value: make([]BGPLargeCommunity, 0),
+	}
+}
+
+func decodeMPNLRI(pkt []byte, afi AFI, safi SAFI, data interface{}) ([]NLRI, error) {
+	nlriList := make([]NLRI, 0)
+	if afi == AfiL2VPN && safi == SafiEVPN {
+		return decodeEVPNNLRI(pkt)
+	}
+
+	if (afi != AfiIP && afi != AfiIP6) ||
+		(safi != SafiUnicast && safi != SafiLabeledUnicast && safi != SafiMPLSVPN) {
+		return nlriList, nil
+	}
+
+	peerAttrs, _ := data.(BGPPeerAttrs)
+	ptr := uint32(0)
+	for ptr < uint32(len(pkt)) {
+		var nlri NLRI
+		var err error
+		if safi == SafiLabeledUnicast {
+			labeledPrefix := &LabeledPrefix{}
+			err = labeledPrefix.decodeLabeledPrefix(pkt[ptr:], afi)
+			nlri = labeledPrefix
+		} else if safi == SafiMPLSVPN {
+			vpnPrefix := &VPNPrefix{}
+			err = vpnPrefix.decodeVPNPrefix(pkt[ptr:], afi)
+			nlri = vpnPrefix
+		} else if peerAttrs.IsAddPathsRx(afi, safi) {
+			extNLRI := &ExtNLRI{}
+			err = extNLRI.decodeExtNLRI(pkt[ptr:], afi)
+			nlri = extNLRI
+		} else {
+			ipPrefix := &IPPrefix{}
+			err = ipPrefix.decodeIPPrefix(pkt[ptr:], afi)
+			nlri = ipPrefix
+		}
+		if err != nil {
+			return nlriList, err
+		}
+
+		nlriList = append(nlriList, nlri)
+		ptr += nlri.Len()
+	}
+	return nlriList, nil
+}
+
+func cloneNLRIList(nlriList []NLRI) []NLRI {
+	clonedList := make([]NLRI, 0, len(nlriList))
+	for _, nlri := range nlriList {
+		clonedList = append(clonedList, nlri.Clone())
+	}
+	return clonedList
+}
+
 type BGPPathAttrMPReachNLRI struct {
 	BGPPathAttrBase
-	AFI      AFI
-	SAFI     SAFI
-	Length   uint8
-	NextHop  []byte
-	Reserved byte
-	NLRI     [][]byte
+	AFI              AFI
+	SAFI             SAFI
+	NextHopLen       uint8
+	NextHop          net.IP
+	LinkLocalNextHop net.IP
+	Reserved         byte
+	NLRI             []NLRI
 }
 
 func (r *BGPPathAttrMPReachNLRI) Clone() BGPPathAttr {
@@ -1989,11 +2971,11 @@ func (r *BGPPathAttrMPReachNLRI) Clone() BGPPathAttr {
 	x.BGPPathAttrBase = r.BGPPathAttrBase.Clone()
 	x.NextHop = make(net.IP, len(r.NextHop))
 	copy(x.NextHop, r.NextHop)
-	x.NLRI = make([][]byte, len(r.NLRI))
-	for i, nlri := range r.NLRI {
-		x.NLRI[i] = make(net.IP, len(nlri))
-		copy(x.NLRI[i], nlri)
+	if r.LinkLocalNextHop != nil {
+		x.LinkLocalNextHop = make(net.IP, len(r.LinkLocalNextHop))
+		copy(x.LinkLocalNextHop, r.LinkLocalNextHop)
 	}
+	x.NLRI = cloneNLRIList(r.NLRI)
 	return &x
 }
 
@@ -2009,16 +2991,29 @@ func (r *BGPPathAttrMPReachNLRI) Encode() ([]byte, error) {
 	pkt[idx] = uint8(r.SAFI)
 	idx++
 
-	pkt[idx] = uint8(len(r.NextHop))
-	copy(pkt[idx:], r.NextHop)
-	idx += len(r.NextHop)
+	pkt[idx] = r.NextHopLen
+	idx++
+	if r.SAFI == SafiMPLSVPN {
+		// The route distinguisher of the next hop is 0
+		idx += routeDistinguisherLen
+	}
+	idx += copy(pkt[idx:], r.getNextHopBytes(r.NextHop))
+	if r.LinkLocalNextHop != nil {
+		if r.SAFI == SafiMPLSVPN {
+			idx += routeDistinguisherLen
+		}
+		idx += copy(pkt[idx:], r.LinkLocalNextHop.To16())
+	}
 
 	pkt[idx] = 0
 	idx++
 
 	for i := 0; i < len(r.NLRI); i++ {
-		copy(pkt[idx:], r.NLRI[i])
-		idx += len(r.NLRI[i])
+		bytes, err := r.NLRI[i].Encode()
+		if err != nil {
+			return pkt, err
+		}
+		idx += copy(pkt[idx:], bytes)
 	}
 	return pkt, nil
 }
@@ -2029,63 +3024,145 @@ func (r *BGPPathAttrMPReachNLRI) Decode(pkt []byte, data interface{}) error {
 		return err
 	}
 
+	if r.Length < 5 {
+		return BGPMessageError{BGPUpdateMsgError, BGPOptionalAttrError, pkt[:r.TotalLen()],
+			"MP_REACH_NLRI attribute is too short"}
+	}
+
 	idx := int(r.BGPPathAttrBase.BGPPathAttrLen)
 	r.AFI = AFI(binary.BigEndian.Uint16(pkt[idx : idx+2]))
 	r.SAFI = SAFI(pkt[idx+2])
-	r.Length = pkt[idx+3]
-	idx += 3
+	r.NextHopLen = pkt[idx+3]
+	idx += 4
+
+	if uint32(idx)+uint32(r.NextHopLen)+1 > r.TotalLen() {
+		return BGPMessageError{BGPUpdateMsgError, BGPOptionalAttrError, pkt[:r.TotalLen()],
+			fmt.Sprintf("MP_REACH_NLRI next hop length %d is greater than the attribute length", r.NextHopLen)}
+	}
+
+	switch {
+	case isVPNNextHop(r.SAFI, r.NextHopLen):
+		r.decodeVPNNextHop(pkt[idx : idx+int(r.NextHopLen)])
+
+	case r.NextHopLen == net.IPv4len || r.NextHopLen == net.IPv6len:
+		r.NextHop = make(net.IP, r.NextHopLen)
+		copy(r.NextHop, pkt[idx:idx+int(r.NextHopLen)])
+		r.LinkLocalNextHop = nil
 
-	r.NextHop = make([]byte, r.Length)
-	copy(r.NextHop, pkt[idx:idx+int(r.Length)])
-	idx += int(r.Length)
+	case r.NextHopLen == 2*net.IPv6len:
+		r.NextHop = make(net.IP, net.IPv6len)
+		copy(r.NextHop, pkt[idx:idx+net.IPv6len])
+		r.LinkLocalNextHop = make(net.IP, net.IPv6len)
+		copy(r.LinkLocalNextHop, pkt[idx+net.IPv6len:idx+2*net.IPv6len])
+
+	default:
+		if (r.AFI == AfiIP || r.AFI == AfiIP6) && r.SAFI == SafiUnicast {
+			return BGPMessageError{BGPUpdateMsgError, BGPOptionalAttrError, pkt[:r.TotalLen()],
+				fmt.Sprintf("MP_REACH_NLRI has invalid next hop length %d", r.NextHopLen)}
+		}
+		r.NextHop = make(net.IP, r.NextHopLen)
+		copy(r.NextHop, pkt[idx:idx+int(r.NextHopLen)])
+	}
+	idx += int(r.NextHopLen)
 
 	r.Reserved = pkt[idx]
 	idx++
 
-	r.NLRI = make([][]byte, 0)
-	for uint32(idx) < r.TotalLen() {
-		bytes := int((pkt[idx] + 7) / 8)
-		idx++
-		nlri := make([]byte, bytes)
-		copy(nlri[0:], pkt[idx:idx+bytes])
-		r.NLRI = append(r.NLRI, nlri)
-		idx += bytes
+	r.NLRI, err = decodeMPNLRI(pkt[idx:r.TotalLen()], r.AFI, r.SAFI, data)
+	if err != nil {
+		return BGPMessageError{BGPUpdateMsgError, BGPOptionalAttrError, pkt[:r.TotalLen()],
+			fmt.Sprintf("Failed to decode the NLRI in MP_REACH_NLRI, error: %s", err)}
 	}
 	return nil
 }
 
+func (r *BGPPathAttrMPReachNLRI) getNextHopBytes(nextHop net.IP) []byte {
+	if (r.AFI == AfiIP || r.AFI == AfiL2VPN) &&
+		(r.NextHopLen == net.IPv4len || r.NextHopLen == routeDistinguisherLen+net.IPv4len) {
+		return nextHop.To4()
+	}
+	return nextHop.To16()
+}
+
+func (r *BGPPathAttrMPReachNLRI) setLength() {
+	length := 5 + int(r.NextHopLen)
+	for _, nlri := range r.NLRI {
+		length += int(nlri.Len())
+	}
+
+	r.Length = uint16(length)
+	if length > math.MaxUint8 {
+		r.Flags |= BGPPathAttrFlagExtendedLen
+		r.BGPPathAttrLen = 4
+	} else {
+		r.Flags &^= BGPPathAttrFlagExtendedLen
+		r.BGPPathAttrLen = 3
+	}
+}
+
+func (r *BGPPathAttrMPReachNLRI) SetNextHop(nextHop net.IP, linkLocalNextHop net.IP) {
+	r.NextHop = nextHop
+	r.LinkLocalNextHop = linkLocalNextHop
+	if (r.AFI == AfiIP || r.AFI == AfiL2VPN) && nextHop.To4() != nil {
+		r.NextHopLen = net.IPv4len
+	} else if linkLocalNextHop != nil {
+		r.NextHopLen = 2 * net.IPv6len
+	} else {
+		r.NextHopLen = net.IPv6len
+	}
+	if r.SAFI == SafiMPLSVPN {
+		r.NextHopLen += routeDistinguisherLen
+		if linkLocalNextHop != nil {
+			r.NextHopLen += routeDistinguisherLen
+		}
+	}
+	r.setLength()
+}
+
+func (r *BGPPathAttrMPReachNLRI) GetNextHop() net.IP {
+	if (r.NextHop == nil || r.NextHop.IsUnspecified()) && r.LinkLocalNextHop != nil {
+		return r.LinkLocalNextHop
+	}
+	return r.NextHop
+}
+
+func (r *BGPPathAttrMPReachNLRI) SetNLRIList(nlriList []NLRI) {
+	r.NLRI = nlriList
+	r.setLength()
+}
+
 func (o *BGPPathAttrMPReachNLRI) New() BGPPathAttr {
 	return &BGPPathAttrMPReachNLRI{}
 }
 
-func NewBGPPathAttrMPReachNLRI() *BGPPathAttrMPReachNLRI {
-	return &BGPPathAttrMPReachNLRI{
+func NewBGPPathAttrMPReachNLRI(afi AFI, safi SAFI) *BGPPathAttrMPReachNLRI {
+	mpReach := &BGPPathAttrMPReachNLRI{
 		BGPPathAttrBase: BGPPathAttrBase{
-			Flags:          BGPPathAttrFlagOptional & BGPPathAttrFlagExtendedLen,
+			Flags:          BGPPathAttrFlagOptional,
 			Code:           BGPPathAttrTypeMPReachNLRI,
 			Length:         0,
-			BGPPathAttrLen: 4,
+			BGPPathAttrLen: 3,
 		},
-		NextHop: make([]byte, 0),
-		NLRI:    make([][]byte, 0),
+		AFI:     afi,
+		SAFI:    safi,
+		NextHop: net.IP{},
+		NLRI:    make([]NLRI, 0),
 	}
+	mpReach.setLength()
+	return mpReach
 }
 
 type BGPPathAttrMPUnreachNLRI struct {
 	BGPPathAttrBase
-	AFI  uint16
-	SAFI uint8
-	NLRI [][]byte
+	AFI  AFI
+	SAFI SAFI
+	NLRI []NLRI
 }
 
 func (u *BGPPathAttrMPUnreachNLRI) Clone() BGPPathAttr {
 	x := *u
 	x.BGPPathAttrBase = u.BGPPathAttrBase.Clone()
-	x.NLRI = make([][]byte, len(u.NLRI))
-	for i, nlri := range u.NLRI {
-		x.NLRI[i] = make(net.IP, len(nlri))
-		copy(x.NLRI[i], nlri)
-	}
+	x.NLRI = cloneNLRIList(u.NLRI)
 	return &x
 }
 
@@ -2096,14 +3173,17 @@ func (u *BGPPathAttrMPUnreachNLRI) Encode() ([]byte, error) {
 	}
 	idx := int(u.BGPPathAttrBase.BGPPathAttrLen)
 
-	binary.BigEndian.PutUint16(pkt[idx:idx+2], u.AFI)
+	binary.BigEndian.PutUint16(pkt[idx:idx+2], uint16(u.AFI))
 	idx += 2
-	pkt[idx] = u.SAFI
+	pkt[idx] = uint8(u.SAFI)
 	idx++
 
 	for i := 0; i < len(u.NLRI); i++ {
-		copy(pkt[idx:], u.NLRI[i])
-		idx += len(u.NLRI[i])
+		bytes, err := u.NLRI[i].Encode()
+		if err != nil {
+			return pkt, err
+		}
+		idx += copy(pkt[idx:], bytes)
 	}
 	return pkt, nil
 }
@@ -2114,37 +3194,63 @@ func (u *BGPPathAttrMPUnreachNLRI) Decode(pkt []byte, data interface{}) error {
 		return err
 	}
 
+	if u.Length < 3 {
+		return BGPMessageError{BGPUpdateMsgError, BGPOptionalAttrError, pkt[:u.TotalLen()],
+			"MP_UNREACH_NLRI attribute is too short"}
+	}
+
 	idx := int(u.BGPPathAttrBase.BGPPathAttrLen)
-	u.AFI = binary.BigEndian.Uint16(pkt[idx : idx+2])
-	u.SAFI = pkt[idx+2]
-	idx += 2
+	u.AFI = AFI(binary.BigEndian.Uint16(pkt[idx : idx+2]))
+	u.SAFI = SAFI(pkt[idx+2])
+	idx += 3
 
-	u.NLRI = make([][]byte, 0)
-	for uint32(idx) < u.TotalLen() {
-		bytes := int((pkt[idx] + 7) / 8)
-		idx++
-		nlri := make([]byte, bytes)
-		copy(nlri[0:], pkt[idx:idx+bytes])
-		u.NLRI = append(u.NLRI, nlri)
-		idx += bytes
+	u.NLRI, err = decodeMPNLRI(pkt[idx:u.TotalLen()], u.AFI, u.SAFI, data)
+	if err != nil {
+		return BGPMessageError{BGPUpdateMsgError, BGPOptionalAttrError, pkt[:u.TotalLen()],
+			fmt.Sprintf("Failed to decode the NLRI in MP_UNREACH_NLRI, error: %s", err)}
 	}
 	return nil
 }
 
+func (u *BGPPathAttrMPUnreachNLRI) setLength() {
+	length := 3
+	for _, nlri := range u.NLRI {
+		length += int(nlri.Len())
+	}
+
+	u.Length = uint16(length)
+	if length > math.MaxUint8 {
+		u.Flags |= BGPPathAttrFlagExtendedLen
+		u.BGPPathAttrLen = 4
+	} else {
+		u.Flags &^= BGPPathAttrFlagExtendedLen
+		u.BGPPathAttrLen = 3
+	}
+}
+
+func (u *BGPPathAttrMPUnreachNLRI) SetNLRIList(nlriList []NLRI) {
+	u.NLRI = nlriList
+	u.setLength()
+}
+
 func (o *BGPPathAttrMPUnreachNLRI) New() BGPPathAttr {
 	return &BGPPathAttrMPUnreachNLRI{}
 }
 
-func NewBGPPathAttrMPUnreachNLRI() *BGPPathAttrMPUnreachNLRI {
-	return &BGPPathAttrMPUnreachNLRI{
+func NewBGPPathAttrMPUnreachNLRI(afi AFI, safi SAFI) *BGPPathAttrMPUnreachNLRI {
+	mpUnreach := &BGPPathAttrMPUnreachNLRI{
 		BGPPathAttrBase: BGPPathAttrBase{
-			Flags:          BGPPathAttrFlagOptional & BGPPathAttrFlagExtendedLen,
+			Flags:          BGPPathAttrFlagOptional,
 			Code:           BGPPathAttrTypeMPUnreachNLRI,
 			Length:         0,
-			BGPPathAttrLen: 4,
+			BGPPathAttrLen: 3,
 		},
-		NLRI: make([][]byte, 0),
+		AFI:  afi,
+		SAFI: safi,
+		NLRI: make([]NLRI, 0),
 	}
+	mpUnreach.setLength()
+	return mpUnreach
 }
 
 type BGPPathAttrUnknown struct {
@@ -2381,6 +3487,16 @@ func NewBGPUpdateMessage(wdRoutes []NLRI, pa []BGPPathAttr, nlri []NLRI) *BGPMes
 	}
 }
 
+func NewBGPEndOfRIBMessage() *BGPMessage {
+	return NewBGPUpdateMessage(make([]NLRI, 0), make([]BGPPathAttr, 0), make([]NLRI, 0))
+}
+
+func NewBGPMPEndOfRIBMessage(afi AFI, safi SAFI) *BGPMessage {
+	pathAttrs := make([]BGPPathAttr, 0, 1)
+	pathAttrs = append(pathAttrs, NewBGPPathAttrMPUnreachNLRI(afi, safi))
+	return NewBGPUpdateMessage(make([]NLRI, 0), pathAttrs, make([]NLRI, 0))
+}
+
 type BGPMessage struct {
 	Header BGPHeader
 	Body   BGPBody
@@ -2432,6 +3548,9 @@ func (msg *BGPMessage) Decode(header *BGPHeader, pkt []byte, data interface{}) e
 	case BGPMsgTypeNotification:
 		msg.Body = &BGPNotification{}
 
+	case BGPMsgTypeRouteRefresh:
+		msg.Body = &BGPRouteRefresh{}
+
 	default:
 		return nil
 	}
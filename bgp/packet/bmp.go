@@ -0,0 +1,344 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// bmp.go
+package packet
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+)
+
+const BMPVersion uint8 = 3
+
+const (
+	BMPMsgTypeRouteMonitoring uint8 = iota
+	BMPMsgTypeStatsReport
+	BMPMsgTypePeerDown
+	BMPMsgTypePeerUp
+	BMPMsgTypeInitiation
+	BMPMsgTypeTermination
+)
+
+const (
+	BMPCommonHeaderLen int = 6
+	BMPPeerHeaderLen   int = 42
+)
+
+const (
+	BMPPeerTypeGlobal uint8 = iota
+	BMPPeerTypeRD
+	BMPPeerTypeLocal
+)
+
+const (
+	BMPPeerFlag2ByteAS    uint8 = 0x20
+	BMPPeerFlagPostPolicy uint8 = 0x40
+	BMPPeerFlagIPv6       uint8 = 0x80
+)
+
+const (
+	_ uint8 = iota
+	BMPPeerDownLocalNotification
+	BMPPeerDownLocalNoNotification
+	BMPPeerDownRemoteNotification
+	BMPPeerDownRemoteNoData
+	BMPPeerDownDeconfigured
+)
+
+const (
+	BMPInfoTypeString uint16 = iota
+	BMPInfoTypeSysDescr
+	BMPInfoTypeSysName
+)
+
+const (
+	BMPTermTypeString uint16 = iota
+	BMPTermTypeReason
+)
+
+const (
+	BMPTermReasonAdminClose uint16 = iota
+	BMPTermReasonUnspecified
+	BMPTermReasonOutOfResources
+	BMPTermReasonRedundantConn
+	BMPTermReasonPermAdminClose
+)
+
+const (
+	BMPStatRejectedPrefixes      uint16 = 0
+	BMPStatAdjRIBInRoutes        uint16 = 7
+	BMPStatLocRIBRoutes          uint16 = 8
+	BMPStatAfiSafiAdjRIBInRoutes uint16 = 9
+	BMPStatAfiSafiLocRIBRoutes   uint16 = 10
+)
+
+// Encodes the IPv4 addresses in the low order 4 bytes of the 16 byte address field
+func encodeBMPAddress(pkt []byte, ip net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		copy(pkt[12:16], ip4)
+	} else if ip16 := ip.To16(); ip16 != nil {
+		copy(pkt[0:16], ip16)
+	}
+}
+
+type BMPPeerHeader struct {
+	PeerType    uint8
+	Flags       uint8
+	PeerRD      uint64
+	PeerAddress net.IP
+	PeerAS      uint32
+	PeerBGPId   net.IP
+	Timestamp   time.Time
+}
+
+func NewBMPPeerHeader(peerAddress net.IP, peerAS uint32, peerBGPId net.IP, asSize uint8,
+	postPolicy bool) *BMPPeerHeader {
+	peerHeader := &BMPPeerHeader{
+		PeerType:    BMPPeerTypeGlobal,
+		PeerAddress: peerAddress,
+		PeerAS:      peerAS,
+		PeerBGPId:   peerBGPId,
+		Timestamp:   time.Now(),
+	}
+	if peerAddress.To4() == nil {
+		peerHeader.Flags |= BMPPeerFlagIPv6
+	}
+	if asSize == 2 {
+		peerHeader.Flags |= BMPPeerFlag2ByteAS
+	}
+	if postPolicy {
+		peerHeader.Flags |= BMPPeerFlagPostPolicy
+	}
+	return peerHeader
+}
+
+func (p *BMPPeerHeader) Encode() ([]byte, error) {
+	pkt := make([]byte, BMPPeerHeaderLen)
+	pkt[0] = p.PeerType
+	pkt[1] = p.Flags
+	binary.BigEndian.PutUint64(pkt[2:10], p.PeerRD)
+	encodeBMPAddress(pkt[10:26], p.PeerAddress)
+	binary.BigEndian.PutUint32(pkt[26:30], p.PeerAS)
+	if bgpId := p.PeerBGPId.To4(); bgpId != nil {
+		copy(pkt[30:34], bgpId)
+	}
+	if !p.Timestamp.IsZero() {
+		binary.BigEndian.PutUint32(pkt[34:38], uint32(p.Timestamp.Unix()))
+		binary.BigEndian.PutUint32(pkt[38:42], uint32(p.Timestamp.Nanosecond()/1000))
+	}
+	return pkt, nil
+}
+
+type BMPBody interface {
+	Encode() ([]byte, error)
+}
+
+type BMPRouteMonitoring struct {
+	Update *BGPMessage
+}
+
+func (r *BMPRouteMonitoring) Encode() ([]byte, error) {
+	return r.Update.Encode()
+}
+
+type BMPStat struct {
+	Type  uint16
+	Value []byte
+}
+
+func NewBMPStatGauge(statType uint16, value uint64) BMPStat {
+	stat := BMPStat{Type: statType, Value: make([]byte, 8)}
+	binary.BigEndian.PutUint64(stat.Value, value)
+	return stat
+}
+
+func NewBMPStatAfiSafiGauge(statType uint16, afi AFI, safi SAFI, value uint64) BMPStat {
+	stat := BMPStat{Type: statType, Value: make([]byte, 11)}
+	binary.BigEndian.PutUint16(stat.Value[0:2], uint16(afi))
+	stat.Value[2] = uint8(safi)
+	binary.BigEndian.PutUint64(stat.Value[3:11], value)
+	return stat
+}
+
+type BMPStatsReport struct {
+	Stats []BMPStat
+}
+
+func (s *BMPStatsReport) Encode() ([]byte, error) {
+	pkt := make([]byte, 4)
+	binary.BigEndian.PutUint32(pkt, uint32(len(s.Stats)))
+	for _, stat := range s.Stats {
+		tlv := make([]byte, 4+len(stat.Value))
+		binary.BigEndian.PutUint16(tlv[0:2], stat.Type)
+		binary.BigEndian.PutUint16(tlv[2:4], uint16(len(stat.Value)))
+		copy(tlv[4:], stat.Value)
+		pkt = append(pkt, tlv...)
+	}
+	return pkt, nil
+}
+
+type BMPPeerDown struct {
+	Reason uint8
+	Data   []byte
+}
+
+func (d *BMPPeerDown) Encode() ([]byte, error) {
+	pkt := make([]byte, 1, 1+len(d.Data))
+	pkt[0] = d.Reason
+	pkt = append(pkt, d.Data...)
+	return pkt, nil
+}
+
+type BMPPeerUp struct {
+	LocalAddress net.IP
+	LocalPort    uint16
+	RemotePort   uint16
+	SentOpen     []byte
+	RcvdOpen     []byte
+}
+
+func (u *BMPPeerUp) Encode() ([]byte, error) {
+	pkt := make([]byte, 20, 20+len(u.SentOpen)+len(u.RcvdOpen))
+	encodeBMPAddress(pkt[0:16], u.LocalAddress)
+	binary.BigEndian.PutUint16(pkt[16:18], u.LocalPort)
+	binary.BigEndian.PutUint16(pkt[18:20], u.RemotePort)
+	pkt = append(pkt, u.SentOpen...)
+	pkt = append(pkt, u.RcvdOpen...)
+	return pkt, nil
+}
+
+type BMPInfoTLV struct {
+	Type  uint16
+	Value []byte
+}
+
+// Body of the Initiation and Termination messages
+type BMPInfo struct {
+	TLVs []BMPInfoTLV
+}
+
+func (i *BMPInfo) Encode() ([]byte, error) {
+	pkt := make([]byte, 0)
+	for _, info := range i.TLVs {
+		tlv := make([]byte, 4+len(info.Value))
+		binary.BigEndian.PutUint16(tlv[0:2], info.Type)
+		binary.BigEndian.PutUint16(tlv[2:4], uint16(len(info.Value)))
+		copy(tlv[4:], info.Value)
+		pkt = append(pkt, tlv...)
+	}
+	return pkt, nil
+}
+
+type BMPMessage struct {
+	Type       uint8
+	PeerHeader *BMPPeerHeader
+	Body       BMPBody
+}
+
+func (msg *BMPMessage) Encode() ([]byte, error) {
+	pkt := make([]byte, BMPCommonHeaderLen)
+	pkt[0] = BMPVersion
+	pkt[5] = msg.Type
+
+	if msg.PeerHeader != nil {
+		peerHeader, err := msg.PeerHeader.Encode()
+		if err != nil {
+			return nil, err
+		}
+		pkt = append(pkt, peerHeader...)
+	}
+
+	body, err := msg.Body.Encode()
+	if err != nil {
+		return nil, err
+	}
+	pkt = append(pkt, body...)
+	binary.BigEndian.PutUint32(pkt[1:5], uint32(len(pkt)))
+	return pkt, nil
+}
+
+func NewBMPRouteMonitoringMessage(peerHeader *BMPPeerHeader, updateMsg *BGPMessage) *BMPMessage {
+	return &BMPMessage{
+		Type:       BMPMsgTypeRouteMonitoring,
+		PeerHeader: peerHeader,
+		Body:       &BMPRouteMonitoring{Update: updateMsg},
+	}
+}
+
+func NewBMPStatsReportMessage(peerHeader *BMPPeerHeader, stats []BMPStat) *BMPMessage {
+	return &BMPMessage{
+		Type:       BMPMsgTypeStatsReport,
+		PeerHeader: peerHeader,
+		Body:       &BMPStatsReport{Stats: stats},
+	}
+}
+
+func NewBMPPeerDownMessage(peerHeader *BMPPeerHeader, reason uint8, data []byte) *BMPMessage {
+	return &BMPMessage{
+		Type:       BMPMsgTypePeerDown,
+		PeerHeader: peerHeader,
+		Body:       &BMPPeerDown{Reason: reason, Data: data},
+	}
+}
+
+func NewBMPPeerUpMessage(peerHeader *BMPPeerHeader, localAddress net.IP, localPort uint16, remotePort uint16,
+	sentOpen []byte, rcvdOpen []byte) *BMPMessage {
+	return &BMPMessage{
+		Type:       BMPMsgTypePeerUp,
+		PeerHeader: peerHeader,
+		Body: &BMPPeerUp{
+			LocalAddress: localAddress,
+			LocalPort:    localPort,
+			RemotePort:   remotePort,
+			SentOpen:     sentOpen,
+			RcvdOpen:     rcvdOpen,
+		},
+	}
+}
+
+func NewBMPInitiationMessage(sysName string, sysDescr string) *BMPMessage {
+	return &BMPMessage{
+		Type: BMPMsgTypeInitiation,
+		Body: &BMPInfo{
+			TLVs: []BMPInfoTLV{
+				BMPInfoTLV{Type: BMPInfoTypeSysDescr, Value: []byte(sysDescr)},
+				BMPInfoTLV{Type: BMPInfoTypeSysName, Value: []byte(sysName)},
+			},
+		},
+	}
+}
+
+func NewBMPTerminationMessage(reason uint16) *BMPMessage {
+	value := make([]byte, 2)
+	binary.BigEndian.PutUint16(value, reason)
+	return &BMPMessage{
+		Type: BMPMsgTypeTermination,
+		Body: &BMPInfo{
+			TLVs: []BMPInfoTLV{
+				BMPInfoTLV{Type: BMPTermTypeReason, Value: value},
+			},
+		},
+	}
+}
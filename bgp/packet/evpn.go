@@ -0,0 +1,496 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// evpn.go
+package packet
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+)
+
+// EVPN route types (RFC 7432 and RFC 9136)
+const (
+	EVPNRouteTypeMACIPAdvertisement uint8 = 2
+	EVPNRouteTypeInclusiveMulticast uint8 = 3
+	EVPNRouteTypeIPPrefix           uint8 = 5
+)
+
+const (
+	BGPPathAttrTypePMSITunnel BGPPathAttrType = 22
+
+	// Ingress replication is the only PMSI tunnel type used with VXLAN (RFC 8365)
+	PMSITunnelTypeIngressReplication uint8 = 6
+
+	BGPExtCommunityTypeEVPN             uint8  = 0x06
+	BGPExtCommunitySubTypeEncapsulation uint8  = 0x0c
+	BGPExtCommunitySubTypeRouterMAC     uint8  = 0x03
+	BGPTunnelTypeVXLAN                  uint16 = 8
+
+	evpnESILen         = 10
+	evpnEthernetTagLen = 4
+	evpnMACLen         = 6
+	evpnLabelLen       = 3
+)
+
+/*  EVPNNLRI is the NLRI of the L2VPN EVPN family. The routes used with VXLAN carry the VNI
+ *  in the label fields (RFC 8365). IP is the IP address of a MAC/IP advertisement route,
+ *  the originating router of an inclusive multicast route and the prefix of an IP prefix
+ *  route. The value of the unknown route types is kept as is.
+ */
+type EVPNNLRI struct {
+	RouteType   uint8
+	RD          RouteDistinguisher
+	ESI         [evpnESILen]byte
+	EthernetTag uint32
+	MAC         net.HardwareAddr
+	IP          net.IP
+	IPLength    uint8
+	GatewayIP   net.IP
+	Labels      []uint32
+	Value       []byte
+}
+
+func (e *EVPNNLRI) Clone() NLRI {
+	x := *e
+	x.MAC = append(net.HardwareAddr(nil), e.MAC...)
+	x.IP = append(net.IP(nil), e.IP...)
+	x.GatewayIP = append(net.IP(nil), e.GatewayIP...)
+	x.Labels = append([]uint32(nil), e.Labels...)
+	x.Value = append([]byte(nil), e.Value...)
+	return &x
+}
+
+func evpnIPBytes(ip net.IP) []byte {
+	if ip == nil {
+		return nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip.To16()
+}
+
+func encodeEVPNLabels(labels []uint32) []byte {
+	pkt := make([]byte, len(labels)*evpnLabelLen)
+	for idx, label := range labels {
+		pkt[idx*evpnLabelLen] = uint8(label >> 16)
+		pkt[idx*evpnLabelLen+1] = uint8(label >> 8)
+		pkt[idx*evpnLabelLen+2] = uint8(label)
+	}
+	return pkt
+}
+
+func (e *EVPNNLRI) encodeValue() []byte {
+	rd := make([]byte, routeDistinguisherLen)
+	binary.BigEndian.PutUint64(rd, uint64(e.RD))
+	ethernetTag := make([]byte, evpnEthernetTagLen)
+	binary.BigEndian.PutUint32(ethernetTag, e.EthernetTag)
+
+	pkt := make([]byte, 0, 64)
+	switch e.RouteType {
+	case EVPNRouteTypeMACIPAdvertisement:
+		ip := evpnIPBytes(e.IP)
+		pkt = append(pkt, rd...)
+		pkt = append(pkt, e.ESI[:]...)
+		pkt = append(pkt, ethernetTag...)
+		pkt = append(pkt, evpnMACLen*8)
+		pkt = append(pkt, e.MAC...)
+		pkt = append(pkt, uint8(len(ip)*8))
+		pkt = append(pkt, ip...)
+		pkt = append(pkt, encodeEVPNLabels(e.Labels)...)
+
+	case EVPNRouteTypeInclusiveMulticast:
+		ip := evpnIPBytes(e.IP)
+		pkt = append(pkt, rd...)
+		pkt = append(pkt, ethernetTag...)
+		pkt = append(pkt, uint8(len(ip)*8))
+		pkt = append(pkt, ip...)
+
+	case EVPNRouteTypeIPPrefix:
+		ip := evpnIPBytes(e.IP)
+		gatewayIP := make([]byte, len(ip))
+		copy(gatewayIP, evpnIPBytes(e.GatewayIP))
+		pkt = append(pkt, rd...)
+		pkt = append(pkt, e.ESI[:]...)
+		pkt = append(pkt, ethernetTag...)
+		pkt = append(pkt, e.IPLength)
+		pkt = append(pkt, ip...)
+		pkt = append(pkt, gatewayIP...)
+		labels := e.Labels
+		if len(labels) == 0 {
+			labels = []uint32{0}
+		}
+		pkt = append(pkt, encodeEVPNLabels(labels[:1])...)
+
+	default:
+		pkt = append(pkt, e.Value...)
+	}
+	return pkt
+}
+
+func (e *EVPNNLRI) Len() uint32 {
+	return uint32(2 + len(e.encodeValue()))
+}
+
+func (e *EVPNNLRI) Encode() ([]byte, error) {
+	value := e.encodeValue()
+	if len(value) > math.MaxUint8 {
+		return nil, BGPMessageError{BGPUpdateMsgError, BGPInvalidNetworkField, nil,
+			fmt.Sprintf("EVPN route type %d length %d is too long", e.RouteType, len(value))}
+	}
+
+	pkt := make([]byte, 2, 2+len(value))
+	pkt[0] = e.RouteType
+	pkt[1] = uint8(len(value))
+	return append(pkt, value...), nil
+}
+
+func decodeEVPNIP(pkt []byte, bits uint8) (net.IP, error) {
+	if bits != 0 && bits != net.IPv4len*8 && bits != net.IPv6len*8 {
+		return nil, BGPMessageError{BGPUpdateMsgError, BGPInvalidNetworkField, nil,
+			fmt.Sprintf("EVPN NLRI IP address length %d is not valid", bits)}
+	}
+	if len(pkt) < int(bits/8) {
+		return nil, BGPMessageError{BGPUpdateMsgError, BGPInvalidNetworkField, nil,
+			"EVPN NLRI does not contain the IP address"}
+	}
+	if bits == 0 {
+		return nil, nil
+	}
+
+	ip := make(net.IP, bits/8)
+	copy(ip, pkt)
+	return ip, nil
+}
+
+func (e *EVPNNLRI) decodeMACIPAdvertisement(pkt []byte) error {
+	idx := routeDistinguisherLen + evpnESILen + evpnEthernetTagLen
+	if len(pkt) < idx+1+evpnMACLen+1 || pkt[idx] != evpnMACLen*8 {
+		return BGPMessageError{BGPUpdateMsgError, BGPInvalidNetworkField, nil,
+			"EVPN MAC/IP advertisement route does not contain the MAC address"}
+	}
+	copy(e.ESI[:], pkt[routeDistinguisherLen:])
+	e.EthernetTag = binary.BigEndian.Uint32(pkt[routeDistinguisherLen+evpnESILen:])
+	e.MAC = make(net.HardwareAddr, evpnMACLen)
+	copy(e.MAC, pkt[idx+1:])
+	idx += 1 + evpnMACLen
+
+	ipBits := pkt[idx]
+	ip, err := decodeEVPNIP(pkt[idx+1:], ipBits)
+	if err != nil {
+		return err
+	}
+	e.IP = ip
+	idx += 1 + int(ipBits/8)
+
+	labelsLen := len(pkt) - idx
+	if labelsLen != evpnLabelLen && labelsLen != 2*evpnLabelLen {
+		return BGPMessageError{BGPUpdateMsgError, BGPInvalidNetworkField, nil,
+			fmt.Sprintf("EVPN MAC/IP advertisement route labels length %d is not valid", labelsLen)}
+	}
+	e.Labels = decodeEVPNLabels(pkt[idx:])
+	return nil
+}
+
+func (e *EVPNNLRI) decodeInclusiveMulticast(pkt []byte) error {
+	idx := routeDistinguisherLen + evpnEthernetTagLen
+	if len(pkt) < idx+1 {
+		return BGPMessageError{BGPUpdateMsgError, BGPInvalidNetworkField, nil,
+			"EVPN inclusive multicast route does not contain the originating router IP"}
+	}
+	e.EthernetTag = binary.BigEndian.Uint32(pkt[routeDistinguisherLen:])
+	ip, err := decodeEVPNIP(pkt[idx+1:], pkt[idx])
+	if err != nil {
+		return err
+	}
+	e.IP = ip
+	return nil
+}
+
+// The IPv4 and IPv6 IP prefix routes are 34 and 58 bytes long, the prefix and the gateway
+// IP have the same address family.
+func (e *EVPNNLRI) decodeIPPrefix(pkt []byte) error {
+	idx := routeDistinguisherLen + evpnESILen + evpnEthernetTagLen + 1
+	ipLen := (len(pkt) - idx - evpnLabelLen) / 2
+	if (ipLen != net.IPv4len && ipLen != net.IPv6len) || idx+2*ipLen+evpnLabelLen != len(pkt) {
+		return BGPMessageError{BGPUpdateMsgError, BGPInvalidNetworkField, nil,
+			fmt.Sprintf("EVPN IP prefix route length %d is not valid", len(pkt))}
+	}
+
+	copy(e.ESI[:], pkt[routeDistinguisherLen:])
+	e.EthernetTag = binary.BigEndian.Uint32(pkt[routeDistinguisherLen+evpnESILen:])
+	e.IPLength = pkt[idx-1]
+	if int(e.IPLength) > ipLen*8 {
+		return BGPMessageError{BGPUpdateMsgError, BGPInvalidNetworkField, nil,
+			fmt.Sprintf("EVPN IP prefix route prefix length %d is not valid", e.IPLength)}
+	}
+	e.IP = make(net.IP, ipLen)
+	copy(e.IP, pkt[idx:])
+	e.GatewayIP = make(net.IP, ipLen)
+	copy(e.GatewayIP, pkt[idx+ipLen:])
+	e.Labels = decodeEVPNLabels(pkt[idx+2*ipLen:])
+	return nil
+}
+
+func decodeEVPNNLRI(pkt []byte) ([]NLRI, error) {
+	nlriList := make([]NLRI, 0)
+	for ptr := 0; ptr < len(pkt); {
+		nlri := &EVPNNLRI{}
+		if err := nlri.Decode(pkt[ptr:]); err != nil {
+			return nlriList, err
+		}
+		nlriList = append(nlriList, nlri)
+		ptr += 2 + int(pkt[ptr+1])
+	}
+	return nlriList, nil
+}
+
+func decodeEVPNLabels(pkt []byte) []uint32 {
+	labels := make([]uint32, 0, len(pkt)/evpnLabelLen)
+	for idx := 0; idx+evpnLabelLen <= len(pkt); idx += evpnLabelLen {
+		labels = append(labels, uint32(pkt[idx])<<16|uint32(pkt[idx+1])<<8|uint32(pkt[idx+2]))
+	}
+	return labels
+}
+
+func (e *EVPNNLRI) Decode(pkt []byte) error {
+	if len(pkt) < 2 || len(pkt) < 2+int(pkt[1]) {
+		return BGPMessageError{BGPUpdateMsgError, BGPInvalidNetworkField, nil,
+			"EVPN NLRI does not contain the route"}
+	}
+
+	e.RouteType = pkt[0]
+	value := pkt[2 : 2+int(pkt[1])]
+	switch e.RouteType {
+	case EVPNRouteTypeMACIPAdvertisement, EVPNRouteTypeInclusiveMulticast, EVPNRouteTypeIPPrefix:
+		if len(value) < routeDistinguisherLen {
+			return BGPMessageError{BGPUpdateMsgError, BGPInvalidNetworkField, nil,
+				"EVPN NLRI does not contain the route distinguisher"}
+		}
+		e.RD = RouteDistinguisher(binary.BigEndian.Uint64(value))
+	}
+
+	switch e.RouteType {
+	case EVPNRouteTypeMACIPAdvertisement:
+		return e.decodeMACIPAdvertisement(value)
+	case EVPNRouteTypeInclusiveMulticast:
+		return e.decodeInclusiveMulticast(value)
+	case EVPNRouteTypeIPPrefix:
+		return e.decodeIPPrefix(value)
+	}
+
+	e.Value = make([]byte, len(value))
+	copy(e.Value, value)
+	return nil
+}
+
+// GetPrefix returns the IP address of the route as a host prefix, or the prefix of an IP prefix route
+func (e *EVPNNLRI) GetPrefix() *IPPrefix {
+	ip := e.IP
+	if ip == nil {
+		ip = net.IPv4zero
+	}
+
+	length := e.IPLength
+	if e.RouteType != EVPNRouteTypeIPPrefix {
+		length = uint8(len(evpnIPBytes(e.IP)) * 8)
+	}
+	return &IPPrefix{Prefix: ip, Length: length}
+}
+
+func (e *EVPNNLRI) GetPathId() uint32 {
+	return 0
+}
+
+// GetVNI returns the VNI of the route, the first label of the routes sent with VXLAN encapsulation
+func (e *EVPNNLRI) GetVNI() uint32 {
+	if len(e.Labels) == 0 {
+		return e.EthernetTag
+	}
+	return e.Labels[0]
+}
+
+// String returns the route key, the fields that identify the route without the labels (RFC 7432 section 7)
+func (e *EVPNNLRI) String() string {
+	switch e.RouteType {
+	case EVPNRouteTypeMACIPAdvertisement:
+		return fmt.Sprintf("[%d][%s][%d][%s][%s]", e.RouteType, e.RD, e.EthernetTag, e.MAC, e.IP)
+	case EVPNRouteTypeInclusiveMulticast:
+		return fmt.Sprintf("[%d][%s][%d][%s]", e.RouteType, e.RD, e.EthernetTag, e.IP)
+	case EVPNRouteTypeIPPrefix:
+		return fmt.Sprintf("[%d][%s][%d][%s/%d]", e.RouteType, e.RD, e.EthernetTag, e.IP, e.IPLength)
+	}
+	return fmt.Sprintf("[%d][%s]", e.RouteType, hex.EncodeToString(e.Value))
+}
+
+func NewEVPNMACIPAdvertisement(rd RouteDistinguisher, ethernetTag uint32, mac net.HardwareAddr, ip net.IP,
+	labels []uint32) *EVPNNLRI {
+	return &EVPNNLRI{
+		RouteType:   EVPNRouteTypeMACIPAdvertisement,
+		RD:          rd,
+		EthernetTag: ethernetTag,
+		MAC:         mac,
+		IP:          ip,
+		Labels:      labels,
+	}
+}
+
+func NewEVPNInclusiveMulticast(rd RouteDistinguisher, ethernetTag uint32, originatorIP net.IP) *EVPNNLRI {
+	return &EVPNNLRI{
+		RouteType:   EVPNRouteTypeInclusiveMulticast,
+		RD:          rd,
+		EthernetTag: ethernetTag,
+		IP:          originatorIP,
+	}
+}
+
+func NewEVPNIPPrefix(rd RouteDistinguisher, ethernetTag uint32, prefix IPPrefix, label uint32) *EVPNNLRI {
+	return &EVPNNLRI{
+		RouteType:   EVPNRouteTypeIPPrefix,
+		RD:          rd,
+		EthernetTag: ethernetTag,
+		IP:          prefix.Prefix,
+		IPLength:    prefix.Length,
+		Labels:      []uint32{label},
+	}
+}
+
+// BGPPathAttrPMSITunnel is the P-Multicast Service Interface tunnel of the inclusive multicast routes (RFC 6514)
+type BGPPathAttrPMSITunnel struct {
+	BGPPathAttrBase
+	TunnelFlags uint8
+	TunnelType  uint8
+	Label       uint32
+	TunnelId    net.IP
+}
+
+func (p *BGPPathAttrPMSITunnel) Clone() BGPPathAttr {
+	x := *p
+	x.BGPPathAttrBase = p.BGPPathAttrBase.Clone()
+	x.TunnelId = append(net.IP(nil), p.TunnelId...)
+	return &x
+}
+
+func (p *BGPPathAttrPMSITunnel) Encode() ([]byte, error) {
+	pkt, err := p.BGPPathAttrBase.Encode()
+	if err != nil {
+		return pkt, err
+	}
+
+	idx := p.BGPPathAttrLen
+	pkt[idx] = p.TunnelFlags
+	pkt[idx+1] = p.TunnelType
+	copy(pkt[idx+2:], encodeEVPNLabels([]uint32{p.Label}))
+	copy(pkt[idx+5:], evpnIPBytes(p.TunnelId))
+	return pkt, nil
+}
+
+func (p *BGPPathAttrPMSITunnel) Decode(pkt []byte, data interface{}) error {
+	err := p.BGPPathAttrBase.Decode(pkt, data)
+	if err != nil {
+		return err
+	}
+
+	if p.Length < 5 {
+		return BGPMessageError{BGPUpdateMsgError, BGPOptionalAttrError, pkt[:p.TotalLen()],
+			"PMSI tunnel attribute length is less than 5"}
+	}
+
+	idx := p.BGPPathAttrLen
+	p.TunnelFlags = pkt[idx]
+	p.TunnelType = pkt[idx+1]
+	p.Label = decodeEVPNLabels(pkt[idx+2 : idx+5])[0]
+	p.TunnelId = nil
+	if p.Length > 5 {
+		p.TunnelId = make(net.IP, p.Length-5)
+		copy(p.TunnelId, pkt[idx+5:idx+p.Length])
+	}
+	return nil
+}
+
+func (p *BGPPathAttrPMSITunnel) New() BGPPathAttr {
+	return &BGPPathAttrPMSITunnel{}
+}
+
+func NewBGPPathAttrPMSITunnel(tunnelType uint8, label uint32, tunnelId net.IP) *BGPPathAttrPMSITunnel {
+	return &BGPPathAttrPMSITunnel{
+		BGPPathAttrBase: BGPPathAttrBase{
+			Flags:          BGPPathAttrFlagOptional | BGPPathAttrFlagTransitive,
+			Code:           BGPPathAttrTypePMSITunnel,
+			Length:         uint16(5 + len(evpnIPBytes(tunnelId))),
+			BGPPathAttrLen: 3,
+		},
+		TunnelType: tunnelType,
+		Label:      label,
+		TunnelId:   tunnelId,
+	}
+}
+
+func GetPMSITunnel(pathAttrs []BGPPathAttr) *BGPPathAttrPMSITunnel {
+	for _, attr := range pathAttrs {
+		if attr.GetCode() == BGPPathAttrTypePMSITunnel {
+			return attr.(*BGPPathAttrPMSITunnel)
+		}
+	}
+	return nil
+}
+
+// AddPMSITunnel adds the PMSI tunnel attribute to the path attrs, replacing the existing one
+func AddPMSITunnel(pathAttrs []BGPPathAttr, tunnelType uint8, label uint32, tunnelId net.IP) []BGPPathAttr {
+	pathAttrs = removePathAttrFromPathAttrs(pathAttrs, BGPPathAttrTypePMSITunnel)
+	return insertPathAttr(pathAttrs, NewBGPPathAttrPMSITunnel(tunnelType, label, tunnelId))
+}
+
+// NewEncapsulationExtCommunity returns the encapsulation extended community of a tunnel type (RFC 5512)
+func NewEncapsulationExtCommunity(tunnelType uint16) uint64 {
+	return uint64(BGPExtCommunityTypeOpaque)<<56 | uint64(BGPExtCommunitySubTypeEncapsulation)<<48 |
+		uint64(tunnelType)
+}
+
+// NewRouterMACExtCommunity returns the router's MAC extended community of the IP prefix routes (RFC 9135)
+func NewRouterMACExtCommunity(mac net.HardwareAddr) uint64 {
+	extCommunity := uint64(BGPExtCommunityTypeEVPN)<<56 | uint64(BGPExtCommunitySubTypeRouterMAC)<<48
+	for idx := 0; idx < evpnMACLen && idx < len(mac); idx++ {
+		extCommunity |= uint64(mac[idx]) << uint(8*(evpnMACLen-1-idx))
+	}
+	return extCommunity
+}
+
+// GetRouterMAC returns the MAC of the router's MAC extended community in the path attrs
+func GetRouterMAC(pathAttrs []BGPPathAttr) net.HardwareAddr {
+	for _, extCommunity := range GetExtCommunities(pathAttrs) {
+		if uint8(extCommunity>>56) == BGPExtCommunityTypeEVPN &&
+			uint8(extCommunity>>48) == BGPExtCommunitySubTypeRouterMAC {
+			mac := make(net.HardwareAddr, evpnMACLen)
+			for idx := 0; idx < evpnMACLen; idx++ {
+				mac[idx] = uint8(extCommunity >> uint(8*(evpnMACLen-1-idx)))
+			}
+			return mac
+		}
+	}
+	return nil
+}
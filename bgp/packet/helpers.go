@@ -25,25 +25,40 @@
 package packet
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"l3/bgp/utils"
 	"math"
 	"net"
 	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 func PrependAS(updateMsg *BGPMessage, AS uint32, asSize uint8) {
+	prependASToSegment(updateMsg, AS, asSize, BGPASPathSegmentSequence)
+}
+
+// PrependConfedAS prepends the member AS to the AS_CONFED_SEQUENCE at the start of the AS path (RFC 5065)
+func PrependConfedAS(updateMsg *BGPMessage, AS uint32, asSize uint8) {
+	prependASToSegment(updateMsg, AS, asSize, BGPASPathSegmentConfedSequence)
+}
+
+func prependASToSegment(updateMsg *BGPMessage, AS uint32, asSize uint8, segType BGPASPathSegmentType) {
 	body := updateMsg.Body.(*BGPUpdate)
 
 	for _, pa := range body.PathAttributes {
 		if pa.GetCode() == BGPPathAttrTypeASPath {
 			asPathSegments := pa.(*BGPPathAttrASPath).Value
 			var newASPathSegment BGPASPathSegment
-			if len(asPathSegments) == 0 || asPathSegments[0].GetType() == BGPASPathSegmentSet || asPathSegments[0].GetLen() >= 255 {
+			if len(asPathSegments) == 0 || asPathSegments[0].GetType() != segType || asPathSegments[0].GetLen() >= 255 {
 				if asSize == 4 {
-					newASPathSegment = NewBGPAS4PathSegmentSeq()
+					newASPathSegment = NewBGPAS4PathSegment(segType)
 				} else {
-					newASPathSegment = NewBGPAS2PathSegmentSeq()
+					newASPathSegment = NewBGPAS2PathSegment(segType)
 					if asSize == 2 {
 						if AS > math.MaxUint16 {
 							AS = uint32(BGPASTrans)
@@ -55,11 +70,11 @@ func PrependAS(updateMsg *BGPMessage, AS uint32, asSize uint8) {
 			asPathSegments = pa.(*BGPPathAttrASPath).Value
 			asPathSegments[0].PrependAS(AS)
 			pa.(*BGPPathAttrASPath).BGPPathAttrBase.Length += uint16(asSize)
-		} else if pa.GetCode() == BGPPathAttrTypeAS4Path {
+		} else if pa.GetCode() == BGPPathAttrTypeAS4Path && !segType.IsConfed() {
 			asPathSegments := pa.(*BGPPathAttrAS4Path).Value
 			var newAS4PathSegment *BGPAS4PathSegment
-			if len(asPathSegments) == 0 || asPathSegments[0].GetType() == BGPASPathSegmentSet || asPathSegments[0].GetLen() >= 255 {
-				newAS4PathSegment = NewBGPAS4PathSegmentSeq()
+			if len(asPathSegments) == 0 || asPathSegments[0].GetType() != segType || asPathSegments[0].GetLen() >= 255 {
+				newAS4PathSegment = NewBGPAS4PathSegment(segType)
 				pa.(*BGPPathAttrAS4Path).AddASPathSegment(newAS4PathSegment)
 			}
 			asPathSegments = pa.(*BGPPathAttrAS4Path).Value
@@ -69,6 +84,48 @@ func PrependAS(updateMsg *BGPMessage, AS uint32, asSize uint8) {
 	}
 }
 
+/*  PrependASToPathAttrs prepends the AS count times to the AS_SEQUENCE at the start of the AS_PATH
+ *  in the path attrs. The AS is prepended as AS_TRANS to a 2 byte AS path when it doesn't fit.
+ */
+func PrependASToPathAttrs(pathAttrs []BGPPathAttr, AS uint32, count int) {
+	for _, pa := range pathAttrs {
+		if pa.GetCode() != BGPPathAttrTypeASPath {
+			continue
+		}
+
+		asPath := pa.(*BGPPathAttrASPath)
+		asSize := asPath.ASSize
+		if asSize == 2 && AS > math.MaxUint16 {
+			AS = uint32(BGPASTrans)
+		}
+		for i := 0; i < count; i++ {
+			if len(asPath.Value) == 0 || asPath.Value[0].GetType() != BGPASPathSegmentSequence ||
+				asPath.Value[0].GetLen() >= 255 {
+				if asSize == 4 {
+					asPath.PrependASPathSegment(NewBGPAS4PathSegment(BGPASPathSegmentSequence))
+				} else {
+					asPath.PrependASPathSegment(NewBGPAS2PathSegment(BGPASPathSegmentSequence))
+				}
+			}
+			asPath.Value[0].PrependAS(AS)
+			asPath.BGPPathAttrBase.Length += uint16(asSize)
+		}
+		break
+	}
+}
+
+// RemoveConfedSegments strips the confederation segments from the AS path before the update
+// leaves the confederation (RFC 5065)
+func RemoveConfedSegments(updateMsg *BGPMessage) {
+	body := updateMsg.Body.(*BGPUpdate)
+	for _, pa := range body.PathAttributes {
+		if pa.GetCode() == BGPPathAttrTypeASPath {
+			pa.(*BGPPathAttrASPath).RemoveConfedSegments()
+			break
+		}
+	}
+}
+
 func AppendASToAS4PathSeg(asPath *BGPPathAttrASPath, pathSeg BGPASPathSegment, asPathType BGPASPathSegmentType,
 	asNum uint32) BGPASPathSegment {
 	if pathSeg == nil {
@@ -170,6 +227,8 @@ func SetNextHopPathAttrs(pathAttrs []BGPPathAttr, nextHopIP net.IP) {
 	for idx, pa := range pathAttrs {
 		if pa.GetCode() == BGPPathAttrTypeNextHop {
 			pathAttrs[idx].(*BGPPathAttrNextHop).Value = nextHopIP
+		} else if pa.GetCode() == BGPPathAttrTypeMPReachNLRI {
+			pathAttrs[idx].(*BGPPathAttrMPReachNLRI).SetNextHop(nextHopIP, nil)
 		}
 	}
 }
@@ -183,24 +242,23 @@ func SetPathAttrAggregator(pathAttrs []BGPPathAttr, as uint32, ip net.IP) {
 	}
 }
 
-func HasASLoop(pathAttrs []BGPPathAttr, localAS uint32) bool {
+// CountASInPath returns the number of times the AS appears in the AS path
+func CountASInPath(pathAttrs []BGPPathAttr, as uint32) int {
+	count := 0
 	for _, attr := range pathAttrs {
 		if attr.GetCode() == BGPPathAttrTypeASPath {
-			asPaths := attr.(*BGPPathAttrASPath).Value
-			asSize := attr.(*BGPPathAttrASPath).ASSize
-			for _, asSegment := range asPaths {
-				if asSize == 4 {
-					seg := asSegment.(*BGPAS4PathSegment)
-					for _, as := range seg.AS {
-						if as == localAS {
-							return true
+			for _, asSegment := range attr.(*BGPPathAttrASPath).Value {
+				switch seg := asSegment.(type) {
+				case *BGPAS4PathSegment:
+					for _, segAS := range seg.AS {
+						if segAS == as {
+							count++
 						}
 					}
-				} else {
-					seg := asSegment.(*BGPAS2PathSegment)
-					for _, as := range seg.AS {
-						if as == uint16(localAS) {
-							return true
+				case *BGPAS2PathSegment:
+					for _, segAS := range seg.AS {
+						if uint32(segAS) == as {
+							count++
 						}
 					}
 				}
@@ -209,7 +267,155 @@ func HasASLoop(pathAttrs []BGPPathAttr, localAS uint32) bool {
 		}
 	}
 
-	return false
+	return count
+}
+
+func HasASLoop(pathAttrs []BGPPathAttr, localAS uint32) bool {
+	return CountASInPath(pathAttrs, localAS) > 0
+}
+
+// IsPrivateAS returns true for the 2 byte and 4 byte private use ASes (RFC 6996)
+func IsPrivateAS(as uint32) bool {
+	return (as >= 64512 && as <= 65534) || (as >= 4200000000 && as <= 4294967294)
+}
+
+func updateASPathASes(updateMsg *BGPMessage, update func(uint32) (uint32, bool)) {
+	body := updateMsg.Body.(*BGPUpdate)
+	for _, pa := range body.PathAttributes {
+		if pa.GetCode() == BGPPathAttrTypeASPath {
+			pa.(*BGPPathAttrASPath).UpdateASes(update)
+		} else if pa.GetCode() == BGPPathAttrTypeAS4Path {
+			pa.(*BGPPathAttrAS4Path).UpdateASes(update)
+		}
+	}
+}
+
+/*  RemovePrivateAS removes the private ASes from the AS path of the update message (remove-private-AS).
+ *  When replaceAS is not 0 the private ASes are replaced with replaceAS instead.
+ */
+func RemovePrivateAS(updateMsg *BGPMessage, replaceAS uint32) {
+	updateASPathASes(updateMsg, func(as uint32) (uint32, bool) {
+		if !IsPrivateAS(as) {
+			return as, true
+		}
+		return replaceAS, replaceAS != 0
+	})
+}
+
+// ReplaceAS replaces all the occurrences of oldAS in the AS path with newAS (as-override)
+func ReplaceAS(updateMsg *BGPMessage, oldAS uint32, newAS uint32) {
+	updateASPathASes(updateMsg, func(as uint32) (uint32, bool) {
+		if as == oldAS {
+			return newAS, true
+		}
+		return as, true
+	})
+}
+
+/*  Returns the origin AS of the path (RFC 6811), the last AS of the AS_PATH when the last
+ *  segment is an AS_SEQUENCE. Returns false when the origin AS is NONE, when the last segment
+ *  is an AS_SET. The origin AS is 0 for an empty AS_PATH, the route is originated locally.
+ *  The confederation segments are ignored.
+ */
+func GetOriginAS(pathAttrs []BGPPathAttr) (uint32, bool) {
+	for _, attr := range pathAttrs {
+		if attr.GetCode() != BGPPathAttrTypeASPath {
+			continue
+		}
+
+		var originAS uint32
+		var segType BGPASPathSegmentType = BGPASPathSegmentSequence
+		for _, asSegment := range attr.(*BGPPathAttrASPath).Value {
+			if asSegment.GetType().IsConfed() {
+				continue
+			}
+			switch seg := asSegment.(type) {
+			case *BGPAS4PathSegment:
+				if len(seg.AS) > 0 {
+					originAS, segType = seg.AS[len(seg.AS)-1], seg.Type
+				}
+			case *BGPAS2PathSegment:
+				if len(seg.AS) > 0 {
+					originAS, segType = uint32(seg.AS[len(seg.AS)-1]), seg.Type
+				}
+			}
+		}
+		if segType != BGPASPathSegmentSequence {
+			return 0, false
+		}
+		return originAS, true
+	}
+
+	return 0, true
+}
+
+/*  Returns the neighbor AS of the path, the first AS of the AS_PATH when the first segment is
+ *  an AS_SEQUENCE (RFC 4271 section 9.1.2.2). The confederation segments are skipped (RFC 5065).
+ *  The neighbor AS is 0 for an empty AS path or when the first segment is an AS_SET.
+ */
+func GetNeighborAS(pathAttrs []BGPPathAttr) uint32 {
+	for _, attr := range pathAttrs {
+		if attr.GetCode() != BGPPathAttrTypeASPath {
+			continue
+		}
+
+		for _, asSegment := range attr.(*BGPPathAttrASPath).Value {
+			if asSegment.GetType().IsConfed() || asSegment.GetLen() == 0 {
+				continue
+			}
+			if asSegment.GetType() != BGPASPathSegmentSequence {
+				return 0
+			}
+			switch seg := asSegment.(type) {
+			case *BGPAS4PathSegment:
+				return seg.AS[0]
+			case *BGPAS2PathSegment:
+				return uint32(seg.AS[0])
+			}
+		}
+		break
+	}
+
+	return 0
+}
+
+/*  GetASList returns the ASes of the AS path in order, an AS_SET is a single "{ AS1, AS2 }"
+ *  entry. The confederation segments are not included.
+ */
+func GetASList(pathAttrs []BGPPathAttr) []string {
+	asList := make([]string, 0)
+	for _, attr := range pathAttrs {
+		if attr.GetCode() != BGPPathAttrTypeASPath {
+			continue
+		}
+
+		for _, asSegment := range attr.(*BGPPathAttrASPath).Value {
+			segType := asSegment.GetType()
+			if segType != BGPASPathSegmentSet && segType != BGPASPathSegmentSequence {
+				continue
+			}
+
+			ases := make([]string, 0, asSegment.GetLen())
+			switch seg := asSegment.(type) {
+			case *BGPAS4PathSegment:
+				for _, as := range seg.AS {
+					ases = append(ases, strconv.FormatUint(uint64(as), 10))
+				}
+			case *BGPAS2PathSegment:
+				for _, as := range seg.AS {
+					ases = append(ases, strconv.FormatUint(uint64(as), 10))
+				}
+			}
+			if segType == BGPASPathSegmentSet {
+				asList = append(asList, "{ "+strings.Join(ases, ", ")+" }")
+			} else {
+				asList = append(asList, ases...)
+			}
+		}
+		break
+	}
+
+	return asList
 }
 
 func GetNumASes(pathAttrs []BGPPathAttr) uint32 {
@@ -219,6 +425,9 @@ func GetNumASes(pathAttrs []BGPPathAttr) uint32 {
 		if attr.GetCode() == BGPPathAttrTypeASPath {
 			asPaths := attr.(*BGPPathAttrASPath).Value
 			for _, asPath := range asPaths {
+				if asPath.GetType().IsConfed() {
+					continue
+				}
 				total += uint32(asPath.GetNumASes())
 			}
 			break
@@ -252,12 +461,32 @@ func GetNextHop(pathAttrs []BGPPathAttr) net.IP {
 	for _, attr := range pathAttrs {
 		if attr.GetCode() == BGPPathAttrTypeNextHop {
 			return attr.(*BGPPathAttrNextHop).Value
+		} else if attr.GetCode() == BGPPathAttrTypeMPReachNLRI {
+			return attr.(*BGPPathAttrMPReachNLRI).GetNextHop()
 		}
 	}
 
 	return net.IPv4zero
 }
 
+func GetMPReachNLRI(pathAttrs []BGPPathAttr) *BGPPathAttrMPReachNLRI {
+	for _, attr := range pathAttrs {
+		if attr.GetCode() == BGPPathAttrTypeMPReachNLRI {
+			return attr.(*BGPPathAttrMPReachNLRI)
+		}
+	}
+	return nil
+}
+
+func GetMPUnreachNLRI(pathAttrs []BGPPathAttr) *BGPPathAttrMPUnreachNLRI {
+	for _, attr := range pathAttrs {
+		if attr.GetCode() == BGPPathAttrTypeMPUnreachNLRI {
+			return attr.(*BGPPathAttrMPUnreachNLRI)
+		}
+	}
+	return nil
+}
+
 func GetNumClusters(pathAttrs []BGPPathAttr) uint16 {
 	var total uint16 = 0
 	for _, attr := range pathAttrs {
@@ -271,6 +500,450 @@ func GetNumClusters(pathAttrs []BGPPathAttr) uint16 {
 	return total
 }
 
+func ClonePathAttrs(pathAttrs []BGPPathAttr) []BGPPathAttr {
+	clonedAttrs := make([]BGPPathAttr, 0, len(pathAttrs))
+	for _, pa := range pathAttrs {
+		clonedAttrs = append(clonedAttrs, pa.Clone())
+	}
+	return clonedAttrs
+}
+
+// PathAttrsEqual returns true if both the lists have the same path attrs with the same encoding.
+func PathAttrsEqual(pathAttrs1, pathAttrs2 []BGPPathAttr) bool {
+	if len(pathAttrs1) != len(pathAttrs2) {
+		return false
+	}
+
+	for idx, pa := range pathAttrs1 {
+		if pa.GetCode() != pathAttrs2[idx].GetCode() {
+			return false
+		}
+		pkt1, err1 := pa.Encode()
+		pkt2, err2 := pathAttrs2[idx].Encode()
+		if err1 != nil || err2 != nil || !bytes.Equal(pkt1, pkt2) {
+			return false
+		}
+	}
+	return true
+}
+
+func insertPathAttr(pathAttrs []BGPPathAttr, attr BGPPathAttr) []BGPPathAttr {
+	idx := len(pathAttrs)
+	for i, pa := range pathAttrs {
+		if pa.GetCode() > attr.GetCode() {
+			idx = i
+			break
+		}
+	}
+
+	pathAttrs = append(pathAttrs, nil)
+	copy(pathAttrs[idx+1:], pathAttrs[idx:])
+	pathAttrs[idx] = attr
+	return pathAttrs
+}
+
+func removePathAttrFromPathAttrs(pathAttrs []BGPPathAttr, code BGPPathAttrType) []BGPPathAttr {
+	for idx, pa := range pathAttrs {
+		if pa.GetCode() == code {
+			return append(pathAttrs[:idx], pathAttrs[idx+1:]...)
+		}
+	}
+	return pathAttrs
+}
+
+func GetCommunities(pathAttrs []BGPPathAttr) []uint32 {
+	for _, attr := range pathAttrs {
+		if attr.GetCode() == BGPPathAttrTypeCommunities {
+			return attr.(*BGPPathAttrCommunities).Value
+		}
+	}
+
+	return nil
+}
+
+func HasCommunity(pathAttrs []BGPPathAttr, community uint32) bool {
+	for _, val := range GetCommunities(pathAttrs) {
+		if val == community {
+			return true
+		}
+	}
+	return false
+}
+
+func getOrAddCommunitiesPathAttr(pathAttrs []BGPPathAttr) ([]BGPPathAttr, *BGPPathAttrCommunities) {
+	for _, attr := range pathAttrs {
+		if attr.GetCode() == BGPPathAttrTypeCommunities {
+			return pathAttrs, attr.(*BGPPathAttrCommunities)
+		}
+	}
+
+	communities := NewBGPPathAttrCommunities()
+	return insertPathAttr(pathAttrs, communities), communities
+}
+
+// SetCommunities replaces the communities in the path attrs. An empty list removes the COMMUNITIES attr.
+func SetCommunities(pathAttrs []BGPPathAttr, communities []uint32) []BGPPathAttr {
+	if len(communities) == 0 {
+		return removePathAttrFromPathAttrs(pathAttrs, BGPPathAttrTypeCommunities)
+	}
+
+	pathAttrs, communitiesAttr := getOrAddCommunitiesPathAttr(pathAttrs)
+	communitiesAttr.SetCommunities(communities)
+	return pathAttrs
+}
+
+func AddCommunities(pathAttrs []BGPPathAttr, communities []uint32) []BGPPathAttr {
+	if len(communities) == 0 {
+		return pathAttrs
+	}
+
+	pathAttrs, communitiesAttr := getOrAddCommunitiesPathAttr(pathAttrs)
+	for _, community := range communities {
+		communitiesAttr.AddCommunity(community)
+	}
+	return pathAttrs
+}
+
+func RemoveCommunities(pathAttrs []BGPPathAttr, communities []uint32) []BGPPathAttr {
+	for _, attr := range pathAttrs {
+		if attr.GetCode() == BGPPathAttrTypeCommunities {
+			communitiesAttr := attr.(*BGPPathAttrCommunities)
+			for _, community := range communities {
+				communitiesAttr.RemoveCommunity(community)
+			}
+			if len(communitiesAttr.Value) == 0 {
+				return removePathAttrFromPathAttrs(pathAttrs, BGPPathAttrTypeCommunities)
+			}
+			break
+		}
+	}
+	return pathAttrs
+}
+
+// SetLocalPrefInPathAttrs sets the LOCAL_PREF in the path attrs, the attr is added when it's not present.
+func SetLocalPrefInPathAttrs(pathAttrs []BGPPathAttr, pref uint32) []BGPPathAttr {
+	for _, attr := range pathAttrs {
+		if attr.GetCode() == BGPPathAttrTypeLocalPref {
+			attr.(*BGPPathAttrLocalPref).Value = pref
+			return pathAttrs
+		}
+	}
+
+	localPref := NewBGPPathAttrLocalPref()
+	localPref.Value = pref
+	return insertPathAttr(pathAttrs, localPref)
+}
+
+// SetMEDInPathAttrs sets the MULTI_EXIT_DISC in the path attrs, the attr is added when it's not present.
+func SetMEDInPathAttrs(pathAttrs []BGPPathAttr, med uint32) []BGPPathAttr {
+	for _, attr := range pathAttrs {
+		if attr.GetCode() == BGPPathAttrTypeMultiExitDisc {
+			attr.(*BGPPathAttrMultiExitDisc).Value = med
+			return pathAttrs
+		}
+	}
+
+	multiExitDisc := NewBGPPathAttrMultiExitDisc()
+	multiExitDisc.Value = med
+	return insertPathAttr(pathAttrs, multiExitDisc)
+}
+
+// RemoveLocalPrefFromPathAttrs returns a copy of the path attrs without the LOCAL_PREF attr.
+func RemoveLocalPrefFromPathAttrs(pathAttrs []BGPPathAttr) []BGPPathAttr {
+	for idx, attr := range pathAttrs {
+		if attr.GetCode() == BGPPathAttrTypeLocalPref {
+			newAttrs := make([]BGPPathAttr, 0, len(pathAttrs)-1)
+			newAttrs = append(newAttrs, pathAttrs[:idx]...)
+			return append(newAttrs, pathAttrs[idx+1:]...)
+		}
+	}
+	return pathAttrs
+}
+
+// ParseCommunity converts a community string, either one of the well-known names,
+// a 32 bit value or in the AA:NN format, to its 32 bit value.
+func ParseCommunity(str string) (uint32, error) {
+	str = strings.ToLower(strings.TrimSpace(str))
+	for community, name := range BGPWellKnownCommunityToStr {
+		if str == name {
+			return community, nil
+		}
+	}
+
+	tokens := strings.Split(str, ":")
+	if len(tokens) == 1 {
+		val, err := strconv.ParseUint(tokens[0], 10, 32)
+		if err != nil {
+			return 0, errors.New(fmt.Sprintf("Community %s is not valid", str))
+		}
+		return uint32(val), nil
+	} else if len(tokens) == 2 {
+		as, err := strconv.ParseUint(tokens[0], 10, 16)
+		if err != nil {
+			return 0, errors.New(fmt.Sprintf("AS in community %s is not valid", str))
+		}
+		val, err := strconv.ParseUint(tokens[1], 10, 16)
+		if err != nil {
+			return 0, errors.New(fmt.Sprintf("Value in community %s is not valid", str))
+		}
+		return uint32(as)<<16 | uint32(val), nil
+	}
+
+	return 0, errors.New(fmt.Sprintf("Community %s is not valid", str))
+}
+
+func CommunityToString(community uint32) string {
+	if name, ok := BGPWellKnownCommunityToStr[community]; ok {
+		return name
+	}
+	return fmt.Sprintf("%d:%d", community>>16, community&0xFFFF)
+}
+
+func GetExtCommunities(pathAttrs []BGPPathAttr) []uint64 {
+	for _, attr := range pathAttrs {
+		if attr.GetCode() == BGPPathAttrTypeExtCommunities {
+			return attr.(*BGPPathAttrExtCommunities).Value
+		}
+	}
+
+	return nil
+}
+
+func HasExtCommunity(pathAttrs []BGPPathAttr, extCommunity uint64) bool {
+	for _, val := range GetExtCommunities(pathAttrs) {
+		if val == extCommunity {
+			return true
+		}
+	}
+	return false
+}
+
+func getOrAddExtCommunitiesPathAttr(pathAttrs []BGPPathAttr) ([]BGPPathAttr, *BGPPathAttrExtCommunities) {
+	for _, attr := range pathAttrs {
+		if attr.GetCode() == BGPPathAttrTypeExtCommunities {
+			return pathAttrs, attr.(*BGPPathAttrExtCommunities)
+		}
+	}
+
+	extCommunities := NewBGPPathAttrExtCommunities()
+	return insertPathAttr(pathAttrs, extCommunities), extCommunities
+}
+
+func SetExtCommunities(pathAttrs []BGPPathAttr, extCommunities []uint64) []BGPPathAttr {
+	if len(extCommunities) == 0 {
+		return removePathAttrFromPathAttrs(pathAttrs, BGPPathAttrTypeExtCommunities)
+	}
+
+	pathAttrs, extCommunitiesAttr := getOrAddExtCommunitiesPathAttr(pathAttrs)
+	extCommunitiesAttr.SetExtCommunities(extCommunities)
+	return pathAttrs
+}
+
+func AddExtCommunities(pathAttrs []BGPPathAttr, extCommunities []uint64) []BGPPathAttr {
+	if len(extCommunities) == 0 {
+		return pathAttrs
+	}
+
+	pathAttrs, extCommunitiesAttr := getOrAddExtCommunitiesPathAttr(pathAttrs)
+	for _, extCommunity := range extCommunities {
+		extCommunitiesAttr.AddExtCommunity(extCommunity)
+	}
+	return pathAttrs
+}
+
+func RemoveExtCommunities(pathAttrs []BGPPathAttr, extCommunities []uint64) []BGPPathAttr {
+	for _, attr := range pathAttrs {
+		if attr.GetCode() == BGPPathAttrTypeExtCommunities {
+			extCommunitiesAttr := attr.(*BGPPathAttrExtCommunities)
+			for _, extCommunity := range extCommunities {
+				extCommunitiesAttr.RemoveExtCommunity(extCommunity)
+			}
+			if len(extCommunitiesAttr.Value) == 0 {
+				return removePathAttrFromPathAttrs(pathAttrs, BGPPathAttrTypeExtCommunities)
+			}
+			break
+		}
+	}
+	return pathAttrs
+}
+
+// RemoveNonTransitiveExtCommunities removes the extended communities that must not be
+// advertised outside of the AS.
+func RemoveNonTransitiveExtCommunities(pathAttrs []BGPPathAttr) []BGPPathAttr {
+	nonTransitive := make([]uint64, 0)
+	for _, extCommunity := range GetExtCommunities(pathAttrs) {
+		if uint8(extCommunity>>56)&BGPExtCommunityNonTransitive != 0 {
+			nonTransitive = append(nonTransitive, extCommunity)
+		}
+	}
+	return RemoveExtCommunities(pathAttrs, nonTransitive)
+}
+
+// ParseExtCommunity converts a route target or route origin string in the format
+// rt|ro:ASN:NN or rt|ro:IPv4:NN to its 64 bit extended community value. An ASN larger
+// than 65535 is encoded as a four octet AS specific extended community.
+func ParseExtCommunity(str string) (uint64, error) {
+	tokens := strings.Split(strings.ToLower(strings.TrimSpace(str)), ":")
+	if len(tokens) != 3 {
+		return 0, errors.New(fmt.Sprintf("Extended community %s is not valid", str))
+	}
+
+	var subType uint8
+	switch tokens[0] {
+	case "rt":
+		subType = BGPExtCommunitySubTypeRouteTarget
+	case "ro":
+		subType = BGPExtCommunitySubTypeRouteOrigin
+	default:
+		return 0, errors.New(fmt.Sprintf("Extended community type %s is not supported", tokens[0]))
+	}
+
+	if ip := net.ParseIP(tokens[1]).To4(); ip != nil {
+		val, err := strconv.ParseUint(tokens[2], 10, 16)
+		if err != nil {
+			return 0, errors.New(fmt.Sprintf("Value in extended community %s is not valid", str))
+		}
+		return uint64(BGPExtCommunityTypeIPv4Addr)<<56 | uint64(subType)<<48 |
+			uint64(binary.BigEndian.Uint32(ip))<<16 | val, nil
+	}
+
+	as, err := strconv.ParseUint(tokens[1], 10, 32)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("AS in extended community %s is not valid", str))
+	}
+
+	if as > math.MaxUint16 {
+		val, err := strconv.ParseUint(tokens[2], 10, 16)
+		if err != nil {
+			return 0, errors.New(fmt.Sprintf("Value in extended community %s is not valid", str))
+		}
+		return uint64(BGPExtCommunityTypeFourOctetAS)<<56 | uint64(subType)<<48 | as<<16 | val, nil
+	}
+
+	val, err := strconv.ParseUint(tokens[2], 10, 32)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("Value in extended community %s is not valid", str))
+	}
+	return uint64(BGPExtCommunityTypeTwoOctetAS)<<56 | uint64(subType)<<48 | as<<32 | val, nil
+}
+
+func ExtCommunityToString(extCommunity uint64) string {
+	extType := uint8(extCommunity>>56) &^ BGPExtCommunityNonTransitive
+	subType := uint8(extCommunity >> 48)
+	name, ok := BGPExtCommunitySubTypeToStr[subType]
+	if !ok {
+		return fmt.Sprintf("0x%016x", extCommunity)
+	}
+
+	switch extType {
+	case BGPExtCommunityTypeTwoOctetAS:
+		return fmt.Sprintf("%s:%d:%d", name, (extCommunity>>32)&0xFFFF, extCommunity&0xFFFFFFFF)
+	case BGPExtCommunityTypeIPv4Addr:
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, uint32(extCommunity>>16))
+		return fmt.Sprintf("%s:%s:%d", name, ip, extCommunity&0xFFFF)
+	case BGPExtCommunityTypeFourOctetAS:
+		return fmt.Sprintf("%s:%d:%d", name, uint32(extCommunity>>16), extCommunity&0xFFFF)
+	}
+	return fmt.Sprintf("0x%016x", extCommunity)
+}
+
+func GetLargeCommunities(pathAttrs []BGPPathAttr) []BGPLargeCommunity {
+	for _, attr := range pathAttrs {
+		if attr.GetCode() == BGPPathAttrTypeLargeCommunities {
+			return attr.(*BGPPathAttrLargeCommunities).Value
+		}
+	}
+
+	return nil
+}
+
+func HasLargeCommunity(pathAttrs []BGPPathAttr, largeCommunity BGPLargeCommunity) bool {
+	for _, val := range GetLargeCommunities(pathAttrs) {
+		if val == largeCommunity {
+			return true
+		}
+	}
+	return false
+}
+
+func getOrAddLargeCommunitiesPathAttr(pathAttrs []BGPPathAttr) ([]BGPPathAttr, *BGPPathAttrLargeCommunities) {
+	for _, attr := range pathAttrs {
+		if attr.GetCode() == BGPPathAttrTypeLargeCommunities {
+			return pathAttrs, attr.(*BGPPathAttrLargeCommunities)
+		}
+	}
+
+	largeCommunities := NewBGPPathAttrLargeCommunities()
+	return insertPathAttr(pathAttrs, largeCommunities), largeCommunities
+}
+
+func SetLargeCommunities(pathAttrs []BGPPathAttr, largeCommunities []BGPLargeCommunity) []BGPPathAttr {
+	if len(largeCommunities) == 0 {
+		return removePathAttrFromPathAttrs(pathAttrs, BGPPathAttrTypeLargeCommunities)
+	}
+
+	pathAttrs, largeCommunitiesAttr := getOrAddLargeCommunitiesPathAttr(pathAttrs)
+	largeCommunitiesAttr.SetLargeCommunities(largeCommunities)
+	return pathAttrs
+}
+
+func AddLargeCommunities(pathAttrs []BGPPathAttr, largeCommunities []BGPLargeCommunity) []BGPPathAttr {
+	if len(largeCommunities) == 0 {
+		return pathAttrs
+	}
+
+	pathAttrs, largeCommunitiesAttr := getOrAddLargeCommunitiesPathAttr(pathAttrs)
+	for _, largeCommunity := range largeCommunities {
+		largeCommunitiesAttr.AddLargeCommunity(largeCommunity)
+	}
+	return pathAttrs
+}
+
+func RemoveLargeCommunities(pathAttrs []BGPPathAttr, largeCommunities []BGPLargeCommunity) []BGPPathAttr {
+	for _, attr := range pathAttrs {
+		if attr.GetCode() == BGPPathAttrTypeLargeCommunities {
+			largeCommunitiesAttr := attr.(*BGPPathAttrLargeCommunities)
+			for _, largeCommunity := range largeCommunities {
+				largeCommunitiesAttr.RemoveLargeCommunity(largeCommunity)
+			}
+			if len(largeCommunitiesAttr.Value) == 0 {
+				return removePathAttrFromPathAttrs(pathAttrs, BGPPathAttrTypeLargeCommunities)
+			}
+			break
+		}
+	}
+	return pathAttrs
+}
+
+// ParseLargeCommunity converts a large community string in the GA:LD1:LD2 format.
+func ParseLargeCommunity(str string) (BGPLargeCommunity, error) {
+	var largeCommunity BGPLargeCommunity
+	tokens := strings.Split(strings.TrimSpace(str), ":")
+	if len(tokens) != 3 {
+		return largeCommunity, errors.New(fmt.Sprintf("Large community %s is not valid", str))
+	}
+
+	vals := make([]uint32, len(tokens))
+	for idx, token := range tokens {
+		val, err := strconv.ParseUint(token, 10, 32)
+		if err != nil {
+			return largeCommunity, errors.New(fmt.Sprintf("Large community %s is not valid", str))
+		}
+		vals[idx] = uint32(val)
+	}
+
+	largeCommunity.GlobalAdmin = vals[0]
+	largeCommunity.LocalData1 = vals[1]
+	largeCommunity.LocalData2 = vals[2]
+	return largeCommunity, nil
+}
+
+func LargeCommunityToString(largeCommunity BGPLargeCommunity) string {
+	return fmt.Sprintf("%d:%d:%d", largeCommunity.GlobalAdmin, largeCommunity.LocalData1,
+		largeCommunity.LocalData2)
+}
+
 var AggRoutesDefaultBGPPathAttr = map[BGPPathAttrType]BGPPathAttr{
 	BGPPathAttrTypeOrigin:     NewBGPPathAttrOrigin(BGPPathAttrOriginIncomplete),
 	BGPPathAttrTypeASPath:     NewBGPPathAttrASPath(),
@@ -421,7 +1094,12 @@ func ConstructPathAttrForConnRoutes(ip net.IP, as uint32) []BGPPathAttr {
 
 func ConstructIPPrefix(ipStr string, maskStr string) *IPPrefix {
 	ip := net.ParseIP(ipStr)
-	mask := net.IPMask(net.ParseIP(maskStr).To4())
+	var mask net.IPMask
+	if ip.To4() != nil {
+		mask = net.IPMask(net.ParseIP(maskStr).To4())
+	} else {
+		mask = net.IPMask(net.ParseIP(maskStr).To16())
+	}
 	ones, _ := mask.Size()
 	return NewIPPrefix(ip.Mask(mask), uint8(ones))
 }
@@ -434,7 +1112,11 @@ func ConstructIPPrefixFromCIDR(cidr string) (*IPPrefix, error) {
 	}
 
 	ones, _ := ipNet.Mask.Size()
-	return NewIPPrefix(ipNet.IP, uint8(ones)), nil
+	ipPrefix := NewIPPrefix(ipNet.IP, uint8(ones))
+	if len(ipNet.IP) == net.IPv6len {
+		ipPrefix.SetAFI(AfiIP6)
+	}
+	return ipPrefix, nil
 }
 
 func AddOriginatorId(updateMsg *BGPMessage, id net.IP) bool {
@@ -506,12 +1188,16 @@ func ConvertIPBytesToUint(bytes []byte) uint32 {
 	return uint32(bytes[0])<<24 | uint32(bytes[1]<<16) | uint32(bytes[2]<<8) | uint32(bytes[3])
 }
 
-func ConstructOptParams(as uint32, afiSAfiMap map[uint32]bool, addPathsRx bool, addPathsMaxTx uint8) []BGPOptParam {
+func ConstructOptParams(as uint32, afiSAfiMap map[uint32]bool, addPathsRx bool, addPathsMaxTx uint8,
+	gracefulRestart bool, restartTime uint16, restarting bool, extendedNextHop bool,
+	prefixORFFlags uint8) []BGPOptParam {
 	optParams := make([]BGPOptParam, 0)
 	capParams := make([]BGPCapability, 0)
 
 	cap4ByteASPath := NewBGPCap4ByteASPath(as)
 	capParams = append(capParams, cap4ByteASPath)
+	capParams = append(capParams, NewBGPCapRouteRefresh())
+	capParams = append(capParams, NewBGPCapEnhancedRouteRefresh())
 	capAddPaths := NewBGPCapAddPath()
 	addPathFlags := uint8(0)
 	if addPathsRx {
@@ -521,21 +1207,55 @@ func ConstructOptParams(as uint32, afiSAfiMap map[uint32]bool, addPathsRx bool,
 		addPathFlags |= BGPCapAddPathTx
 	}
 
+	// Forwarding state is preserved only across a restart of the local speaker
+	capGracefulRestart := NewBGPCapGracefulRestart(restarting, restartTime)
+	grAfiSafiFlags := uint8(0)
+	if restarting {
+		grAfiSafiFlags |= BGPCapGracefulRestartFlagForwarding
+	}
+	capORF := NewBGPCapORF()
+
 	for protoFamily, _ := range afiSAfiMap {
 		afi, safi := GetAfiSafi(protoFamily)
 		utils.Logger.Info(fmt.Sprintf("Advertising capability for afi %d safi %d\n", afi, safi))
 		capAfiSafi := NewBGPCapMPExt(afi, safi)
 		capParams = append(capParams, capAfiSafi)
 
-		addPathAfiSafi := NewAddPathAFISAFI(afi, safi, addPathFlags)
-		capAddPaths.AddAddPathAFISAFI(addPathAfiSafi)
+		if IsAddPathsFamily(afi, safi) {
+			addPathAfiSafi := NewAddPathAFISAFI(afi, safi, addPathFlags)
+			capAddPaths.AddAddPathAFISAFI(addPathAfiSafi)
+		}
+
+		grAfiSafi := NewGracefulRestartAFISAFI(afi, safi, grAfiSafiFlags)
+		capGracefulRestart.AddGracefulRestartAFISAFI(grAfiSafi)
+
+		if prefixORFFlags != 0 && IsPrefixORFFamily(afi, safi) {
+			capORF.AddORFAFISAFI(NewORFAFISAFI(afi, safi, BGPORFTypeAddressPrefix, prefixORFFlags))
+		}
 	}
 
-	if addPathFlags != 0 {
+	if addPathFlags != 0 && len(capAddPaths.Value) > 0 {
 		utils.Logger.Info(fmt.Sprintf("Advertising capability for addPaths %+v\n", capAddPaths.Value))
 		capParams = append(capParams, capAddPaths)
 	}
 
+	if len(capORF.Value) > 0 {
+		utils.Logger.Info(fmt.Sprintf("Advertising capability for outbound route filtering %+v\n", capORF.Value))
+		capParams = append(capParams, capORF)
+	}
+
+	if gracefulRestart {
+		utils.Logger.Info(fmt.Sprintf("Advertising capability for graceful restart %+v\n", capGracefulRestart))
+		capParams = append(capParams, capGracefulRestart)
+	}
+
+	if extendedNextHop && afiSAfiMap[GetProtocolFamily(AfiIP, SafiUnicast)] {
+		capExtendedNextHop := NewBGPCapExtendedNextHop()
+		capExtendedNextHop.AddExtendedNextHopAFISAFI(AfiIP, SafiUnicast, AfiIP6)
+		utils.Logger.Info(fmt.Sprintf("Advertising capability for extended next hop %+v\n", capExtendedNextHop.Value))
+		capParams = append(capParams, capExtendedNextHop)
+	}
+
 	optCapability := NewBGPOptParamCapability(capParams)
 	optParams = append(optParams, optCapability)
 
@@ -557,6 +1277,68 @@ func GetASSize(openMsg *BGPOpen) uint8 {
 	return 2
 }
 
+func GetPeerAS(openMsg *BGPOpen) uint32 {
+	for _, optParam := range openMsg.OptParams {
+		if optParam.GetCode() == BGPOptParamTypeCapability {
+			capabilities := optParam.(*BGPOptParamCapability)
+			for _, capability := range capabilities.Value {
+				if capability.GetCode() == BGPCapTypeAS4Path {
+					return capability.(*BGPCapAS4Path).Value
+				}
+			}
+		}
+	}
+
+	return openMsg.MyAS
+}
+
+// Data of the Cease NOTIFICATION with subcode Maximum Number of Prefixes Reached (RFC 4486)
+func ConstructMaxPrefixesCeaseData(afi AFI, safi SAFI, maxPrefixes uint32) []byte {
+	data := make([]byte, 7)
+	binary.BigEndian.PutUint16(data[0:2], uint16(afi))
+	data[2] = uint8(safi)
+	binary.BigEndian.PutUint32(data[3:7], maxPrefixes)
+	return data
+}
+
+// Maximum length of the shutdown communication in the Administrative Shutdown Cease NOTIFICATION (RFC 8203)
+const BGPShutdownCommunicationMaxLen = 128
+
+// Data of the Cease NOTIFICATION with subcode Administrative Shutdown (RFC 8203). The UTF-8 message is
+// truncated on a character boundary to BGPShutdownCommunicationMaxLen bytes.
+func ConstructShutdownCommunicationData(msg string) []byte {
+	if msg == "" {
+		return nil
+	}
+
+	length := 0
+	for idx, r := range msg {
+		if idx+utf8.RuneLen(r) > BGPShutdownCommunicationMaxLen {
+			break
+		}
+		length = idx + utf8.RuneLen(r)
+	}
+
+	data := make([]byte, length+1)
+	data[0] = uint8(length)
+	copy(data[1:], msg[:length])
+	return data
+}
+
+// GetShutdownCommunication returns the shutdown communication in the data of an Administrative Shutdown
+// Cease NOTIFICATION. An empty string is returned if the data is not a valid shutdown communication.
+func GetShutdownCommunication(data []byte) string {
+	if len(data) == 0 || int(data[0]) > BGPShutdownCommunicationMaxLen || int(data[0]) > len(data)-1 {
+		return ""
+	}
+
+	msg := data[1 : data[0]+1]
+	if !utf8.Valid(msg) {
+		return ""
+	}
+	return string(msg)
+}
+
 func GetAddPathFamily(openMsg *BGPOpen) map[AFI]map[SAFI]uint8 {
 	addPathFamily := make(map[AFI]map[SAFI]uint8)
 	for _, optParam := range openMsg.OptParams {
@@ -580,6 +1362,223 @@ func GetAddPathFamily(openMsg *BGPOpen) map[AFI]map[SAFI]uint8 {
 	return addPathFamily
 }
 
+func HasCapability(openMsg *BGPOpen, capType BGPCapabilityType) bool {
+	for _, optParam := range openMsg.OptParams {
+		if capabilities, ok := optParam.(*BGPOptParamCapability); ok {
+			for _, capability := range capabilities.Value {
+				if capability.GetCode() == capType {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func GetGracefulRestartCap(openMsg *BGPOpen) *BGPCapGracefulRestart {
+	for _, optParam := range openMsg.OptParams {
+		if capabilities, ok := optParam.(*BGPOptParamCapability); ok {
+			for _, capability := range capabilities.Value {
+				if grCap, ok := capability.(*BGPCapGracefulRestart); ok {
+					utils.Logger.Info(fmt.Sprintf("graceful restart capability = %+v\n", grCap))
+					return grCap
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func GetExtendedNextHopCap(openMsg *BGPOpen) *BGPCapExtendedNextHop {
+	for _, optParam := range openMsg.OptParams {
+		if capabilities, ok := optParam.(*BGPOptParamCapability); ok {
+			for _, capability := range capabilities.Value {
+				if extNextHopCap, ok := capability.(*BGPCapExtendedNextHop); ok {
+					utils.Logger.Info(fmt.Sprintf("extended next hop capability = %+v\n", extNextHopCap))
+					return extNextHopCap
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func IsEndOfRIB(updateMsg *BGPUpdate) bool {
+	return len(updateMsg.WithdrawnRoutes) == 0 && len(updateMsg.PathAttributes) == 0 && len(updateMsg.NLRI) == 0
+}
+
+func GetEndOfRIBFamily(updateMsg *BGPUpdate) (uint32, bool) {
+	if IsEndOfRIB(updateMsg) {
+		return GetProtocolFamily(AfiIP, SafiUnicast), true
+	}
+
+	if len(updateMsg.WithdrawnRoutes) == 0 && len(updateMsg.NLRI) == 0 && len(updateMsg.PathAttributes) == 1 {
+		if mpUnreach, ok := updateMsg.PathAttributes[0].(*BGPPathAttrMPUnreachNLRI); ok && len(mpUnreach.NLRI) == 0 {
+			return GetProtocolFamily(mpUnreach.AFI, mpUnreach.SAFI), true
+		}
+	}
+	return 0, false
+}
+
+func isMPPathAttr(pa BGPPathAttr) bool {
+	return pa.GetCode() == BGPPathAttrTypeMPReachNLRI || pa.GetCode() == BGPPathAttrTypeMPUnreachNLRI
+}
+
+/*  SplitMPUpdate normalizes a received update message into one update per address family.
+ *  The prefixes carried in MP_REACH_NLRI and MP_UNREACH_NLRI are moved to the NLRI and the
+ *  withdrawn routes of the update for that family. The path attrs of a multiprotocol family
+ *  keep the MP_REACH_NLRI attr, without the NLRI, to carry the next hop.
+ */
+func SplitMPUpdate(updateMsg *BGPUpdate) map[uint32]*BGPUpdate {
+	updates := make(map[uint32]*BGPUpdate)
+	mpReach := GetMPReachNLRI(updateMsg.PathAttributes)
+	mpUnreach := GetMPUnreachNLRI(updateMsg.PathAttributes)
+	if mpReach == nil && mpUnreach == nil {
+		updates[GetProtocolFamily(AfiIP, SafiUnicast)] = updateMsg
+		return updates
+	}
+
+	if len(updateMsg.WithdrawnRoutes) > 0 || len(updateMsg.NLRI) > 0 {
+		pathAttrs := make([]BGPPathAttr, 0, len(updateMsg.PathAttributes))
+		if len(updateMsg.NLRI) > 0 {
+			for _, pa := range updateMsg.PathAttributes {
+				if !isMPPathAttr(pa) {
+					pathAttrs = append(pathAttrs, pa)
+				}
+			}
+		}
+		updates[GetProtocolFamily(AfiIP, SafiUnicast)] = &BGPUpdate{
+			WithdrawnRoutes: updateMsg.WithdrawnRoutes,
+			PathAttributes:  pathAttrs,
+			NLRI:            updateMsg.NLRI,
+		}
+	}
+
+	if mpUnreach != nil && len(mpUnreach.NLRI) > 0 {
+		protoFamily := GetProtocolFamily(mpUnreach.AFI, mpUnreach.SAFI)
+		updates[protoFamily] = &BGPUpdate{
+			WithdrawnRoutes: mpUnreach.NLRI,
+			PathAttributes:  make([]BGPPathAttr, 0),
+			NLRI:            make([]NLRI, 0),
+		}
+	}
+
+	if mpReach != nil && len(mpReach.NLRI) > 0 {
+		protoFamily := GetProtocolFamily(mpReach.AFI, mpReach.SAFI)
+		pathAttrs := make([]BGPPathAttr, 0, len(updateMsg.PathAttributes))
+		for _, pa := range updateMsg.PathAttributes {
+			if pa.GetCode() == BGPPathAttrTypeMPReachNLRI {
+				mpReachAttr := pa.Clone().(*BGPPathAttrMPReachNLRI)
+				mpReachAttr.SetNLRIList(make([]NLRI, 0))
+				pathAttrs = append(pathAttrs, mpReachAttr)
+			} else if pa.GetCode() != BGPPathAttrTypeNextHop && pa.GetCode() != BGPPathAttrTypeMPUnreachNLRI {
+				pathAttrs = append(pathAttrs, pa)
+			}
+		}
+
+		update, ok := updates[protoFamily]
+		if !ok {
+			update = &BGPUpdate{
+				WithdrawnRoutes: make([]NLRI, 0),
+			}
+			updates[protoFamily] = update
+		}
+		update.PathAttributes = pathAttrs
+		update.NLRI = mpReach.NLRI
+	}
+
+	return updates
+}
+
+/*  Constructs the update messages of an address family from an update returned by
+ *  SplitMPUpdate. The NLRI and the withdrawn routes of the families other than IPv4
+ *  unicast are moved to the MP_REACH_NLRI and MP_UNREACH_NLRI attributes.
+ */
+func ConstructFamilyUpdatePackets(protoFamily uint32, updateMsg *BGPUpdate) []*BGPMessage {
+	afi, safi := GetAfiSafi(protoFamily)
+	pathAttrs := make([]BGPPathAttr, 0, len(updateMsg.PathAttributes)+1)
+	pathAttrs = append(pathAttrs, updateMsg.PathAttributes...)
+	if afi != AfiIP || safi != SafiUnicast {
+		if len(updateMsg.NLRI) > 0 && GetMPReachNLRI(pathAttrs) == nil {
+			pathAttrs = ConstructPathAttrsForFamily(pathAttrs, afi, safi)
+		}
+		if len(updateMsg.WithdrawnRoutes) > 0 && GetMPUnreachNLRI(pathAttrs) == nil {
+			pathAttrs = append(pathAttrs, NewBGPPathAttrMPUnreachNLRI(afi, safi))
+		}
+	}
+
+	bgpMsg := NewBGPUpdateMessage(updateMsg.WithdrawnRoutes, pathAttrs, updateMsg.NLRI)
+	return ConstructMaxSizedUpdatePackets(bgpMsg)
+}
+
+/*  ConstructPathAttrsForFamily returns a copy of the path attrs to advertise the prefixes of
+ *  an address family. IPv4 unicast prefixes use the NEXT_HOP attr and all the other families
+ *  use the MP_REACH_NLRI attr for the next hop.
+ */
+func ConstructPathAttrsForFamily(pathAttrs []BGPPathAttr, afi AFI, safi SAFI) []BGPPathAttr {
+	newPathAttrs := make([]BGPPathAttr, 0, len(pathAttrs)+1)
+	if afi == AfiIP && safi == SafiUnicast {
+		var mpReach *BGPPathAttrMPReachNLRI
+		hasNextHop := false
+		for _, pa := range pathAttrs {
+			if !isMPPathAttr(pa) {
+				newPathAttrs = append(newPathAttrs, pa.Clone())
+				if pa.GetCode() == BGPPathAttrTypeNextHop {
+					hasNextHop = true
+				}
+			} else if pa.GetCode() == BGPPathAttrTypeMPReachNLRI {
+				mpReach = pa.(*BGPPathAttrMPReachNLRI)
+			}
+		}
+
+		// IPv4 prefixes received with an IPv6 next hop don't have the NEXT_HOP attr
+		if !hasNextHop && mpReach != nil {
+			nextHop := NewBGPPathAttrNextHop()
+			nextHop.Value = mpReach.GetNextHop().To4()
+			if nextHop.Value == nil {
+				nextHop.Value = net.IPv4zero.To4()
+			}
+			newPathAttrs = insertPathAttr(newPathAttrs, nextHop)
+		}
+		return newPathAttrs
+	}
+
+	return ConstructMPPathAttrs(pathAttrs, afi, safi)
+}
+
+/*  ConstructMPPathAttrs returns a copy of the path attrs that carries the next hop in the
+ *  MP_REACH_NLRI attr for the address family. It is also used to send IPv4 prefixes with
+ *  an IPv6 next hop to the peers that negotiated the extended next hop encoding.
+ */
+func ConstructMPPathAttrs(pathAttrs []BGPPathAttr, afi AFI, safi SAFI) []BGPPathAttr {
+	newPathAttrs := make([]BGPPathAttr, 0, len(pathAttrs)+1)
+	var nextHop net.IP
+	var mpReach *BGPPathAttrMPReachNLRI
+	for _, pa := range pathAttrs {
+		if pa.GetCode() == BGPPathAttrTypeNextHop {
+			nextHop = pa.(*BGPPathAttrNextHop).Value
+		} else if pa.GetCode() == BGPPathAttrTypeMPReachNLRI {
+			mpReach = pa.Clone().(*BGPPathAttrMPReachNLRI)
+			mpReach.AFI = afi
+			mpReach.SAFI = safi
+			mpReach.SetNLRIList(make([]NLRI, 0))
+			newPathAttrs = append(newPathAttrs, mpReach)
+		} else if pa.GetCode() != BGPPathAttrTypeMPUnreachNLRI {
+			newPathAttrs = append(newPathAttrs, pa.Clone())
+		}
+	}
+
+	if mpReach == nil {
+		mpReach = NewBGPPathAttrMPReachNLRI(afi, safi)
+		if nextHop == nil {
+			nextHop = net.IPv6zero
+		}
+		mpReach.SetNextHop(nextHop.To16(), nil)
+		newPathAttrs = insertPathAttr(newPathAttrs, mpReach)
+	}
+	return newPathAttrs
+}
+
 func IsAddPathsTxEnabledForIPv4(addPathFamily map[AFI]map[SAFI]uint8) bool {
 	enabled := false
 	if _, ok := addPathFamily[AfiIP]; ok {
@@ -593,6 +1592,19 @@ func IsAddPathsTxEnabledForIPv4(addPathFamily map[AFI]map[SAFI]uint8) bool {
 	return enabled
 }
 
+// IsAddPathsFamily returns true for the families the paths are sent and received with path ids
+func IsAddPathsFamily(afi AFI, safi SAFI) bool {
+	return (afi == AfiIP || afi == AfiIP6) && safi == SafiUnicast
+}
+
+// IsAddPathsEnabled returns true when the add paths flag is set for the family in the capability
+func IsAddPathsEnabled(addPathFamily map[AFI]map[SAFI]uint8, afi AFI, safi SAFI, flag uint8) bool {
+	if safiMap, ok := addPathFamily[afi]; ok {
+		return safiMap[safi]&flag != 0
+	}
+	return false
+}
+
 func GetNumASesByASType(updateMsg *BGPMessage, asType BGPPathAttrType) uint32 {
 	var total uint32 = 0
 
@@ -758,6 +1770,97 @@ func NormalizeASPath(updateMsg *BGPMessage, data interface{}) {
 	}
 }
 
+/*  constructMaxSizedMPUpdatePackets moves the withdrawn routes and the NLRI of a normalized
+ *  multiprotocol update message to the MP_UNREACH_NLRI and MP_REACH_NLRI path attrs and
+ *  splits them in to update messages that fit in the max BGP message size.
+ */
+func constructMaxSizedMPUpdatePackets(updateMsg *BGPUpdate) []*BGPMessage {
+	newUpdateMsgs := make([]*BGPMessage, 0)
+	mpReach := GetMPReachNLRI(updateMsg.PathAttributes)
+	mpUnreach := GetMPUnreachNLRI(updateMsg.PathAttributes)
+
+	if len(updateMsg.WithdrawnRoutes) > 0 {
+		afi, safi := AfiIP6, SafiUnicast
+		if mpUnreach != nil {
+			afi, safi = mpUnreach.AFI, mpUnreach.SAFI
+		} else if mpReach != nil {
+			afi, safi = mpReach.AFI, mpReach.SAFI
+		}
+
+		// 1 byte for the extended length, 3 bytes for AFI and SAFI
+		pktLen := uint32(BGPUpdateMsgMinLen) + 4 + 3
+		startIdx := 0
+		lastIdx := 0
+		for lastIdx = 0; lastIdx < len(updateMsg.WithdrawnRoutes); lastIdx++ {
+			nlriLen := updateMsg.WithdrawnRoutes[lastIdx].Len()
+			if nlriLen+pktLen > BGPMsgMaxLen {
+				unreach := NewBGPPathAttrMPUnreachNLRI(afi, safi)
+				unreach.SetNLRIList(updateMsg.WithdrawnRoutes[startIdx:lastIdx])
+				newMsg := NewBGPUpdateMessage(make([]NLRI, 0), []BGPPathAttr{unreach}, make([]NLRI, 0))
+				newUpdateMsgs = append(newUpdateMsgs, newMsg)
+				startIdx = lastIdx
+				pktLen = uint32(BGPUpdateMsgMinLen) + 4 + 3
+			}
+			pktLen += nlriLen
+		}
+
+		if lastIdx > startIdx {
+			unreach := NewBGPPathAttrMPUnreachNLRI(afi, safi)
+			unreach.SetNLRIList(updateMsg.WithdrawnRoutes[startIdx:lastIdx])
+			newMsg := NewBGPUpdateMessage(make([]NLRI, 0), []BGPPathAttr{unreach}, make([]NLRI, 0))
+			newUpdateMsgs = append(newUpdateMsgs, newMsg)
+		}
+	}
+
+	if len(updateMsg.NLRI) == 0 || mpReach == nil {
+		return newUpdateMsgs
+	}
+
+	paLen := uint32(0)
+	for i := 0; i < len(updateMsg.PathAttributes); i++ {
+		if !isMPPathAttr(updateMsg.PathAttributes[i]) {
+			paLen += updateMsg.PathAttributes[i].TotalLen()
+		}
+	}
+
+	// 1 byte for the extended length of MP_REACH_NLRI
+	mpReachLen := mpReach.TotalLen() + 1
+	pktLen := uint32(BGPUpdateMsgMinLen) + paLen + mpReachLen
+	startIdx := 0
+	lastIdx := 0
+	for lastIdx = 0; lastIdx <= len(updateMsg.NLRI); lastIdx++ {
+		if lastIdx < len(updateMsg.NLRI) {
+			nlriLen := updateMsg.NLRI[lastIdx].Len()
+			if nlriLen+pktLen <= BGPMsgMaxLen {
+				pktLen += nlriLen
+				continue
+			}
+		}
+
+		if lastIdx > startIdx {
+			pathAttrs := make([]BGPPathAttr, 0, len(updateMsg.PathAttributes))
+			for _, pa := range updateMsg.PathAttributes {
+				if pa.GetCode() == BGPPathAttrTypeMPReachNLRI {
+					reach := pa.Clone().(*BGPPathAttrMPReachNLRI)
+					reach.SetNLRIList(updateMsg.NLRI[startIdx:lastIdx])
+					pathAttrs = append(pathAttrs, reach)
+				} else if pa.GetCode() != BGPPathAttrTypeMPUnreachNLRI {
+					pathAttrs = append(pathAttrs, pa)
+				}
+			}
+			newMsg := NewBGPUpdateMessage(make([]NLRI, 0), pathAttrs, make([]NLRI, 0))
+			newUpdateMsgs = append(newUpdateMsgs, newMsg)
+		}
+
+		if lastIdx < len(updateMsg.NLRI) {
+			startIdx = lastIdx
+			pktLen = uint32(BGPUpdateMsgMinLen) + paLen + mpReachLen + updateMsg.NLRI[lastIdx].Len()
+		}
+	}
+
+	return newUpdateMsgs
+}
+
 func ConstructMaxSizedUpdatePackets(bgpMsg *BGPMessage) []*BGPMessage {
 	var withdrawnRoutes []NLRI
 	newUpdateMsgs := make([]*BGPMessage, 0)
@@ -766,6 +1869,19 @@ func ConstructMaxSizedUpdatePackets(bgpMsg *BGPMessage) []*BGPMessage {
 	lastIdx := 0
 	updateMsg := bgpMsg.Body.(*BGPUpdate)
 
+	if IsEndOfRIB(updateMsg) {
+		newUpdateMsgs = append(newUpdateMsgs, bgpMsg)
+		return newUpdateMsgs
+	}
+
+	if GetMPReachNLRI(updateMsg.PathAttributes) != nil || GetMPUnreachNLRI(updateMsg.PathAttributes) != nil {
+		if len(updateMsg.WithdrawnRoutes) == 0 && len(updateMsg.NLRI) == 0 {
+			newUpdateMsgs = append(newUpdateMsgs, bgpMsg)
+			return newUpdateMsgs
+		}
+		return constructMaxSizedMPUpdatePackets(updateMsg)
+	}
+
 	if updateMsg.WithdrawnRoutes != nil {
 		for lastIdx = 0; lastIdx < len(updateMsg.WithdrawnRoutes); lastIdx++ {
 			nlriLen := updateMsg.WithdrawnRoutes[lastIdx].Len()
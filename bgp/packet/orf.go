@@ -0,0 +1,432 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// orf.go
+package packet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// Outbound route filtering (RFC 5291) with the address prefix ORF type (RFC 5292)
+const (
+	BGPORFTypeAddressPrefix uint8 = 64
+)
+
+// Send/Receive flags of the ORF type in the ORF capability
+const (
+	BGPORFCapReceive uint8 = 1 << iota
+	BGPORFCapSend
+)
+
+// When-to-refresh field of a ROUTE-REFRESH message that carries ORF entries
+const (
+	BGPORFWhenImmediate uint8 = 1
+	BGPORFWhenDefer     uint8 = 2
+)
+
+const (
+	BGPORFActionAdd uint8 = iota
+	BGPORFActionRemove
+	BGPORFActionRemoveAll
+)
+
+const (
+	BGPORFMatchPermit uint8 = iota
+	BGPORFMatchDeny
+)
+
+type ORFTypeFlags struct {
+	Type  uint8
+	Flags uint8
+}
+
+type ORFAFISAFI struct {
+	AFI  AFI
+	SAFI SAFI
+	ORFs []ORFTypeFlags
+}
+
+func (o *ORFAFISAFI) Encode(pkt []byte) error {
+	binary.BigEndian.PutUint16(pkt, uint16(o.AFI))
+	pkt[2] = 0
+	pkt[3] = uint8(o.SAFI)
+	pkt[4] = uint8(len(o.ORFs))
+	for idx, orf := range o.ORFs {
+		pkt[5+idx*2] = orf.Type
+		pkt[6+idx*2] = orf.Flags
+	}
+	return nil
+}
+
+func (o *ORFAFISAFI) Decode(pkt []byte) error {
+	if len(pkt) < 5 {
+		return BGPMessageError{BGPOpenMsgError, BGPUnspecific, nil, "Not enough data to decode ORF capability"}
+	}
+
+	o.AFI = AFI(binary.BigEndian.Uint16(pkt))
+	o.SAFI = SAFI(pkt[3])
+	numORFs := int(pkt[4])
+	if len(pkt) < 5+numORFs*2 {
+		return BGPMessageError{BGPOpenMsgError, BGPUnspecific, nil, "Not enough data to decode ORF types"}
+	}
+
+	o.ORFs = make([]ORFTypeFlags, 0, numORFs)
+	for idx := 0; idx < numORFs; idx++ {
+		o.ORFs = append(o.ORFs, ORFTypeFlags{pkt[5+idx*2], pkt[6+idx*2]})
+	}
+	return nil
+}
+
+func (o *ORFAFISAFI) Len() uint8 {
+	return 5 + uint8(len(o.ORFs))*2
+}
+
+func NewORFAFISAFI(afi AFI, safi SAFI, orfType uint8, flags uint8) *ORFAFISAFI {
+	return &ORFAFISAFI{
+		AFI:  afi,
+		SAFI: safi,
+		ORFs: []ORFTypeFlags{ORFTypeFlags{orfType, flags}},
+	}
+}
+
+type BGPCapORF struct {
+	BGPCapabilityBase
+	Value []ORFAFISAFI
+}
+
+func (msg *BGPCapORF) New() BGPCapability {
+	return &BGPCapORF{}
+}
+
+func (msg *BGPCapORF) Encode() ([]byte, error) {
+	pkt, err := msg.BGPCapabilityBase.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	offset := uint8(2)
+	for _, val := range msg.Value {
+		val.Encode(pkt[offset:])
+		offset += val.Len()
+	}
+	return pkt, nil
+}
+
+func (msg *BGPCapORF) Decode(pkt []byte) error {
+	err := msg.BGPCapabilityBase.Decode(pkt)
+	if err != nil {
+		return err
+	}
+
+	offset := uint16(2)
+	for offset < msg.TotalLen() {
+		orfAFISAFI := ORFAFISAFI{}
+		err := orfAFISAFI.Decode(pkt[offset:msg.TotalLen()])
+		if err != nil {
+			return err
+		}
+		msg.Value = append(msg.Value, orfAFISAFI)
+		offset += uint16(orfAFISAFI.Len())
+	}
+	return nil
+}
+
+func (msg *BGPCapORF) AddORFAFISAFI(orfAFISAFI *ORFAFISAFI) {
+	msg.Value = append(msg.Value, *orfAFISAFI)
+	msg.Len += orfAFISAFI.Len()
+}
+
+func NewBGPCapORF() *BGPCapORF {
+	return &BGPCapORF{
+		BGPCapabilityBase: BGPCapabilityBase{
+			Type: BGPCapTypeOutboundRouteFilter,
+			Len:  0,
+		},
+		Value: make([]ORFAFISAFI, 0),
+	}
+}
+
+// IsPrefixORFFamily returns true for the families that support the address prefix ORF
+func IsPrefixORFFamily(afi AFI, safi SAFI) bool {
+	return (afi == AfiIP || afi == AfiIP6) && safi == SafiUnicast
+}
+
+// GetPrefixORFFamily returns the Send/Receive flags of the address prefix ORF per family in the OPEN message
+func GetPrefixORFFamily(openMsg *BGPOpen) map[uint32]uint8 {
+	orfFamily := make(map[uint32]uint8)
+	for _, optParam := range openMsg.OptParams {
+		if capabilities, ok := optParam.(*BGPOptParamCapability); ok {
+			for _, capability := range capabilities.Value {
+				if orfCap, ok := capability.(*BGPCapORF); ok {
+					for _, val := range orfCap.Value {
+						for _, orf := range val.ORFs {
+							if orf.Type == BGPORFTypeAddressPrefix {
+								orfFamily[GetProtocolFamily(val.AFI, val.SAFI)] |= orf.Flags
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return orfFamily
+}
+
+/*  Address prefix ORF entry (RFC 5292). The entry matches the routes covered by the prefix
+ *  with a length between MinLen and MaxLen, a zero MinLen stands for the length of the prefix
+ *  and a zero MaxLen for the max length of the address family. When both are zero only the
+ *  prefix itself matches. The Remove-All entry has no prefix.
+ */
+type AddressPrefixORFEntry struct {
+	Action   uint8
+	Match    uint8
+	Sequence uint32
+	MinLen   uint8
+	MaxLen   uint8
+	Prefix   IPPrefix
+}
+
+func (e *AddressPrefixORFEntry) Encode() ([]byte, error) {
+	pkt := make([]byte, e.Len())
+	pkt[0] = e.Action<<6 | e.Match<<5
+	if e.Action == BGPORFActionRemoveAll {
+		return pkt, nil
+	}
+
+	binary.BigEndian.PutUint32(pkt[1:5], e.Sequence)
+	pkt[5] = e.MinLen
+	pkt[6] = e.MaxLen
+	prefix, err := e.Prefix.Encode()
+	if err != nil {
+		return nil, err
+	}
+	copy(pkt[7:], prefix)
+	return pkt, nil
+}
+
+func (e *AddressPrefixORFEntry) Decode(pkt []byte, afi AFI) error {
+	if len(pkt) < 1 {
+		return BGPMessageError{BGPRouteRefreshMsgError, BGPRouteRefreshInvalidMsgLen, nil,
+			"Not enough data to decode address prefix ORF entry"}
+	}
+
+	e.Action = pkt[0] >> 6
+	e.Match = (pkt[0] >> 5) & 0x1
+	if e.Action == BGPORFActionRemoveAll {
+		return nil
+	}
+
+	if len(pkt) < 8 {
+		return BGPMessageError{BGPRouteRefreshMsgError, BGPRouteRefreshInvalidMsgLen, nil,
+			"Not enough data to decode address prefix ORF entry"}
+	}
+	e.Sequence = binary.BigEndian.Uint32(pkt[1:5])
+	e.MinLen = pkt[5]
+	e.MaxLen = pkt[6]
+	err := e.Prefix.decodeIPPrefix(pkt[7:], afi)
+	if err != nil {
+		return BGPMessageError{BGPRouteRefreshMsgError, BGPRouteRefreshInvalidMsgLen, nil,
+			fmt.Sprintf("Failed to decode the prefix of address prefix ORF entry, err: %s", err)}
+	}
+	return nil
+}
+
+func (e *AddressPrefixORFEntry) Len() uint32 {
+	if e.Action == BGPORFActionRemoveAll {
+		return 1
+	}
+	return 7 + e.Prefix.Len()
+}
+
+func (e *AddressPrefixORFEntry) String() string {
+	if e.Action == BGPORFActionRemoveAll {
+		return "remove-all"
+	}
+
+	match := "permit"
+	if e.Match == BGPORFMatchDeny {
+		match = "deny"
+	}
+	return fmt.Sprintf("seq %d %s %s/%d ge %d le %d", e.Sequence, match, e.Prefix.Prefix, e.Prefix.Length,
+		e.MinLen, e.MaxLen)
+}
+
+// MatchPrefix returns true when the prefix is matched by the entry, the prefix and the entry must have the same AFI
+func (e *AddressPrefixORFEntry) MatchPrefix(ipPrefix *IPPrefix) bool {
+	afi := e.Prefix.GetAFI()
+	if afi != ipPrefix.GetAFI() {
+		return false
+	}
+
+	entryPrefix, ip := e.Prefix.Prefix.To16(), ipPrefix.Prefix.To16()
+	maxLen := uint8(8 * net.IPv6len)
+	if afi == AfiIP {
+		entryPrefix, ip = e.Prefix.Prefix.To4(), ipPrefix.Prefix.To4()
+		maxLen = 8 * net.IPv4len
+	}
+	length := ipPrefix.Length
+
+	mask := net.CIDRMask(int(e.Prefix.Length), int(maxLen))
+	if length < e.Prefix.Length || !ip.Mask(mask).Equal(entryPrefix.Mask(mask)) {
+		return false
+	}
+
+	if e.MinLen == 0 && e.MaxLen == 0 {
+		return length == e.Prefix.Length
+	}
+	minLen := e.MinLen
+	if minLen == 0 {
+		minLen = e.Prefix.Length
+	}
+	if e.MaxLen != 0 {
+		maxLen = e.MaxLen
+	}
+	return length >= minLen && length <= maxLen
+}
+
+func NewAddressPrefixORFEntry(action uint8, match uint8, sequence uint32, prefix net.IP, length uint8,
+	minLen uint8, maxLen uint8) *AddressPrefixORFEntry {
+	return &AddressPrefixORFEntry{
+		Action:   action,
+		Match:    match,
+		Sequence: sequence,
+		MinLen:   minLen,
+		MaxLen:   maxLen,
+		Prefix:   IPPrefix{Length: length, Prefix: prefix},
+	}
+}
+
+func isSameORFEntry(e1 *AddressPrefixORFEntry, e2 *AddressPrefixORFEntry) bool {
+	return e1.Sequence == e2.Sequence && e1.Match == e2.Match && e1.MinLen == e2.MinLen &&
+		e1.MaxLen == e2.MaxLen && e1.Prefix.Length == e2.Prefix.Length && e1.Prefix.Prefix.Equal(e2.Prefix.Prefix)
+}
+
+/*  Applies the received ORF entries to the address prefix ORF of a family and returns the
+ *  new list ordered by sequence number. An added entry replaces the entry with the same
+ *  sequence number. The list passed in is not modified.
+ */
+func UpdateAddressPrefixORF(entries []*AddressPrefixORFEntry,
+	updates []*AddressPrefixORFEntry) []*AddressPrefixORFEntry {
+	orf := make([]*AddressPrefixORFEntry, 0, len(entries)+len(updates))
+	orf = append(orf, entries...)
+	for _, update := range updates {
+		switch update.Action {
+		case BGPORFActionRemoveAll:
+			orf = make([]*AddressPrefixORFEntry, 0, len(updates))
+
+		case BGPORFActionRemove:
+			for idx, entry := range orf {
+				if isSameORFEntry(entry, update) {
+					orf = append(orf[:idx:idx], orf[idx+1:]...)
+					break
+				}
+			}
+
+		case BGPORFActionAdd:
+			idx := 0
+			for idx < len(orf) && orf[idx].Sequence < update.Sequence {
+				idx++
+			}
+			if idx < len(orf) && orf[idx].Sequence == update.Sequence {
+				orf[idx] = update
+				continue
+			}
+			orf = append(orf, nil)
+			copy(orf[idx+1:], orf[idx:])
+			orf[idx] = update
+		}
+	}
+	return orf
+}
+
+// IsPrefixPermittedByORF returns false when the first entry that matches the prefix denies it
+// or no entry matches it. All the prefixes are permitted when the ORF has no entries.
+func IsPrefixPermittedByORF(entries []*AddressPrefixORFEntry, prefix *IPPrefix) bool {
+	if len(entries) == 0 {
+		return true
+	}
+
+	for _, entry := range entries {
+		if entry.MatchPrefix(prefix) {
+			return entry.Match == BGPORFMatchPermit
+		}
+	}
+	return false
+}
+
+func encodeAddressPrefixORF(entries []*AddressPrefixORFEntry) ([]byte, error) {
+	length := uint32(0)
+	for _, entry := range entries {
+		length += entry.Len()
+	}
+
+	pkt := make([]byte, 3, 3+length)
+	pkt[0] = BGPORFTypeAddressPrefix
+	binary.BigEndian.PutUint16(pkt[1:3], uint16(length))
+	for _, entry := range entries {
+		entryPkt, err := entry.Encode()
+		if err != nil {
+			return nil, err
+		}
+		pkt = append(pkt, entryPkt...)
+	}
+	return pkt, nil
+}
+
+// Decodes the ORFs of a ROUTE-REFRESH message, the ORF types other than address prefix are ignored
+func decodeAddressPrefixORF(pkt []byte, afi AFI) ([]*AddressPrefixORFEntry, error) {
+	entries := make([]*AddressPrefixORFEntry, 0)
+	for len(pkt) > 0 {
+		if len(pkt) < 3 {
+			return nil, BGPMessageError{BGPRouteRefreshMsgError, BGPRouteRefreshInvalidMsgLen, nil,
+				"Not enough data to decode ORF type and length"}
+		}
+
+		orfType := pkt[0]
+		length := int(binary.BigEndian.Uint16(pkt[1:3]))
+		if len(pkt) < 3+length {
+			return nil, BGPMessageError{BGPRouteRefreshMsgError, BGPRouteRefreshInvalidMsgLen, nil,
+				fmt.Sprintf("ORF type %d has length %d, only %d bytes left", orfType, length, len(pkt)-3)}
+		}
+
+		orfPkt := pkt[3 : 3+length]
+		pkt = pkt[3+length:]
+		if orfType != BGPORFTypeAddressPrefix {
+			continue
+		}
+
+		for len(orfPkt) > 0 {
+			entry := &AddressPrefixORFEntry{}
+			err := entry.Decode(orfPkt, afi)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+			orfPkt = orfPkt[entry.Len():]
+		}
+	}
+	return entries, nil
+}
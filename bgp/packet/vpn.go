@@ -0,0 +1,333 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// vpn.go
+package packet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	MPLSLabelWithdraw      uint32 = 0x800000 // Label field of the withdrawn routes (RFC 3107)
+	MPLSLabelMax           uint32 = 0xFFFFF
+	mplsLabelLen                  = 3
+	mplsLabelBottomOfStack        = 0x01
+	routeDistinguisherLen         = 8
+)
+
+// RouteDistinguisher is the 8 byte route distinguisher of a VPN route (RFC 4364), the
+// type in the first 2 bytes and the administrator and the assigned number in the rest.
+type RouteDistinguisher uint64
+
+const (
+	RouteDistinguisherTypeTwoOctetAS uint16 = iota
+	RouteDistinguisherTypeIPv4Addr
+	RouteDistinguisherTypeFourOctetAS
+)
+
+// ParseRouteDistinguisher converts a route distinguisher string in the format ASN:NN or
+// IPv4:NN. An ASN larger than 65535 is encoded as a type 2 route distinguisher.
+func ParseRouteDistinguisher(str string) (RouteDistinguisher, error) {
+	// The administrator and the assigned number are encoded like in the route targets
+	extCommunity, err := ParseExtCommunity("rt:" + str)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("Route distinguisher %s is not valid", str))
+	}
+
+	rdType := uint64(extCommunity>>56) & 0xFF
+	return RouteDistinguisher(rdType<<48 | extCommunity&0xFFFFFFFFFFFF), nil
+}
+
+// ParseRouteTarget converts a route target string in the format ASN:NN or IPv4:NN to the
+// route target extended community.
+func ParseRouteTarget(str string) (uint64, error) {
+	return ParseExtCommunity("rt:" + str)
+}
+
+func (rd RouteDistinguisher) Type() uint16 {
+	return uint16(rd >> 48)
+}
+
+func (rd RouteDistinguisher) String() string {
+	extCommunity := uint64(rd.Type())<<56 | uint64(BGPExtCommunitySubTypeRouteTarget)<<48 |
+		uint64(rd)&0xFFFFFFFFFFFF
+	return strings.TrimPrefix(ExtCommunityToString(extCommunity), "rt:")
+}
+
+func encodeMPLSLabels(pkt []byte, labels []uint32) {
+	for idx, label := range labels {
+		val := label<<4 | mplsLabelBottomOfStack
+		if label == MPLSLabelWithdraw {
+			val = MPLSLabelWithdraw
+		} else if idx != len(labels)-1 {
+			val = label << 4
+		}
+		pkt[idx*mplsLabelLen] = uint8(val >> 16)
+		pkt[idx*mplsLabelLen+1] = uint8(val >> 8)
+		pkt[idx*mplsLabelLen+2] = uint8(val)
+	}
+}
+
+/*  Decodes the label stack at the start of a labeled NLRI, the labels are read till the
+ *  bottom of stack bit. The withdrawn routes may carry the 0x800000 label without the
+ *  bottom of stack bit (RFC 8277), it is decoded as MPLSLabelWithdraw.
+ */
+func decodeMPLSLabels(pkt []byte, maxBits int) ([]uint32, error) {
+	labels := make([]uint32, 0, 1)
+	for idx := 0; ; idx += mplsLabelLen {
+		if len(pkt) < idx+mplsLabelLen || (idx+mplsLabelLen)*8 > maxBits {
+			return nil, BGPMessageError{BGPUpdateMsgError, BGPInvalidNetworkField, nil,
+				"NLRI does not contain the bottom of the label stack"}
+		}
+
+		val := uint32(pkt[idx])<<16 | uint32(pkt[idx+1])<<8 | uint32(pkt[idx+2])
+		if val == MPLSLabelWithdraw {
+			labels = append(labels, MPLSLabelWithdraw)
+			return labels, nil
+		}
+
+		labels = append(labels, val>>4)
+		if val&mplsLabelBottomOfStack != 0 {
+			return labels, nil
+		}
+	}
+}
+
+func encodeLabeledPrefix(labels []uint32, rd []byte, ip *IPPrefix) ([]byte, error) {
+	prefix, err := ip.getPrefixBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	prefixLen := int(ip.Length+7) / 8
+	pkt := make([]byte, 1+len(labels)*mplsLabelLen+len(rd)+prefixLen)
+	pkt[0] = uint8(len(labels)*mplsLabelLen*8+len(rd)*8) + ip.Length
+	encodeMPLSLabels(pkt[1:], labels)
+	idx := 1 + len(labels)*mplsLabelLen
+	idx += copy(pkt[idx:], rd)
+	copy(pkt[idx:], prefix[:prefixLen])
+	return pkt, nil
+}
+
+/*  Decodes a labeled prefix (RFC 8277) and the route distinguisher of the VPN prefixes
+ *  (RFC 4364). The length in the first byte covers the labels, the route distinguisher
+ *  and the prefix bits.
+ */
+func decodeLabeledPrefix(pkt []byte, afi AFI, rdLen int) ([]uint32, []byte, *IPPrefix, error) {
+	if len(pkt) < 1 {
+		return nil, nil, nil, BGPMessageError{BGPUpdateMsgError, BGPInvalidNetworkField, nil,
+			"NLRI does not contain prefix length"}
+	}
+
+	totalBits := int(pkt[0])
+	labels, err := decodeMPLSLabels(pkt[1:], totalBits)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	idx := 1 + len(labels)*mplsLabelLen
+	prefixBits := totalBits - (len(labels)*mplsLabelLen+rdLen)*8
+	if prefixBits < 0 || len(pkt) < idx+rdLen {
+		return nil, nil, nil, BGPMessageError{BGPUpdateMsgError, BGPInvalidNetworkField, nil,
+			"NLRI does not contain the route distinguisher"}
+	}
+	rd := pkt[idx : idx+rdLen]
+	idx += rdLen
+
+	ipPkt := make([]byte, 0, len(pkt)-idx+1)
+	ipPkt = append(ipPkt, uint8(prefixBits))
+	ipPkt = append(ipPkt, pkt[idx:]...)
+	ip := &IPPrefix{}
+	if err = ip.decodeIPPrefix(ipPkt, afi); err != nil {
+		return nil, nil, nil, err
+	}
+	return labels, rd, ip, nil
+}
+
+// LabeledPrefix is the NLRI of the labeled unicast routes, SAFI 4 (RFC 8277)
+type LabeledPrefix struct {
+	IPPrefix
+	Labels []uint32
+}
+
+func (l *LabeledPrefix) Clone() NLRI {
+	x := *l
+	prefix := l.IPPrefix.Clone()
+	x.IPPrefix = *prefix.(*IPPrefix)
+	x.Labels = make([]uint32, len(l.Labels))
+	copy(x.Labels, l.Labels)
+	return &x
+}
+
+func (l *LabeledPrefix) Len() uint32 {
+	return l.IPPrefix.Len() + uint32(len(l.Labels)*mplsLabelLen)
+}
+
+func (l *LabeledPrefix) Encode() ([]byte, error) {
+	return encodeLabeledPrefix(l.Labels, nil, &l.IPPrefix)
+}
+
+func (l *LabeledPrefix) Decode(pkt []byte) error {
+	return l.decodeLabeledPrefix(pkt, AfiIP)
+}
+
+func (l *LabeledPrefix) decodeLabeledPrefix(pkt []byte, afi AFI) error {
+	labels, _, ip, err := decodeLabeledPrefix(pkt, afi, 0)
+	if err != nil {
+		return err
+	}
+
+	l.Labels = labels
+	l.IPPrefix = *ip
+	return nil
+}
+
+func (l *LabeledPrefix) GetPrefix() *IPPrefix {
+	return &l.IPPrefix
+}
+
+func (l *LabeledPrefix) GetPathId() uint32 {
+	return 0
+}
+
+func NewLabeledPrefix(prefix IPPrefix, labels []uint32) *LabeledPrefix {
+	return &LabeledPrefix{
+		IPPrefix: prefix,
+		Labels:   labels,
+	}
+}
+
+// VPNPrefix is the NLRI of the MPLS VPN routes, SAFI 128 (RFC 4364 and RFC 4659)
+type VPNPrefix struct {
+	LabeledPrefix
+	RD RouteDistinguisher
+}
+
+func (v *VPNPrefix) Clone() NLRI {
+	x := *v
+	labeled := v.LabeledPrefix.Clone()
+	x.LabeledPrefix = *labeled.(*LabeledPrefix)
+	return &x
+}
+
+func (v *VPNPrefix) Len() uint32 {
+	return v.LabeledPrefix.Len() + routeDistinguisherLen
+}
+
+func (v *VPNPrefix) Encode() ([]byte, error) {
+	rd := make([]byte, routeDistinguisherLen)
+	binary.BigEndian.PutUint64(rd, uint64(v.RD))
+	return encodeLabeledPrefix(v.Labels, rd, &v.IPPrefix)
+}
+
+func (v *VPNPrefix) Decode(pkt []byte) error {
+	return v.decodeVPNPrefix(pkt, AfiIP)
+}
+
+func (v *VPNPrefix) decodeVPNPrefix(pkt []byte, afi AFI) error {
+	labels, rd, ip, err := decodeLabeledPrefix(pkt, afi, routeDistinguisherLen)
+	if err != nil {
+		return err
+	}
+
+	v.Labels = labels
+	v.RD = RouteDistinguisher(binary.BigEndian.Uint64(rd))
+	v.IPPrefix = *ip
+	return nil
+}
+
+/*  GetNLRIKey returns the key of the destination of the NLRI in a rib. The labeled unicast
+ *  routes of a prefix are kept apart from its unicast routes and the VPN routes of a prefix
+ *  are kept apart by their route distinguisher. The EVPN routes are keyed by their route key.
+ */
+func GetNLRIKey(nlri NLRI) string {
+	switch n := nlri.(type) {
+	case *LabeledPrefix:
+		return "labeled:" + n.Prefix.String()
+	case *VPNPrefix:
+		return n.RD.String() + ":" + n.Prefix.String()
+	case *EVPNNLRI:
+		return n.String()
+	}
+	return nlri.GetPrefix().Prefix.String()
+}
+
+func NewVPNPrefix(rd RouteDistinguisher, prefix IPPrefix, labels []uint32) *VPNPrefix {
+	return &VPNPrefix{
+		LabeledPrefix: LabeledPrefix{
+			IPPrefix: prefix,
+			Labels:   labels,
+		},
+		RD: rd,
+	}
+}
+
+// GetNLRILabels returns the label stack of a labeled NLRI and nil for the unlabeled NLRI
+func GetNLRILabels(nlri NLRI) []uint32 {
+	switch prefix := nlri.(type) {
+	case *LabeledPrefix:
+		return prefix.Labels
+	case *VPNPrefix:
+		return prefix.Labels
+	}
+	return nil
+}
+
+// GetRouteTargets returns the route target extended communities of the path attrs
+func GetRouteTargets(pathAttrs []BGPPathAttr) []uint64 {
+	routeTargets := make([]uint64, 0)
+	for _, extCommunity := range GetExtCommunities(pathAttrs) {
+		extType := uint8(extCommunity >> 56)
+		if uint8(extCommunity>>48) == BGPExtCommunitySubTypeRouteTarget && (extType == BGPExtCommunityTypeTwoOctetAS ||
+			extType == BGPExtCommunityTypeIPv4Addr || extType == BGPExtCommunityTypeFourOctetAS) {
+			routeTargets = append(routeTargets, extCommunity)
+		}
+	}
+	return routeTargets
+}
+
+func isVPNNextHop(safi SAFI, nextHopLen uint8) bool {
+	return safi == SafiMPLSVPN && (nextHopLen == routeDistinguisherLen+net.IPv4len ||
+		nextHopLen == routeDistinguisherLen+net.IPv6len || nextHopLen == 2*(routeDistinguisherLen+net.IPv6len))
+}
+
+// The next hop of the VPN routes is a VPN address with a route distinguisher of 0 (RFC 4364)
+func (r *BGPPathAttrMPReachNLRI) decodeVPNNextHop(pkt []byte) {
+	ipLen := net.IPv6len
+	if len(pkt) == routeDistinguisherLen+net.IPv4len {
+		ipLen = net.IPv4len
+	}
+
+	r.NextHop = make(net.IP, ipLen)
+	copy(r.NextHop, pkt[routeDistinguisherLen:routeDistinguisherLen+ipLen])
+	r.LinkLocalNextHop = nil
+	if len(pkt) == 2*(routeDistinguisherLen+net.IPv6len) {
+		r.LinkLocalNextHop = make(net.IP, net.IPv6len)
+		copy(r.LinkLocalNextHop, pkt[2*routeDistinguisherLen+net.IPv6len:])
+	}
+}
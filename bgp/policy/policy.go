@@ -58,6 +58,16 @@ type BGPPolicyEngine struct {
 	StmtDelCh       chan string
 	DefinitionDelCh chan string
 	policyMgr       config.PolicyMgrIntf
+
+	RoutePolicyDB       *RoutePolicyDB
+	RouteConditionCfgCh chan config.RoutePolicyConditionConfig
+	RouteActionCfgCh    chan config.RoutePolicyActionConfig
+	RouteStmtCfgCh      chan config.RoutePolicyStmtConfig
+	RoutePolicyCfgCh    chan config.RoutePolicyConfig
+	RouteConditionDelCh chan string
+	RouteActionDelCh    chan string
+	RouteStmtDelCh      chan string
+	RoutePolicyDelCh    chan string
 }
 
 func NewBGPPolicyEngine(logger *logging.Writer, pMgr config.PolicyMgrIntf) *BGPPolicyEngine {
@@ -74,6 +84,15 @@ func NewBGPPolicyEngine(logger *logging.Writer, pMgr config.PolicyMgrIntf) *BGPP
 		bgpPE.StmtDelCh = make(chan string)
 		bgpPE.DefinitionDelCh = make(chan string)
 	    bgpPE.policyMgr = pMgr
+		bgpPE.RoutePolicyDB = NewRoutePolicyDB()
+		bgpPE.RouteConditionCfgCh = make(chan config.RoutePolicyConditionConfig)
+		bgpPE.RouteActionCfgCh = make(chan config.RoutePolicyActionConfig)
+		bgpPE.RouteStmtCfgCh = make(chan config.RoutePolicyStmtConfig)
+		bgpPE.RoutePolicyCfgCh = make(chan config.RoutePolicyConfig)
+		bgpPE.RouteConditionDelCh = make(chan string)
+		bgpPE.RouteActionDelCh = make(chan string)
+		bgpPE.RouteStmtDelCh = make(chan string)
+		bgpPE.RoutePolicyDelCh = make(chan string)
 		PolicyEngine = bgpPE
 	}
 
@@ -122,6 +141,44 @@ func (eng *BGPPolicyEngine) StartPolicyEngine() {
 			eng.logger.Info(fmt.Sprintln("BGPPolicyEngine - delete statment", policyName))
 			policyCfg := utilspolicy.PolicyDefinitionConfig{Name: policyName}
 			eng.PolicyEngine.DeletePolicyDefinition(policyCfg)
+
+		case condCfg := <-eng.RouteConditionCfgCh:
+			eng.logger.Info(fmt.Sprintln("BGPPolicyEngine - create route policy condition", condCfg.Name))
+			if err := eng.RoutePolicyDB.CreateCondition(condCfg); err != nil {
+				eng.logger.Err(fmt.Sprintln("BGPPolicyEngine - failed to create route policy condition",
+					condCfg.Name, "with error", err))
+			}
+
+		case actionCfg := <-eng.RouteActionCfgCh:
+			eng.logger.Info(fmt.Sprintln("BGPPolicyEngine - create route policy action", actionCfg.Name))
+			if err := eng.RoutePolicyDB.CreateAction(actionCfg); err != nil {
+				eng.logger.Err(fmt.Sprintln("BGPPolicyEngine - failed to create route policy action",
+					actionCfg.Name, "with error", err))
+			}
+
+		case stmtCfg := <-eng.RouteStmtCfgCh:
+			eng.logger.Info(fmt.Sprintln("BGPPolicyEngine - create route policy statement", stmtCfg.Name))
+			eng.RoutePolicyDB.CreateStmt(stmtCfg)
+
+		case policyCfg := <-eng.RoutePolicyCfgCh:
+			eng.logger.Info(fmt.Sprintln("BGPPolicyEngine - create route policy", policyCfg.Name))
+			eng.RoutePolicyDB.CreatePolicy(policyCfg)
+
+		case conditionName := <-eng.RouteConditionDelCh:
+			eng.logger.Info(fmt.Sprintln("BGPPolicyEngine - delete route policy condition", conditionName))
+			eng.RoutePolicyDB.DeleteCondition(conditionName)
+
+		case actionName := <-eng.RouteActionDelCh:
+			eng.logger.Info(fmt.Sprintln("BGPPolicyEngine - delete route policy action", actionName))
+			eng.RoutePolicyDB.DeleteAction(actionName)
+
+		case stmtName := <-eng.RouteStmtDelCh:
+			eng.logger.Info(fmt.Sprintln("BGPPolicyEngine - delete route policy statement", stmtName))
+			eng.RoutePolicyDB.DeleteStmt(stmtName)
+
+		case policyName := <-eng.RoutePolicyDelCh:
+			eng.logger.Info(fmt.Sprintln("BGPPolicyEngine - delete route policy", policyName))
+			eng.RoutePolicyDB.DeletePolicy(policyName)
 		}
 	}
 }
@@ -0,0 +1,731 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// routePolicy.go
+package server
+
+import (
+	"errors"
+	"fmt"
+	"l3/bgp/config"
+	"l3/bgp/packet"
+	"l3/bgp/rpki"
+	"math"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// LocalAddress is the local address of the session with the neighbor, IGPCost is the
+// IGP metric to the next hop of the path, set for the export policies
+type RoutePolicyParams struct {
+	Neighbor        net.IP
+	PathAttrs       []packet.BGPPathAttr
+	ValidationState rpki.ValidationState
+	Prefix          *packet.IPPrefix
+	LocalAddress    net.IP
+	IGPCost         uint32
+}
+
+type RoutePolicyCondition struct {
+	config.RoutePolicyConditionConfig
+	communities      []uint32
+	validationStates []rpki.ValidationState
+	prefixes         []*packet.AddressPrefixORFEntry
+	asPathRegexes    []*regexp.Regexp
+	ipNets           []*net.IPNet
+}
+
+type RoutePolicyAction struct {
+	config.RoutePolicyActionConfig
+	communities []uint32
+}
+
+/*  RoutePolicyResult is the result of a route policy evaluated for a route. The actions of the
+ *  matched statements are kept, so they can be applied to the path attrs sent to a neighbor
+ *  without matching the statements of the policy again. Matched is set when the route is
+ *  accepted by a statement of the policy rather than by the default result of the policy.
+ */
+type RoutePolicyResult struct {
+	Accepted bool
+	Matched  bool
+	actions  []*RoutePolicyAction
+}
+
+type RoutePolicyDB struct {
+	mutex      sync.RWMutex
+	Conditions map[string]*RoutePolicyCondition
+	Actions    map[string]*RoutePolicyAction
+	Stmts      map[string]*config.RoutePolicyStmtConfig
+	Policies   map[string]*config.RoutePolicyConfig
+}
+
+func NewRoutePolicyDB() *RoutePolicyDB {
+	return &RoutePolicyDB{
+		Conditions: make(map[string]*RoutePolicyCondition),
+		Actions:    make(map[string]*RoutePolicyAction),
+		Stmts:      make(map[string]*config.RoutePolicyStmtConfig),
+		Policies:   make(map[string]*config.RoutePolicyConfig),
+	}
+}
+
+func parseCommunities(communityStrs []string) ([]uint32, error) {
+	communities := make([]uint32, 0, len(communityStrs))
+	for _, communityStr := range communityStrs {
+		community, err := packet.ParseCommunity(communityStr)
+		if err != nil {
+			return nil, err
+		}
+		communities = append(communities, community)
+	}
+	return communities, nil
+}
+
+// The prefixes are kept as address prefix ORF entries, which match prefixes the same way
+func parsePrefix(prefix config.RoutePolicyPrefix) (*packet.AddressPrefixORFEntry, error) {
+	_, ipNet, err := net.ParseCIDR(prefix.IpPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	length, bits := ipNet.Mask.Size()
+	entry := packet.NewAddressPrefixORFEntry(packet.BGPORFActionAdd, packet.BGPORFMatchPermit, 0, ipNet.IP,
+		uint8(length), 0, 0)
+	if len(ipNet.IP) == net.IPv6len {
+		entry.Prefix.SetAFI(packet.AfiIP6)
+	}
+	if prefix.MasklengthRange == "" || prefix.MasklengthRange == "exact" {
+		return entry, nil
+	}
+
+	lens := strings.Split(prefix.MasklengthRange, "..")
+	if len(lens) != 2 {
+		return nil, errors.New(fmt.Sprintf("Invalid mask length range %s", prefix.MasklengthRange))
+	}
+	minLen, err := strconv.Atoi(lens[0])
+	if err != nil {
+		return nil, err
+	}
+	maxLen, err := strconv.Atoi(lens[1])
+	if err != nil {
+		return nil, err
+	}
+	if minLen < length || maxLen < minLen || maxLen > bits {
+		return nil, errors.New(fmt.Sprintf("Mask length range %s is not valid for prefix %s",
+			prefix.MasklengthRange, prefix.IpPrefix))
+	}
+	entry.MinLen = uint8(minLen)
+	entry.MaxLen = uint8(maxLen)
+	return entry, nil
+}
+
+// The "_" of the Cisco AS path regex matches the start or the end of the AS path or a delimiter
+func parseASPathRegex(regex string) (*regexp.Regexp, error) {
+	return regexp.Compile(strings.Replace(regex, "_", `(^|$|[ ,{}])`, -1))
+}
+
+// The neighbor and next hop addresses are kept as host prefixes
+func parseIPNets(addrs []string) ([]*net.IPNet, error) {
+	ipNets := make([]*net.IPNet, 0, len(addrs))
+	for _, addr := range addrs {
+		if strings.Contains(addr, "/") {
+			_, ipNet, err := net.ParseCIDR(addr)
+			if err != nil {
+				return nil, err
+			}
+			ipNets = append(ipNets, ipNet)
+			continue
+		}
+
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, errors.New(fmt.Sprintf("Invalid address %s", addr))
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		ipNets = append(ipNets, &net.IPNet{IP: ip, Mask: net.CIDRMask(8*len(ip), 8*len(ip))})
+	}
+	return ipNets, nil
+}
+
+func (db *RoutePolicyDB) CreateCondition(cfg config.RoutePolicyConditionConfig) error {
+	condition := &RoutePolicyCondition{RoutePolicyConditionConfig: cfg}
+	switch cfg.ConditionType {
+	case config.RoutePolicyConditionTypeCommunity:
+		communities, err := parseCommunities(cfg.Communities)
+		if err != nil {
+			return err
+		}
+		condition.communities = communities
+
+	case config.RoutePolicyConditionTypeRpkiValidation:
+		for _, stateStr := range cfg.ValidationStates {
+			state, err := rpki.ParseValidationState(stateStr)
+			if err != nil {
+				return err
+			}
+			condition.validationStates = append(condition.validationStates, state)
+		}
+
+	case config.RoutePolicyConditionTypePrefixSet:
+		for _, prefix := range cfg.Prefixes {
+			entry, err := parsePrefix(prefix)
+			if err != nil {
+				return err
+			}
+			condition.prefixes = append(condition.prefixes, entry)
+		}
+
+	case config.RoutePolicyConditionTypeASPathSet:
+		for _, regexStr := range cfg.ASPathRegexes {
+			regex, err := parseASPathRegex(regexStr)
+			if err != nil {
+				return err
+			}
+			condition.asPathRegexes = append(condition.asPathRegexes, regex)
+		}
+
+	case config.RoutePolicyConditionTypeASPathLength:
+		if cfg.Comparison < config.RoutePolicyCompareEq || cfg.Comparison > config.RoutePolicyCompareLe {
+			return errors.New(fmt.Sprintf("Route policy condition %s has unknown comparison %d", cfg.Name,
+				cfg.Comparison))
+		}
+
+	case config.RoutePolicyConditionTypeNeighborSet:
+		ipNets, err := parseIPNets(cfg.Neighbors)
+		if err != nil {
+			return err
+		}
+		condition.ipNets = ipNets
+
+	case config.RoutePolicyConditionTypeNextHopSet:
+		ipNets, err := parseIPNets(cfg.NextHops)
+		if err != nil {
+			return err
+		}
+		condition.ipNets = ipNets
+
+	default:
+		return errors.New(fmt.Sprintf("Route policy condition %s has unknown type %d", cfg.Name,
+			cfg.ConditionType))
+	}
+
+	db.mutex.Lock()
+	db.Conditions[cfg.Name] = condition
+	db.mutex.Unlock()
+	return nil
+}
+
+func (db *RoutePolicyDB) DeleteCondition(name string) {
+	db.mutex.Lock()
+	delete(db.Conditions, name)
+	db.mutex.Unlock()
+}
+
+func (db *RoutePolicyDB) CreateAction(cfg config.RoutePolicyActionConfig) error {
+	action := &RoutePolicyAction{RoutePolicyActionConfig: cfg}
+	switch cfg.ActionType {
+	case config.RoutePolicyActionTypeSetCommunity, config.RoutePolicyActionTypeAddCommunity,
+		config.RoutePolicyActionTypeRemoveCommunity:
+		communities, err := parseCommunities(cfg.Communities)
+		if err != nil {
+			return err
+		}
+		action.communities = communities
+
+	case config.RoutePolicyActionTypeSetLocalPref:
+
+	case config.RoutePolicyActionTypePrependASPath:
+		if cfg.PrependAS == 0 && !cfg.PrependLastAS {
+			return errors.New(fmt.Sprintf("Route policy action %s has no AS to prepend", cfg.Name))
+		}
+		if action.PrependCount == 0 {
+			action.PrependCount = 1
+		}
+
+	case config.RoutePolicyActionTypeSetMED:
+		if cfg.MEDOption < config.MEDActionSet || cfg.MEDOption > config.MEDActionIGPCost {
+			return errors.New(fmt.Sprintf("Route policy action %s has unknown MED option %d", cfg.Name,
+				cfg.MEDOption))
+		}
+
+	case config.RoutePolicyActionTypeSetNextHop:
+		if cfg.NextHopOption < config.NextHopActionSelf || cfg.NextHopOption > config.NextHopActionPeerAddress {
+			return errors.New(fmt.Sprintf("Route policy action %s has unknown next hop option %d", cfg.Name,
+				cfg.NextHopOption))
+		}
+
+	default:
+		return errors.New(fmt.Sprintf("Route policy action %s has unknown type %d", cfg.Name,
+			cfg.ActionType))
+	}
+
+	db.mutex.Lock()
+	db.Actions[cfg.Name] = action
+	db.mutex.Unlock()
+	return nil
+}
+
+func (db *RoutePolicyDB) DeleteAction(name string) {
+	db.mutex.Lock()
+	delete(db.Actions, name)
+	db.mutex.Unlock()
+}
+
+func (db *RoutePolicyDB) CreateStmt(cfg config.RoutePolicyStmtConfig) {
+	db.mutex.Lock()
+	db.Stmts[cfg.Name] = &cfg
+	db.mutex.Unlock()
+}
+
+func (db *RoutePolicyDB) DeleteStmt(name string) {
+	db.mutex.Lock()
+	delete(db.Stmts, name)
+	db.mutex.Unlock()
+}
+
+func (db *RoutePolicyDB) CreatePolicy(cfg config.RoutePolicyConfig) {
+	db.mutex.Lock()
+	db.Policies[cfg.Name] = &cfg
+	db.mutex.Unlock()
+}
+
+func (db *RoutePolicyDB) DeletePolicy(name string) {
+	db.mutex.Lock()
+	delete(db.Policies, name)
+	db.mutex.Unlock()
+}
+
+// matchSet applies the match set option to the number of the set entries that matched
+func (c *RoutePolicyCondition) matchSet(matched, total int) bool {
+	switch c.MatchSetOption {
+	case config.MatchSetOptionAll:
+		return total > 0 && matched == total
+	case config.MatchSetOptionInvert:
+		return matched == 0
+	default:
+		return matched > 0
+	}
+}
+
+func (c *RoutePolicyCondition) matchCommunities(pathAttrs []packet.BGPPathAttr) bool {
+	matched := 0
+	for _, community := range c.communities {
+		if packet.HasCommunity(pathAttrs, community) {
+			matched++
+		}
+	}
+	return c.matchSet(matched, len(c.communities))
+}
+
+func (c *RoutePolicyCondition) matchValidationState(state rpki.ValidationState) bool {
+	for _, validationState := range c.validationStates {
+		if validationState == state {
+			return true
+		}
+	}
+	return false
+}
+
+// The prefix set conditions don't match when the params have no prefix
+func (c *RoutePolicyCondition) matchPrefixes(prefix *packet.IPPrefix) bool {
+	if prefix == nil {
+		return false
+	}
+
+	matched := false
+	for _, entry := range c.prefixes {
+		if entry.MatchPrefix(prefix) {
+			matched = true
+			break
+		}
+	}
+	if c.MatchSetOption == config.MatchSetOptionInvert {
+		return !matched
+	}
+	return matched
+}
+
+// The AS path regexes are matched against the ASes of the AS path separated by spaces
+func (c *RoutePolicyCondition) matchASPath(pathAttrs []packet.BGPPathAttr) bool {
+	asPath := strings.Join(packet.GetASList(pathAttrs), " ")
+	matched := 0
+	for _, regex := range c.asPathRegexes {
+		if regex.MatchString(asPath) {
+			matched++
+		}
+	}
+	return c.matchSet(matched, len(c.asPathRegexes))
+}
+
+func (c *RoutePolicyCondition) matchASPathLength(pathAttrs []packet.BGPPathAttr) bool {
+	length := uint32(len(packet.GetASList(pathAttrs)))
+	switch c.Comparison {
+	case config.RoutePolicyCompareGe:
+		return length >= c.ASPathLength
+	case config.RoutePolicyCompareLe:
+		return length <= c.ASPathLength
+	default:
+		return length == c.ASPathLength
+	}
+}
+
+// The neighbor and next hop set conditions don't match when the params have no address
+func (c *RoutePolicyCondition) matchAddress(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	matched := 0
+	for _, ipNet := range c.ipNets {
+		if ipNet.Contains(ip) {
+			matched++
+		}
+	}
+	return c.matchSet(matched, len(c.ipNets))
+}
+
+func (c *RoutePolicyCondition) Match(params *RoutePolicyParams) bool {
+	switch c.ConditionType {
+	case config.RoutePolicyConditionTypeCommunity:
+		return c.matchCommunities(params.PathAttrs)
+	case config.RoutePolicyConditionTypeRpkiValidation:
+		return c.matchValidationState(params.ValidationState)
+	case config.RoutePolicyConditionTypePrefixSet:
+		return c.matchPrefixes(params.Prefix)
+	case config.RoutePolicyConditionTypeASPathSet:
+		return c.matchASPath(params.PathAttrs)
+	case config.RoutePolicyConditionTypeASPathLength:
+		return c.matchASPathLength(params.PathAttrs)
+	case config.RoutePolicyConditionTypeNeighborSet:
+		return c.matchAddress(params.Neighbor)
+	case config.RoutePolicyConditionTypeNextHopSet:
+		return c.matchAddress(packet.GetNextHop(params.PathAttrs))
+	}
+	return false
+}
+
+// getMED returns the MED after the add, subtract or IGP cost option of the action is applied
+func (a *RoutePolicyAction) getMED(params *RoutePolicyParams) uint32 {
+	med, _ := packet.GetMED(params.PathAttrs)
+	switch a.MEDOption {
+	case config.MEDActionAdd:
+		if med > math.MaxUint32-a.MED {
+			return math.MaxUint32
+		}
+		return med + a.MED
+	case config.MEDActionSubtract:
+		if med < a.MED {
+			return 0
+		}
+		return med - a.MED
+	case config.MEDActionIGPCost:
+		return params.IGPCost
+	default:
+		return a.MED
+	}
+}
+
+// The next hop of the NEXT_HOP attr is only set to an IPv4 address
+func setNextHop(pathAttrs []packet.BGPPathAttr, nextHop net.IP) {
+	if nextHop == nil {
+		return
+	}
+
+	if packet.GetMPReachNLRI(pathAttrs) != nil {
+		packet.SetNextHopPathAttrs(pathAttrs, nextHop.To16())
+	} else if ip4 := nextHop.To4(); ip4 != nil {
+		packet.SetNextHopPathAttrs(pathAttrs, ip4)
+	}
+}
+
+func (a *RoutePolicyAction) Apply(params *RoutePolicyParams) {
+	switch a.ActionType {
+	case config.RoutePolicyActionTypeSetCommunity:
+		params.PathAttrs = packet.SetCommunities(params.PathAttrs, a.communities)
+	case config.RoutePolicyActionTypeAddCommunity:
+		params.PathAttrs = packet.AddCommunities(params.PathAttrs, a.communities)
+	case config.RoutePolicyActionTypeRemoveCommunity:
+		params.PathAttrs = packet.RemoveCommunities(params.PathAttrs, a.communities)
+	case config.RoutePolicyActionTypeSetLocalPref:
+		params.PathAttrs = packet.SetLocalPrefInPathAttrs(params.PathAttrs, a.LocalPref)
+	case config.RoutePolicyActionTypePrependASPath:
+		as := a.PrependAS
+		if a.PrependLastAS {
+			as = packet.GetNeighborAS(params.PathAttrs)
+		}
+		if as != 0 {
+			packet.PrependASToPathAttrs(params.PathAttrs, as, int(a.PrependCount))
+		}
+	case config.RoutePolicyActionTypeSetMED:
+		params.PathAttrs = packet.SetMEDInPathAttrs(params.PathAttrs, a.getMED(params))
+	case config.RoutePolicyActionTypeSetNextHop:
+		nextHop := params.LocalAddress
+		if a.NextHopOption == config.NextHopActionPeerAddress {
+			nextHop = params.Neighbor
+		}
+		setNextHop(params.PathAttrs, nextHop)
+	}
+}
+
+func (db *RoutePolicyDB) matchStmt(stmt *config.RoutePolicyStmtConfig, params *RoutePolicyParams) bool {
+	matchAny := stmt.MatchConditions == "any"
+	for _, conditionName := range stmt.Conditions {
+		condition, ok := db.Conditions[conditionName]
+		if !ok {
+			return false
+		}
+
+		matched := condition.Match(params)
+		if matchAny && matched {
+			return true
+		} else if !matchAny && !matched {
+			return false
+		}
+	}
+	return !matchAny || len(stmt.Conditions) == 0
+}
+
+/*  Evaluate the statements of the route policy in order. The path attrs in the params are
+ *  cloned before any action is applied so that the caller's path attrs are never modified.
+ *  Returns false if the route is rejected by the policy.
+ */
+func (db *RoutePolicyDB) ApplyPolicy(policyName string, params *RoutePolicyParams) bool {
+	return db.EvaluatePolicy(policyName, params).Accepted
+}
+
+// MatchPolicy returns true when an accept statement of the route policy matches the route, the
+// default result of the policy and a policy that does not exist never match
+func (db *RoutePolicyDB) MatchPolicy(policyName string, params *RoutePolicyParams) bool {
+	return db.EvaluatePolicy(policyName, params).Matched
+}
+
+// EvaluatePolicy evaluates the route policy like ApplyPolicy and returns the actions of the matched statements
+func (db *RoutePolicyDB) EvaluatePolicy(policyName string, params *RoutePolicyParams) *RoutePolicyResult {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	result := &RoutePolicyResult{Accepted: true}
+	policy, ok := db.Policies[policyName]
+	if !ok {
+		return result
+	}
+
+	cloned := false
+	for _, stmtName := range policy.Statements {
+		stmt, ok := db.Stmts[stmtName]
+		if !ok || !db.matchStmt(stmt, params) {
+			continue
+		}
+
+		for _, actionName := range stmt.Actions {
+			action, ok := db.Actions[actionName]
+			if !ok {
+				continue
+			}
+			if !cloned {
+				params.PathAttrs = packet.ClonePathAttrs(params.PathAttrs)
+				cloned = true
+			}
+			action.Apply(params)
+			result.actions = append(result.actions, action)
+		}
+
+		if stmt.Result != config.RoutePolicyResultNext {
+			result.Accepted = stmt.Result == config.RoutePolicyResultAccept
+			result.Matched = result.Accepted
+			return result
+		}
+	}
+
+	result.Accepted = policy.DefaultResult != config.RoutePolicyResultReject
+	return result
+}
+
+// ApplyActions applies the actions of the evaluated policy to a clone of the path attrs in the params
+func (r *RoutePolicyResult) ApplyActions(params *RoutePolicyParams) {
+	if len(r.actions) == 0 {
+		return
+	}
+
+	params.PathAttrs = packet.ClonePathAttrs(params.PathAttrs)
+	for _, action := range r.actions {
+		action.Apply(params)
+	}
+}
+
+func (db *RoutePolicyDB) HasPolicy(policyName string) bool {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	_, ok := db.Policies[policyName]
+	return ok
+}
+
+func (db *RoutePolicyDB) usesConditionType(policyName string, conditionType config.RoutePolicyConditionType) bool {
+	policy, ok := db.Policies[policyName]
+	if !ok {
+		return false
+	}
+
+	for _, stmtName := range policy.Statements {
+		stmt, ok := db.Stmts[stmtName]
+		if !ok {
+			continue
+		}
+		for _, conditionName := range stmt.Conditions {
+			if condition, ok := db.Conditions[conditionName]; ok && condition.ConditionType == conditionType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// UsesRpkiValidation returns true if any statement of the policy matches on the RPKI validation state.
+func (db *RoutePolicyDB) UsesRpkiValidation(policyName string) bool {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	return db.usesConditionType(policyName, config.RoutePolicyConditionTypeRpkiValidation)
+}
+
+// UsesPrefixMatch returns true if any statement of the policy matches on the prefix of the route.
+func (db *RoutePolicyDB) UsesPrefixMatch(policyName string) bool {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	return db.usesConditionType(policyName, config.RoutePolicyConditionTypePrefixSet)
+}
+
+/*  UsesNeighbor returns true if any statement of the policy matches on the neighbor or sets the
+ *  next hop to the address of the neighbor, the result of the policy then depends on the neighbor
+ *  the route is sent to.
+ */
+func (db *RoutePolicyDB) UsesNeighbor(policyName string) bool {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	if db.usesConditionType(policyName, config.RoutePolicyConditionTypeNeighborSet) {
+		return true
+	}
+
+	policy, ok := db.Policies[policyName]
+	if !ok {
+		return false
+	}
+
+	for _, stmtName := range policy.Statements {
+		stmt, ok := db.Stmts[stmtName]
+		if !ok {
+			continue
+		}
+		for _, actionName := range stmt.Actions {
+			action, ok := db.Actions[actionName]
+			if ok && action.ActionType == config.RoutePolicyActionTypeSetNextHop &&
+				action.NextHopOption == config.NextHopActionPeerAddress {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the prefix set conditions of the statement when it matches only on prefix sets
+func (db *RoutePolicyDB) getPrefixSetConditions(stmt *config.RoutePolicyStmtConfig) []*RoutePolicyCondition {
+	if len(stmt.Conditions) == 0 || (len(stmt.Conditions) > 1 && stmt.MatchConditions != "any") {
+		return nil
+	}
+
+	conditions := make([]*RoutePolicyCondition, 0, len(stmt.Conditions))
+	for _, conditionName := range stmt.Conditions {
+		condition, ok := db.Conditions[conditionName]
+		if !ok || condition.ConditionType != config.RoutePolicyConditionTypePrefixSet ||
+			condition.MatchSetOption == config.MatchSetOptionInvert {
+			return nil
+		}
+		conditions = append(conditions, condition)
+	}
+	return conditions
+}
+
+/*  Builds the address prefix ORF of the address family from the prefix set conditions of the
+ *  policy, so that the neighbor only sends the routes the policy can accept. The statements
+ *  that accept or reject the routes are converted in order up to the first statement that
+ *  matches on anything other than prefix sets. The routes not matched by the entries are
+ *  permitted by a last entry, unless the policy rejects them. Returns nil when no entry is
+ *  built from the policy.
+ */
+func (db *RoutePolicyDB) GetPrefixORF(policyName string, afi packet.AFI) []*packet.AddressPrefixORFEntry {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	policy, ok := db.Policies[policyName]
+	if !ok {
+		return nil
+	}
+
+	entries := make([]*packet.AddressPrefixORFEntry, 0)
+	permitRest := policy.DefaultResult != config.RoutePolicyResultReject
+	for _, stmtName := range policy.Statements {
+		stmt, ok := db.Stmts[stmtName]
+		if !ok || stmt.Result == config.RoutePolicyResultNext {
+			continue
+		}
+
+		conditions := db.getPrefixSetConditions(stmt)
+		if conditions == nil {
+			permitRest = len(stmt.Conditions) > 0 || stmt.Result == config.RoutePolicyResultAccept
+			break
+		}
+
+		match := packet.BGPORFMatchPermit
+		if stmt.Result == config.RoutePolicyResultReject {
+			match = packet.BGPORFMatchDeny
+		}
+		for _, condition := range conditions {
+			for _, prefix := range condition.prefixes {
+				if prefix.Prefix.GetAFI() != afi {
+					continue
+				}
+				entry := *prefix
+				entry.Match = match
+				entry.Sequence = uint32(len(entries)+1) * 10
+				entries = append(entries, &entry)
+			}
+		}
+	}
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	if permitRest {
+		prefix, maxLen := net.IPv4zero, uint8(8*net.IPv4len)
+		if afi == packet.AfiIP6 {
+			prefix, maxLen = net.IPv6zero, 8*net.IPv6len
+		}
+		entries = append(entries, packet.NewAddressPrefixORFEntry(packet.BGPORFActionAdd, packet.BGPORFMatchPermit,
+			uint32(len(entries)+1)*10, prefix, 0, 0, maxLen))
+	}
+	return entries
+}
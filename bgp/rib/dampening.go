@@ -0,0 +1,288 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// dampening.go
+package server
+
+import (
+	"fmt"
+	"l3/bgp/config"
+	"l3/bgp/packet"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	DampeningWithdrawPenalty   float64 = 1000
+	DampeningAttrChangePenalty float64 = 500
+	DampeningReuseInterval     int     = 10 // seconds
+)
+
+type dampKey struct {
+	destKey string
+	peerIP  string
+	pathId  uint32
+}
+
+/*  Route flap dampening (RFC 2439) info of a path received from an external peer. The
+ *  penalty decays exponentially with the configured half life and is updated when the
+ *  path is withdrawn or its attributes change. The path is suppressed when the penalty
+ *  goes above the suppress threshold and used again when it decays below the reuse
+ *  threshold. The info is kept as history after the path is withdrawn.
+ */
+type dampInfo struct {
+	network    string
+	prefixLen  uint8
+	penalty    float64
+	lastUpdate time.Time
+	flaps      uint32
+	suppressed bool
+	withdrawn  bool
+}
+
+func (d *dampInfo) decay(gConf *config.GlobalConfig, now time.Time) {
+	elapsed := now.Sub(d.lastUpdate).Seconds()
+	if elapsed > 0 {
+		d.penalty = d.penalty * math.Pow(2, -elapsed/float64(gConf.DampeningHalfLife))
+	}
+	d.lastUpdate = now
+}
+
+// The penalty is capped so that a path is never suppressed for more than the max suppress time
+func getMaxPenalty(gConf *config.GlobalConfig) float64 {
+	return float64(gConf.DampeningReuse) *
+		math.Pow(2, float64(gConf.DampeningMaxSuppressTime)/float64(gConf.DampeningHalfLife))
+}
+
+func (d *dampInfo) addPenalty(gConf *config.GlobalConfig, penalty float64, now time.Time) {
+	d.decay(gConf, now)
+	d.penalty = math.Min(d.penalty+penalty, getMaxPenalty(gConf))
+	d.flaps++
+	if !d.suppressed && d.penalty > float64(gConf.DampeningSuppress) {
+		d.suppressed = true
+	}
+}
+
+func (d *dampInfo) getReuseTime(gConf *config.GlobalConfig) time.Duration {
+	if !d.suppressed || d.penalty <= float64(gConf.DampeningReuse) {
+		return 0
+	}
+	seconds := float64(gConf.DampeningHalfLife) * math.Log2(d.penalty/float64(gConf.DampeningReuse))
+	return time.Duration(seconds) * time.Second
+}
+
+func (adjRib *AdjRib) isDampeningEnabled(path *Path) bool {
+	return adjRib.gConf.Dampening && path != nil && path.IsExternal() && !adjRib.view && !adjRib.vpn
+}
+
+func (adjRib *AdjRib) getOrCreateDampInfo(dest *Destination, peerIP string, pathId uint32) *dampInfo {
+	key := dampKey{dest.key, peerIP, pathId}
+	info, ok := adjRib.dampInfoMap[key]
+	if !ok {
+		info = &dampInfo{network: dest.IPPrefix.Prefix.String(), prefixLen: dest.IPPrefix.Length,
+			lastUpdate: time.Now()}
+		adjRib.dampInfoMap[key] = info
+	}
+	return info
+}
+
+func (adjRib *AdjRib) dampenWithdrawnPath(dest *Destination, peerIP string, pathId uint32, oldPath *Path) {
+	if oldPath == nil || oldPath.IsStale() || !adjRib.isDampeningEnabled(oldPath) {
+		return
+	}
+
+	adjRib.dampMutex.Lock()
+	defer adjRib.dampMutex.Unlock()
+	info := adjRib.getOrCreateDampInfo(dest, peerIP, pathId)
+	info.addPenalty(adjRib.gConf, DampeningWithdrawPenalty, time.Now())
+	info.withdrawn = true
+	adjRib.logger.Info(fmt.Sprintln("Dampening - path for", dest.IPPrefix.Prefix.String(), "from", peerIP,
+		"withdrawn, penalty", uint32(info.penalty), "suppressed", info.suppressed))
+}
+
+// A path that is advertised again after it's withdrawn is not penalized, only the changes of the path attrs are
+func (adjRib *AdjRib) dampenUpdatedPath(dest *Destination, peerIP string, pathId uint32, oldPath, path *Path) {
+	if !adjRib.isDampeningEnabled(path) {
+		return
+	}
+
+	adjRib.dampMutex.Lock()
+	defer adjRib.dampMutex.Unlock()
+	key := dampKey{dest.key, peerIP, pathId}
+	if oldPath == nil || oldPath.IsStale() {
+		if info, ok := adjRib.dampInfoMap[key]; ok {
+			info.withdrawn = false
+		}
+		return
+	}
+
+	if packet.PathAttrsEqual(oldPath.PathAttrs, path.PathAttrs) {
+		return
+	}
+
+	info := adjRib.getOrCreateDampInfo(dest, peerIP, pathId)
+	info.addPenalty(adjRib.gConf, DampeningAttrChangePenalty, time.Now())
+	adjRib.logger.Info(fmt.Sprintln("Dampening - path for", dest.IPPrefix.Prefix.String(), "from", peerIP,
+		"changed, penalty", uint32(info.penalty), "suppressed", info.suppressed))
+}
+
+func (adjRib *AdjRib) isPathSuppressed(dest *Destination, peerIP string, pathId uint32) bool {
+	if !adjRib.gConf.Dampening {
+		return false
+	}
+
+	adjRib.dampMutex.RLock()
+	defer adjRib.dampMutex.RUnlock()
+	if info, ok := adjRib.dampInfoMap[dampKey{dest.key, peerIP, pathId}]; ok {
+		return info.suppressed
+	}
+	return false
+}
+
+func (d *Destination) isPathSuppressed(path *Path) bool {
+	if !d.rib.isDampeningEnabled(path) {
+		return false
+	}
+
+	for peerIP, pathMap := range d.peerPathMap {
+		for pathId, peerPath := range pathMap {
+			if peerPath == path {
+				return d.rib.isPathSuppressed(d, peerIP, pathId)
+			}
+		}
+	}
+	return false
+}
+
+// GetSuppressedPaths returns the NLRIs of the suppressed paths by the neighbor they are received from
+func (adjRib *AdjRib) GetSuppressedPaths() map[string][]packet.NLRI {
+	peerNLRIMap := make(map[string][]packet.NLRI)
+	adjRib.dampMutex.RLock()
+	defer adjRib.dampMutex.RUnlock()
+	for key, info := range adjRib.dampInfoMap {
+		if !info.suppressed {
+			continue
+		}
+
+		if dest, ok := adjRib.destPathMap[key.destKey]; ok && dest.getPathForIP(key.peerIP, key.pathId) != nil {
+			peerNLRIMap[key.peerIP] = append(peerNLRIMap[key.peerIP], dest.getNLRI(key.pathId))
+		}
+	}
+	return peerNLRIMap
+}
+
+/*  Called periodically to decay the penalties. The suppressed paths with a penalty below the
+ *  reuse threshold are used again and the best path of their destinations is selected again.
+ *  The info of the paths with a penalty below half of the reuse threshold is removed. All the
+ *  paths are reused when dampening is disabled.
+ */
+func (adjRib *AdjRib) ReuseDampenedPaths(addPathCount int) (map[*Path][]*Destination, []*Destination,
+	[]*Destination) {
+	withdrawn := make([]*Destination, 0)
+	updated := make(map[*Path][]*Destination)
+	updatedAddPaths := make([]*Destination, 0)
+	reusedDests := make(map[string]*Destination)
+
+	adjRib.dampMutex.Lock()
+	now := time.Now()
+	for key, info := range adjRib.dampInfoMap {
+		info.decay(adjRib.gConf, now)
+		if info.suppressed && (!adjRib.gConf.Dampening || info.penalty < float64(adjRib.gConf.DampeningReuse)) {
+			info.suppressed = false
+			adjRib.logger.Info(fmt.Sprintln("Dampening - reuse path for", info.network, "from", key.peerIP,
+				"penalty", uint32(info.penalty)))
+			if dest, ok := adjRib.destPathMap[key.destKey]; ok {
+				reusedDests[key.destKey] = dest
+			}
+		}
+		if !adjRib.gConf.Dampening || (!info.suppressed &&
+			info.penalty < float64(adjRib.gConf.DampeningReuse)/2) {
+			delete(adjRib.dampInfoMap, key)
+		}
+	}
+	adjRib.dampMutex.Unlock()
+
+	for _, dest := range reusedDests {
+		dest.recalculate = true
+		action, addPathsMod, addRoutes, updRoutes, delRoutes := dest.SelectRouteForLocRib(addPathCount)
+		withdrawn, updated, updatedAddPaths = adjRib.updateRibOutInfo(action, addPathsMod, addRoutes,
+			updRoutes, delRoutes, dest, withdrawn, updated, updatedAddPaths)
+	}
+	return updated, withdrawn, updatedAddPaths
+}
+
+type dampKeys []dampKey
+
+func (k dampKeys) Len() int {
+	return len(k)
+}
+
+func (k dampKeys) Swap(i, j int) {
+	k[i], k[j] = k[j], k[i]
+}
+
+func (k dampKeys) Less(i, j int) bool {
+	if k[i].destKey != k[j].destKey {
+		return k[i].destKey < k[j].destKey
+	}
+	if k[i].peerIP != k[j].peerIP {
+		return k[i].peerIP < k[j].peerIP
+	}
+	return k[i].pathId < k[j].pathId
+}
+
+func (adjRib *AdjRib) BulkGetDampenedRoutes(index int, count int) (int, int, []*config.DampenedRouteState) {
+	adjRib.dampMutex.RLock()
+	defer adjRib.dampMutex.RUnlock()
+
+	keys := make(dampKeys, 0, len(adjRib.dampInfoMap))
+	for key, _ := range adjRib.dampInfoMap {
+		keys = append(keys, key)
+	}
+	sort.Sort(keys)
+
+	var i int
+	now := time.Now()
+	result := make([]*config.DampenedRouteState, 0, count)
+	for i = index; i < len(keys) && len(result) < count; i++ {
+		info := *adjRib.dampInfoMap[keys[i]]
+		info.decay(adjRib.gConf, now)
+		result = append(result, &config.DampenedRouteState{
+			Network:    info.network,
+			CIDRLen:    info.prefixLen,
+			Neighbor:   keys[i].peerIP,
+			PathId:     keys[i].pathId,
+			Penalty:    uint32(info.penalty),
+			Flaps:      info.flaps,
+			Suppressed: info.suppressed,
+			History:    info.withdrawn,
+			ReuseTime:  info.getReuseTime(adjRib.gConf).String(),
+		})
+	}
+
+	if i >= len(keys) {
+		i = 0
+	}
+	return i, len(result), result
+}
@@ -48,6 +48,8 @@ type Destination struct {
 	logger            *logging.Writer
 	gConf             *config.GlobalConfig
 	IPPrefix          *packet.IPPrefix
+	protoFamily       uint32
+	key               string
 	peerPathMap       map[string]map[uint32]*Path
 	LocRibPath        *Path
 	LocRibPathRoute   *Route
@@ -100,6 +102,58 @@ func (d *Destination) GetPathRoute(path *Path) *Route {
 	return nil
 }
 
+// GetECMPPaths returns the paths of the ECMP routes other than the best path, a path per next hop
+func (d *Destination) GetECMPPaths() []*Path {
+	paths := make([]*Path, 0, len(d.ecmpPaths))
+	for path, _ := range d.ecmpPaths {
+		if path != d.LocRibPath {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+/*  SelectAddPaths returns the paths advertised with the best path for the add paths transmit
+ *  mode. The add paths of the destination are ordered from the best to the worst path.
+ */
+func (d *Destination) SelectAddPaths(txMode string) []*Path {
+	switch txMode {
+	case config.AddPathsTxModeECMP:
+		return d.GetECMPPaths()
+
+	case config.AddPathsTxModeBestExternal:
+		// The best path is advertised as the best external path when it is not internal
+		if d.LocRibPath != nil && !d.LocRibPath.IsInternal() {
+			return nil
+		}
+		for _, path := range d.AddPaths {
+			if !path.IsInternal() {
+				return []*Path{path}
+			}
+		}
+		return nil
+
+	case config.AddPathsTxModeDiversePath:
+		for _, path := range d.AddPaths {
+			if d.LocRibPath == nil || !path.GetNextHop().Equal(d.LocRibPath.GetNextHop()) {
+				return []*Path{path}
+			}
+		}
+		return nil
+	}
+	return d.AddPaths
+}
+
+// GetKey returns the key of the destination in the rib
+func (d *Destination) GetKey() string {
+	return d.key
+}
+
+// GetProtocolFamily returns the protocol family of the NLRIs of the destination
+func (d *Destination) GetProtocolFamily() uint32 {
+	return d.protoFamily
+}
+
 func (d *Destination) IsEmpty() bool {
 	return len(d.peerPathMap) == 0
 }
@@ -150,6 +204,14 @@ func (d *Destination) getPathForIP(peerIP string, pathId uint32) (path *Path) {
 	return path
 }
 
+// getNLRI returns the NLRI of the destination for the path id
+func (d *Destination) getNLRI(pathId uint32) packet.NLRI {
+	if pathId != 0 {
+		return packet.NewExtNLRI(pathId, *d.IPPrefix)
+	}
+	return d.IPPrefix
+}
+
 func (d *Destination) getPathIdForPath(path *Path) (uint32, bool) {
 	for _, pathMap := range d.peerPathMap {
 		for pathId, peerPath := range pathMap {
@@ -286,6 +348,86 @@ func (d *Destination) RemoveAllPaths(peerIP string, path *Path) {
 	}
 }
 
+func (d *Destination) MarkStalePaths(peerIP string) bool {
+	pathMap, ok := d.peerPathMap[peerIP]
+	if !ok {
+		return false
+	}
+
+	d.logger.Info(fmt.Sprintln("Mark all paths for", d.IPPrefix.Prefix.String(),
+		"from peer", peerIP, "as stale"))
+	for _, path := range pathMap {
+		path.SetStale(true)
+	}
+	return true
+}
+
+func (d *Destination) RemoveStalePaths(peerIP string, path *Path) bool {
+	pathMap, ok := d.peerPathMap[peerIP]
+	if !ok {
+		return false
+	}
+
+	removed := false
+	for pathId, stalePath := range pathMap {
+		if stalePath.IsStale() {
+			d.logger.Info(fmt.Sprintln("Remove stale path id", pathId, "for",
+				d.IPPrefix.Prefix.String(), "from peer", peerIP))
+			d.RemovePath(peerIP, pathId, path)
+			removed = true
+		}
+	}
+	return removed
+}
+
+func (d *Destination) MarkRefreshStalePaths(peerIP string) bool {
+	pathMap, ok := d.peerPathMap[peerIP]
+	if !ok {
+		return false
+	}
+
+	for _, path := range pathMap {
+		path.SetRefreshStale(true)
+	}
+	return true
+}
+
+/*  Removes the paths from the peer that were not received again in the enhanced route
+ *  refresh. Returns the number of paths removed and the number of those counted in the
+ *  prefix count of the peer, the paths retained for graceful restart are not counted.
+ */
+func (d *Destination) RemoveRefreshStalePaths(peerIP string, path *Path) (int, int) {
+	pathMap, ok := d.peerPathMap[peerIP]
+	if !ok {
+		return 0, 0
+	}
+
+	removed, counted := 0, 0
+	for pathId, stalePath := range pathMap {
+		if stalePath.IsRefreshStale() {
+			d.logger.Info(fmt.Sprintln("Remove route refresh stale path id", pathId, "for",
+				d.IPPrefix.Prefix.String(), "from peer", peerIP))
+			d.RemovePath(peerIP, pathId, path)
+			removed++
+			if !stalePath.IsStale() {
+				counted++
+			}
+		}
+	}
+	return removed, counted
+}
+
+// hasRoute returns true if the route with the next hop is installed in the RIB manager for the destination
+func (d *Destination) hasRoute(nextHop string) bool {
+	for path, _ := range d.ecmpPaths {
+		if (path.IsAggregate() || !path.IsLocal()) && path.reachabilityInfo != nil &&
+			path.reachabilityInfo.NextHop == nextHop {
+			return true
+		}
+	}
+	return false
+}
+
 func (d *Destination) RemoveAllNeighborPaths() {
 	for peerIP, pathMap := range d.peerPathMap {
 		for pathId, path := range pathMap {
@@ -320,6 +462,13 @@ func constructNetmaskFromLen(ones, bits int) net.IP {
 	return ip
 }
 
+func (d *Destination) getNetmask() net.IP {
+	if d.IPPrefix.GetAFI() == packet.AfiIP6 {
+		return constructNetmaskFromLen(int(d.IPPrefix.Length), 128)
+	}
+	return constructNetmaskFromLen(int(d.IPPrefix.Length), 32)
+}
+
 func (d *Destination) removeAndPrepend(pathsList *[][]*Path, item *Path) {
 	idx := 0
 	found := false
@@ -371,7 +520,8 @@ func (d *Destination) SelectRouteForLocRib(addPathCount int) (RouteAction, bool,
 	d.recalculate = false
 
 	locRibPathAdded := false
-	if d.LocRibPath != nil && !d.LocRibPath.IsWithdrawn() && !d.LocRibPath.IsUpdated() {
+	if d.LocRibPath != nil && !d.LocRibPath.IsWithdrawn() && !d.LocRibPath.IsUpdated() &&
+		!d.isPathSuppressed(d.LocRibPath) {
 		peerIP := d.gConf.RouterId.String()
 		if d.LocRibPath.NeighborConf != nil {
 			peerIP = d.LocRibPath.NeighborConf.Neighbor.NeighborAddress.String()
@@ -385,8 +535,14 @@ func (d *Destination) SelectRouteForLocRib(addPathCount int) (RouteAction, bool,
 	}
 
 	for peerIP, pathMap := range d.peerPathMap {
-		for _, path := range pathMap {
+		for pathId, path := range pathMap {
 			if !locRibPathAdded || d.LocRibPath != path {
+				if d.rib.isPathSuppressed(d, peerIP, pathId) {
+					d.logger.Info(fmt.Sprintln("Path for", d.IPPrefix.Prefix.String(), "from", peerIP,
+						"is suppressed by dampening, removing this path from the selection process"))
+					continue
+				}
+
 				if !path.IsLocal() && !path.IsReachable() {
 					d.logger.Info(fmt.Sprintf("peer %s, NEXT_HOP[%s] is",
 						"not reachable\n", peerIP, path.GetNextHop()))
@@ -511,8 +667,7 @@ func (d *Destination) SelectRouteForLocRib(addPathCount int) (RouteAction, bool,
 				if paths[0].IsAggregate() || !paths[0].IsLocal() {
 					d.logger.Info(fmt.Sprintf("Add route for ip=%s, mask=%s,",
 						"next hop=%s\n", d.IPPrefix.Prefix.String(),
-						constructNetmaskFromLen(int(d.IPPrefix.Length),
-							32).String(),
+						d.getNetmask().String(),
 						paths[0].reachabilityInfo.NextHop))
 					createRibRoutes = append(createRibRoutes, paths[0])
 				}
@@ -543,11 +698,12 @@ func (d *Destination) SelectRouteForLocRib(addPathCount int) (RouteAction, bool,
 						Cost:      int32(path.reachabilityInfo.Metric),
 						Protocol:  protocol,
 						NextHopIp: path.reachabilityInfo.NextHop,
-						NetworkMask: constructNetmaskFromLen(
-							int(d.IPPrefix.Length), 32).String(),
+						NetworkMask: d.getNetmask().String(),
 						DestinationNw: d.IPPrefix.Prefix.String(),
 						OutgoingInterface: strconv.Itoa(
 							int(path.reachabilityInfo.NextHopIfIdx)),
+						Vrf:    d.rib.vrf,
+						Labels: path.labels,
 					}
 					//d.rib.routeMgr.DeleteRoute(&cfg)
 					d.rib.routeMgr.UpdateRoute(&cfg,"remove")
@@ -575,11 +731,12 @@ func (d *Destination) SelectRouteForLocRib(addPathCount int) (RouteAction, bool,
 					Cost:      int32(path.reachabilityInfo.Metric),
 					Protocol:  protocol,
 					NextHopIp: path.reachabilityInfo.NextHop,
-					NetworkMask: constructNetmaskFromLen(
-						int(d.IPPrefix.Length), 32).String(),
+					NetworkMask: d.getNetmask().String(),
 					DestinationNw: d.IPPrefix.Prefix.String(),
 					OutgoingInterface: strconv.Itoa(
 						int(path.reachabilityInfo.NextHopIfIdx)),
+					Vrf:    d.rib.vrf,
+					Labels: path.labels,
 				}
 				//d.rib.routeMgr.DeleteRoute(&cfg)
 				d.rib.routeMgr.UpdateRoute(&cfg,"remove")
@@ -597,7 +754,7 @@ func (d *Destination) SelectRouteForLocRib(addPathCount int) (RouteAction, bool,
 	for _, path := range createRibRoutes {
 		d.logger.Info(fmt.Sprintf("Add route for ip=%s, mask=%s, next hop=%s\n",
 			d.IPPrefix.Prefix.String(),
-			constructNetmaskFromLen(int(d.IPPrefix.Length), 32).String(),
+			d.getNetmask().String(),
 			path.reachabilityInfo.NextHop))
 		protocol := "IBGP"
 		if path.IsExternal() {
@@ -608,9 +765,11 @@ func (d *Destination) SelectRouteForLocRib(addPathCount int) (RouteAction, bool,
 			IntfType:          int32(path.reachabilityInfo.NextHopIfType),
 			Protocol:          protocol,
 			NextHopIp:         path.reachabilityInfo.NextHop,
-			NetworkMask:       constructNetmaskFromLen(int(d.IPPrefix.Length), 32).String(),
+			NetworkMask:       d.getNetmask().String(),
 			DestinationNw:     d.IPPrefix.Prefix.String(),
 			OutgoingInterface: strconv.Itoa(int(path.reachabilityInfo.NextHopIfIdx)),
+			Vrf:               d.rib.vrf,
+			Labels:            path.labels,
 		}
 		if firstRoute {
 		    d.rib.routeMgr.CreateRoute(&cfg)
@@ -624,7 +783,7 @@ func (d *Destination) SelectRouteForLocRib(addPathCount int) (RouteAction, bool,
 
 func (d *Destination) updateRoute(path *Path) {
 	d.logger.Info(fmt.Sprintf("Remove route for ip=%s, mask=%s\n", d.IPPrefix.Prefix.String(),
-		constructNetmaskFromLen(int(d.IPPrefix.Length), 32).String()))
+		d.getNetmask().String()))
 	protocol := "IBGP"
 	if path.IsExternal() {
 		protocol = "EBGP"
@@ -634,8 +793,10 @@ func (d *Destination) updateRoute(path *Path) {
 		Protocol:          protocol,
 		OutgoingInterface: strconv.Itoa(int(path.reachabilityInfo.NextHopIfIdx)),
 		Cost:              int32(path.reachabilityInfo.Metric),
-		NetworkMask:       constructNetmaskFromLen(int(d.IPPrefix.Length), 32).String(),
-		NextHopIp:         path.reachabilityInfo.NextHop}
+		NetworkMask:       d.getNetmask().String(),
+		NextHopIp:         path.reachabilityInfo.NextHop,
+		Vrf:               d.rib.vrf,
+		Labels:            path.labels}
 	//d.rib.routeMgr.DeleteRoute(&cfg)
 	d.rib.routeMgr.UpdateRoute(&cfg,"remove")
 
@@ -649,20 +810,23 @@ func (d *Destination) updateRoute(path *Path) {
 
 		d.logger.Info(fmt.Sprintf("Add route for ip=%s, mask=%s, next hop=%s\n",
 			d.IPPrefix.Prefix.String(),
-			constructNetmaskFromLen(int(d.IPPrefix.Length), 32).String(), nextHop))
+			d.getNetmask().String(), nextHop))
 		cfg := config.RouteConfig{
 			Cost:              int32(path.reachabilityInfo.Metric),
 			Protocol:          protocol,
 			IntfType:          int32(path.reachabilityInfo.NextHopIfType),
 			NextHopIp:         nextHop,
-			NetworkMask:       constructNetmaskFromLen(int(d.IPPrefix.Length), 32).String(),
+			NetworkMask:       d.getNetmask().String(),
 			DestinationNw:     d.IPPrefix.Prefix.String(),
 			OutgoingInterface: strconv.Itoa(int(path.reachabilityInfo.NextHopIfIdx)),
+			Vrf:               d.rib.vrf,
+			Labels:            path.labels,
 		}
 		d.rib.routeMgr.CreateRoute(&cfg)
 	}
 }
 
+// The confederation segments are not counted in the AS path length (RFC 5065)
 func (d *Destination) getRoutesWithSmallestAS(updatedPaths []*Path,
 	prunedPaths []PathSortIface) ([]*Path,
 	[]PathSortIface) {
@@ -754,13 +918,129 @@ func (d *Destination) getRoutesWithLowestOrigin(updatedPaths []*Path,
 	return updatedPaths, prunedPaths
 }
 
+// getMED returns the MED of the path. A missing MED is the lowest MED unless missing-as-worst is set.
+func (d *Destination) getMED(path *Path) uint32 {
+	if med, ok := packet.GetMED(path.PathAttrs); ok {
+		return med
+	}
+
+	if d.gConf.MEDMissingAsWorst {
+		return math.MaxUint32
+	}
+	return 0
+}
+
+// getMEDNeighborAS returns the neighbor AS used to group the paths for MED comparison
+func (d *Destination) getMEDNeighborAS(path *Path) uint32 {
+	if d.gConf.AlwaysCompareMED {
+		return 0
+	}
+	return packet.GetNeighborAS(path.PathAttrs)
+}
+
+/*  getRoutesWithLowestMED removes the paths with a higher MED than another path from the same
+ *  neighbor AS (RFC 4271 section 9.1.2.2). With always-compare-med the MEDs of all the paths are
+ *  compared. Otherwise the paths are grouped by the neighbor AS and the MEDs are compared in
+ *  every group, the result does not depend on the order of the paths so deterministic-med does
+ *  not change it.
+ */
+func (d *Destination) getRoutesWithLowestMED(updatedPaths []*Path,
+	prunedPaths []PathSortIface) ([]*Path,
+	[]PathSortIface) {
+	lowestMEDs := make(map[uint32]uint32)
+	for _, path := range updatedPaths {
+		neighborAS := d.getMEDNeighborAS(path)
+		med := d.getMED(path)
+		if lowestMED, ok := lowestMEDs[neighborAS]; !ok || med < lowestMED {
+			lowestMEDs[neighborAS] = med
+		}
+	}
+
+	removedPaths := make([]*Path, 0)
+	n := len(updatedPaths)
+	idx := 0
+	for i := 0; i < n; i++ {
+		lowestMED, ok := lowestMEDs[d.getMEDNeighborAS(updatedPaths[i])]
+		if ok && d.getMED(updatedPaths[i]) > lowestMED {
+			removedPaths = append(removedPaths, updatedPaths[i])
+			continue
+		}
+		updatedPaths[idx] = updatedPaths[i]
+		idx++
+	}
+
+	if len(removedPaths) > 0 {
+		pathSortIface := PathSortIface{
+			paths: removedPaths,
+			iface: ByLowestMED{removedPaths, d},
+		}
+		prunedPaths = append(prunedPaths, pathSortIface)
+	}
+
+	for i := idx; i < n; i++ {
+		updatedPaths[i] = nil
+	}
+	return updatedPaths[:idx], prunedPaths
+}
+
+// getIGPCost returns the IGP metric of the route to the next hop of the path
+func (d *Destination) getIGPCost(path *Path) int32 {
+	if path.reachabilityInfo == nil {
+		return 0
+	}
+	return path.reachabilityInfo.Metric
+}
+
+// getRoutesWithLowestIGPCost keeps the paths with the lowest IGP metric to the next hop (RFC 4271 section 9.1.2.2)
+func (d *Destination) getRoutesWithLowestIGPCost(updatedPaths []*Path,
+	prunedPaths []PathSortIface) ([]*Path,
+	[]PathSortIface) {
+	removedPaths := make([]*Path, 0)
+	n := len(updatedPaths)
+	lowestCost := int32(math.MaxInt32)
+	idx := 0
+
+	for i := 0; i < n; i++ {
+		cost := d.getIGPCost(updatedPaths[i])
+		if cost > lowestCost {
+			removedPaths = append(removedPaths, updatedPaths[i])
+		} else if cost < lowestCost {
+			removedPaths = append(removedPaths, updatedPaths[:idx]...)
+			lowestCost = cost
+			updatedPaths[0] = updatedPaths[i]
+			idx = 1
+		} else if cost == lowestCost {
+			updatedPaths[idx] = updatedPaths[i]
+			idx++
+		}
+	}
+
+	if len(removedPaths) > 0 {
+		pathSortIface := PathSortIface{
+			paths: removedPaths,
+			iface: ByLowestIGPCost{removedPaths, d},
+		}
+		prunedPaths = append(prunedPaths, pathSortIface)
+	}
+
+	if idx > 0 {
+		for i := idx; i < n; i++ {
+			updatedPaths[i] = nil
+		}
+		updatedPaths = updatedPaths[:idx]
+	}
+
+	return updatedPaths, prunedPaths
+}
+
 func deleteIBGPRoutes(updatedPaths []*Path, prunedPaths []PathSortIface) ([]*Path, []PathSortIface) {
 	removedPaths := make([]*Path, 0)
 	n := len(updatedPaths) - 1
 	i := 0
 
 	for i <= n {
-		if updatedPaths[i].NeighborConf.IsInternal() {
+		// Paths from the other member ASes of the confederation are treated as internal paths
+		if updatedPaths[i].NeighborConf.IsInternal() || updatedPaths[i].NeighborConf.IsConfedExternal() {
 			removedPaths = append(removedPaths, updatedPaths[i])
 			updatedPaths[i] = updatedPaths[n]
 			updatedPaths[n] = nil
@@ -802,7 +1082,7 @@ func (d *Destination) isEBGPRoute(path *Path) bool {
 }
 
 func (d *Destination) isIBGPRoute(path *Path) bool {
-	if path.NeighborConf != nil && path.NeighborConf.IsInternal() {
+	if path.NeighborConf != nil && (path.NeighborConf.IsInternal() || path.NeighborConf.IsConfedExternal()) {
 		return true
 	}
 
@@ -1003,6 +1283,12 @@ func (d *Destination) calculateBestPath(updatedPaths, removedPaths []*Path,
 	}
 	prunedPaths = append(prunedPaths, pathSortIface)
 
+	if len(updatedPaths) > 1 && d.gConf.RpkiPreferValid {
+		d.logger.Info(fmt.Sprintln("calling getRoutesWithBestValidationState, update paths =",
+			updatedPaths))
+		updatedPaths, prunedPaths = d.getRoutesWithBestValidationState(updatedPaths, prunedPaths)
+	}
+
 	if len(updatedPaths) > 1 {
 		d.logger.Info(fmt.Sprintln("calling getRoutesWithSmallestAS, update paths =",
 			updatedPaths))
@@ -1015,8 +1301,18 @@ func (d *Destination) calculateBestPath(updatedPaths, removedPaths []*Path,
 		updatedPaths, prunedPaths = d.getRoutesWithLowestOrigin(updatedPaths, prunedPaths)
 	}
 
+	if len(updatedPaths) > 1 {
+		d.logger.Info(fmt.Sprintln("calling getRoutesWithLowestMED, update paths =",
+			updatedPaths))
+		updatedPaths, prunedPaths = d.getRoutesWithLowestMED(updatedPaths, prunedPaths)
+	}
+
 	if (len(updatedPaths) > 1) && ebgpMultiPath && ibgpMultiPath {
-		ecmpPaths = d.getECMPPaths(updatedPaths)
+		// The IGP cost is compared after the EBGP and IBGP paths are grouped together
+		multiPaths := make([]*Path, len(updatedPaths))
+		copy(multiPaths, updatedPaths)
+		multiPaths, _ = d.getRoutesWithLowestIGPCost(multiPaths, nil)
+		ecmpPaths = d.getECMPPaths(multiPaths)
 		d.logger.Info(fmt.Sprintln("calculateBestPath: IBGP & EBGP multi paths =",
 			ecmpPaths))
 	}
@@ -1028,6 +1324,12 @@ func (d *Destination) calculateBestPath(updatedPaths, removedPaths []*Path,
 			prunedPaths)
 	}
 
+	if len(updatedPaths) > 1 {
+		d.logger.Info(fmt.Sprintln("calling getRoutesWithLowestIGPCost, update paths =",
+			updatedPaths))
+		updatedPaths, prunedPaths = d.getRoutesWithLowestIGPCost(updatedPaths, prunedPaths)
+	}
+
 	if len(updatedPaths) > 1 && ibgpMultiPath != ebgpMultiPath {
 		if ebgpMultiPath && d.isEBGPRoute(updatedPaths[0]) {
 			ecmpPaths = d.getECMPPaths(updatedPaths)
@@ -31,8 +31,6 @@ import (
 	"l3/bgp/packet"
 	"net"
 	_ "ribd"
-	"strconv"
-	"strings"
 	"utils/logging"
 )
 
@@ -74,12 +72,15 @@ type Path struct {
 	PathAttrs        []packet.BGPPathAttr
 	withdrawn        bool
 	updated          bool
+	stale            bool
+	refreshStale     bool
 	Pref             uint32
 	reachabilityInfo *ReachabilityInfo
 	routeType        uint8
 	MED              uint32
 	LocalPref        uint32
 	AggregatedPaths  map[string]*Path
+	labels           []uint32
 }
 
 func NewPath(adjRib *AdjRib, peer *base.NeighborConf, pa []packet.BGPPathAttr, withdrawn bool, updated bool, routeType uint8) *Path {
@@ -112,6 +113,7 @@ func (p *Path) Clone() *Path {
 		routeType:        p.routeType,
 		MED:              p.MED,
 		LocalPref:        p.LocalPref,
+		labels:           p.labels,
 	}
 
 	return path
@@ -121,7 +123,11 @@ func (p *Path) calculatePref() uint32 {
 	var pref uint32
 
 	pref = BGP_INTERNAL_PREF
+	if p.IsExternal() {
+		pref = BGP_EXTERNAL_PREF
+	}
 
+	// LOCAL_PREF is removed from the updates received from the external peers, it's only set by the import policy
 	for _, attr := range p.PathAttrs {
 		if attr.GetCode() == packet.BGPPathAttrTypeLocalPref {
 			p.LocalPref = attr.(*packet.BGPPathAttrLocalPref).Value
@@ -131,17 +137,13 @@ func (p *Path) calculatePref() uint32 {
 		}
 	}
 
-	if p.IsExternal() {
-		pref = BGP_EXTERNAL_PREF
-	}
-
 	return pref
 }
 
 func (p *Path) IsValid() bool {
 	for _, attr := range p.PathAttrs {
 		if attr.GetCode() == packet.BGPPathAttrTypeOriginatorId {
-			if p.NeighborConf.Global.RouterId.Equal(attr.(*packet.BGPPathAttrOriginatorId).Value) {
+			if p.isOwn() && !p.isAcceptOwn() {
 				return false
 			}
 		}
@@ -159,6 +161,24 @@ func (p *Path) IsValid() bool {
 	return true
 }
 
+// isAcceptOwn returns true for our own routes reflected back by an internal peer with the
+// ACCEPT_OWN community when accept-own is enabled on the neighbor (RFC 7611). The routes are
+// only accepted as VPN routes, into the VPN rib and the VRFs, never into the global table.
+func (p *Path) isAcceptOwn() bool {
+	return p.rib != nil && (p.rib.vrf != "" || p.rib.vpn) && p.NeighborConf.RunningConf.AcceptOwn &&
+		p.NeighborConf.IsInternal() && packet.HasCommunity(p.PathAttrs, packet.BGPCommunityAcceptOwn)
+}
+
+// isOwn returns true when the originator of the path is the local router
+func (p *Path) isOwn() bool {
+	for _, attr := range p.PathAttrs {
+		if attr.GetCode() == packet.BGPPathAttrTypeOriginatorId {
+			return p.NeighborConf.Global.RouterId.Equal(attr.(*packet.BGPPathAttrOriginatorId).Value)
+		}
+	}
+	return false
+}
+
 func (p *Path) SetWithdrawn(status bool) {
 	p.withdrawn = status
 }
@@ -167,6 +187,23 @@ func (p *Path) IsWithdrawn() bool {
 	return p.withdrawn
 }
 
+func (p *Path) SetStale(status bool) {
+	p.stale = status
+}
+
+func (p *Path) IsStale() bool {
+	return p.stale
+}
+
+// SetRefreshStale marks the path as stale for an enhanced route refresh, separately from graceful restart
+func (p *Path) SetRefreshStale(status bool) {
+	p.refreshStale = status
+}
+
+func (p *Path) IsRefreshStale() bool {
+	return p.refreshStale
+}
+
 func (p *Path) UpdatePath(pa []packet.BGPPathAttr) {
 	p.PathAttrs = pa
 	p.Pref = p.calculatePref()
@@ -196,70 +233,71 @@ func (p *Path) GetPreference() uint32 {
 	return p.Pref
 }
 
+// GetAS4ByteList returns the ASes of the AS path, an AS_SET is a single "{ AS1, AS2 }" entry
 func (p *Path) GetAS4ByteList() []string {
-	asList := make([]string, 0)
-	for _, attr := range p.PathAttrs {
-		if attr.GetCode() == packet.BGPPathAttrTypeASPath {
-			asPaths := attr.(*packet.BGPPathAttrASPath).Value
-			asSize := attr.(*packet.BGPPathAttrASPath).ASSize
-			for _, asSegment := range asPaths {
-				if asSize == 4 {
-					seg := asSegment.(*packet.BGPAS4PathSegment)
-					if seg.Type == packet.BGPASPathSegmentSet {
-						asSetList := make([]string, 0, len(seg.AS))
-						for _, as := range seg.AS {
-							asSetList = append(asSetList, strconv.Itoa(int(as)))
-						}
-						asSetStr := strings.Join(asSetList, ", ")
-						asSetStr = "{ " + asSetStr + " }"
-						//asSetStr = append(asSetStr, "}")
-						asList = append(asList, asSetStr)
-					} else if seg.Type == packet.BGPASPathSegmentSequence {
-						//asSeqList := make([]string, 0, len(seg.AS))
-						for _, as := range seg.AS {
-							//asSeq := make([]int32, 1)
-							//asSeq[0] = int32(as)
-							//asSeqList = append(asSeqList, asSeq)
-							asList = append(asList, strconv.Itoa(int(as)))
-						}
-						//asList = append(asList, asSeqList...)
-					}
-				} else {
-					seg := asSegment.(*packet.BGPAS2PathSegment)
-					if seg.Type == packet.BGPASPathSegmentSet {
-						asSetList := make([]string, 0, len(seg.AS))
-						for _, as := range seg.AS {
-							asSetList = append(asSetList, strconv.Itoa(int(as)))
-						}
-						asSetStr := strings.Join(asSetList, ", ")
-						asSetStr = "{ " + asSetStr + " }"
-						//asSetStr = append("{", asSetStr...)
-						//asSetStr = append(asSetStr, "}")
-						asList = append(asList, asSetStr)
-					} else if seg.Type == packet.BGPASPathSegmentSequence {
-						//asSeqList := make([][]int32, 0, len(seg.AS))
-						for _, as := range seg.AS {
-							//asSeq := make([]int32, 1)
-							//asSeq[0] = int32(as)
-							//asSeqList = append(asSeqList, asSeq)
-							asList = append(asList, strconv.Itoa(int(as)))
-						}
-						//asList = append(asList, asSeqList...)
-					}
-				}
-			}
-			break
-		}
+	return packet.GetASList(p.PathAttrs)
+}
+
+// GetIGPCost returns the IGP metric to the next hop of the path
+func (p *Path) GetIGPCost() uint32 {
+	if p.reachabilityInfo == nil || p.reachabilityInfo.Metric < 0 {
+		return 0
+	}
+	return uint32(p.reachabilityInfo.Metric)
+}
+
+func (p *Path) GetCommunityList() []string {
+	communities := packet.GetCommunities(p.PathAttrs)
+	communityList := make([]string, 0, len(communities))
+	for _, community := range communities {
+		communityList = append(communityList, packet.CommunityToString(community))
 	}
+	return communityList
+}
+
+func (p *Path) GetExtCommunityList() []string {
+	extCommunities := packet.GetExtCommunities(p.PathAttrs)
+	extCommunityList := make([]string, 0, len(extCommunities))
+	for _, extCommunity := range extCommunities {
+		extCommunityList = append(extCommunityList, packet.ExtCommunityToString(extCommunity))
+	}
+	return extCommunityList
+}
 
-	return asList
+func (p *Path) GetLargeCommunityList() []string {
+	largeCommunities := packet.GetLargeCommunities(p.PathAttrs)
+	largeCommunityList := make([]string, 0, len(largeCommunities))
+	for _, largeCommunity := range largeCommunities {
+		largeCommunityList = append(largeCommunityList, packet.LargeCommunityToString(largeCommunity))
+	}
+	return largeCommunityList
 }
 
+/*  Returns true when the AS path has the local AS or the global AS more times than the
+ *  AllowAsIn count of the neighbor. The local AS prepended to the AS path of the routes
+ *  received from a neighbor with local-as is not a loop.
+ */
 func (p *Path) HasASLoop() bool {
 	if p.NeighborConf == nil {
 		return false
 	}
-	return packet.HasASLoop(p.PathAttrs, p.NeighborConf.RunningConf.LocalAS)
+
+	conf := &p.NeighborConf.RunningConf
+	count := packet.CountASInPath(p.PathAttrs, conf.LocalAS)
+	if p.NeighborConf.IsLocalASPrependedIn() {
+		count--
+	}
+
+	global := p.NeighborConf.Global
+	if global != nil && global.AS != conf.LocalAS {
+		count += packet.CountASInPath(p.PathAttrs, global.AS)
+	}
+
+	// The confederation identifier in the AS path of a path from outside the confederation is a loop
+	if global != nil && global.ConfederationId != 0 && global.ConfederationId != conf.LocalAS {
+		count += packet.CountASInPath(p.PathAttrs, global.ConfederationId)
+	}
+	return count > int(conf.AllowAsIn)
 }
 
 func (p *Path) IsLocal() bool {
@@ -64,6 +64,15 @@ func (b ByPref) Less(i, j int) bool {
 	return b.Paths[i].Pref < b.Paths[i].Pref
 }
 
+type ByValidationState struct {
+	Paths
+	dest *Destination
+}
+
+func (b ByValidationState) Less(i, j int) bool {
+	return isValidationStateBetter(b.dest.getValidationState(b.Paths[i]), b.dest.getValidationState(b.Paths[j]))
+}
+
 type BySmallestAS struct {
 	Paths
 }
@@ -80,6 +89,15 @@ func (b ByLowestOrigin) Less(i, j int) bool {
 	return b.Paths[i].GetOrigin() < b.Paths[i].GetOrigin()
 }
 
+type ByLowestMED struct {
+	Paths
+	dest *Destination
+}
+
+func (b ByLowestMED) Less(i, j int) bool {
+	return b.dest.getMED(b.Paths[i]) < b.dest.getMED(b.Paths[j])
+}
+
 type ByIBGPOrEBGPRoutes struct {
 	Paths
 }
@@ -88,6 +106,15 @@ func (b ByIBGPOrEBGPRoutes) Less(i, j int) bool {
 	return true
 }
 
+type ByLowestIGPCost struct {
+	Paths
+	dest *Destination
+}
+
+func (b ByLowestIGPCost) Less(i, j int) bool {
+	return b.dest.getIGPCost(b.Paths[i]) < b.dest.getIGPCost(b.Paths[j])
+}
+
 type ByLowestBGPId struct {
 	Paths
 }
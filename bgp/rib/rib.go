@@ -30,6 +30,7 @@ import (
 	"l3/bgp/baseobjects"
 	"l3/bgp/config"
 	"l3/bgp/packet"
+	"l3/bgp/rpki"
 	"net"
 	"sync"
 	"time"
@@ -69,6 +70,15 @@ type AdjRib struct {
 	routeListDirty   bool
 	activeGet        bool
 	timer            *time.Timer
+	vrpTable         *rpki.VRPTable
+	dampInfoMap      map[dampKey]*dampInfo
+	dampMutex        sync.RWMutex
+	vrf              string
+	rd               packet.RouteDistinguisher
+	vrfPathIds       map[vrfPathKey]uint32
+	nextVrfPathId    uint32
+	vpn              bool
+	view             bool
 }
 
 func NewAdjRib(logger *logging.Writer, rMgr config.RouteMgrIntf,
@@ -84,6 +94,7 @@ func NewAdjRib(logger *logging.Writer, rMgr config.RouteMgrIntf,
 		routeListDirty:   false,
 		activeGet:        false,
 		routeMutex:       sync.RWMutex{},
+		dampInfoMap:      make(map[dampKey]*dampInfo),
 	}
 
 	rib.timer = time.AfterFunc(time.Duration(100)*time.Second, rib.ResetRouteList)
@@ -93,8 +104,7 @@ func NewAdjRib(logger *logging.Writer, rMgr config.RouteMgrIntf,
 
 func isIpInList(prefixes []packet.NLRI, ip packet.NLRI) bool {
 	for _, nlri := range prefixes {
-		if nlri.GetPathId() == ip.GetPathId() &&
-			nlri.GetPrefix().Prefix.Equal(ip.GetPrefix().Prefix) {
+		if nlri.GetPathId() == ip.GetPathId() && packet.GetNLRIKey(nlri) == packet.GetNLRIKey(ip) {
 			return true
 		}
 	}
@@ -102,7 +112,13 @@ func isIpInList(prefixes []packet.NLRI, ip packet.NLRI) bool {
 }
 
 func (adjRib *AdjRib) GetReachabilityInfo(path *Path) *ReachabilityInfo {
-	ipStr := path.GetNextHop().String()
+	nextHopIP := path.GetNextHop()
+	ipStr := nextHopIP.String()
+	if nextHopIP.IsLinkLocalUnicast() && path.NeighborConf != nil && path.NeighborConf.RunningConf.IfIndex != 0 {
+		// Link-local next hops are only valid on the interface of the neighbor they were learnt from
+		return NewReachabilityInfo(ipStr, 0, path.NeighborConf.RunningConf.IfIndex, 0)
+	}
+
 	if reachabilityInfo, ok := adjRib.reachabilityMap[ipStr]; ok {
 		return reachabilityInfo
 	}
@@ -136,10 +152,13 @@ func (adjRib *AdjRib) GetDestFromIPAndLen(ip string, cidrLen uint32) *Destinatio
 }
 
 func (adjRib *AdjRib) GetDest(nlri packet.NLRI, createIfNotExist bool) (*Destination, bool) {
-	dest, ok := adjRib.destPathMap[nlri.GetPrefix().Prefix.String()]
+	key := adjRib.getDestKey(nlri)
+	dest, ok := adjRib.destPathMap[key]
 	if !ok && createIfNotExist {
 		dest = NewDestination(adjRib, nlri.GetPrefix(), adjRib.gConf)
-		adjRib.destPathMap[nlri.GetPrefix().Prefix.String()] = dest
+		dest.protoFamily = adjRib.getDestProtocolFamily(nlri)
+		dest.key = key
+		adjRib.destPathMap[key] = dest
 	}
 
 	return dest, ok
@@ -191,15 +210,17 @@ func (adjRib *AdjRib) ProcessRoutes(peerIP string, add []packet.NLRI, addPath *P
 					nlri.GetPrefix().Prefix.String()))
 				continue
 			}
-			oldPath := dest.RemovePath(peerIP, nlri.GetPathId(), remPath)
+			pathId := adjRib.getPathId(peerIP, nlri)
+			oldPath := dest.RemovePath(peerIP, pathId, remPath)
+			adjRib.dampenWithdrawnPath(dest, peerIP, pathId, oldPath)
 			if oldPath != nil && !oldPath.IsReachable() {
 				nextHopStr := oldPath.GetNextHop().String()
 				if _, ok := adjRib.unreachablePaths[nextHopStr]; ok {
 					if _, ok := adjRib.unreachablePaths[nextHopStr][oldPath]; ok {
 						if pathIds, ok :=
 							adjRib.unreachablePaths[nextHopStr][oldPath][dest]; ok {
-							for idx, pathId := range pathIds {
-								if pathId == nlri.GetPathId() {
+							for idx, id := range pathIds {
+								if id == pathId {
 									adjRib.unreachablePaths[nextHopStr][oldPath][dest][idx] =
 										pathIds[len(pathIds)-1]
 									adjRib.unreachablePaths[nextHopStr][oldPath][dest] =
@@ -229,17 +250,17 @@ func (adjRib *AdjRib) ProcessRoutes(peerIP string, add []packet.NLRI, addPath *P
 				adjRib.updateRibOutInfo(action, addPathsMod, addRoutes, updRoutes,
 					delRoutes, dest, withdrawn, updated, updatedAddPaths)
 
-			if oldPath != nil && remPath != nil {
+			if oldPath != nil && !oldPath.IsStale() && remPath != nil && adjRib.countsPrefixes() {
 				if neighborConf := remPath.GetNeighborConf(); neighborConf != nil {
 					adjRib.logger.Info(fmt.Sprintln("Decrement prefix count for",
 						"destination %s from Peer %s",
 						nlri.GetPrefix().Prefix.String(), peerIP))
-					neighborConf.DecrPrefixCount()
+					neighborConf.DecrPrefixCount(packet.GetNLRIProtocolFamily(nlri))
 				}
 			}
 			if action == RouteActionDelete {
 				if dest.IsEmpty() {
-					delete(adjRib.destPathMap, nlri.GetPrefix().Prefix.String())
+					delete(adjRib.destPathMap, dest.key)
 				}
 			}
 		} else {
@@ -258,9 +279,10 @@ func (adjRib *AdjRib) ProcessRoutes(peerIP string, add []packet.NLRI, addPath *P
 
 		adjRib.logger.Info(fmt.Sprintln("Processing nlri", nlri.GetPrefix().Prefix.String()))
 		dest, _ := adjRib.GetDest(nlri, true)
-		if oldPath := dest.getPathForIP(peerIP, nlri.GetPathId()); oldPath == nil &&
-			addPath.NeighborConf != nil {
-			if !addPath.NeighborConf.CanAcceptNewPrefix() {
+		pathId := adjRib.getPathId(peerIP, nlri)
+		oldPath := dest.getPathForIP(peerIP, pathId)
+		if (oldPath == nil || oldPath.IsStale()) && addPath.NeighborConf != nil && adjRib.countsPrefixes() {
+			if !addPath.NeighborConf.CanAcceptNewPrefix(packet.GetNLRIProtocolFamily(nlri)) {
 				adjRib.logger.Info(fmt.Sprintf("Max prefixes limit reached for",
 					"peer %s, can't process %s",
 					peerIP, nlri.GetPrefix().Prefix.String()))
@@ -269,18 +291,18 @@ func (adjRib *AdjRib) ProcessRoutes(peerIP string, add []packet.NLRI, addPath *P
 			}
 			adjRib.logger.Info(fmt.Sprintf("Increment prefix count for destination %s",
 				"from Peer %s", nlri.GetPrefix().Prefix.String(), peerIP))
-			addPath.NeighborConf.IncrPrefixCount()
+			addPath.NeighborConf.IncrPrefixCount(packet.GetNLRIProtocolFamily(nlri))
 		}
 
-		dest.AddOrUpdatePath(peerIP, nlri.GetPathId(), addPath)
+		adjRib.dampenUpdatedPath(dest, peerIP, pathId, oldPath, addPath)
+		dest.AddOrUpdatePath(peerIP, pathId, addPath)
 		if !addPath.IsReachable() {
 			if _, ok := adjRib.unreachablePaths[nextHopStr][addPath][dest]; !ok {
 				adjRib.unreachablePaths[nextHopStr][addPath][dest] = make([]uint32, 0)
 			}
 
 			adjRib.unreachablePaths[nextHopStr][addPath][dest] =
-				append(adjRib.unreachablePaths[nextHopStr][addPath][dest],
-					nlri.GetPathId())
+				append(adjRib.unreachablePaths[nextHopStr][addPath][dest], pathId)
 			continue
 		}
 
@@ -333,6 +355,10 @@ func (adjRib *AdjRib) ProcessUpdate(neighborConf *base.NeighborConf, pktInfo *pa
 
 	remPath := NewPath(adjRib, neighborConf, body.PathAttributes, true, false, RouteTypeEGP)
 	addPath := NewPath(adjRib, neighborConf, body.PathAttributes, false, true, RouteTypeEGP)
+	if len(body.NLRI) > 0 {
+		// The NLRIs of an update are split by their labels before they are processed
+		addPath.labels = packet.GetNLRILabels(body.NLRI[0])
+	}
 
 	reachabilityInfo := adjRib.GetReachabilityInfo(addPath)
 	addPath.SetReachabilityInfo(reachabilityInfo)
@@ -345,6 +371,11 @@ func (adjRib *AdjRib) ProcessUpdate(neighborConf *base.NeighborConf, pktInfo *pa
 		return nil, nil, nil, nil, true
 	}
 
+	nlriList := body.NLRI
+	if addPath.isOwn() {
+		nlriList = adjRib.removeSourceVrfRoutes(nlriList)
+	}
+
 	nextHopStr := addPath.GetNextHop().String()
 	if reachabilityInfo == nil {
 		adjRib.logger.Info(fmt.Sprintf("ProcessUpdate - next hop %s is not reachable",
@@ -360,7 +391,7 @@ func (adjRib *AdjRib) ProcessUpdate(neighborConf *base.NeighborConf, pktInfo *pa
 	}
 
 	updated, withdrawn, updatedAddPaths, addedAllPrefixes :=
-		adjRib.ProcessRoutes(pktInfo.Src, body.NLRI, addPath,
+		adjRib.ProcessRoutes(pktInfo.Src, nlriList, addPath,
 			body.WithdrawnRoutes, remPath, addPathCount)
 	addPath.updated = false
 
@@ -416,13 +447,129 @@ func (adjRib *AdjRib) RemoveUpdatesFromNeighbor(peerIP string, neighborConf *bas
 			delete(adjRib.destPathMap, destIP)
 		}
 	}
+	adjRib.releasePathIds(peerIP)
 
-	if neighborConf != nil {
+	if neighborConf != nil && adjRib.countsPrefixes() {
 		neighborConf.SetPrefixCount(0)
 	}
 	return updated, withdrawn, remPath, updatedAddPaths
 }
 
+func (adjRib *AdjRib) MarkStaleUpdatesFromNeighbor(peerIP string) int {
+	numStaleDests := 0
+	for _, dest := range adjRib.destPathMap {
+		if dest.MarkStalePaths(peerIP) {
+			numStaleDests++
+		}
+	}
+
+	adjRib.logger.Info(fmt.Sprintln("MarkStaleUpdatesFromNeighbor - Neighbor", peerIP,
+		"marked paths for", numStaleDests, "destinations as stale"))
+	return numStaleDests
+}
+
+func (adjRib *AdjRib) RemoveStaleUpdatesFromNeighbor(peerIP string, neighborConf *base.NeighborConf,
+	addPathCount int) (
+	map[*Path][]*Destination, []*Destination, *Path, []*Destination) {
+	return adjRib.RemoveStaleFamilyUpdatesFromNeighbor(peerIP, 0, neighborConf, addPathCount)
+}
+
+// RemoveStaleFamilyUpdatesFromNeighbor removes the stale paths of a protocol family, of all the families if it is 0
+func (adjRib *AdjRib) RemoveStaleFamilyUpdatesFromNeighbor(peerIP string, protoFamily uint32,
+	neighborConf *base.NeighborConf, addPathCount int) (
+	map[*Path][]*Destination, []*Destination, *Path, []*Destination) {
+	remPath := NewPath(adjRib, neighborConf, nil, true, false, RouteTypeEGP)
+	withdrawn := make([]*Destination, 0)
+	updated := make(map[*Path][]*Destination)
+	updatedAddPaths := make([]*Destination, 0)
+
+	for destIP, dest := range adjRib.destPathMap {
+		if protoFamily != 0 && dest.protoFamily != protoFamily {
+			continue
+		}
+
+		if !dest.RemoveStalePaths(peerIP, remPath) {
+			continue
+		}
+
+		action, addPathsMod, addRoutes, updRoutes, delRoutes :=
+			dest.SelectRouteForLocRib(addPathCount)
+		adjRib.logger.Info(fmt.Sprintln("RemoveStaleUpdatesFromNeighbor - dest",
+			dest.IPPrefix.Prefix.String(), "SelectRouteForLocRib returned action",
+			action, "addRoutes", addRoutes, "updRoutes", updRoutes,
+			"delRoutes", delRoutes))
+		withdrawn, updated, updatedAddPaths = adjRib.updateRibOutInfo(action,
+			addPathsMod, addRoutes, updRoutes,
+			delRoutes, dest, withdrawn, updated, updatedAddPaths)
+		if action == RouteActionDelete && dest.IsEmpty() {
+			delete(adjRib.destPathMap, destIP)
+		}
+	}
+
+	return updated, withdrawn, remPath, updatedAddPaths
+}
+
+// MarkRefreshStaleFamilyUpdatesFromNeighbor marks the paths of a protocol family from the neighbor as route refresh stale
+func (adjRib *AdjRib) MarkRefreshStaleFamilyUpdatesFromNeighbor(peerIP string, protoFamily uint32) int {
+	numStaleDests := 0
+	for _, dest := range adjRib.destPathMap {
+		if dest.protoFamily == protoFamily && dest.MarkRefreshStalePaths(peerIP) {
+			numStaleDests++
+		}
+	}
+
+	adjRib.logger.Info(fmt.Sprintln("MarkRefreshStaleFamilyUpdatesFromNeighbor - Neighbor", peerIP,
+		"family", protoFamily, "marked paths for", numStaleDests, "destinations as stale"))
+	return numStaleDests
+}
+
+func (adjRib *AdjRib) RemoveRefreshStaleFamilyUpdatesFromNeighbor(peerIP string, protoFamily uint32,
+	neighborConf *base.NeighborConf, addPathCount int) (
+	map[*Path][]*Destination, []*Destination, *Path, []*Destination) {
+	remPath := NewPath(adjRib, neighborConf, nil, true, false, RouteTypeEGP)
+	withdrawn := make([]*Destination, 0)
+	updated := make(map[*Path][]*Destination)
+	updatedAddPaths := make([]*Destination, 0)
+
+	for destIP, dest := range adjRib.destPathMap {
+		if dest.protoFamily != protoFamily {
+			continue
+		}
+
+		removed, counted := dest.RemoveRefreshStalePaths(peerIP, remPath)
+		if removed == 0 {
+			continue
+		}
+
+		if neighborConf != nil && adjRib.countsPrefixes() {
+			for i := 0; i < counted; i++ {
+				neighborConf.DecrPrefixCount(protoFamily)
+			}
+		}
+		action, addPathsMod, addRoutes, updRoutes, delRoutes :=
+			dest.SelectRouteForLocRib(addPathCount)
+		withdrawn, updated, updatedAddPaths = adjRib.updateRibOutInfo(action,
+			addPathsMod, addRoutes, updRoutes,
+			delRoutes, dest, withdrawn, updated, updatedAddPaths)
+		if action == RouteActionDelete && dest.IsEmpty() {
+			delete(adjRib.destPathMap, destIP)
+		}
+	}
+
+	return updated, withdrawn, remPath, updatedAddPaths
+}
+
+/*  HasRoute returns true if the route to the prefix with the next hop is selected in the Loc-RIB
+ *  and installed in the RIB manager.
+ */
+func (adjRib *AdjRib) HasRoute(ip string, cidrLen uint32, nextHop string) bool {
+	dest := adjRib.GetDestFromIPAndLen(ip, cidrLen)
+	if dest == nil || uint32(dest.IPPrefix.Length) != cidrLen {
+		return false
+	}
+	return dest.hasRoute(nextHop)
+}
+
 func (adjRib *AdjRib) RemoveUpdatesFromAllNeighbors(addPathCount int) {
 	withdrawn := make([]*Destination, 0)
 	updated := make(map[*Path][]*Destination)
@@ -452,6 +599,54 @@ func (adjRib *AdjRib) GetLocRib() map[*Path][]*Destination {
 	return updated
 }
 
+// Returns the paths received from a neighbor, after the import policy, as update messages
+func (adjRib *AdjRib) GetNeighborUpdates(peerIP string) []*packet.BGPUpdate {
+	pathNLRIMap := make(map[*Path][]packet.NLRI)
+	for _, dest := range adjRib.destPathMap {
+		pathMap, ok := dest.peerPathMap[peerIP]
+		if !ok {
+			continue
+		}
+
+		for pathId, path := range pathMap {
+			pathNLRIMap[path] = append(pathNLRIMap[path], dest.getNLRI(pathId))
+		}
+	}
+	return getPathUpdates(pathNLRIMap)
+}
+
+// GetNeighborPathUpdates returns the updates with the paths of the NLRIs received from the neighbor
+func (adjRib *AdjRib) GetNeighborPathUpdates(peerIP string, nlris []packet.NLRI) []*packet.BGPUpdate {
+	pathNLRIMap := make(map[*Path][]packet.NLRI)
+	for _, nlri := range nlris {
+		dest, ok := adjRib.GetDest(nlri, false)
+		if !ok {
+			continue
+		}
+
+		if path := dest.getPathForIP(peerIP, nlri.GetPathId()); path != nil {
+			pathNLRIMap[path] = append(pathNLRIMap[path], nlri)
+		}
+	}
+	return getPathUpdates(pathNLRIMap)
+}
+
+func getPathUpdates(pathNLRIMap map[*Path][]packet.NLRI) []*packet.BGPUpdate {
+	updates := make([]*packet.BGPUpdate, 0, len(pathNLRIMap))
+	for path, nlriList := range pathNLRIMap {
+		pathAttrs := make([]packet.BGPPathAttr, 0, len(path.PathAttrs))
+		for _, pa := range path.PathAttrs {
+			pathAttrs = append(pathAttrs, pa.Clone())
+		}
+		updates = append(updates, &packet.BGPUpdate{
+			WithdrawnRoutes: make([]packet.NLRI, 0),
+			PathAttributes:  pathAttrs,
+			NLRI:            nlriList,
+		})
+	}
+	return updates
+}
+
 func (adjRib *AdjRib) RemoveRouteFromAggregate(ip *packet.IPPrefix, aggIP *packet.IPPrefix,
 	srcIP string, bgpAgg *config.BGPAggregate, ipDest *Destination,
 	addPathCount int) (map[*Path][]*Destination, []*Destination,
@@ -651,6 +846,18 @@ func (adjRib *AdjRib) GetBGPRoute(prefix string) *bgpd.BGPRouteState {
 	return nil
 }
 
+// GetBGPRouteAttrs returns the path attrs of the Loc-RIB route of the prefix that are not in the BGP route state
+func (adjRib *AdjRib) GetBGPRouteAttrs(prefix string) *config.RouteAttrsState {
+	defer adjRib.routeMutex.RUnlock()
+	adjRib.routeMutex.RLock()
+
+	if dest, ok := adjRib.destPathMap[prefix]; ok && dest.LocRibPathRoute != nil {
+		return dest.LocRibPathRoute.GetRouteAttrs()
+	}
+
+	return nil
+}
+
 func (adjRib *AdjRib) BulkGetBGPRoutes(index int, count int) (int, int, []*bgpd.BGPRouteState) {
 	adjRib.timer.Stop()
 	if index == 0 && adjRib.activeGet {
@@ -26,6 +26,7 @@ package server
 
 import (
 	"bgpd"
+	"l3/bgp/config"
 	"time"
 )
 
@@ -40,6 +41,7 @@ const (
 
 type Route struct {
 	BGPRouteState    *bgpd.BGPRouteState
+	attrsState       *config.RouteAttrsState
 	dest             *Destination
 	path             *Path
 	routeListIdx     int
@@ -62,8 +64,18 @@ func NewRoute(dest *Destination, path *Path, action RouteAction, inPathId, outPa
 		PathId:      int32(inPathId),
 		UpdatedTime: currTime.String(),
 	}
+	attrsState := &config.RouteAttrsState{
+		Network:             bgpRoute.Network,
+		CIDRLen:             bgpRoute.CIDRLen,
+		PathId:              bgpRoute.PathId,
+		Communities:         path.GetCommunityList(),
+		ExtendedCommunities: path.GetExtCommunityList(),
+		LargeCommunities:    path.GetLargeCommunityList(),
+		ValidationState:     dest.getValidationState(path).String(),
+	}
 	return &Route{
 		BGPRouteState:    bgpRoute,
+		attrsState:       attrsState,
 		dest:             dest,
 		path:             path,
 		routeListIdx:     -1,
@@ -82,6 +94,10 @@ func (r *Route) GetBGPRoute() *bgpd.BGPRouteState {
 	return r.BGPRouteState
 }
 
+func (r *Route) GetRouteAttrs() *config.RouteAttrsState {
+	return r.attrsState
+}
+
 func (r *Route) update() {
 	r.time = time.Now()
 	r.BGPRouteState.UpdatedTime = r.time.String()
@@ -0,0 +1,51 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// routeServer.go
+package server
+
+import (
+	"l3/bgp/packet"
+)
+
+/*  SetView makes the rib the view of the paths selected for a route server client (RFC 7947).
+ *  A view is fed only the paths accepted by the global rib and not suppressed by its dampening,
+ *  so it doesn't count the prefixes of the neighbors or dampen their paths again.
+ */
+func (adjRib *AdjRib) SetView() {
+	adjRib.view = true
+}
+
+/*  IsPathAccepted returns true if the path of the NLRI received from the neighbor is in the rib
+ *  and is not suppressed. The paths rejected by the prefix limit of the neighbor are not added
+ *  to the rib.
+ */
+func (adjRib *AdjRib) IsPathAccepted(peerIP string, nlri packet.NLRI) bool {
+	dest, ok := adjRib.GetDest(nlri, false)
+	if !ok {
+		return false
+	}
+
+	path := dest.getPathForIP(peerIP, nlri.GetPathId())
+	return path != nil && !path.IsStale() && !adjRib.isPathSuppressed(dest, peerIP, nlri.GetPathId())
+}
@@ -0,0 +1,157 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// rpki.go
+package server
+
+import (
+	"fmt"
+	"l3/bgp/baseobjects"
+	"l3/bgp/packet"
+	"l3/bgp/rpki"
+)
+
+func (adjRib *AdjRib) SetVRPTable(vrpTable *rpki.VRPTable) {
+	adjRib.vrpTable = vrpTable
+}
+
+// GetValidationState returns the origin validation state of the prefix. Paths with an empty AS path are
+// originated in the local AS.
+func (adjRib *AdjRib) GetValidationState(ipPrefix *packet.IPPrefix, pathAttrs []packet.BGPPathAttr,
+	neighborConf *base.NeighborConf) rpki.ValidationState {
+	if adjRib.vrpTable == nil {
+		return rpki.ValidationStateNotFound
+	}
+
+	originAS, ok := packet.GetOriginAS(pathAttrs)
+	if ok && originAS == 0 {
+		originAS = adjRib.gConf.AS
+		if adjRib.gConf.ConfederationId != 0 {
+			// Routes originated within the confederation are seen with the confederation identifier
+			originAS = adjRib.gConf.ConfederationId
+		} else if neighborConf != nil {
+			originAS = neighborConf.RunningConf.LocalAS
+		}
+	}
+	return adjRib.vrpTable.Validate(ipPrefix.Prefix, ipPrefix.Length, originAS, ok)
+}
+
+func (d *Destination) getValidationState(path *Path) rpki.ValidationState {
+	return d.rib.GetValidationState(d.IPPrefix, path.PathAttrs, path.NeighborConf)
+}
+
+func (d *Destination) updateValidationStates() {
+	for path, route := range d.pathRouteMap {
+		route.attrsState.ValidationState = d.getValidationState(path).String()
+	}
+}
+
+func (d *Destination) getRoutesWithBestValidationState(updatedPaths []*Path,
+	prunedPaths []PathSortIface) ([]*Path, []PathSortIface) {
+	bestState := rpki.ValidationStateInvalid
+	removedPaths := make([]*Path, 0)
+	n := len(updatedPaths)
+	idx := 0
+
+	for i := 0; i < n; i++ {
+		state := d.getValidationState(updatedPaths[i])
+		if idx > 0 && isValidationStateBetter(bestState, state) {
+			removedPaths = append(removedPaths, updatedPaths[i])
+		} else if idx == 0 || isValidationStateBetter(state, bestState) {
+			removedPaths = append(removedPaths, updatedPaths[:idx]...)
+			bestState = state
+			updatedPaths[0] = updatedPaths[i]
+			idx = 1
+		} else {
+			updatedPaths[idx] = updatedPaths[i]
+			idx++
+		}
+	}
+	d.logger.Info(fmt.Sprintln("Destination:getRoutesWithBestValidationState - Dest =", d.IPPrefix.Prefix,
+		"best validation state =", bestState))
+
+	if len(removedPaths) > 0 {
+		pathSortIface := PathSortIface{
+			paths: removedPaths,
+			iface: ByValidationState{removedPaths, d},
+		}
+		prunedPaths = append(prunedPaths, pathSortIface)
+	}
+
+	for i := idx; i < n; i++ {
+		updatedPaths[i] = nil
+	}
+	return updatedPaths[:idx], prunedPaths
+}
+
+// Valid routes are preferred over the not found routes that are preferred over the invalid routes
+func isValidationStateBetter(a, b rpki.ValidationState) bool {
+	return getValidationStateRank(a) < getValidationStateRank(b)
+}
+
+func getValidationStateRank(state rpki.ValidationState) int {
+	switch state {
+	case rpki.ValidationStateValid:
+		return 0
+	case rpki.ValidationStateNotFound:
+		return 1
+	}
+	return 2
+}
+
+/*  Update the validation state of all the routes covered by the VRPs that were added to or
+ *  removed from the VRP table. When the valid routes are preferred, the best path of the
+ *  destinations is selected again.
+ */
+func (adjRib *AdjRib) ValidateRoutes(changedVRPs []rpki.VRP, addPathCount int) (
+	map[*Path][]*Destination, []*Destination, []*Destination) {
+	withdrawn := make([]*Destination, 0)
+	updated := make(map[*Path][]*Destination)
+	updatedAddPaths := make([]*Destination, 0)
+	if len(changedVRPs) == 0 {
+		return updated, withdrawn, updatedAddPaths
+	}
+
+	changedTable := rpki.NewVRPTable()
+	changedTable.SetCacheVRPs("", changedVRPs)
+	for destIP, dest := range adjRib.destPathMap {
+		if !changedTable.Covers(dest.IPPrefix.Prefix, dest.IPPrefix.Length) {
+			continue
+		}
+
+		dest.updateValidationStates()
+		if !adjRib.gConf.RpkiPreferValid {
+			continue
+		}
+
+		dest.recalculate = true
+		action, addPathsMod, addRoutes, updRoutes, delRoutes := dest.SelectRouteForLocRib(addPathCount)
+		withdrawn, updated, updatedAddPaths = adjRib.updateRibOutInfo(action, addPathsMod, addRoutes,
+			updRoutes, delRoutes, dest, withdrawn, updated, updatedAddPaths)
+		if action == RouteActionDelete && dest.IsEmpty() {
+			delete(adjRib.destPathMap, destIP)
+		}
+	}
+
+	return updated, withdrawn, updatedAddPaths
+}
@@ -0,0 +1,128 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// vpn.go
+package server
+
+import (
+	"fmt"
+	"l3/bgp/packet"
+)
+
+/*  SetVrf sets the VRF of the routes the rib installs and its route distinguisher, the routes
+ *  of the global rib don't have a VRF. A VRF rib keeps the routes by their IP prefix, the local
+ *  routes of the VRF and the VPN routes of all the route distinguishers are the paths of the
+ *  destination of their prefix and the best path is selected among them.
+ */
+func (adjRib *AdjRib) SetVrf(vrf string, rd packet.RouteDistinguisher) {
+	adjRib.vrf = vrf
+	adjRib.rd = rd
+	adjRib.vrfPathIds = make(map[vrfPathKey]uint32)
+}
+
+/*  SetVPN makes the rib the VPN rib, the rib of the VPN routes received from the neighbors
+ *  before they are imported into the VRFs. The VPN rib counts the routes in the prefix limits
+ *  of the neighbors once per received route, the VRF ribs don't count the routes imported
+ *  into them. The routes are dampened in the VRF ribs.
+ */
+func (adjRib *AdjRib) SetVPN() {
+	adjRib.vpn = true
+}
+
+// The prefixes of the neighbors are counted by the global rib and the VPN rib
+func (adjRib *AdjRib) countsPrefixes() bool {
+	return !adjRib.view && adjRib.vrf == ""
+}
+
+func (adjRib *AdjRib) getDestKey(nlri packet.NLRI) string {
+	if adjRib.vrf != "" {
+		return packet.GetNLRIKey(nlri.GetPrefix())
+	}
+	return packet.GetNLRIKey(nlri)
+}
+
+// The destinations of a VRF rib have the VPN family of the address family of their prefix
+func (adjRib *AdjRib) getDestProtocolFamily(nlri packet.NLRI) uint32 {
+	protoFamily := packet.GetNLRIProtocolFamily(nlri)
+	if adjRib.vrf != "" {
+		afi, _ := packet.GetAfiSafi(protoFamily)
+		protoFamily = packet.GetProtocolFamily(afi, packet.SafiMPLSVPN)
+	}
+	return protoFamily
+}
+
+// vrfPathKey is a route received from a neighbor, with its route distinguisher, and its path id
+type vrfPathKey struct {
+	peerIP  string
+	nlriKey string
+	pathId  uint32
+}
+
+/*  getPathId returns the id of the path of the route in its destination. A neighbor, like a route
+ *  reflector, may send the same prefix with different route distinguishers and the routes are
+ *  different paths of the destination in a VRF rib, each route gets a path id of its own. The
+ *  path ids are kept until the routes of the neighbor are removed.
+ */
+func (adjRib *AdjRib) getPathId(peerIP string, nlri packet.NLRI) uint32 {
+	if adjRib.vrf == "" {
+		return nlri.GetPathId()
+	}
+
+	key := vrfPathKey{peerIP, packet.GetNLRIKey(nlri), nlri.GetPathId()}
+	pathId, ok := adjRib.vrfPathIds[key]
+	if !ok {
+		adjRib.nextVrfPathId++
+		pathId = adjRib.nextVrfPathId
+		adjRib.vrfPathIds[key] = pathId
+	}
+	return pathId
+}
+
+func (adjRib *AdjRib) releasePathIds(peerIP string) {
+	for key := range adjRib.vrfPathIds {
+		if key.peerIP == peerIP {
+			delete(adjRib.vrfPathIds, key)
+		}
+	}
+}
+
+// GetLabels returns the MPLS labels the path was received with
+func (p *Path) GetLabels() []uint32 {
+	return p.labels
+}
+
+/*  The own routes accepted with the ACCEPT_OWN community are imported only into the VRFs other
+ *  than the source VRF of the route, the VRF with the route distinguisher of the route.
+ */
+func (adjRib *AdjRib) removeSourceVrfRoutes(nlriList []packet.NLRI) []packet.NLRI {
+	routes := make([]packet.NLRI, 0, len(nlriList))
+	for _, nlri := range nlriList {
+		if vpnPrefix, ok := nlri.(*packet.VPNPrefix); ok && vpnPrefix.RD == adjRib.rd {
+			adjRib.logger.Info(fmt.Sprintln("VRF", adjRib.vrf, "is the source VRF of own route", nlri,
+				"ignore the route"))
+			continue
+		}
+		routes = append(routes, nlri)
+	}
+	return routes
+}
@@ -44,20 +44,19 @@ const DBName string = "UsrConfDb.db"
 type PeerConfigCommands struct {
 	IP      net.IP
 	Command int
+	Message string
 }
 
 type BGPHandler struct {
-	PeerCommandCh chan PeerConfigCommands
-	server        *server.BGPServer
-	bgpPE         *bgppolicy.BGPPolicyEngine
-	logger        *logging.Writer
-	dbUtil        *dbutils.DBUtil
+	server *server.BGPServer
+	bgpPE  *bgppolicy.BGPPolicyEngine
+	logger *logging.Writer
+	dbUtil *dbutils.DBUtil
 }
 
 func NewBGPHandler(server *server.BGPServer, policy *bgppolicy.BGPPolicyEngine, logger *logging.Writer,
 	dbUtil *dbutils.DBUtil, filePath string) *BGPHandler {
 	h := new(BGPHandler)
-	h.PeerCommandCh = make(chan PeerConfigCommands)
 	h.server = server
 	h.bgpPE = policy
 	h.logger = logger
@@ -479,13 +478,20 @@ func (h *BGPHandler) getIPAndIfIndexForNeighbor(neighborIP string,
 		ifIndex = 0
 		if ip == nil {
 			err = errors.New(fmt.Sprintf("Neighbor address %s not valid", neighborIP))
+		} else if ip.IsLinkLocalUnicast() {
+			// Link-local neighbors need the interface to connect to the neighbor
+			ifIndex = neighborIfIndex
 		}
 	} else if neighborIfIndex != 0 {
 		//neighbor address is a ifIndex
 		var ipv4Intf string
 		// @TODO: this needs to be interface once we decide to move listener
 		ipv4Intf, err = h.server.IntfMgr.GetIPv4Information(neighborIfIndex)
-		if err == nil {
+		if err == nil && ipv4Intf == "" {
+			h.logger.Info(fmt.Sprintln("getIPAndIfIndexForNeighbor - interface", neighborIfIndex,
+				"has no IPv4 address, unnumbered neighbor"))
+			ifIndex = neighborIfIndex
+		} else if err == nil {
 			h.logger.Info(fmt.Sprintln("getIPAndIfIndexForNeighbor - Call ASICd",
 				"to get ip address for interface with ifIndex: ", neighborIfIndex))
 			ifIP, ipMask, err := net.ParseCIDR(ipv4Intf)
@@ -714,6 +720,11 @@ func (h *BGPHandler) UpdateBGPNeighbor(origN *bgpd.BGPNeighbor, updatedN *bgpd.B
 
 func (h *BGPHandler) DeleteBGPNeighbor(bgpNeighbor *bgpd.BGPNeighbor) (bool, error) {
 	h.logger.Info(fmt.Sprintln("Delete BGP neighbor:", bgpNeighbor.NeighborAddress))
+	if strings.TrimSpace(bgpNeighbor.NeighborAddress) == "" && bgpNeighbor.IfIndex != 0 {
+		h.server.RemUnnumberedPeerCh <- bgpNeighbor.IfIndex
+		return true, nil
+	}
+
 	ip := net.ParseIP(bgpNeighbor.NeighborAddress)
 	if ip == nil {
 		h.logger.Info(fmt.Sprintf("Can't delete BGP neighbor - IP[%s] not valid",
@@ -726,7 +737,7 @@ func (h *BGPHandler) DeleteBGPNeighbor(bgpNeighbor *bgpd.BGPNeighbor) (bool, err
 }
 
 func (h *BGPHandler) PeerCommand(in *PeerConfigCommands, out *bool) error {
-	h.PeerCommandCh <- *in
+	h.server.PeerCommandCh <- config.PeerCommand{IP: in.IP, Command: in.Command, Message: in.Message}
 	h.logger.Info(fmt.Sprintln("Good peer command:", in))
 	*out = true
 	return nil
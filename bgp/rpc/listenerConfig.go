@@ -0,0 +1,348 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// listenerConfig.go
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"l3/bgp/config"
+	"l3/bgp/packet"
+	"l3/bgp/server"
+	"net"
+	netrpc "net/rpc"
+	"net/rpc/jsonrpc"
+	"strconv"
+	"utils/logging"
+)
+
+/*  Config listener for the BGP features that are not in the BGP model objects. The methods
+ *  follow the net/rpc convention and take the config of the BGP server as it is, so that all
+ *  the neighbor, peer group and global options can be set. They are served with JSON-RPC on
+ *  the port of the bgpdConfig client in clients.json.
+ */
+
+func StartConfigServer(logger *logging.Writer, handler *BGPHandler, filePath string) {
+	clientJson, err := getClient(logger, filePath+ClientsFileName, "bgpdConfig")
+	if err != nil || clientJson == nil {
+		return
+	}
+
+	rpcServer := netrpc.NewServer()
+	if err = rpcServer.RegisterName("BGPD", handler); err != nil {
+		logger.Err(fmt.Sprintln("StartConfigServer: Failed to register the config handler, err:", err))
+		return
+	}
+
+	listener, err := net.Listen("tcp", "localhost:"+strconv.Itoa(clientJson.Port))
+	if err != nil {
+		logger.Err(fmt.Sprintln("StartConfigServer: Failed to listen on port", clientJson.Port, "err:", err))
+		return
+	}
+
+	logger.Info(fmt.Sprintln("Start the config listener on port", clientJson.Port))
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			logger.Err(fmt.Sprintln("StartConfigServer: Accept failed with error:", err))
+			continue
+		}
+		go rpcServer.ServeCodec(jsonrpc.NewServerCodec(conn))
+	}
+}
+
+func (h *BGPHandler) SetBGPGlobalConfig(in *config.GlobalConfig, out *bool) error {
+	if in.RouterId == nil {
+		return errors.New(fmt.Sprintf("BGPGlobal: Router id %s is not valid", in.RouterId))
+	}
+
+	h.logger.Info(fmt.Sprintln("Set global config:", *in))
+	h.server.GlobalConfigCh <- *in
+	*out = true
+	return nil
+}
+
+func (h *BGPHandler) SetBGPNeighborConfig(in *config.NeighborConfig, out *bool) error {
+	if !h.server.VerifyBgpGlobalConfig() {
+		return errors.New("Create BGP Local AS and router id before configuring Neighbor")
+	}
+
+	if in.NeighborAddress == nil && in.IfIndex == 0 {
+		return errors.New("Neighbor address is not set")
+	}
+
+	if !h.isValidIP(in.UpdateSource) {
+		return errors.New(fmt.Sprintf("Update source %s not a valid IP", in.UpdateSource))
+	}
+
+	var oldConf config.NeighborConfig
+	if in.NeighborAddress != nil && h.server.GetBGPNeighborState(in.NeighborAddress.String()) != nil {
+		oldConf.NeighborAddress = in.NeighborAddress
+	}
+	newConf := *in
+	h.setDefault(&newConf)
+
+	h.logger.Info(fmt.Sprintln("Set neighbor config:", newConf))
+	h.server.AddPeerCh <- server.PeerUpdate{OldPeer: oldConf, NewPeer: newConf, AttrSet: make([]bool, 0)}
+	*out = true
+	return nil
+}
+
+func (h *BGPHandler) SetBGPPeerGroupConfig(in *config.PeerGroupConfig, out *bool) error {
+	if in.Name == "" {
+		return errors.New("Peer group name is not set")
+	}
+
+	if !h.isValidIP(in.UpdateSource) {
+		return errors.New(fmt.Sprintf("Update source %s not a valid IP", in.UpdateSource))
+	}
+
+	h.logger.Info(fmt.Sprintln("Set peer group config:", *in))
+	h.server.AddPeerGroupCh <- server.PeerGroupUpdate{NewGroup: *in, AttrSet: make([]bool, 0)}
+	*out = true
+	return nil
+}
+
+func (h *BGPHandler) GetBGPRouteAttrs(network *string, out *config.RouteAttrsState) error {
+	routeAttrs := h.server.AdjRib.GetBGPRouteAttrs(*network)
+	if routeAttrs == nil {
+		return errors.New(fmt.Sprintf("Route %s not found", *network))
+	}
+
+	*out = *routeAttrs
+	return nil
+}
+
+func (h *BGPHandler) CreateBmpCollector(in *config.BmpCollectorConfig, out *bool) error {
+	if net.ParseIP(in.Address) == nil {
+		return errors.New(fmt.Sprintf("BMP collector address %s is not a valid IP", in.Address))
+	}
+
+	if in.Port == 0 {
+		return errors.New(fmt.Sprintf("BMP collector %s port is not set", in.Address))
+	}
+
+	switch in.RouteMonitoring {
+	case "", config.BmpRouteMonitoringPrePolicy, config.BmpRouteMonitoringPostPolicy, config.BmpRouteMonitoringAll:
+	default:
+		return errors.New(fmt.Sprintf("BMP collector %s route monitoring %s is not valid", in.Address,
+			in.RouteMonitoring))
+	}
+
+	h.logger.Info(fmt.Sprintln("Create BMP collector:", *in))
+	h.server.AddBmpCollectorCh <- *in
+	*out = true
+	return nil
+}
+
+func (h *BGPHandler) DeleteBmpCollector(in *config.BmpCollectorConfig, out *bool) error {
+	h.logger.Info(fmt.Sprintln("Delete BMP collector:", in.Address, "port", in.Port))
+	h.server.RemBmpCollectorCh <- net.JoinHostPort(in.Address, strconv.Itoa(int(in.Port)))
+	*out = true
+	return nil
+}
+
+func (h *BGPHandler) CreateRpkiCache(in *config.RpkiCacheConfig, out *bool) error {
+	if net.ParseIP(in.Address) == nil {
+		return errors.New(fmt.Sprintf("RPKI cache address %s is not a valid IP", in.Address))
+	}
+
+	if in.Port == 0 {
+		return errors.New(fmt.Sprintf("RPKI cache %s port is not set", in.Address))
+	}
+
+	h.logger.Info(fmt.Sprintln("Create RPKI cache:", *in))
+	h.server.AddRpkiCacheCh <- *in
+	*out = true
+	return nil
+}
+
+func (h *BGPHandler) DeleteRpkiCache(in *config.RpkiCacheConfig, out *bool) error {
+	h.logger.Info(fmt.Sprintln("Delete RPKI cache:", in.Address, "port", in.Port))
+	h.server.RemRpkiCacheCh <- net.JoinHostPort(in.Address, strconv.Itoa(int(in.Port)))
+	*out = true
+	return nil
+}
+
+func validateRouteTargets(routeTargets []string) error {
+	for _, routeTarget := range routeTargets {
+		if _, err := packet.ParseRouteTarget(routeTarget); err != nil {
+			return errors.New(fmt.Sprintf("Route target %s is not valid", routeTarget))
+		}
+	}
+	return nil
+}
+
+// CreateVrf adds the VRF, the VRF is removed and added again when its config changed
+func (h *BGPHandler) CreateVrf(in *config.VrfConfig, out *bool) error {
+	if in.Name == "" {
+		return errors.New("VRF name is not set")
+	}
+
+	if _, err := packet.ParseRouteDistinguisher(in.RouteDistinguisher); err != nil {
+		return err
+	}
+
+	if in.Label > packet.MPLSLabelMax {
+		return errors.New(fmt.Sprintf("VRF %s label %d is greater than %d", in.Name, in.Label,
+			packet.MPLSLabelMax))
+	}
+
+	if err := validateRouteTargets(in.ImportRouteTargets); err != nil {
+		return err
+	}
+
+	if err := validateRouteTargets(in.ExportRouteTargets); err != nil {
+		return err
+	}
+
+	h.logger.Info(fmt.Sprintln("Create VRF:", *in))
+	h.server.AddVrfCh <- *in
+	*out = true
+	return nil
+}
+
+func (h *BGPHandler) DeleteVrf(name *string, out *bool) error {
+	h.logger.Info(fmt.Sprintln("Delete VRF:", *name))
+	h.server.RemVrfCh <- *name
+	*out = true
+	return nil
+}
+
+// CreateEvpnInstance adds the EVPN instance of a VNI, the instance is removed and added again
+// when its config changed
+func (h *BGPHandler) CreateEvpnInstance(in *config.EvpnConfig, out *bool) error {
+	if in.VNI == 0 || in.VNI > packet.MPLSLabelMax {
+		return errors.New(fmt.Sprintf("VNI %d is not in the range 1-%d", in.VNI, packet.MPLSLabelMax))
+	}
+
+	if _, err := packet.ParseRouteDistinguisher(in.RouteDistinguisher); err != nil {
+		return err
+	}
+
+	if err := validateRouteTargets(in.ImportRouteTargets); err != nil {
+		return err
+	}
+
+	if err := validateRouteTargets(in.ExportRouteTargets); err != nil {
+		return err
+	}
+
+	h.logger.Info(fmt.Sprintln("Create EVPN instance:", *in))
+	h.server.AddEvpnCh <- *in
+	*out = true
+	return nil
+}
+
+func (h *BGPHandler) DeleteEvpnInstance(vni *uint32, out *bool) error {
+	h.logger.Info(fmt.Sprintln("Delete EVPN instance:", *vni))
+	h.server.RemEvpnCh <- *vni
+	*out = true
+	return nil
+}
+
+func (h *BGPHandler) CreateRoutePolicyCondition(in *config.RoutePolicyConditionConfig, out *bool) error {
+	if in.Name == "" {
+		return errors.New("Route policy condition name is not set")
+	}
+
+	h.bgpPE.RouteConditionCfgCh <- *in
+	*out = true
+	return nil
+}
+
+func (h *BGPHandler) DeleteRoutePolicyCondition(name *string, out *bool) error {
+	h.bgpPE.RouteConditionDelCh <- *name
+	*out = true
+	return nil
+}
+
+func (h *BGPHandler) CreateRoutePolicyAction(in *config.RoutePolicyActionConfig, out *bool) error {
+	if in.Name == "" {
+		return errors.New("Route policy action name is not set")
+	}
+
+	h.bgpPE.RouteActionCfgCh <- *in
+	*out = true
+	return nil
+}
+
+func (h *BGPHandler) DeleteRoutePolicyAction(name *string, out *bool) error {
+	h.bgpPE.RouteActionDelCh <- *name
+	*out = true
+	return nil
+}
+
+func (h *BGPHandler) CreateRoutePolicyStmt(in *config.RoutePolicyStmtConfig, out *bool) error {
+	if in.Name == "" {
+		return errors.New("Route policy statement name is not set")
+	}
+
+	h.bgpPE.RouteStmtCfgCh <- *in
+	*out = true
+	return nil
+}
+
+func (h *BGPHandler) DeleteRoutePolicyStmt(name *string, out *bool) error {
+	h.bgpPE.RouteStmtDelCh <- *name
+	*out = true
+	return nil
+}
+
+func (h *BGPHandler) CreateRoutePolicy(in *config.RoutePolicyConfig, out *bool) error {
+	if in.Name == "" {
+		return errors.New("Route policy name is not set")
+	}
+
+	h.bgpPE.RoutePolicyCfgCh <- *in
+	*out = true
+	return nil
+}
+
+func (h *BGPHandler) DeleteRoutePolicy(name *string, out *bool) error {
+	h.bgpPE.RoutePolicyDelCh <- *name
+	*out = true
+	return nil
+}
+
+func (h *BGPHandler) GetBGPPrefixLimitEvents(neighbor *string, out *[]config.PrefixLimitEvent) error {
+	*out = h.server.GetPrefixLimitEvents(*neighbor)
+	return nil
+}
+
+// GetBGPUpdateGroups returns the update group of the neighbor, or all the update groups when neighbor is empty
+func (h *BGPHandler) GetBGPUpdateGroups(neighbor *string, out *[]config.UpdateGroupState) error {
+	groups := make([]config.UpdateGroupState, 0)
+	for _, group := range h.server.GetBGPUpdateGroups() {
+		for _, member := range group.Members {
+			if *neighbor == "" || member == *neighbor {
+				groups = append(groups, group)
+				break
+			}
+		}
+	}
+
+	*out = groups
+	return nil
+}
@@ -36,6 +36,7 @@ import (
 	"time"
 	"utils/ipcutils"
 	"utils/logging"
+	"vxland"
 
 	"git.apache.org/thrift.git/lib/go/thrift"
 )
@@ -205,3 +206,33 @@ func StartBfddClient(logger *logging.Writer, filePath string, bfddClient chan *b
 	client := bfdd.NewBFDDServicesClientFactory(clientTransport, protocolFactory)
 	bfddClient <- client
 }
+
+func StartVxlandClient(logger *logging.Writer, filePath string, vxlandClient chan *vxland.VXLANDServicesClient) {
+	fileName := filePath + ClientsFileName
+	clientJson, err := getClient(logger, fileName, "vxland")
+	if err != nil || clientJson == nil {
+		vxlandClient <- nil
+		return
+	}
+
+	clientTransport, protocolFactory, err := ipcutils.CreateIPCHandles("localhost:" + strconv.Itoa(clientJson.Port))
+	if err != nil {
+		logger.Info(fmt.Sprintf("Failed to connect to VXLANd, retrying until connection is successful"))
+		count := 0
+		ticker := time.NewTicker(time.Duration(1000) * time.Millisecond)
+		for _ = range ticker.C {
+			clientTransport, protocolFactory, err = ipcutils.CreateIPCHandles("localhost:" + strconv.Itoa(clientJson.Port))
+			if err == nil {
+				ticker.Stop()
+				break
+			}
+			count++
+			if (count % 10) == 0 {
+				logger.Info(fmt.Sprintf("Still can't connect to VXLANd, retrying..."))
+			}
+		}
+	}
+
+	client := vxland.NewVXLANDServicesClientFactory(clientTransport, protocolFactory)
+	vxlandClient <- client
+}
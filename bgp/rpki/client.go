@@ -0,0 +1,374 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// client.go
+package rpki
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+	"utils/logging"
+)
+
+const (
+	cacheConnectTimeout = 30 // seconds
+	cacheWriteTimeout   = 30 // seconds
+)
+
+// CacheSnapshot carries all the VRPs received from a cache. An empty snapshot is sent when the data of the cache
+// expires.
+type CacheSnapshot struct {
+	Client *CacheClient
+	VRPs   []VRP
+}
+
+type rtrReadResult struct {
+	pdu *RTRPDU
+	err error
+}
+
+/*  A cache client keeps a copy of the VRPs of an RPKI cache using the RPKI to Router protocol
+ *  (RFC 8210). It starts with version 1 of the protocol and falls back to version 0 when the
+ *  cache doesn't support it. A snapshot of the VRPs is sent on updateCh at the end of every
+ *  update from the cache. The refresh, retry and expire intervals configured as 0 are taken
+ *  from the End of Data PDU.
+ */
+type CacheClient struct {
+	logger      *logging.Writer
+	Address     string
+	refresh     uint32
+	retry       uint32
+	expire      uint32
+	confRefresh uint32
+	confRetry   uint32
+	confExpire  uint32
+	version     uint8
+	sessionId   uint16
+	serial      uint32
+	hasData     bool
+	vrps        map[VRP]bool
+	pending     map[VRP]bool
+	expiry      *time.Timer
+	updateCh    chan<- CacheSnapshot
+	stopCh      chan bool
+}
+
+func NewCacheClient(logger *logging.Writer, address string, refresh, retry, expire uint32,
+	updateCh chan<- CacheSnapshot) *CacheClient {
+	c := &CacheClient{
+		logger:      logger,
+		Address:     address,
+		confRefresh: refresh,
+		confRetry:   retry,
+		confExpire:  expire,
+		version:     RTRVersion1,
+		vrps:        make(map[VRP]bool),
+		updateCh:    updateCh,
+		stopCh:      make(chan bool, 1),
+	}
+	c.setIntervals(RTRRefreshIntervalDefault, RTRRetryIntervalDefault, RTRExpireIntervalDefault)
+	return c
+}
+
+func (c *CacheClient) setIntervals(refresh, retry, expire uint32) {
+	c.refresh, c.retry, c.expire = refresh, retry, expire
+	if c.confRefresh != 0 {
+		c.refresh = c.confRefresh
+	}
+	if c.confRetry != 0 {
+		c.retry = c.confRetry
+	}
+	if c.confExpire != 0 {
+		c.expire = c.confExpire
+	}
+}
+
+func (c *CacheClient) Stop() {
+	select {
+	case c.stopCh <- true:
+	default:
+	}
+}
+
+func (c *CacheClient) getExpiryCh() <-chan time.Time {
+	if c.expiry == nil {
+		return nil
+	}
+	return c.expiry.C
+}
+
+func (c *CacheClient) resetExpiry() {
+	if c.expiry != nil {
+		c.expiry.Stop()
+	}
+	c.expiry = time.NewTimer(time.Duration(c.expire) * time.Second)
+}
+
+// Returns false when the client is stopped
+func (c *CacheClient) sendSnapshot() bool {
+	vrps := make([]VRP, 0, len(c.vrps))
+	for vrp, _ := range c.vrps {
+		vrps = append(vrps, vrp)
+	}
+
+	select {
+	case c.updateCh <- CacheSnapshot{c, vrps}:
+		return true
+	case <-c.stopCh:
+		return false
+	}
+}
+
+// Returns false when the client is stopped
+func (c *CacheClient) expireData() bool {
+	c.logger.Warning(fmt.Sprintln("RPKI cache", c.Address, "data expired, remove all the VRPs"))
+	c.expiry = nil
+	c.hasData = false
+	c.vrps = make(map[VRP]bool)
+	return c.sendSnapshot()
+}
+
+func (c *CacheClient) Run() {
+	for {
+		conn, err := net.DialTimeout("tcp", c.Address, time.Duration(cacheConnectTimeout)*time.Second)
+		if err == nil {
+			c.logger.Info(fmt.Sprintln("RPKI cache", c.Address, "connected, version", c.version))
+			stopped, retryNow := c.runSession(conn)
+			conn.Close()
+			if stopped {
+				return
+			}
+			if retryNow {
+				continue
+			}
+		} else {
+			c.logger.Info(fmt.Sprintln("RPKI cache", c.Address, "failed to connect, err:", err))
+		}
+
+		retryTimer := time.NewTimer(time.Duration(c.retry) * time.Second)
+		for retryTimer != nil {
+			select {
+			case <-retryTimer.C:
+				retryTimer = nil
+
+			case <-c.getExpiryCh():
+				if !c.expireData() {
+					retryTimer.Stop()
+					return
+				}
+
+			case <-c.stopCh:
+				retryTimer.Stop()
+				return
+			}
+		}
+	}
+}
+
+func (c *CacheClient) readPDUs(conn net.Conn, readCh chan<- rtrReadResult, doneCh <-chan bool) {
+	for {
+		pdu, err := ReadRTRPDU(conn)
+		select {
+		case readCh <- rtrReadResult{pdu, err}:
+		case <-doneCh:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *CacheClient) sendPDU(conn net.Conn, pdu *RTRPDU) error {
+	pkt, err := pdu.Encode()
+	if err != nil {
+		return err
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(time.Duration(cacheWriteTimeout) * time.Second))
+	_, err = conn.Write(pkt)
+	return err
+}
+
+func (c *CacheClient) sendQuery(conn net.Conn) error {
+	if c.hasData {
+		return c.sendPDU(conn, NewRTRSerialQueryPDU(c.version, c.sessionId, c.serial))
+	}
+	return c.sendPDU(conn, NewRTRResetQueryPDU(c.version))
+}
+
+func (c *CacheClient) sendErrorReport(conn net.Conn, err error) {
+	rtrErr, ok := err.(RTRError)
+	if !ok {
+		return
+	}
+	c.sendPDU(conn, NewRTRErrorReportPDU(c.version, rtrErr.ErrorCode, nil, rtrErr.Message))
+}
+
+// Returns true for stopped when the client is stopped and true for retryNow when the session needs to be
+// restarted without waiting for the retry interval
+func (c *CacheClient) runSession(conn net.Conn) (stopped bool, retryNow bool) {
+	readCh := make(chan rtrReadResult)
+	doneCh := make(chan bool)
+	defer close(doneCh)
+	go c.readPDUs(conn, readCh, doneCh)
+	c.pending = nil
+
+	if err := c.sendQuery(conn); err != nil {
+		c.logger.Err(fmt.Sprintln("RPKI cache", c.Address, "failed to send query, err:", err))
+		return false, false
+	}
+
+	var refreshCh <-chan time.Time
+	for {
+		select {
+		case result := <-readCh:
+			if result.err != nil {
+				c.logger.Err(fmt.Sprintln("RPKI cache", c.Address, "failed to read PDU, err:", result.err))
+				c.sendErrorReport(conn, result.err)
+				return false, false
+			}
+
+			endOfData, retryNow, err := c.processPDU(conn, result.pdu)
+			if err != nil {
+				c.logger.Err(fmt.Sprintln("RPKI cache", c.Address, "session error:", err))
+				c.sendErrorReport(conn, err)
+				return false, retryNow
+			}
+			if retryNow {
+				return false, true
+			}
+			if endOfData {
+				refreshCh = time.After(time.Duration(c.refresh) * time.Second)
+				if !c.sendSnapshot() {
+					return true, false
+				}
+			}
+
+		case <-refreshCh:
+			refreshCh = nil
+			if err := c.sendQuery(conn); err != nil {
+				c.logger.Err(fmt.Sprintln("RPKI cache", c.Address, "failed to send query, err:", err))
+				return false, false
+			}
+
+		case <-c.getExpiryCh():
+			if !c.expireData() {
+				return true, false
+			}
+
+		case <-c.stopCh:
+			c.logger.Info(fmt.Sprintln("RPKI cache", c.Address, "stopped, close the connection"))
+			return true, false
+		}
+	}
+}
+
+func (c *CacheClient) processPDU(conn net.Conn, pdu *RTRPDU) (endOfData bool, retryNow bool, err error) {
+	if pdu.Header.Version != c.version {
+		if pdu.Header.Version == RTRVersion0 && c.version == RTRVersion1 && !c.hasData {
+			c.logger.Info(fmt.Sprintln("RPKI cache", c.Address, "supports version 0, downgrade the session"))
+			c.version = RTRVersion0
+			return false, true, nil
+		}
+		return false, false, RTRError{RTRErrUnexpectedVersion, fmt.Sprintf("PDU version %d is not expected",
+			pdu.Header.Version)}
+	}
+
+	switch body := pdu.Body.(type) {
+	case *RTRSerial:
+		if pdu.Header.Type == RTRPDUTypeSerialNotify && c.pending == nil && c.hasData &&
+			pdu.Header.SessionId == c.sessionId && body.Serial != c.serial {
+			err = c.sendQuery(conn)
+		}
+
+	case *RTREmpty:
+		switch pdu.Header.Type {
+		case RTRPDUTypeCacheResponse:
+			if c.hasData && pdu.Header.SessionId != c.sessionId {
+				return false, false, RTRError{RTRErrCorruptData, fmt.Sprintf("Session id %d changed to %d",
+					c.sessionId, pdu.Header.SessionId)}
+			}
+			c.pending = make(map[VRP]bool)
+			if c.hasData {
+				for vrp, _ := range c.vrps {
+					c.pending[vrp] = true
+				}
+			}
+
+		case RTRPDUTypeCacheReset:
+			c.hasData = false
+			err = c.sendQuery(conn)
+
+		default:
+			err = RTRError{RTRErrInvalidRequest, fmt.Sprintf("PDU type %d is not expected", pdu.Header.Type)}
+		}
+
+	case *RTRPrefix:
+		if c.pending == nil {
+			return false, false, RTRError{RTRErrCorruptData, "Prefix PDU received outside of a cache response"}
+		}
+		vrp := NewVRP(body.Prefix, body.PrefixLen, body.MaxLen, body.AS)
+		if body.IsAnnouncement() {
+			if c.pending[vrp] {
+				return false, false, RTRError{RTRErrDuplicateAnnouncement, fmt.Sprintf("VRP %s is already announced",
+					vrp)}
+			}
+			c.pending[vrp] = true
+		} else {
+			if !c.pending[vrp] {
+				return false, false, RTRError{RTRErrWithdrawalOfUnknownRecord,
+					fmt.Sprintf("VRP %s is not known", vrp)}
+			}
+			delete(c.pending, vrp)
+		}
+
+	case *RTREndOfData:
+		if c.pending == nil {
+			return false, false, RTRError{RTRErrCorruptData, "End of Data PDU received outside of a cache response"}
+		}
+		c.vrps = c.pending
+		c.pending = nil
+		c.sessionId = pdu.Header.SessionId
+		c.serial = body.Serial
+		c.hasData = true
+		c.setIntervals(body.RefreshInterval, body.RetryInterval, body.ExpireInterval)
+		c.resetExpiry()
+		c.logger.Info(fmt.Sprintln("RPKI cache", c.Address, "serial", c.serial, "number of VRPs", len(c.vrps)))
+		endOfData = true
+
+	case *RTRErrorReport:
+		c.logger.Err(fmt.Sprintln("RPKI cache", c.Address, "sent error code", pdu.Header.SessionId, body.Text))
+		c.pending = nil
+		if pdu.Header.SessionId == RTRErrUnsupportedVersion && c.version == RTRVersion1 {
+			c.version = RTRVersion0
+			return false, true, nil
+		}
+		return false, false, errors.New(fmt.Sprintf("Error report code %d received from the cache",
+			pdu.Header.SessionId))
+	}
+
+	return endOfData, false, err
+}
@@ -0,0 +1,450 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// rtr.go
+package rpki
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+const (
+	RTRVersion0 uint8 = 0
+	RTRVersion1 uint8 = 1
+)
+
+const (
+	RTRPDUTypeSerialNotify  uint8 = 0
+	RTRPDUTypeSerialQuery   uint8 = 1
+	RTRPDUTypeResetQuery    uint8 = 2
+	RTRPDUTypeCacheResponse uint8 = 3
+	RTRPDUTypeIPv4Prefix    uint8 = 4
+	RTRPDUTypeIPv6Prefix    uint8 = 6
+	RTRPDUTypeEndOfData     uint8 = 7
+	RTRPDUTypeCacheReset    uint8 = 8
+	RTRPDUTypeRouterKey     uint8 = 9
+	RTRPDUTypeErrorReport   uint8 = 10
+)
+
+const (
+	RTRHeaderLen        uint32 = 8
+	RTRSerialPDULen     uint32 = 12
+	RTRIPv4PrefixPDULen uint32 = 20
+	RTRIPv6PrefixPDULen uint32 = 32
+	RTREndOfDataV0Len   uint32 = 12
+	RTREndOfDataV1Len   uint32 = 24
+	RTRMaxPDULen        uint32 = 65536
+)
+
+const (
+	RTRErrCorruptData uint16 = iota
+	RTRErrInternalError
+	RTRErrNoDataAvailable
+	RTRErrInvalidRequest
+	RTRErrUnsupportedVersion
+	RTRErrUnsupportedPDUType
+	RTRErrWithdrawalOfUnknownRecord
+	RTRErrDuplicateAnnouncement
+	RTRErrUnexpectedVersion
+)
+
+const RTRPrefixFlagAnnounce uint8 = 0x01
+
+// Intervals sent by a version 0 cache, that doesn't send them in End of Data (RFC 8210 section 6)
+const (
+	RTRRefreshIntervalDefault uint32 = 3600
+	RTRRetryIntervalDefault   uint32 = 600
+	RTRExpireIntervalDefault  uint32 = 7200
+)
+
+type RTRError struct {
+	ErrorCode uint16
+	Message   string
+}
+
+func (e RTRError) Error() string {
+	return fmt.Sprintf("RTR error code %d, %s", e.ErrorCode, e.Message)
+}
+
+// The session id field is the error code in an Error Report PDU and zero in some PDUs
+type RTRHeader struct {
+	Version   uint8
+	Type      uint8
+	SessionId uint16
+	Length    uint32
+}
+
+func (h *RTRHeader) Encode() []byte {
+	pkt := make([]byte, RTRHeaderLen)
+	pkt[0] = h.Version
+	pkt[1] = h.Type
+	binary.BigEndian.PutUint16(pkt[2:4], h.SessionId)
+	binary.BigEndian.PutUint32(pkt[4:8], h.Length)
+	return pkt
+}
+
+func (h *RTRHeader) Decode(pkt []byte) error {
+	if uint32(len(pkt)) < RTRHeaderLen {
+		return RTRError{RTRErrCorruptData, fmt.Sprintf("PDU header is %d bytes long", len(pkt))}
+	}
+
+	h.Version = pkt[0]
+	h.Type = pkt[1]
+	h.SessionId = binary.BigEndian.Uint16(pkt[2:4])
+	h.Length = binary.BigEndian.Uint32(pkt[4:8])
+	if h.Length < RTRHeaderLen || h.Length > RTRMaxPDULen {
+		return RTRError{RTRErrCorruptData, fmt.Sprintf("PDU length %d is not valid", h.Length)}
+	}
+	return nil
+}
+
+type RTRBody interface {
+	Encode(version uint8) ([]byte, error)
+	Decode(header *RTRHeader, pkt []byte) error
+}
+
+// Body of the Serial Notify and the Serial Query PDUs
+type RTRSerial struct {
+	Serial uint32
+}
+
+func (s *RTRSerial) Encode(version uint8) ([]byte, error) {
+	pkt := make([]byte, 4)
+	binary.BigEndian.PutUint32(pkt, s.Serial)
+	return pkt, nil
+}
+
+func (s *RTRSerial) Decode(header *RTRHeader, pkt []byte) error {
+	if header.Length != RTRSerialPDULen {
+		return RTRError{RTRErrCorruptData, fmt.Sprintf("Serial PDU length %d is not valid", header.Length)}
+	}
+	s.Serial = binary.BigEndian.Uint32(pkt[0:4])
+	return nil
+}
+
+// Body of the Reset Query, Cache Response and Cache Reset PDUs
+type RTREmpty struct {
+}
+
+func (e *RTREmpty) Encode(version uint8) ([]byte, error) {
+	return make([]byte, 0), nil
+}
+
+func (e *RTREmpty) Decode(header *RTRHeader, pkt []byte) error {
+	if header.Length != RTRHeaderLen {
+		return RTRError{RTRErrCorruptData, fmt.Sprintf("PDU type %d length %d is not valid", header.Type,
+			header.Length)}
+	}
+	return nil
+}
+
+// Body of the IPv4 Prefix and the IPv6 Prefix PDUs
+type RTRPrefix struct {
+	Flags     uint8
+	PrefixLen uint8
+	MaxLen    uint8
+	Prefix    net.IP
+	AS        uint32
+}
+
+func (p *RTRPrefix) IsAnnouncement() bool {
+	return p.Flags&RTRPrefixFlagAnnounce != 0
+}
+
+func (p *RTRPrefix) Encode(version uint8) ([]byte, error) {
+	prefix := p.Prefix.To4()
+	if prefix == nil {
+		prefix = p.Prefix.To16()
+	}
+	if prefix == nil {
+		return nil, errors.New(fmt.Sprintf("RTR prefix %s is not valid", p.Prefix))
+	}
+
+	pkt := make([]byte, 4, 8+len(prefix))
+	pkt[0] = p.Flags
+	pkt[1] = p.PrefixLen
+	pkt[2] = p.MaxLen
+	pkt = append(pkt, prefix...)
+	as := make([]byte, 4)
+	binary.BigEndian.PutUint32(as, p.AS)
+	pkt = append(pkt, as...)
+	return pkt, nil
+}
+
+func (p *RTRPrefix) Decode(header *RTRHeader, pkt []byte) error {
+	ipLen := net.IPv4len
+	maxBits := uint8(32)
+	if header.Type == RTRPDUTypeIPv6Prefix {
+		ipLen = net.IPv6len
+		maxBits = 128
+	}
+	if header.Length != RTRHeaderLen+uint32(8+ipLen) {
+		return RTRError{RTRErrCorruptData, fmt.Sprintf("Prefix PDU length %d is not valid", header.Length)}
+	}
+
+	p.Flags = pkt[0]
+	p.PrefixLen = pkt[1]
+	p.MaxLen = pkt[2]
+	p.Prefix = make(net.IP, ipLen)
+	copy(p.Prefix, pkt[4:4+ipLen])
+	p.AS = binary.BigEndian.Uint32(pkt[4+ipLen : 8+ipLen])
+	if p.PrefixLen > p.MaxLen || p.MaxLen > maxBits {
+		return RTRError{RTRErrCorruptData, fmt.Sprintf("Prefix %s length %d max length %d is not valid",
+			p.Prefix, p.PrefixLen, p.MaxLen)}
+	}
+	return nil
+}
+
+type RTREndOfData struct {
+	Serial          uint32
+	RefreshInterval uint32
+	RetryInterval   uint32
+	ExpireInterval  uint32
+}
+
+func (e *RTREndOfData) Encode(version uint8) ([]byte, error) {
+	if version == RTRVersion0 {
+		pkt := make([]byte, 4)
+		binary.BigEndian.PutUint32(pkt, e.Serial)
+		return pkt, nil
+	}
+
+	pkt := make([]byte, 16)
+	binary.BigEndian.PutUint32(pkt[0:4], e.Serial)
+	binary.BigEndian.PutUint32(pkt[4:8], e.RefreshInterval)
+	binary.BigEndian.PutUint32(pkt[8:12], e.RetryInterval)
+	binary.BigEndian.PutUint32(pkt[12:16], e.ExpireInterval)
+	return pkt, nil
+}
+
+func (e *RTREndOfData) Decode(header *RTRHeader, pkt []byte) error {
+	if header.Version == RTRVersion0 {
+		if header.Length != RTREndOfDataV0Len {
+			return RTRError{RTRErrCorruptData, fmt.Sprintf("End of Data PDU length %d is not valid",
+				header.Length)}
+		}
+		e.Serial = binary.BigEndian.Uint32(pkt[0:4])
+		e.RefreshInterval = RTRRefreshIntervalDefault
+		e.RetryInterval = RTRRetryIntervalDefault
+		e.ExpireInterval = RTRExpireIntervalDefault
+		return nil
+	}
+
+	if header.Length != RTREndOfDataV1Len {
+		return RTRError{RTRErrCorruptData, fmt.Sprintf("End of Data PDU length %d is not valid", header.Length)}
+	}
+	e.Serial = binary.BigEndian.Uint32(pkt[0:4])
+	e.RefreshInterval = binary.BigEndian.Uint32(pkt[4:8])
+	e.RetryInterval = binary.BigEndian.Uint32(pkt[8:12])
+	e.ExpireInterval = binary.BigEndian.Uint32(pkt[12:16])
+	return nil
+}
+
+type RTRErrorReport struct {
+	PDU  []byte
+	Text string
+}
+
+func (e *RTRErrorReport) Encode(version uint8) ([]byte, error) {
+	pkt := make([]byte, 4, 8+len(e.PDU)+len(e.Text))
+	binary.BigEndian.PutUint32(pkt[0:4], uint32(len(e.PDU)))
+	pkt = append(pkt, e.PDU...)
+	textLen := make([]byte, 4)
+	binary.BigEndian.PutUint32(textLen, uint32(len(e.Text)))
+	pkt = append(pkt, textLen...)
+	pkt = append(pkt, []byte(e.Text)...)
+	return pkt, nil
+}
+
+func (e *RTRErrorReport) Decode(header *RTRHeader, pkt []byte) error {
+	if len(pkt) < 4 {
+		return RTRError{RTRErrCorruptData, "Error Report PDU is too short"}
+	}
+	// The lengths are checked in uint64 so that a large length can't wrap around
+	pduLen := uint64(binary.BigEndian.Uint32(pkt[0:4]))
+	if uint64(len(pkt)) < 8+pduLen {
+		return RTRError{RTRErrCorruptData, fmt.Sprintf("Error Report PDU length %d is not valid", pduLen)}
+	}
+	e.PDU = pkt[4 : 4+pduLen]
+	textLen := uint64(binary.BigEndian.Uint32(pkt[4+pduLen : 8+pduLen]))
+	if uint64(len(pkt)) != 8+pduLen+textLen {
+		return RTRError{RTRErrCorruptData, fmt.Sprintf("Error Report text length %d is not valid", textLen)}
+	}
+	e.Text = string(pkt[8+pduLen:])
+	return nil
+}
+
+// Body of the PDUs that are not used by the router, like the Router Key PDU
+type RTRUnknown struct {
+	Data []byte
+}
+
+func (u *RTRUnknown) Encode(version uint8) ([]byte, error) {
+	return u.Data, nil
+}
+
+func (u *RTRUnknown) Decode(header *RTRHeader, pkt []byte) error {
+	u.Data = pkt
+	return nil
+}
+
+type RTRPDU struct {
+	Header RTRHeader
+	Body   RTRBody
+}
+
+func (pdu *RTRPDU) Encode() ([]byte, error) {
+	body, err := pdu.Body.Encode(pdu.Header.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	pdu.Header.Length = RTRHeaderLen + uint32(len(body))
+	return append(pdu.Header.Encode(), body...), nil
+}
+
+func (pdu *RTRPDU) Decode(header *RTRHeader, pkt []byte) error {
+	pdu.Header = *header
+	switch header.Type {
+	case RTRPDUTypeSerialNotify, RTRPDUTypeSerialQuery:
+		pdu.Body = &RTRSerial{}
+
+	case RTRPDUTypeResetQuery, RTRPDUTypeCacheResponse, RTRPDUTypeCacheReset:
+		pdu.Body = &RTREmpty{}
+
+	case RTRPDUTypeIPv4Prefix, RTRPDUTypeIPv6Prefix:
+		pdu.Body = &RTRPrefix{}
+
+	case RTRPDUTypeEndOfData:
+		pdu.Body = &RTREndOfData{}
+
+	case RTRPDUTypeErrorReport:
+		pdu.Body = &RTRErrorReport{}
+
+	case RTRPDUTypeRouterKey:
+		pdu.Body = &RTRUnknown{}
+
+	default:
+		return RTRError{RTRErrUnsupportedPDUType, fmt.Sprintf("PDU type %d is not supported", header.Type)}
+	}
+
+	return pdu.Body.Decode(header, pkt)
+}
+
+// Reads a PDU from the connection to the cache
+func ReadRTRPDU(reader io.Reader) (*RTRPDU, error) {
+	headerBuf := make([]byte, RTRHeaderLen)
+	if _, err := io.ReadFull(reader, headerBuf); err != nil {
+		return nil, err
+	}
+
+	header := &RTRHeader{}
+	if err := header.Decode(headerBuf); err != nil {
+		return nil, err
+	}
+
+	body := make([]byte, header.Length-RTRHeaderLen)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, err
+	}
+
+	pdu := &RTRPDU{}
+	if err := pdu.Decode(header, body); err != nil {
+		return nil, err
+	}
+	return pdu, nil
+}
+
+func NewRTRResetQueryPDU(version uint8) *RTRPDU {
+	return &RTRPDU{
+		Header: RTRHeader{Version: version, Type: RTRPDUTypeResetQuery},
+		Body:   &RTREmpty{},
+	}
+}
+
+func NewRTRSerialQueryPDU(version uint8, sessionId uint16, serial uint32) *RTRPDU {
+	return &RTRPDU{
+		Header: RTRHeader{Version: version, Type: RTRPDUTypeSerialQuery, SessionId: sessionId},
+		Body:   &RTRSerial{Serial: serial},
+	}
+}
+
+func NewRTRSerialNotifyPDU(version uint8, sessionId uint16, serial uint32) *RTRPDU {
+	return &RTRPDU{
+		Header: RTRHeader{Version: version, Type: RTRPDUTypeSerialNotify, SessionId: sessionId},
+		Body:   &RTRSerial{Serial: serial},
+	}
+}
+
+func NewRTRCacheResponsePDU(version uint8, sessionId uint16) *RTRPDU {
+	return &RTRPDU{
+		Header: RTRHeader{Version: version, Type: RTRPDUTypeCacheResponse, SessionId: sessionId},
+		Body:   &RTREmpty{},
+	}
+}
+
+func NewRTRCacheResetPDU(version uint8) *RTRPDU {
+	return &RTRPDU{
+		Header: RTRHeader{Version: version, Type: RTRPDUTypeCacheReset},
+		Body:   &RTREmpty{},
+	}
+}
+
+func NewRTRPrefixPDU(version uint8, announce bool, prefix net.IP, prefixLen, maxLen uint8, as uint32) *RTRPDU {
+	pduType := RTRPDUTypeIPv4Prefix
+	if prefix.To4() == nil {
+		pduType = RTRPDUTypeIPv6Prefix
+	}
+
+	body := &RTRPrefix{PrefixLen: prefixLen, MaxLen: maxLen, Prefix: prefix, AS: as}
+	if announce {
+		body.Flags = RTRPrefixFlagAnnounce
+	}
+	return &RTRPDU{
+		Header: RTRHeader{Version: version, Type: pduType},
+		Body:   body,
+	}
+}
+
+func NewRTREndOfDataPDU(version uint8, sessionId uint16, serial, refresh, retry, expire uint32) *RTRPDU {
+	return &RTRPDU{
+		Header: RTRHeader{Version: version, Type: RTRPDUTypeEndOfData, SessionId: sessionId},
+		Body: &RTREndOfData{
+			Serial:          serial,
+			RefreshInterval: refresh,
+			RetryInterval:   retry,
+			ExpireInterval:  expire,
+		},
+	}
+}
+
+func NewRTRErrorReportPDU(version uint8, errorCode uint16, pdu []byte, text string) *RTRPDU {
+	return &RTRPDU{
+		Header: RTRHeader{Version: version, Type: RTRPDUTypeErrorReport, SessionId: errorCode},
+		Body:   &RTRErrorReport{PDU: pdu, Text: text},
+	}
+}
@@ -0,0 +1,232 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// vrp.go
+package rpki
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+type ValidationState uint8
+
+const (
+	ValidationStateNotFound ValidationState = iota
+	ValidationStateValid
+	ValidationStateInvalid
+)
+
+func (v ValidationState) String() string {
+	switch v {
+	case ValidationStateValid:
+		return "valid"
+	case ValidationStateInvalid:
+		return "invalid"
+	default:
+		return "not-found"
+	}
+}
+
+func ParseValidationState(str string) (ValidationState, error) {
+	for _, state := range []ValidationState{ValidationStateNotFound, ValidationStateValid, ValidationStateInvalid} {
+		if str == state.String() {
+			return state, nil
+		}
+	}
+	return ValidationStateNotFound, errors.New(fmt.Sprintf("RPKI validation state %s is not valid", str))
+}
+
+// Validated ROA Payload
+type VRP struct {
+	Prefix    string
+	PrefixLen uint8
+	MaxLen    uint8
+	AS        uint32
+}
+
+func NewVRP(prefix net.IP, prefixLen, maxLen uint8, as uint32) VRP {
+	return VRP{getMaskedPrefix(prefix, prefixLen), prefixLen, maxLen, as}
+}
+
+func (v VRP) String() string {
+	return fmt.Sprintf("%s/%d-%d AS%d", v.Prefix, v.PrefixLen, v.MaxLen, v.AS)
+}
+
+type vrpKey struct {
+	prefix    string
+	prefixLen uint8
+}
+
+// VRPTable merges the VRPs received from all the caches. A VRP sent by more than one cache is counted once per cache.
+type VRPTable struct {
+	sync.RWMutex
+	cacheVRPs map[string]map[VRP]bool
+	vrps      map[vrpKey]map[VRP]int
+}
+
+func NewVRPTable() *VRPTable {
+	return &VRPTable{
+		cacheVRPs: make(map[string]map[VRP]bool),
+		vrps:      make(map[vrpKey]map[VRP]int),
+	}
+}
+
+func getMaskedPrefix(prefix net.IP, prefixLen uint8) string {
+	ip := prefix.To4()
+	bits := 32
+	if ip == nil {
+		ip = prefix.To16()
+		bits = 128
+	}
+	if ip == nil {
+		return prefix.String()
+	}
+	return ip.Mask(net.CIDRMask(int(prefixLen), bits)).String()
+}
+
+func (t *VRPTable) addVRP(vrp VRP) bool {
+	key := vrpKey{vrp.Prefix, vrp.PrefixLen}
+	if _, ok := t.vrps[key]; !ok {
+		t.vrps[key] = make(map[VRP]int)
+	}
+	t.vrps[key][vrp]++
+	return t.vrps[key][vrp] == 1
+}
+
+func (t *VRPTable) removeVRP(vrp VRP) bool {
+	key := vrpKey{vrp.Prefix, vrp.PrefixLen}
+	if _, ok := t.vrps[key][vrp]; !ok {
+		return false
+	}
+
+	t.vrps[key][vrp]--
+	if t.vrps[key][vrp] > 0 {
+		return false
+	}
+
+	delete(t.vrps[key], vrp)
+	if len(t.vrps[key]) == 0 {
+		delete(t.vrps, key)
+	}
+	return true
+}
+
+// SetCacheVRPs replaces the VRPs of a cache and returns the VRPs that were added to or removed from the table.
+func (t *VRPTable) SetCacheVRPs(cache string, vrps []VRP) []VRP {
+	t.Lock()
+	defer t.Unlock()
+
+	changed := make([]VRP, 0)
+	newVRPs := make(map[VRP]bool, len(vrps))
+	for _, vrp := range vrps {
+		newVRPs[vrp] = true
+	}
+
+	oldVRPs := t.cacheVRPs[cache]
+	for vrp, _ := range oldVRPs {
+		if !newVRPs[vrp] && t.removeVRP(vrp) {
+			changed = append(changed, vrp)
+		}
+	}
+	for vrp, _ := range newVRPs {
+		if !oldVRPs[vrp] && t.addVRP(vrp) {
+			changed = append(changed, vrp)
+		}
+	}
+
+	if len(newVRPs) == 0 {
+		delete(t.cacheVRPs, cache)
+	} else {
+		t.cacheVRPs[cache] = newVRPs
+	}
+	return changed
+}
+
+func (t *VRPTable) RemoveCache(cache string) []VRP {
+	return t.SetCacheVRPs(cache, nil)
+}
+
+func (t *VRPTable) Len() int {
+	t.RLock()
+	defer t.RUnlock()
+
+	count := 0
+	for _, vrps := range t.vrps {
+		count += len(vrps)
+	}
+	return count
+}
+
+// Calls fn for every VRP that covers the prefix, stops when fn returns true
+func (t *VRPTable) walkCoveringVRPs(prefix net.IP, prefixLen uint8, fn func(vrp VRP) bool) {
+	ip := prefix.To4()
+	if ip == nil {
+		ip = prefix.To16()
+	}
+	if ip == nil {
+		return
+	}
+
+	for length := uint8(0); length <= prefixLen; length++ {
+		key := vrpKey{getMaskedPrefix(ip, length), length}
+		for vrp, _ := range t.vrps[key] {
+			if fn(vrp) {
+				return
+			}
+		}
+	}
+}
+
+// Covers returns true if any VRP in the table covers the prefix.
+func (t *VRPTable) Covers(prefix net.IP, prefixLen uint8) bool {
+	t.RLock()
+	defer t.RUnlock()
+
+	covered := false
+	t.walkCoveringVRPs(prefix, prefixLen, func(vrp VRP) bool {
+		covered = true
+		return true
+	})
+	return covered
+}
+
+// Validate returns the origin validation state of a route as defined in RFC 6811. hasOrigin is false when the
+// origin AS can't be determined, like when the AS path ends with an AS_SET.
+func (t *VRPTable) Validate(prefix net.IP, prefixLen uint8, originAS uint32, hasOrigin bool) ValidationState {
+	t.RLock()
+	defer t.RUnlock()
+
+	state := ValidationStateNotFound
+	t.walkCoveringVRPs(prefix, prefixLen, func(vrp VRP) bool {
+		state = ValidationStateInvalid
+		if hasOrigin && vrp.AS != 0 && vrp.AS == originAS && prefixLen <= vrp.MaxLen {
+			state = ValidationStateValid
+			return true
+		}
+		return false
+	})
+	return state
+}
@@ -0,0 +1,427 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// bmp.go
+package server
+
+import (
+	"fmt"
+	"l3/bgp/config"
+	"l3/bgp/fsm"
+	"l3/bgp/packet"
+	"net"
+	"os"
+	"strconv"
+	"time"
+	"utils/logging"
+)
+
+const (
+	bmpCollectorMsgQueueLen  = 4096
+	bmpCollectorRetryTime    = 30 // seconds
+	bmpCollectorWriteTimeout = 30 // seconds
+	bmpSysDescr              = "bgpd"
+)
+
+type bmpCollectorState struct {
+	collector *bmpCollector
+	up        bool
+}
+
+/*  A BMP collector streams the BGP Monitoring Protocol (RFC 7854) messages to a monitoring
+ *  station. The collector go routine owns the TCP connection to the station and reconnects
+ *  when it goes down. The server owns the up flag and queues the messages on msgCh without
+ *  blocking, the messages are dropped when the queue is full or the station is down.
+ */
+type bmpCollector struct {
+	server  *BGPServer
+	logger  *logging.Writer
+	config  config.BmpCollectorConfig
+	address string
+	up      bool
+	msgCh   chan []byte
+	stopCh  chan bool
+}
+
+func getBmpCollectorAddress(conf *config.BmpCollectorConfig) string {
+	return net.JoinHostPort(conf.Address, strconv.Itoa(int(conf.Port)))
+}
+
+func newBmpCollector(server *BGPServer, conf config.BmpCollectorConfig) *bmpCollector {
+	return &bmpCollector{
+		server:  server,
+		logger:  server.logger,
+		config:  conf,
+		address: getBmpCollectorAddress(&conf),
+		msgCh:   make(chan []byte, bmpCollectorMsgQueueLen),
+		stopCh:  make(chan bool, 1),
+	}
+}
+
+func (c *bmpCollector) stop() {
+	select {
+	case c.stopCh <- true:
+	default:
+	}
+}
+
+func (c *bmpCollector) sendState(up bool) bool {
+	select {
+	case c.server.bmpCollectorCh <- bmpCollectorState{c, up}:
+		return true
+	case <-c.stopCh:
+		return false
+	}
+}
+
+func (c *bmpCollector) run() {
+	for {
+		conn, err := net.DialTimeout("tcp", c.address, time.Duration(bmpCollectorRetryTime)*time.Second)
+		if err == nil {
+			c.logger.Info(fmt.Sprintln("BMP collector", c.address, "connected"))
+			if !c.sendState(true) {
+				c.terminate(conn)
+				return
+			}
+			if c.sendMessages(conn) {
+				return
+			}
+			if !c.sendState(false) {
+				return
+			}
+			c.drainMessages()
+		} else {
+			c.logger.Info(fmt.Sprintln("BMP collector", c.address, "failed to connect, err:", err))
+		}
+
+		select {
+		case <-time.After(time.Duration(bmpCollectorRetryTime) * time.Second):
+		case <-c.stopCh:
+			return
+		}
+	}
+}
+
+// Returns true when the collector is stopped and false when the connection goes down
+func (c *bmpCollector) sendMessages(conn net.Conn) bool {
+	var statsCh <-chan time.Time
+	if c.config.StatsInterval > 0 {
+		ticker := time.NewTicker(time.Duration(c.config.StatsInterval) * time.Second)
+		defer ticker.Stop()
+		statsCh = ticker.C
+	}
+
+	for {
+		select {
+		case msg := <-c.msgCh:
+			conn.SetWriteDeadline(time.Now().Add(time.Duration(bmpCollectorWriteTimeout) * time.Second))
+			if _, err := conn.Write(msg); err != nil {
+				c.logger.Err(fmt.Sprintln("BMP collector", c.address, "failed to send message, err:", err))
+				conn.Close()
+				return false
+			}
+
+		case <-statsCh:
+			select {
+			case c.server.bmpStatsCh <- c:
+			case <-c.stopCh:
+				c.terminate(conn)
+				return true
+			}
+
+		case <-c.stopCh:
+			c.terminate(conn)
+			return true
+		}
+	}
+}
+
+func (c *bmpCollector) terminate(conn net.Conn) {
+	c.logger.Info(fmt.Sprintln("BMP collector", c.address, "stopped, close the connection"))
+	if msg, err := packet.NewBMPTerminationMessage(packet.BMPTermReasonAdminClose).Encode(); err == nil {
+		conn.SetWriteDeadline(time.Now().Add(time.Duration(bmpCollectorWriteTimeout) * time.Second))
+		conn.Write(msg)
+	}
+	conn.Close()
+}
+
+func (c *bmpCollector) drainMessages() {
+	for {
+		select {
+		case <-c.msgCh:
+		default:
+			return
+		}
+	}
+}
+
+func (c *bmpCollector) sendMsg(msg []byte) {
+	if !c.up {
+		return
+	}
+
+	select {
+	case c.msgCh <- msg:
+	default:
+		c.logger.Warning(fmt.Sprintln("BMP collector", c.address, "message queue is full, drop the message"))
+	}
+}
+
+func (c *bmpCollector) isRouteMonitoringEnabled(postPolicy bool) bool {
+	switch c.config.RouteMonitoring {
+	case config.BmpRouteMonitoringPrePolicy:
+		return !postPolicy
+	case config.BmpRouteMonitoringPostPolicy:
+		return postPolicy
+	}
+	return true
+}
+
+func (server *BGPServer) AddBmpCollector(conf config.BmpCollectorConfig) {
+	if conf.Address == "" || conf.Port == 0 {
+		server.logger.Err(fmt.Sprintln("BMP collector", conf.Address, "port", conf.Port,
+			"address and port must be set"))
+		return
+	}
+
+	address := getBmpCollectorAddress(&conf)
+	if collector, ok := server.bmpCollectors[address]; ok {
+		if collector.config == conf {
+			return
+		}
+		server.logger.Info(fmt.Sprintln("BMP collector", address, "config changed, restart the collector"))
+		collector.stop()
+	}
+
+	server.logger.Info(fmt.Sprintln("Add BMP collector", address))
+	collector := newBmpCollector(server, conf)
+	server.bmpCollectors[address] = collector
+	go collector.run()
+}
+
+func (server *BGPServer) RemoveBmpCollector(address string) {
+	collector, ok := server.bmpCollectors[address]
+	if !ok {
+		server.logger.Info(fmt.Sprintln("BMP collector", address, "not found"))
+		return
+	}
+
+	server.logger.Info(fmt.Sprintln("Remove BMP collector", address))
+	collector.stop()
+	delete(server.bmpCollectors, address)
+}
+
+// The states and the stats requests of the collectors that are already removed are ignored
+func (server *BGPServer) ProcessBmpCollectorState(state bmpCollectorState) {
+	collector := state.collector
+	if server.bmpCollectors[collector.address] != collector {
+		return
+	}
+
+	collector.up = state.up
+	if !state.up {
+		server.logger.Info(fmt.Sprintln("BMP collector", collector.address, "is down"))
+		return
+	}
+
+	server.logger.Info(fmt.Sprintln("BMP collector", collector.address, "is up, send the initial dump"))
+	sysName, _ := os.Hostname()
+	server.encodeAndSendBmpMsg(collector, packet.NewBMPInitiationMessage(sysName, bmpSysDescr))
+	for _, peer := range server.PeerMap {
+		if peer.conn == nil {
+			continue
+		}
+		server.encodeAndSendBmpMsg(collector, server.getBmpPeerUpMsg(peer))
+		server.sendBmpRouteDump(collector, peer)
+	}
+}
+
+func (server *BGPServer) ProcessBmpStats(collector *bmpCollector) {
+	if server.bmpCollectors[collector.address] != collector || !collector.up {
+		return
+	}
+
+	for _, peer := range server.PeerMap {
+		if peer.conn == nil {
+			continue
+		}
+
+		stats := make([]packet.BMPStat, 0, len(peer.NeighborConf.PeerAfiSafiMap)+1)
+		total := uint64(0)
+		for protoFamily, _ := range peer.NeighborConf.PeerAfiSafiMap {
+			count := uint64(peer.NeighborConf.PrefixCounts[protoFamily])
+			afi, safi := packet.GetAfiSafi(protoFamily)
+			stats = append(stats, packet.NewBMPStatAfiSafiGauge(packet.BMPStatAfiSafiAdjRIBInRoutes, afi, safi,
+				count))
+			total += count
+		}
+		stats = append(stats, packet.NewBMPStatGauge(packet.BMPStatAdjRIBInRoutes, total))
+		server.encodeAndSendBmpMsg(collector, packet.NewBMPStatsReportMessage(server.getBmpPeerHeader(peer,
+			false), stats))
+	}
+}
+
+func (server *BGPServer) getBmpPeerHeader(peer *Peer, postPolicy bool) *packet.BMPPeerHeader {
+	return packet.NewBMPPeerHeader(peer.NeighborConf.Neighbor.NeighborAddress, peer.NeighborConf.RunningConf.PeerAS,
+		peer.NeighborConf.BGPId, peer.NeighborConf.ASSize, postPolicy)
+}
+
+func getConnPort(addr net.Addr) uint16 {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return uint16(tcpAddr.Port)
+	}
+	return 0
+}
+
+func (server *BGPServer) getBmpPeerUpMsg(peer *Peer) *packet.BMPMessage {
+	var localPort, remotePort uint16
+	if peer.conn != nil {
+		localPort = getConnPort((*peer.conn).LocalAddr())
+		remotePort = getConnPort((*peer.conn).RemoteAddr())
+	}
+	return packet.NewBMPPeerUpMessage(server.getBmpPeerHeader(peer, false),
+		peer.NeighborConf.Neighbor.Transport.Config.LocalAddress, localPort, remotePort, peer.sentOpen,
+		peer.rcvdOpen)
+}
+
+/*  Pre-policy routes are sent from the Adj-RIB-In of the neighbor when soft reconfiguration
+ *  inbound is enabled, the post-policy routes are the paths of the neighbor in the RIB.
+ *  The updates of the Adj-RIB-In and the RIB carry the prefixes of a single address family.
+ */
+func (server *BGPServer) sendBmpRouteDump(collector *bmpCollector, peer *Peer) {
+	if peer.adjRibIn != nil && collector.isRouteMonitoringEnabled(false) {
+		for _, updateMsg := range peer.getAdjRibInUpdates() {
+			server.sendBmpFamilyRouteMonitoring(collector, peer, updateMsg.Body.(*packet.BGPUpdate), false)
+		}
+	}
+
+	if collector.isRouteMonitoringEnabled(true) {
+		for _, updateMsg := range server.AdjRib.GetNeighborUpdates(peer.NeighborConf.Neighbor.NeighborAddress.String()) {
+			server.sendBmpFamilyRouteMonitoring(collector, peer, updateMsg, true)
+		}
+	}
+}
+
+func (server *BGPServer) sendBmpFamilyRouteMonitoring(collector *bmpCollector, peer *Peer,
+	updateMsg *packet.BGPUpdate, postPolicy bool) {
+	if len(updateMsg.NLRI) == 0 {
+		return
+	}
+
+	protoFamily := packet.GetNLRIProtocolFamily(updateMsg.NLRI[0])
+	for _, msg := range packet.ConstructFamilyUpdatePackets(protoFamily, updateMsg) {
+		server.encodeAndSendBmpMsg(collector, packet.NewBMPRouteMonitoringMessage(
+			server.getBmpPeerHeader(peer, postPolicy), msg))
+	}
+}
+
+func (server *BGPServer) encodeAndSendBmpMsg(collector *bmpCollector, bmpMsg *packet.BMPMessage) {
+	msg, err := bmpMsg.Encode()
+	if err != nil {
+		server.logger.Err(fmt.Sprintln("Failed to encode BMP message type", bmpMsg.Type, "err:", err))
+		return
+	}
+	collector.sendMsg(msg)
+}
+
+func (server *BGPServer) sendBmpMsg(bmpMsg *packet.BMPMessage, postPolicy bool) {
+	var msg []byte
+	for _, collector := range server.bmpCollectors {
+		if !collector.up {
+			continue
+		}
+		if bmpMsg.Type == packet.BMPMsgTypeRouteMonitoring && !collector.isRouteMonitoringEnabled(postPolicy) {
+			continue
+		}
+
+		if msg == nil {
+			var err error
+			if msg, err = bmpMsg.Encode(); err != nil {
+				server.logger.Err(fmt.Sprintln("Failed to encode BMP message type", bmpMsg.Type, "err:", err))
+				return
+			}
+		}
+		collector.sendMsg(msg)
+	}
+}
+
+func (server *BGPServer) isBmpEnabled() bool {
+	for _, collector := range server.bmpCollectors {
+		if collector.up {
+			return true
+		}
+	}
+	return false
+}
+
+func (server *BGPServer) SendBmpPeerUp(peer *Peer) {
+	if !server.isBmpEnabled() {
+		return
+	}
+	server.sendBmpMsg(server.getBmpPeerUpMsg(peer), false)
+}
+
+/*  The reason of the Peer Down message is derived from the NOTIFICATION message sent or
+ *  received when the connection went down. Must be called before the peer state is reset.
+ */
+func (server *BGPServer) SendBmpPeerDown(peer *Peer, fsmConn *fsm.PeerFSMConn) {
+	if peer.conn == nil || !server.isBmpEnabled() {
+		return
+	}
+
+	var reason uint8
+	var data []byte
+	if fsmConn.Deconfigured {
+		reason = packet.BMPPeerDownDeconfigured
+	} else if fsmConn.Notification != nil && fsmConn.NotificationSent {
+		reason = packet.BMPPeerDownLocalNotification
+		data = fsmConn.Notification
+	} else if fsmConn.Notification != nil {
+		reason = packet.BMPPeerDownRemoteNotification
+		data = fsmConn.Notification
+	} else {
+		reason = packet.BMPPeerDownRemoteNoData
+	}
+	server.sendBmpMsg(packet.NewBMPPeerDownMessage(server.getBmpPeerHeader(peer, false), reason, data), false)
+}
+
+func (server *BGPServer) SendBmpRouteMonitoring(peer *Peer, updateMsg *packet.BGPMessage, postPolicy bool) {
+	if !server.isBmpEnabled() {
+		return
+	}
+	server.sendBmpMsg(packet.NewBMPRouteMonitoringMessage(server.getBmpPeerHeader(peer, postPolicy), updateMsg),
+		postPolicy)
+}
+
+func (server *BGPServer) SendBmpFamilyRouteMonitoring(peer *Peer, protoFamily uint32, updateMsg *packet.BGPUpdate) {
+	if !server.isBmpEnabled() {
+		return
+	}
+	if len(updateMsg.NLRI) == 0 && len(updateMsg.WithdrawnRoutes) == 0 {
+		return
+	}
+
+	for _, msg := range packet.ConstructFamilyUpdatePackets(protoFamily, updateMsg) {
+		server.sendBmpMsg(packet.NewBMPRouteMonitoringMessage(server.getBmpPeerHeader(peer, true), msg), true)
+	}
+}
@@ -0,0 +1,235 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// conditionalAdv.go
+package server
+
+import (
+	"fmt"
+	"l3/bgp/packet"
+	bgppolicy "l3/bgp/policy"
+	bgprib "l3/bgp/rib"
+	"net"
+)
+
+// The conditions of the default route and of the conditionally advertised routes are
+// checked against the Loc-RIB every scan interval, in seconds.
+const conditionalAdvScanInterval = 60
+
+// Path id of the default route sent to the neighbors that receive add paths
+const defaultRoutePathId uint32 = 1
+
+/*  isPolicyMatchedInLocRib returns true when an accept statement of the policy matches a route
+ *  in the Loc-RIB. A policy that does not exist matches no route.
+ */
+func (server *BGPServer) isPolicyMatchedInLocRib(policyName string) bool {
+	if !server.bgpPE.RoutePolicyDB.HasPolicy(policyName) {
+		server.logger.Err(fmt.Sprintln("Route policy", policyName, "not found, it does not match any route"))
+		return false
+	}
+
+	for path, dests := range server.AdjRib.GetLocRib() {
+		for _, dest := range dests {
+			params := &bgppolicy.RoutePolicyParams{
+				PathAttrs: path.PathAttrs,
+				Prefix:    dest.IPPrefix,
+			}
+			if server.bgpPE.RoutePolicyDB.MatchPolicy(policyName, params) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/*  Returns true when the routes accepted by the advertise policy of the group can be advertised,
+ *  when the exist policy accepts a route in the Loc-RIB and the non-exist policy accepts none.
+ *  The routes are always advertised when the group has no advertise policy.
+ */
+func (g *UpdateGroup) getAdvertiseCondition() bool {
+	conf := &g.leader().NeighborConf.RunningConf
+	if conf.AdvertisePolicy == "" {
+		return true
+	}
+
+	if conf.AdvertiseExistPolicy != "" && !g.server.isPolicyMatchedInLocRib(conf.AdvertiseExistPolicy) {
+		return false
+	}
+	if conf.AdvertiseNonExistPolicy != "" && g.server.isPolicyMatchedInLocRib(conf.AdvertiseNonExistPolicy) {
+		return false
+	}
+	return true
+}
+
+// updateAdvertiseCondition checks the advertise condition of the group and returns true if it changed
+func (g *UpdateGroup) updateAdvertiseCondition() bool {
+	advertiseCond := g.getAdvertiseCondition()
+	if advertiseCond == g.advertiseCond {
+		return false
+	}
+
+	g.logger.Info(fmt.Sprintf("Update group %d: Advertise condition changed to %t", g.id, advertiseCond))
+	g.advertiseCond = advertiseCond
+	return true
+}
+
+// isWithheld returns true for the routes matched by the advertise policy when the advertise condition is not met
+func (g *UpdateGroup) isWithheld(dest *bgprib.Destination, path *bgprib.Path) bool {
+	if g.advertiseCond {
+		return false
+	}
+
+	if path == nil {
+		path = dest.LocRibPath
+	}
+	if path == nil {
+		return false
+	}
+
+	params := &bgppolicy.RoutePolicyParams{
+		Neighbor:  g.leader().NeighborConf.Neighbor.NeighborAddress,
+		PathAttrs: path.PathAttrs,
+		Prefix:    dest.IPPrefix,
+	}
+	return g.server.bgpPE.RoutePolicyDB.MatchPolicy(g.leader().NeighborConf.RunningConf.AdvertisePolicy, params)
+}
+
+func getDefaultRoutePrefix(protoFamily uint32) *packet.IPPrefix {
+	if afi, _ := packet.GetAfiSafi(protoFamily); afi == packet.AfiIP6 {
+		return packet.NewIPPrefix(net.IPv6zero, 0)
+	}
+	return packet.NewIPPrefix(net.IPv4zero, 0)
+}
+
+// isDefaultOriginated returns true when the destination is the default route originated for the group,
+// the default route in the Loc-RIB is not advertised then
+func (g *UpdateGroup) isDefaultOriginated(dest *bgprib.Destination) bool {
+	return dest.IPPrefix.Length == 0 && g.defaultRoutes[packet.GetNLRIProtocolFamily(dest.IPPrefix)]
+}
+
+func (g *UpdateGroup) getDefaultRouteNLRI(protoFamily uint32) packet.NLRI {
+	prefix := getDefaultRoutePrefix(protoFamily)
+	if g.leader().NeighborConf.IsAddPathsTxNegotiated(protoFamily) {
+		return packet.NewExtNLRI(defaultRoutePathId, *prefix)
+	}
+	return prefix
+}
+
+/*  Returns true when the group sends the default route of the family, the neighbors of the group
+ *  negotiated the family and set SendDefaultRoute for it and the default originate policy, when
+ *  set, accepts a route in the Loc-RIB.
+ */
+func (g *UpdateGroup) getDefaultRouteCondition(protoFamily uint32, policyMatched bool) bool {
+	leader := g.leader()
+	afi, safi := packet.GetAfiSafi(protoFamily)
+	return leader.NeighborConf.DefaultRouteFamilies[protoFamily] && policyMatched &&
+		leader.NeighborConf.IsProtocolFamilyNegotiated(afi, safi)
+}
+
+// updateDefaultRoutes advertises or withdraws the default routes of the group when their condition changed
+func (g *UpdateGroup) updateDefaultRoutes() {
+	leader := g.leader()
+	if len(leader.NeighborConf.DefaultRouteFamilies) == 0 && len(g.defaultRoutes) == 0 {
+		return
+	}
+
+	policyMatched := true
+	if policyName := leader.NeighborConf.RunningConf.DefaultOriginatePolicy; policyName != "" {
+		policyMatched = g.server.isPolicyMatchedInLocRib(policyName)
+	}
+
+	for _, protoFamily := range []uint32{packet.GetProtocolFamily(packet.AfiIP, packet.SafiUnicast),
+		packet.GetProtocolFamily(packet.AfiIP6, packet.SafiUnicast)} {
+		send := g.getDefaultRouteCondition(protoFamily, policyMatched)
+		if send == g.defaultRoutes[protoFamily] {
+			continue
+		}
+
+		if send {
+			g.sendDefaultRoute(protoFamily)
+		} else {
+			g.withdrawDefaultRoute(protoFamily)
+		}
+	}
+}
+
+// sendDefaultRoute advertises the default route of the family in place of the default route in the Loc-RIB
+func (g *UpdateGroup) sendDefaultRoute(protoFamily uint32) {
+	g.logger.Info(fmt.Sprintf("Update group %d: Advertise default route for family %d", g.id, protoFamily))
+	prefix := getDefaultRoutePrefix(protoFamily)
+	ip := prefix.Prefix.String()
+	withdrawList := make([]packet.NLRI, 0)
+	if g.leader().NeighborConf.IsAddPathsTxNegotiated(protoFamily) {
+		for pathId, _ := range g.ribOut[ip] {
+			withdrawList = append(withdrawList, packet.NewExtNLRI(pathId, *prefix))
+		}
+	}
+	delete(g.ribOut, ip)
+
+	g.defaultRoutes[protoFamily] = true
+	newUpdated := map[*bgprib.Path][]packet.NLRI{g.server.ConnRoutesPath: {g.getDefaultRouteNLRI(protoFamily)}}
+	g.sendUpdates(withdrawList, nil, newUpdated, g.members)
+}
+
+// withdrawDefaultRoute withdraws the default route of the family and advertises the default route in the Loc-RIB
+func (g *UpdateGroup) withdrawDefaultRoute(protoFamily uint32) {
+	g.logger.Info(fmt.Sprintf("Update group %d: Withdraw default route for family %d", g.id, protoFamily))
+	delete(g.defaultRoutes, protoFamily)
+	g.sendUpdates([]packet.NLRI{g.getDefaultRouteNLRI(protoFamily)}, nil, nil, g.members)
+
+	dest, ok := g.locRib().GetDest(getDefaultRoutePrefix(protoFamily), false)
+	if ok && dest != nil && dest.IPPrefix.Length == 0 && dest.LocRibPath != nil {
+		updated := map[*bgprib.Path][]*bgprib.Destination{dest.LocRibPath: {dest}}
+		g.SendUpdate(updated, make([]*bgprib.Destination, 0), nil, make([]*bgprib.Destination, 0))
+	}
+}
+
+// sendDefaultRoutesToPeer sends the default routes advertised by the group to a peer that joined it
+func (g *UpdateGroup) sendDefaultRoutesToPeer(peer *Peer) {
+	for protoFamily, _ := range g.defaultRoutes {
+		newUpdated := map[*bgprib.Path][]packet.NLRI{g.server.ConnRoutesPath: {g.getDefaultRouteNLRI(protoFamily)}}
+		g.sendUpdates(nil, nil, newUpdated, []*Peer{peer})
+	}
+}
+
+/*  Called every scan interval to check the conditions of the default routes and of the
+ *  conditionally advertised routes of the update groups against the Loc-RIB. The routes
+ *  of a group are calculated again from the Loc-RIB when its advertise condition changed.
+ */
+func (server *BGPServer) ProcessConditionalAdvScan() {
+	if server.grRestarting {
+		return
+	}
+
+	for _, group := range server.updateGroups {
+		if len(group.members) == 0 {
+			continue
+		}
+
+		if group.updateAdvertiseCondition() {
+			group.SendUpdate(group.locRib().GetLocRib(), make([]*bgprib.Destination, 0), nil,
+				make([]*bgprib.Destination, 0))
+		}
+		group.updateDefaultRoutes()
+	}
+}
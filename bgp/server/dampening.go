@@ -0,0 +1,57 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// dampening.go
+package server
+
+import (
+	"l3/bgp/config"
+)
+
+func setDampeningDefaults(gConf *config.GlobalConfig) {
+	if gConf.DampeningHalfLife == 0 {
+		gConf.DampeningHalfLife = config.BGPDampeningHalfLifeDefault
+	}
+	if gConf.DampeningReuse == 0 {
+		gConf.DampeningReuse = config.BGPDampeningReuseDefault
+	}
+	if gConf.DampeningSuppress == 0 {
+		gConf.DampeningSuppress = config.BGPDampeningSuppressDefault
+	}
+	if gConf.DampeningMaxSuppressTime == 0 {
+		gConf.DampeningMaxSuppressTime = config.BGPDampeningMaxSuppressTimeDefault
+	}
+}
+
+// Called every reuse interval to advertise the paths that are not suppressed anymore
+func (server *BGPServer) ProcessDampeningReuse() {
+	suppressed := server.AdjRib.GetSuppressedPaths()
+	updated, withdrawn, updatedAddPaths := server.AdjRib.ReuseDampenedPaths(server.AddPathCount)
+	server.sendRouteServerReusedPaths(suppressed)
+	if len(updated) == 0 && len(withdrawn) == 0 && len(updatedAddPaths) == 0 {
+		return
+	}
+
+	updated, withdrawn, _, updatedAddPaths = server.CheckForAggregation(updated, withdrawn, nil, updatedAddPaths)
+	server.SendUpdate(updated, withdrawn, nil, updatedAddPaths)
+}
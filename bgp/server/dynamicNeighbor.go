@@ -0,0 +1,146 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// dynamicNeighbor.go
+package server
+
+import (
+	"fmt"
+	"l3/bgp/config"
+	"net"
+)
+
+// The time a dynamic neighbor gets to reach the Established state when its hold time is 0
+const dynamicNeighborTimeDefault uint32 = 180
+
+/*  Dynamic neighbors are created for the connections from the addresses in the listen
+ *  ranges of the peer groups. They inherit the config of the peer group, never initiate
+ *  a connection to the peer and are removed when the session goes down. A dynamic
+ *  neighbor that doesn't reach the Established state before the hold time is removed too.
+ */
+func (server *BGPServer) getDynamicPeerGroup(ip net.IP) *config.PeerGroup {
+	var matchGroup *config.PeerGroup
+	matchLen := -1
+	for _, group := range server.BgpConfig.PeerGroups {
+		if group.Config.PeerAS == 0 && len(group.Config.AllowedAS) == 0 {
+			continue
+		}
+
+		for _, listenRange := range group.Config.ListenRanges {
+			_, ipNet, err := net.ParseCIDR(listenRange)
+			if err != nil {
+				server.logger.Err(fmt.Sprintln("Peer group", group.Config.Name, "listen range", listenRange,
+					"is not valid, error:", err))
+				continue
+			}
+
+			if ones, _ := ipNet.Mask.Size(); ipNet.Contains(ip) && ones > matchLen {
+				matchGroup = group
+				matchLen = ones
+			}
+		}
+	}
+	return matchGroup
+}
+
+func (server *BGPServer) getNumDynamicNeighbors(groupName string) uint32 {
+	count := uint32(0)
+	for _, peer := range server.PeerMap {
+		if peer.NeighborConf.IsDynamic() && peer.NeighborConf.RunningConf.PeerGroup == groupName {
+			count++
+		}
+	}
+	return count
+}
+
+func (server *BGPServer) createDynamicNeighbor(ip net.IP) *Peer {
+	if ip == nil {
+		return nil
+	}
+
+	group := server.getDynamicPeerGroup(ip)
+	if group == nil {
+		return nil
+	}
+
+	if group.Config.MaxDynamicNeighbors != 0 &&
+		server.getNumDynamicNeighbors(group.Config.Name) >= group.Config.MaxDynamicNeighbors {
+		server.logger.Info(fmt.Sprintln("Can't add dynamic neighbor", ip, "peer group", group.Config.Name,
+			"reached the max dynamic neighbors", group.Config.MaxDynamicNeighbors))
+		return nil
+	}
+
+	if group.Config.AuthPassword != "" && server.peerGroupMD5[group.Config.Name] != group.Config.AuthPassword {
+		server.logger.Info(fmt.Sprintln("Can't add dynamic neighbor", ip, "MD5 authentication is not set",
+			"for peer group", group.Config.Name))
+		return nil
+	}
+
+	server.logger.Info(fmt.Sprintln("Add dynamic neighbor, ip:", ip, "peer group:", group.Config.Name))
+	peerConf := config.NeighborConfig{
+		NeighborAddress: ip,
+		PeerGroup:       group.Config.Name,
+		Dynamic:         true,
+	}
+	peer := NewPeer(server, &server.BgpConfig.Global.Config, &group.Config, peerConf)
+	peer.NeighborConf.Restarting = server.grRestarting
+	server.PeerMap[ip.String()] = peer
+	server.NeighborMutex.Lock()
+	server.addPeerToList(peer)
+	server.NeighborMutex.Unlock()
+	peer.ProcessBfd(true)
+	peer.Init()
+	holdTime := peer.NeighborConf.RunningConf.HoldTime
+	if holdTime == 0 {
+		holdTime = dynamicNeighborTimeDefault
+	}
+	peer.startDynamicTimer(holdTime)
+	return peer
+}
+
+func (server *BGPServer) removeDynamicNeighbor(peerIP string, peer *Peer) {
+	server.logger.Info(fmt.Sprintln("Remove dynamic neighbor:", peerIP))
+	peer.stopDynamicTimer()
+	server.removeNeighbor(peerIP, peer)
+}
+
+func (server *BGPServer) removeDynamicNeighborsByGroup(groupName string) {
+	for peerIP, peer := range server.PeerMap {
+		if peer.NeighborConf.IsDynamic() && peer.NeighborConf.RunningConf.PeerGroup == groupName {
+			server.removeDynamicNeighbor(peerIP, peer)
+		}
+	}
+}
+
+func (server *BGPServer) ProcessDynamicTimerExpiry(peerIP string) {
+	peer, ok := server.PeerMap[peerIP]
+	if !ok || !peer.NeighborConf.IsDynamic() {
+		return
+	}
+
+	peer.dynamicTimer = nil
+	if peer.NeighborConf.Neighbor.Transport.Config.LocalAddress == nil {
+		server.logger.Info(fmt.Sprintln("Dynamic neighbor", peerIP, "did not establish the session"))
+		server.removeDynamicNeighbor(peerIP, peer)
+	}
+}
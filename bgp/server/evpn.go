@@ -0,0 +1,525 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// evpn.go
+package server
+
+import (
+	"errors"
+	"fmt"
+	"l3/bgp/config"
+	"l3/bgp/packet"
+	bgprib "l3/bgp/rib"
+	"net"
+	"reflect"
+)
+
+/*  An EVPN instance is a VXLAN segment identified by its VNI. The inclusive multicast route
+ *  of the instance advertises the local VTEP and the MAC/IP advertisement routes advertise
+ *  the MACs learnt locally on the segment to the peers that negotiated the L2VPN EVPN family.
+ *  The routes received from the peers with an import route target of the instance create the
+ *  remote VTEPs and the remote MACs of the segment.
+ */
+type evpnInstance struct {
+	config     config.EvpnConfig
+	rd         packet.RouteDistinguisher
+	importRTs  map[uint64]bool
+	exportRTs  []uint64
+	imetPath   *bgprib.Path
+	macPath    *bgprib.Path
+	localMacs  map[string]*config.EvpnMacInfo
+	vteps      map[string]int
+	remoteMacs map[string]int
+}
+
+/*  EVPN route received from a peer. The routes are kept for all the instances, they are
+ *  imported when an instance is added.
+ */
+type evpnRoute struct {
+	nlri         *packet.EVPNNLRI
+	vtepIP       net.IP
+	vni          uint32
+	routeTargets []uint64
+	stale        bool
+	refreshStale bool
+}
+
+func getEvpnMacKey(mac net.HardwareAddr, ip net.IP) string {
+	if ip == nil {
+		return mac.String()
+	}
+	return mac.String() + "-" + ip.String()
+}
+
+func (server *BGPServer) newEvpnInstance(conf config.EvpnConfig) (*evpnInstance, error) {
+	if conf.VNI == 0 || conf.VNI > packet.MPLSLabelMax {
+		return nil, errors.New(fmt.Sprintf("VNI %d is not in the range 1-%d", conf.VNI, packet.MPLSLabelMax))
+	}
+
+	rd, err := packet.ParseRouteDistinguisher(conf.RouteDistinguisher)
+	if err != nil {
+		return nil, err
+	}
+
+	importRTs, err := parseRouteTargets(conf.ImportRouteTargets)
+	if err != nil {
+		return nil, err
+	}
+
+	exportRTs, err := parseRouteTargets(conf.ExportRouteTargets)
+	if err != nil {
+		return nil, err
+	}
+
+	gConf := &server.BgpConfig.Global.Config
+	extCommunities := make([]uint64, 0, len(exportRTs)+1)
+	extCommunities = append(extCommunities, exportRTs...)
+	extCommunities = append(extCommunities, packet.NewEncapsulationExtCommunity(packet.BGPTunnelTypeVXLAN))
+	macPathAttrs := packet.AddExtCommunities(packet.ConstructPathAttrForConnRoutes(gConf.RouterId, gConf.AS),
+		extCommunities)
+	imetPathAttrs := packet.AddExtCommunities(packet.ConstructPathAttrForConnRoutes(gConf.RouterId, gConf.AS),
+		extCommunities)
+	imetPathAttrs = packet.AddPMSITunnel(imetPathAttrs, packet.PMSITunnelTypeIngressReplication, conf.VNI,
+		gConf.RouterId)
+
+	if _, ok := server.evpnLocalMacs[conf.VNI]; !ok {
+		server.evpnLocalMacs[conf.VNI] = make(map[string]*config.EvpnMacInfo)
+	}
+
+	inst := &evpnInstance{
+		config:     conf,
+		rd:         rd,
+		importRTs:  make(map[uint64]bool),
+		exportRTs:  exportRTs,
+		imetPath:   bgprib.NewPath(server.AdjRib, nil, imetPathAttrs, false, false, bgprib.RouteTypeConnected),
+		macPath:    bgprib.NewPath(server.AdjRib, nil, macPathAttrs, false, false, bgprib.RouteTypeConnected),
+		localMacs:  server.evpnLocalMacs[conf.VNI],
+		vteps:      make(map[string]int),
+		remoteMacs: make(map[string]int),
+	}
+	for _, routeTarget := range importRTs {
+		inst.importRTs[routeTarget] = true
+	}
+	return inst, nil
+}
+
+/*  The routes are imported by the route targets of the instance. The instances without any
+ *  import route target import the routes of their VNI.
+ */
+func (inst *evpnInstance) isImported(route *evpnRoute) bool {
+	if route.nlri.RouteType != packet.EVPNRouteTypeMACIPAdvertisement &&
+		route.nlri.RouteType != packet.EVPNRouteTypeInclusiveMulticast {
+		return false
+	}
+
+	if len(inst.importRTs) == 0 {
+		return route.vni == inst.config.VNI
+	}
+
+	for _, routeTarget := range route.routeTargets {
+		if inst.importRTs[routeTarget] {
+			return true
+		}
+	}
+	return false
+}
+
+func (inst *evpnInstance) getIMETNLRI(originatorIP net.IP) packet.NLRI {
+	return packet.NewEVPNInclusiveMulticast(inst.rd, 0, originatorIP)
+}
+
+func (inst *evpnInstance) getMacNLRI(mac *config.EvpnMacInfo) packet.NLRI {
+	return packet.NewEVPNMACIPAdvertisement(inst.rd, 0, mac.MAC, mac.IP, []uint32{inst.config.VNI})
+}
+
+func (inst *evpnInstance) getLocalRoutes(originatorIP net.IP) map[*bgprib.Path][]packet.NLRI {
+	routes := make(map[*bgprib.Path][]packet.NLRI)
+	routes[inst.imetPath] = []packet.NLRI{inst.getIMETNLRI(originatorIP)}
+	for _, mac := range inst.localMacs {
+		routes[inst.macPath] = append(routes[inst.macPath], inst.getMacNLRI(mac))
+	}
+	return routes
+}
+
+func (server *BGPServer) AddEvpnInstance(conf config.EvpnConfig) {
+	if inst, ok := server.evpnInstances[conf.VNI]; ok {
+		if reflect.DeepEqual(inst.config, conf) {
+			return
+		}
+		server.logger.Info(fmt.Sprintln("EVPN instance", conf.VNI, "config changed, remove the instance and add it again"))
+		server.RemoveEvpnInstance(conf.VNI)
+	}
+
+	inst, err := server.newEvpnInstance(conf)
+	if err != nil {
+		server.logger.Err(fmt.Sprintln("Failed to add EVPN instance", conf.VNI, "err:", err))
+		return
+	}
+
+	server.logger.Info(fmt.Sprintln("Add EVPN instance", conf.VNI, "route distinguisher", inst.rd))
+	server.evpnInstances[conf.VNI] = inst
+	for _, routes := range server.evpnRoutes {
+		for _, route := range routes {
+			if inst.isImported(route) {
+				server.importEvpnRoute(inst, route)
+			}
+		}
+	}
+	server.sendEvpnUpdates(inst, inst.getLocalRoutes(server.BgpConfig.Global.Config.RouterId), nil, nil)
+}
+
+func (server *BGPServer) RemoveEvpnInstance(vni uint32) {
+	inst, ok := server.evpnInstances[vni]
+	if !ok {
+		server.logger.Info(fmt.Sprintln("EVPN instance", vni, "not found"))
+		return
+	}
+
+	server.logger.Info(fmt.Sprintln("Remove EVPN instance", vni))
+	withdrawn := make([]packet.NLRI, 0)
+	for _, nlriList := range inst.getLocalRoutes(server.BgpConfig.Global.Config.RouterId) {
+		withdrawn = append(withdrawn, nlriList...)
+	}
+	server.sendEvpnUpdates(inst, nil, withdrawn, nil)
+
+	for _, routes := range server.evpnRoutes {
+		for _, route := range routes {
+			if inst.isImported(route) {
+				server.unimportEvpnRoute(inst, route)
+			}
+		}
+	}
+	delete(server.evpnInstances, vni)
+}
+
+func (server *BGPServer) getRemoteMacInfo(inst *evpnInstance, route *evpnRoute) *config.EvpnMacInfo {
+	return &config.EvpnMacInfo{
+		VNI:    inst.config.VNI,
+		MAC:    route.nlri.MAC,
+		IP:     route.nlri.IP,
+		VtepIP: route.vtepIP,
+	}
+}
+
+/*  The VTEPs and the remote MACs are ref counted as the same route can be received from
+ *  multiple peers, like the route reflectors of the network.
+ */
+func (server *BGPServer) importEvpnRoute(inst *evpnInstance, route *evpnRoute) {
+	switch route.nlri.RouteType {
+	case packet.EVPNRouteTypeInclusiveMulticast:
+		key := route.vtepIP.String()
+		inst.vteps[key]++
+		if inst.vteps[key] == 1 {
+			server.logger.Info(fmt.Sprintln("EVPN instance", inst.config.VNI, "create VTEP", key))
+			server.vtepMgr.CreateVtep(&config.VtepInfo{
+				VNI:    inst.config.VNI,
+				SrcIP:  server.BgpConfig.Global.Config.RouterId,
+				VtepIP: route.vtepIP,
+			})
+		}
+
+	case packet.EVPNRouteTypeMACIPAdvertisement:
+		key := getEvpnMacKey(route.nlri.MAC, route.nlri.IP) + "-" + route.vtepIP.String()
+		inst.remoteMacs[key]++
+		if inst.remoteMacs[key] == 1 {
+			server.logger.Info(fmt.Sprintln("EVPN instance", inst.config.VNI, "add remote MAC", key))
+			server.vtepMgr.AddRemoteMac(server.getRemoteMacInfo(inst, route))
+		}
+	}
+}
+
+func (server *BGPServer) unimportEvpnRoute(inst *evpnInstance, route *evpnRoute) {
+	switch route.nlri.RouteType {
+	case packet.EVPNRouteTypeInclusiveMulticast:
+		key := route.vtepIP.String()
+		inst.vteps[key]--
+		if inst.vteps[key] <= 0 {
+			delete(inst.vteps, key)
+			server.logger.Info(fmt.Sprintln("EVPN instance", inst.config.VNI, "delete VTEP", key))
+			server.vtepMgr.DeleteVtep(&config.VtepInfo{
+				VNI:    inst.config.VNI,
+				SrcIP:  server.BgpConfig.Global.Config.RouterId,
+				VtepIP: route.vtepIP,
+			})
+		}
+
+	case packet.EVPNRouteTypeMACIPAdvertisement:
+		key := getEvpnMacKey(route.nlri.MAC, route.nlri.IP) + "-" + route.vtepIP.String()
+		inst.remoteMacs[key]--
+		if inst.remoteMacs[key] <= 0 {
+			delete(inst.remoteMacs, key)
+			server.logger.Info(fmt.Sprintln("EVPN instance", inst.config.VNI, "remove remote MAC", key))
+			server.vtepMgr.RemoveRemoteMac(server.getRemoteMacInfo(inst, route))
+		}
+	}
+}
+
+func (server *BGPServer) addEvpnRoute(peerIP string, route *evpnRoute) {
+	key := route.nlri.String()
+	server.removeEvpnRoute(peerIP, key)
+	if _, ok := server.evpnRoutes[peerIP]; !ok {
+		server.evpnRoutes[peerIP] = make(map[string]*evpnRoute)
+	}
+	server.evpnRoutes[peerIP][key] = route
+
+	for _, inst := range server.evpnInstances {
+		if inst.isImported(route) {
+			server.importEvpnRoute(inst, route)
+		}
+	}
+}
+
+func (server *BGPServer) removeEvpnRoute(peerIP string, key string) {
+	route, ok := server.evpnRoutes[peerIP][key]
+	if !ok {
+		return
+	}
+
+	delete(server.evpnRoutes[peerIP], key)
+	for _, inst := range server.evpnInstances {
+		if inst.isImported(route) {
+			server.unimportEvpnRoute(inst, route)
+		}
+	}
+}
+
+func isEVPNUpdate(updateMsg *packet.BGPUpdate) bool {
+	nlriList := updateMsg.NLRI
+	if len(nlriList) == 0 {
+		nlriList = updateMsg.WithdrawnRoutes
+	}
+	if len(nlriList) == 0 {
+		return false
+	}
+	_, ok := nlriList[0].(*packet.EVPNNLRI)
+	return ok
+}
+
+func getEVPNIPPrefix(nlri *packet.EVPNNLRI) packet.NLRI {
+	return packet.NewLabeledPrefix(*nlri.GetPrefix(), []uint32{nlri.GetVNI()})
+}
+
+func getEVPNIPPrefixes(nlriList []packet.NLRI) []packet.NLRI {
+	ipPrefixes := make([]packet.NLRI, 0, len(nlriList))
+	for _, nlri := range nlriList {
+		ipPrefixes = append(ipPrefixes, getEVPNIPPrefix(nlri.(*packet.EVPNNLRI)))
+	}
+	return ipPrefixes
+}
+
+/*  The MAC/IP advertisement and the inclusive multicast routes are imported into the EVPN
+ *  instances. The IP prefix routes are processed by the VPN rib and imported into the VRFs
+ *  with an L3 VNI like the VPN routes. The VTEP of an inclusive multicast route is the tunnel identifier of its PMSI
+ *  tunnel attribute, the VTEP of the other routes is the next hop of the route.
+ */
+func (server *BGPServer) processEVPNUpdate(peer *Peer, pktInfo *packet.BGPPktSrc) {
+	updateMsg := pktInfo.Msg.Body.(*packet.BGPUpdate)
+	peerIP := pktInfo.Src
+	ipPrefixUpdate := &packet.BGPUpdate{
+		WithdrawnRoutes: make([]packet.NLRI, 0),
+		PathAttributes:  updateMsg.PathAttributes,
+		NLRI:            make([]packet.NLRI, 0),
+	}
+
+	for _, nlri := range updateMsg.WithdrawnRoutes {
+		evpnNLRI, ok := nlri.(*packet.EVPNNLRI)
+		if !ok {
+			continue
+		}
+		if evpnNLRI.RouteType == packet.EVPNRouteTypeIPPrefix {
+			ipPrefixUpdate.WithdrawnRoutes = append(ipPrefixUpdate.WithdrawnRoutes, evpnNLRI)
+		} else {
+			server.removeEvpnRoute(peerIP, evpnNLRI.String())
+		}
+	}
+
+	localVtepIP := server.BgpConfig.Global.Config.RouterId
+	routeTargets := packet.GetRouteTargets(updateMsg.PathAttributes)
+	nextHop := packet.GetNextHop(updateMsg.PathAttributes)
+	pmsiTunnel := packet.GetPMSITunnel(updateMsg.PathAttributes)
+	for _, nlri := range updateMsg.NLRI {
+		evpnNLRI, ok := nlri.(*packet.EVPNNLRI)
+		if !ok {
+			continue
+		}
+		if evpnNLRI.RouteType == packet.EVPNRouteTypeIPPrefix {
+			ipPrefixUpdate.NLRI = append(ipPrefixUpdate.NLRI, evpnNLRI)
+			continue
+		}
+
+		route := &evpnRoute{
+			nlri:         evpnNLRI,
+			vtepIP:       nextHop,
+			vni:          evpnNLRI.GetVNI(),
+			routeTargets: routeTargets,
+		}
+		if evpnNLRI.RouteType == packet.EVPNRouteTypeInclusiveMulticast && pmsiTunnel != nil {
+			if pmsiTunnel.TunnelId != nil {
+				route.vtepIP = pmsiTunnel.TunnelId
+			}
+			if pmsiTunnel.Label != 0 {
+				route.vni = pmsiTunnel.Label
+			}
+		}
+
+		if route.vtepIP.Equal(localVtepIP) {
+			server.logger.Info(fmt.Sprintln("Neighbor", peerIP, "EVPN route", evpnNLRI,
+				"has the local VTEP, ignore the route"))
+			server.removeEvpnRoute(peerIP, evpnNLRI.String())
+			continue
+		}
+		server.addEvpnRoute(peerIP, route)
+	}
+
+	if len(ipPrefixUpdate.WithdrawnRoutes) > 0 || len(ipPrefixUpdate.NLRI) > 0 {
+		ipPrefixUpdate = server.processVPNRibUpdate(peer, pktInfo, ipPrefixUpdate)
+		server.importVrfRoutes(peer, pktInfo, &packet.BGPUpdate{
+			WithdrawnRoutes: getEVPNIPPrefixes(ipPrefixUpdate.WithdrawnRoutes),
+			PathAttributes:  ipPrefixUpdate.PathAttributes,
+			NLRI:            getEVPNIPPrefixes(ipPrefixUpdate.NLRI),
+		}, true)
+	}
+}
+
+/*  Advertise the MACs learnt locally on the VXLAN segments and withdraw the MACs that aged out.
+ *  The MACs are kept for the VNIs without an instance, they are advertised when the instance
+ *  is added.
+ */
+func (server *BGPServer) ProcessLocalMacs(add []*config.EvpnMacInfo, remove []*config.EvpnMacInfo) {
+	for _, mac := range remove {
+		key := getEvpnMacKey(mac.MAC, mac.IP)
+		if _, ok := server.evpnLocalMacs[mac.VNI][key]; !ok {
+			continue
+		}
+		delete(server.evpnLocalMacs[mac.VNI], key)
+
+		inst, ok := server.evpnInstances[mac.VNI]
+		if !ok {
+			continue
+		}
+		server.sendEvpnUpdates(inst, nil, []packet.NLRI{inst.getMacNLRI(mac)}, nil)
+	}
+
+	for _, mac := range add {
+		if _, ok := server.evpnLocalMacs[mac.VNI]; !ok {
+			server.evpnLocalMacs[mac.VNI] = make(map[string]*config.EvpnMacInfo)
+		}
+		server.evpnLocalMacs[mac.VNI][getEvpnMacKey(mac.MAC, mac.IP)] = mac
+
+		inst, ok := server.evpnInstances[mac.VNI]
+		if !ok {
+			server.logger.Info(fmt.Sprintln("EVPN instance", mac.VNI, "not found, MAC", mac.MAC,
+				"is advertised when the instance is added"))
+			continue
+		}
+		updated := map[*bgprib.Path][]packet.NLRI{inst.macPath: []packet.NLRI{inst.getMacNLRI(mac)}}
+		server.sendEvpnUpdates(inst, updated, nil, nil)
+	}
+}
+
+/*  Advertise the local routes of the EVPN instance to the peers in all the update groups,
+ *  or to the peer when it is set.
+ */
+func (server *BGPServer) sendEvpnUpdates(inst *evpnInstance, updated map[*bgprib.Path][]packet.NLRI,
+	withdrawn []packet.NLRI, peer *Peer) {
+	if server.grRestarting {
+		return
+	}
+
+	if len(withdrawn) == 0 && len(updated) == 0 {
+		return
+	}
+
+	if peer != nil {
+		peer.updateGroup.sendEvpnUpdates(inst, withdrawn, updated, []*Peer{peer})
+		return
+	}
+
+	for _, group := range server.updateGroups {
+		group.sendEvpnUpdates(inst, withdrawn, updated, group.members)
+	}
+}
+
+func (g *UpdateGroup) sendEvpnUpdates(inst *evpnInstance, withdrawList []packet.NLRI,
+	newUpdated map[*bgprib.Path][]packet.NLRI, members []*Peer) {
+	if !g.leader().NeighborConf.IsProtocolFamilyNegotiated(packet.AfiL2VPN, packet.SafiEVPN) {
+		return
+	}
+
+	if len(withdrawList) > 0 {
+		g.logger.Info(fmt.Sprintf("Update group %d: Send update message withdraw EVPN instance %d routes:%+v",
+			g.id, inst.config.VNI, withdrawList))
+		pathAttrs := []packet.BGPPathAttr{packet.NewBGPPathAttrMPUnreachNLRI(packet.AfiL2VPN, packet.SafiEVPN)}
+		g.sendUpdateMsg(packet.NewBGPUpdateMessage(withdrawList, pathAttrs, nil), nil, members)
+	}
+
+	for path, nlriList := range newUpdated {
+		g.logger.Info(fmt.Sprintf("Update group %d: Send update message EVPN instance %d routes:%+v",
+			g.id, inst.config.VNI, nlriList))
+		pathAttrs := packet.ConstructPathAttrsForFamily(path.PathAttrs, packet.AfiL2VPN, packet.SafiEVPN)
+		updateMsg := packet.NewBGPUpdateMessage(make([]packet.NLRI, 0), pathAttrs, nlriList)
+		g.sendUpdateMsg(updateMsg, path, members)
+	}
+	g.clearExportPolicyResults()
+}
+
+func (server *BGPServer) sendEvpnRoutesToPeer(peer *Peer) {
+	for _, inst := range server.evpnInstances {
+		server.sendEvpnUpdates(inst, inst.getLocalRoutes(server.BgpConfig.Global.Config.RouterId), nil, peer)
+	}
+}
+
+func (server *BGPServer) markEvpnStaleRoutesFromNeighbor(peerIP string) {
+	for _, route := range server.evpnRoutes[peerIP] {
+		route.stale = true
+	}
+}
+
+func (server *BGPServer) removeEvpnRoutesFromNeighbor(peerIP string) {
+	for key, _ := range server.evpnRoutes[peerIP] {
+		server.removeEvpnRoute(peerIP, key)
+	}
+	delete(server.evpnRoutes, peerIP)
+}
+
+func (server *BGPServer) markEvpnRefreshStaleRoutesFromNeighbor(peerIP string) {
+	for _, route := range server.evpnRoutes[peerIP] {
+		route.refreshStale = true
+	}
+}
+
+func (server *BGPServer) removeEvpnRefreshStaleRoutesFromNeighbor(peerIP string) {
+	for key, route := range server.evpnRoutes[peerIP] {
+		if route.refreshStale {
+			server.removeEvpnRoute(peerIP, key)
+		}
+	}
+}
+
+func (server *BGPServer) removeEvpnStaleRoutesFromNeighbor(peerIP string) {
+	for key, route := range server.evpnRoutes[peerIP] {
+		if route.stale {
+			server.removeEvpnRoute(peerIP, key)
+		}
+	}
+}
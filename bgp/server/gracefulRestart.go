@@ -0,0 +1,273 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// gracefulRestart.go
+package server
+
+import (
+	"fmt"
+	"l3/bgp/config"
+	"l3/bgp/fsm"
+	"l3/bgp/packet"
+	bgprib "l3/bgp/rib"
+	"time"
+)
+
+func setGracefulRestartDefaults(gConf *config.GlobalConfig) {
+	if gConf.GracefulRestartTime == 0 || gConf.GracefulRestartTime > packet.BGPCapGracefulRestartMaxTime {
+		gConf.GracefulRestartTime = config.BGPGracefulRestartTimeDefault
+	}
+	if gConf.GracefulRestartStalePathTime == 0 {
+		gConf.GracefulRestartStalePathTime = config.BGPGracefulRestartStalePathTimeDefault
+	}
+	if gConf.GracefulRestartDeferralTime == 0 {
+		gConf.GracefulRestartDeferralTime = config.BGPGracefulRestartDeferralTimeDefault
+	}
+}
+
+/*  When bgpd comes up with graceful restart enabled it acts as the restarting speaker.
+ *  The routes installed before the restart are left in the RIB manager, the OPEN message
+ *  carries the Restart state and Forwarding state bits and the advertisement of routes
+ *  to the peers is deferred until all the peers have sent End-of-RIB or the selection
+ *  deferral timer expires.
+ */
+func (server *BGPServer) startGracefulRestart() {
+	if !server.BgpConfig.Global.Config.GracefulRestart {
+		return
+	}
+
+	server.logger.Info(fmt.Sprintln("Graceful restart - defer route advertisement for",
+		server.BgpConfig.Global.Config.GracefulRestartDeferralTime, "seconds"))
+	server.grRestarting = true
+	server.deferralTimer = time.AfterFunc(
+		time.Duration(server.BgpConfig.Global.Config.GracefulRestartDeferralTime)*time.Second,
+		func() {
+			server.deferralTimerCh <- true
+		})
+}
+
+func (server *BGPServer) isDeferralComplete() bool {
+	for peerIP, peer := range server.PeerMap {
+		if peer.NeighborConf.Neighbor.Transport.Config.LocalAddress == nil {
+			server.logger.Info(fmt.Sprintln("Graceful restart - waiting for neighbor", peerIP,
+				"to establish the session"))
+			return false
+		}
+
+		if peer.NeighborConf.IsGracefulRestartNegotiated() && !peer.eorReceived {
+			server.logger.Info(fmt.Sprintln("Graceful restart - waiting for End-of-RIB from neighbor",
+				peerIP))
+			return false
+		}
+	}
+	return true
+}
+
+func (server *BGPServer) completeGracefulRestart() {
+	if !server.grRestarting {
+		return
+	}
+
+	server.logger.Info(fmt.Sprintln("Graceful restart complete, advertise routes to all neighbors"))
+	server.grRestarting = false
+	if server.deferralTimer != nil {
+		server.deferralTimer.Stop()
+		server.deferralTimer = nil
+	}
+
+	for _, peer := range server.PeerMap {
+		peer.NeighborConf.Restarting = false
+	}
+	server.removeStaleInstalledRoutes()
+
+	updated := server.AdjRib.GetLocRib()
+	for _, group := range server.updateGroups {
+		group.updateAdvertiseCondition()
+		if group.rsRib != nil {
+			group.SendUpdate(group.rsRib.GetLocRib(), make([]*bgprib.Destination, 0), nil,
+				make([]*bgprib.Destination, 0))
+			group.updateDefaultRoutes()
+			continue
+		}
+		group.SendUpdate(updated, make([]*bgprib.Destination, 0), nil, make([]*bgprib.Destination, 0))
+		group.updateDefaultRoutes()
+	}
+	for _, v := range server.vrfs {
+		server.sendVrfUpdates(v, v.adjRib.GetLocRib(), nil, nil)
+	}
+	for _, inst := range server.evpnInstances {
+		server.sendEvpnUpdates(inst, inst.getLocalRoutes(server.BgpConfig.Global.Config.RouterId), nil, nil)
+	}
+
+	for _, peer := range server.PeerMap {
+		if peer.NeighborConf.Neighbor.Transport.Config.LocalAddress == nil {
+			continue
+		}
+
+		if peer.NeighborConf.IsGracefulRestartNegotiated() {
+			peer.SendEndOfRIB()
+		}
+	}
+}
+
+/*  The routes installed in the RIB manager before the restart that were not relearned
+ *  from the neighbors are removed when the selection deferral completes.
+ */
+func (server *BGPServer) removeStaleInstalledRoutes() {
+	for _, cfg := range server.routeMgr.GetInstalledRoutes() {
+		ipPrefix := packet.ConstructIPPrefix(cfg.DestinationNw, cfg.NetworkMask)
+		if server.AdjRib.HasRoute(ipPrefix.Prefix.String(), uint32(ipPrefix.Length), cfg.NextHopIp) {
+			continue
+		}
+
+		server.logger.Info(fmt.Sprintln("Graceful restart - remove route", cfg.DestinationNw, cfg.NetworkMask,
+			"next hop", cfg.NextHopIp, "that was not relearned"))
+		server.routeMgr.UpdateRoute(cfg, "remove")
+	}
+}
+
+/*  The paths of a neighbor are retained only when the session went down without a
+ *  NOTIFICATION, the TCP connection failed or the hold timer expired (RFC 4724). The
+ *  Hold Timer Expired NOTIFICATION sent on a hold timer expiry is not counted.
+ */
+func isGracefulRestartDisconnect(fsmConn *fsm.PeerFSMConn) bool {
+	if fsmConn.Deconfigured {
+		return false
+	}
+
+	if fsmConn.Notification == nil {
+		return true
+	}
+
+	return fsmConn.NotificationSent && len(fsmConn.Notification) > packet.BGPMsgHeaderLen &&
+		fsmConn.Notification[packet.BGPMsgHeaderLen] == packet.BGPHoldTimerExpired
+}
+
+/*  Helper speaker - The session with a neighbor that advertised the graceful restart
+ *  capability went down. Retain the paths from the neighbor as stale and wait for the
+ *  neighbor to come back before the restart time advertised by it expires.
+ */
+func (server *BGPServer) ProcessNeighborRestart(peerIP string, peer *Peer, fsmConn *fsm.PeerFSMConn) bool {
+	grCap := peer.NeighborConf.GracefulRestartCap
+	if !peer.NeighborConf.IsGracefulRestartNegotiated() || grCap.RestartTime == 0 {
+		return false
+	}
+
+	if !isGracefulRestartDisconnect(fsmConn) {
+		server.logger.Info(fmt.Sprintln("Graceful restart - Neighbor", peerIP, "session closed with",
+			"a NOTIFICATION, remove its paths"))
+		return false
+	}
+
+	server.logger.Info(fmt.Sprintln("Graceful restart - Neighbor", peerIP, "restarting,",
+		"retain its paths as stale for", grCap.RestartTime, "seconds"))
+	peer.stopRefreshStaleTimers()
+	server.AdjRib.MarkStaleUpdatesFromNeighbor(peerIP)
+	server.markVrfStaleRoutesFromNeighbor(peerIP)
+	server.markRouteServerStaleRoutesFromNeighbor(peerIP)
+	server.markEvpnStaleRoutesFromNeighbor(peerIP)
+	peer.stalePaths = true
+	peer.NeighborConf.Neighbor.State.StalePaths = true
+	peer.startStalePathsTimer(uint32(grCap.RestartTime))
+	return true
+}
+
+func (server *BGPServer) ProcessNeighborEstablished(peerIP string, peer *Peer) {
+	peer.eorReceived = false
+	peer.eorFamilies = make(map[uint32]bool)
+	if !peer.stalePaths {
+		return
+	}
+
+	grCap := peer.NeighborConf.GracefulRestartCap
+	if !peer.NeighborConf.IsGracefulRestartNegotiated() {
+		server.logger.Info(fmt.Sprintln("Graceful restart - Neighbor", peerIP,
+			"did not negotiate graceful restart, remove stale paths"))
+		server.ProcessRemoveStalePaths(peerIP, peer)
+		return
+	}
+
+	// The stale paths of the families without the forwarding state bit are removed
+	removed := make(map[uint32]bool)
+	for _, protoFamily := range packet.ProtocolFamilyMap {
+		afi, safi := packet.GetAfiSafi(protoFamily)
+		if removed[protoFamily] || (peer.NeighborConf.IsProtocolFamilyNegotiated(afi, safi) &&
+			grCap.IsForwardingPreserved(afi, safi)) {
+			continue
+		}
+
+		server.logger.Info(fmt.Sprintln("Graceful restart - Neighbor", peerIP, "did not preserve",
+			"the forwarding state for AFI", afi, "SAFI", safi, "remove its stale paths"))
+		server.removeStaleFamilyPaths(peerIP, peer, protoFamily)
+		removed[protoFamily] = true
+	}
+
+	server.logger.Info(fmt.Sprintln("Graceful restart - Neighbor", peerIP, "re-established,",
+		"wait for End-of-RIB for", server.BgpConfig.Global.Config.GracefulRestartStalePathTime, "seconds"))
+	peer.startStalePathsTimer(server.BgpConfig.Global.Config.GracefulRestartStalePathTime)
+}
+
+func (server *BGPServer) ProcessEndOfRIB(peerIP string, peer *Peer, protoFamily uint32) {
+	afi, safi := packet.GetAfiSafi(protoFamily)
+	server.logger.Info(fmt.Sprintln("Received End-of-RIB for AFI", afi, "SAFI", safi, "from neighbor", peerIP))
+	peer.eorFamilies[protoFamily] = true
+	for family, _ := range peer.NeighborConf.PeerAfiSafiMap {
+		if !peer.eorFamilies[family] {
+			return
+		}
+	}
+
+	peer.eorReceived = true
+	if peer.stalePaths {
+		server.ProcessRemoveStalePaths(peerIP, peer)
+	}
+
+	if server.grRestarting && server.isDeferralComplete() {
+		server.completeGracefulRestart()
+	}
+}
+
+func (server *BGPServer) ProcessRemoveStalePaths(peerIP string, peer *Peer) {
+	peer.stopStalePathsTimer()
+	peer.stalePaths = false
+	peer.NeighborConf.Neighbor.State.StalePaths = false
+	server.removeStaleFamilyPaths(peerIP, peer, 0)
+}
+
+// removeStaleFamilyPaths removes the stale paths of a protocol family, of all the families if it is 0
+func (server *BGPServer) removeStaleFamilyPaths(peerIP string, peer *Peer, protoFamily uint32) {
+	updated, withdrawn, withdrawPath, updatedAddPaths :=
+		server.AdjRib.RemoveStaleFamilyUpdatesFromNeighbor(peerIP, protoFamily,
+			peer.NeighborConf, server.AddPathCount)
+	server.removeVrfStaleRoutesFromNeighbor(peerIP, peer, protoFamily)
+	server.removeRouteServerStaleRoutesFromNeighbor(peerIP, peer, protoFamily)
+	if protoFamily == 0 || protoFamily == packet.GetProtocolFamily(packet.AfiL2VPN, packet.SafiEVPN) {
+		server.removeEvpnStaleRoutesFromNeighbor(peerIP)
+	}
+	server.logger.Info(fmt.Sprintln("removeStaleFamilyPaths - Neighbor", peerIP,
+		"send updated paths", updated, "withdrawn paths", withdrawn))
+	updated, withdrawn, withdrawPath, updatedAddPaths =
+		server.CheckForAggregation(updated, withdrawn, withdrawPath,
+			updatedAddPaths)
+	server.SendUpdate(updated, withdrawn, withdrawPath, updatedAddPaths)
+}
@@ -0,0 +1,178 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// gracefulShutdown.go
+package server
+
+import (
+	"fmt"
+	"l3/bgp/config"
+	"l3/bgp/fsm"
+	"l3/bgp/packet"
+	"time"
+)
+
+func setGracefulShutdownDefaults(gConf *config.GlobalConfig) {
+	if gConf.GracefulShutdownTime == 0 {
+		gConf.GracefulShutdownTime = config.BGPGracefulShutdownTimeDefault
+	}
+}
+
+/*  setGracefulShutdownAttrs adds the GRACEFUL_SHUTDOWN community (RFC 8326) to the path attrs and
+ *  lowers the LOCAL_PREF when setLocalPref is true. The path attrs are modified in place.
+ */
+func setGracefulShutdownAttrs(pathAttrs []packet.BGPPathAttr, setLocalPref bool) []packet.BGPPathAttr {
+	if setLocalPref {
+		pathAttrs = packet.SetLocalPrefInPathAttrs(pathAttrs, config.BGPGracefulShutdownLocalPref)
+	}
+	return packet.AddCommunities(pathAttrs, []uint32{packet.BGPCommunityGracefulShutdown})
+}
+
+// isReachUpdate returns true if the update advertises any routes
+func isReachUpdate(updateMsg *packet.BGPUpdate) bool {
+	return len(updateMsg.NLRI) > 0 || packet.GetMPReachNLRI(updateMsg.PathAttributes) != nil
+}
+
+func (p *Peer) startDrainTimer(seconds uint32) {
+	p.stopDrainTimer()
+	ipStr := p.NeighborConf.Neighbor.NeighborAddress.String()
+	p.drainTimer = time.AfterFunc(time.Duration(seconds)*time.Second, func() {
+		p.Server.drainTimerCh <- ipStr
+	})
+}
+
+func (p *Peer) stopDrainTimer() {
+	if p.drainTimer != nil {
+		p.drainTimer.Stop()
+		p.drainTimer = nil
+	}
+}
+
+/*  ProcessDrainCommand drains or undrains the neighbor in the command, or all the neighbors when
+ *  the command has no neighbor address.
+ */
+func (server *BGPServer) ProcessDrainCommand(cmd config.PeerCommand) {
+	peers := make([]*Peer, 0)
+	if cmd.IP == nil {
+		for _, peer := range server.PeerMap {
+			peers = append(peers, peer)
+		}
+	} else if peer, ok := server.PeerMap[cmd.IP.String()]; ok {
+		peers = append(peers, peer)
+	} else {
+		server.logger.Info(fmt.Sprintln("ProcessDrainCommand - Neighbor", cmd.IP, "does not exist"))
+		return
+	}
+
+	for _, peer := range peers {
+		if cmd.Command == config.PeerCommandDrain {
+			server.drainPeer(peer, cmd.Message)
+		} else {
+			server.undrainPeer(peer)
+		}
+	}
+}
+
+/*  drainPeer starts the graceful shutdown (RFC 8326) of the session with the neighbor. All the
+ *  routes are sent to the neighbor again with the GRACEFUL_SHUTDOWN community and the paths
+ *  received from the neighbor are processed again with the lowest LOCAL_PREF, so that the
+ *  traffic moves to the alternate paths. The session is brought down with the shutdown
+ *  communication (RFC 8203) when the graceful shutdown time expires.
+ */
+func (server *BGPServer) drainPeer(peer *Peer, msg string) {
+	peerIP := peer.NeighborConf.Neighbor.NeighborAddress.String()
+	peer.drainMessage = msg
+	if peer.NeighborConf.Neighbor.State.Draining {
+		server.logger.Info(fmt.Sprintln("Graceful shutdown - Neighbor", peerIP, "is already draining"))
+		return
+	}
+
+	peer.NeighborConf.Neighbor.State.Draining = true
+	if peer.NeighborConf.Neighbor.Transport.Config.LocalAddress == nil {
+		// There is no traffic to move away from the neighbor, keep the session down
+		server.logger.Info(fmt.Sprintln("Graceful shutdown - Neighbor", peerIP, "is not in Established",
+			"state, shut down the session"))
+		server.shutdownDrainedPeer(peer)
+		return
+	}
+
+	server.logger.Info(fmt.Sprintln("Graceful shutdown - Neighbor", peerIP, "start draining, shut down",
+		"the session in", server.BgpConfig.Global.Config.GracefulShutdownTime, "seconds"))
+	server.SoftResetOut(peer)
+	server.SoftResetIn(peer)
+	peer.startDrainTimer(server.BgpConfig.Global.Config.GracefulShutdownTime)
+}
+
+/*  undrainPeer stops the graceful shutdown of the session with the neighbor. The routes are
+ *  exchanged again without the GRACEFUL_SHUTDOWN community if the session is still up, the
+ *  session is started again if it was brought down by the drain.
+ */
+func (server *BGPServer) undrainPeer(peer *Peer) {
+	peerIP := peer.NeighborConf.Neighbor.NeighborAddress.String()
+	if !peer.NeighborConf.Neighbor.State.Draining {
+		server.logger.Info(fmt.Sprintln("Graceful shutdown - Neighbor", peerIP, "is not draining"))
+		return
+	}
+
+	server.logger.Info(fmt.Sprintln("Graceful shutdown - Neighbor", peerIP, "stop draining"))
+	peer.stopDrainTimer()
+	peer.NeighborConf.Neighbor.State.Draining = false
+	peer.drainMessage = ""
+	if peer.drainShutdown {
+		peer.drainShutdown = false
+		peer.Command(int(fsm.BGPEventManualStart), fsm.BGPCmdReasonNone)
+		return
+	}
+
+	server.SoftResetOut(peer)
+	server.SoftResetIn(peer)
+}
+
+// ProcessDrainTimerExpiry brings down the session with the neighbor at the end of the graceful shutdown
+func (server *BGPServer) ProcessDrainTimerExpiry(peerIP string) {
+	peer, ok := server.PeerMap[peerIP]
+	if !ok || peer.drainTimer == nil || !peer.NeighborConf.Neighbor.State.Draining {
+		return
+	}
+
+	server.logger.Info(fmt.Sprintln("Graceful shutdown - Neighbor", peerIP, "graceful shutdown time",
+		"expired, shut down the session"))
+	peer.drainTimer = nil
+	server.shutdownDrainedPeer(peer)
+}
+
+// shutdownDrainedPeer sends the Administrative Shutdown Cease NOTIFICATION with the shutdown communication
+func (server *BGPServer) shutdownDrainedPeer(peer *Peer) {
+	if peer.fsmManager == nil {
+		server.logger.Info(fmt.Sprintf("FSM Manager is not instantiated yet for neighbor %s\n",
+			peer.NeighborConf.Neighbor.NeighborAddress))
+		return
+	}
+
+	peer.drainShutdown = true
+	peer.fsmManager.CommandCh <- fsm.PeerFSMCommand{
+		Command:   int(fsm.BGPEventManualStop),
+		Reason:    fsm.BGPCmdReasonAdminShutdown,
+		CeaseData: packet.ConstructShutdownCommunicationData(peer.drainMessage),
+	}
+}
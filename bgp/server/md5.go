@@ -0,0 +1,139 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// md5.go
+package server
+
+import (
+	"fmt"
+	"l3/bgp/config"
+	"l3/bgp/utils"
+	"reflect"
+	"utils/netUtils"
+)
+
+/*  TCP MD5 signature (RFC 2385) - The keys of the configured neighbors are set per address
+ *  on the listener and the keys of the peer groups are set on the listener for the listen
+ *  ranges of the group, so that the dynamic neighbors are authenticated too. The kernel
+ *  drops the segments that are not signed with the key of the peer address.
+ */
+func (server *BGPServer) setNeighborMD5(peerIP string, peer *Peer) {
+	key := peer.NeighborConf.RunningConf.AuthPassword
+	if peer.NeighborConf.IsDynamic() || key == peer.md5Key {
+		return
+	}
+
+	err := netUtils.SetTCPListenerMD5(server.listener, peerIP, key)
+	if err != nil {
+		server.logger.Err(fmt.Sprintln("Failed to set MD5 authentication for neighbor", peerIP,
+			"with error", err))
+		return
+	}
+	peer.md5Key = key
+}
+
+func (server *BGPServer) clearNeighborMD5(peerIP string, peer *Peer) {
+	if peer.NeighborConf.IsDynamic() || peer.md5Key == "" {
+		return
+	}
+
+	err := netUtils.SetTCPListenerMD5(server.listener, peerIP, "")
+	if err != nil {
+		server.logger.Err(fmt.Sprintln("Failed to remove MD5 authentication for neighbor", peerIP,
+			"with error", err))
+	}
+	peer.md5Key = ""
+}
+
+func (server *BGPServer) isNeighborMD5Set(peer *Peer) bool {
+	key := peer.NeighborConf.RunningConf.AuthPassword
+	if key == "" {
+		return true
+	}
+
+	if peer.NeighborConf.IsDynamic() {
+		return server.peerGroupMD5[peer.NeighborConf.RunningConf.PeerGroup] == key
+	}
+	return peer.md5Key == key
+}
+
+func (server *BGPServer) setPeerGroupMD5(oldGroup *config.PeerGroupConfig, newGroup *config.PeerGroupConfig) {
+	if oldGroup != nil {
+		if _, ok := server.peerGroupMD5[oldGroup.Name]; ok {
+			for _, listenRange := range oldGroup.ListenRanges {
+				err := utils.SetTCPListenerMD5Prefix(server.listener, listenRange, "")
+				if err != nil {
+					server.logger.Err(fmt.Sprintln("Failed to remove MD5 authentication for peer group",
+						oldGroup.Name, "listen range", listenRange, "with error", err))
+				}
+			}
+			delete(server.peerGroupMD5, oldGroup.Name)
+		}
+	}
+
+	if newGroup == nil || newGroup.AuthPassword == "" || len(newGroup.ListenRanges) == 0 {
+		return
+	}
+
+	key := newGroup.AuthPassword
+	for _, listenRange := range newGroup.ListenRanges {
+		err := utils.SetTCPListenerMD5Prefix(server.listener, listenRange, newGroup.AuthPassword)
+		if err != nil {
+			server.logger.Err(fmt.Sprintln("Failed to set MD5 authentication for peer group", newGroup.Name,
+				"listen range", listenRange, "with error", err))
+			key = ""
+		}
+	}
+	server.peerGroupMD5[newGroup.Name] = key
+}
+
+func (server *BGPServer) isAuthPasswordChangeOnly(peer *Peer, nConf config.NeighborConfig) bool {
+	oldConf := peer.NeighborConf.Neighbor.Config
+	if peer.NeighborConf.RunningConf.AuthPassword == "" || nConf.AuthPassword == "" ||
+		oldConf.AuthPassword == nConf.AuthPassword {
+		return false
+	}
+
+	oldConf.AuthPassword = nConf.AuthPassword
+	return reflect.DeepEqual(oldConf, nConf)
+}
+
+/*  Change the MD5 key of a neighbor without resetting the session. The key is replaced on
+ *  the listener and on the socket of the established session. If the kernel doesn't allow
+ *  the key to be changed on the socket, the caller resets the session.
+ */
+func (server *BGPServer) changeNeighborAuthPassword(peerIP string, peer *Peer, nConf config.NeighborConfig) bool {
+	if peer.conn != nil {
+		err := utils.SetTCPConnMD5(*peer.conn, peerIP, nConf.AuthPassword)
+		if err != nil {
+			server.logger.Info(fmt.Sprintln("Failed to change MD5 key on the session with neighbor", peerIP,
+				"with error", err, "reset the session"))
+			return false
+		}
+	}
+
+	server.logger.Info(fmt.Sprintln("Change MD5 key for neighbor", peerIP))
+	peer.NeighborConf.UpdateAuthPassword(nConf)
+	server.setNeighborMD5(peerIP, peer)
+	return true
+}
@@ -30,28 +30,54 @@ import (
 	"l3/bgp/config"
 	"l3/bgp/fsm"
 	"l3/bgp/packet"
+	bgppolicy "l3/bgp/policy"
 	bgprib "l3/bgp/rib"
 	"net"
 	"sync/atomic"
+	"time"
 	"utils/logging"
 )
 
+// Number of add paths the rib selects to advertise all the paths of the destinations
+const maxAddPathsCount int = 255
+
 type Peer struct {
 	Server       *BGPServer
 	logger       *logging.Writer
 	NeighborConf *base.NeighborConf
 	fsmManager   *fsm.FSMManager
 	ifIdx        int32
-	ribOut       map[string]map[uint32]*bgprib.Path
+	adjRibIn     map[string]map[uint32]*adjRibInPath
+	prefixORF    map[uint32][]*packet.AddressPrefixORFEntry
+
+	stalePaths      bool
+	stalePathsTimer *time.Timer
+	eorReceived     bool
+	eorFamilies     map[uint32]bool
+
+	refreshStaleTimers map[uint32]*time.Timer
+
+	drainMessage  string
+	drainTimer    *time.Timer
+	drainShutdown bool
+
+	dynamicTimer *time.Timer
+	conn         *net.Conn
+	md5Key       string
+	sentOpen     []byte
+	rcvdOpen     []byte
+	updateGroup  *UpdateGroup
 }
 
 func NewPeer(server *BGPServer, globalConf *config.GlobalConfig, peerGroup *config.PeerGroupConfig,
 	peerConf config.NeighborConfig) *Peer {
 	peer := Peer{
-		Server: server,
-		logger: server.logger,
-		ifIdx:  -1,
-		ribOut: make(map[string]map[uint32]*bgprib.Path),
+		Server:             server,
+		logger:             server.logger,
+		ifIdx:              -1,
+		eorFamilies:        make(map[uint32]bool),
+		refreshStaleTimers: make(map[uint32]*time.Timer),
+		prefixORF:          make(map[uint32][]*packet.AddressPrefixORFEntry),
 	}
 
 	peer.NeighborConf = base.NewNeighborConf(peer.logger, globalConf, peerGroup, peerConf)
@@ -62,10 +88,16 @@ func NewPeer(server *BGPServer, globalConf *config.GlobalConfig, peerGroup *conf
 
 func (p *Peer) UpdatePeerGroup(peerGroup *config.PeerGroupConfig) {
 	p.NeighborConf.UpdatePeerGroup(peerGroup)
+	if !p.NeighborConf.RunningConf.SoftReconfigInbound {
+		p.clearAdjRibIn()
+	}
 }
 
 func (p *Peer) UpdateNeighborConf(nConf config.NeighborConfig, bgp *config.Bgp) {
 	p.NeighborConf.UpdateNeighborConf(nConf, bgp)
+	if !p.NeighborConf.RunningConf.SoftReconfigInbound {
+		p.clearAdjRibIn()
+	}
 }
 
 func (p *Peer) IsBfdStateUp() bool {
@@ -102,10 +134,54 @@ func (p *Peer) StopFSM(msg string) {
 }
 
 func (p *Peer) MaxPrefixesExceeded() {
-	if p.NeighborConf.RunningConf.MaxPrefixesDisconnect {
-		p.Command(int(fsm.BGPEventAutoStop), fsm.BGPCmdReasonMaxPrefixExceeded)
+	protoFamily, maxPrefixes, restartTime, disconnect := p.NeighborConf.GetExceededPrefixLimit()
+	if !disconnect {
+		return
+	}
+
+	if p.fsmManager == nil {
+		p.logger.Info(fmt.Sprintf("FSM Manager is not instantiated yet for neighbor %s\n",
+			p.NeighborConf.Neighbor.NeighborAddress))
+		return
+	}
+	afi, safi := packet.GetAfiSafi(protoFamily)
+	p.fsmManager.CommandCh <- fsm.PeerFSMCommand{
+		Command:     int(fsm.BGPEventAutoStop),
+		Reason:      fsm.BGPCmdReasonMaxPrefixExceeded,
+		CeaseData:   packet.ConstructMaxPrefixesCeaseData(afi, safi, maxPrefixes),
+		RestartTime: restartTime,
 	}
 }
+func (p *Peer) startStalePathsTimer(seconds uint32) {
+	p.stopStalePathsTimer()
+	ipStr := p.NeighborConf.Neighbor.NeighborAddress.String()
+	p.stalePathsTimer = time.AfterFunc(time.Duration(seconds)*time.Second, func() {
+		p.Server.stalePathsTimerCh <- ipStr
+	})
+}
+
+func (p *Peer) stopStalePathsTimer() {
+	if p.stalePathsTimer != nil {
+		p.stalePathsTimer.Stop()
+		p.stalePathsTimer = nil
+	}
+}
+
+func (p *Peer) startDynamicTimer(seconds uint32) {
+	p.stopDynamicTimer()
+	ipStr := p.NeighborConf.Neighbor.NeighborAddress.String()
+	p.dynamicTimer = time.AfterFunc(time.Duration(seconds)*time.Second, func() {
+		p.Server.dynamicTimerCh <- ipStr
+	})
+}
+
+func (p *Peer) stopDynamicTimer() {
+	if p.dynamicTimer != nil {
+		p.dynamicTimer.Stop()
+		p.dynamicTimer = nil
+	}
+}
+
 func (p *Peer) setIfIdx(ifIdx int32) {
 	p.ifIdx = ifIdx
 }
@@ -130,14 +206,89 @@ func (p *Peer) Command(command int, reason int) {
 			p.NeighborConf.Neighbor.NeighborAddress))
 		return
 	}
-	p.fsmManager.CommandCh <- fsm.PeerFSMCommand{command, reason}
+	p.fsmManager.CommandCh <- fsm.PeerFSMCommand{Command: command, Reason: reason}
 }
 
 func (p *Peer) getAddPathsMaxTx() int {
 	return int(p.NeighborConf.Neighbor.State.AddPathsMaxTx)
 }
 
-func (p *Peer) updatePathAttrs(bgpMsg *packet.BGPMessage, path *bgprib.Path) bool {
+// Returns the add paths transmit modes of the families negotiated to send add paths
+func (p *Peer) getAddPathsTxModes() map[uint32]string {
+	txModes := make(map[uint32]string)
+	for protoFamily, _ := range p.NeighborConf.AddPathsTxFamilies {
+		txModes[protoFamily] = p.NeighborConf.GetAddPathsTxMode(protoFamily)
+	}
+	return txModes
+}
+
+/*  Returns the number of add paths the rib selects for the peer. The all, best-external and
+ *  diverse-path modes select the paths from all the paths of a destination.
+ */
+func (p *Peer) getAddPathsCount() int {
+	addPathsMaxTx := p.getAddPathsMaxTx()
+	if addPathsMaxTx == 0 {
+		return 0
+	}
+
+	for _, txMode := range p.getAddPathsTxModes() {
+		switch txMode {
+		case config.AddPathsTxModeAll, config.AddPathsTxModeBestExternal, config.AddPathsTxModeDiversePath:
+			return maxAddPathsCount
+		}
+	}
+	return addPathsMaxTx
+}
+
+func (p *Peer) getExportPolicyParams(pathAttrs []packet.BGPPathAttr, path *bgprib.Path) *bgppolicy.RoutePolicyParams {
+	return &bgppolicy.RoutePolicyParams{
+		Neighbor:     p.NeighborConf.Neighbor.NeighborAddress,
+		PathAttrs:    pathAttrs,
+		LocalAddress: p.NeighborConf.Neighbor.Transport.Config.LocalAddress,
+		IGPCost:      path.GetIGPCost(),
+	}
+}
+
+// evaluateExportPolicy returns the result of the export policy for the path, nil when the peer has no export policy
+func (p *Peer) evaluateExportPolicy(path *bgprib.Path) *bgppolicy.RoutePolicyResult {
+	policyName := p.NeighborConf.RunningConf.ExportPolicy
+	if policyName == "" || path == nil {
+		return nil
+	}
+
+	params := p.getExportPolicyParams(path.PathAttrs, path)
+	return p.Server.bgpPE.RoutePolicyDB.EvaluatePolicy(policyName, params)
+}
+
+/*  The IPv6 routes use the local address of the session as the next hop, an IPv4 local
+ *  address is sent as an IPv4-mapped IPv6 address. The IPv4 routes sent over an IPv6
+ *  session use the router id as the next hop, unless they are sent in the MP_REACH_NLRI
+ *  attr with the extended next hop encoding.
+ */
+func (p *Peer) getLocalNextHop(bgpMsg *packet.BGPMessage) net.IP {
+	localAddress := p.NeighborConf.Neighbor.Transport.Config.LocalAddress
+	if packet.GetMPReachNLRI(bgpMsg.Body.(*packet.BGPUpdate).PathAttributes) != nil {
+		return localAddress.To16()
+	}
+
+	if localAddress.To4() == nil {
+		return p.NeighborConf.Global.RouterId.To4()
+	}
+	return localAddress.To4()
+}
+
+/*  IPv4 routes are sent with an IPv6 next hop in the MP_REACH_NLRI attr (RFC 5549) when
+ *  the session is over IPv6 and the peer supports the extended next hop encoding.
+ */
+func (p *Peer) isExtendedNextHopUsed() bool {
+	return p.NeighborConf.ExtendedNextHopCap && p.NeighborConf.Neighbor.Transport.Config.LocalAddress.To4() == nil
+}
+
+/*  Updates the path attrs of the update sent to the peer. The actions of the export policy
+ *  result, evaluated once for the path by the update group, are applied last.
+ */
+func (p *Peer) updatePathAttrs(bgpMsg *packet.BGPMessage, path *bgprib.Path,
+	exportResult *bgppolicy.RoutePolicyResult) bool {
 	if p.NeighborConf.Neighbor.Transport.Config.LocalAddress == nil {
 		p.logger.Err(fmt.Sprintf("Neighbor %s: Can't send Update message, FSM is not",
 			"in Established state\n", p.NeighborConf.Neighbor.NeighborAddress))
@@ -164,29 +315,68 @@ func (p *Peer) updatePathAttrs(bgpMsg *packet.BGPMessage, path *bgprib.Path) boo
 			packet.AddOriginatorId(bgpMsg, path.NeighborConf.BGPId)
 			packet.AddClusterId(bgpMsg, path.NeighborConf.RunningConf.RouteReflectorClusterId)
 		} else {
-			packet.SetNextHop(bgpMsg, p.NeighborConf.Neighbor.Transport.Config.LocalAddress)
+			packet.SetNextHop(bgpMsg, p.getLocalNextHop(bgpMsg))
 			packet.SetLocalPref(bgpMsg, path.GetPreference())
 		}
+	} else if p.NeighborConf.IsRouteServerClient() {
+		// The route server doesn't prepend its AS and keeps the NEXT_HOP and MED (RFC 7947)
+		packet.RemoveConfedSegments(bgpMsg)
+		packet.RemoveLocalPref(bgpMsg)
+	} else if p.NeighborConf.IsConfedExternal() {
+		// MED and LOCAL_PREF are preserved within the confederation
+		packet.PrependConfedAS(bgpMsg, p.NeighborConf.RunningConf.LocalAS, p.NeighborConf.ASSize)
+		packet.SetNextHop(bgpMsg, p.getLocalNextHop(bgpMsg))
+		packet.SetLocalPref(bgpMsg, path.GetPreference())
 	} else {
 		// Do change these path attrs for local routes
 		if path.NeighborConf != nil {
 			packet.RemoveMultiExitDisc(bgpMsg)
 		}
-		packet.PrependAS(bgpMsg, p.NeighborConf.RunningConf.LocalAS, p.NeighborConf.ASSize)
-		packet.SetNextHop(bgpMsg, p.NeighborConf.Neighbor.Transport.Config.LocalAddress)
+		packet.RemoveConfedSegments(bgpMsg)
+		p.updateASPath(bgpMsg)
+		packet.SetNextHop(bgpMsg, p.getLocalNextHop(bgpMsg))
 		packet.RemoveLocalPref(bgpMsg)
 	}
 
+	updateMsg := bgpMsg.Body.(*packet.BGPUpdate)
+	if p.NeighborConf.IsExternal() {
+		updateMsg.PathAttributes = packet.RemoveNonTransitiveExtCommunities(updateMsg.PathAttributes)
+	}
+	if exportResult != nil {
+		params := p.getExportPolicyParams(updateMsg.PathAttributes, path)
+		exportResult.ApplyActions(params)
+		updateMsg.PathAttributes = params.PathAttrs
+	}
+	if p.NeighborConf.Neighbor.State.Draining {
+		updateMsg.PathAttributes = setGracefulShutdownAttrs(updateMsg.PathAttributes,
+			!p.NeighborConf.IsExternal())
+	}
 	return true
 }
 
-func (p *Peer) clearRibOut() {
-	for ip, pathIdMap := range p.ribOut {
-		for pathId, _ := range pathIdMap {
-			delete(p.ribOut[ip], pathId)
-		}
-		delete(p.ribOut, ip)
+/*  updateASPath removes or replaces the private ASes (remove-private-AS), replaces the AS of the
+ *  peer with the local AS (as-override) and prepends the local AS to the AS path of the update
+ *  sent to an external peer. With local-as the global AS is prepended before the local AS
+ *  unless replace-as is set.
+ */
+func (p *Peer) updateASPath(bgpMsg *packet.BGPMessage) {
+	conf := &p.NeighborConf.RunningConf
+	advertisedAS := p.NeighborConf.GetAdvertisedAS()
+	switch conf.RemovePrivateAS {
+	case config.RemovePrivateASAll:
+		packet.RemovePrivateAS(bgpMsg, 0)
+	case config.RemovePrivateASReplace:
+		packet.RemovePrivateAS(bgpMsg, advertisedAS)
+	}
+
+	if conf.ASOverride {
+		packet.ReplaceAS(bgpMsg, conf.PeerAS, advertisedAS)
 	}
+
+	if p.NeighborConf.IsLocalASPrependedOut() {
+		packet.PrependAS(bgpMsg, p.NeighborConf.GetGlobalAS(), p.NeighborConf.ASSize)
+	}
+	packet.PrependAS(bgpMsg, advertisedAS, p.NeighborConf.ASSize)
 }
 
 func (p *Peer) ProcessBfd(add bool) {
@@ -228,9 +418,11 @@ func (p *Peer) PeerConnEstablished(conn *net.Conn) {
 			p.NeighborConf.Neighbor.NeighborAddress, (*conn).LocalAddr()))
 		return
 	}
+	host, _ = splitHostZone(host)
 	p.NeighborConf.Neighbor.Transport.Config.LocalAddress = net.ParseIP(host)
+	p.conn = conn
 	p.NeighborConf.PeerConnEstablished()
-	p.clearRibOut()
+	p.initAdjRibIn()
 	//p.Server.PeerConnEstCh <- p.Neighbor.NeighborAddress.String()
 }
 
@@ -239,216 +431,39 @@ func (p *Peer) PeerConnBroken(fsmCleanup bool) {
 		p.NeighborConf.Neighbor.Transport.Config.LocalAddress = nil
 		//p.Server.PeerConnBrokenCh <- p.Neighbor.NeighborAddress.String()
 	}
+	p.conn = nil
+	p.sentOpen = nil
+	p.rcvdOpen = nil
 	p.NeighborConf.PeerConnBroken()
-	p.clearRibOut()
-}
-
-func (p *Peer) sendUpdateMsg(msg *packet.BGPMessage, path *bgprib.Path) {
-	if path != nil && path.NeighborConf != nil {
-		if path.NeighborConf.IsInternal() {
-
-			if p.NeighborConf.IsInternal() && !path.NeighborConf.IsRouteReflectorClient() &&
-				!p.NeighborConf.IsRouteReflectorClient() {
-				return
-			}
-		}
-
-		// Don't send the update to the peer that sent the update.
-		if p.NeighborConf.RunningConf.NeighborAddress.String() ==
-			path.NeighborConf.RunningConf.NeighborAddress.String() {
-			return
-		}
-	}
-
-	if p.updatePathAttrs(msg, path) {
-		atomic.AddUint32(&p.NeighborConf.Neighbor.State.Queues.Output, 1)
-		p.fsmManager.SendUpdateMsg(msg)
-	}
-
-}
-
-func (p *Peer) isAdvertisable(path *bgprib.Path) bool {
-	if path != nil && path.NeighborConf != nil {
-		if path.NeighborConf.IsInternal() {
-
-			if p.NeighborConf.IsInternal() && !path.NeighborConf.IsRouteReflectorClient() &&
-				!p.NeighborConf.IsRouteReflectorClient() {
-				return false
-			}
-		}
-
-		// Don't send the update to the peer that sent the update.
-		if p.NeighborConf.RunningConf.NeighborAddress.String() ==
-			path.NeighborConf.RunningConf.NeighborAddress.String() {
-			return false
-		}
-	}
-
-	return true
-}
-
-func (p *Peer) calculateAddPathsAdvertisements(dest *bgprib.Destination, path *bgprib.Path, newUpdated map[*bgprib.Path][]packet.NLRI,
-	withdrawList []packet.NLRI, addPathsTx int) (map[*bgprib.Path][]packet.NLRI, []packet.NLRI) {
-	pathIdMap := make(map[uint32]*bgprib.Path)
-	ip := dest.IPPrefix.Prefix.String()
-
-	if _, ok := p.ribOut[ip]; !ok {
-		p.logger.Info(fmt.Sprintf("Neighbor %s: calculateAddPathsAdvertisements -",
-			"processing updates, dest %s not",
-			"found in rib out", p.NeighborConf.Neighbor.NeighborAddress, ip))
-		p.ribOut[ip] = make(map[uint32]*bgprib.Path)
-	}
-
-	if p.isAdvertisable(path) {
-		route := dest.LocRibPathRoute
-		if path != nil { // Loc-RIB path changed
-			if _, ok := newUpdated[path]; !ok {
-				newUpdated[path] = make([]packet.NLRI, 0)
-			}
-			nlri := packet.NewExtNLRI(route.OutPathId, *dest.IPPrefix)
-			newUpdated[path] = append(newUpdated[path], nlri)
-		} else {
-			path = dest.LocRibPath
-		}
-		pathIdMap[route.OutPathId] = path
-	}
-
-	for i := 0; i < len(dest.AddPaths) && len(pathIdMap) < (addPathsTx-1); i++ {
-		route := dest.GetPathRoute(dest.AddPaths[i])
-		if route != nil && p.isAdvertisable(dest.AddPaths[i]) {
-			pathIdMap[route.OutPathId] = dest.AddPaths[i]
-		}
-	}
-
-	ribPathMap, _ := p.ribOut[ip]
-	for ribPathId, ribPath := range ribPathMap {
-		if path, ok := pathIdMap[ribPathId]; !ok {
-			nlri := packet.NewExtNLRI(ribPathId, *dest.IPPrefix)
-			withdrawList = append(withdrawList, nlri)
-			delete(p.ribOut[ip], ribPathId)
-		} else if ribPath == path {
-			delete(pathIdMap, ribPathId)
-		} else if ribPath != path {
-			if _, ok := newUpdated[path]; !ok {
-				newUpdated[path] = make([]packet.NLRI, 0)
-			}
-			nlri := packet.NewExtNLRI(ribPathId, *dest.IPPrefix)
-			newUpdated[path] = append(newUpdated[path], nlri)
-			p.ribOut[ip][ribPathId] = path
-			delete(pathIdMap, ribPathId)
-		}
-	}
-
-	for pathId, path := range pathIdMap {
-		if _, ok := newUpdated[path]; !ok {
-			newUpdated[path] = make([]packet.NLRI, 0)
-		}
-		nlri := packet.NewExtNLRI(pathId, *dest.IPPrefix)
-		newUpdated[path] = append(newUpdated[path], nlri)
-		p.ribOut[ip][pathId] = path
-		delete(pathIdMap, pathId)
-	}
-
-	return newUpdated, withdrawList
+	p.clearAdjRibIn()
+	p.prefixORF = make(map[uint32][]*packet.AddressPrefixORFEntry)
 }
 
-func (p *Peer) SendUpdate(updated map[*bgprib.Path][]*bgprib.Destination,
-	withdrawn []*bgprib.Destination, withdrawPath *bgprib.Path,
-	updatedAddPaths []*bgprib.Destination) {
-	p.logger.Info(fmt.Sprintf("Neighbor %s: Send update message valid routes:%v, withdraw routes:%v",
-		p.NeighborConf.Neighbor.NeighborAddress, updated, withdrawn))
+func (p *Peer) SendEndOfRIB() {
 	if p.NeighborConf.Neighbor.Transport.Config.LocalAddress == nil {
-		p.logger.Err(fmt.Sprintf("Neighbor %s: Can't send Update message,",
-			"FSM is not in Established state",
+		p.logger.Err(fmt.Sprintf("Neighbor %s: Can't send End-of-RIB, FSM is not in Established state",
 			p.NeighborConf.Neighbor.NeighborAddress))
 		return
 	}
 
-	addPathsTx := p.getAddPathsMaxTx()
-	withdrawList := make([]packet.NLRI, 0)
-	newUpdated := make(map[*bgprib.Path][]packet.NLRI)
-	if len(withdrawn) > 0 {
-		for _, dest := range withdrawn {
-			if dest != nil {
-				ip := dest.IPPrefix.Prefix.String()
-				if addPathsTx > 0 {
-					pathIdMap, ok := p.ribOut[ip]
-					if !ok {
-						p.logger.Err(fmt.Sprintf("Neighbor %s: SendUpdate -",
-							"processing withdraws, dest %s not found in rib out",
-							p.NeighborConf.Neighbor.NeighborAddress, ip))
-						continue
-					}
-					for pathId, _ := range pathIdMap {
-						nlri := packet.NewExtNLRI(pathId, *dest.IPPrefix)
-						withdrawList = append(withdrawList, nlri)
-					}
-					delete(p.ribOut, ip)
-				} else {
-					withdrawList = append(withdrawList, dest.IPPrefix)
-					delete(p.ribOut, ip)
-				}
-			}
-		}
-	}
-
-	for path, destinations := range updated {
-		for _, dest := range destinations {
-			if dest != nil {
-				ip := dest.IPPrefix.Prefix.String()
-				if addPathsTx > 0 {
-					newUpdated, withdrawList =
-						p.calculateAddPathsAdvertisements(dest, path,
-							newUpdated, withdrawList, addPathsTx)
-				} else {
-					if !p.isAdvertisable(path) {
-						withdrawList = append(withdrawList, dest.IPPrefix)
-						delete(p.ribOut, ip)
-					} else {
-						route := dest.LocRibPathRoute
-						pathId := route.OutPathId
-						if _, ok := p.ribOut[ip]; !ok {
-							p.ribOut[ip] = make(map[uint32]*bgprib.Path)
-						}
-						for ribPathId, _ := range p.ribOut[ip] {
-							if pathId != ribPathId {
-								delete(p.ribOut[ip], ribPathId)
-							}
-						}
-						if ribPath, ok := p.ribOut[ip][pathId]; !ok ||
-							ribPath != path {
-							if _, ok := newUpdated[path]; !ok {
-								newUpdated[path] = make([]packet.NLRI, 0)
-							}
-							newUpdated[path] =
-								append(newUpdated[path], dest.IPPrefix)
-						}
-						p.ribOut[ip][pathId] = path
-					}
-				}
-			}
-		}
-	}
-
-	if addPathsTx > 0 {
-		for _, dest := range updatedAddPaths {
-			newUpdated, withdrawList = p.calculateAddPathsAdvertisements(dest, nil,
-				newUpdated, withdrawList, addPathsTx)
+	for protoFamily, _ := range p.NeighborConf.PeerAfiSafiMap {
+		afi, safi := packet.GetAfiSafi(protoFamily)
+		p.logger.Info(fmt.Sprintf("Neighbor %s: Send End-of-RIB marker for AFI %d SAFI %d",
+			p.NeighborConf.Neighbor.NeighborAddress, afi, safi))
+		atomic.AddUint32(&p.NeighborConf.Neighbor.State.Queues.Output, 1)
+		if afi == packet.AfiIP && safi == packet.SafiUnicast {
+			p.fsmManager.SendUpdateMsg(packet.NewBGPEndOfRIBMessage())
+		} else {
+			p.fsmManager.SendUpdateMsg(packet.NewBGPMPEndOfRIBMessage(afi, safi))
 		}
 	}
+}
 
-	if len(withdrawList) > 0 {
-		p.logger.Info(fmt.Sprintf("Neighbor %s: Send update message withdraw routes:%+v",
-			p.NeighborConf.Neighbor.NeighborAddress, withdrawList))
-		updateMsg := packet.NewBGPUpdateMessage(withdrawList, nil, nil)
-		p.sendUpdateMsg(updateMsg.Clone(), withdrawPath)
-		withdrawList = withdrawList[:0]
-	}
-
-	for path, nlriList := range newUpdated {
-		p.logger.Info(fmt.Sprintf("Neighbor %s: Send update message valid routes:%+v",
-			p.NeighborConf.Neighbor.NeighborAddress, nlriList))
-		updateMsg := packet.NewBGPUpdateMessage(make([]packet.NLRI, 0), path.PathAttrs, nlriList)
-		p.sendUpdateMsg(updateMsg.Clone(), path)
+func splitNLRIByProtocolFamily(nlriList []packet.NLRI) map[uint32][]packet.NLRI {
+	familyNLRI := make(map[uint32][]packet.NLRI)
+	for _, nlri := range nlriList {
+		protoFamily := packet.GetNLRIProtocolFamily(nlri)
+		familyNLRI[protoFamily] = append(familyNLRI[protoFamily], nlri)
 	}
+	return familyNLRI
 }
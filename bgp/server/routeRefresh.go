@@ -0,0 +1,370 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// routeRefresh.go
+package server
+
+import (
+	"fmt"
+	"l3/bgp/packet"
+	bgprib "l3/bgp/rib"
+	"time"
+)
+
+// refreshStaleTimer is posted when the neighbor did not send the EoRR of a family in time
+type refreshStaleTimer struct {
+	peerIP      string
+	protoFamily uint32
+}
+
+type adjRibInAttrs struct {
+	pathAttrs []packet.BGPPathAttr
+}
+
+type adjRibInPath struct {
+	nlri  packet.NLRI
+	attrs *adjRibInAttrs
+}
+
+func getAdjRibInKey(nlri packet.NLRI) string {
+	return fmt.Sprintf("%s/%d", packet.GetNLRIKey(nlri), nlri.GetPrefix().Length)
+}
+
+/*  The Adj-RIB-In holds the paths received from the neighbor before the import policy
+ *  is applied. It is created when the session is established with soft reconfiguration
+ *  inbound enabled, enabling it on an established session takes effect after the session
+ *  is reset. Until then soft reset inbound falls back to sending a ROUTE-REFRESH message.
+ */
+func (p *Peer) initAdjRibIn() {
+	if p.NeighborConf.RunningConf.SoftReconfigInbound {
+		p.adjRibIn = make(map[string]map[uint32]*adjRibInPath)
+	} else {
+		p.adjRibIn = nil
+	}
+}
+
+func (p *Peer) clearAdjRibIn() {
+	p.adjRibIn = nil
+}
+
+func (p *Peer) updateAdjRibIn(updateMsg *packet.BGPUpdate) {
+	if p.adjRibIn == nil {
+		return
+	}
+
+	for _, nlri := range updateMsg.WithdrawnRoutes {
+		key := getAdjRibInKey(nlri)
+		if pathIdMap, ok := p.adjRibIn[key]; ok {
+			delete(pathIdMap, nlri.GetPathId())
+			if len(pathIdMap) == 0 {
+				delete(p.adjRibIn, key)
+			}
+		}
+	}
+
+	if len(updateMsg.NLRI) == 0 {
+		return
+	}
+
+	attrs := &adjRibInAttrs{make([]packet.BGPPathAttr, 0, len(updateMsg.PathAttributes))}
+	for _, pa := range updateMsg.PathAttributes {
+		attrs.pathAttrs = append(attrs.pathAttrs, pa.Clone())
+	}
+	for _, nlri := range updateMsg.NLRI {
+		key := getAdjRibInKey(nlri)
+		if _, ok := p.adjRibIn[key]; !ok {
+			p.adjRibIn[key] = make(map[uint32]*adjRibInPath)
+		}
+		p.adjRibIn[key][nlri.GetPathId()] = &adjRibInPath{nlri.Clone(), attrs}
+	}
+}
+
+func (p *Peer) getAdjRibInUpdates() []*packet.BGPMessage {
+	attrsMap := make(map[*adjRibInAttrs][]packet.NLRI)
+	for _, pathIdMap := range p.adjRibIn {
+		for _, path := range pathIdMap {
+			attrsMap[path.attrs] = append(attrsMap[path.attrs], path.nlri.Clone())
+		}
+	}
+
+	updateMsgs := make([]*packet.BGPMessage, 0, len(attrsMap))
+	for attrs, nlriList := range attrsMap {
+		pathAttrs := make([]packet.BGPPathAttr, 0, len(attrs.pathAttrs))
+		for _, pa := range attrs.pathAttrs {
+			pathAttrs = append(pathAttrs, pa.Clone())
+		}
+		updateMsgs = append(updateMsgs, packet.NewBGPUpdateMessage(make([]packet.NLRI, 0), pathAttrs, nlriList))
+	}
+	return updateMsgs
+}
+
+func (p *Peer) startRefreshStaleTimer(protoFamily uint32, seconds uint32) {
+	p.stopRefreshStaleTimer(protoFamily)
+	ipStr := p.NeighborConf.Neighbor.NeighborAddress.String()
+	p.refreshStaleTimers[protoFamily] = time.AfterFunc(time.Duration(seconds)*time.Second, func() {
+		p.Server.refreshStaleCh <- refreshStaleTimer{ipStr, protoFamily}
+	})
+}
+
+func (p *Peer) stopRefreshStaleTimer(protoFamily uint32) {
+	if timer, ok := p.refreshStaleTimers[protoFamily]; ok {
+		timer.Stop()
+		delete(p.refreshStaleTimers, protoFamily)
+	}
+}
+
+func (p *Peer) stopRefreshStaleTimers() {
+	for protoFamily, _ := range p.refreshStaleTimers {
+		p.stopRefreshStaleTimer(protoFamily)
+	}
+}
+
+func (p *Peer) SendRouteRefresh(afi packet.AFI, safi packet.SAFI, subType uint8) {
+	if p.NeighborConf.Neighbor.Transport.Config.LocalAddress == nil {
+		p.logger.Err(fmt.Sprintf("Neighbor %s: Can't send Route Refresh, FSM is not in Established state",
+			p.NeighborConf.Neighbor.NeighborAddress))
+		return
+	}
+
+	p.fsmManager.SendRouteRefreshMsg(afi, safi, subType)
+}
+
+func (p *Peer) SendRouteRefreshORF(afi packet.AFI, safi packet.SAFI, whenToRefresh uint8,
+	prefixORF []*packet.AddressPrefixORFEntry) {
+	if p.NeighborConf.Neighbor.Transport.Config.LocalAddress == nil {
+		p.logger.Err(fmt.Sprintf("Neighbor %s: Can't send Route Refresh with prefix ORF, FSM is not in "+
+			"Established state", p.NeighborConf.Neighbor.NeighborAddress))
+		return
+	}
+
+	p.fsmManager.SendRouteRefreshORFMsg(afi, safi, whenToRefresh, prefixORF)
+}
+
+// Applies the prefix ORF entries received in a ROUTE-REFRESH message to the prefix ORF of the family
+func (p *Peer) updatePrefixORF(protoFamily uint32, entries []*packet.AddressPrefixORFEntry) {
+	prefixORF := packet.UpdateAddressPrefixORF(p.prefixORF[protoFamily], entries)
+	if len(prefixORF) == 0 {
+		delete(p.prefixORF, protoFamily)
+		return
+	}
+	p.prefixORF[protoFamily] = prefixORF
+}
+
+/*  Sends the prefix set conditions of the import policy of the neighbor as address prefix
+ *  ORF for the families the neighbor can receive the ORF for. The ORF entries sent before
+ *  are removed first when removeAll is set. The neighbor sends its routes again after
+ *  applying the ORF. Returns the families the ORF is sent for.
+ */
+func (server *BGPServer) sendPrefixORF(peer *Peer, removeAll bool) map[uint32]bool {
+	families := make(map[uint32]bool)
+	policyName := peer.NeighborConf.RunningConf.ImportPolicy
+	for protoFamily, _ := range peer.NeighborConf.PrefixORFTxFamilies {
+		afi, safi := packet.GetAfiSafi(protoFamily)
+		entries := make([]*packet.AddressPrefixORFEntry, 0)
+		if removeAll {
+			entries = append(entries, packet.NewAddressPrefixORFEntry(packet.BGPORFActionRemoveAll,
+				packet.BGPORFMatchPermit, 0, nil, 0, 0, 0))
+		}
+		if policyName != "" {
+			entries = append(entries, server.bgpPE.RoutePolicyDB.GetPrefixORF(policyName, afi)...)
+		}
+		if len(entries) == 0 {
+			continue
+		}
+
+		server.logger.Info(fmt.Sprintln("Neighbor", peer.NeighborConf.Neighbor.NeighborAddress, "send prefix ORF",
+			entries, "for AFI", afi, "SAFI", safi))
+		peer.SendRouteRefreshORF(afi, safi, packet.BGPORFWhenImmediate, entries)
+		families[protoFamily] = true
+	}
+	return families
+}
+
+func (server *BGPServer) ProcessRouteRefresh(pktInfo *packet.BGPPktSrc) {
+	peer, ok := server.PeerMap[pktInfo.Src]
+	if !ok {
+		server.logger.Err(fmt.Sprintln("BgpServer:ProcessRouteRefresh - Peer not found,",
+			"address:", pktInfo.Src))
+		return
+	}
+
+	routeRefresh := pktInfo.Msg.Body.(*packet.BGPRouteRefresh)
+	protoFamily := packet.GetProtocolFamily(routeRefresh.AFI, routeRefresh.SAFI)
+	if !peer.NeighborConf.PeerAfiSafiMap[protoFamily] {
+		server.logger.Info(fmt.Sprintln("Neighbor", pktInfo.Src, "sent route refresh for AFI",
+			routeRefresh.AFI, "SAFI", routeRefresh.SAFI, "that is not negotiated, ignore it"))
+		return
+	}
+
+	switch routeRefresh.SubType {
+	case packet.BGPRouteRefreshNormal:
+		if routeRefresh.WhenToRefresh != 0 {
+			if !peer.NeighborConf.IsPrefixORFReceiveNegotiated(protoFamily) {
+				server.logger.Info(fmt.Sprintln("Neighbor", pktInfo.Src, "sent prefix ORF for AFI",
+					routeRefresh.AFI, "SAFI", routeRefresh.SAFI, "that is not negotiated, ignore it"))
+			} else {
+				peer.updatePrefixORF(protoFamily, routeRefresh.PrefixORF)
+				server.logger.Info(fmt.Sprintln("Neighbor", pktInfo.Src, "sent prefix ORF for AFI",
+					routeRefresh.AFI, "SAFI", routeRefresh.SAFI, "ORF is now", peer.prefixORF[protoFamily]))
+				if routeRefresh.WhenToRefresh == packet.BGPORFWhenDefer {
+					return
+				}
+			}
+		}
+		server.logger.Info(fmt.Sprintln("Neighbor", pktInfo.Src, "requested route refresh"))
+		server.SoftResetOut(peer)
+
+	case packet.BGPRouteRefreshBoRR:
+		if !peer.NeighborConf.EnhancedRRCap {
+			server.logger.Info(fmt.Sprintln("Neighbor", pktInfo.Src, "sent BoRR without negotiating",
+				"enhanced route refresh, ignore it"))
+			return
+		}
+		server.logger.Info(fmt.Sprintln("Neighbor", pktInfo.Src, "sent BoRR for AFI", routeRefresh.AFI,
+			"SAFI", routeRefresh.SAFI, "mark its paths as stale"))
+		server.markRefreshStaleFamilyPaths(pktInfo.Src, protoFamily)
+		peer.startRefreshStaleTimer(protoFamily, server.BgpConfig.Global.Config.GracefulRestartStalePathTime)
+
+	case packet.BGPRouteRefreshEoRR:
+		if !peer.NeighborConf.EnhancedRRCap || peer.refreshStaleTimers[protoFamily] == nil {
+			return
+		}
+		server.logger.Info(fmt.Sprintln("Neighbor", pktInfo.Src, "sent EoRR for AFI", routeRefresh.AFI,
+			"SAFI", routeRefresh.SAFI, "remove the stale paths"))
+		server.removeRefreshStaleFamilyPaths(pktInfo.Src, peer, protoFamily)
+
+	default:
+		server.logger.Info(fmt.Sprintln("Neighbor", pktInfo.Src, "sent route refresh with unknown subtype",
+			routeRefresh.SubType, "ignore it"))
+	}
+}
+
+/*  Enhanced route refresh (RFC 7313) - The paths of the family from the neighbor are marked
+ *  stale on BoRR and the paths not received again are removed on EoRR. The stale state is
+ *  kept per family and is separate from the stale paths of graceful restart.
+ */
+func (server *BGPServer) markRefreshStaleFamilyPaths(peerIP string, protoFamily uint32) {
+	server.AdjRib.MarkRefreshStaleFamilyUpdatesFromNeighbor(peerIP, protoFamily)
+	server.markVrfRefreshStaleRoutesFromNeighbor(peerIP, protoFamily)
+	server.markRouteServerRefreshStaleRoutesFromNeighbor(peerIP, protoFamily)
+	if protoFamily == packet.GetProtocolFamily(packet.AfiL2VPN, packet.SafiEVPN) {
+		server.markEvpnRefreshStaleRoutesFromNeighbor(peerIP)
+	}
+}
+
+func (server *BGPServer) removeRefreshStaleFamilyPaths(peerIP string, peer *Peer, protoFamily uint32) {
+	peer.stopRefreshStaleTimer(protoFamily)
+	updated, withdrawn, withdrawPath, updatedAddPaths :=
+		server.AdjRib.RemoveRefreshStaleFamilyUpdatesFromNeighbor(peerIP, protoFamily,
+			peer.NeighborConf, server.AddPathCount)
+	server.removeVrfRefreshStaleRoutesFromNeighbor(peerIP, peer, protoFamily)
+	server.removeRouteServerRefreshStaleRoutesFromNeighbor(peerIP, peer, protoFamily)
+	if protoFamily == packet.GetProtocolFamily(packet.AfiL2VPN, packet.SafiEVPN) {
+		server.removeEvpnRefreshStaleRoutesFromNeighbor(peerIP)
+	}
+	server.logger.Info(fmt.Sprintln("removeRefreshStaleFamilyPaths - Neighbor", peerIP,
+		"send updated paths", updated, "withdrawn paths", withdrawn))
+	updated, withdrawn, withdrawPath, updatedAddPaths =
+		server.CheckForAggregation(updated, withdrawn, withdrawPath,
+			updatedAddPaths)
+	server.SendUpdate(updated, withdrawn, withdrawPath, updatedAddPaths)
+}
+
+func (server *BGPServer) SoftResetIn(peer *Peer) {
+	peerIP := peer.NeighborConf.Neighbor.NeighborAddress.String()
+	if peer.NeighborConf.Neighbor.Transport.Config.LocalAddress == nil {
+		server.logger.Info(fmt.Sprintln("SoftResetIn - Neighbor", peerIP, "is not in Established state"))
+		return
+	}
+
+	// The prefix ORF is sent again in case the import policy changed, the neighbor resends
+	// the routes of the families the ORF is sent for
+	orfFamilies := server.sendPrefixORF(peer, true)
+	if peer.adjRibIn != nil {
+		server.logger.Info(fmt.Sprintln("SoftResetIn - Neighbor", peerIP, "reprocess paths from Adj-RIB-In"))
+		for _, updateMsg := range peer.getAdjRibInUpdates() {
+			server.processUpdateMsg(peer, packet.NewBGPPktSrc(peerIP, updateMsg))
+		}
+		return
+	}
+
+	if !peer.NeighborConf.RouteRefreshCap {
+		server.logger.Info(fmt.Sprintln("SoftResetIn - Neighbor", peerIP, "does not support route refresh",
+			"and soft reconfiguration inbound is not enabled"))
+		return
+	}
+
+	server.logger.Info(fmt.Sprintln("SoftResetIn - Neighbor", peerIP, "send route refresh"))
+	for protoFamily, _ := range peer.NeighborConf.PeerAfiSafiMap {
+		if orfFamilies[protoFamily] {
+			continue
+		}
+		afi, safi := packet.GetAfiSafi(protoFamily)
+		peer.SendRouteRefresh(afi, safi, packet.BGPRouteRefreshNormal)
+	}
+}
+
+func (server *BGPServer) SoftResetOut(peer *Peer) {
+	peerIP := peer.NeighborConf.Neighbor.NeighborAddress.String()
+	if peer.NeighborConf.Neighbor.Transport.Config.LocalAddress == nil {
+		server.logger.Info(fmt.Sprintln("SoftResetOut - Neighbor", peerIP, "is not in Established state"))
+		return
+	}
+
+	if server.grRestarting {
+		server.logger.Info(fmt.Sprintln("SoftResetOut - Neighbor", peerIP, "graceful restart in progress,",
+			"routes will be sent when the restart completes"))
+		return
+	}
+
+	// The peer moves to another update group when its prefix ORF changed
+	ribOut := server.regroupPeer(peer)
+	server.logger.Info(fmt.Sprintln("SoftResetOut - Neighbor", peerIP, "send all routes"))
+	if peer.NeighborConf.EnhancedRRCap {
+		for protoFamily, _ := range peer.NeighborConf.PeerAfiSafiMap {
+			afi, safi := packet.GetAfiSafi(protoFamily)
+			peer.SendRouteRefresh(afi, safi, packet.BGPRouteRefreshBoRR)
+		}
+	}
+
+	// The rib out of the update group is brought up to date with the Loc-RIB and all the routes
+	// in it are sent to the neighbor again, the other members of the group are not affected.
+	// The view of a route server client is built again with the export policy of the client.
+	if group := peer.updateGroup; group != nil {
+		if group.rsRib != nil && ribOut == nil {
+			group.resetRouteServerRib()
+		}
+		group.updateDefaultRoutes()
+		group.SendUpdate(group.locRib().GetLocRib(), make([]*bgprib.Destination, 0), nil,
+			make([]*bgprib.Destination, 0))
+		group.sendRibOutToPeer(peer)
+	}
+	server.withdrawRibOutFromPeer(peer, ribOut)
+
+	if peer.NeighborConf.EnhancedRRCap {
+		for protoFamily, _ := range peer.NeighborConf.PeerAfiSafiMap {
+			afi, safi := packet.GetAfiSafi(protoFamily)
+			peer.SendRouteRefresh(afi, safi, packet.BGPRouteRefreshEoRR)
+		}
+	}
+}
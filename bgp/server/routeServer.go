@@ -0,0 +1,298 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// routeServer.go
+package server
+
+import (
+	"fmt"
+	"l3/bgp/config"
+	"l3/bgp/packet"
+	bgppolicy "l3/bgp/policy"
+	bgprib "l3/bgp/rib"
+	"l3/bgp/rpki"
+)
+
+/*  Route server (RFC 7947) - The routes sent to a route server client keep their AS path,
+ *  NEXT_HOP and MED. Each client is in an update group of its own and the group keeps a view
+ *  with the paths received from the other neighbors that are accepted by the export policy
+ *  of the client. The best paths sent to the client are selected from its view, so the
+ *  client gets the best path permitted by its policy even when the best path of the
+ *  Loc-RIB is filtered for it. The locally originated routes are not sent to the clients.
+ */
+
+/*  nullRouteMgr keeps the routes selected in the ribs that are not installed, the views of the
+ *  route server clients and the VPN rib, out of the RIB manager.
+ */
+type nullRouteMgr struct {
+	config.RouteMgrIntf
+}
+
+func (r *nullRouteMgr) CreateRoute(cfg *config.RouteConfig) {
+}
+
+func (r *nullRouteMgr) DeleteRoute(cfg *config.RouteConfig) {
+}
+
+func (r *nullRouteMgr) UpdateRoute(cfg *config.RouteConfig, op string) {
+}
+
+// locRib returns the rib the routes of the update group are selected from
+func (g *UpdateGroup) locRib() *bgprib.AdjRib {
+	if g.rsRib != nil {
+		return g.rsRib
+	}
+	return g.server.AdjRib
+}
+
+// initRouteServerRib builds the view of a route server client from the paths in the Loc-RIB
+func (g *UpdateGroup) initRouteServerRib() {
+	server := g.server
+	g.rsRib = bgprib.NewAdjRib(server.logger, &nullRouteMgr{server.routeMgr}, &server.BgpConfig.Global.Config)
+	g.rsRib.SetView()
+	g.rsRib.SetVRPTable(server.vrpTable)
+	for peerIP, peer := range server.PeerMap {
+		if peer == g.leader() {
+			continue
+		}
+
+		for _, updateMsg := range server.AdjRib.GetNeighborUpdates(peerIP) {
+			updateMsg.NLRI, _ = splitAcceptedNLRI(server.AdjRib, peerIP, updateMsg.NLRI)
+			if len(updateMsg.NLRI) > 0 {
+				g.processRouteServerUpdate(peer, peerIP, updateMsg)
+			}
+		}
+	}
+	g.logger.Info(fmt.Sprintln("Update group", g.id, "created the route server view for neighbor",
+		g.leader().NeighborConf.Neighbor.NeighborAddress))
+}
+
+/*  resetRouteServerRib builds the view of the client again after its export policy changed
+ *  and withdraws the routes that are not in the new view.
+ */
+func (g *UpdateGroup) resetRouteServerRib() {
+	oldRib := g.rsRib
+	g.initRouteServerRib()
+
+	withdrawn := make([]*bgprib.Destination, 0)
+	for _, dests := range oldRib.GetLocRib() {
+		for _, dest := range dests {
+			if newDest, ok := g.rsRib.GetDest(dest.IPPrefix, false); !ok || newDest.LocRibPath == nil {
+				withdrawn = append(withdrawn, dest)
+			}
+		}
+	}
+	g.SendUpdate(make(map[*bgprib.Path][]*bgprib.Destination), withdrawn, nil, make([]*bgprib.Destination, 0))
+}
+
+/*  applyRouteServerExportPolicy applies the export policy of the client to an update received
+ *  from another neighbor. The routes rejected by the policy are withdrawn from the view.
+ */
+func (g *UpdateGroup) applyRouteServerExportPolicy(peer *Peer, updateMsg *packet.BGPUpdate) []*packet.BGPUpdate {
+	leader := g.leader()
+	policyName := leader.NeighborConf.RunningConf.ExportPolicy
+	if policyName == "" || len(updateMsg.NLRI) == 0 {
+		return []*packet.BGPUpdate{updateMsg}
+	}
+
+	states := []rpki.ValidationState{rpki.ValidationStateNotFound}
+	nlris := [][]packet.NLRI{updateMsg.NLRI}
+	if g.server.bgpPE.RoutePolicyDB.UsesRpkiValidation(policyName) {
+		states, nlris = g.server.splitNLRIByValidationState(peer, updateMsg)
+	}
+	prefixMatch := g.server.bgpPE.RoutePolicyDB.UsesPrefixMatch(policyName)
+	if prefixMatch {
+		states, nlris = splitNLRIByPrefix(states, nlris)
+	}
+
+	updates := make([]*packet.BGPUpdate, 0, len(nlris))
+	for idx, nlriList := range nlris {
+		update := &packet.BGPUpdate{
+			WithdrawnRoutes: make([]packet.NLRI, 0),
+			PathAttributes:  updateMsg.PathAttributes,
+			NLRI:            nlriList,
+		}
+		if idx == 0 {
+			update.WithdrawnRoutes = append(update.WithdrawnRoutes, updateMsg.WithdrawnRoutes...)
+		}
+
+		params := &bgppolicy.RoutePolicyParams{
+			Neighbor:        leader.NeighborConf.Neighbor.NeighborAddress,
+			PathAttrs:       update.PathAttributes,
+			ValidationState: states[idx],
+			LocalAddress:    leader.NeighborConf.Neighbor.Transport.Config.LocalAddress,
+		}
+		if prefixMatch {
+			params.Prefix = nlriList[0].GetPrefix()
+		}
+		if !g.server.bgpPE.RoutePolicyDB.ApplyPolicy(policyName, params) {
+			update.WithdrawnRoutes = append(update.WithdrawnRoutes, update.NLRI...)
+			update.NLRI = make([]packet.NLRI, 0)
+			update.PathAttributes = make([]packet.BGPPathAttr, 0)
+		} else {
+			update.PathAttributes = params.PathAttrs
+		}
+		updates = append(updates, update)
+	}
+	return updates
+}
+
+// processRouteServerUpdate adds the routes of an update received from a neighbor to the view of the client
+func (g *UpdateGroup) processRouteServerUpdate(peer *Peer, peerIP string, updateMsg *packet.BGPUpdate) (
+	map[*bgprib.Path][]*bgprib.Destination, []*bgprib.Destination, *bgprib.Path, []*bgprib.Destination) {
+	updated := make(map[*bgprib.Path][]*bgprib.Destination)
+	withdrawn := make([]*bgprib.Destination, 0)
+	updatedAddPaths := make([]*bgprib.Destination, 0)
+	var withdrawPath *bgprib.Path
+	for _, update := range g.applyRouteServerExportPolicy(peer, updateMsg) {
+		msg := packet.NewBGPUpdateMessage(update.WithdrawnRoutes, update.PathAttributes, update.NLRI)
+		upd, wd, wdPath, updAddPaths, _ := g.rsRib.ProcessUpdate(peer.NeighborConf,
+			packet.NewBGPPktSrc(peerIP, msg), g.leader().getAddPathsCount())
+		for path, dests := range upd {
+			updated[path] = append(updated[path], dests...)
+		}
+		withdrawn = append(withdrawn, wd...)
+		updatedAddPaths = append(updatedAddPaths, updAddPaths...)
+		if len(wd) > 0 {
+			withdrawPath = wdPath
+		}
+	}
+	return updated, withdrawn, withdrawPath, updatedAddPaths
+}
+
+/*  splitAcceptedNLRI splits the NLRIs received from a neighbor into the routes accepted by the
+ *  rib and the routes rejected by the prefix limit of the neighbor or suppressed by dampening.
+ */
+func splitAcceptedNLRI(adjRib *bgprib.AdjRib, peerIP string, nlris []packet.NLRI) ([]packet.NLRI,
+	[]packet.NLRI) {
+	accepted := make([]packet.NLRI, 0, len(nlris))
+	rejected := make([]packet.NLRI, 0)
+	for _, nlri := range nlris {
+		if adjRib.IsPathAccepted(peerIP, nlri) {
+			accepted = append(accepted, nlri)
+		} else {
+			rejected = append(rejected, nlri)
+		}
+	}
+	return accepted, rejected
+}
+
+/*  sendRouteServerUpdate adds the routes of an update received from a neighbor to the views of the
+ *  other clients after the update is processed by the global rib. The views get only the routes
+ *  accepted by the global rib, the other routes are withdrawn from the views.
+ */
+func (server *BGPServer) sendRouteServerUpdate(peer *Peer, peerIP string, updateMsg *packet.BGPUpdate) {
+	accepted, rejected := splitAcceptedNLRI(server.AdjRib, peerIP, updateMsg.NLRI)
+	withdrawn := make([]packet.NLRI, 0, len(updateMsg.WithdrawnRoutes)+len(rejected))
+	withdrawn = append(withdrawn, updateMsg.WithdrawnRoutes...)
+	updateMsg = &packet.BGPUpdate{
+		WithdrawnRoutes: append(withdrawn, rejected...),
+		PathAttributes:  updateMsg.PathAttributes,
+		NLRI:            accepted,
+	}
+
+	for _, group := range server.updateGroups {
+		if group.rsRib == nil || group.leader() == peer {
+			continue
+		}
+
+		updated, withdrawn, withdrawPath, updatedAddPaths := group.processRouteServerUpdate(peer, peerIP, updateMsg)
+		if !server.grRestarting {
+			group.SendUpdate(updated, withdrawn, withdrawPath, updatedAddPaths)
+		}
+	}
+}
+
+// sendRouteServerReusedPaths adds the paths that are not suppressed anymore to the views of the clients
+func (server *BGPServer) sendRouteServerReusedPaths(suppressed map[string][]packet.NLRI) {
+	for peerIP, nlris := range suppressed {
+		peer, ok := server.PeerMap[peerIP]
+		if !ok {
+			continue
+		}
+
+		for _, updateMsg := range server.AdjRib.GetNeighborPathUpdates(peerIP, nlris) {
+			updateMsg.NLRI, _ = splitAcceptedNLRI(server.AdjRib, peerIP, updateMsg.NLRI)
+			if len(updateMsg.NLRI) > 0 {
+				server.sendRouteServerUpdate(peer, peerIP, updateMsg)
+			}
+		}
+	}
+}
+
+func (server *BGPServer) markRouteServerStaleRoutesFromNeighbor(peerIP string) {
+	for _, group := range server.updateGroups {
+		if group.rsRib != nil {
+			group.rsRib.MarkStaleUpdatesFromNeighbor(peerIP)
+		}
+	}
+}
+
+func (server *BGPServer) markRouteServerRefreshStaleRoutesFromNeighbor(peerIP string, protoFamily uint32) {
+	for _, group := range server.updateGroups {
+		if group.rsRib != nil {
+			group.rsRib.MarkRefreshStaleFamilyUpdatesFromNeighbor(peerIP, protoFamily)
+		}
+	}
+}
+
+func (server *BGPServer) removeRouteServerRefreshStaleRoutesFromNeighbor(peerIP string, peer *Peer,
+	protoFamily uint32) {
+	for _, group := range server.updateGroups {
+		if group.rsRib == nil {
+			continue
+		}
+
+		updated, withdrawn, withdrawPath, updatedAddPaths := group.rsRib.RemoveRefreshStaleFamilyUpdatesFromNeighbor(
+			peerIP, protoFamily, peer.NeighborConf, group.leader().getAddPathsCount())
+		group.SendUpdate(updated, withdrawn, withdrawPath, updatedAddPaths)
+	}
+}
+
+func (server *BGPServer) removeRouteServerRoutesFromNeighbor(peerIP string, peer *Peer) {
+	for _, group := range server.updateGroups {
+		if group.rsRib == nil {
+			continue
+		}
+
+		updated, withdrawn, withdrawPath, updatedAddPaths := group.rsRib.RemoveUpdatesFromNeighbor(peerIP,
+			peer.NeighborConf, group.leader().getAddPathsCount())
+		if !server.grRestarting {
+			group.SendUpdate(updated, withdrawn, withdrawPath, updatedAddPaths)
+		}
+	}
+}
+
+func (server *BGPServer) removeRouteServerStaleRoutesFromNeighbor(peerIP string, peer *Peer, protoFamily uint32) {
+	for _, group := range server.updateGroups {
+		if group.rsRib == nil {
+			continue
+		}
+
+		updated, withdrawn, withdrawPath, updatedAddPaths := group.rsRib.RemoveStaleFamilyUpdatesFromNeighbor(peerIP,
+			protoFamily, peer.NeighborConf, group.leader().getAddPathsCount())
+		if !server.grRestarting {
+			group.SendUpdate(updated, withdrawn, withdrawPath, updatedAddPaths)
+		}
+	}
+}
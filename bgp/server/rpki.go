@@ -0,0 +1,153 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// rpki.go
+package server
+
+import (
+	"fmt"
+	"l3/bgp/config"
+	"l3/bgp/packet"
+	bgprib "l3/bgp/rib"
+	"l3/bgp/rpki"
+	"net"
+	"strconv"
+)
+
+type rpkiCache struct {
+	config config.RpkiCacheConfig
+	client *rpki.CacheClient
+}
+
+func getRpkiCacheAddress(conf *config.RpkiCacheConfig) string {
+	return net.JoinHostPort(conf.Address, strconv.Itoa(int(conf.Port)))
+}
+
+func (server *BGPServer) AddRpkiCache(conf config.RpkiCacheConfig) {
+	if conf.Address == "" || conf.Port == 0 {
+		server.logger.Err(fmt.Sprintln("RPKI cache", conf.Address, "port", conf.Port,
+			"address and port must be set"))
+		return
+	}
+
+	address := getRpkiCacheAddress(&conf)
+	if cache, ok := server.rpkiCaches[address]; ok {
+		if cache.config == conf {
+			return
+		}
+		server.logger.Info(fmt.Sprintln("RPKI cache", address, "config changed, restart the cache client"))
+		cache.client.Stop()
+	}
+
+	server.logger.Info(fmt.Sprintln("Add RPKI cache", address))
+	client := rpki.NewCacheClient(server.logger, address, conf.RefreshInterval, conf.RetryInterval,
+		conf.ExpireInterval, server.rpkiSnapshotCh)
+	server.rpkiCaches[address] = &rpkiCache{conf, client}
+	go client.Run()
+}
+
+func (server *BGPServer) RemoveRpkiCache(address string) {
+	cache, ok := server.rpkiCaches[address]
+	if !ok {
+		server.logger.Info(fmt.Sprintln("RPKI cache", address, "not found"))
+		return
+	}
+
+	server.logger.Info(fmt.Sprintln("Remove RPKI cache", address))
+	cache.client.Stop()
+	delete(server.rpkiCaches, address)
+	server.processVRPChanges(server.vrpTable.RemoveCache(address))
+}
+
+// The snapshots of the cache clients that are already stopped are ignored
+func (server *BGPServer) ProcessRpkiCacheSnapshot(snapshot rpki.CacheSnapshot) {
+	cache, ok := server.rpkiCaches[snapshot.Client.Address]
+	if !ok || cache.client != snapshot.Client {
+		return
+	}
+
+	changed := server.vrpTable.SetCacheVRPs(snapshot.Client.Address, snapshot.VRPs)
+	server.logger.Info(fmt.Sprintln("RPKI cache", snapshot.Client.Address, "sent", len(snapshot.VRPs),
+		"VRPs,", len(changed), "VRPs changed, total VRPs", server.vrpTable.Len()))
+	server.processVRPChanges(changed)
+}
+
+/*  Revalidate the routes covered by the changed VRPs. The best paths change only when the
+ *  valid routes are preferred. The routes of the neighbors with an import policy that matches
+ *  on the validation state are received again to apply the policy with the new states. The
+ *  views of the route server clients with such an export policy are built again.
+ */
+func (server *BGPServer) processVRPChanges(changed []rpki.VRP) {
+	if len(changed) == 0 {
+		return
+	}
+
+	updated, withdrawn, updatedAddPaths := server.AdjRib.ValidateRoutes(changed, server.AddPathCount)
+	updated, withdrawn, _, updatedAddPaths = server.CheckForAggregation(updated, withdrawn, nil,
+		updatedAddPaths)
+	server.SendUpdate(updated, withdrawn, nil, updatedAddPaths)
+	for _, group := range server.updateGroups {
+		if group.rsRib == nil {
+			continue
+		}
+
+		policyName := group.leader().NeighborConf.RunningConf.ExportPolicy
+		if policyName != "" && server.bgpPE.RoutePolicyDB.UsesRpkiValidation(policyName) {
+			group.resetRouteServerRib()
+			group.SendUpdate(group.locRib().GetLocRib(), make([]*bgprib.Destination, 0), nil,
+				make([]*bgprib.Destination, 0))
+			continue
+		}
+
+		updated, withdrawn, updatedAddPaths = group.rsRib.ValidateRoutes(changed, group.leader().getAddPathsCount())
+		group.SendUpdate(updated, withdrawn, nil, updatedAddPaths)
+	}
+
+	for _, peer := range server.PeerMap {
+		policyName := peer.NeighborConf.RunningConf.ImportPolicy
+		if policyName != "" && server.bgpPE.RoutePolicyDB.UsesRpkiValidation(policyName) {
+			server.SoftResetIn(peer)
+		}
+	}
+}
+
+// Returns the NLRIs of the update grouped by their validation state, in the order valid, not found and invalid
+func (server *BGPServer) splitNLRIByValidationState(peer *Peer, updateMsg *packet.BGPUpdate) (
+	[]rpki.ValidationState, [][]packet.NLRI) {
+	stateNLRIMap := make(map[rpki.ValidationState][]packet.NLRI)
+	for _, nlri := range updateMsg.NLRI {
+		state := server.AdjRib.GetValidationState(nlri.GetPrefix(), updateMsg.PathAttributes, peer.NeighborConf)
+		stateNLRIMap[state] = append(stateNLRIMap[state], nlri)
+	}
+
+	states := make([]rpki.ValidationState, 0, len(stateNLRIMap))
+	nlris := make([][]packet.NLRI, 0, len(stateNLRIMap))
+	for _, state := range []rpki.ValidationState{rpki.ValidationStateValid, rpki.ValidationStateNotFound,
+		rpki.ValidationStateInvalid} {
+		if nlriList, ok := stateNLRIMap[state]; ok {
+			states = append(states, state)
+			nlris = append(nlris, nlriList)
+		}
+	}
+	return states, nlris
+}
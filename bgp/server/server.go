@@ -32,6 +32,7 @@ import (
 	"l3/bgp/packet"
 	bgppolicy "l3/bgp/policy"
 	bgprib "l3/bgp/rib"
+	"l3/bgp/rpki"
 	"l3/bgp/utils"
 	"net"
 	"runtime"
@@ -39,12 +40,16 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 	"utils/logging"
-	"utils/netUtils"
 	utilspolicy "utils/policy"
 	"utils/policy/policyCommonDefs"
+
+	"golang.org/x/net/ipv6"
 )
 
+const maxPrefixLimitEvents int = 256
+
 type PeerUpdate struct {
 	OldPeer config.NeighborConfig
 	NewPeer config.NeighborConfig
@@ -95,9 +100,58 @@ type BGPServer struct {
 	bfdCh            chan config.BfdInfo
 	intfCh           chan config.IntfStateInfo
 	routesCh         chan *config.RouteCh
+	evpnMacCh        chan *config.EvpnMacCh
 	acceptCh         chan *net.TCPConn
 	GlobalCfgDone    bool
 
+	stalePathsTimerCh chan string
+	refreshStaleCh    chan refreshStaleTimer
+	drainTimerCh      chan string
+	deferralTimerCh   chan bool
+	dampeningTicker   *time.Ticker
+	condAdvTicker     *time.Ticker
+	deferralTimer     *time.Timer
+	grRestarting      bool
+	dynamicTimerCh    chan string
+	peerGroupMD5      map[string]string
+
+	listenerTTLSecurity bool
+
+	AddBmpCollectorCh chan config.BmpCollectorConfig
+	RemBmpCollectorCh chan string
+	bmpCollectorCh    chan bmpCollectorState
+	bmpStatsCh        chan *bmpCollector
+	bmpCollectors     map[string]*bmpCollector
+
+	AddRpkiCacheCh chan config.RpkiCacheConfig
+	RemRpkiCacheCh chan string
+	rpkiSnapshotCh chan rpki.CacheSnapshot
+	rpkiCaches     map[string]*rpkiCache
+	vrpTable       *rpki.VRPTable
+
+	AddVrfCh chan config.VrfConfig
+	RemVrfCh chan string
+	vrfs     map[string]*vrf
+	vpnRib   *bgprib.AdjRib
+
+	AddEvpnCh     chan config.EvpnConfig
+	RemEvpnCh     chan uint32
+	evpnInstances map[uint32]*evpnInstance
+	evpnRoutes    map[string]map[string]*evpnRoute
+	evpnLocalMacs map[uint32]map[string]*config.EvpnMacInfo
+
+	updateGroupMutex sync.RWMutex
+	updateGroups     map[updateGroupKey]*UpdateGroup
+	updateGroupId    uint32
+
+	RemUnnumberedPeerCh chan int32
+	linkLocalCh         chan linkLocalNeighbor
+	unnumberedNeighbors map[int32]*unnumberedNeighbor
+	raConn              *ipv6.PacketConn
+
+	prefixLimitEventMutex sync.RWMutex
+	prefixLimitEvents     []config.PrefixLimitEvent
+
 	NeighborMutex  sync.RWMutex
 	PeerMap        map[string]*Peer
 	Neighbors      []*Peer
@@ -111,11 +165,12 @@ type BGPServer struct {
 	IntfMgr  config.IntfStateMgrIntf
 	routeMgr config.RouteMgrIntf
 	bfdMgr   config.BfdMgrIntf
+	vtepMgr  config.VtepMgrIntf
 }
 
 func NewBGPServer(logger *logging.Writer, policyEngine *bgppolicy.BGPPolicyEngine,
 	iMgr config.IntfStateMgrIntf, rMgr config.RouteMgrIntf,
-	bMgr config.BfdMgrIntf) *BGPServer {
+	bMgr config.BfdMgrIntf, vMgr config.VtepMgrIntf) *BGPServer {
 	bgpServer := &BGPServer{}
 	bgpServer.logger = logger
 	bgpServer.bgpPE = policyEngine
@@ -135,13 +190,47 @@ func NewBGPServer(logger *logging.Writer, policyEngine *bgppolicy.BGPPolicyEngin
 	bgpServer.PeerCommandCh = make(chan config.PeerCommand)
 	bgpServer.ReachabilityCh = make(chan config.ReachabilityInfo)
 	bgpServer.BGPPktSrcCh = make(chan *packet.BGPPktSrc)
+	bgpServer.stalePathsTimerCh = make(chan string)
+	bgpServer.refreshStaleCh = make(chan refreshStaleTimer)
+	bgpServer.drainTimerCh = make(chan string)
+	bgpServer.deferralTimerCh = make(chan bool)
+	bgpServer.dampeningTicker = time.NewTicker(time.Duration(bgprib.DampeningReuseInterval) * time.Second)
+	bgpServer.condAdvTicker = time.NewTicker(time.Duration(conditionalAdvScanInterval) * time.Second)
+	bgpServer.dynamicTimerCh = make(chan string)
+	bgpServer.peerGroupMD5 = make(map[string]string)
+	bgpServer.AddBmpCollectorCh = make(chan config.BmpCollectorConfig)
+	bgpServer.RemBmpCollectorCh = make(chan string)
+	bgpServer.bmpCollectorCh = make(chan bmpCollectorState)
+	bgpServer.bmpStatsCh = make(chan *bmpCollector)
+	bgpServer.bmpCollectors = make(map[string]*bmpCollector)
+	bgpServer.AddRpkiCacheCh = make(chan config.RpkiCacheConfig)
+	bgpServer.RemRpkiCacheCh = make(chan string)
+	bgpServer.rpkiSnapshotCh = make(chan rpki.CacheSnapshot)
+	bgpServer.rpkiCaches = make(map[string]*rpkiCache)
+	bgpServer.vrpTable = rpki.NewVRPTable()
+	bgpServer.AddVrfCh = make(chan config.VrfConfig)
+	bgpServer.RemVrfCh = make(chan string)
+	bgpServer.vrfs = make(map[string]*vrf)
+	bgpServer.vpnRib = bgprib.NewAdjRib(logger, &nullRouteMgr{rMgr}, &bgpServer.BgpConfig.Global.Config)
+	bgpServer.vpnRib.SetVPN()
+	bgpServer.AddEvpnCh = make(chan config.EvpnConfig)
+	bgpServer.RemEvpnCh = make(chan uint32)
+	bgpServer.evpnInstances = make(map[uint32]*evpnInstance)
+	bgpServer.evpnRoutes = make(map[string]map[string]*evpnRoute)
+	bgpServer.evpnLocalMacs = make(map[uint32]map[string]*config.EvpnMacInfo)
+	bgpServer.updateGroups = make(map[updateGroupKey]*UpdateGroup)
+	bgpServer.RemUnnumberedPeerCh = make(chan int32)
+	bgpServer.linkLocalCh = make(chan linkLocalNeighbor)
+	bgpServer.unnumberedNeighbors = make(map[int32]*unnumberedNeighbor)
 	bgpServer.NeighborMutex = sync.RWMutex{}
 	bgpServer.PeerMap = make(map[string]*Peer)
 	bgpServer.Neighbors = make([]*Peer, 0)
 	bgpServer.IntfMgr = iMgr
 	bgpServer.routeMgr = rMgr
 	bgpServer.bfdMgr = bMgr
+	bgpServer.vtepMgr = vMgr
 	bgpServer.AdjRib = bgprib.NewAdjRib(logger, rMgr, &bgpServer.BgpConfig.Global.Config)
+	bgpServer.AdjRib.SetVRPTable(bgpServer.vrpTable)
 	bgpServer.IfacePeerMap = make(map[int32][]string)
 	bgpServer.ifaceIP = nil
 	bgpServer.actionFuncMap = make(map[int]bgppolicy.PolicyActionFunc)
@@ -164,7 +253,7 @@ func NewBGPServer(logger *logging.Writer, policyEngine *bgppolicy.BGPPolicyEngin
 }
 
 func (server *BGPServer) createListener() (*net.TCPListener, error) {
-	proto := "tcp4"
+	proto := "tcp"
 	addr := ":" + config.BGPPort
 	server.logger.Info(fmt.Sprintf("Listening for incomig connections on %s\n", addr))
 	tcpAddr, err := net.ResolveTCPAddr(proto, addr)
@@ -182,6 +271,39 @@ func (server *BGPServer) createListener() (*net.TCPListener, error) {
 	return listener, nil
 }
 
+/*  The SYN-ACKs to the neighbors are sent with the TTL of the listener. The TTL is set to 255
+ *  when a neighbor or a peer group uses TTL security, so that the neighbors don't drop them.
+ */
+func (server *BGPServer) updateListenerTTL() {
+	ttlSecurity := false
+	for _, peer := range server.PeerMap {
+		if peer.NeighborConf.RunningConf.TtlSecurityHops != 0 {
+			ttlSecurity = true
+			break
+		}
+	}
+	for _, group := range server.BgpConfig.PeerGroups {
+		if group.Config.TtlSecurityHops != 0 {
+			ttlSecurity = true
+			break
+		}
+	}
+
+	if server.listener == nil || ttlSecurity == server.listenerTTLSecurity {
+		return
+	}
+
+	ttl := utils.DefaultTTL
+	if ttlSecurity {
+		ttl = int(config.BGPMaxTTL)
+	}
+	if err := utils.SetTCPListenerTTL(server.listener, ttl); err != nil {
+		server.logger.Err(fmt.Sprintln("Failed to set TTL", ttl, "on the listener with error", err))
+		return
+	}
+	server.listenerTTLSecurity = ttlSecurity
+}
+
 func (server *BGPServer) listenForPeers(listener *net.TCPListener, acceptCh chan *net.TCPConn) {
 	for {
 		server.logger.Info(fmt.Sprintln("Waiting for peer connections..."))
@@ -202,8 +324,15 @@ func (server *BGPServer) IsPeerLocal(peerIp string) bool {
 func (server *BGPServer) SendUpdate(updated map[*bgprib.Path][]*bgprib.Destination,
 	withdrawn []*bgprib.Destination, withdrawPath *bgprib.Path,
 	updatedAddPaths []*bgprib.Destination) {
-	for _, peer := range server.PeerMap {
-		peer.SendUpdate(updated, withdrawn, withdrawPath, updatedAddPaths)
+	if server.grRestarting {
+		return
+	}
+
+	// The routes of the route server clients are selected from their views
+	for _, group := range server.updateGroups {
+		if group.rsRib == nil {
+			group.SendUpdate(updated, withdrawn, withdrawPath, updatedAddPaths)
+		}
 	}
 }
 
@@ -680,6 +809,79 @@ func (server *BGPServer) TraverseAndReverseBGPRib(policyData interface{}) {
 	}
 }
 
+/*  Apply the import policy of the peer to the received routes. The routes rejected by the
+ *  policy are treated as withdrawn so that any previously accepted path is removed. The
+ *  routes are split into an update per validation state when the policy matches on the RPKI
+ *  validation state, and into an update per route when the policy matches on the prefix.
+ *  Returns the updates after the policy is applied.
+ */
+func (server *BGPServer) applyImportPolicy(peer *Peer, updateMsg *packet.BGPUpdate) []*packet.BGPUpdate {
+	policyName := peer.NeighborConf.RunningConf.ImportPolicy
+	if policyName == "" || len(updateMsg.NLRI) == 0 {
+		return []*packet.BGPUpdate{updateMsg}
+	}
+
+	states := []rpki.ValidationState{rpki.ValidationStateNotFound}
+	nlris := [][]packet.NLRI{updateMsg.NLRI}
+	if server.bgpPE.RoutePolicyDB.UsesRpkiValidation(policyName) {
+		states, nlris = server.splitNLRIByValidationState(peer, updateMsg)
+	}
+	prefixMatch := server.bgpPE.RoutePolicyDB.UsesPrefixMatch(policyName)
+	if prefixMatch {
+		states, nlris = splitNLRIByPrefix(states, nlris)
+	}
+
+	updates := make([]*packet.BGPUpdate, 0, len(states))
+	for idx, state := range states {
+		update := updateMsg
+		if len(states) > 1 {
+			update = &packet.BGPUpdate{
+				WithdrawnRoutes: make([]packet.NLRI, 0),
+				PathAttributes:  updateMsg.PathAttributes,
+				NLRI:            nlris[idx],
+			}
+			if idx == 0 {
+				update.WithdrawnRoutes = updateMsg.WithdrawnRoutes
+			}
+		}
+
+		params := &bgppolicy.RoutePolicyParams{
+			Neighbor:        peer.NeighborConf.Neighbor.NeighborAddress,
+			PathAttrs:       update.PathAttributes,
+			ValidationState: state,
+			LocalAddress:    peer.NeighborConf.Neighbor.Transport.Config.LocalAddress,
+		}
+		if prefixMatch {
+			params.Prefix = update.NLRI[0].GetPrefix()
+		}
+		if !server.bgpPE.RoutePolicyDB.ApplyPolicy(policyName, params) {
+			server.logger.Info(fmt.Sprintln("Neighbor", peer.NeighborConf.Neighbor.NeighborAddress,
+				"routes", update.NLRI, "rejected by import policy", policyName))
+			update.WithdrawnRoutes = append(update.WithdrawnRoutes, update.NLRI...)
+			update.NLRI = make([]packet.NLRI, 0)
+			update.PathAttributes = make([]packet.BGPPathAttr, 0)
+		} else {
+			update.PathAttributes = params.PathAttrs
+		}
+		updates = append(updates, update)
+	}
+	return updates
+}
+
+// Splits the routes of each validation state into a list per route
+func splitNLRIByPrefix(states []rpki.ValidationState, nlris [][]packet.NLRI) ([]rpki.ValidationState,
+	[][]packet.NLRI) {
+	prefixStates := make([]rpki.ValidationState, 0, len(states))
+	prefixNLRIs := make([][]packet.NLRI, 0, len(nlris))
+	for idx, state := range states {
+		for _, nlri := range nlris[idx] {
+			prefixStates = append(prefixStates, state)
+			prefixNLRIs = append(prefixNLRIs, []packet.NLRI{nlri})
+		}
+	}
+	return prefixStates, prefixNLRIs
+}
+
 func (server *BGPServer) ProcessUpdate(pktInfo *packet.BGPPktSrc) {
 	peer, ok := server.PeerMap[pktInfo.Src]
 	if !ok {
@@ -690,15 +892,110 @@ func (server *BGPServer) ProcessUpdate(pktInfo *packet.BGPPktSrc) {
 
 	atomic.AddUint32(&peer.NeighborConf.Neighbor.State.Queues.Input, ^uint32(0))
 	peer.NeighborConf.Neighbor.State.Messages.Received.Update++
-	updated, withdrawn, withdrawPath, updatedAddPaths, addedAllPrefixes :=
-		server.AdjRib.ProcessUpdate(peer.NeighborConf, pktInfo, server.AddPathCount)
-	if !addedAllPrefixes {
-		peer.MaxPrefixesExceeded()
+	server.SendBmpRouteMonitoring(peer, pktInfo.Msg, false)
+	updateMsg := pktInfo.Msg.Body.(*packet.BGPUpdate)
+	if protoFamily, ok := packet.GetEndOfRIBFamily(updateMsg); ok {
+		server.ProcessEndOfRIB(pktInfo.Src, peer, protoFamily)
+		return
 	}
-	updated, withdrawn, withdrawPath, updatedAddPaths =
-		server.CheckForAggregation(updated, withdrawn, withdrawPath,
-			updatedAddPaths)
-	server.SendUpdate(updated, withdrawn, withdrawPath, updatedAddPaths)
+
+	for protoFamily, familyUpdate := range packet.SplitMPUpdate(updateMsg) {
+		afi, safi := packet.GetAfiSafi(protoFamily)
+		if !peer.NeighborConf.IsProtocolFamilyNegotiated(afi, safi) {
+			server.logger.Info(fmt.Sprintln("Neighbor", pktInfo.Src, "sent routes for AFI", afi, "SAFI", safi,
+				"that is not negotiated, ignore them"))
+			continue
+		}
+
+		peer.updateAdjRibIn(familyUpdate)
+		familyMsg := &packet.BGPMessage{Header: pktInfo.Msg.Header, Body: familyUpdate}
+		for _, policyUpdate := range server.processUpdateMsg(peer, packet.NewBGPPktSrc(pktInfo.Src, familyMsg)) {
+			server.SendBmpFamilyRouteMonitoring(peer, protoFamily, policyUpdate)
+		}
+	}
+	server.publishPrefixLimitEvents(peer)
+}
+
+/*  Publishes the prefix limit events raised by the update. The last maxPrefixLimitEvents
+ *  events are kept for the config listener.
+ */
+func (server *BGPServer) publishPrefixLimitEvents(peer *Peer) {
+	events := peer.NeighborConf.GetPrefixLimitEvents()
+	if len(events) == 0 {
+		return
+	}
+
+	server.prefixLimitEventMutex.Lock()
+	server.prefixLimitEvents = append(server.prefixLimitEvents, events...)
+	if len(server.prefixLimitEvents) > maxPrefixLimitEvents {
+		server.prefixLimitEvents = server.prefixLimitEvents[len(server.prefixLimitEvents)-maxPrefixLimitEvents:]
+	}
+	server.prefixLimitEventMutex.Unlock()
+}
+
+// GetPrefixLimitEvents returns the prefix limit events of the neighbor, or of all the neighbors when neighborIP is empty
+func (s *BGPServer) GetPrefixLimitEvents(neighborIP string) []config.PrefixLimitEvent {
+	defer s.prefixLimitEventMutex.RUnlock()
+
+	s.prefixLimitEventMutex.RLock()
+	events := make([]config.PrefixLimitEvent, 0)
+	for _, event := range s.prefixLimitEvents {
+		if neighborIP == "" || event.NeighborAddress.String() == neighborIP {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// LOCAL_PREF received from the external peers is ignored (RFC 4271 section 5.1.5)
+// The local AS of a neighbor with local-as is prepended to the AS path unless no-prepend is set
+// The paths received from a draining neighbor get the lowest LOCAL_PREF (RFC 8326)
+func (server *BGPServer) processUpdateMsg(peer *Peer, pktInfo *packet.BGPPktSrc) []*packet.BGPUpdate {
+	updateMsg := pktInfo.Msg.Body.(*packet.BGPUpdate)
+	if peer.NeighborConf.IsExternal() {
+		updateMsg.PathAttributes = packet.RemoveLocalPrefFromPathAttrs(updateMsg.PathAttributes)
+	}
+
+	if peer.NeighborConf.IsLocalASPrependedIn() && len(updateMsg.PathAttributes) > 0 {
+		// The path attrs are shared by the updates of all the families of the message
+		updateMsg.PathAttributes = packet.ClonePathAttrs(updateMsg.PathAttributes)
+		packet.PrependAS(pktInfo.Msg, peer.NeighborConf.RunningConf.LocalAS, 4)
+	}
+
+	if peer.NeighborConf.Neighbor.State.Draining && isReachUpdate(updateMsg) {
+		updateMsg.PathAttributes = setGracefulShutdownAttrs(packet.ClonePathAttrs(updateMsg.PathAttributes), true)
+	}
+
+	if isEVPNUpdate(updateMsg) {
+		server.processEVPNUpdate(peer, pktInfo)
+		return []*packet.BGPUpdate{updateMsg}
+	}
+
+	updates := server.applyImportPolicy(peer, updateMsg)
+	if isVPNUpdate(updateMsg) {
+		for _, update := range updates {
+			server.processVPNUpdate(peer, pktInfo, update)
+		}
+		return updates
+	}
+
+	for _, update := range updates {
+		for _, labelUpdate := range splitUpdateByLabels(update) {
+			msg := &packet.BGPMessage{Header: pktInfo.Msg.Header, Body: labelUpdate}
+			updated, withdrawn, withdrawPath, updatedAddPaths, addedAllPrefixes :=
+				server.AdjRib.ProcessUpdate(peer.NeighborConf, packet.NewBGPPktSrc(pktInfo.Src, msg),
+					server.AddPathCount)
+			if !addedAllPrefixes {
+				peer.MaxPrefixesExceeded()
+			}
+			updated, withdrawn, withdrawPath, updatedAddPaths =
+				server.CheckForAggregation(updated, withdrawn, withdrawPath,
+					updatedAddPaths)
+			server.SendUpdate(updated, withdrawn, withdrawPath, updatedAddPaths)
+			server.sendRouteServerUpdate(peer, pktInfo.Src, labelUpdate)
+		}
+	}
+	return updates
 }
 
 func (server *BGPServer) convertDestIPToIPPrefix(routes []*config.RouteInfo) []packet.NLRI {
@@ -716,6 +1013,9 @@ func (server *BGPServer) ProcessConnectedRoutes(installedRoutes []*config.RouteI
 	withdrawnRoutes []*config.RouteInfo) {
 	server.logger.Info(fmt.Sprintln("valid routes:", installedRoutes,
 		"invalid routes:", withdrawnRoutes))
+	installedRoutes, vrfInstalledRoutes := splitRoutesByVrf(installedRoutes)
+	withdrawnRoutes, vrfWithdrawnRoutes := splitRoutesByVrf(withdrawnRoutes)
+	server.processVrfConnectedRoutes(vrfInstalledRoutes, vrfWithdrawnRoutes)
 	valid := server.convertDestIPToIPPrefix(installedRoutes)
 	invalid := server.convertDestIPToIPPrefix(withdrawnRoutes)
 	updated, withdrawn, withdrawPath, updatedAddPaths := server.AdjRib.ProcessConnectedRoutes(
@@ -727,6 +1027,16 @@ func (server *BGPServer) ProcessConnectedRoutes(installedRoutes []*config.RouteI
 	server.SendUpdate(updated, withdrawn, withdrawPath, updatedAddPaths)
 }
 
+// The rib selects the add paths of the destinations for the peer that needs the most add paths
+func (server *BGPServer) updateAddPathCount() {
+	server.AddPathCount = 0
+	for _, peer := range server.PeerMap {
+		if addPathCount := peer.getAddPathsCount(); addPathCount > server.AddPathCount {
+			server.AddPathCount = addPathCount
+		}
+	}
+}
+
 func (server *BGPServer) ProcessIntfStates(intfs []*config.IntfStateInfo) {
 	for _, ifState := range intfs {
 		if ifState.State == config.INTF_CREATED {
@@ -738,9 +1048,16 @@ func (server *BGPServer) ProcessIntfStates(intfs []*config.IntfStateInfo) {
 }
 
 func (server *BGPServer) ProcessRemoveNeighbor(peerIp string, peer *Peer) {
+	peer.stopStalePathsTimer()
+	peer.stopRefreshStaleTimers()
+	peer.stalePaths = false
+	peer.NeighborConf.Neighbor.State.StalePaths = false
 	updated, withdrawn, withdrawPath, updatedAddPaths :=
 		server.AdjRib.RemoveUpdatesFromNeighbor(peerIp,
 			peer.NeighborConf, server.AddPathCount)
+	server.removeVrfRoutesFromNeighbor(peerIp, peer)
+	server.removeRouteServerRoutesFromNeighbor(peerIp, peer)
+	server.removeEvpnRoutesFromNeighbor(peerIp)
 	server.logger.Info(fmt.Sprintf("ProcessRemoveNeighbor - Neighbor %s,",
 		"send updated paths %v, withdrawn paths %v\n",
 		peerIp, updated, withdrawn))
@@ -750,15 +1067,45 @@ func (server *BGPServer) ProcessRemoveNeighbor(peerIp string, peer *Peer) {
 	server.SendUpdate(updated, withdrawn, withdrawPath, updatedAddPaths)
 }
 
+/*  A peer that joined an update group with other members gets the routes in the rib out of
+ *  the group, the routes of a new update group are calculated from the Loc-RIB.
+ */
 func (server *BGPServer) SendAllRoutesToPeer(peer *Peer) {
+	group := peer.updateGroup
+	if group == nil {
+		return
+	}
+
+	server.sendVrfRoutesToPeer(peer)
+	server.sendEvpnRoutesToPeer(peer)
+	if len(group.members) > 1 {
+		group.sendRibOutToPeer(peer)
+		return
+	}
+
 	withdrawn := make([]*bgprib.Destination, 0)
 	updatedAddPaths := make([]*bgprib.Destination, 0)
-	updated := server.AdjRib.GetLocRib()
-	server.SendUpdate(updated, withdrawn, nil, updatedAddPaths)
+	updated := group.locRib().GetLocRib()
+	group.SendUpdate(updated, withdrawn, nil, updatedAddPaths)
+	group.updateDefaultRoutes()
 }
 
 func (server *BGPServer) RemoveRoutesFromAllNeighbor() {
+	for _, peer := range server.PeerMap {
+		peer.stopStalePathsTimer()
+		peer.stopRefreshStaleTimers()
+		peer.stalePaths = false
+		peer.NeighborConf.Neighbor.State.StalePaths = false
+	}
 	server.AdjRib.RemoveUpdatesFromAllNeighbors(server.AddPathCount)
+	for _, v := range server.vrfs {
+		v.adjRib.RemoveUpdatesFromAllNeighbors(0)
+	}
+	for _, group := range server.updateGroups {
+		if group.rsRib != nil {
+			group.rsRib.RemoveUpdatesFromAllNeighbors(group.leader().getAddPathsCount())
+		}
+	}
 }
 
 func (server *BGPServer) addPeerToList(peer *Peer) {
@@ -776,11 +1123,25 @@ func (server *BGPServer) removePeerFromList(peer *Peer) {
 	}
 }
 
+func (server *BGPServer) removeNeighbor(peerIP string, peer *Peer) {
+	server.NeighborMutex.Lock()
+	server.removePeerFromList(peer)
+	server.NeighborMutex.Unlock()
+	delete(server.PeerMap, peerIP)
+	server.clearNeighborMD5(peerIP, peer)
+	server.SendBmpPeerDown(peer, &fsm.PeerFSMConn{PeerIP: peerIP, Deconfigured: true})
+	server.leaveUpdateGroup(peer)
+	peer.Cleanup()
+	peer.ProcessBfd(false)
+	server.ProcessRemoveNeighbor(peerIP, peer)
+}
+
 func (server *BGPServer) StopPeersByGroup(groupName string) []*Peer {
 	peers := make([]*Peer, 0)
 	for peerIP, peer := range server.PeerMap {
 		if peer.NeighborConf.Group.Name == groupName {
 			server.logger.Info(fmt.Sprintln("Clean up peer", peerIP))
+			server.leaveUpdateGroup(peer)
 			peer.Cleanup()
 			server.ProcessRemoveNeighbor(peerIP, peer)
 			peers = append(peers, peer)
@@ -793,9 +1154,11 @@ func (server *BGPServer) StopPeersByGroup(groupName string) []*Peer {
 }
 
 func (server *BGPServer) UpdatePeerGroupInPeers(groupName string, peerGroup *config.PeerGroupConfig) {
+	server.removeDynamicNeighborsByGroup(groupName)
 	peers := server.StopPeersByGroup(groupName)
 	for _, peer := range peers {
 		peer.UpdatePeerGroup(peerGroup)
+		server.setNeighborMD5(peer.NeighborConf.Neighbor.NeighborAddress.String(), peer)
 		peer.Init()
 	}
 }
@@ -829,6 +1192,23 @@ func (server *BGPServer) copyGlobalConf(gConf config.GlobalConfig) {
 	server.BgpConfig.Global.Config.EBGPMaxPaths = gConf.EBGPMaxPaths
 	server.BgpConfig.Global.Config.EBGPAllowMultipleAS = gConf.EBGPAllowMultipleAS
 	server.BgpConfig.Global.Config.IBGPMaxPaths = gConf.IBGPMaxPaths
+	server.BgpConfig.Global.Config.GracefulRestart = gConf.GracefulRestart
+	server.BgpConfig.Global.Config.GracefulRestartTime = gConf.GracefulRestartTime
+	server.BgpConfig.Global.Config.GracefulRestartStalePathTime = gConf.GracefulRestartStalePathTime
+	server.BgpConfig.Global.Config.GracefulRestartDeferralTime = gConf.GracefulRestartDeferralTime
+	server.BgpConfig.Global.Config.RpkiPreferValid = gConf.RpkiPreferValid
+	server.BgpConfig.Global.Config.Dampening = gConf.Dampening
+	server.BgpConfig.Global.Config.DampeningHalfLife = gConf.DampeningHalfLife
+	server.BgpConfig.Global.Config.DampeningReuse = gConf.DampeningReuse
+	server.BgpConfig.Global.Config.DampeningSuppress = gConf.DampeningSuppress
+	server.BgpConfig.Global.Config.DampeningMaxSuppressTime = gConf.DampeningMaxSuppressTime
+	server.BgpConfig.Global.Config.ConfederationId = gConf.ConfederationId
+	server.BgpConfig.Global.Config.ConfederationMembers = gConf.ConfederationMembers
+	server.BgpConfig.Global.Config.AlwaysCompareMED = gConf.AlwaysCompareMED
+	server.BgpConfig.Global.Config.DeterministicMED = gConf.DeterministicMED
+	server.BgpConfig.Global.Config.MEDMissingAsWorst = gConf.MEDMissingAsWorst
+	server.BgpConfig.Global.Config.GracefulShutdownTime = gConf.GracefulShutdownTime
+	server.BgpConfig.Global.Config.RouteServer = gConf.RouteServer
 }
 
 func (server *BGPServer) handleBfdNotifications(oper config.Operation, DestIp string,
@@ -849,25 +1229,31 @@ func (server *BGPServer) handleBfdNotifications(oper config.Operation, DestIp st
 }
 
 func (server *BGPServer) setInterfaceMapForPeer(peerIP string, peer *Peer) {
-	server.logger.Info(fmt.Sprintln("Server: setInterfaceMapForPeer Peer", peer,
-		"calling GetRouteReachabilityInfo"))
-	reachInfo, err := server.routeMgr.GetNextHopInfo(peerIP)
-	server.logger.Info(fmt.Sprintln("Server: setInterfaceMapForPeer Peer",
-		peer, "GetRouteReachabilityInfo returned", reachInfo))
-	if err != nil {
-		server.logger.Info(fmt.Sprintf("Server: Peer %s is not reachable", peerIP))
+	var ifIdx int32
+	if peer.NeighborConf.IsLinkLocal() {
+		// Link-local neighbors are directly connected on the configured interface
+		ifIdx = peer.NeighborConf.RunningConf.IfIndex
 	} else {
+		server.logger.Info(fmt.Sprintln("Server: setInterfaceMapForPeer Peer", peer,
+			"calling GetRouteReachabilityInfo"))
+		reachInfo, err := server.routeMgr.GetNextHopInfo(peerIP)
+		server.logger.Info(fmt.Sprintln("Server: setInterfaceMapForPeer Peer",
+			peer, "GetRouteReachabilityInfo returned", reachInfo))
+		if err != nil {
+			server.logger.Info(fmt.Sprintf("Server: Peer %s is not reachable", peerIP))
+			return
+		}
 		// @TODO: jgheewala think of something better for ovsdb....
-		ifIdx := server.IntfMgr.GetIfIndex(int(reachInfo.NextHopIfIndex),
+		ifIdx = server.IntfMgr.GetIfIndex(int(reachInfo.NextHopIfIndex),
 			int(reachInfo.NextHopIfType))
 		///		ifIdx := asicdCommonDefs.GetIfIndexFromIntfIdAndIntfType(int(reachInfo.NextHopIfIndex), int(reachInfo.NextHopIfType))
-		server.logger.Info(fmt.Sprintf("Server: Peer %s IfIdx %d", peerIP, ifIdx))
-		if _, ok := server.IfacePeerMap[ifIdx]; !ok {
-			server.IfacePeerMap[ifIdx] = make([]string, 0)
-		}
-		server.IfacePeerMap[ifIdx] = append(server.IfacePeerMap[ifIdx], peerIP)
-		peer.setIfIdx(ifIdx)
 	}
+	server.logger.Info(fmt.Sprintf("Server: Peer %s IfIdx %d", peerIP, ifIdx))
+	if _, ok := server.IfacePeerMap[ifIdx]; !ok {
+		server.IfacePeerMap[ifIdx] = make([]string, 0)
+	}
+	server.IfacePeerMap[ifIdx] = append(server.IfacePeerMap[ifIdx], peerIP)
+	peer.setIfIdx(ifIdx)
 }
 
 func (server *BGPServer) clearInterfaceMapForPeer(peerIP string, peer *Peer) {
@@ -895,6 +1281,23 @@ func (server *BGPServer) constructBGPGlobalState(gConf *config.GlobalConfig) {
 	server.BgpConfig.Global.State.EBGPMaxPaths = gConf.EBGPMaxPaths
 	server.BgpConfig.Global.State.EBGPAllowMultipleAS = gConf.EBGPAllowMultipleAS
 	server.BgpConfig.Global.State.IBGPMaxPaths = gConf.IBGPMaxPaths
+	server.BgpConfig.Global.State.GracefulRestart = gConf.GracefulRestart
+	server.BgpConfig.Global.State.GracefulRestartTime = gConf.GracefulRestartTime
+	server.BgpConfig.Global.State.GracefulRestartStalePathTime = gConf.GracefulRestartStalePathTime
+	server.BgpConfig.Global.State.GracefulRestartDeferralTime = gConf.GracefulRestartDeferralTime
+	server.BgpConfig.Global.State.RpkiPreferValid = gConf.RpkiPreferValid
+	server.BgpConfig.Global.State.Dampening = gConf.Dampening
+	server.BgpConfig.Global.State.DampeningHalfLife = gConf.DampeningHalfLife
+	server.BgpConfig.Global.State.DampeningReuse = gConf.DampeningReuse
+	server.BgpConfig.Global.State.DampeningSuppress = gConf.DampeningSuppress
+	server.BgpConfig.Global.State.DampeningMaxSuppressTime = gConf.DampeningMaxSuppressTime
+	server.BgpConfig.Global.State.ConfederationId = gConf.ConfederationId
+	server.BgpConfig.Global.State.ConfederationMembers = gConf.ConfederationMembers
+	server.BgpConfig.Global.State.AlwaysCompareMED = gConf.AlwaysCompareMED
+	server.BgpConfig.Global.State.DeterministicMED = gConf.DeterministicMED
+	server.BgpConfig.Global.State.MEDMissingAsWorst = gConf.MEDMissingAsWorst
+	server.BgpConfig.Global.State.GracefulShutdownTime = gConf.GracefulShutdownTime
+	server.BgpConfig.Global.State.RouteServer = gConf.RouteServer
 }
 
 func (server *BGPServer) listenChannelUpdates() {
@@ -911,6 +1314,9 @@ func (server *BGPServer) listenChannelUpdates() {
 
 			packet.SetNextHopPathAttrs(server.ConnRoutesPath.PathAttrs, gConf.RouterId)
 			server.RemoveRoutesFromAllNeighbor()
+			setGracefulRestartDefaults(&gConf)
+			setDampeningDefaults(&gConf)
+			setGracefulShutdownDefaults(&gConf)
 			server.copyGlobalConf(gConf)
 			server.constructBGPGlobalState(&gConf)
 			for _, peer := range server.PeerMap {
@@ -922,21 +1328,26 @@ func (server *BGPServer) listenChannelUpdates() {
 			server.logger.Info("message received on AddPeerCh")
 			oldPeer := peerUpdate.OldPeer
 			newPeer := peerUpdate.NewPeer
+			if !server.resolveUnnumberedNeighbor(&oldPeer, &newPeer) {
+				break
+			}
+
 			var peer *Peer
 			var ok bool
 			if oldPeer.NeighborAddress != nil {
 				if peer, ok = server.PeerMap[oldPeer.NeighborAddress.String()]; ok {
+					if server.isAuthPasswordChangeOnly(peer, newPeer) &&
+						server.changeNeighborAuthPassword(oldPeer.NeighborAddress.String(), peer, newPeer) {
+						break
+					}
+
 					server.logger.Info(fmt.Sprintln("Clean up peer", oldPeer.NeighborAddress.String()))
+					server.leaveUpdateGroup(peer)
 					peer.Cleanup()
 					server.ProcessRemoveNeighbor(oldPeer.NeighborAddress.String(), peer)
-					if peer.NeighborConf.RunningConf.AuthPassword != "" {
-						err := netUtils.SetTCPListenerMD5(server.listener, oldPeer.NeighborAddress.String(), "")
-						if err != nil {
-							server.logger.Info(fmt.Sprintln("Failed to add MD5 authentication for old neighbor",
-								newPeer.NeighborAddress.String(), "with error", err))
-						}
-					}
+					server.clearNeighborMD5(oldPeer.NeighborAddress.String(), peer)
 					peer.UpdateNeighborConf(newPeer, &server.BgpConfig)
+					server.setNeighborMD5(newPeer.NeighborAddress.String(), peer)
 
 					runtime.Gosched()
 				} else {
@@ -966,21 +1377,17 @@ func (server *BGPServer) listenChannelUpdates() {
 				}
 				server.logger.Info(fmt.Sprintln("Add neighbor, ip:", newPeer.NeighborAddress.String()))
 				peer = NewPeer(server, &server.BgpConfig.Global.Config, groupConfig, newPeer)
-				if peer.NeighborConf.RunningConf.AuthPassword != "" {
-					err := netUtils.SetTCPListenerMD5(server.listener, newPeer.NeighborAddress.String(),
-						peer.NeighborConf.RunningConf.AuthPassword)
-					if err != nil {
-						server.logger.Info(fmt.Sprintln("Failed to add MD5 authentication for neighbor",
-							newPeer.NeighborAddress.String(), "with error", err))
-					}
-				}
+				peer.NeighborConf.Restarting = server.grRestarting
+				server.setNeighborMD5(newPeer.NeighborAddress.String(), peer)
 				server.PeerMap[newPeer.NeighborAddress.String()] = peer
 				server.NeighborMutex.Lock()
 				server.addPeerToList(peer)
 				server.NeighborMutex.Unlock()
 			}
+			peer.NeighborConf.IntfName = server.getLinkLocalIntfName(&peer.NeighborConf.RunningConf)
 			peer.ProcessBfd(true)
 			peer.Init()
+			server.updateListenerTTL()
 
 		case remPeer := <-server.RemPeerCh:
 			server.logger.Info(fmt.Sprintln("Remove Peer:", remPeer))
@@ -990,13 +1397,15 @@ func (server *BGPServer) listenChannelUpdates() {
 					"Peer at that address does not exist,", remPeer))
 				break
 			}
-			server.NeighborMutex.Lock()
-			server.removePeerFromList(peer)
-			server.NeighborMutex.Unlock()
-			delete(server.PeerMap, remPeer)
-			peer.Cleanup()
-			peer.ProcessBfd(false)
-			server.ProcessRemoveNeighbor(remPeer, peer)
+			server.removeNeighbor(remPeer, peer)
+			server.updateListenerTTL()
+
+		case ifIndex := <-server.RemUnnumberedPeerCh:
+			server.logger.Info(fmt.Sprintln("Remove unnumbered Peer on ifIndex:", ifIndex))
+			server.removeUnnumberedNeighbor(ifIndex)
+
+		case linkLocalPeer := <-server.linkLocalCh:
+			server.processLinkLocalNeighbor(linkLocalPeer.intfName, linkLocalPeer.ip)
 
 		case groupUpdate := <-server.AddPeerGroupCh:
 			oldGroupConf := groupUpdate.OldGroup
@@ -1013,15 +1422,20 @@ func (server *BGPServer) listenChannelUpdates() {
 				}
 			}
 
-			if _, ok = server.BgpConfig.PeerGroups[newGroupConf.Name]; !ok {
+			if peerGroup, ok := server.BgpConfig.PeerGroups[newGroupConf.Name]; !ok {
 				server.logger.Info(fmt.Sprintln("Add new peer group with name",
 					newGroupConf.Name))
 				peerGroup := config.PeerGroup{
 					Config: newGroupConf,
 				}
 				server.BgpConfig.PeerGroups[newGroupConf.Name] = &peerGroup
+				server.setPeerGroupMD5(nil, &newGroupConf)
+			} else {
+				server.setPeerGroupMD5(&peerGroup.Config, &newGroupConf)
+				peerGroup.Config = newGroupConf
 			}
 			server.UpdatePeerGroupInPeers(newGroupConf.Name, &newGroupConf)
+			server.updateListenerTTL()
 
 		case groupName := <-server.RemPeerGroupCh:
 			server.logger.Info(fmt.Sprintln("Remove Peer group:", groupName))
@@ -1029,31 +1443,74 @@ func (server *BGPServer) listenChannelUpdates() {
 				server.logger.Info(fmt.Sprintln("Peer group", groupName, "not found"))
 				break
 			}
+			server.setPeerGroupMD5(&server.BgpConfig.PeerGroups[groupName].Config, nil)
 			delete(server.BgpConfig.PeerGroups, groupName)
 			server.UpdatePeerGroupInPeers(groupName, nil)
+			server.updateListenerTTL()
 
 		case tcpConn := <-server.acceptCh:
 			server.logger.Info(fmt.Sprintln("Connected to", tcpConn.RemoteAddr().String()))
 			host, _, _ := net.SplitHostPort(tcpConn.RemoteAddr().String())
+			host, zone := splitHostZone(host)
 			peer, ok := server.PeerMap[host]
 			if !ok {
+				peer = server.createDynamicNeighbor(net.ParseIP(host))
+			}
+			if peer == nil {
 				server.logger.Info(fmt.Sprintln("Can't accept connection.",
 					"Peer is not configured yet", host))
+				if ip := net.ParseIP(host); zone != "" && ip.IsLinkLocalUnicast() {
+					server.processLinkLocalNeighbor(zone, ip)
+				}
 				tcpConn.Close()
 				server.logger.Info(fmt.Sprintln("Closed connection from", host))
 				break
 			}
+			if !server.isNeighborMD5Set(peer) {
+				server.logger.Info(fmt.Sprintln("Can't accept connection from", host,
+					"MD5 authentication is not set for the neighbor"))
+				tcpConn.Close()
+				break
+			}
+			ttl, minTTL := peer.NeighborConf.GetTTL()
+			if err := utils.SetTCPConnTTL(tcpConn, ttl, minTTL); err != nil {
+				server.logger.Info(fmt.Sprintln("Can't accept connection from", host,
+					"failed to set TTL with error", err))
+				tcpConn.Close()
+				break
+			}
 			peer.AcceptConn(tcpConn)
 
 		case peerCommand := <-server.PeerCommandCh:
 			server.logger.Info(fmt.Sprintln("Peer Command received", peerCommand))
+			if peerCommand.Command == config.PeerCommandDrain ||
+				peerCommand.Command == config.PeerCommandUndrain {
+				server.ProcessDrainCommand(peerCommand)
+				break
+			}
+
 			peer, ok := server.PeerMap[peerCommand.IP.String()]
 			if !ok {
 				server.logger.Info(fmt.Sprintf("Failed to apply command %s.",
 					"Peer at that address does not exist, %v\n",
 					peerCommand.Command, peerCommand.IP))
+				break
+			}
+
+			switch peerCommand.Command {
+			case config.PeerCommandSoftResetIn:
+				server.SoftResetIn(peer)
+
+			case config.PeerCommandSoftResetOut:
+				server.SoftResetOut(peer)
+
+			case config.PeerCommandSoftReset:
+				server.SoftResetIn(peer)
+				server.SoftResetOut(peer)
+
+			default:
+				peer.Command(peerCommand.Command, fsm.BGPCmdReasonNone)
 			}
-			peer.Command(peerCommand.Command, fsm.BGPCmdReasonNone)
 
 		case peerFSMConn := <-server.PeerFSMConnCh:
 			server.logger.Info(fmt.Sprintf("Server: Peer %s FSM established/broken",
@@ -1066,27 +1523,37 @@ func (server *BGPServer) listenChannelUpdates() {
 			}
 
 			if peerFSMConn.Established {
+				peer.sentOpen = peerFSMConn.SentOpen
+				peer.rcvdOpen = peerFSMConn.RcvdOpen
 				peer.PeerConnEstablished(peerFSMConn.Conn)
-				addPathsMaxTx := peer.getAddPathsMaxTx()
-				if addPathsMaxTx > server.AddPathCount {
-					server.AddPathCount = addPathsMaxTx
-				}
+				peer.stopDynamicTimer()
+				server.joinUpdateGroup(peer)
+				server.SendBmpPeerUp(peer)
+				server.updateAddPathCount()
 				server.setInterfaceMapForPeer(peerFSMConn.PeerIP, peer)
+				server.ProcessNeighborEstablished(peerFSMConn.PeerIP, peer)
+				server.sendPrefixORF(peer, false)
+				if server.grRestarting {
+					if server.isDeferralComplete() {
+						server.completeGracefulRestart()
+					}
+					break
+				}
 				server.SendAllRoutesToPeer(peer)
+				if peer.NeighborConf.IsGracefulRestartNegotiated() {
+					peer.SendEndOfRIB()
+				}
 			} else {
+				server.SendBmpPeerDown(peer, &peerFSMConn)
+				server.leaveUpdateGroup(peer)
 				peer.PeerConnBroken(true)
-				addPathsMaxTx := peer.getAddPathsMaxTx()
-				if addPathsMaxTx < server.AddPathCount {
-					server.AddPathCount = 0
-					for _, otherPeer := range server.PeerMap {
-						addPathsMaxTx = otherPeer.getAddPathsMaxTx()
-						if addPathsMaxTx > server.AddPathCount {
-							server.AddPathCount = addPathsMaxTx
-						}
-					}
-				}
+				server.updateAddPathCount()
 				server.clearInterfaceMapForPeer(peerFSMConn.PeerIP, peer)
-				server.ProcessRemoveNeighbor(peerFSMConn.PeerIP, peer)
+				if peer.NeighborConf.IsDynamic() {
+					server.removeDynamicNeighbor(peerFSMConn.PeerIP, peer)
+				} else if !server.ProcessNeighborRestart(peerFSMConn.PeerIP, peer, &peerFSMConn) {
+					server.ProcessRemoveNeighbor(peerFSMConn.PeerIP, peer)
+				}
 			}
 
 		case peerIP := <-server.PeerConnEstCh:
@@ -1099,25 +1566,7 @@ func (server *BGPServer) listenChannelUpdates() {
 					"Peer %s does not exist", peerIP))
 				break
 			}
-			reachInfo, err := server.routeMgr.GetNextHopInfo(peerIP)
-			if err != nil {
-				server.logger.Info(fmt.Sprintf(
-					"Server: Peer %s is not reachable", peerIP))
-			} else {
-				// @TODO: jgheewala think of something better for ovsdb....
-				ifIdx := server.IntfMgr.GetIfIndex(int(reachInfo.NextHopIfIndex),
-					int(reachInfo.NextHopIfType))
-				server.logger.Info(fmt.Sprintf("Server: Peer %s IfIdx %d",
-					peerIP, ifIdx))
-				if _, ok := server.IfacePeerMap[ifIdx]; !ok {
-					server.IfacePeerMap[ifIdx] = make([]string, 0)
-					//ifIdx := asicdCommonDefs.GetIfIndexFromIntfIdAndIntfType(int(reachInfo.NextHopIfIndex), int(reachInfo.NextHopIfType))
-				}
-				server.IfacePeerMap[ifIdx] = append(server.IfacePeerMap[ifIdx],
-					peerIP)
-				peer.setIfIdx(ifIdx)
-			}
-
+			server.setInterfaceMapForPeer(peerIP, peer)
 			server.SendAllRoutesToPeer(peer)
 
 		case peerIP := <-server.PeerConnBrokenCh:
@@ -1152,12 +1601,22 @@ func (server *BGPServer) listenChannelUpdates() {
 		case pktInfo := <-server.BGPPktSrcCh:
 			server.logger.Info(fmt.Sprintln("Received BGP message from peer %s",
 				pktInfo.Src))
-			server.ProcessUpdate(pktInfo)
+			if pktInfo.Msg.Header.Type == packet.BGPMsgTypeRouteRefresh {
+				server.ProcessRouteRefresh(pktInfo)
+			} else {
+				server.ProcessUpdate(pktInfo)
+			}
 
 		case reachabilityInfo := <-server.ReachabilityCh:
 			server.logger.Info(fmt.Sprintln("Server: Reachability info for ip",
 				reachabilityInfo.IP))
 
+			if ip := net.ParseIP(reachabilityInfo.IP); ip != nil && ip.IsLinkLocalUnicast() {
+				// Link-local neighbors are always directly connected
+				reachabilityInfo.ReachableCh <- true
+				break
+			}
+
 			_, err := server.routeMgr.GetNextHopInfo(reachabilityInfo.IP)
 			if err != nil {
 				reachabilityInfo.ReachableCh <- false
@@ -1183,6 +1642,76 @@ func (server *BGPServer) listenChannelUpdates() {
 			}
 		case routeInfo := <-server.routesCh:
 			server.ProcessConnectedRoutes(routeInfo.Add, routeInfo.Remove)
+
+		case peerIP := <-server.stalePathsTimerCh:
+			peer, ok := server.PeerMap[peerIP]
+			if !ok || !peer.stalePaths {
+				break
+			}
+			server.logger.Info(fmt.Sprintln("Graceful restart - stale paths timer expired for neighbor",
+				peerIP))
+			server.ProcessRemoveStalePaths(peerIP, peer)
+
+		case refreshStale := <-server.refreshStaleCh:
+			peer, ok := server.PeerMap[refreshStale.peerIP]
+			if !ok || peer.refreshStaleTimers[refreshStale.protoFamily] == nil {
+				break
+			}
+			server.logger.Info(fmt.Sprintln("Enhanced route refresh - EoRR not received from neighbor",
+				refreshStale.peerIP, "for family", refreshStale.protoFamily))
+			server.removeRefreshStaleFamilyPaths(refreshStale.peerIP, peer, refreshStale.protoFamily)
+
+		case peerIP := <-server.drainTimerCh:
+			server.ProcessDrainTimerExpiry(peerIP)
+
+		case <-server.dampeningTicker.C:
+			server.ProcessDampeningReuse()
+
+		case <-server.condAdvTicker.C:
+			server.ProcessConditionalAdvScan()
+
+		case <-server.deferralTimerCh:
+			server.logger.Info(fmt.Sprintln("Graceful restart - selection deferral timer expired"))
+			server.completeGracefulRestart()
+
+		case peerIP := <-server.dynamicTimerCh:
+			server.ProcessDynamicTimerExpiry(peerIP)
+
+		case bmpConf := <-server.AddBmpCollectorCh:
+			server.AddBmpCollector(bmpConf)
+
+		case address := <-server.RemBmpCollectorCh:
+			server.RemoveBmpCollector(address)
+
+		case bmpState := <-server.bmpCollectorCh:
+			server.ProcessBmpCollectorState(bmpState)
+
+		case collector := <-server.bmpStatsCh:
+			server.ProcessBmpStats(collector)
+
+		case rpkiConf := <-server.AddRpkiCacheCh:
+			server.AddRpkiCache(rpkiConf)
+
+		case address := <-server.RemRpkiCacheCh:
+			server.RemoveRpkiCache(address)
+
+		case snapshot := <-server.rpkiSnapshotCh:
+			server.ProcessRpkiCacheSnapshot(snapshot)
+
+		case vrfConf := <-server.AddVrfCh:
+			server.AddVrf(vrfConf)
+
+		case vrfName := <-server.RemVrfCh:
+			server.RemoveVrf(vrfName)
+
+		case evpnConf := <-server.AddEvpnCh:
+			server.AddEvpnInstance(evpnConf)
+
+		case vni := <-server.RemEvpnCh:
+			server.RemoveEvpnInstance(vni)
+
+		case macInfo := <-server.evpnMacCh:
+			server.ProcessLocalMacs(macInfo.Add, macInfo.Remove)
 		}
 	}
 
@@ -1192,6 +1721,7 @@ func (server *BGPServer) StartServer() {
 	gConf := <-server.GlobalConfigCh
 	server.GlobalCfgDone = true
 	server.logger.Info(fmt.Sprintln("Recieved global conf:", gConf))
+	setGracefulRestartDefaults(&gConf)
 	server.BgpConfig.Global.Config = gConf
 	server.constructBGPGlobalState(&gConf)
 	server.BgpConfig.PeerGroups = make(map[string]*config.PeerGroup)
@@ -1209,16 +1739,20 @@ func (server *BGPServer) StartServer() {
 	server.intfCh = make(chan config.IntfStateInfo)
 	// Channel for handling route notifications
 	server.routesCh = make(chan *config.RouteCh)
+	// Channel for handling local MAC notifications on VXLAN segments
+	server.evpnMacCh = make(chan *config.EvpnMacCh)
 
 	server.listener, _ = server.createListener()
 	go server.listenForPeers(server.listener, server.acceptCh)
 
 	server.logger.Info("Start all managers and initialize API Layer")
-	api.Init(server.bfdCh, server.intfCh, server.routesCh)
+	api.Init(server.bfdCh, server.intfCh, server.routesCh, server.evpnMacCh)
 	server.IntfMgr.Start()
 	server.routeMgr.Start()
 	server.bfdMgr.Start()
+	server.vtepMgr.Start()
 	server.SetupRedistribution(gConf)
+	server.startGracefulRestart()
 
 	/*  ALERT: StartServer is a go routine and hence do not have any other go routine where
 	 *	   you are making calls to other client. FlexSwitch uses thrift for rpc and hence
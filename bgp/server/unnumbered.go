@@ -0,0 +1,275 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// unnumbered.go
+package server
+
+import (
+	"fmt"
+	"l3/bgp/config"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	icmpv6TypeRouterSolicitation  = 133
+	icmpv6TypeRouterAdvertisement = 134
+	icmpv6HopLimit                = 255
+	routerAdvertisementInterval   = 10 // seconds
+)
+
+var allNodesAddr = net.ParseIP("ff02::1")
+var allRoutersAddr = net.ParseIP("ff02::2")
+
+/*  Unnumbered neighbors are configured with the ifIndex of the interface and no neighbor
+ *  address. The IPv6 link-local address of the neighbor is learnt from the router
+ *  advertisements received on the interface, the session is brought up once the address
+ *  is known. The router advertisements are also sent on the interface so that the
+ *  neighbor can discover the local link-local address.
+ */
+type unnumberedNeighbor struct {
+	conf     config.NeighborConfig
+	intfName string
+	peerIP   net.IP
+	stopCh   chan bool
+}
+
+type linkLocalNeighbor struct {
+	intfName string
+	ip       net.IP
+}
+
+func splitHostZone(host string) (string, string) {
+	if idx := strings.LastIndex(host, "%"); idx >= 0 {
+		return host[:idx], host[idx+1:]
+	}
+	return host, ""
+}
+
+func (server *BGPServer) getLinkLocalIntfName(peerConf *config.NeighborConfig) string {
+	if peerConf.IfIndex == 0 || !peerConf.NeighborAddress.IsLinkLocalUnicast() {
+		return ""
+	}
+
+	if unnumbered, ok := server.unnumberedNeighbors[peerConf.IfIndex]; ok {
+		return unnumbered.intfName
+	}
+
+	intfName, err := server.IntfMgr.GetIntfName(peerConf.IfIndex)
+	if err != nil {
+		server.logger.Err(fmt.Sprintln("Failed to get the interface name for ifIndex", peerConf.IfIndex,
+			"of neighbor", peerConf.NeighborAddress, "error:", err))
+		return ""
+	}
+	return intfName
+}
+
+/*  resolveUnnumberedNeighbor sets the neighbor address of the unnumbered neighbors to the
+ *  discovered link-local address. It returns false if the address is not discovered yet.
+ */
+func (server *BGPServer) resolveUnnumberedNeighbor(oldPeer *config.NeighborConfig,
+	newPeer *config.NeighborConfig) bool {
+	if oldPeer.IsUnnumbered() {
+		if oldPeer.IfIndex != newPeer.IfIndex || !newPeer.IsUnnumbered() {
+			server.removeUnnumberedNeighbor(oldPeer.IfIndex)
+		} else if unnumbered, ok := server.unnumberedNeighbors[oldPeer.IfIndex]; ok {
+			oldPeer.NeighborAddress = unnumbered.peerIP
+		}
+	}
+
+	if !newPeer.IsUnnumbered() {
+		return true
+	}
+
+	unnumbered, ok := server.unnumberedNeighbors[newPeer.IfIndex]
+	if !ok {
+		intfName, err := server.IntfMgr.GetIntfName(newPeer.IfIndex)
+		if err != nil {
+			server.logger.Err(fmt.Sprintln("Failed to add unnumbered neighbor on ifIndex", newPeer.IfIndex,
+				"error:", err))
+			return false
+		}
+
+		unnumbered = &unnumberedNeighbor{
+			intfName: intfName,
+		}
+		server.unnumberedNeighbors[newPeer.IfIndex] = unnumbered
+		if !server.startLinkLocalDiscovery(unnumbered) {
+			delete(server.unnumberedNeighbors, newPeer.IfIndex)
+			return false
+		}
+	}
+
+	unnumbered.conf = *newPeer
+	if unnumbered.peerIP == nil {
+		server.logger.Info(fmt.Sprintln("Unnumbered neighbor on interface", unnumbered.intfName,
+			"- waiting to discover the link-local address of the neighbor"))
+		return false
+	}
+
+	newPeer.NeighborAddress = unnumbered.peerIP
+	return true
+}
+
+func (server *BGPServer) removeUnnumberedNeighbor(ifIndex int32) {
+	unnumbered, ok := server.unnumberedNeighbors[ifIndex]
+	if !ok {
+		server.logger.Info(fmt.Sprintln("Unnumbered neighbor on ifIndex", ifIndex, "not found"))
+		return
+	}
+
+	server.logger.Info(fmt.Sprintln("Remove unnumbered neighbor on interface", unnumbered.intfName))
+	close(unnumbered.stopCh)
+	delete(server.unnumberedNeighbors, ifIndex)
+	if unnumbered.peerIP != nil {
+		peerIP := unnumbered.peerIP.String()
+		go func() {
+			server.RemPeerCh <- peerIP
+		}()
+	}
+}
+
+/*  processLinkLocalNeighbor is called when a neighbor is discovered on an interface. The
+ *  unnumbered neighbor on the interface is added with the discovered address, replacing
+ *  the neighbor with the previously discovered address if there was one.
+ */
+func (server *BGPServer) processLinkLocalNeighbor(intfName string, ip net.IP) {
+	for _, unnumbered := range server.unnumberedNeighbors {
+		if unnumbered.intfName != intfName {
+			continue
+		}
+
+		if unnumbered.peerIP.Equal(ip) {
+			return
+		}
+
+		server.logger.Info(fmt.Sprintln("Unnumbered neighbor on interface", intfName,
+			"discovered at address", ip, "old address", unnumbered.peerIP))
+		oldPeerIP := unnumbered.peerIP
+		unnumbered.peerIP = ip
+		peerConf := unnumbered.conf
+		go func() {
+			if oldPeerIP != nil {
+				server.RemPeerCh <- oldPeerIP.String()
+			}
+			server.AddPeerCh <- PeerUpdate{config.NeighborConfig{}, peerConf, make([]bool, 0)}
+		}()
+		return
+	}
+}
+
+func (server *BGPServer) startLinkLocalDiscovery(unnumbered *unnumberedNeighbor) bool {
+	if server.raConn == nil {
+		conn, err := net.ListenPacket("ip6:ipv6-icmp", "::")
+		if err != nil {
+			server.logger.Err(fmt.Sprintln("Failed to open ICMPv6 socket for neighbor discovery, error:", err))
+			return false
+		}
+
+		raConn := ipv6.NewPacketConn(conn)
+		var filter ipv6.ICMPFilter
+		filter.SetAll(true)
+		filter.Accept(ipv6.ICMPTypeRouterAdvertisement)
+		if err = raConn.SetICMPFilter(&filter); err != nil {
+			server.logger.Err(fmt.Sprintln("Failed to set ICMPv6 filter, error:", err))
+			raConn.Close()
+			return false
+		}
+		if err = raConn.SetControlMessage(ipv6.FlagInterface|ipv6.FlagHopLimit, true); err != nil {
+			server.logger.Err(fmt.Sprintln("Failed to set ICMPv6 control messages, error:", err))
+			raConn.Close()
+			return false
+		}
+		raConn.SetMulticastHopLimit(icmpv6HopLimit)
+		server.raConn = raConn
+		go server.listenForRouterAdvertisements(raConn)
+	}
+
+	unnumbered.stopCh = make(chan bool)
+	go server.sendRouterAdvertisements(server.raConn, unnumbered.intfName, unnumbered.stopCh)
+	return true
+}
+
+func (server *BGPServer) listenForRouterAdvertisements(raConn *ipv6.PacketConn) {
+	buf := make([]byte, 1500)
+	for {
+		n, cm, src, err := raConn.ReadFrom(buf)
+		if err != nil {
+			server.logger.Err(fmt.Sprintln("Failed to read router advertisements, error:", err))
+			return
+		}
+
+		if n == 0 || buf[0] != icmpv6TypeRouterAdvertisement || cm == nil || cm.HopLimit != icmpv6HopLimit {
+			continue
+		}
+
+		srcAddr, ok := src.(*net.IPAddr)
+		if !ok || !srcAddr.IP.IsLinkLocalUnicast() {
+			continue
+		}
+
+		intf, err := net.InterfaceByIndex(cm.IfIndex)
+		if err != nil {
+			continue
+		}
+		server.linkLocalCh <- linkLocalNeighbor{intf.Name, srcAddr.IP}
+	}
+}
+
+/*  Send a router solicitation followed by periodic router advertisements with zero router
+ *  lifetime on the interface. The zero lifetime makes sure the neighbor doesn't use this
+ *  router as a default router.
+ */
+func (server *BGPServer) sendRouterAdvertisements(raConn *ipv6.PacketConn, intfName string, stopCh chan bool) {
+	intf, err := net.InterfaceByName(intfName)
+	if err != nil {
+		server.logger.Err(fmt.Sprintln("Failed to find interface", intfName, "error:", err))
+		return
+	}
+
+	cm := &ipv6.ControlMessage{HopLimit: icmpv6HopLimit, IfIndex: intf.Index}
+	rs := []byte{icmpv6TypeRouterSolicitation, 0, 0, 0, 0, 0, 0, 0}
+	if _, err = raConn.WriteTo(rs, cm, &net.IPAddr{IP: allRoutersAddr, Zone: intfName}); err != nil {
+		server.logger.Err(fmt.Sprintln("Failed to send router solicitation on interface", intfName,
+			"error:", err))
+	}
+
+	ra := []byte{icmpv6TypeRouterAdvertisement, 0, 0, 0, 64, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	ticker := time.NewTicker(time.Duration(routerAdvertisementInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		if _, err = raConn.WriteTo(ra, cm, &net.IPAddr{IP: allNodesAddr, Zone: intfName}); err != nil {
+			server.logger.Err(fmt.Sprintln("Failed to send router advertisement on interface", intfName,
+				"error:", err))
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			return
+		}
+	}
+}
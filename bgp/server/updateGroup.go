@@ -0,0 +1,732 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// updateGroup.go
+package server
+
+import (
+	"fmt"
+	"l3/bgp/config"
+	"l3/bgp/packet"
+	bgppolicy "l3/bgp/policy"
+	bgprib "l3/bgp/rib"
+	"net"
+	"sync/atomic"
+	"utils/logging"
+)
+
+/*  Update groups - The established peers with the same outbound policy, AS, add path,
+ *  prefix ORF and capability settings are grouped in an update group. The group keeps
+ *  the Adj-RIB-Out, builds and encodes the Update messages once and sends the encoded
+ *  messages to all its members. The path attrs are updated with the config of the
+ *  group leader, which is the same for all the members. Routes are not sent back to
+ *  the peer they were received from, that peer gets a withdraw for them instead.
+ */
+type updateGroupKey struct {
+	internal        bool
+	confedExternal  bool
+	rrClient        bool
+	localAS         uint32
+	asSize          uint8
+	localAddress    string
+	extendedNextHop bool
+	addPathsMaxTx   int
+	addPathsTxModes string
+	exportPolicy    string
+	families        string
+	prefixLimitsOut string
+	prefixORF       string
+	asPathOptions   string
+	defaultRoute    string
+	advertisePolicy string
+	draining        bool
+	routeServer     string
+	policyNeighbor  string
+}
+
+type UpdateGroup struct {
+	server        *BGPServer
+	logger        *logging.Writer
+	id            uint32
+	key           updateGroupKey
+	members       []*Peer
+	ribOut        map[string]map[uint32]*bgprib.Path
+	prefixORF     map[uint32][]*packet.AddressPrefixORFEntry
+	defaultRoutes map[uint32]bool
+	advertiseCond bool
+	rsRib         *bgprib.AdjRib
+	exportResults map[*bgprib.Path]*bgppolicy.RoutePolicyResult
+}
+
+func NewUpdateGroup(server *BGPServer, id uint32, key updateGroupKey) *UpdateGroup {
+	return &UpdateGroup{
+		server:        server,
+		logger:        server.logger,
+		id:            id,
+		key:           key,
+		members:       make([]*Peer, 0),
+		ribOut:        make(map[string]map[uint32]*bgprib.Path),
+		prefixORF:     make(map[uint32][]*packet.AddressPrefixORFEntry),
+		defaultRoutes: make(map[uint32]bool),
+		advertiseCond: true,
+		exportResults: make(map[*bgprib.Path]*bgppolicy.RoutePolicyResult),
+	}
+}
+
+func (g *UpdateGroup) leader() *Peer {
+	return g.members[0]
+}
+
+func (g *UpdateGroup) addMember(peer *Peer) {
+	g.members = append(g.members, peer)
+	peer.updateGroup = g
+}
+
+func (g *UpdateGroup) removeMember(peer *Peer) {
+	for idx, member := range g.members {
+		if member == peer {
+			g.members = append(g.members[:idx], g.members[idx+1:]...)
+			break
+		}
+	}
+	peer.updateGroup = nil
+}
+
+// Returns the max number of paths sent for the destination, 0 when its family is sent without path ids
+func (g *UpdateGroup) getAddPathsTx(dest *bgprib.Destination) int {
+	if !g.leader().NeighborConf.IsAddPathsTxNegotiated(packet.GetNLRIProtocolFamily(dest.IPPrefix)) {
+		return 0
+	}
+	return g.key.addPathsMaxTx
+}
+
+func (g *UpdateGroup) clearRibOut() {
+	for ip, pathIdMap := range g.ribOut {
+		for pathId, _ := range pathIdMap {
+			delete(g.ribOut[ip], pathId)
+		}
+		delete(g.ribOut, ip)
+	}
+}
+
+func encodeUpdateMsgs(logger *logging.Writer, bgpMsg *packet.BGPMessage) [][]byte {
+	updateMsgs := packet.ConstructMaxSizedUpdatePackets(bgpMsg)
+	pkts := make([][]byte, 0, len(updateMsgs))
+	for _, updateMsg := range updateMsgs {
+		pkt, err := updateMsg.Encode()
+		if err != nil {
+			logger.Err(fmt.Sprintln("Failed to encode Update message, err:", err))
+			continue
+		}
+		pkts = append(pkts, pkt)
+	}
+	return pkts
+}
+
+func isPathFromPeer(path *bgprib.Path, peer *Peer) bool {
+	return path != nil && path.NeighborConf != nil &&
+		peer.NeighborConf.RunningConf.NeighborAddress.String() ==
+			path.NeighborConf.RunningConf.NeighborAddress.String()
+}
+
+// Withdraws the routes of an update from the peer that sent the path of the update
+func (g *UpdateGroup) sendWithdrawToPathPeer(peer *Peer, nlriList []packet.NLRI) {
+	if len(nlriList) == 0 {
+		return
+	}
+
+	var pathAttrs []packet.BGPPathAttr
+	afi, safi := packet.GetAfiSafi(packet.GetNLRIProtocolFamily(nlriList[0]))
+	if afi != packet.AfiIP || safi != packet.SafiUnicast {
+		pathAttrs = []packet.BGPPathAttr{packet.NewBGPPathAttrMPUnreachNLRI(afi, safi)}
+	}
+	withdrawMsg := packet.NewBGPUpdateMessage(nlriList, pathAttrs, nil)
+	peer.sendEncodedUpdateMsgs(encodeUpdateMsgs(g.logger, withdrawMsg))
+}
+
+func (g *UpdateGroup) sendUpdateMsg(msg *packet.BGPMessage, path *bgprib.Path, members []*Peer) {
+	leader := g.leader()
+	if path != nil && path.NeighborConf != nil && path.NeighborConf.IsInternal() {
+		if leader.NeighborConf.IsInternal() && !path.NeighborConf.IsRouteReflectorClient() &&
+			!leader.NeighborConf.IsRouteReflectorClient() {
+			return
+		}
+	}
+
+	if !leader.updatePathAttrs(msg, path, g.getExportPolicyResult(path)) {
+		return
+	}
+
+	nlriList := msg.Body.(*packet.BGPUpdate).NLRI
+	pkts := encodeUpdateMsgs(g.logger, msg)
+	for _, member := range members {
+		// Don't send the update to the peer that sent the update.
+		if isPathFromPeer(path, member) {
+			g.sendWithdrawToPathPeer(member, nlriList)
+			continue
+		}
+		member.sendEncodedUpdateMsgs(pkts)
+	}
+}
+
+func (g *UpdateGroup) isAdvertisable(path *bgprib.Path) bool {
+	leader := g.leader()
+	if path != nil && path.NeighborConf != nil {
+		if path.NeighborConf.IsInternal() {
+
+			if leader.NeighborConf.IsInternal() && !path.NeighborConf.IsRouteReflectorClient() &&
+				!leader.NeighborConf.IsRouteReflectorClient() {
+				return false
+			}
+		}
+	}
+
+	if path != nil {
+		if packet.HasCommunity(path.PathAttrs, packet.BGPCommunityNoAdvertise) {
+			return false
+		}
+
+		if leader.NeighborConf.IsExternal() &&
+			(packet.HasCommunity(path.PathAttrs, packet.BGPCommunityNoExport) ||
+				packet.HasCommunity(path.PathAttrs, packet.BGPCommunityNoExportSubconfed)) {
+			return false
+		}
+
+		if leader.NeighborConf.IsConfedExternal() &&
+			packet.HasCommunity(path.PathAttrs, packet.BGPCommunityNoExportSubconfed) {
+			return false
+		}
+
+		if result := g.getExportPolicyResult(path); result != nil && !result.Accepted {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*  Returns the result of the export policy of the group for the path. The policy is evaluated
+ *  once per path for an update, the result decides if the path is advertised and its actions
+ *  are applied to the path attrs sent to the members. The export policy of a route server
+ *  client is applied to the paths added to its view instead.
+ */
+func (g *UpdateGroup) getExportPolicyResult(path *bgprib.Path) *bgppolicy.RoutePolicyResult {
+	if g.rsRib != nil || path == nil {
+		return nil
+	}
+
+	result, ok := g.exportResults[path]
+	if !ok {
+		result = g.leader().evaluateExportPolicy(path)
+		g.exportResults[path] = result
+	}
+	return result
+}
+
+func (g *UpdateGroup) clearExportPolicyResults() {
+	for path, _ := range g.exportResults {
+		delete(g.exportResults, path)
+	}
+}
+
+// Returns false when the prefix of the destination is filtered by the prefix ORF of the group
+func (g *UpdateGroup) isPermittedByORF(dest *bgprib.Destination) bool {
+	entries, ok := g.prefixORF[packet.GetNLRIProtocolFamily(dest.IPPrefix)]
+	if !ok {
+		return true
+	}
+	return packet.IsPrefixPermittedByORF(entries, dest.IPPrefix)
+}
+
+func (g *UpdateGroup) calculateAddPathsAdvertisements(dest *bgprib.Destination, path *bgprib.Path, newUpdated map[*bgprib.Path][]packet.NLRI,
+	withdrawList []packet.NLRI, addPathsTx int) (map[*bgprib.Path][]packet.NLRI, []packet.NLRI) {
+	pathIdMap := make(map[uint32]*bgprib.Path)
+	ip := dest.IPPrefix.Prefix.String()
+
+	if _, ok := g.ribOut[ip]; !ok {
+		g.logger.Info(fmt.Sprintf("Update group %d: calculateAddPathsAdvertisements - processing updates, "+
+			"dest %s not found in rib out", g.id, ip))
+		g.ribOut[ip] = make(map[uint32]*bgprib.Path)
+	}
+
+	permitted := g.isPermittedByORF(dest) && !g.isWithheld(dest, path)
+	if permitted && g.isAdvertisable(path) {
+		route := dest.LocRibPathRoute
+		if path != nil { // Loc-RIB path changed
+			if _, ok := newUpdated[path]; !ok {
+				newUpdated[path] = make([]packet.NLRI, 0)
+			}
+			nlri := packet.NewExtNLRI(route.OutPathId, *dest.IPPrefix)
+			newUpdated[path] = append(newUpdated[path], nlri)
+		} else {
+			path = dest.LocRibPath
+		}
+		pathIdMap[route.OutPathId] = path
+	}
+
+	txMode := g.leader().NeighborConf.GetAddPathsTxMode(packet.GetNLRIProtocolFamily(dest.IPPrefix))
+	for _, addPath := range dest.SelectAddPaths(txMode) {
+		if !permitted {
+			break
+		}
+		if txMode == config.AddPathsTxModeBestN && len(pathIdMap) >= (addPathsTx-1) {
+			break
+		}
+		route := dest.GetPathRoute(addPath)
+		if route != nil && g.isAdvertisable(addPath) {
+			pathIdMap[route.OutPathId] = addPath
+		}
+	}
+
+	ribPathMap, _ := g.ribOut[ip]
+	for ribPathId, ribPath := range ribPathMap {
+		if path, ok := pathIdMap[ribPathId]; !ok {
+			nlri := packet.NewExtNLRI(ribPathId, *dest.IPPrefix)
+			withdrawList = append(withdrawList, nlri)
+			delete(g.ribOut[ip], ribPathId)
+		} else if ribPath == path {
+			delete(pathIdMap, ribPathId)
+		} else if ribPath != path {
+			if _, ok := newUpdated[path]; !ok {
+				newUpdated[path] = make([]packet.NLRI, 0)
+			}
+			nlri := packet.NewExtNLRI(ribPathId, *dest.IPPrefix)
+			newUpdated[path] = append(newUpdated[path], nlri)
+			g.ribOut[ip][ribPathId] = path
+			delete(pathIdMap, ribPathId)
+		}
+	}
+
+	for pathId, path := range pathIdMap {
+		if _, ok := newUpdated[path]; !ok {
+			newUpdated[path] = make([]packet.NLRI, 0)
+		}
+		nlri := packet.NewExtNLRI(pathId, *dest.IPPrefix)
+		newUpdated[path] = append(newUpdated[path], nlri)
+		g.ribOut[ip][pathId] = path
+		delete(pathIdMap, pathId)
+	}
+
+	return newUpdated, withdrawList
+}
+
+func (g *UpdateGroup) SendUpdate(updated map[*bgprib.Path][]*bgprib.Destination,
+	withdrawn []*bgprib.Destination, withdrawPath *bgprib.Path,
+	updatedAddPaths []*bgprib.Destination) {
+	g.logger.Info(fmt.Sprintf("Update group %d: Send update message valid routes:%v, withdraw routes:%v",
+		g.id, updated, withdrawn))
+	if len(g.members) == 0 {
+		g.logger.Err(fmt.Sprintf("Update group %d: Can't send Update message, the group has no members",
+			g.id))
+		return
+	}
+
+	withdrawList := make([]packet.NLRI, 0)
+	newUpdated := make(map[*bgprib.Path][]packet.NLRI)
+	if len(withdrawn) > 0 {
+		for _, dest := range withdrawn {
+			if dest != nil && !g.isDefaultOriginated(dest) && !isLabeledUnicastDest(dest) {
+				ip := dest.IPPrefix.Prefix.String()
+				if addPathsTx := g.getAddPathsTx(dest); addPathsTx > 0 {
+					pathIdMap, ok := g.ribOut[ip]
+					if !ok {
+						g.logger.Err(fmt.Sprintf("Update group %d: SendUpdate - processing withdraws, "+
+							"dest %s not found in rib out", g.id, ip))
+						continue
+					}
+					for pathId, _ := range pathIdMap {
+						nlri := packet.NewExtNLRI(pathId, *dest.IPPrefix)
+						withdrawList = append(withdrawList, nlri)
+					}
+					delete(g.ribOut, ip)
+				} else {
+					withdrawList = append(withdrawList, dest.IPPrefix)
+					delete(g.ribOut, ip)
+				}
+			}
+		}
+	}
+
+	outCounts := g.getRibOutCounts()
+	for path, destinations := range updated {
+		for _, dest := range destinations {
+			if dest != nil && !g.isDefaultOriginated(dest) && !isLabeledUnicastDest(dest) {
+				ip := dest.IPPrefix.Prefix.String()
+				if addPathsTx := g.getAddPathsTx(dest); addPathsTx > 0 {
+					if !g.canAdvertiseNewPrefix(dest, outCounts) {
+						continue
+					}
+					newUpdated, withdrawList =
+						g.calculateAddPathsAdvertisements(dest, path,
+							newUpdated, withdrawList, addPathsTx)
+				} else {
+					if !g.isAdvertisable(path) || !g.isPermittedByORF(dest) || g.isWithheld(dest, path) {
+						if _, ok := g.ribOut[ip]; ok {
+							withdrawList = append(withdrawList, dest.IPPrefix)
+							delete(g.ribOut, ip)
+						}
+					} else if g.canAdvertiseNewPrefix(dest, outCounts) {
+						route := dest.LocRibPathRoute
+						pathId := route.OutPathId
+						if _, ok := g.ribOut[ip]; !ok {
+							g.ribOut[ip] = make(map[uint32]*bgprib.Path)
+						}
+						for ribPathId, _ := range g.ribOut[ip] {
+							if pathId != ribPathId {
+								delete(g.ribOut[ip], ribPathId)
+							}
+						}
+						if ribPath, ok := g.ribOut[ip][pathId]; !ok ||
+							ribPath != path {
+							if _, ok := newUpdated[path]; !ok {
+								newUpdated[path] = make([]packet.NLRI, 0)
+							}
+							newUpdated[path] =
+								append(newUpdated[path], dest.IPPrefix)
+						}
+						g.ribOut[ip][pathId] = path
+					}
+				}
+			}
+		}
+	}
+
+	for _, dest := range updatedAddPaths {
+		addPathsTx := g.getAddPathsTx(dest)
+		if addPathsTx == 0 || g.isDefaultOriginated(dest) || !g.canAdvertiseNewPrefix(dest, outCounts) {
+			continue
+		}
+		newUpdated, withdrawList = g.calculateAddPathsAdvertisements(dest, nil,
+			newUpdated, withdrawList, addPathsTx)
+	}
+
+	g.sendUpdates(withdrawList, withdrawPath, newUpdated, g.members)
+}
+
+func (g *UpdateGroup) sendUpdates(withdrawList []packet.NLRI, withdrawPath *bgprib.Path,
+	newUpdated map[*bgprib.Path][]packet.NLRI, members []*Peer) {
+	leader := g.leader()
+	if len(withdrawList) > 0 {
+		for protoFamily, nlriList := range splitNLRIByProtocolFamily(withdrawList) {
+			afi, safi := packet.GetAfiSafi(protoFamily)
+			if !leader.NeighborConf.IsProtocolFamilyNegotiated(afi, safi) {
+				continue
+			}
+
+			g.logger.Info(fmt.Sprintf("Update group %d: Send update message withdraw routes:%+v",
+				g.id, nlriList))
+			var pathAttrs []packet.BGPPathAttr
+			if afi != packet.AfiIP || safi != packet.SafiUnicast {
+				pathAttrs = []packet.BGPPathAttr{packet.NewBGPPathAttrMPUnreachNLRI(afi, safi)}
+			}
+			updateMsg := packet.NewBGPUpdateMessage(nlriList, pathAttrs, nil)
+			g.sendUpdateMsg(updateMsg.Clone(), withdrawPath, members)
+		}
+	}
+
+	for path, pathNLRIList := range newUpdated {
+		for protoFamily, nlriList := range splitNLRIByProtocolFamily(pathNLRIList) {
+			afi, safi := packet.GetAfiSafi(protoFamily)
+			if !leader.NeighborConf.IsProtocolFamilyNegotiated(afi, safi) {
+				continue
+			}
+
+			g.logger.Info(fmt.Sprintf("Update group %d: Send update message valid routes:%+v",
+				g.id, nlriList))
+			var pathAttrs []packet.BGPPathAttr
+			if protoFamily == packet.GetProtocolFamily(packet.AfiIP, packet.SafiUnicast) && g.key.extendedNextHop {
+				pathAttrs = packet.ConstructMPPathAttrs(path.PathAttrs, afi, safi)
+			} else {
+				pathAttrs = packet.ConstructPathAttrsForFamily(path.PathAttrs, afi, safi)
+			}
+			updateMsg := packet.NewBGPUpdateMessage(make([]packet.NLRI, 0), pathAttrs, nlriList)
+			g.sendUpdateMsg(updateMsg.Clone(), path, members)
+		}
+	}
+	g.clearExportPolicyResults()
+}
+
+// Sends the routes in the rib out of the group to a peer that joined the group
+func (g *UpdateGroup) sendRibOutToPeer(peer *Peer) {
+	newUpdated := make(map[*bgprib.Path][]packet.NLRI)
+	for ip, pathIdMap := range g.ribOut {
+		dest := g.locRib().GetDestFromIPAndLen(ip, 0)
+		if dest == nil {
+			continue
+		}
+
+		for pathId, path := range pathIdMap {
+			var nlri packet.NLRI = dest.IPPrefix
+			if g.getAddPathsTx(dest) > 0 {
+				nlri = packet.NewExtNLRI(pathId, *dest.IPPrefix)
+			}
+			newUpdated[path] = append(newUpdated[path], nlri)
+		}
+	}
+
+	g.logger.Info(fmt.Sprintf("Update group %d: Send %d paths in rib out to neighbor %s", g.id, len(newUpdated),
+		peer.NeighborConf.Neighbor.NeighborAddress))
+	g.sendUpdates(nil, nil, newUpdated, []*Peer{peer})
+	g.sendDefaultRoutesToPeer(peer)
+}
+
+// Returns the number of prefixes in rib out per address family when an outbound prefix limit is set
+func (g *UpdateGroup) getRibOutCounts() map[uint32]uint32 {
+	limitSet := false
+	for _, limit := range g.leader().NeighborConf.PrefixLimits {
+		if limit.MaxPrefixesOut != 0 {
+			limitSet = true
+			break
+		}
+	}
+	if !limitSet {
+		return nil
+	}
+
+	outCounts := make(map[uint32]uint32)
+	for ip, pathIdMap := range g.ribOut {
+		if len(pathIdMap) == 0 {
+			continue
+		}
+		if net.ParseIP(ip).To4() == nil {
+			outCounts[packet.GetProtocolFamily(packet.AfiIP6, packet.SafiUnicast)]++
+		} else {
+			outCounts[packet.GetProtocolFamily(packet.AfiIP, packet.SafiUnicast)]++
+		}
+	}
+	return outCounts
+}
+
+func (g *UpdateGroup) canAdvertiseNewPrefix(dest *bgprib.Destination, outCounts map[uint32]uint32) bool {
+	if outCounts == nil {
+		return true
+	}
+
+	ip := dest.IPPrefix.Prefix.String()
+	if pathIdMap, ok := g.ribOut[ip]; ok && len(pathIdMap) > 0 {
+		return true
+	}
+
+	protoFamily := packet.GetNLRIProtocolFamily(dest.IPPrefix)
+	maxPrefixesOut, warningOnly := g.leader().NeighborConf.GetMaxPrefixesOut(protoFamily)
+	if maxPrefixesOut != 0 && outCounts[protoFamily] >= maxPrefixesOut {
+		afi, safi := packet.GetAfiSafi(protoFamily)
+		if !warningOnly {
+			g.logger.Warning(fmt.Sprintf("Update group %d AFI %d SAFI %d Number of prefixes advertised %d reached "+
+				"the max prefix limit %d, can't advertise %s", g.id, afi, safi,
+				outCounts[protoFamily], maxPrefixesOut, ip))
+			return false
+		}
+		g.logger.Warning(fmt.Sprintf("Update group %d AFI %d SAFI %d Number of prefixes advertised %d exceeds the "+
+			"max prefix limit %d, warning only", g.id, afi, safi,
+			outCounts[protoFamily], maxPrefixesOut))
+	}
+	outCounts[protoFamily]++
+	return true
+}
+
+func (p *Peer) getUpdateGroupKey() updateGroupKey {
+	prefixLimitsOut := make(map[uint32]string)
+	for protoFamily, limit := range p.NeighborConf.PrefixLimits {
+		if limit.MaxPrefixesOut != 0 {
+			prefixLimitsOut[protoFamily] = fmt.Sprint(limit.MaxPrefixesOut, limit.WarningOnly)
+		}
+	}
+
+	conf := &p.NeighborConf.RunningConf
+	var routeServer string
+	if p.NeighborConf.IsRouteServerClient() {
+		routeServer = p.NeighborConf.Neighbor.NeighborAddress.String()
+	}
+	return updateGroupKey{
+		internal:        p.NeighborConf.IsInternal(),
+		confedExternal:  p.NeighborConf.IsConfedExternal(),
+		rrClient:        p.NeighborConf.IsRouteReflectorClient(),
+		localAS:         p.NeighborConf.RunningConf.LocalAS,
+		asSize:          p.NeighborConf.ASSize,
+		localAddress:    p.NeighborConf.Neighbor.Transport.Config.LocalAddress.String(),
+		extendedNextHop: p.isExtendedNextHopUsed(),
+		addPathsMaxTx:   p.getAddPathsMaxTx(),
+		addPathsTxModes: fmt.Sprint(p.getAddPathsTxModes()),
+		exportPolicy:    p.NeighborConf.RunningConf.ExportPolicy,
+		families:        fmt.Sprint(p.NeighborConf.PeerAfiSafiMap),
+		prefixLimitsOut: fmt.Sprint(prefixLimitsOut),
+		prefixORF:       fmt.Sprint(p.prefixORF),
+		asPathOptions:   p.getASPathOptions(),
+		defaultRoute:    fmt.Sprint(p.NeighborConf.DefaultRouteFamilies, conf.DefaultOriginatePolicy),
+		advertisePolicy: fmt.Sprint(conf.AdvertisePolicy, conf.AdvertiseExistPolicy, conf.AdvertiseNonExistPolicy),
+		draining:        p.NeighborConf.Neighbor.State.Draining,
+		routeServer:     routeServer,
+		policyNeighbor:  p.getPolicyNeighbor(),
+	}
+}
+
+/*  Returns the address of the peer when its export or advertise policy depends on the neighbor
+ *  the routes are sent to. The policies are evaluated for the leader of the group, such a peer
+ *  has its own update group.
+ */
+func (p *Peer) getPolicyNeighbor() string {
+	conf := &p.NeighborConf.RunningConf
+	for _, policyName := range []string{conf.ExportPolicy, conf.AdvertisePolicy} {
+		if policyName != "" && p.Server.bgpPE.RoutePolicyDB.UsesNeighbor(policyName) {
+			return p.NeighborConf.Neighbor.NeighborAddress.String()
+		}
+	}
+	return ""
+}
+
+// getASPathOptions returns the options that change the AS path of the updates sent to the peer
+func (p *Peer) getASPathOptions() string {
+	conf := &p.NeighborConf.RunningConf
+	var overrideAS uint32
+	if conf.ASOverride {
+		overrideAS = conf.PeerAS
+	}
+	return fmt.Sprint(conf.RemovePrivateAS, overrideAS, p.NeighborConf.IsLocalASPrependedOut())
+}
+
+func (p *Peer) sendEncodedUpdateMsgs(pkts [][]byte) {
+	if len(pkts) == 0 {
+		return
+	}
+
+	atomic.AddUint32(&p.NeighborConf.Neighbor.State.Queues.Output, 1)
+	p.fsmManager.SendEncodedUpdateMsgs(pkts)
+}
+
+func (server *BGPServer) joinUpdateGroup(peer *Peer) {
+	key := peer.getUpdateGroupKey()
+	server.updateGroupMutex.Lock()
+	group, ok := server.updateGroups[key]
+	if !ok {
+		server.updateGroupId++
+		group = NewUpdateGroup(server, server.updateGroupId, key)
+		server.updateGroups[key] = group
+		// The group keeps the prefix ORF it was created with, a deferred ORF of the
+		// peer takes effect when the peer moves to another group
+		for protoFamily, entries := range peer.prefixORF {
+			group.prefixORF[protoFamily] = entries
+		}
+		server.logger.Info(fmt.Sprintln("Created update group", group.id, "for neighbor",
+			peer.NeighborConf.Neighbor.NeighborAddress))
+	}
+
+	group.addMember(peer)
+	server.updateGroupMutex.Unlock()
+	if !ok && key.routeServer != "" {
+		group.initRouteServerRib()
+	}
+	if !ok {
+		group.updateAdvertiseCondition()
+	}
+	server.logger.Info(fmt.Sprintln("Neighbor", peer.NeighborConf.Neighbor.NeighborAddress, "joined update group",
+		group.id, "with", len(group.members), "members"))
+}
+
+// Removes the peer from its update group when the session goes down or the config of the peer changes
+func (server *BGPServer) leaveUpdateGroup(peer *Peer) {
+	group := peer.updateGroup
+	if group == nil {
+		return
+	}
+
+	server.updateGroupMutex.Lock()
+	defer server.updateGroupMutex.Unlock()
+	group.removeMember(peer)
+	server.logger.Info(fmt.Sprintln("Neighbor", peer.NeighborConf.Neighbor.NeighborAddress, "left update group",
+		group.id))
+	if len(group.members) == 0 {
+		server.logger.Info(fmt.Sprintln("Delete update group", group.id))
+		delete(server.updateGroups, group.key)
+	}
+}
+
+// GetBGPUpdateGroups returns the members of the update groups, the first member of a group is its leader
+func (s *BGPServer) GetBGPUpdateGroups() []config.UpdateGroupState {
+	defer s.updateGroupMutex.RUnlock()
+
+	s.updateGroupMutex.RLock()
+	groups := make([]config.UpdateGroupState, 0, len(s.updateGroups))
+	for _, group := range s.updateGroups {
+		members := make([]string, 0, len(group.members))
+		for _, member := range group.members {
+			members = append(members, member.NeighborConf.Neighbor.NeighborAddress.String())
+		}
+		groups = append(groups, config.UpdateGroupState{Id: group.id, Members: members})
+	}
+	return groups
+}
+
+/*  Moves the peer to the update group that matches its settings after the prefix ORF of the
+ *  peer changed. Returns the rib out of the old group, the routes in it that are not in the
+ *  rib out of the new group are withdrawn with withdrawRibOutFromPeer once the new group
+ *  sent its routes.
+ */
+func (server *BGPServer) regroupPeer(peer *Peer) map[string]map[uint32]*bgprib.Path {
+	oldGroup := peer.updateGroup
+	if oldGroup == nil || oldGroup.key == peer.getUpdateGroupKey() {
+		return nil
+	}
+
+	ribOut := make(map[string]map[uint32]*bgprib.Path)
+	for ip, pathIdMap := range oldGroup.ribOut {
+		ribOut[ip] = make(map[uint32]*bgprib.Path)
+		for pathId, path := range pathIdMap {
+			ribOut[ip][pathId] = path
+		}
+	}
+
+	server.leaveUpdateGroup(peer)
+	server.joinUpdateGroup(peer)
+	return ribOut
+}
+
+func (server *BGPServer) withdrawRibOutFromPeer(peer *Peer, ribOut map[string]map[uint32]*bgprib.Path) {
+	group := peer.updateGroup
+	if group == nil || len(ribOut) == 0 {
+		return
+	}
+
+	withdrawList := make([]packet.NLRI, 0)
+	for ip, pathIdMap := range ribOut {
+		dest := server.AdjRib.GetDestFromIPAndLen(ip, 0)
+		if dest == nil {
+			continue
+		}
+
+		for pathId, _ := range pathIdMap {
+			if _, ok := group.ribOut[ip][pathId]; ok {
+				continue
+			}
+			if group.getAddPathsTx(dest) > 0 {
+				withdrawList = append(withdrawList, packet.NewExtNLRI(pathId, *dest.IPPrefix))
+			} else {
+				withdrawList = append(withdrawList, dest.IPPrefix)
+			}
+		}
+	}
+
+	server.logger.Info(fmt.Sprintln("Neighbor", peer.NeighborConf.Neighbor.NeighborAddress, "withdraw",
+		len(withdrawList), "routes filtered by the prefix ORF"))
+	group.sendUpdates(withdrawList, nil, nil, []*Peer{peer})
+}
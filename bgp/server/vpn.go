@@ -0,0 +1,456 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// vpn.go
+package server
+
+import (
+	"errors"
+	"fmt"
+	"l3/bgp/config"
+	"l3/bgp/packet"
+	bgprib "l3/bgp/rib"
+	"reflect"
+)
+
+/*  A VRF has its own rib, the VPN routes received with an import route target of the VRF
+ *  are installed in it. The local routes of the VRF are advertised to the peers that
+ *  negotiated the VPN families with the route distinguisher, the label and the export
+ *  route targets of the VRF.
+ */
+type vrf struct {
+	config         config.VrfConfig
+	rd             packet.RouteDistinguisher
+	importRTs      map[uint64]bool
+	exportRTs      []uint64
+	adjRib         *bgprib.AdjRib
+	connRoutesPath *bgprib.Path
+	advertised     map[string]bool
+}
+
+func parseRouteTargets(routeTargets []string) ([]uint64, error) {
+	parsed := make([]uint64, 0, len(routeTargets))
+	for _, str := range routeTargets {
+		routeTarget, err := packet.ParseRouteTarget(str)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Route target %s is not valid", str))
+		}
+		parsed = append(parsed, routeTarget)
+	}
+	return parsed, nil
+}
+
+func (server *BGPServer) newVrf(conf config.VrfConfig) (*vrf, error) {
+	if conf.Name == "" {
+		return nil, errors.New("VRF name is not set")
+	}
+
+	rd, err := packet.ParseRouteDistinguisher(conf.RouteDistinguisher)
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.Label > packet.MPLSLabelMax {
+		return nil, errors.New(fmt.Sprintf("Label %d is greater than %d", conf.Label, packet.MPLSLabelMax))
+	}
+
+	importRTs, err := parseRouteTargets(conf.ImportRouteTargets)
+	if err != nil {
+		return nil, err
+	}
+
+	exportRTs, err := parseRouteTargets(conf.ExportRouteTargets)
+	if err != nil {
+		return nil, err
+	}
+
+	gConf := &server.BgpConfig.Global.Config
+	adjRib := bgprib.NewAdjRib(server.logger, server.routeMgr, gConf)
+	adjRib.SetVrf(conf.Name, rd)
+	pathAttrs := packet.ConstructPathAttrForConnRoutes(gConf.RouterId, gConf.AS)
+	v := &vrf{
+		config:         conf,
+		rd:             rd,
+		importRTs:      make(map[uint64]bool),
+		exportRTs:      exportRTs,
+		adjRib:         adjRib,
+		connRoutesPath: bgprib.NewPath(adjRib, nil, pathAttrs, false, false, bgprib.RouteTypeConnected),
+		advertised:     make(map[string]bool),
+	}
+	for _, routeTarget := range importRTs {
+		v.importRTs[routeTarget] = true
+	}
+	return v, nil
+}
+
+func (v *vrf) isImported(routeTargets []uint64) bool {
+	for _, routeTarget := range routeTargets {
+		if v.importRTs[routeTarget] {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *vrf) getVPNPrefix(dest *bgprib.Destination, label uint32) packet.NLRI {
+	return packet.NewVPNPrefix(v.rd, *dest.IPPrefix, []uint32{label})
+}
+
+/*  The local routes of a VRF with an L3 VNI are advertised as the EVPN IP prefix routes too.
+ */
+func (v *vrf) getPrefixes(dest *bgprib.Destination, label uint32) []packet.NLRI {
+	prefixes := []packet.NLRI{v.getVPNPrefix(dest, label)}
+	if v.config.L3VNI != 0 {
+		prefixes = append(prefixes, packet.NewEVPNIPPrefix(v.rd, 0, *dest.IPPrefix, v.config.L3VNI))
+	}
+	return prefixes
+}
+
+/*  The VPN routes are not retained for the VRFs that don't import them. The routes are
+ *  received again from the peers that negotiated the VPN families when a VRF is added.
+ */
+func (server *BGPServer) AddVrf(conf config.VrfConfig) {
+	if v, ok := server.vrfs[conf.Name]; ok {
+		if reflect.DeepEqual(v.config, conf) {
+			return
+		}
+		server.logger.Info(fmt.Sprintln("VRF", conf.Name, "config changed, remove the VRF and add it again"))
+		server.RemoveVrf(conf.Name)
+	}
+
+	v, err := server.newVrf(conf)
+	if err != nil {
+		server.logger.Err(fmt.Sprintln("Failed to add VRF", conf.Name, "err:", err))
+		return
+	}
+
+	server.logger.Info(fmt.Sprintln("Add VRF", conf.Name, "route distinguisher", v.rd))
+	server.vrfs[conf.Name] = v
+	for _, peer := range server.PeerMap {
+		if peer.NeighborConf.IsProtocolFamilyNegotiated(packet.AfiIP, packet.SafiMPLSVPN) ||
+			peer.NeighborConf.IsProtocolFamilyNegotiated(packet.AfiIP6, packet.SafiMPLSVPN) ||
+			(conf.L3VNI != 0 && peer.NeighborConf.IsProtocolFamilyNegotiated(packet.AfiL2VPN, packet.SafiEVPN)) {
+			server.SoftResetIn(peer)
+		}
+	}
+}
+
+func (server *BGPServer) RemoveVrf(name string) {
+	v, ok := server.vrfs[name]
+	if !ok {
+		server.logger.Info(fmt.Sprintln("VRF", name, "not found"))
+		return
+	}
+
+	server.logger.Info(fmt.Sprintln("Remove VRF", name))
+	delete(server.vrfs, name)
+	withdrawn := make([]*bgprib.Destination, 0)
+	for _, dests := range v.adjRib.GetLocRib() {
+		withdrawn = append(withdrawn, dests...)
+	}
+	server.sendVrfUpdates(v, nil, withdrawn, nil)
+	v.adjRib.RemoveUpdatesFromAllNeighbors(0)
+}
+
+/*  The labeled unicast routes are kept apart from the unicast routes in the global rib. They
+ *  are installed with their labels but not advertised to the update groups, the routes
+ *  advertised with a changed next hop would need a local label.
+ */
+func isLabeledUnicastDest(dest *bgprib.Destination) bool {
+	_, safi := packet.GetAfiSafi(dest.GetProtocolFamily())
+	return safi == packet.SafiLabeledUnicast
+}
+
+func isVPNUpdate(updateMsg *packet.BGPUpdate) bool {
+	nlriList := updateMsg.NLRI
+	if len(nlriList) == 0 {
+		nlriList = updateMsg.WithdrawnRoutes
+	}
+	if len(nlriList) == 0 {
+		return false
+	}
+	_, ok := nlriList[0].(*packet.VPNPrefix)
+	return ok
+}
+
+/*  The path of a route carries the labels of its NLRI, the NLRIs of an update with
+ *  different labels are split into an update per label stack. The first update carries
+ *  the withdrawn routes.
+ */
+func splitUpdateByLabels(updateMsg *packet.BGPUpdate) []*packet.BGPUpdate {
+	labelNLRIMap := make(map[string][]packet.NLRI)
+	keys := make([]string, 0, 1)
+	for _, nlri := range updateMsg.NLRI {
+		key := fmt.Sprint(packet.GetNLRILabels(nlri))
+		if _, ok := labelNLRIMap[key]; !ok {
+			keys = append(keys, key)
+		}
+		labelNLRIMap[key] = append(labelNLRIMap[key], nlri)
+	}
+
+	if len(keys) <= 1 {
+		return []*packet.BGPUpdate{updateMsg}
+	}
+
+	updates := make([]*packet.BGPUpdate, 0, len(keys))
+	for idx, key := range keys {
+		update := &packet.BGPUpdate{
+			WithdrawnRoutes: make([]packet.NLRI, 0),
+			PathAttributes:  updateMsg.PathAttributes,
+			NLRI:            labelNLRIMap[key],
+		}
+		if idx == 0 {
+			update.WithdrawnRoutes = updateMsg.WithdrawnRoutes
+		}
+		updates = append(updates, update)
+	}
+	return updates
+}
+
+// The VPN routes rejected by the import policy of the neighbor are withdrawn from the VRFs
+func (server *BGPServer) processVPNUpdate(peer *Peer, pktInfo *packet.BGPPktSrc, updateMsg *packet.BGPUpdate) {
+	server.importVrfRoutes(peer, pktInfo, server.processVPNRibUpdate(peer, pktInfo, updateMsg), false)
+}
+
+/*  processVPNRibUpdate processes the VPN routes of an update in the VPN rib, the routes are counted
+ *  in the prefix limit of the neighbor once per received route whatever the number of VRFs that
+ *  import them. The update is returned with the routes rejected by the prefix limit moved to the
+ *  withdrawn routes, so they are not imported into the VRFs.
+ */
+func (server *BGPServer) processVPNRibUpdate(peer *Peer, pktInfo *packet.BGPPktSrc,
+	updateMsg *packet.BGPUpdate) *packet.BGPUpdate {
+	for _, labelUpdate := range splitUpdateByLabels(updateMsg) {
+		msg := &packet.BGPMessage{Header: pktInfo.Msg.Header, Body: labelUpdate}
+		_, _, _, _, addedAllPrefixes := server.vpnRib.ProcessUpdate(peer.NeighborConf,
+			packet.NewBGPPktSrc(pktInfo.Src, msg), 0)
+		if !addedAllPrefixes {
+			peer.MaxPrefixesExceeded()
+		}
+	}
+
+	accepted, rejected := splitAcceptedNLRI(server.vpnRib, pktInfo.Src, updateMsg.NLRI)
+	if len(rejected) == 0 {
+		return updateMsg
+	}
+
+	withdrawnRoutes := make([]packet.NLRI, 0, len(updateMsg.WithdrawnRoutes)+len(rejected))
+	withdrawnRoutes = append(withdrawnRoutes, updateMsg.WithdrawnRoutes...)
+	return &packet.BGPUpdate{
+		WithdrawnRoutes: append(withdrawnRoutes, rejected...),
+		PathAttributes:  updateMsg.PathAttributes,
+		NLRI:            accepted,
+	}
+}
+
+/*  Import the VPN routes into the VRFs with a matching import route target. The routes are
+ *  withdrawn from the other VRFs as they may have been imported with different route
+ *  targets before. The EVPN IP prefix routes are imported only into the VRFs with an L3 VNI.
+ */
+func (server *BGPServer) importVrfRoutes(peer *Peer, pktInfo *packet.BGPPktSrc, updateMsg *packet.BGPUpdate,
+	evpn bool) {
+	routeTargets := packet.GetRouteTargets(updateMsg.PathAttributes)
+	for _, labelUpdate := range splitUpdateByLabels(updateMsg) {
+		for _, v := range server.vrfs {
+			if evpn && v.config.L3VNI == 0 {
+				continue
+			}
+
+			update := labelUpdate
+			if len(update.NLRI) > 0 && !v.isImported(routeTargets) {
+				withdrawnRoutes := make([]packet.NLRI, 0, len(update.WithdrawnRoutes)+len(update.NLRI))
+				withdrawnRoutes = append(withdrawnRoutes, update.WithdrawnRoutes...)
+				update = &packet.BGPUpdate{
+					WithdrawnRoutes: append(withdrawnRoutes, update.NLRI...),
+					PathAttributes:  make([]packet.BGPPathAttr, 0),
+					NLRI:            make([]packet.NLRI, 0),
+				}
+			}
+
+			msg := &packet.BGPMessage{Header: pktInfo.Msg.Header, Body: update}
+			updated, withdrawn, _, _, _ := v.adjRib.ProcessUpdate(peer.NeighborConf,
+				packet.NewBGPPktSrc(pktInfo.Src, msg), 0)
+			server.sendVrfUpdates(v, updated, withdrawn, nil)
+		}
+	}
+}
+
+func splitRoutesByVrf(routes []*config.RouteInfo) ([]*config.RouteInfo, map[string][]*config.RouteInfo) {
+	globalRoutes := make([]*config.RouteInfo, 0, len(routes))
+	vrfRoutes := make(map[string][]*config.RouteInfo)
+	for _, route := range routes {
+		if route.Vrf == "" {
+			globalRoutes = append(globalRoutes, route)
+		} else {
+			vrfRoutes[route.Vrf] = append(vrfRoutes[route.Vrf], route)
+		}
+	}
+	return globalRoutes, vrfRoutes
+}
+
+func (server *BGPServer) processVrfConnectedRoutes(installedRoutes, withdrawnRoutes map[string][]*config.RouteInfo) {
+	for name, v := range server.vrfs {
+		if len(installedRoutes[name]) == 0 && len(withdrawnRoutes[name]) == 0 {
+			continue
+		}
+
+		valid := server.convertDestIPToIPPrefix(installedRoutes[name])
+		invalid := server.convertDestIPToIPPrefix(withdrawnRoutes[name])
+		updated, withdrawn, _, _ := v.adjRib.ProcessConnectedRoutes(
+			server.BgpConfig.Global.Config.RouterId.String(), v.connRoutesPath, valid, invalid, 0)
+		server.sendVrfUpdates(v, updated, withdrawn, nil)
+	}
+
+	for name, _ := range installedRoutes {
+		if _, ok := server.vrfs[name]; !ok {
+			server.logger.Info(fmt.Sprintln("VRF", name, "not found, ignore routes", installedRoutes[name]))
+		}
+	}
+}
+
+/*  Advertise the local routes of the VRF to the peers in all the update groups, or to the
+ *  peer when it is set. The routes of the VRF that were advertised are withdrawn when a
+ *  route received from another PE becomes the best path.
+ */
+func (server *BGPServer) sendVrfUpdates(v *vrf, updated map[*bgprib.Path][]*bgprib.Destination,
+	withdrawn []*bgprib.Destination, peer *Peer) {
+	if server.grRestarting {
+		return
+	}
+
+	withdrawList := make([]packet.NLRI, 0)
+	for _, dest := range withdrawn {
+		if v.advertised[dest.GetKey()] {
+			delete(v.advertised, dest.GetKey())
+			withdrawList = append(withdrawList, v.getPrefixes(dest, packet.MPLSLabelWithdraw)...)
+		}
+	}
+
+	newUpdated := make(map[*bgprib.Path][]packet.NLRI)
+	for path, dests := range updated {
+		for _, dest := range dests {
+			if path.IsLocal() {
+				v.advertised[dest.GetKey()] = true
+				newUpdated[path] = append(newUpdated[path], v.getPrefixes(dest, v.config.Label)...)
+			} else if v.advertised[dest.GetKey()] {
+				delete(v.advertised, dest.GetKey())
+				withdrawList = append(withdrawList, v.getPrefixes(dest, packet.MPLSLabelWithdraw)...)
+			}
+		}
+	}
+
+	if len(withdrawList) == 0 && len(newUpdated) == 0 {
+		return
+	}
+
+	if peer != nil {
+		peer.updateGroup.sendVrfUpdates(v, withdrawList, newUpdated, []*Peer{peer})
+		return
+	}
+
+	for _, group := range server.updateGroups {
+		group.sendVrfUpdates(v, withdrawList, newUpdated, group.members)
+	}
+}
+
+func (g *UpdateGroup) sendVrfUpdates(v *vrf, withdrawList []packet.NLRI,
+	newUpdated map[*bgprib.Path][]packet.NLRI, members []*Peer) {
+	leader := g.leader()
+	for protoFamily, nlriList := range splitNLRIByProtocolFamily(withdrawList) {
+		afi, safi := packet.GetAfiSafi(protoFamily)
+		if !leader.NeighborConf.IsProtocolFamilyNegotiated(afi, safi) {
+			continue
+		}
+
+		g.logger.Info(fmt.Sprintf("Update group %d: Send update message withdraw VRF %s routes:%+v",
+			g.id, v.config.Name, nlriList))
+		pathAttrs := []packet.BGPPathAttr{packet.NewBGPPathAttrMPUnreachNLRI(afi, safi)}
+		g.sendUpdateMsg(packet.NewBGPUpdateMessage(nlriList, pathAttrs, nil), nil, members)
+	}
+
+	for path, pathNLRIList := range newUpdated {
+		for protoFamily, nlriList := range splitNLRIByProtocolFamily(pathNLRIList) {
+			afi, safi := packet.GetAfiSafi(protoFamily)
+			if !leader.NeighborConf.IsProtocolFamilyNegotiated(afi, safi) {
+				continue
+			}
+
+			g.logger.Info(fmt.Sprintf("Update group %d: Send update message VRF %s routes:%+v",
+				g.id, v.config.Name, nlriList))
+			pathAttrs := packet.ConstructPathAttrsForFamily(path.PathAttrs, afi, safi)
+			pathAttrs = packet.AddExtCommunities(pathAttrs, v.exportRTs)
+			if afi == packet.AfiL2VPN {
+				pathAttrs = packet.AddExtCommunities(pathAttrs,
+					[]uint64{packet.NewEncapsulationExtCommunity(packet.BGPTunnelTypeVXLAN)})
+			}
+			updateMsg := packet.NewBGPUpdateMessage(make([]packet.NLRI, 0), pathAttrs, nlriList)
+			g.sendUpdateMsg(updateMsg, path, members)
+		}
+	}
+	g.clearExportPolicyResults()
+}
+
+func (server *BGPServer) sendVrfRoutesToPeer(peer *Peer) {
+	for _, v := range server.vrfs {
+		server.sendVrfUpdates(v, v.adjRib.GetLocRib(), nil, peer)
+	}
+}
+
+func (server *BGPServer) markVrfStaleRoutesFromNeighbor(peerIP string) {
+	server.vpnRib.MarkStaleUpdatesFromNeighbor(peerIP)
+	for _, v := range server.vrfs {
+		v.adjRib.MarkStaleUpdatesFromNeighbor(peerIP)
+	}
+}
+
+func (server *BGPServer) markVrfRefreshStaleRoutesFromNeighbor(peerIP string, protoFamily uint32) {
+	server.vpnRib.MarkRefreshStaleFamilyUpdatesFromNeighbor(peerIP, protoFamily)
+	for _, v := range server.vrfs {
+		v.adjRib.MarkRefreshStaleFamilyUpdatesFromNeighbor(peerIP, protoFamily)
+	}
+}
+
+func (server *BGPServer) removeVrfRefreshStaleRoutesFromNeighbor(peerIP string, peer *Peer, protoFamily uint32) {
+	server.vpnRib.RemoveRefreshStaleFamilyUpdatesFromNeighbor(peerIP, protoFamily, peer.NeighborConf, 0)
+	for _, v := range server.vrfs {
+		updated, withdrawn, _, _ := v.adjRib.RemoveRefreshStaleFamilyUpdatesFromNeighbor(peerIP, protoFamily,
+			peer.NeighborConf, 0)
+		server.sendVrfUpdates(v, updated, withdrawn, nil)
+	}
+}
+
+func (server *BGPServer) removeVrfRoutesFromNeighbor(peerIP string, peer *Peer) {
+	server.vpnRib.RemoveUpdatesFromNeighbor(peerIP, peer.NeighborConf, 0)
+	for _, v := range server.vrfs {
+		updated, withdrawn, _, _ := v.adjRib.RemoveUpdatesFromNeighbor(peerIP, peer.NeighborConf, 0)
+		server.sendVrfUpdates(v, updated, withdrawn, nil)
+	}
+}
+
+func (server *BGPServer) removeVrfStaleRoutesFromNeighbor(peerIP string, peer *Peer, protoFamily uint32) {
+	server.vpnRib.RemoveStaleFamilyUpdatesFromNeighbor(peerIP, protoFamily, peer.NeighborConf, 0)
+	for _, v := range server.vrfs {
+		updated, withdrawn, _, _ := v.adjRib.RemoveStaleFamilyUpdatesFromNeighbor(peerIP, protoFamily,
+			peer.NeighborConf, 0)
+		server.sendVrfUpdates(v, updated, withdrawn, nil)
+	}
+}
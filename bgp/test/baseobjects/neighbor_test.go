@@ -0,0 +1,156 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+
+// neighbor_test.go
+package baseobjectstest
+
+import (
+	base "l3/bgp/baseobjects"
+	"l3/bgp/config"
+	"l3/bgp/packet"
+	"net"
+	"testing"
+	"utils/logging"
+)
+
+func newTestNeighborConf(t *testing.T, pConf config.NeighborConfig) *base.NeighborConf {
+	logger, err := logging.NewLogger("bgpd", "BGP", true)
+	if err != nil {
+		t.Fatal("Failed to start the logger. Exiting!!")
+	}
+	return base.NewNeighborConf(logger, &config.GlobalConfig{}, nil, pConf)
+}
+
+func newPrefixLimitNeighborConf(t *testing.T, limit config.PrefixLimit) *base.NeighborConf {
+	pConf := config.NeighborConfig{NeighborAddress: net.ParseIP("10.1.1.1")}
+	pConf.AfiSafis = []config.AfiSafiConfig{
+		{
+			AfiSafiName:    "ipv4-unicast",
+			AfiSafiEnabled: true,
+			IPv4Unicast:    config.IPUnicast{PrefixLimit: limit},
+		},
+	}
+	return newTestNeighborConf(t, pConf)
+}
+
+// Adds count prefixes and returns the number of prefixes accepted
+func addPrefixes(nConf *base.NeighborConf, protoFamily uint32, count int) int {
+	accepted := 0
+	for i := 0; i < count; i++ {
+		if nConf.CanAcceptNewPrefix(protoFamily) {
+			nConf.IncrPrefixCount(protoFamily)
+			accepted++
+		}
+	}
+	return accepted
+}
+
+func TestPrefixLimitWarningOnlyEvents(t *testing.T) {
+	limit := config.PrefixLimit{MaxPrefixes: 4, ShutdownThresholdPct: 50, WarningOnly: true}
+	nConf := newPrefixLimitNeighborConf(t, limit)
+	protoFamily := packet.GetProtocolFamily(packet.AfiIP, packet.SafiUnicast)
+
+	if accepted := addPrefixes(nConf, protoFamily, 10); accepted != 10 {
+		t.Fatal("Warning only prefix limit accepted", accepted, "prefixes, expected 10")
+	}
+
+	events := nConf.GetPrefixLimitEvents()
+	if len(events) != 2 {
+		t.Fatal("Got", len(events), "prefix limit events, expected one threshold and one limit event:", events)
+	}
+	if events[0].EventType != config.PrefixLimitThresholdReached || events[0].Limit != 2 ||
+		events[0].ProtoFamily != protoFamily {
+		t.Error("First event is not the threshold event of the family:", events[0])
+	}
+	if events[1].EventType != config.PrefixLimitExceeded || events[1].Limit != 4 || events[1].Teardown {
+		t.Error("Second event is not the warning only max prefix event:", events[1])
+	}
+	if _, _, _, disconnect := nConf.GetExceededPrefixLimit(); disconnect {
+		t.Error("Warning only prefix limit disconnected the neighbor")
+	}
+
+	// The events are raised again after the count drops below the limits
+	for i := 0; i < 10; i++ {
+		nConf.DecrPrefixCount(protoFamily)
+	}
+	addPrefixes(nConf, protoFamily, 10)
+	if events = nConf.GetPrefixLimitEvents(); len(events) != 2 {
+		t.Error("Got", len(events), "prefix limit events after the count dropped, expected 2:", events)
+	}
+}
+
+func TestPrefixLimitTeardownEvent(t *testing.T) {
+	limit := config.PrefixLimit{MaxPrefixes: 3, RestartTimer: 30}
+	nConf := newPrefixLimitNeighborConf(t, limit)
+	protoFamily := packet.GetProtocolFamily(packet.AfiIP, packet.SafiUnicast)
+
+	if accepted := addPrefixes(nConf, protoFamily, 5); accepted != 3 {
+		t.Fatal("Prefix limit accepted", accepted, "prefixes, expected 3")
+	}
+
+	events := nConf.GetPrefixLimitEvents()
+	if len(events) != 1 || events[0].EventType != config.PrefixLimitExceeded || !events[0].Teardown ||
+		events[0].PrefixCount != 3 {
+		t.Fatal("Expected one max prefix event with teardown, got:", events)
+	}
+
+	family, maxPrefixes, restartTime, disconnect := nConf.GetExceededPrefixLimit()
+	if !disconnect || family != protoFamily || maxPrefixes != 3 || restartTime != 30 {
+		t.Error("Exceeded prefix limit family", family, "max prefixes", maxPrefixes, "restart time", restartTime,
+			"disconnect", disconnect, "does not match the limit", limit)
+	}
+}
+
+// The Cease data of the total prefix limit has the family of the prefix that exceeded the limit
+func TestTotalPrefixLimitFamily(t *testing.T) {
+	nConf := newTestNeighborConf(t, config.NeighborConfig{
+		NeighborAddress:         net.ParseIP("10.1.1.1"),
+		MaxPrefixes:             2,
+		MaxPrefixesDisconnect:   true,
+		MaxPrefixesRestartTimer: 10,
+	})
+	protoFamily := packet.GetProtocolFamily(packet.AfiIP6, packet.SafiMPLSVPN)
+
+	if accepted := addPrefixes(nConf, protoFamily, 3); accepted != 2 {
+		t.Fatal("Total prefix limit accepted", accepted, "prefixes, expected 2")
+	}
+
+	family, maxPrefixes, restartTime, disconnect := nConf.GetExceededPrefixLimit()
+	if !disconnect || family != protoFamily || maxPrefixes != 2 || restartTime != 10 {
+		t.Error("Exceeded total prefix limit family", family, "max prefixes", maxPrefixes, "restart time",
+			restartTime, "disconnect", disconnect, "expected family", protoFamily)
+	}
+}
+
+// The threshold of a large total prefix limit does not overflow
+func TestTotalPrefixLimitThreshold(t *testing.T) {
+	nConf := newTestNeighborConf(t, config.NeighborConfig{
+		NeighborAddress:         net.ParseIP("10.1.1.1"),
+		MaxPrefixes:             100000000,
+		MaxPrefixesThresholdPct: 80,
+	})
+	if nConf.MaxPrefixesThreshold != 80000000 {
+		t.Error("Total prefix limit threshold", nConf.MaxPrefixesThreshold, "expected 80000000")
+	}
+}
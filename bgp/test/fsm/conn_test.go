@@ -91,3 +91,28 @@ func TestDecodeMessageBGPOpen(t *testing.T) {
 		}
 	}
 }
+
+func TestNeighborTTL(t *testing.T) {
+	logger, err := logging.NewLogger("bgpd", "BGP", true)
+	if err != nil {
+		t.Fatal("Failed to start the logger. Exiting!!")
+	}
+
+	gConf := &config.GlobalConfig{AS: 65000}
+	pConfs := []config.NeighborConfig{
+		{BaseConfig: config.BaseConfig{PeerAS: 65001}},
+		{BaseConfig: config.BaseConfig{PeerAS: 65000}},
+		{BaseConfig: config.BaseConfig{PeerAS: 65001, MultiHopEnable: true, MultiHopTTL: 5}},
+		{BaseConfig: config.BaseConfig{PeerAS: 65001, MultiHopEnable: true, MultiHopTTL: 5, TtlSecurityHops: 2}},
+	}
+	ttls := []uint8{1, 255, 5, 255}
+	minTTLs := []uint8{0, 0, 0, 254}
+
+	for idx, pConf := range pConfs {
+		nConf := base.NewNeighborConf(logger, gConf, nil, pConf)
+		if ttl, minTTL := nConf.GetTTL(); ttl != ttls[idx] || minTTL != minTTLs[idx] {
+			t.Error("GetTTL called for", pConf.BaseConfig, "... expected TTL", ttls[idx], "min TTL",
+				minTTLs[idx], "got", ttl, minTTL)
+		}
+	}
+}
@@ -30,6 +30,7 @@ import (
 	"fmt"
 	"l3/bgp/packet"
 	"math"
+	"net"
 	"testing"
 )
 
@@ -239,3 +240,975 @@ func TestBGPUpdatePathAttrsBadLength(t *testing.T) {
 		}
 	}
 }
+
+func TestBGPUpdatePathAttrCommunities(t *testing.T) {
+	pathAttrs := "40010100500200060201000002584003045a01010280040400000000"
+	nlri := "183c010118500101"
+	communities := []string{"c0080cffffff01fde8006400010002", "c0080bffffff01fde80064000100"}
+	expectedErr := []bool{false, true}
+
+	for idx, pa := range communities {
+		strPkt := fmt.Sprintf("0000%04x", (len(pathAttrs)+len(pa))/2) + pathAttrs + pa + nlri
+		hexPkt, err := hex.DecodeString(strPkt)
+		fmt.Printf("packet = %x, len = %d\n", hexPkt, len(hexPkt))
+		if err != nil {
+			t.Fatal("Failed to decode the string to hex, string =", strPkt)
+		}
+
+		pktLen := make([]byte, 2)
+		binary.BigEndian.PutUint16(pktLen, uint16(len(hexPkt)+19))
+		header := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x02}
+		copy(header[16:18], pktLen)
+
+		bgpHeader := packet.NewBGPHeader()
+		err = bgpHeader.Decode(header)
+		if err != nil {
+			t.Fatal("BGP packet header decode failed with error", err)
+		}
+
+		peerAttrs := packet.BGPPeerAttrs{
+			ASSize:           4,
+			AddPathsRxActual: false,
+		}
+		bgpMessage := packet.NewBGPMessage()
+		err = bgpMessage.Decode(bgpHeader, hexPkt, peerAttrs)
+		if expectedErr[idx] {
+			if err == nil {
+				t.Error("BGP update message decode called... expected failure, got NO error")
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatal("BGP update message decode failed with error", err)
+		}
+
+		updateMsg := bgpMessage.Body.(*packet.BGPUpdate)
+		for _, community := range []uint32{packet.BGPCommunityNoExport, 0xFDE80064, 0x00010002} {
+			if !packet.HasCommunity(updateMsg.PathAttributes, community) {
+				t.Error("Community", packet.CommunityToString(community), "not found in path attrs")
+			}
+		}
+
+		pkt, err := bgpMessage.Encode()
+		if err != nil {
+			t.Fatal("BGP update message encode failed with error", err)
+		}
+		if hex.EncodeToString(pkt[19:]) != strPkt {
+			t.Error("Encoded BGP update message", hex.EncodeToString(pkt[19:]), "does not match", strPkt)
+		}
+	}
+}
+
+func TestBGPUpdatePathAttrExtAndLargeCommunities(t *testing.T) {
+	pathAttrs := "40010100500200060201000002584003045a01010280040400000000"
+	nlri := "183c010118500101"
+	communities := []string{"c010100002fde800000064010301020304000a", "c0200c0000fde8000000010000000a", "c0100c0002fde80000006401020304",
+		"c0200b0000fde8000000010000000a"}
+	expectedErr := []bool{false, false, true, true}
+	extCommunities := []string{"rt:65000:100", "ro:1.2.3.4:10"}
+	largeCommunities := []string{"65000:1:10"}
+
+	for idx, pa := range communities {
+		strPkt := fmt.Sprintf("0000%04x", (len(pathAttrs)+len(pa))/2) + pathAttrs + pa + nlri
+		hexPkt, err := hex.DecodeString(strPkt)
+		fmt.Printf("packet = %x, len = %d\n", hexPkt, len(hexPkt))
+		if err != nil {
+			t.Fatal("Failed to decode the string to hex, string =", strPkt)
+		}
+
+		pktLen := make([]byte, 2)
+		binary.BigEndian.PutUint16(pktLen, uint16(len(hexPkt)+19))
+		header := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x02}
+		copy(header[16:18], pktLen)
+
+		bgpHeader := packet.NewBGPHeader()
+		err = bgpHeader.Decode(header)
+		if err != nil {
+			t.Fatal("BGP packet header decode failed with error", err)
+		}
+
+		peerAttrs := packet.BGPPeerAttrs{
+			ASSize:           4,
+			AddPathsRxActual: false,
+		}
+		bgpMessage := packet.NewBGPMessage()
+		err = bgpMessage.Decode(bgpHeader, hexPkt, peerAttrs)
+		if expectedErr[idx] {
+			if err == nil {
+				t.Error("BGP update message decode called... expected failure, got NO error")
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatal("BGP update message decode failed with error", err)
+		}
+
+		updateMsg := bgpMessage.Body.(*packet.BGPUpdate)
+		for _, extCommunityStr := range packet.GetExtCommunities(updateMsg.PathAttributes) {
+			str := packet.ExtCommunityToString(extCommunityStr)
+			if str != extCommunities[0] && str != extCommunities[1] {
+				t.Error("Unexpected extended community", str, "in path attrs")
+			}
+		}
+		for _, largeCommunity := range packet.GetLargeCommunities(updateMsg.PathAttributes) {
+			if str := packet.LargeCommunityToString(largeCommunity); str != largeCommunities[0] {
+				t.Error("Unexpected large community", str, "in path attrs")
+			}
+		}
+
+		pkt, err := bgpMessage.Encode()
+		if err != nil {
+			t.Fatal("BGP update message encode failed with error", err)
+		}
+		if hex.EncodeToString(pkt[19:]) != strPkt {
+			t.Error("Encoded BGP update message", hex.EncodeToString(pkt[19:]), "does not match", strPkt)
+		}
+	}
+}
+
+func TestBGPOpenGracefulRestartCapability(t *testing.T) {
+	strPkt := "04fde800b40a0a0a010a02084006807800010180"
+	hexPkt, err := hex.DecodeString(strPkt)
+	if err != nil {
+		t.Fatal("Failed to decode the string to hex, string =", strPkt)
+	}
+
+	pktLen := make([]byte, 2)
+	binary.BigEndian.PutUint16(pktLen, uint16(len(hexPkt)+19))
+	header := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x01}
+	copy(header[16:18], pktLen)
+
+	bgpHeader := packet.NewBGPHeader()
+	err = bgpHeader.Decode(header)
+	if err != nil {
+		t.Fatal("BGP packet header decode failed with error", err)
+	}
+
+	peerAttrs := packet.BGPPeerAttrs{
+		ASSize:           2,
+		AddPathsRxActual: false,
+	}
+	bgpMessage := packet.NewBGPMessage()
+	err = bgpMessage.Decode(bgpHeader, hexPkt, peerAttrs)
+	if err != nil {
+		t.Fatal("BGP open message decode failed with error", err)
+	}
+
+	grCap := packet.GetGracefulRestartCap(bgpMessage.Body.(*packet.BGPOpen))
+	if grCap == nil {
+		t.Fatal("Graceful restart capability not found in the open message")
+	}
+	if !grCap.IsRestarting() || grCap.RestartTime != 120 {
+		t.Error("Graceful restart capability decoded with restart flags", grCap.RestartFlags,
+			"restart time", grCap.RestartTime)
+	}
+	if !grCap.IsForwardingPreserved(packet.AfiIP, packet.SafiUnicast) {
+		t.Error("Forwarding state not preserved for IPv4 unicast in", grCap.Value)
+	}
+	if grCap.IsForwardingPreserved(packet.AfiIP6, packet.SafiUnicast) {
+		t.Error("Forwarding state preserved for IPv6 unicast in", grCap.Value)
+	}
+
+	newCap := packet.NewBGPCapGracefulRestart(true, 120)
+	newCap.AddGracefulRestartAFISAFI(packet.NewGracefulRestartAFISAFI(packet.AfiIP, packet.SafiUnicast,
+		packet.BGPCapGracefulRestartFlagForwarding))
+	pkt, err := newCap.Encode()
+	if err != nil {
+		t.Fatal("Graceful restart capability encode failed with error", err)
+	}
+	if hex.EncodeToString(pkt) != "4006807800010180" {
+		t.Error("Encoded graceful restart capability", hex.EncodeToString(pkt), "does not match 4006807800010180")
+	}
+}
+
+func TestBGPEndOfRIB(t *testing.T) {
+	pkt, err := packet.NewBGPEndOfRIBMessage().Encode()
+	if err != nil {
+		t.Fatal("End-of-RIB encode failed with error", err)
+	}
+	if len(pkt) != 23 {
+		t.Fatal("End-of-RIB encoded with length", len(pkt), "expected 23")
+	}
+
+	bgpHeader := packet.NewBGPHeader()
+	err = bgpHeader.Decode(pkt[:19])
+	if err != nil {
+		t.Fatal("BGP packet header decode failed with error", err)
+	}
+
+	peerAttrs := packet.BGPPeerAttrs{
+		ASSize:           4,
+		AddPathsRxActual: false,
+	}
+	bgpMessage := packet.NewBGPMessage()
+	err = bgpMessage.Decode(bgpHeader, pkt[19:], peerAttrs)
+	if err != nil {
+		t.Fatal("End-of-RIB decode failed with error", err)
+	}
+	if !packet.IsEndOfRIB(bgpMessage.Body.(*packet.BGPUpdate)) {
+		t.Error("Decoded update message is not an End-of-RIB marker")
+	}
+}
+
+func TestBGPOpenRouteRefreshCapability(t *testing.T) {
+	strPkt := "04fde800b40a0a0a0106020402004600"
+	hexPkt, err := hex.DecodeString(strPkt)
+	if err != nil {
+		t.Fatal("Failed to decode the string to hex, string =", strPkt)
+	}
+
+	pktLen := make([]byte, 2)
+	binary.BigEndian.PutUint16(pktLen, uint16(len(hexPkt)+19))
+	header := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x01}
+	copy(header[16:18], pktLen)
+
+	bgpHeader := packet.NewBGPHeader()
+	err = bgpHeader.Decode(header)
+	if err != nil {
+		t.Fatal("BGP packet header decode failed with error", err)
+	}
+
+	peerAttrs := packet.BGPPeerAttrs{
+		ASSize:           2,
+		AddPathsRxActual: false,
+	}
+	bgpMessage := packet.NewBGPMessage()
+	err = bgpMessage.Decode(bgpHeader, hexPkt, peerAttrs)
+	if err != nil {
+		t.Fatal("BGP open message decode failed with error", err)
+	}
+
+	openMsg := bgpMessage.Body.(*packet.BGPOpen)
+	if !packet.HasCapability(openMsg, packet.BGPCapTypeRouteRefresh) {
+		t.Error("Route refresh capability not found in the open message")
+	}
+	if !packet.HasCapability(openMsg, packet.BGPCapTypeEnhancedRouteRefresh) {
+		t.Error("Enhanced route refresh capability not found in the open message")
+	}
+	if packet.HasCapability(openMsg, packet.BGPCapTypeGracefulRestart) {
+		t.Error("Graceful restart capability found in the open message")
+	}
+}
+
+func TestBGPOpenExtendedNextHopCapability(t *testing.T) {
+	strPkt := "04fde800b40a0a0a010a02080506000100010002"
+	hexPkt, err := hex.DecodeString(strPkt)
+	if err != nil {
+		t.Fatal("Failed to decode the string to hex, string =", strPkt)
+	}
+
+	pktLen := make([]byte, 2)
+	binary.BigEndian.PutUint16(pktLen, uint16(len(hexPkt)+19))
+	header := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x01}
+	copy(header[16:18], pktLen)
+
+	bgpHeader := packet.NewBGPHeader()
+	err = bgpHeader.Decode(header)
+	if err != nil {
+		t.Fatal("BGP packet header decode failed with error", err)
+	}
+
+	peerAttrs := packet.BGPPeerAttrs{
+		ASSize:           2,
+		AddPathsRxActual: false,
+	}
+	bgpMessage := packet.NewBGPMessage()
+	err = bgpMessage.Decode(bgpHeader, hexPkt, peerAttrs)
+	if err != nil {
+		t.Fatal("BGP open message decode failed with error", err)
+	}
+
+	extNHCap := packet.GetExtendedNextHopCap(bgpMessage.Body.(*packet.BGPOpen))
+	if extNHCap == nil {
+		t.Fatal("Extended next hop capability not found in the open message")
+	}
+	if !extNHCap.IsNextHopAFISupported(packet.AfiIP, packet.SafiUnicast, packet.AfiIP6) {
+		t.Error("Extended next hop capability does not support IPv4 unicast with IPv6 next hop")
+	}
+	if extNHCap.IsNextHopAFISupported(packet.AfiIP6, packet.SafiUnicast, packet.AfiIP) {
+		t.Error("Extended next hop capability supports IPv6 unicast with IPv4 next hop")
+	}
+
+	capability := packet.NewBGPCapExtendedNextHop()
+	capability.AddExtendedNextHopAFISAFI(packet.AfiIP, packet.SafiUnicast, packet.AfiIP6)
+	encPkt, err := capability.Encode()
+	if err != nil {
+		t.Fatal("Extended next hop capability encode failed with error", err)
+	}
+	if hex.EncodeToString(encPkt) != "0506000100010002" {
+		t.Error("Encoded extended next hop capability", hex.EncodeToString(encPkt),
+			"does not match 0506000100010002")
+	}
+}
+
+func TestBGPOpenGetPeerAS(t *testing.T) {
+	strPkt := "045ba000b40a0a0a01080206410400010000"
+	hexPkt, err := hex.DecodeString(strPkt)
+	if err != nil {
+		t.Fatal("Failed to decode the string to hex, string =", strPkt)
+	}
+
+	pktLen := make([]byte, 2)
+	binary.BigEndian.PutUint16(pktLen, uint16(len(hexPkt)+19))
+	header := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x01}
+	copy(header[16:18], pktLen)
+
+	bgpHeader := packet.NewBGPHeader()
+	err = bgpHeader.Decode(header)
+	if err != nil {
+		t.Fatal("BGP packet header decode failed with error", err)
+	}
+
+	peerAttrs := packet.BGPPeerAttrs{
+		ASSize:           2,
+		AddPathsRxActual: false,
+	}
+	bgpMessage := packet.NewBGPMessage()
+	err = bgpMessage.Decode(bgpHeader, hexPkt, peerAttrs)
+	if err != nil {
+		t.Fatal("BGP open message decode failed with error", err)
+	}
+
+	peerAS := packet.GetPeerAS(bgpMessage.Body.(*packet.BGPOpen))
+	if peerAS != 65536 {
+		t.Error("Peer AS from the four byte AS capability", peerAS, "does not match 65536")
+	}
+
+	openMsg := packet.NewBGPOpenMessage(65001, 180, "10.10.10.1", nil)
+	peerAS = packet.GetPeerAS(openMsg.Body.(*packet.BGPOpen))
+	if peerAS != 65001 {
+		t.Error("Peer AS without the four byte AS capability", peerAS, "does not match 65001")
+	}
+}
+
+func TestBGPRouteRefresh(t *testing.T) {
+	header := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x17, 0x05}
+	bgpHeader := packet.NewBGPHeader()
+	err := bgpHeader.Decode(header)
+	if err != nil {
+		t.Fatal("BGP packet header decode failed with error", err)
+	}
+
+	peerAttrs := packet.BGPPeerAttrs{
+		ASSize:           4,
+		AddPathsRxActual: false,
+	}
+	bgpMessage := packet.NewBGPMessage()
+	err = bgpMessage.Decode(bgpHeader, []byte{0x00, 0x01, 0x01, 0x01}, peerAttrs)
+	if err != nil {
+		t.Fatal("BGP route refresh message decode failed with error", err)
+	}
+
+	routeRefresh, ok := bgpMessage.Body.(*packet.BGPRouteRefresh)
+	if !ok {
+		t.Fatal("BGP message body is not a route refresh message", bgpMessage.Body)
+	}
+	if routeRefresh.AFI != packet.AfiIP || routeRefresh.SAFI != packet.SafiUnicast ||
+		routeRefresh.SubType != packet.BGPRouteRefreshBoRR {
+		t.Error("Route refresh decoded with AFI", routeRefresh.AFI, "SAFI", routeRefresh.SAFI,
+			"subtype", routeRefresh.SubType)
+	}
+
+	pkt, err := packet.NewBGPRouteRefreshMessage(packet.AfiIP, packet.SafiUnicast,
+		packet.BGPRouteRefreshEoRR).Encode()
+	if err != nil {
+		t.Fatal("BGP route refresh message encode failed with error", err)
+	}
+	if hex.EncodeToString(pkt) != "ffffffffffffffffffffffffffffffff00170500010201" {
+		t.Error("BGP route refresh message encoded to", hex.EncodeToString(pkt))
+	}
+
+	bgpHeader.Length = 24
+	bgpMessage = packet.NewBGPMessage()
+	err = bgpMessage.Decode(bgpHeader, []byte{0x00, 0x01, 0x02, 0x01, 0x00}, peerAttrs)
+	if err == nil {
+		t.Fatal("BGP EoRR message with invalid length decoded without error")
+	}
+	if msgErr, ok := err.(packet.BGPMessageError); !ok || msgErr.TypeCode != packet.BGPRouteRefreshMsgError ||
+		msgErr.SubTypeCode != packet.BGPRouteRefreshInvalidMsgLen {
+		t.Error("BGP EoRR message with invalid length failed with error", err)
+	}
+}
+
+func TestBGPOpenORFCapability(t *testing.T) {
+	strPkt := "04fde800b40a0a0a01120210030e0001000101400300020001014001"
+	hexPkt, err := hex.DecodeString(strPkt)
+	if err != nil {
+		t.Fatal("Failed to decode the string to hex, string =", strPkt)
+	}
+
+	pktLen := make([]byte, 2)
+	binary.BigEndian.PutUint16(pktLen, uint16(len(hexPkt)+19))
+	header := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x01}
+	copy(header[16:18], pktLen)
+
+	bgpHeader := packet.NewBGPHeader()
+	err = bgpHeader.Decode(header)
+	if err != nil {
+		t.Fatal("BGP packet header decode failed with error", err)
+	}
+
+	peerAttrs := packet.BGPPeerAttrs{
+		ASSize:           2,
+		AddPathsRxActual: false,
+	}
+	bgpMessage := packet.NewBGPMessage()
+	err = bgpMessage.Decode(bgpHeader, hexPkt, peerAttrs)
+	if err != nil {
+		t.Fatal("BGP open message decode failed with error", err)
+	}
+
+	orfFamily := packet.GetPrefixORFFamily(bgpMessage.Body.(*packet.BGPOpen))
+	ipv4Family := packet.GetProtocolFamily(packet.AfiIP, packet.SafiUnicast)
+	ipv6Family := packet.GetProtocolFamily(packet.AfiIP6, packet.SafiUnicast)
+	if len(orfFamily) != 2 || orfFamily[ipv4Family] != packet.BGPORFCapSend|packet.BGPORFCapReceive ||
+		orfFamily[ipv6Family] != packet.BGPORFCapReceive {
+		t.Error("Prefix ORF capability decoded as", orfFamily)
+	}
+
+	afiSafiMap := map[uint32]bool{
+		ipv4Family: true,
+		packet.GetProtocolFamily(packet.AfiIP, packet.SafiMPLSVPN): true,
+	}
+	optParams := packet.ConstructOptParams(65000, afiSafiMap, false, 0, false, 0, false, false,
+		packet.BGPORFCapSend)
+	openMsg := packet.NewBGPOpenMessage(65000, 180, "10.0.0.1", optParams).Body.(*packet.BGPOpen)
+	orfFamily = packet.GetPrefixORFFamily(openMsg)
+	if len(orfFamily) != 1 || orfFamily[ipv4Family] != packet.BGPORFCapSend {
+		t.Error("Prefix ORF capability advertised as", orfFamily, "expected send for IPv4 unicast only")
+	}
+}
+
+func TestBGPRouteRefreshPrefixORF(t *testing.T) {
+	strPkt := "0001000101400015" + "80" + "000000000a1820100a01" + "20000000140000100a02"
+	hexPkt, err := hex.DecodeString(strPkt)
+	if err != nil {
+		t.Fatal("Failed to decode the string to hex, string =", strPkt)
+	}
+
+	header := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x05}
+	header[17] = uint8(len(hexPkt) + 19)
+	bgpHeader := packet.NewBGPHeader()
+	err = bgpHeader.Decode(header)
+	if err != nil {
+		t.Fatal("BGP packet header decode failed with error", err)
+	}
+
+	peerAttrs := packet.BGPPeerAttrs{
+		ASSize:           4,
+		AddPathsRxActual: false,
+	}
+	bgpMessage := packet.NewBGPMessage()
+	err = bgpMessage.Decode(bgpHeader, hexPkt, peerAttrs)
+	if err != nil {
+		t.Fatal("BGP route refresh message with prefix ORF decode failed with error", err)
+	}
+
+	routeRefresh := bgpMessage.Body.(*packet.BGPRouteRefresh)
+	if routeRefresh.WhenToRefresh != packet.BGPORFWhenImmediate || len(routeRefresh.PrefixORF) != 3 {
+		t.Fatal("Route refresh decoded with when to refresh", routeRefresh.WhenToRefresh, "prefix ORF",
+			routeRefresh.PrefixORF)
+	}
+	expected := []*packet.AddressPrefixORFEntry{
+		packet.NewAddressPrefixORFEntry(packet.BGPORFActionRemoveAll, packet.BGPORFMatchPermit, 0, nil, 0, 0, 0),
+		packet.NewAddressPrefixORFEntry(packet.BGPORFActionAdd, packet.BGPORFMatchPermit, 10,
+			net.ParseIP("10.1.0.0"), 16, 24, 32),
+		packet.NewAddressPrefixORFEntry(packet.BGPORFActionAdd, packet.BGPORFMatchDeny, 20,
+			net.ParseIP("10.2.0.0"), 16, 0, 0),
+	}
+	for idx, entry := range routeRefresh.PrefixORF {
+		if entry.String() != expected[idx].String() || entry.Action != expected[idx].Action {
+			t.Error("Prefix ORF entry", idx, "decoded as", entry, "expected", expected[idx])
+		}
+	}
+
+	pkt, err := packet.NewBGPRouteRefreshORFMessage(packet.AfiIP, packet.SafiUnicast, packet.BGPORFWhenImmediate,
+		expected).Encode()
+	if err != nil {
+		t.Fatal("BGP route refresh message with prefix ORF encode failed with error", err)
+	}
+	if hex.EncodeToString(pkt[19:]) != strPkt {
+		t.Error("BGP route refresh message with prefix ORF encoded to", hex.EncodeToString(pkt[19:]),
+			"expected", strPkt)
+	}
+
+	hexPkt[7] = 0x20
+	bgpMessage = packet.NewBGPMessage()
+	err = bgpMessage.Decode(bgpHeader, hexPkt, peerAttrs)
+	if msgErr, ok := err.(packet.BGPMessageError); !ok || msgErr.TypeCode != packet.BGPRouteRefreshMsgError {
+		t.Error("BGP route refresh message with invalid ORF length failed with error", err)
+	}
+}
+
+func TestBGPMPReachNLRIIPv6(t *testing.T) {
+	strPkt := "800e2e0002012020010db8000000000000000000000001fe800000000000000000000000000001" +
+		"004020010db800010000"
+	pkt, err := hex.DecodeString(strPkt)
+	if err != nil {
+		t.Fatal("Failed to decode the string to hex, string =", strPkt)
+	}
+
+	peerAttrs := packet.BGPPeerAttrs{
+		ASSize:           4,
+		AddPathsRxActual: false,
+	}
+	mpReach := &packet.BGPPathAttrMPReachNLRI{}
+	err = mpReach.Decode(pkt, peerAttrs)
+	if err != nil {
+		t.Fatal("MP_REACH_NLRI decode failed with error", err)
+	}
+
+	if mpReach.AFI != packet.AfiIP6 || mpReach.SAFI != packet.SafiUnicast {
+		t.Error("MP_REACH_NLRI decoded with AFI", mpReach.AFI, "SAFI", mpReach.SAFI, "expected AFI 2 SAFI 1")
+	}
+	if !mpReach.NextHop.Equal(net.ParseIP("2001:db8::1")) {
+		t.Error("MP_REACH_NLRI decoded with next hop", mpReach.NextHop, "expected 2001:db8::1")
+	}
+	if !mpReach.LinkLocalNextHop.Equal(net.ParseIP("fe80::1")) {
+		t.Error("MP_REACH_NLRI decoded with link local next hop", mpReach.LinkLocalNextHop, "expected fe80::1")
+	}
+	if len(mpReach.NLRI) != 1 {
+		t.Fatal("MP_REACH_NLRI decoded with", len(mpReach.NLRI), "NLRI, expected 1")
+	}
+	ipPrefix := mpReach.NLRI[0].GetPrefix()
+	if !ipPrefix.Prefix.Equal(net.ParseIP("2001:db8:1::")) || ipPrefix.Length != 64 {
+		t.Error("MP_REACH_NLRI decoded with prefix", ipPrefix.Prefix, "length", ipPrefix.Length,
+			"expected 2001:db8:1::/64")
+	}
+
+	encPkt, err := mpReach.Encode()
+	if err != nil {
+		t.Fatal("MP_REACH_NLRI encode failed with error", err)
+	}
+	if hex.EncodeToString(encPkt) != strPkt {
+		t.Error("Encoded MP_REACH_NLRI", hex.EncodeToString(encPkt), "does not match", strPkt)
+	}
+}
+
+// The IPv4-mapped IPv6 prefixes received for AFI 2 are IPv6 prefixes
+func TestBGPMPReachNLRIIPv4MappedIPv6(t *testing.T) {
+	strPkt := "800e23000201" + "1020010db8000000000000000000000001" + "00" + "6800000000000000000000ffff0a"
+	pkt, err := hex.DecodeString(strPkt)
+	if err != nil {
+		t.Fatal("Failed to decode the string to hex, string =", strPkt)
+	}
+
+	peerAttrs := packet.BGPPeerAttrs{
+		ASSize:           4,
+		AddPathsRxActual: false,
+	}
+	mpReach := &packet.BGPPathAttrMPReachNLRI{}
+	err = mpReach.Decode(pkt, peerAttrs)
+	if err != nil {
+		t.Fatal("MP_REACH_NLRI decode failed with error", err)
+	}
+	if len(mpReach.NLRI) != 1 {
+		t.Fatal("MP_REACH_NLRI decoded with", len(mpReach.NLRI), "NLRI, expected 1")
+	}
+
+	ipPrefix := mpReach.NLRI[0].GetPrefix()
+	if !ipPrefix.Prefix.Equal(net.ParseIP("::ffff:10.0.0.0")) || ipPrefix.Length != 104 {
+		t.Error("MP_REACH_NLRI decoded with prefix", ipPrefix.Prefix, "length", ipPrefix.Length,
+			"expected ::ffff:10.0.0.0/104")
+	}
+	if ipPrefix.GetAFI() != packet.AfiIP6 {
+		t.Error("IPv4-mapped IPv6 prefix decoded with AFI", ipPrefix.GetAFI(), "expected AFI 2")
+	}
+	if protoFamily := packet.GetNLRIProtocolFamily(mpReach.NLRI[0]); protoFamily !=
+		packet.GetProtocolFamily(packet.AfiIP6, packet.SafiUnicast) {
+		t.Error("IPv4-mapped IPv6 prefix classified as protocol family", protoFamily, "expected IPv6 unicast")
+	}
+
+	clone := mpReach.NLRI[0].Clone()
+	if clone.GetPrefix().GetAFI() != packet.AfiIP6 {
+		t.Error("Cloned IPv4-mapped IPv6 prefix has AFI", clone.GetPrefix().GetAFI(), "expected AFI 2")
+	}
+
+	encPkt, err := mpReach.Encode()
+	if err != nil {
+		t.Fatal("MP_REACH_NLRI encode failed with error", err)
+	}
+	if hex.EncodeToString(encPkt) != strPkt {
+		t.Error("Encoded MP_REACH_NLRI", hex.EncodeToString(encPkt), "does not match", strPkt)
+	}
+
+	prefixORF := []*packet.AddressPrefixORFEntry{
+		packet.NewAddressPrefixORFEntry(packet.BGPORFActionAdd, packet.BGPORFMatchPermit, 10,
+			net.ParseIP("10.0.0.0"), 8, 8, 32),
+	}
+	if packet.IsPrefixPermittedByORF(prefixORF, ipPrefix) {
+		t.Error("IPv4-mapped IPv6 prefix", ipPrefix.Prefix, "matched by IPv4 prefix ORF entry 10.0.0.0/8")
+	}
+}
+
+func TestBGPMPReachNLRIIPv6AddPaths(t *testing.T) {
+	strPkt := "800e22000201" + "1020010db8000000000000000000000001" + "00" + "000000074020010db800010000"
+	pkt, err := hex.DecodeString(strPkt)
+	if err != nil {
+		t.Fatal("Failed to decode the string to hex, string =", strPkt)
+	}
+
+	peerAttrs := packet.BGPPeerAttrs{
+		ASSize:             4,
+		AddPathsRxActual:   false,
+		AddPathsRxFamilies: map[uint32]bool{packet.GetProtocolFamily(packet.AfiIP6, packet.SafiUnicast): true},
+	}
+	mpReach := &packet.BGPPathAttrMPReachNLRI{}
+	err = mpReach.Decode(pkt, peerAttrs)
+	if err != nil {
+		t.Fatal("MP_REACH_NLRI decode failed with error", err)
+	}
+
+	if len(mpReach.NLRI) != 1 {
+		t.Fatal("MP_REACH_NLRI decoded with", len(mpReach.NLRI), "NLRI, expected 1")
+	}
+	extNLRI, ok := mpReach.NLRI[0].(*packet.ExtNLRI)
+	if !ok {
+		t.Fatal("MP_REACH_NLRI decoded NLRI", mpReach.NLRI[0], "is not an NLRI with path id")
+	}
+	if extNLRI.PathId != 7 || !extNLRI.Prefix.Equal(net.ParseIP("2001:db8:1::")) || extNLRI.Length != 64 {
+		t.Error("MP_REACH_NLRI decoded with path id", extNLRI.PathId, "prefix", extNLRI.Prefix, "length",
+			extNLRI.Length, "expected path id 7 prefix 2001:db8:1::/64")
+	}
+
+	encPkt, err := mpReach.Encode()
+	if err != nil {
+		t.Fatal("MP_REACH_NLRI encode failed with error", err)
+	}
+	if hex.EncodeToString(encPkt) != strPkt {
+		t.Error("Encoded MP_REACH_NLRI", hex.EncodeToString(encPkt), "does not match", strPkt)
+	}
+}
+
+func TestBGPOpenAddPathsCapabilityFamilies(t *testing.T) {
+	afiSafiMap := map[uint32]bool{
+		packet.GetProtocolFamily(packet.AfiIP, packet.SafiUnicast):  true,
+		packet.GetProtocolFamily(packet.AfiIP6, packet.SafiUnicast): true,
+		packet.GetProtocolFamily(packet.AfiIP, packet.SafiMPLSVPN):  true,
+		packet.GetProtocolFamily(packet.AfiL2VPN, packet.SafiEVPN):  true,
+	}
+	optParams := packet.ConstructOptParams(65000, afiSafiMap, true, 2, false, 0, false, false, 0)
+	openMsg := packet.NewBGPOpenMessage(65000, 180, "10.0.0.1", optParams).Body.(*packet.BGPOpen)
+
+	addPathFamily := packet.GetAddPathFamily(openMsg)
+	for _, family := range []uint32{packet.GetProtocolFamily(packet.AfiIP, packet.SafiUnicast),
+		packet.GetProtocolFamily(packet.AfiIP6, packet.SafiUnicast)} {
+		afi, safi := packet.GetAfiSafi(family)
+		if !packet.IsAddPathsEnabled(addPathFamily, afi, safi, packet.BGPCapAddPathRx|packet.BGPCapAddPathTx) {
+			t.Error("Add paths capability not advertised for AFI", afi, "SAFI", safi)
+		}
+	}
+	if _, ok := addPathFamily[packet.AfiL2VPN]; ok {
+		t.Error("Add paths capability advertised for L2VPN EVPN, flags", addPathFamily[packet.AfiL2VPN])
+	}
+	if _, ok := addPathFamily[packet.AfiIP][packet.SafiMPLSVPN]; ok {
+		t.Error("Add paths capability advertised for VPN-IPv4, flags", addPathFamily[packet.AfiIP])
+	}
+}
+
+func TestBGPMPReachNLRIInvalidNextHopLen(t *testing.T) {
+	pkt, _ := hex.DecodeString("800e0a0002010520010db80000")
+	peerAttrs := packet.BGPPeerAttrs{
+		ASSize:           4,
+		AddPathsRxActual: false,
+	}
+	mpReach := &packet.BGPPathAttrMPReachNLRI{}
+	if err := mpReach.Decode(pkt, peerAttrs); err == nil {
+		t.Error("MP_REACH_NLRI with next hop length 5 decoded without error")
+	}
+}
+
+func TestBGPMPUnreachNLRIIPv6(t *testing.T) {
+	mpUnreach := packet.NewBGPPathAttrMPUnreachNLRI(packet.AfiIP6, packet.SafiUnicast)
+	mpUnreach.SetNLRIList([]packet.NLRI{packet.NewIPPrefix(net.ParseIP("2001:db8:1::"), 48)})
+	pkt, err := mpUnreach.Encode()
+	if err != nil {
+		t.Fatal("MP_UNREACH_NLRI encode failed with error", err)
+	}
+	if hex.EncodeToString(pkt) != "800f0a0002013020010db80001" {
+		t.Error("Encoded MP_UNREACH_NLRI", hex.EncodeToString(pkt), "does not match 800f0a0002013020010db80001")
+	}
+
+	peerAttrs := packet.BGPPeerAttrs{
+		ASSize:           4,
+		AddPathsRxActual: false,
+	}
+	decoded := &packet.BGPPathAttrMPUnreachNLRI{}
+	err = decoded.Decode(pkt, peerAttrs)
+	if err != nil {
+		t.Fatal("MP_UNREACH_NLRI decode failed with error", err)
+	}
+	if decoded.AFI != packet.AfiIP6 || len(decoded.NLRI) != 1 ||
+		!decoded.NLRI[0].GetPrefix().Prefix.Equal(net.ParseIP("2001:db8:1::")) {
+		t.Error("MP_UNREACH_NLRI decoded as", decoded)
+	}
+}
+
+func TestBGPMPEndOfRIB(t *testing.T) {
+	pkt, err := packet.NewBGPMPEndOfRIBMessage(packet.AfiIP6, packet.SafiUnicast).Encode()
+	if err != nil {
+		t.Fatal("End-of-RIB encode failed with error", err)
+	}
+
+	bgpHeader := packet.NewBGPHeader()
+	err = bgpHeader.Decode(pkt[:19])
+	if err != nil {
+		t.Fatal("BGP packet header decode failed with error", err)
+	}
+
+	peerAttrs := packet.BGPPeerAttrs{
+		ASSize:           4,
+		AddPathsRxActual: false,
+	}
+	bgpMessage := packet.NewBGPMessage()
+	err = bgpMessage.Decode(bgpHeader, pkt[19:], peerAttrs)
+	if err != nil {
+		t.Fatal("End-of-RIB decode failed with error", err)
+	}
+	protoFamily, ok := packet.GetEndOfRIBFamily(bgpMessage.Body.(*packet.BGPUpdate))
+	if !ok || protoFamily != packet.GetProtocolFamily(packet.AfiIP6, packet.SafiUnicast) {
+		t.Error("Decoded update message is not an IPv6 End-of-RIB marker, family", protoFamily)
+	}
+}
+
+func TestBGPMPReachNLRIVPN(t *testing.T) {
+	strPkt := "800e20000180" + "0c00000000000000000a000001" + "00" + "70000641" + "0000fde800000001" + "c0a801"
+	pkt, err := hex.DecodeString(strPkt)
+	if err != nil {
+		t.Fatal("Failed to decode the string to hex, string =", strPkt)
+	}
+
+	peerAttrs := packet.BGPPeerAttrs{
+		ASSize:           4,
+		AddPathsRxActual: false,
+	}
+	mpReach := &packet.BGPPathAttrMPReachNLRI{}
+	err = mpReach.Decode(pkt, peerAttrs)
+	if err != nil {
+		t.Fatal("MP_REACH_NLRI decode failed with error", err)
+	}
+
+	if mpReach.AFI != packet.AfiIP || mpReach.SAFI != packet.SafiMPLSVPN {
+		t.Error("MP_REACH_NLRI decoded with AFI", mpReach.AFI, "SAFI", mpReach.SAFI, "expected AFI 1 SAFI 128")
+	}
+	if !mpReach.NextHop.Equal(net.ParseIP("10.0.0.1")) {
+		t.Error("MP_REACH_NLRI decoded with next hop", mpReach.NextHop, "expected 10.0.0.1")
+	}
+	if len(mpReach.NLRI) != 1 {
+		t.Fatal("MP_REACH_NLRI decoded with", len(mpReach.NLRI), "NLRI, expected 1")
+	}
+	vpnPrefix, ok := mpReach.NLRI[0].(*packet.VPNPrefix)
+	if !ok {
+		t.Fatal("MP_REACH_NLRI decoded NLRI", mpReach.NLRI[0], "is not a VPN prefix")
+	}
+	if vpnPrefix.RD.String() != "65000:1" || len(vpnPrefix.Labels) != 1 || vpnPrefix.Labels[0] != 100 {
+		t.Error("VPN prefix decoded with RD", vpnPrefix.RD, "labels", vpnPrefix.Labels, "expected RD 65000:1 label 100")
+	}
+	if !vpnPrefix.Prefix.Equal(net.ParseIP("192.168.1.0")) || vpnPrefix.Length != 24 {
+		t.Error("VPN prefix decoded with prefix", vpnPrefix.Prefix, "length", vpnPrefix.Length,
+			"expected 192.168.1.0/24")
+	}
+	if packet.GetNLRIProtocolFamily(vpnPrefix) != packet.GetProtocolFamily(packet.AfiIP, packet.SafiMPLSVPN) {
+		t.Error("VPN prefix protocol family", packet.GetNLRIProtocolFamily(vpnPrefix), "is not VPN-IPv4")
+	}
+
+	encPkt, err := mpReach.Encode()
+	if err != nil {
+		t.Fatal("MP_REACH_NLRI encode failed with error", err)
+	}
+	if hex.EncodeToString(encPkt) != strPkt {
+		t.Error("Encoded MP_REACH_NLRI", hex.EncodeToString(encPkt), "does not match", strPkt)
+	}
+}
+
+func TestBGPMPReachNLRILabeledUnicast(t *testing.T) {
+	mpReach := packet.NewBGPPathAttrMPReachNLRI(packet.AfiIP, packet.SafiLabeledUnicast)
+	mpReach.SetNextHop(net.ParseIP("10.0.0.1"), nil)
+	prefix := packet.NewIPPrefix(net.ParseIP("10.1.0.0"), 16)
+	mpReach.SetNLRIList([]packet.NLRI{packet.NewLabeledPrefix(*prefix, []uint32{16, 17})})
+	pkt, err := mpReach.Encode()
+	if err != nil {
+		t.Fatal("MP_REACH_NLRI encode failed with error", err)
+	}
+	strPkt := "800e12000104040a00000100" + "400001000001110a01"
+	if hex.EncodeToString(pkt) != strPkt {
+		t.Error("Encoded MP_REACH_NLRI", hex.EncodeToString(pkt), "does not match", strPkt)
+	}
+
+	peerAttrs := packet.BGPPeerAttrs{
+		ASSize:           4,
+		AddPathsRxActual: false,
+	}
+	decoded := &packet.BGPPathAttrMPReachNLRI{}
+	err = decoded.Decode(pkt, peerAttrs)
+	if err != nil {
+		t.Fatal("MP_REACH_NLRI decode failed with error", err)
+	}
+	if len(decoded.NLRI) != 1 {
+		t.Fatal("MP_REACH_NLRI decoded with", len(decoded.NLRI), "NLRI, expected 1")
+	}
+	labels := packet.GetNLRILabels(decoded.NLRI[0])
+	if len(labels) != 2 || labels[0] != 16 || labels[1] != 17 {
+		t.Error("Labeled prefix decoded with labels", labels, "expected [16 17]")
+	}
+	ipPrefix := decoded.NLRI[0].GetPrefix()
+	if !ipPrefix.Prefix.Equal(net.ParseIP("10.1.0.0")) || ipPrefix.Length != 16 {
+		t.Error("Labeled prefix decoded with prefix", ipPrefix.Prefix, "length", ipPrefix.Length,
+			"expected 10.1.0.0/16")
+	}
+}
+
+func TestBGPMPReachNLRIEVPNInclusiveMulticast(t *testing.T) {
+	strPkt := "800e1c001946040a00000100" + "0311" + "0000fde800000001" + "00000000" + "200a000001"
+	pkt, err := hex.DecodeString(strPkt)
+	if err != nil {
+		t.Fatal("Failed to decode the string to hex, string =", strPkt)
+	}
+
+	peerAttrs := packet.BGPPeerAttrs{
+		ASSize:           4,
+		AddPathsRxActual: false,
+	}
+	mpReach := &packet.BGPPathAttrMPReachNLRI{}
+	err = mpReach.Decode(pkt, peerAttrs)
+	if err != nil {
+		t.Fatal("MP_REACH_NLRI decode failed with error", err)
+	}
+
+	if mpReach.AFI != packet.AfiL2VPN || mpReach.SAFI != packet.SafiEVPN {
+		t.Error("MP_REACH_NLRI decoded with AFI", mpReach.AFI, "SAFI", mpReach.SAFI, "expected AFI 25 SAFI 70")
+	}
+	if !mpReach.NextHop.Equal(net.ParseIP("10.0.0.1")) {
+		t.Error("MP_REACH_NLRI decoded with next hop", mpReach.NextHop, "expected 10.0.0.1")
+	}
+	if len(mpReach.NLRI) != 1 {
+		t.Fatal("MP_REACH_NLRI decoded with", len(mpReach.NLRI), "NLRI, expected 1")
+	}
+	evpnNLRI, ok := mpReach.NLRI[0].(*packet.EVPNNLRI)
+	if !ok {
+		t.Fatal("MP_REACH_NLRI decoded NLRI", mpReach.NLRI[0], "is not an EVPN NLRI")
+	}
+	if evpnNLRI.RouteType != packet.EVPNRouteTypeInclusiveMulticast || evpnNLRI.RD.String() != "65000:1" {
+		t.Error("EVPN NLRI decoded with route type", evpnNLRI.RouteType, "RD", evpnNLRI.RD,
+			"expected route type 3 RD 65000:1")
+	}
+	if !evpnNLRI.IP.Equal(net.ParseIP("10.0.0.1")) {
+		t.Error("EVPN NLRI decoded with originator IP", evpnNLRI.IP, "expected 10.0.0.1")
+	}
+	if packet.GetNLRIProtocolFamily(evpnNLRI) != packet.GetProtocolFamily(packet.AfiL2VPN, packet.SafiEVPN) {
+		t.Error("EVPN NLRI protocol family", packet.GetNLRIProtocolFamily(evpnNLRI), "is not L2VPN EVPN")
+	}
+
+	encPkt, err := mpReach.Encode()
+	if err != nil {
+		t.Fatal("MP_REACH_NLRI encode failed with error", err)
+	}
+	if hex.EncodeToString(encPkt) != strPkt {
+		t.Error("Encoded MP_REACH_NLRI", hex.EncodeToString(encPkt), "does not match", strPkt)
+	}
+}
+
+func TestBGPMPReachNLRIEVPNRoutes(t *testing.T) {
+	rd, err := packet.ParseRouteDistinguisher("10.0.0.1:100")
+	if err != nil {
+		t.Fatal("Failed to parse route distinguisher, error:", err)
+	}
+	mac, _ := net.ParseMAC("00:11:22:33:44:55")
+	prefix := packet.NewIPPrefix(net.ParseIP("192.168.1.0"), 24)
+	nlriList := []packet.NLRI{
+		packet.NewEVPNMACIPAdvertisement(rd, 0, mac, net.ParseIP("192.168.1.10"), []uint32{10000}),
+		packet.NewEVPNMACIPAdvertisement(rd, 0, mac, nil, []uint32{10000}),
+		packet.NewEVPNIPPrefix(rd, 0, *prefix, 20000),
+	}
+
+	mpReach := packet.NewBGPPathAttrMPReachNLRI(packet.AfiL2VPN, packet.SafiEVPN)
+	mpReach.SetNextHop(net.ParseIP("10.0.0.1"), nil)
+	mpReach.SetNLRIList(nlriList)
+	pkt, err := mpReach.Encode()
+	if err != nil {
+		t.Fatal("MP_REACH_NLRI encode failed with error", err)
+	}
+
+	peerAttrs := packet.BGPPeerAttrs{
+		ASSize:           4,
+		AddPathsRxActual: false,
+	}
+	decoded := &packet.BGPPathAttrMPReachNLRI{}
+	err = decoded.Decode(pkt, peerAttrs)
+	if err != nil {
+		t.Fatal("MP_REACH_NLRI decode failed with error", err)
+	}
+	if len(decoded.NLRI) != len(nlriList) {
+		t.Fatal("MP_REACH_NLRI decoded with", len(decoded.NLRI), "NLRI, expected", len(nlriList))
+	}
+
+	for idx, nlri := range decoded.NLRI {
+		evpnNLRI, ok := nlri.(*packet.EVPNNLRI)
+		if !ok {
+			t.Fatal("MP_REACH_NLRI decoded NLRI", nlri, "is not an EVPN NLRI")
+		}
+		if evpnNLRI.String() != nlriList[idx].(*packet.EVPNNLRI).String() {
+			t.Error("EVPN NLRI decoded as", evpnNLRI, "expected", nlriList[idx])
+		}
+	}
+
+	macIP := decoded.NLRI[0].(*packet.EVPNNLRI)
+	if macIP.MAC.String() != mac.String() || !macIP.IP.Equal(net.ParseIP("192.168.1.10")) ||
+		macIP.GetVNI() != 10000 {
+		t.Error("MAC/IP advertisement decoded with MAC", macIP.MAC, "IP", macIP.IP, "VNI", macIP.GetVNI(),
+			"expected MAC", mac, "IP 192.168.1.10 VNI 10000")
+	}
+	if decoded.NLRI[1].(*packet.EVPNNLRI).IP != nil {
+		t.Error("MAC advertisement decoded with IP", decoded.NLRI[1].(*packet.EVPNNLRI).IP, "expected no IP")
+	}
+	ipPrefix := decoded.NLRI[2].(*packet.EVPNNLRI)
+	if !ipPrefix.GetPrefix().Prefix.Equal(net.ParseIP("192.168.1.0")) || ipPrefix.GetPrefix().Length != 24 ||
+		ipPrefix.GetVNI() != 20000 {
+		t.Error("IP prefix route decoded with prefix", ipPrefix.GetPrefix(), "VNI", ipPrefix.GetVNI(),
+			"expected 192.168.1.0/24 VNI 20000")
+	}
+}
+
+func TestBGPPathAttrPMSITunnel(t *testing.T) {
+	strPkt := "c0160900060027100a000001"
+	pkt, err := hex.DecodeString(strPkt)
+	if err != nil {
+		t.Fatal("Failed to decode the string to hex, string =", strPkt)
+	}
+
+	peerAttrs := packet.BGPPeerAttrs{
+		ASSize:           4,
+		AddPathsRxActual: false,
+	}
+	pmsiTunnel := &packet.BGPPathAttrPMSITunnel{}
+	err = pmsiTunnel.Decode(pkt, peerAttrs)
+	if err != nil {
+		t.Fatal("PMSI tunnel attribute decode failed with error", err)
+	}
+	if pmsiTunnel.TunnelType != packet.PMSITunnelTypeIngressReplication || pmsiTunnel.Label != 10000 ||
+		!pmsiTunnel.TunnelId.Equal(net.ParseIP("10.0.0.1")) {
+		t.Error("PMSI tunnel attribute decoded with tunnel type", pmsiTunnel.TunnelType, "label",
+			pmsiTunnel.Label, "tunnel id", pmsiTunnel.TunnelId, "expected tunnel type 6 label 10000 tunnel id 10.0.0.1")
+	}
+
+	newPMSITunnel := packet.NewBGPPathAttrPMSITunnel(packet.PMSITunnelTypeIngressReplication, 10000,
+		net.ParseIP("10.0.0.1"))
+	encPkt, err := newPMSITunnel.Encode()
+	if err != nil {
+		t.Fatal("PMSI tunnel attribute encode failed with error", err)
+	}
+	if hex.EncodeToString(encPkt) != strPkt {
+		t.Error("Encoded PMSI tunnel attribute", hex.EncodeToString(encPkt), "does not match", strPkt)
+	}
+}
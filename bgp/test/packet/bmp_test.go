@@ -0,0 +1,129 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// bmp_test.go
+package packettest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"l3/bgp/packet"
+	"net"
+	"testing"
+)
+
+func TestBMPPeerDownMessage(t *testing.T) {
+	peerHeader := packet.NewBMPPeerHeader(net.ParseIP("10.1.1.2"), 65001, net.ParseIP("2.2.2.2"), 2, false)
+	notif := []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
+		0xFF, 0x00, 0x15, 0x03, 0x06, 0x02}
+	pkt, err := packet.NewBMPPeerDownMessage(peerHeader, packet.BMPPeerDownLocalNotification, notif).Encode()
+	if err != nil {
+		t.Fatal("BMP Peer Down message encode failed with error", err)
+	}
+
+	expectedLen := packet.BMPCommonHeaderLen + packet.BMPPeerHeaderLen + 1 + len(notif)
+	if len(pkt) != expectedLen {
+		t.Fatal("BMP Peer Down message len expected", expectedLen, "got", len(pkt))
+	}
+	if pkt[0] != packet.BMPVersion || pkt[5] != packet.BMPMsgTypePeerDown ||
+		binary.BigEndian.Uint32(pkt[1:5]) != uint32(expectedLen) {
+		t.Error("BMP common header is not encoded correctly, got", pkt[:packet.BMPCommonHeaderLen])
+	}
+
+	header := pkt[packet.BMPCommonHeaderLen:]
+	if header[0] != packet.BMPPeerTypeGlobal || header[1] != packet.BMPPeerFlag2ByteAS {
+		t.Error("BMP peer header type and flags expected", packet.BMPPeerTypeGlobal, packet.BMPPeerFlag2ByteAS,
+			"got", header[0], header[1])
+	}
+	if !bytes.Equal(header[10:26], append(make([]byte, 12), 10, 1, 1, 2)) {
+		t.Error("BMP peer header address is not encoded correctly, got", header[10:26])
+	}
+	if binary.BigEndian.Uint32(header[26:30]) != 65001 || !bytes.Equal(header[30:34], []byte{2, 2, 2, 2}) {
+		t.Error("BMP peer header AS and BGP id are not encoded correctly, got", header[26:34])
+	}
+
+	body := header[packet.BMPPeerHeaderLen:]
+	if body[0] != packet.BMPPeerDownLocalNotification || !bytes.Equal(body[1:], notif) {
+		t.Error("BMP Peer Down reason and data are not encoded correctly, got", body)
+	}
+}
+
+func TestBMPStatsReportMessage(t *testing.T) {
+	peerHeader := packet.NewBMPPeerHeader(net.ParseIP("2001:db8::2"), 65001, net.ParseIP("2.2.2.2"), 4, true)
+	stats := []packet.BMPStat{
+		packet.NewBMPStatAfiSafiGauge(packet.BMPStatAfiSafiAdjRIBInRoutes, packet.AfiIP6, packet.SafiUnicast, 10),
+		packet.NewBMPStatGauge(packet.BMPStatAdjRIBInRoutes, 10),
+	}
+	pkt, err := packet.NewBMPStatsReportMessage(peerHeader, stats).Encode()
+	if err != nil {
+		t.Fatal("BMP Statistics Report message encode failed with error", err)
+	}
+
+	expectedLen := packet.BMPCommonHeaderLen + packet.BMPPeerHeaderLen + 4 + 15 + 12
+	if len(pkt) != expectedLen || binary.BigEndian.Uint32(pkt[1:5]) != uint32(expectedLen) {
+		t.Fatal("BMP Statistics Report message len expected", expectedLen, "got", len(pkt))
+	}
+
+	header := pkt[packet.BMPCommonHeaderLen:]
+	if header[1] != packet.BMPPeerFlagIPv6|packet.BMPPeerFlagPostPolicy {
+		t.Error("BMP peer header flags expected", packet.BMPPeerFlagIPv6|packet.BMPPeerFlagPostPolicy,
+			"got", header[1])
+	}
+	if !bytes.Equal(header[10:26], net.ParseIP("2001:db8::2").To16()) {
+		t.Error("BMP peer header address is not encoded correctly, got", header[10:26])
+	}
+
+	body := header[packet.BMPPeerHeaderLen:]
+	expectedBody := []byte{0, 0, 0, 2, 0, 9, 0, 11, 0, 2, 1, 0, 0, 0, 0, 0, 0, 0, 10, 0, 7, 0, 8, 0, 0, 0, 0, 0,
+		0, 0, 10}
+	if !bytes.Equal(body, expectedBody) {
+		t.Error("BMP Statistics Report expected", expectedBody, "got", body)
+	}
+}
+
+func TestConstructFamilyUpdatePackets(t *testing.T) {
+	pathAttrs := packet.ConstructPathAttrForConnRoutes(net.ParseIP("10.1.10.10"), 12345)
+	nlri := []packet.NLRI{packet.NewIPPrefix(net.ParseIP("2001:db8:1::"), 48)}
+	updateMsg := &packet.BGPUpdate{
+		WithdrawnRoutes: []packet.NLRI{packet.NewIPPrefix(net.ParseIP("2001:db8:2::"), 48)},
+		PathAttributes:  pathAttrs,
+		NLRI:            nlri,
+	}
+
+	msgs := packet.ConstructFamilyUpdatePackets(packet.GetProtocolFamily(packet.AfiIP6, packet.SafiUnicast),
+		updateMsg)
+	if len(msgs) == 0 {
+		t.Fatal("ConstructFamilyUpdatePackets did not return any update message")
+	}
+
+	for _, msg := range msgs {
+		update := msg.Body.(*packet.BGPUpdate)
+		if len(update.NLRI) != 0 || len(update.WithdrawnRoutes) != 0 {
+			t.Error("IPv6 update message has NLRI", update.NLRI, "withdrawn routes", update.WithdrawnRoutes,
+				"outside the MP attrs")
+		}
+		if _, err := msg.Encode(); err != nil {
+			t.Error("IPv6 update message encode failed with error", err)
+		}
+	}
+}
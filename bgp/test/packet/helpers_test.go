@@ -25,8 +25,11 @@
 package packettest
 
 import (
+	"bytes"
+	"l3/bgp/config"
 	"l3/bgp/packet"
 	"net"
+	"strings"
 	"testing"
 )
 
@@ -101,3 +104,651 @@ func TestBGPUpdateMessageNLRILenMoreThanMaxAllowed(t *testing.T) {
 		}
 	}
 }
+
+func TestParseCommunity(t *testing.T) {
+	communityStrs := []string{"no-export", "NO-ADVERTISE", "100:200", "65536", "65536:1", "100:abc", "1:2:3"}
+	communities := []uint32{packet.BGPCommunityNoExport, packet.BGPCommunityNoAdvertise, 0x006400C8, 65536, 0, 0, 0}
+	expectedErr := []bool{false, false, false, false, true, true, true}
+
+	for idx, communityStr := range communityStrs {
+		community, err := packet.ParseCommunity(communityStr)
+		if expectedErr[idx] {
+			if err == nil {
+				t.Error("ParseCommunity called for", communityStr, "... expected failure, got NO error")
+			}
+		} else if err != nil || community != communities[idx] {
+			t.Error("ParseCommunity called for", communityStr, "... expected", communities[idx], "got", community,
+				"error:", err)
+		}
+	}
+}
+
+func TestSetAddRemoveCommunities(t *testing.T) {
+	pathAttrs := packet.ConstructPathAttrForConnRoutes(net.ParseIP("10.1.1.1"), 100)
+	numPathAttrs := len(pathAttrs)
+
+	pathAttrs = packet.AddCommunities(pathAttrs, []uint32{0x00010001, 0x00010002})
+	if len(pathAttrs) != numPathAttrs+1 || !packet.HasCommunity(pathAttrs, 0x00010002) {
+		t.Fatal("AddCommunities called... expected COMMUNITIES path attr, got", pathAttrs)
+	}
+
+	pathAttrs = packet.SetCommunities(pathAttrs, []uint32{packet.BGPCommunityNoExport})
+	if len(packet.GetCommunities(pathAttrs)) != 1 || !packet.HasCommunity(pathAttrs, packet.BGPCommunityNoExport) {
+		t.Error("SetCommunities called... expected only no-export, got", packet.GetCommunities(pathAttrs))
+	}
+
+	pathAttrs = packet.RemoveCommunities(pathAttrs, []uint32{packet.BGPCommunityNoExport})
+	if len(pathAttrs) != numPathAttrs {
+		t.Error("RemoveCommunities called... expected COMMUNITIES path attr to be removed, got", pathAttrs)
+	}
+}
+
+func TestGetOriginAS(t *testing.T) {
+	pathAttrs := packet.ConstructPathAttrForConnRoutes(net.ParseIP("10.1.1.1"), 100)
+	if originAS, ok := packet.GetOriginAS(pathAttrs); !ok || originAS != 0 {
+		t.Fatal("GetOriginAS called... expected origin AS 0 for an empty AS path, got", originAS, ok)
+	}
+
+	asPath := packet.NewBGPPathAttrASPath()
+	seq := packet.NewBGPAS4PathSegmentSeq()
+	seq.AppendAS(65001)
+	seq.AppendAS(65002)
+	asPath.AppendASPathSegment(seq)
+	pathAttrs = []packet.BGPPathAttr{packet.NewBGPPathAttrOrigin(packet.BGPPathAttrOriginIGP), asPath}
+	if originAS, ok := packet.GetOriginAS(pathAttrs); !ok || originAS != 65002 {
+		t.Error("GetOriginAS called... expected origin AS 65002, got", originAS, ok)
+	}
+
+	set := packet.NewBGPAS4PathSegmentSet()
+	set.AppendAS(65003)
+	asPath.AppendASPathSegment(set)
+	if originAS, ok := packet.GetOriginAS(pathAttrs); ok {
+		t.Error("GetOriginAS called... expected no origin AS for an AS path ending with an AS_SET, got", originAS)
+	}
+}
+
+func TestGetNeighborAS(t *testing.T) {
+	asPath := packet.NewBGPPathAttrASPath()
+	asPath.ASSize = 4
+	pathAttrs := []packet.BGPPathAttr{packet.NewBGPPathAttrOrigin(packet.BGPPathAttrOriginIGP), asPath}
+	updateMsg := packet.NewBGPUpdateMessage(make([]packet.NLRI, 0), pathAttrs, make([]packet.NLRI, 0))
+	if neighborAS := packet.GetNeighborAS(pathAttrs); neighborAS != 0 {
+		t.Fatal("GetNeighborAS called... expected neighbor AS 0 for an empty AS path, got", neighborAS)
+	}
+
+	packet.PrependAS(updateMsg, 65002, 4)
+	packet.PrependAS(updateMsg, 65001, 4)
+	packet.PrependConfedAS(updateMsg, 65010, 4)
+	if neighborAS := packet.GetNeighborAS(pathAttrs); neighborAS != 65001 {
+		t.Error("GetNeighborAS called... expected neighbor AS 65001, got", neighborAS)
+	}
+}
+
+func TestGetASList(t *testing.T) {
+	asPath := packet.NewBGPPathAttrASPath()
+	asPath.ASSize = 4
+	set := packet.NewBGPAS4PathSegmentSet()
+	set.AppendAS(65003)
+	set.AppendAS(65004)
+	asPath.AppendASPathSegment(set)
+	pathAttrs := []packet.BGPPathAttr{packet.NewBGPPathAttrOrigin(packet.BGPPathAttrOriginIGP), asPath}
+	updateMsg := packet.NewBGPUpdateMessage(make([]packet.NLRI, 0), pathAttrs, make([]packet.NLRI, 0))
+
+	packet.PrependAS(updateMsg, 65002, 4)
+	packet.PrependAS(updateMsg, 65001, 4)
+	packet.PrependConfedAS(updateMsg, 65010, 4)
+	asList := strings.Join(packet.GetASList(pathAttrs), " ")
+	if asList != "65001 65002 { 65003, 65004 }" {
+		t.Error("GetASList called... expected AS list 65001 65002 { 65003, 65004 }, got", asList)
+	}
+}
+
+func TestPrependASToPathAttrs(t *testing.T) {
+	asPath := packet.NewBGPPathAttrASPath()
+	asPath.ASSize = 2
+	pathAttrs := []packet.BGPPathAttr{packet.NewBGPPathAttrOrigin(packet.BGPPathAttrOriginIGP), asPath}
+
+	packet.PrependASToPathAttrs(pathAttrs, 65001, 1)
+	packet.PrependASToPathAttrs(pathAttrs, 4200000001, 2)
+	asList := strings.Join(packet.GetASList(pathAttrs), " ")
+	if asList != "23456 23456 65001" {
+		t.Error("PrependASToPathAttrs called... expected AS list 23456 23456 65001, got", asList)
+	}
+	if len(asPath.Value) != 1 || asPath.Length != asPath.Value[0].TotalLen() {
+		t.Error("PrependASToPathAttrs called... expected one AS_SEQUENCE with attr length", asPath.Value[0].TotalLen(),
+			"got", len(asPath.Value), "segments with attr length", asPath.Length)
+	}
+}
+
+func TestSetMEDInPathAttrs(t *testing.T) {
+	pathAttrs := []packet.BGPPathAttr{packet.NewBGPPathAttrOrigin(packet.BGPPathAttrOriginIGP),
+		packet.NewBGPPathAttrASPath(), packet.NewBGPPathAttrLocalPref()}
+
+	pathAttrs = packet.SetMEDInPathAttrs(pathAttrs, 100)
+	if med, ok := packet.GetMED(pathAttrs); !ok || med != 100 {
+		t.Fatal("SetMEDInPathAttrs called... expected MED 100, got", med, ok)
+	}
+	if len(pathAttrs) != 4 || pathAttrs[2].GetCode() != packet.BGPPathAttrTypeMultiExitDisc {
+		t.Error("SetMEDInPathAttrs called... expected MULTI_EXIT_DISC before LOCAL_PREF, got", pathAttrs)
+	}
+
+	pathAttrs = packet.SetMEDInPathAttrs(pathAttrs, 200)
+	if med, _ := packet.GetMED(pathAttrs); med != 200 || len(pathAttrs) != 4 {
+		t.Error("SetMEDInPathAttrs called... expected MED 200 in the existing attr, got", med, len(pathAttrs))
+	}
+}
+
+func TestConfedASPathSegments(t *testing.T) {
+	asPath := packet.NewBGPPathAttrASPath()
+	asPath.ASSize = 4
+	seq := packet.NewBGPAS4PathSegmentSeq()
+	seq.AppendAS(65002)
+	asPath.AppendASPathSegment(seq)
+	pathAttrs := []packet.BGPPathAttr{packet.NewBGPPathAttrOrigin(packet.BGPPathAttrOriginIGP), asPath}
+	updateMsg := packet.NewBGPUpdateMessage(make([]packet.NLRI, 0), pathAttrs, make([]packet.NLRI, 0))
+
+	packet.PrependConfedAS(updateMsg, 65010, 4)
+	packet.PrependConfedAS(updateMsg, 65011, 4)
+	if len(asPath.Value) != 2 || asPath.Value[0].GetType() != packet.BGPASPathSegmentConfedSequence ||
+		asPath.Value[0].GetLen() != 2 {
+		t.Fatal("PrependConfedAS called... expected an AS_CONFED_SEQUENCE with 2 ASes, got", asPath.Value)
+	}
+
+	if numASes := packet.GetNumASes(pathAttrs); numASes != 1 {
+		t.Error("GetNumASes called... expected confed segments to be excluded, got", numASes)
+	}
+	if originAS, ok := packet.GetOriginAS(pathAttrs); !ok || originAS != 65002 {
+		t.Error("GetOriginAS called... expected origin AS 65002, got", originAS, ok)
+	}
+	if !packet.HasASLoop(pathAttrs, 65010) {
+		t.Error("HasASLoop called... expected a loop for the member AS in the AS_CONFED_SEQUENCE")
+	}
+
+	packet.RemoveConfedSegments(updateMsg)
+	packet.PrependAS(updateMsg, 65000, 4)
+	if len(asPath.Value) != 1 || asPath.Value[0].GetType() != packet.BGPASPathSegmentSequence ||
+		packet.GetNumASes(pathAttrs) != 2 {
+		t.Fatal("RemoveConfedSegments called... expected a single AS_SEQUENCE with 2 ASes, got", asPath.Value)
+	}
+	if asPath.BGPPathAttrBase.Length != 10 {
+		t.Error("RemoveConfedSegments called... expected AS path length 10, got", asPath.BGPPathAttrBase.Length)
+	}
+}
+
+func TestPrivateASPathUpdates(t *testing.T) {
+	if !packet.IsPrivateAS(64512) || !packet.IsPrivateAS(4200000000) || packet.IsPrivateAS(65535) ||
+		packet.IsPrivateAS(100) {
+		t.Fatal("IsPrivateAS called... wrong private AS ranges")
+	}
+
+	asPath := packet.NewBGPPathAttrASPath()
+	asPath.ASSize = 4
+	seq := packet.NewBGPAS4PathSegmentSeq()
+	seq.AppendAS(65001)
+	seq.AppendAS(100)
+	seq.AppendAS(65001)
+	asPath.AppendASPathSegment(seq)
+	set := packet.NewBGPAS4PathSegmentSet()
+	set.AppendAS(65002)
+	asPath.AppendASPathSegment(set)
+	pathAttrs := []packet.BGPPathAttr{packet.NewBGPPathAttrOrigin(packet.BGPPathAttrOriginIGP), asPath}
+	updateMsg := packet.NewBGPUpdateMessage(make([]packet.NLRI, 0), pathAttrs, make([]packet.NLRI, 0))
+
+	if count := packet.CountASInPath(pathAttrs, 65001); count != 2 {
+		t.Error("CountASInPath called... expected AS 65001 twice, got", count)
+	}
+
+	packet.ReplaceAS(updateMsg, 65001, 200)
+	if count := packet.CountASInPath(pathAttrs, 200); count != 2 || packet.HasASLoop(pathAttrs, 65001) {
+		t.Error("ReplaceAS called... expected AS 65001 to be replaced by AS 200, got", asPath.Value)
+	}
+
+	packet.RemovePrivateAS(updateMsg, 300)
+	if !packet.HasASLoop(pathAttrs, 300) || packet.HasASLoop(pathAttrs, 65002) || len(asPath.Value) != 2 {
+		t.Error("RemovePrivateAS called... expected AS 65002 to be replaced by AS 300, got", asPath.Value)
+	}
+
+	seq.AppendAS(65003)
+	asPath.BGPPathAttrBase.Length += 4
+	packet.RemovePrivateAS(updateMsg, 0)
+	if len(asPath.Value) != 2 || packet.GetNumASes(pathAttrs) != 4 || packet.HasASLoop(pathAttrs, 65003) {
+		t.Error("RemovePrivateAS called... expected AS 65003 to be removed, got", asPath.Value)
+	}
+	if asPath.BGPPathAttrBase.Length != seq.TotalLen()+set.TotalLen() {
+		t.Error("RemovePrivateAS called... AS path length", asPath.BGPPathAttrBase.Length,
+			"does not match the segments length", seq.TotalLen()+set.TotalLen())
+	}
+}
+
+func TestPathAttrsEqual(t *testing.T) {
+	pathAttrs := packet.ConstructPathAttrForConnRoutes(net.ParseIP("10.1.1.1"), 100)
+	cloned := packet.ClonePathAttrs(pathAttrs)
+	if !packet.PathAttrsEqual(pathAttrs, cloned) {
+		t.Fatal("PathAttrsEqual called... expected cloned path attrs to be equal, got", pathAttrs, cloned)
+	}
+
+	cloned = packet.AddCommunities(cloned, []uint32{0x00010001})
+	if packet.PathAttrsEqual(pathAttrs, cloned) {
+		t.Error("PathAttrsEqual called... expected path attrs with a new community to be different")
+	}
+
+	cloned = packet.ClonePathAttrs(pathAttrs)
+	packet.SetNextHopPathAttrs(cloned, net.ParseIP("10.1.1.2"))
+	if packet.PathAttrsEqual(pathAttrs, cloned) {
+		t.Error("PathAttrsEqual called... expected path attrs with a different next hop to be different")
+	}
+}
+
+func TestParseExtCommunity(t *testing.T) {
+	extCommunityStrs := []string{"rt:65000:100", "ro:1.2.3.4:10", "rt:4200000000:10", "rt:65000", "soo:1:1",
+		"rt:1.2.3.4:65536"}
+	extCommunities := []uint64{0x0002FDE800000064, 0x010301020304000A, 0x0202FA56EA00000A, 0, 0, 0}
+	expectedErr := []bool{false, false, false, true, true, true}
+
+	for idx, extCommunityStr := range extCommunityStrs {
+		extCommunity, err := packet.ParseExtCommunity(extCommunityStr)
+		if expectedErr[idx] {
+			if err == nil {
+				t.Error("ParseExtCommunity called for", extCommunityStr, "... expected failure, got NO error")
+			}
+			continue
+		}
+
+		if err != nil || extCommunity != extCommunities[idx] {
+			t.Error("ParseExtCommunity called for", extCommunityStr, "... expected", extCommunities[idx],
+				"got", extCommunity, "error:", err)
+		} else if str := packet.ExtCommunityToString(extCommunity); str != extCommunityStr {
+			t.Error("ExtCommunityToString called... expected", extCommunityStr, "got", str)
+		}
+	}
+}
+
+func TestParseLargeCommunity(t *testing.T) {
+	largeCommunityStrs := []string{"4200000000:1:2", "1:2", "1:2:4294967296"}
+	expectedErr := []bool{false, true, true}
+
+	for idx, largeCommunityStr := range largeCommunityStrs {
+		largeCommunity, err := packet.ParseLargeCommunity(largeCommunityStr)
+		if expectedErr[idx] {
+			if err == nil {
+				t.Error("ParseLargeCommunity called for", largeCommunityStr, "... expected failure, got NO error")
+			}
+		} else if err != nil || packet.LargeCommunityToString(largeCommunity) != largeCommunityStr {
+			t.Error("ParseLargeCommunity called for", largeCommunityStr, "... got", largeCommunity, "error:", err)
+		}
+	}
+}
+
+func TestConstructMaxSizedUpdatePacketsEndOfRIB(t *testing.T) {
+	updateMsgs := packet.ConstructMaxSizedUpdatePackets(packet.NewBGPEndOfRIBMessage())
+	if len(updateMsgs) != 1 {
+		t.Fatal("Expected 1 End-of-RIB message, got", len(updateMsgs))
+	}
+	if !packet.IsEndOfRIB(updateMsgs[0].Body.(*packet.BGPUpdate)) {
+		t.Error("Constructed update message is not an End-of-RIB marker")
+	}
+}
+
+func TestSplitMPUpdate(t *testing.T) {
+	nextHop := packet.NewBGPPathAttrNextHop()
+	nextHop.Value = net.ParseIP("10.1.1.1")
+	mpReach := packet.NewBGPPathAttrMPReachNLRI(packet.AfiIP6, packet.SafiUnicast)
+	mpReach.SetNextHop(net.ParseIP("2001:db8::1"), net.ParseIP("fe80::1"))
+	mpReach.SetNLRIList([]packet.NLRI{packet.NewIPPrefix(net.ParseIP("2001:db8:1::"), 64)})
+	mpUnreach := packet.NewBGPPathAttrMPUnreachNLRI(packet.AfiIP6, packet.SafiUnicast)
+	mpUnreach.SetNLRIList([]packet.NLRI{packet.NewIPPrefix(net.ParseIP("2001:db8:2::"), 64)})
+	pathAttrs := []packet.BGPPathAttr{packet.NewBGPPathAttrOrigin(packet.BGPPathAttrOriginIGP),
+		packet.NewBGPPathAttrASPath(), nextHop, mpReach, mpUnreach}
+	ipv4NLRI := []packet.NLRI{packet.NewIPPrefix(net.ParseIP("20.1.1.0"), 24)}
+	updateMsg := packet.NewBGPUpdateMessage(make([]packet.NLRI, 0), pathAttrs, ipv4NLRI).Body.(*packet.BGPUpdate)
+
+	updates := packet.SplitMPUpdate(updateMsg)
+	if len(updates) != 2 {
+		t.Fatal("SplitMPUpdate returned", len(updates), "updates, expected 2")
+	}
+
+	ipv4Update := updates[packet.GetProtocolFamily(packet.AfiIP, packet.SafiUnicast)]
+	if ipv4Update == nil || len(ipv4Update.NLRI) != 1 || len(ipv4Update.PathAttributes) != 3 {
+		t.Fatal("SplitMPUpdate IPv4 update", ipv4Update, "expected 1 NLRI and 3 path attrs")
+	}
+	if packet.GetMPReachNLRI(ipv4Update.PathAttributes) != nil ||
+		!packet.GetNextHop(ipv4Update.PathAttributes).Equal(net.ParseIP("10.1.1.1")) {
+		t.Error("SplitMPUpdate IPv4 update has path attrs", ipv4Update.PathAttributes)
+	}
+
+	ipv6Update := updates[packet.GetProtocolFamily(packet.AfiIP6, packet.SafiUnicast)]
+	if ipv6Update == nil || len(ipv6Update.NLRI) != 1 || len(ipv6Update.WithdrawnRoutes) != 1 {
+		t.Fatal("SplitMPUpdate IPv6 update", ipv6Update, "expected 1 NLRI and 1 withdrawn route")
+	}
+	if !ipv6Update.NLRI[0].GetPrefix().Prefix.Equal(net.ParseIP("2001:db8:1::")) ||
+		!ipv6Update.WithdrawnRoutes[0].GetPrefix().Prefix.Equal(net.ParseIP("2001:db8:2::")) {
+		t.Error("SplitMPUpdate IPv6 update has NLRI", ipv6Update.NLRI, "withdrawn routes",
+			ipv6Update.WithdrawnRoutes)
+	}
+	ipv6MPReach := packet.GetMPReachNLRI(ipv6Update.PathAttributes)
+	if ipv6MPReach == nil || len(ipv6MPReach.NLRI) != 0 || packet.GetMPUnreachNLRI(ipv6Update.PathAttributes) != nil {
+		t.Fatal("SplitMPUpdate IPv6 update has path attrs", ipv6Update.PathAttributes)
+	}
+	if !packet.GetNextHop(ipv6Update.PathAttributes).Equal(net.ParseIP("2001:db8::1")) {
+		t.Error("SplitMPUpdate IPv6 update has next hop", packet.GetNextHop(ipv6Update.PathAttributes),
+			"expected 2001:db8::1")
+	}
+	if len(mpReach.NLRI) != 1 {
+		t.Error("SplitMPUpdate modified the MP_REACH_NLRI path attr of the received update")
+	}
+}
+
+func TestSplitMPUpdateExtendedNextHop(t *testing.T) {
+	mpReach := packet.NewBGPPathAttrMPReachNLRI(packet.AfiIP, packet.SafiUnicast)
+	mpReach.SetNextHop(net.ParseIP("fe80::1"), nil)
+	mpReach.SetNLRIList([]packet.NLRI{packet.NewIPPrefix(net.ParseIP("20.1.1.0"), 24)})
+	pathAttrs := []packet.BGPPathAttr{packet.NewBGPPathAttrOrigin(packet.BGPPathAttrOriginIGP),
+		packet.NewBGPPathAttrASPath(), mpReach}
+	withdrawn := []packet.NLRI{packet.NewIPPrefix(net.ParseIP("20.1.2.0"), 24)}
+	updateMsg := packet.NewBGPUpdateMessage(withdrawn, pathAttrs, make([]packet.NLRI, 0)).Body.(*packet.BGPUpdate)
+
+	updates := packet.SplitMPUpdate(updateMsg)
+	if len(updates) != 1 {
+		t.Fatal("SplitMPUpdate returned", len(updates), "updates, expected 1")
+	}
+
+	ipv4Update := updates[packet.GetProtocolFamily(packet.AfiIP, packet.SafiUnicast)]
+	if ipv4Update == nil || len(ipv4Update.NLRI) != 1 || len(ipv4Update.WithdrawnRoutes) != 1 {
+		t.Fatal("SplitMPUpdate IPv4 update", ipv4Update, "expected 1 NLRI and 1 withdrawn route")
+	}
+	if !packet.GetNextHop(ipv4Update.PathAttributes).Equal(net.ParseIP("fe80::1")) {
+		t.Error("SplitMPUpdate IPv4 update has next hop", packet.GetNextHop(ipv4Update.PathAttributes),
+			"expected fe80::1")
+	}
+
+	nextHop := packet.NewBGPPathAttrNextHop()
+	nextHop.Value = net.ParseIP("10.1.1.1")
+	ipv4Attrs := []packet.BGPPathAttr{packet.NewBGPPathAttrOrigin(packet.BGPPathAttrOriginIGP),
+		packet.NewBGPPathAttrASPath(), nextHop}
+	mpAttrs := packet.ConstructMPPathAttrs(ipv4Attrs, packet.AfiIP, packet.SafiUnicast)
+	mpReach = packet.GetMPReachNLRI(mpAttrs)
+	if mpReach == nil || mpReach.AFI != packet.AfiIP || len(mpAttrs) != 3 {
+		t.Fatal("ConstructMPPathAttrs for IPv4 returned path attrs", mpAttrs)
+	}
+	if len(mpReach.NextHop) != 16 {
+		t.Error("ConstructMPPathAttrs for IPv4 returned next hop", mpReach.NextHop, "expected an IPv6 address")
+	}
+}
+
+func TestConstructPathAttrsForFamily(t *testing.T) {
+	nextHop := packet.NewBGPPathAttrNextHop()
+	nextHop.Value = net.ParseIP("10.1.1.1")
+	pathAttrs := []packet.BGPPathAttr{packet.NewBGPPathAttrOrigin(packet.BGPPathAttrOriginIGP),
+		packet.NewBGPPathAttrASPath(), nextHop}
+
+	ipv6Attrs := packet.ConstructPathAttrsForFamily(pathAttrs, packet.AfiIP6, packet.SafiUnicast)
+	mpReach := packet.GetMPReachNLRI(ipv6Attrs)
+	if mpReach == nil || len(ipv6Attrs) != 3 {
+		t.Fatal("ConstructPathAttrsForFamily for IPv6 returned path attrs", ipv6Attrs)
+	}
+	if mpReach.AFI != packet.AfiIP6 || !mpReach.NextHop.Equal(net.ParseIP("::ffff:10.1.1.1")) {
+		t.Error("ConstructPathAttrsForFamily for IPv6 returned MP_REACH_NLRI with AFI", mpReach.AFI,
+			"next hop", mpReach.NextHop)
+	}
+
+	ipv4Attrs := packet.ConstructPathAttrsForFamily(ipv6Attrs, packet.AfiIP, packet.SafiUnicast)
+	if packet.GetMPReachNLRI(ipv4Attrs) != nil || len(ipv4Attrs) != 3 {
+		t.Fatal("ConstructPathAttrsForFamily for IPv4 returned path attrs", ipv4Attrs)
+	}
+	if !packet.GetNextHop(ipv4Attrs).Equal(net.ParseIP("10.1.1.1")) {
+		t.Error("ConstructPathAttrsForFamily for IPv4 returned next hop", packet.GetNextHop(ipv4Attrs))
+	}
+}
+
+func TestConstructMaxSizedUpdatePacketsMP(t *testing.T) {
+	numRoutes := 1000
+	nlriList := make([]packet.NLRI, 0, numRoutes)
+	for i := 0; i < numRoutes; i++ {
+		ip := net.ParseIP("2001:db8::")
+		ip[4] = byte(i >> 8)
+		ip[5] = byte(i)
+		nlriList = append(nlriList, packet.NewIPPrefix(ip, 48))
+	}
+
+	mpReach := packet.NewBGPPathAttrMPReachNLRI(packet.AfiIP6, packet.SafiUnicast)
+	mpReach.SetNextHop(net.ParseIP("2001:db8::1"), nil)
+	pathAttrs := []packet.BGPPathAttr{packet.NewBGPPathAttrOrigin(packet.BGPPathAttrOriginIGP),
+		packet.NewBGPPathAttrASPath(), mpReach}
+	updateMsgs := packet.ConstructMaxSizedUpdatePackets(packet.NewBGPUpdateMessage(make([]packet.NLRI, 0),
+		pathAttrs, nlriList))
+	if len(updateMsgs) < 2 {
+		t.Fatal("ConstructMaxSizedUpdatePackets returned", len(updateMsgs), "update messages, expected at least 2")
+	}
+
+	total := 0
+	for _, updateMsg := range updateMsgs {
+		pkt, err := updateMsg.Encode()
+		if err != nil {
+			t.Fatal("Update message encode failed with error", err)
+		}
+		if len(pkt) > int(packet.BGPMsgMaxLen) {
+			t.Error("Update message encoded with length", len(pkt), "more than max length", packet.BGPMsgMaxLen)
+		}
+
+		body := updateMsg.Body.(*packet.BGPUpdate)
+		if len(body.NLRI) != 0 {
+			t.Error("Update message has", len(body.NLRI), "IPv4 NLRI, expected 0")
+		}
+		total += len(packet.GetMPReachNLRI(body.PathAttributes).NLRI)
+	}
+	if total != numRoutes {
+		t.Error("Update messages have", total, "MP_REACH_NLRI prefixes, expected", numRoutes)
+	}
+
+	unreachAttrs := []packet.BGPPathAttr{packet.NewBGPPathAttrMPUnreachNLRI(packet.AfiIP6, packet.SafiUnicast)}
+	updateMsgs = packet.ConstructMaxSizedUpdatePackets(packet.NewBGPUpdateMessage(nlriList, unreachAttrs, nil))
+	total = 0
+	for _, updateMsg := range updateMsgs {
+		body := updateMsg.Body.(*packet.BGPUpdate)
+		if len(body.WithdrawnRoutes) != 0 {
+			t.Error("Update message has", len(body.WithdrawnRoutes), "IPv4 withdrawn routes, expected 0")
+		}
+		total += len(packet.GetMPUnreachNLRI(body.PathAttributes).NLRI)
+	}
+	if total != numRoutes {
+		t.Error("Update messages have", total, "MP_UNREACH_NLRI prefixes, expected", numRoutes)
+	}
+}
+
+func TestConstructMaxPrefixesCeaseData(t *testing.T) {
+	data := packet.ConstructMaxPrefixesCeaseData(packet.AfiIP6, packet.SafiUnicast, 1000)
+	expected := []byte{0x00, 0x02, 0x01, 0x00, 0x00, 0x03, 0xE8}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("Cease data %v does not match %v", data, expected)
+	}
+
+	msg := packet.NewBGPNotificationMessage(packet.BGPCease, packet.BGPCeaseMaxPrefixesReached, data)
+	pkt, err := msg.Encode()
+	if err != nil {
+		t.Fatal("Notification message encode failed with error", err)
+	}
+	if !bytes.Equal(pkt[packet.BGPMsgHeaderLen:], append([]byte{packet.BGPCease, 0x01}, expected...)) {
+		t.Errorf("Encoded notification message %v does not contain Cease subcode 1 with data %v",
+			pkt[packet.BGPMsgHeaderLen:], expected)
+	}
+}
+
+func TestShutdownCommunication(t *testing.T) {
+	data := packet.ConstructShutdownCommunicationData("maintenance")
+	expected := append([]byte{11}, []byte("maintenance")...)
+	if !bytes.Equal(data, expected) {
+		t.Errorf("Shutdown communication data %v does not match %v", data, expected)
+	}
+	if msg := packet.GetShutdownCommunication(data); msg != "maintenance" {
+		t.Errorf("Shutdown communication %q does not match %q", msg, "maintenance")
+	}
+
+	if data = packet.ConstructShutdownCommunicationData(""); data != nil {
+		t.Errorf("Shutdown communication data %v for an empty message, expected nil", data)
+	}
+
+	// A multi-byte character crossing the length limit is dropped
+	msg := strings.Repeat("a", packet.BGPShutdownCommunicationMaxLen-1) + "\u00e9"
+	data = packet.ConstructShutdownCommunicationData(msg)
+	if int(data[0]) != packet.BGPShutdownCommunicationMaxLen-1 || len(data) != packet.BGPShutdownCommunicationMaxLen {
+		t.Errorf("Shutdown communication length %d, expected %d", data[0], packet.BGPShutdownCommunicationMaxLen-1)
+	}
+	if got := packet.GetShutdownCommunication(data); got != msg[:packet.BGPShutdownCommunicationMaxLen-1] {
+		t.Errorf("Shutdown communication %q was not truncated on a character boundary", got)
+	}
+
+	if got := packet.GetShutdownCommunication([]byte{5, 'a', 'b'}); got != "" {
+		t.Errorf("Shutdown communication %q for a truncated data, expected empty string", got)
+	}
+}
+
+func TestGetPrefixLimitsFromConfig(t *testing.T) {
+	afiSafis := []config.AfiSafiConfig{
+		config.AfiSafiConfig{
+			AfiSafiName: "ipv4-unicast",
+			IPv4Unicast: config.IPUnicast{PrefixLimit: config.PrefixLimit{MaxPrefixes: 100}},
+		},
+		config.AfiSafiConfig{
+			AfiSafiName: "ipv6-unicast",
+			IPv6Unicast: config.IPUnicast{PrefixLimit: config.PrefixLimit{MaxPrefixes: 50, WarningOnly: true}},
+		},
+	}
+
+	prefixLimits := packet.GetPrefixLimitsFromConfig(&afiSafis)
+	ipv4Limit := prefixLimits[packet.GetProtocolFamily(packet.AfiIP, packet.SafiUnicast)]
+	if ipv4Limit.MaxPrefixes != 100 || ipv4Limit.WarningOnly {
+		t.Errorf("IPv4 unicast prefix limit %+v does not match the config", ipv4Limit)
+	}
+	ipv6Limit := prefixLimits[packet.GetProtocolFamily(packet.AfiIP6, packet.SafiUnicast)]
+	if ipv6Limit.MaxPrefixes != 50 || !ipv6Limit.WarningOnly {
+		t.Errorf("IPv6 unicast prefix limit %+v does not match the config", ipv6Limit)
+	}
+}
+
+func TestGetDefaultRouteFamiliesFromConfig(t *testing.T) {
+	afiSafis := []config.AfiSafiConfig{
+		config.AfiSafiConfig{
+			AfiSafiName: "ipv4-unicast",
+			IPv4Unicast: config.IPUnicast{SendDefaultRoute: true},
+		},
+		config.AfiSafiConfig{
+			AfiSafiName: "ipv6-unicast",
+			IPv6Unicast: config.IPUnicast{PrefixLimit: config.PrefixLimit{MaxPrefixes: 50}},
+		},
+	}
+
+	families := packet.GetDefaultRouteFamiliesFromConfig(&afiSafis)
+	if len(families) != 1 || !families[packet.GetProtocolFamily(packet.AfiIP, packet.SafiUnicast)] {
+		t.Errorf("Default route families %v do not match the config", families)
+	}
+}
+
+func TestGetAddPathsTxModesFromConfig(t *testing.T) {
+	afiSafis := []config.AfiSafiConfig{
+		config.AfiSafiConfig{
+			AfiSafiName:    "ipv6-unicast",
+			AddPathsTxMode: config.AddPathsTxModeAll,
+		},
+		config.AfiSafiConfig{
+			AfiSafiName:    "l3vpn-ipv4-unicast",
+			AddPathsTxMode: config.AddPathsTxModeAll,
+		},
+	}
+
+	txModes := packet.GetAddPathsTxModesFromConfig(config.AddPathsTxModeDiversePath, &afiSafis)
+	ipv4Family := packet.GetProtocolFamily(packet.AfiIP, packet.SafiUnicast)
+	if txModes[ipv4Family] != config.AddPathsTxModeDiversePath {
+		t.Error("IPv4 unicast add paths transmit mode", txModes[ipv4Family], "expected",
+			config.AddPathsTxModeDiversePath)
+	}
+	ipv6Family := packet.GetProtocolFamily(packet.AfiIP6, packet.SafiUnicast)
+	if txModes[ipv6Family] != config.AddPathsTxModeAll {
+		t.Error("IPv6 unicast add paths transmit mode", txModes[ipv6Family], "expected", config.AddPathsTxModeAll)
+	}
+	if txMode, ok := txModes[packet.GetProtocolFamily(packet.AfiIP, packet.SafiMPLSVPN)]; ok {
+		t.Error("VPN-IPv4 add paths transmit mode set to", txMode, "expected no mode")
+	}
+
+	txModes = packet.GetAddPathsTxModesFromConfig("", &afiSafis)
+	if txModes[ipv4Family] != config.AddPathsTxModeBestN {
+		t.Error("IPv4 unicast default add paths transmit mode", txModes[ipv4Family], "expected",
+			config.AddPathsTxModeBestN)
+	}
+}
+
+func TestAddressPrefixORF(t *testing.T) {
+	permitAll := packet.NewAddressPrefixORFEntry(packet.BGPORFActionAdd, packet.BGPORFMatchPermit, 30,
+		net.IPv4zero, 0, 0, 32)
+	permit := packet.NewAddressPrefixORFEntry(packet.BGPORFActionAdd, packet.BGPORFMatchPermit, 10,
+		net.ParseIP("10.1.0.0"), 16, 24, 32)
+	deny := packet.NewAddressPrefixORFEntry(packet.BGPORFActionAdd, packet.BGPORFMatchDeny, 20,
+		net.ParseIP("10.2.0.0"), 16, 0, 0)
+
+	prefixORF := packet.UpdateAddressPrefixORF(nil, []*packet.AddressPrefixORFEntry{deny, permit})
+	if len(prefixORF) != 2 || prefixORF[0] != permit || prefixORF[1] != deny {
+		t.Fatal("Prefix ORF entries", prefixORF, "are not ordered by sequence number")
+	}
+
+	tests := []struct {
+		prefix    string
+		length    uint8
+		permitted bool
+	}{
+		{"10.1.1.0", 24, true},
+		{"10.1.0.0", 16, false},
+		{"10.2.0.0", 16, false},
+		{"10.2.1.0", 24, false},
+	}
+	for _, test := range tests {
+		if packet.IsPrefixPermittedByORF(prefixORF, packet.NewIPPrefix(net.ParseIP(test.prefix), test.length)) != test.permitted {
+			t.Error("Prefix", test.prefix, "length", test.length, "expected permitted", test.permitted)
+		}
+	}
+
+	prefixORF = packet.UpdateAddressPrefixORF(prefixORF, []*packet.AddressPrefixORFEntry{permitAll})
+	if !packet.IsPrefixPermittedByORF(prefixORF, packet.NewIPPrefix(net.ParseIP("10.2.1.0"), 24)) ||
+		packet.IsPrefixPermittedByORF(prefixORF, packet.NewIPPrefix(net.ParseIP("10.2.0.0"), 16)) {
+		t.Error("Prefix ORF", prefixORF, "with permit all entry does not match the prefixes")
+	}
+	if packet.IsPrefixPermittedByORF(prefixORF, packet.NewIPPrefix(net.ParseIP("2001:db8::"), 32)) {
+		t.Error("IPv6 prefix permitted by IPv4 prefix ORF", prefixORF)
+	}
+
+	remove := *deny
+	remove.Action = packet.BGPORFActionRemove
+	updated := packet.UpdateAddressPrefixORF(prefixORF, []*packet.AddressPrefixORFEntry{&remove})
+	if len(updated) != 2 || !packet.IsPrefixPermittedByORF(updated, packet.NewIPPrefix(net.ParseIP("10.2.0.0"), 16)) {
+		t.Error("Prefix ORF entry", deny, "not removed from", updated)
+	}
+	if len(prefixORF) != 3 {
+		t.Error("Prefix ORF", prefixORF, "modified by the update")
+	}
+
+	removeAll := packet.NewAddressPrefixORFEntry(packet.BGPORFActionRemoveAll, packet.BGPORFMatchPermit, 0, nil,
+		0, 0, 0)
+	updated = packet.UpdateAddressPrefixORF(updated, []*packet.AddressPrefixORFEntry{removeAll})
+	if len(updated) != 0 || !packet.IsPrefixPermittedByORF(updated, packet.NewIPPrefix(net.ParseIP("10.2.0.0"), 16)) {
+		t.Error("Prefix ORF", updated, "not empty after remove all")
+	}
+}
+
+func TestParseRouteDistinguisher(t *testing.T) {
+	for str, rdType := range map[string]uint16{
+		"65000:1":      packet.RouteDistinguisherTypeTwoOctetAS,
+		"10.0.0.1:100": packet.RouteDistinguisherTypeIPv4Addr,
+		"4200000000:7": packet.RouteDistinguisherTypeFourOctetAS,
+	} {
+		rd, err := packet.ParseRouteDistinguisher(str)
+		if err != nil {
+			t.Error("Failed to parse route distinguisher", str, "err:", err)
+			continue
+		}
+		if rd.Type() != rdType || rd.String() != str {
+			t.Error("Route distinguisher", str, "parsed as type", rd.Type(), "string", rd.String())
+		}
+	}
+
+	if _, err := packet.ParseRouteDistinguisher("65000"); err == nil {
+		t.Error("Route distinguisher 65000 parsed without error")
+	}
+}
@@ -0,0 +1,128 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+
+// routePolicy_test.go
+package policytest
+
+import (
+	"fmt"
+	"l3/bgp/config"
+	"l3/bgp/packet"
+	bgppolicy "l3/bgp/policy"
+	"net"
+	"testing"
+)
+
+/*  The policy accepts 10.22.1.0/24, passes 10.22.2.0/24 to the next statement, rejects
+ *  10.22.3.0/24 and accepts the other routes with the default result.
+ */
+func newTestPolicyDB(t *testing.T) *bgppolicy.RoutePolicyDB {
+	db := bgppolicy.NewRoutePolicyDB()
+	for _, prefix := range []string{"10.22.1.0/24", "10.22.2.0/24", "10.22.3.0/24"} {
+		err := db.CreateCondition(config.RoutePolicyConditionConfig{
+			Name:          prefix,
+			ConditionType: config.RoutePolicyConditionTypePrefixSet,
+			Prefixes:      []config.RoutePolicyPrefix{{IpPrefix: prefix}},
+		})
+		if err != nil {
+			t.Fatal("Failed to create condition", prefix, "error:", err)
+		}
+	}
+	db.CreateStmt(config.RoutePolicyStmtConfig{Name: "accept", Conditions: []string{"10.22.1.0/24"},
+		Result: config.RoutePolicyResultAccept})
+	db.CreateStmt(config.RoutePolicyStmtConfig{Name: "next", Conditions: []string{"10.22.2.0/24"},
+		Result: config.RoutePolicyResultNext})
+	db.CreateStmt(config.RoutePolicyStmtConfig{Name: "reject", Conditions: []string{"10.22.3.0/24"},
+		Result: config.RoutePolicyResultReject})
+	db.CreatePolicy(config.RoutePolicyConfig{Name: "policy", Statements: []string{"accept", "next", "reject"},
+		DefaultResult: config.RoutePolicyResultAccept})
+	return db
+}
+
+func newTestPolicyParams(prefix string) *bgppolicy.RoutePolicyParams {
+	ip, ipNet, _ := net.ParseCIDR(prefix)
+	ones, _ := ipNet.Mask.Size()
+	return &bgppolicy.RoutePolicyParams{
+		PathAttrs: packet.ConstructPathAttrForConnRoutes(net.ParseIP("10.22.0.1"), 65000),
+		Prefix:    packet.NewIPPrefix(ip.Mask(ipNet.Mask), uint8(ones)),
+	}
+}
+
+// Only the routes accepted by a statement match the policy, not the routes accepted by default
+func TestMatchPolicy(t *testing.T) {
+	db := newTestPolicyDB(t)
+	tests := []struct {
+		policy   string
+		prefix   string
+		accepted bool
+		matched  bool
+	}{
+		{"policy", "10.22.1.0/24", true, true},
+		{"policy", "10.22.2.0/24", true, false},
+		{"policy", "10.22.3.0/24", false, false},
+		{"policy", "10.22.4.0/24", true, false},
+		{"missing", "10.22.1.0/24", true, false},
+	}
+
+	for _, test := range tests {
+		if accepted := db.ApplyPolicy(test.policy, newTestPolicyParams(test.prefix)); accepted != test.accepted {
+			t.Error("Policy", test.policy, "accepted", test.prefix, accepted, "expected", test.accepted)
+		}
+		if matched := db.MatchPolicy(test.policy, newTestPolicyParams(test.prefix)); matched != test.matched {
+			t.Error("Policy", test.policy, "matched", test.prefix, matched, "expected", test.matched)
+		}
+	}
+}
+
+// The policies that match on the neighbor or set the next hop to the neighbor address use the neighbor
+func TestUsesNeighbor(t *testing.T) {
+	db := newTestPolicyDB(t)
+	if err := db.CreateCondition(config.RoutePolicyConditionConfig{Name: "neighbors",
+		ConditionType: config.RoutePolicyConditionTypeNeighborSet, Neighbors: []string{"10.24.1.1"}}); err != nil {
+		t.Fatal("Failed to create the neighbor set condition, error:", err)
+	}
+	for _, option := range []config.NextHopActionOption{config.NextHopActionSelf, config.NextHopActionPeerAddress} {
+		if err := db.CreateAction(config.RoutePolicyActionConfig{Name: fmt.Sprint("next-hop-", option),
+			ActionType: config.RoutePolicyActionTypeSetNextHop, NextHopOption: option}); err != nil {
+			t.Fatal("Failed to create the next hop action, error:", err)
+		}
+	}
+	db.CreateStmt(config.RoutePolicyStmtConfig{Name: "neighbors", Conditions: []string{"neighbors"},
+		Result: config.RoutePolicyResultAccept})
+	db.CreateStmt(config.RoutePolicyStmtConfig{Name: "self", Conditions: []string{"10.22.1.0/24"},
+		Actions: []string{"next-hop-0"}, Result: config.RoutePolicyResultAccept})
+	db.CreateStmt(config.RoutePolicyStmtConfig{Name: "peer-address", Conditions: []string{"10.22.1.0/24"},
+		Actions: []string{"next-hop-1"}, Result: config.RoutePolicyResultAccept})
+
+	tests := map[string]bool{"neighbors": true, "self": false, "peer-address": true}
+	for stmtName, uses := range tests {
+		db.CreatePolicy(config.RoutePolicyConfig{Name: stmtName, Statements: []string{"accept", stmtName}})
+		if db.UsesNeighbor(stmtName) != uses {
+			t.Error("Policy with statement", stmtName, "uses the neighbor", !uses, "expected", uses)
+		}
+	}
+	if db.UsesNeighbor("policy") || db.UsesNeighbor("missing") {
+		t.Error("Policy without neighbor conditions or actions uses the neighbor")
+	}
+}
@@ -0,0 +1,131 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+
+// addPaths_test.go
+package ribtest
+
+import (
+	"fmt"
+	"l3/bgp/config"
+	bgprib "l3/bgp/rib"
+	"sort"
+	"testing"
+)
+
+// Returns the next hops of the paths sorted, the ECMP paths are not ordered
+func getTestNextHops(paths []*bgprib.Path) []string {
+	nextHops := make([]string, 0, len(paths))
+	for _, path := range paths {
+		nextHops = append(nextHops, path.GetNextHop().String())
+	}
+	sort.Strings(nextHops)
+	return nextHops
+}
+
+type testAddPathsRoute struct {
+	neighbor string
+	peerAS   uint32
+	nextHop  string
+	asPath   []uint32
+}
+
+/*  The routes of the prefix are received from the neighbors, the paths advertised with the
+ *  best path are selected for every add paths transmit mode.
+ */
+func TestSelectAddPaths(t *testing.T) {
+	prefix := "10.18.0.0/24"
+	tests := []struct {
+		name     string
+		routes   []testAddPathsRoute
+		best     string
+		addPaths map[string][]string
+	}{
+		{
+			name: "external best path",
+			routes: []testAddPathsRoute{
+				{"10.18.1.1", 65001, "10.18.1.1", nil},
+				{"10.18.1.2", 65001, "10.18.1.2", nil},
+				{"10.18.1.3", 65002, "10.18.1.3", []uint32{65008}},
+			},
+			best: "10.18.1.1",
+			addPaths: map[string][]string{
+				config.AddPathsTxModeAll:          {"10.18.1.2", "10.18.1.3"},
+				config.AddPathsTxModeBestN:        {"10.18.1.2", "10.18.1.3"},
+				config.AddPathsTxModeECMP:         {"10.18.1.2"},
+				config.AddPathsTxModeBestExternal: {},
+				config.AddPathsTxModeDiversePath:  {"10.18.1.2"},
+			},
+		},
+		{
+			name: "internal best path",
+			routes: []testAddPathsRoute{
+				{"10.18.2.1", 65000, "10.18.2.1", []uint32{65003}},
+				{"10.18.2.2", 65000, "10.18.2.2", []uint32{65003}},
+				{"10.18.2.3", 65004, "10.18.2.3", nil},
+			},
+			best: "10.18.2.1",
+			addPaths: map[string][]string{
+				config.AddPathsTxModeAll:          {"10.18.2.2", "10.18.2.3"},
+				config.AddPathsTxModeECMP:         {"10.18.2.2"},
+				config.AddPathsTxModeBestExternal: {"10.18.2.3"},
+				config.AddPathsTxModeDiversePath:  {"10.18.2.2"},
+			},
+		},
+		{
+			name: "internal paths with the next hop of the best path",
+			routes: []testAddPathsRoute{
+				{"10.18.3.1", 65000, "10.18.3.100", []uint32{65003}},
+				{"10.18.3.2", 65000, "10.18.3.100", []uint32{65003, 65007}},
+			},
+			best: "10.18.3.100",
+			addPaths: map[string][]string{
+				config.AddPathsTxModeAll:          {"10.18.3.100"},
+				config.AddPathsTxModeECMP:         {},
+				config.AddPathsTxModeBestExternal: {},
+				config.AddPathsTxModeDiversePath:  {},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		rib := newTestRib(t, &config.GlobalConfig{UseMultiplePaths: true, EBGPMaxPaths: 4, IBGPMaxPaths: 4})
+		rib.addPathCount = 4
+		for _, route := range test.routes {
+			rib.advertise(rib.newNeighbor(route.neighbor, route.peerAS), route.nextHop, 0, route.asPath, prefix)
+		}
+
+		dest := rib.getDest(prefix)
+		if dest == nil || dest.LocRibPath == nil {
+			t.Fatal(test.name, "- prefix", prefix, "has no best path")
+		}
+		if best := dest.LocRibPath.GetNextHop().String(); best != test.best {
+			t.Error(test.name, "- best path next hop", best, "expected", test.best)
+		}
+		for txMode, expected := range test.addPaths {
+			if nextHops := getTestNextHops(dest.SelectAddPaths(txMode)); fmt.Sprint(nextHops) != fmt.Sprint(expected) {
+				t.Error(test.name, "- add paths mode", txMode, "selected", nextHops, "expected", expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+
+// bestPath_test.go
+package ribtest
+
+import (
+	"l3/bgp/config"
+	bgprib "l3/bgp/rib"
+	"testing"
+)
+
+func getTestPathNextHop(path *bgprib.Path) string {
+	if path == nil {
+		return "none"
+	}
+	return path.GetNextHop().String()
+}
+
+/*  The MEDs are compared among the paths from the same neighbor AS. The path from 10.21.1.1 has
+ *  the lowest BGP identifier but a higher MED than the path of its neighbor AS from 10.21.1.3,
+ *  it must not be selected when the best path is from another neighbor AS.
+ */
+func TestBestPathMEDPerNeighborAS(t *testing.T) {
+	for _, gConf := range []*config.GlobalConfig{{}, {DeterministicMED: true}} {
+		rib := newTestRib(t, gConf)
+		rib.advertise(rib.newNeighbor("10.21.1.2", 65002), "10.21.1.2", 10, nil, "20.21.1.0/24")
+		rib.advertise(rib.newNeighbor("10.21.1.3", 65001), "10.21.1.3", 50, nil, "20.21.1.0/24")
+		rib.advertise(rib.newNeighbor("10.21.1.1", 65001), "10.21.1.1", 100, nil, "20.21.1.0/24")
+		if nextHop := getTestPathNextHop(rib.getLocRibPath("20.21.1.0/24")); nextHop != "10.21.1.2" {
+			t.Error("Deterministic MED", gConf.DeterministicMED, "- best path", nextHop,
+				"expected the path with the lowest BGP identifier after the MEDs are compared from 10.21.1.2")
+		}
+	}
+}
+
+// With always-compare-med the MEDs of the paths from all the neighbor ASes are compared
+func TestBestPathAlwaysCompareMED(t *testing.T) {
+	rib := newTestRib(t, &config.GlobalConfig{AlwaysCompareMED: true})
+	rib.advertise(rib.newNeighbor("10.21.2.1", 65001), "10.21.2.1", 100, nil, "20.21.2.0/24")
+	rib.advertise(rib.newNeighbor("10.21.2.2", 65001), "10.21.2.2", 50, nil, "20.21.2.0/24")
+	rib.advertise(rib.newNeighbor("10.21.2.3", 65002), "10.21.2.3", 10, nil, "20.21.2.0/24")
+	if nextHop := getTestPathNextHop(rib.getLocRibPath("20.21.2.0/24")); nextHop != "10.21.2.3" {
+		t.Error("Best path", nextHop, "expected the path with the lowest MED from 10.21.2.3")
+	}
+}
+
+// The path with a missing MED is the worst path of its neighbor AS with missing-as-worst
+func TestBestPathMEDMissingAsWorst(t *testing.T) {
+	for _, gConf := range []*config.GlobalConfig{{}, {MEDMissingAsWorst: true}} {
+		rib := newTestRib(t, gConf)
+		rib.advertise(rib.newNeighbor("10.21.3.1", 65001), "10.21.3.1", 0, nil, "20.21.30.0/24")
+		rib.advertise(rib.newNeighbor("10.21.3.2", 65001), "10.21.3.2", 50, nil, "20.21.30.0/24")
+		expected := "10.21.3.1"
+		if gConf.MEDMissingAsWorst {
+			expected = "10.21.3.2"
+		}
+		if nextHop := getTestPathNextHop(rib.getLocRibPath("20.21.30.0/24")); nextHop != expected {
+			t.Error("Missing as worst", gConf.MEDMissingAsWorst, "- best path", nextHop, "expected the path from",
+				expected)
+		}
+	}
+}
+
+// The path with the lowest IGP cost to its next hop is selected before the BGP identifier is compared
+func TestBestPathIGPCost(t *testing.T) {
+	rib := newTestRib(t, &config.GlobalConfig{})
+	rib.routeMgr.metrics["10.21.4.101"] = 20
+	rib.routeMgr.metrics["10.21.4.102"] = 10
+	rib.advertise(rib.newNeighbor("10.21.4.1", testLocalAS), "10.21.4.101", 0, []uint32{65003}, "20.21.40.0/24")
+	rib.advertise(rib.newNeighbor("10.21.4.2", testLocalAS), "10.21.4.102", 0, []uint32{65003}, "20.21.40.0/24")
+	if nextHop := getTestPathNextHop(rib.getLocRibPath("20.21.40.0/24")); nextHop != "10.21.4.102" {
+		t.Fatal("Best path", nextHop, "expected the path with the lowest IGP cost to 10.21.4.102")
+	}
+
+	// The BGP identifier decides between the paths with the same IGP cost
+	rib.routeMgr.metrics["10.21.4.103"] = 10
+	rib.advertise(rib.newNeighbor("10.21.4.3", testLocalAS), "10.21.4.103", 0, []uint32{65003}, "20.21.41.0/24")
+	rib.advertise(rib.newNeighbor("10.21.4.2", testLocalAS), "10.21.4.102", 0, []uint32{65003}, "20.21.41.0/24")
+	if nextHop := getTestPathNextHop(rib.getLocRibPath("20.21.41.0/24")); nextHop != "10.21.4.102" {
+		t.Error("Best path", nextHop, "expected the path with the lowest BGP identifier from 10.21.4.2")
+	}
+}
@@ -0,0 +1,238 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+
+// dampening_test.go
+package ribtest
+
+import (
+	"l3/bgp/config"
+	"testing"
+	"time"
+)
+
+func newDampeningTestRib(t *testing.T, halfLife, maxSuppressTime uint32) *testRib {
+	return newTestRib(t, &config.GlobalConfig{
+		Dampening:                true,
+		DampeningHalfLife:        halfLife,
+		DampeningReuse:           config.BGPDampeningReuseDefault,
+		DampeningSuppress:        config.BGPDampeningSuppressDefault,
+		DampeningMaxSuppressTime: maxSuppressTime,
+	})
+}
+
+func (r *testRib) getDampenedRoute(network, neighbor string) *config.DampenedRouteState {
+	_, _, routes := r.adjRib.BulkGetDampenedRoutes(0, 100)
+	for _, route := range routes {
+		if route.Network == network && route.Neighbor == neighbor {
+			return route
+		}
+	}
+	return nil
+}
+
+// The penalty decays a little between the updates of a test, it's checked to be within 1% of the expected penalty
+func checkPenalty(t *testing.T, route *config.DampenedRouteState, penalty float64, flaps uint32, suppressed,
+	history bool) {
+	if route == nil {
+		t.Fatal("No dampening info, expected penalty", penalty)
+	}
+	if float64(route.Penalty) < penalty*0.99 || float64(route.Penalty) > penalty {
+		t.Error("Dampening penalty", route.Penalty, "expected", penalty)
+	}
+	if route.Flaps != flaps || route.Suppressed != suppressed || route.History != history {
+		t.Error("Dampening info flaps", route.Flaps, "suppressed", route.Suppressed, "history", route.History,
+			"expected", flaps, suppressed, history)
+	}
+}
+
+func TestDampeningPenaltyAccumulation(t *testing.T) {
+	rib := newDampeningTestRib(t, config.BGPDampeningHalfLifeDefault, config.BGPDampeningMaxSuppressTimeDefault)
+	nConf := rib.newNeighbor("10.13.1.1", 65001)
+
+	rib.advertise(nConf, "10.13.1.1", 0, nil, "20.13.1.0/24")
+	if route := rib.getDampenedRoute("20.13.1.0", "10.13.1.1"); route != nil {
+		t.Fatal("First advertisement of the path was penalized:", route)
+	}
+
+	rib.withdraw(nConf, "20.13.1.0/24")
+	checkPenalty(t, rib.getDampenedRoute("20.13.1.0", "10.13.1.1"), 1000, 1, false, true)
+
+	// The path is not penalized when it is advertised again after the withdraw, only when its attrs change
+	rib.advertise(nConf, "10.13.1.1", 0, nil, "20.13.1.0/24")
+	checkPenalty(t, rib.getDampenedRoute("20.13.1.0", "10.13.1.1"), 1000, 1, false, false)
+	rib.advertise(nConf, "10.13.1.1", 0, nil, "20.13.1.0/24")
+	checkPenalty(t, rib.getDampenedRoute("20.13.1.0", "10.13.1.1"), 1000, 1, false, false)
+	rib.advertise(nConf, "10.13.1.1", 10, nil, "20.13.1.0/24")
+	checkPenalty(t, rib.getDampenedRoute("20.13.1.0", "10.13.1.1"), 1500, 2, false, false)
+
+	// Internal paths are not dampened
+	iConf := rib.newNeighbor("10.13.1.2", testLocalAS)
+	rib.advertise(iConf, "10.13.1.2", 0, nil, "20.13.1.0/24")
+	rib.withdraw(iConf, "20.13.1.0/24")
+	if route := rib.getDampenedRoute("20.13.1.0", "10.13.1.2"); route != nil {
+		t.Error("Path from an internal neighbor was penalized:", route)
+	}
+}
+
+func TestDampeningSuppressThreshold(t *testing.T) {
+	rib := newDampeningTestRib(t, config.BGPDampeningHalfLifeDefault, config.BGPDampeningMaxSuppressTimeDefault)
+	nConf := rib.newNeighbor("10.13.2.1", 65001)
+	backupConf := rib.newNeighbor("10.13.2.2", 65002)
+	rib.advertise(backupConf, "10.13.2.2", 0, []uint32{65100}, "20.13.2.0/24")
+
+	// Two withdraws stay below the suppress threshold of 2000
+	for i := 0; i < 2; i++ {
+		rib.advertise(nConf, "10.13.2.1", 0, nil, "20.13.2.0/24")
+		if path := rib.getLocRibPath("20.13.2.0/24"); path == nil || path.NeighborConf != nConf {
+			t.Fatal("Path from the flapping neighbor is not the best path after", i, "withdraws")
+		}
+		rib.withdraw(nConf, "20.13.2.0/24")
+	}
+	checkPenalty(t, rib.getDampenedRoute("20.13.2.0", "10.13.2.1"), 2000, 2, false, true)
+
+	// The attr change goes above the threshold and the path is removed from the best path selection
+	rib.advertise(nConf, "10.13.2.1", 0, nil, "20.13.2.0/24")
+	rib.advertise(nConf, "10.13.2.1", 10, nil, "20.13.2.0/24")
+	checkPenalty(t, rib.getDampenedRoute("20.13.2.0", "10.13.2.1"), 2500, 3, true, false)
+	if path := rib.getLocRibPath("20.13.2.0/24"); path == nil || path.NeighborConf != backupConf {
+		t.Fatal("Suppressed path was not replaced by the path from the other neighbor")
+	}
+
+	// The suppressed path stays suppressed when it is advertised again
+	rib.withdraw(nConf, "20.13.2.0/24")
+	rib.advertise(nConf, "10.13.2.1", 10, nil, "20.13.2.0/24")
+	rib.withdraw(backupConf, "20.13.2.0/24")
+	if path := rib.getLocRibPath("20.13.2.0/24"); path != nil {
+		t.Error("Suppressed path was selected as the best path")
+	}
+}
+
+func TestDampeningReuseTime(t *testing.T) {
+	rib := newDampeningTestRib(t, 1, config.BGPDampeningMaxSuppressTimeDefault)
+	nConf := rib.newNeighbor("10.13.3.1", 65001)
+
+	rib.advertise(nConf, "10.13.3.1", 0, nil, "20.13.3.0/24")
+	rib.withdraw(nConf, "20.13.3.0/24")
+	rib.advertise(nConf, "10.13.3.1", 0, nil, "20.13.3.0/24")
+	rib.withdraw(nConf, "20.13.3.0/24")
+	rib.advertise(nConf, "10.13.3.1", 0, nil, "20.13.3.0/24")
+	rib.advertise(nConf, "10.13.3.1", 10, nil, "20.13.3.0/24")
+	route := rib.getDampenedRoute("20.13.3.0", "10.13.3.1")
+	if route == nil || !route.Suppressed {
+		t.Fatal("Path is not suppressed after 3 flaps:", route)
+	}
+
+	// The penalty of about 2500 decays below the reuse threshold of 750 in log2(2500/750) half lives
+	if route.ReuseTime != "1s" {
+		t.Error("Reuse time", route.ReuseTime, "expected 1s")
+	}
+	if updated, _, _ := rib.adjRib.ReuseDampenedPaths(0); len(updated) != 0 ||
+		rib.getLocRibPath("20.13.3.0/24") != nil {
+		t.Fatal("Path was reused before the penalty decayed below the reuse threshold")
+	}
+
+	time.Sleep(1800 * time.Millisecond)
+	updated, _, _ := rib.adjRib.ReuseDampenedPaths(0)
+	if len(updated) != 1 {
+		t.Fatal("Reused path was not advertised, updated:", updated)
+	}
+	if path := rib.getLocRibPath("20.13.3.0/24"); path == nil || path.NeighborConf != nConf {
+		t.Error("Reused path is not the best path")
+	}
+	if route = rib.getDampenedRoute("20.13.3.0", "10.13.3.1"); route == nil || route.Suppressed ||
+		route.ReuseTime != "0s" {
+		t.Error("Dampening info of the reused path is not updated:", route)
+	}
+}
+
+func TestDampeningMaxSuppressTime(t *testing.T) {
+	rib := newDampeningTestRib(t, 1, 2)
+	nConf := rib.newNeighbor("10.13.4.1", 65001)
+
+	// The penalty is capped at reuse * 2^(max suppress time / half life)
+	for i := 0; i < 10; i++ {
+		rib.advertise(nConf, "10.13.4.1", 0, nil, "20.13.4.0/24")
+		rib.withdraw(nConf, "20.13.4.0/24")
+	}
+	route := rib.getDampenedRoute("20.13.4.0", "10.13.4.1")
+	if route == nil || !route.Suppressed || route.Flaps != 10 {
+		t.Fatal("Path is not suppressed after 10 flaps:", route)
+	}
+	if route.Penalty > 3000 {
+		t.Error("Penalty", route.Penalty, "is above the max penalty 3000")
+	}
+	if route.ReuseTime != "1s" && route.ReuseTime != "2s" {
+		t.Error("Reuse time", route.ReuseTime, "is not within the max suppress time of 2s")
+	}
+
+	// The history of the withdrawn path is removed when the penalty decays below half of the reuse threshold
+	time.Sleep(2500 * time.Millisecond)
+	rib.adjRib.ReuseDampenedPaths(0)
+	if route = rib.getDampenedRoute("20.13.4.0", "10.13.4.1"); route == nil || route.Suppressed {
+		t.Fatal("Path is still suppressed after the max suppress time:", route)
+	}
+	time.Sleep(1200 * time.Millisecond)
+	rib.adjRib.ReuseDampenedPaths(0)
+	if route = rib.getDampenedRoute("20.13.4.0", "10.13.4.1"); route != nil {
+		t.Error("Dampening info was not removed after the penalty decayed:", route)
+	}
+}
+
+// The suppressed paths are not accepted for the views of the route server clients until they are reused
+func TestDampeningAcceptedPaths(t *testing.T) {
+	rib := newDampeningTestRib(t, 1, config.BGPDampeningMaxSuppressTimeDefault)
+	nConf := rib.newNeighbor("10.13.5.1", 65001)
+	nlri := newTestNLRIList(t, []string{"20.13.5.0/24"})[0]
+
+	rib.advertise(nConf, "10.13.5.1", 0, nil, "20.13.5.0/24")
+	if !rib.adjRib.IsPathAccepted("10.13.5.1", nlri) {
+		t.Fatal("Path is not accepted before it flaps")
+	}
+	if rib.adjRib.IsPathAccepted("10.13.5.2", nlri) {
+		t.Error("Path from a neighbor that did not advertise the prefix is accepted")
+	}
+
+	rib.withdraw(nConf, "20.13.5.0/24")
+	rib.advertise(nConf, "10.13.5.1", 0, nil, "20.13.5.0/24")
+	rib.withdraw(nConf, "20.13.5.0/24")
+	rib.advertise(nConf, "10.13.5.1", 0, nil, "20.13.5.0/24")
+	rib.advertise(nConf, "10.13.5.1", 10, nil, "20.13.5.0/24")
+	if rib.adjRib.IsPathAccepted("10.13.5.1", nlri) {
+		t.Fatal("Suppressed path is accepted")
+	}
+	suppressed := rib.adjRib.GetSuppressedPaths()
+	if nlris := suppressed["10.13.5.1"]; len(suppressed) != 1 || len(nlris) != 1 ||
+		nlris[0].GetPrefix().Prefix.String() != "20.13.5.0" {
+		t.Fatal("Suppressed paths", suppressed, "expected 20.13.5.0/24 from 10.13.5.1")
+	}
+
+	time.Sleep(1800 * time.Millisecond)
+	rib.adjRib.ReuseDampenedPaths(0)
+	if !rib.adjRib.IsPathAccepted("10.13.5.1", nlri) {
+		t.Error("Reused path is not accepted")
+	}
+	if suppressed = rib.adjRib.GetSuppressedPaths(); len(suppressed) != 0 {
+		t.Error("Reused path is still in the suppressed paths", suppressed)
+	}
+}
@@ -0,0 +1,181 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+
+// helpers_test.go
+package ribtest
+
+import (
+	base "l3/bgp/baseobjects"
+	"l3/bgp/config"
+	"l3/bgp/packet"
+	bgprib "l3/bgp/rib"
+	"net"
+	"sync"
+	"testing"
+	"utils/logging"
+)
+
+/*  The tests process the updates of test neighbors in an Adj-RIB directly, the next hops of
+ *  the paths are always reachable with the IGP metric set for the next hop.
+ */
+
+const (
+	testLocalAS  uint32 = 65000
+	testRouterId string = "127.0.0.1"
+)
+
+type testRouteMgr struct {
+	mutex   sync.RWMutex
+	metrics map[string]int32
+}
+
+func (m *testRouteMgr) Start() {}
+
+func (m *testRouteMgr) GetNextHopInfo(ipAddr string) (*config.NextHopInfo, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return &config.NextHopInfo{IPAddr: ipAddr, NextHopIp: ipAddr, Metric: m.metrics[ipAddr], IsReachable: true}, nil
+}
+
+func (m *testRouteMgr) CreateRoute(cfg *config.RouteConfig)            {}
+func (m *testRouteMgr) DeleteRoute(cfg *config.RouteConfig)            {}
+func (m *testRouteMgr) UpdateRoute(cfg *config.RouteConfig, op string) {}
+func (m *testRouteMgr) ApplyPolicy(protocol string, policy string, action string,
+	conditions []*config.ConditionInfo) {
+}
+func (m *testRouteMgr) GetRoutes() ([]*config.RouteInfo, []*config.RouteInfo) { return nil, nil }
+func (m *testRouteMgr) GetInstalledRoutes() []*config.RouteConfig             { return nil }
+
+type testRib struct {
+	t            *testing.T
+	logger       *logging.Writer
+	gConf        *config.GlobalConfig
+	routeMgr     *testRouteMgr
+	adjRib       *bgprib.AdjRib
+	addPathCount int
+}
+
+func newTestRib(t *testing.T, gConf *config.GlobalConfig) *testRib {
+	logger, err := logging.NewLogger("bgpd", "BGP", true)
+	if err != nil {
+		t.Fatal("Failed to start the logger. Exiting!!")
+	}
+
+	gConf.AS = testLocalAS
+	gConf.RouterId = net.ParseIP(testRouterId)
+	routeMgr := &testRouteMgr{metrics: make(map[string]int32)}
+	return &testRib{
+		t:        t,
+		logger:   logger,
+		gConf:    gConf,
+		routeMgr: routeMgr,
+		adjRib:   bgprib.NewAdjRib(logger, routeMgr, gConf),
+	}
+}
+
+func (r *testRib) newNeighbor(addr string, peerAS uint32) *base.NeighborConf {
+	pConf := config.NeighborConfig{
+		NeighborAddress: net.ParseIP(addr),
+		PeerAS:          peerAS,
+		LocalAS:         testLocalAS,
+	}
+	pConf.AfiSafis = []config.AfiSafiConfig{{AfiSafiName: "ipv4-unicast", AfiSafiEnabled: true}}
+	nConf := base.NewNeighborConf(r.logger, r.gConf, nil, pConf)
+	nConf.BGPId = net.ParseIP(addr)
+	return nConf
+}
+
+func newTestNLRIList(t *testing.T, prefixes []string) []packet.NLRI {
+	nlriList := make([]packet.NLRI, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		ip, ipNet, err := net.ParseCIDR(prefix)
+		if err != nil {
+			t.Fatal("Failed to parse prefix", prefix, "error:", err)
+		}
+		ones, _ := ipNet.Mask.Size()
+		nlriList = append(nlriList, packet.NewIPPrefix(ip.Mask(ipNet.Mask), uint8(ones)))
+	}
+	return nlriList
+}
+
+// Processes an update from the neighbor, the AS of an external neighbor is prepended to the AS path
+func (r *testRib) advertise(nConf *base.NeighborConf, nextHop string, med uint32, asPath []uint32,
+	prefixes ...string) (map[*bgprib.Path][]*bgprib.Destination, []*bgprib.Destination) {
+	return r.advertiseNLRI(nConf, nextHop, med, asPath, newTestNLRIList(r.t, prefixes))
+}
+
+func (r *testRib) advertiseNLRI(nConf *base.NeighborConf, nextHop string, med uint32, asPath []uint32,
+	nlriList []packet.NLRI) (map[*bgprib.Path][]*bgprib.Destination, []*bgprib.Destination) {
+	pathAttrs := packet.ConstructPathAttrForConnRoutes(net.ParseIP(nextHop).To4(), 0)
+	if med != 0 {
+		medAttr := packet.NewBGPPathAttrMultiExitDisc()
+		medAttr.Value = med
+		pathAttrs = append(pathAttrs, medAttr)
+	}
+
+	msg := packet.NewBGPUpdateMessage(nil, pathAttrs, nlriList)
+	for idx := len(asPath) - 1; idx >= 0; idx-- {
+		packet.PrependAS(msg, asPath[idx], 4)
+	}
+	if nConf.IsExternal() {
+		packet.PrependAS(msg, nConf.RunningConf.PeerAS, 4)
+	}
+	return r.processUpdate(nConf, msg)
+}
+
+func (r *testRib) withdraw(nConf *base.NeighborConf, prefixes ...string) (map[*bgprib.Path][]*bgprib.Destination,
+	[]*bgprib.Destination) {
+	return r.withdrawNLRI(nConf, newTestNLRIList(r.t, prefixes))
+}
+
+func (r *testRib) withdrawNLRI(nConf *base.NeighborConf, nlriList []packet.NLRI) (
+	map[*bgprib.Path][]*bgprib.Destination, []*bgprib.Destination) {
+	return r.processUpdate(nConf, packet.NewBGPUpdateMessage(nlriList, nil, nil))
+}
+
+func (r *testRib) processUpdate(nConf *base.NeighborConf, msg *packet.BGPMessage) (
+	map[*bgprib.Path][]*bgprib.Destination, []*bgprib.Destination) {
+	pktInfo := packet.NewBGPPktSrc(nConf.RunningConf.NeighborAddress.String(), msg)
+	updated, withdrawn, _, _, _ := r.adjRib.ProcessUpdate(nConf, pktInfo, r.addPathCount)
+	return updated, withdrawn
+}
+
+// Returns the destination of the prefix, nil if the prefix is not in the rib
+func (r *testRib) getDest(prefix string) *bgprib.Destination {
+	ip, ipNet, err := net.ParseCIDR(prefix)
+	if err != nil {
+		r.t.Fatal("Failed to parse prefix", prefix, "error:", err)
+	}
+	ones, _ := ipNet.Mask.Size()
+	return r.adjRib.GetDestFromIPAndLen(ip.Mask(ipNet.Mask).String(), uint32(ones))
+}
+
+// Returns the Loc-RIB path of the prefix, nil if the prefix has no Loc-RIB path
+func (r *testRib) getLocRibPath(prefix string) *bgprib.Path {
+	dest := r.getDest(prefix)
+	if dest == nil {
+		return nil
+	}
+	return dest.LocRibPath
+}
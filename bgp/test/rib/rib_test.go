@@ -0,0 +1,153 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+
+// rib_test.go
+package ribtest
+
+import (
+	base "l3/bgp/baseobjects"
+	"l3/bgp/config"
+	"l3/bgp/packet"
+	"net"
+	"testing"
+)
+
+func TestLabeledUnicastKeptApartFromUnicast(t *testing.T) {
+	rib := newTestRib(t, &config.GlobalConfig{})
+	nConf := rib.newNeighbor("10.16.1.1", 65001)
+	ipPrefix := newTestNLRIList(t, []string{"20.16.1.0/24"})[0].(*packet.IPPrefix)
+	labeled := []packet.NLRI{packet.NewLabeledPrefix(*ipPrefix, []uint32{16})}
+
+	rib.advertise(nConf, "10.16.1.1", 0, nil, "20.16.1.0/24")
+	rib.advertiseNLRI(nConf, "10.16.1.1", 0, nil, labeled)
+	labeledDest, ok := rib.adjRib.GetDest(labeled[0], false)
+	if !ok || labeledDest.LocRibPath == nil || labeledDest.GetProtocolFamily() !=
+		packet.GetProtocolFamily(packet.AfiIP, packet.SafiLabeledUnicast) {
+		t.Fatal("Labeled unicast route is not in its own destination")
+	}
+	if labels := labeledDest.LocRibPath.GetLabels(); len(labels) != 1 || labels[0] != 16 {
+		t.Error("Labeled unicast path labels", labels, "expected [16]")
+	}
+	if path := rib.getLocRibPath("20.16.1.0/24"); path == nil || len(path.GetLabels()) != 0 {
+		t.Fatal("Unicast route was replaced by the labeled unicast route")
+	}
+
+	rib.withdrawNLRI(nConf, labeled)
+	if _, ok = rib.adjRib.GetDest(labeled[0], false); ok {
+		t.Error("Labeled unicast destination was not removed after the withdraw")
+	}
+	if path := rib.getLocRibPath("20.16.1.0/24"); path == nil {
+		t.Error("Unicast route was removed by the labeled unicast withdraw")
+	}
+}
+
+/*  The VPN routes of a prefix with different route distinguishers, received from the same
+ *  neighbor, are the paths of the destination of the prefix in a VRF. The local routes of the
+ *  VRF are in the same destination and the best path is selected among all of them.
+ */
+func TestVrfRibBestPathAcrossRouteDistinguishers(t *testing.T) {
+	rib := newTestRib(t, &config.GlobalConfig{})
+	rd, _ := packet.ParseRouteDistinguisher("65000:1")
+	otherRD, _ := packet.ParseRouteDistinguisher("65000:2")
+	rib.adjRib.SetVrf("vrf1", rd)
+	nConf := rib.newNeighbor("10.16.2.1", testLocalAS)
+	ipPrefix := newTestNLRIList(t, []string{"20.16.2.0/24"})[0].(*packet.IPPrefix)
+	route := []packet.NLRI{packet.NewVPNPrefix(rd, *ipPrefix, []uint32{100})}
+	otherRoute := []packet.NLRI{packet.NewVPNPrefix(otherRD, *ipPrefix, []uint32{200})}
+
+	rib.advertiseNLRI(nConf, "10.16.2.11", 10, nil, route)
+	rib.advertiseNLRI(nConf, "10.16.2.12", 20, nil, otherRoute)
+	dest, ok := rib.adjRib.GetDest(route[0], false)
+	if otherDest, _ := rib.adjRib.GetDest(otherRoute[0], false); !ok || otherDest != dest {
+		t.Fatal("VPN routes with different route distinguishers are not in the destination of the prefix")
+	}
+	if localDest, _ := rib.adjRib.GetDest(ipPrefix, false); localDest != dest {
+		t.Error("Local route of the VRF is not kept in the destination of the prefix")
+	}
+	if dest.LocRibPath == nil || dest.LocRibPath.GetNextHop().String() != "10.16.2.11" {
+		t.Fatal("VPN route with the lowest MED is not the best path of the prefix")
+	}
+	if dest.GetProtocolFamily() != packet.GetProtocolFamily(packet.AfiIP, packet.SafiMPLSVPN) {
+		t.Error("VRF destination family", dest.GetProtocolFamily(), "is not the VPN family")
+	}
+
+	rib.withdrawNLRI(nConf, route)
+	if dest.LocRibPath == nil || dest.LocRibPath.GetNextHop().String() != "10.16.2.12" {
+		t.Error("VPN route with the other route distinguisher is not the best path after the withdraw")
+	}
+
+	rib.withdrawNLRI(nConf, otherRoute)
+	if _, ok = rib.adjRib.GetDest(ipPrefix, false); ok {
+		t.Error("Destination of the prefix was not removed after the withdraws")
+	}
+}
+
+// Processes our own route reflected back by the internal neighbor with the ACCEPT_OWN community
+func (r *testRib) advertiseAcceptOwn(nConf *base.NeighborConf, nextHop string, nlriList []packet.NLRI) {
+	pathAttrs := packet.ConstructPathAttrForConnRoutes(net.ParseIP(nextHop).To4(), 0)
+	pathAttrs = append(pathAttrs, packet.NewBGPPathAttrOriginatorId(net.ParseIP(testRouterId).To4()))
+	pathAttrs = packet.AddCommunities(pathAttrs, []uint32{packet.BGPCommunityAcceptOwn})
+	r.processUpdate(nConf, packet.NewBGPUpdateMessage(nil, pathAttrs, nlriList))
+}
+
+/*  Our own routes with the ACCEPT_OWN community are only accepted as VPN routes imported into
+ *  a VRF other than the source VRF of the route, the VRF with the route distinguisher of the route.
+ */
+func TestAcceptOwn(t *testing.T) {
+	rib := newTestRib(t, &config.GlobalConfig{})
+	nConf := rib.newNeighbor("10.20.1.1", testLocalAS)
+	nConf.RunningConf.AcceptOwn = true
+	rib.advertiseAcceptOwn(nConf, "10.20.1.100", newTestNLRIList(t, []string{"20.20.1.0/24"}))
+	if path := rib.getLocRibPath("20.20.1.0/24"); path != nil {
+		t.Error("Own route with the ACCEPT_OWN community was accepted into the global table")
+	}
+
+	vrfRib := newTestRib(t, &config.GlobalConfig{})
+	rd, _ := packet.ParseRouteDistinguisher("65000:1")
+	sourceRD, _ := packet.ParseRouteDistinguisher("65000:2")
+	vrfRib.adjRib.SetVrf("vrf1", rd)
+	nConf = vrfRib.newNeighbor("10.20.1.1", testLocalAS)
+	nConf.RunningConf.AcceptOwn = true
+	ipPrefix := newTestNLRIList(t, []string{"20.20.2.0/24"})[0].(*packet.IPPrefix)
+	route := packet.NewVPNPrefix(sourceRD, *ipPrefix, []uint32{100})
+	vrfRib.advertiseAcceptOwn(nConf, "10.20.1.100", []packet.NLRI{route})
+	if dest, ok := vrfRib.adjRib.GetDest(route, false); !ok || dest.LocRibPath == nil {
+		t.Error("Own route of another VRF with the ACCEPT_OWN community was not imported into the VRF")
+	}
+
+	sourceRoute := packet.NewVPNPrefix(rd, *newTestNLRIList(t, []string{"20.20.4.0/24"})[0].(*packet.IPPrefix),
+		[]uint32{100})
+	vrfRib.advertiseAcceptOwn(nConf, "10.20.1.100", []packet.NLRI{sourceRoute})
+	if dest, ok := vrfRib.adjRib.GetDest(sourceRoute, false); ok && dest.LocRibPath != nil {
+		t.Error("Own route with the ACCEPT_OWN community was imported into its source VRF")
+	}
+
+	nConf.RunningConf.AcceptOwn = false
+	otherRoute := packet.NewVPNPrefix(sourceRD, *newTestNLRIList(t, []string{"20.20.3.0/24"})[0].(*packet.IPPrefix),
+		[]uint32{100})
+	vrfRib.advertiseAcceptOwn(nConf, "10.20.1.100", []packet.NLRI{otherRoute})
+	if dest, ok := vrfRib.adjRib.GetDest(otherRoute, false); ok && dest.LocRibPath != nil {
+		t.Error("Own route was imported into the VRF without accept-own on the neighbor")
+	}
+}
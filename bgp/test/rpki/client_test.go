@@ -0,0 +1,180 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// client_test.go
+package rpkitest
+
+import (
+	"l3/bgp/rpki"
+	"net"
+	"testing"
+	"time"
+	"utils/logging"
+)
+
+// Stub RPKI cache that serves one client connection at a time
+type stubCache struct {
+	t        *testing.T
+	listener net.Listener
+	conn     net.Conn
+}
+
+func newStubCache(t *testing.T) *stubCache {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to start the stub RPKI cache, err:", err)
+	}
+	return &stubCache{t: t, listener: listener}
+}
+
+func (c *stubCache) accept() {
+	c.listener.(*net.TCPListener).SetDeadline(time.Now().Add(5 * time.Second))
+	conn, err := c.listener.Accept()
+	if err != nil {
+		c.t.Fatal("Stub RPKI cache failed to accept the connection, err:", err)
+	}
+	c.conn = conn
+}
+
+func (c *stubCache) expectPDU(pduType uint8, version uint8) *rpki.RTRPDU {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	pdu, err := rpki.ReadRTRPDU(c.conn)
+	if err != nil {
+		c.t.Fatal("Stub RPKI cache failed to read PDU, err:", err)
+	}
+	if pdu.Header.Type != pduType || pdu.Header.Version != version {
+		c.t.Fatal("Stub RPKI cache expected PDU type", pduType, "version", version, "got", pdu.Header)
+	}
+	return pdu
+}
+
+func (c *stubCache) send(pdus ...*rpki.RTRPDU) {
+	for _, pdu := range pdus {
+		pkt, err := pdu.Encode()
+		if err != nil {
+			c.t.Fatal("Stub RPKI cache failed to encode PDU, err:", err)
+		}
+		if _, err = c.conn.Write(pkt); err != nil {
+			c.t.Fatal("Stub RPKI cache failed to send PDU, err:", err)
+		}
+	}
+}
+
+func (c *stubCache) close() {
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.listener.Close()
+}
+
+func waitForSnapshot(t *testing.T, updateCh chan rpki.CacheSnapshot) rpki.CacheSnapshot {
+	select {
+	case snapshot := <-updateCh:
+		return snapshot
+	case <-time.After(5 * time.Second):
+		t.Fatal("RPKI cache client didn't send a snapshot")
+	}
+	return rpki.CacheSnapshot{}
+}
+
+func startCacheClient(t *testing.T, cache *stubCache) (*rpki.CacheClient, chan rpki.CacheSnapshot) {
+	logger, err := logging.NewLogger("bgpd", "BGP", true)
+	if err != nil {
+		t.Fatal("Failed to start the logger. Exiting!!")
+	}
+
+	updateCh := make(chan rpki.CacheSnapshot)
+	client := rpki.NewCacheClient(logger, cache.listener.Addr().String(), 0, 1, 0, updateCh)
+	go client.Run()
+	return client, updateCh
+}
+
+func TestCacheClientResetAndSerialQuery(t *testing.T) {
+	cache := newStubCache(t)
+	defer cache.close()
+	client, updateCh := startCacheClient(t, cache)
+	defer client.Stop()
+
+	v := rpki.RTRVersion1
+	cache.accept()
+	cache.expectPDU(rpki.RTRPDUTypeResetQuery, v)
+	cache.send(rpki.NewRTRCacheResponsePDU(v, 10),
+		rpki.NewRTRPrefixPDU(v, true, net.ParseIP("10.1.0.0"), 16, 24, 65001),
+		rpki.NewRTRPrefixPDU(v, true, net.ParseIP("2001:db8::"), 32, 48, 65002),
+		rpki.NewRTREndOfDataPDU(v, 10, 1, 3600, 600, 7200))
+
+	snapshot := waitForSnapshot(t, updateCh)
+	if snapshot.Client != client || len(snapshot.VRPs) != 2 {
+		t.Fatal("RPKI cache client snapshot expected 2 VRPs, got", snapshot.VRPs)
+	}
+
+	cache.send(rpki.NewRTRSerialNotifyPDU(v, 10, 2))
+	query := cache.expectPDU(rpki.RTRPDUTypeSerialQuery, v)
+	if serial := query.Body.(*rpki.RTRSerial).Serial; query.Header.SessionId != 10 || serial != 1 {
+		t.Fatal("RPKI cache client Serial Query expected session 10 serial 1, got", query.Header.SessionId, serial)
+	}
+	cache.send(rpki.NewRTRCacheResponsePDU(v, 10),
+		rpki.NewRTRPrefixPDU(v, false, net.ParseIP("10.1.0.0"), 16, 24, 65001),
+		rpki.NewRTRPrefixPDU(v, true, net.ParseIP("10.2.0.0"), 16, 16, 65003),
+		rpki.NewRTREndOfDataPDU(v, 10, 2, 3600, 600, 7200))
+
+	snapshot = waitForSnapshot(t, updateCh)
+	expected := map[rpki.VRP]bool{
+		rpki.NewVRP(net.ParseIP("2001:db8::"), 32, 48, 65002): true,
+		rpki.NewVRP(net.ParseIP("10.2.0.0"), 16, 16, 65003):   true,
+	}
+	if len(snapshot.VRPs) != len(expected) {
+		t.Fatal("RPKI cache client snapshot expected", expected, "got", snapshot.VRPs)
+	}
+	for _, vrp := range snapshot.VRPs {
+		if !expected[vrp] {
+			t.Error("RPKI cache client snapshot has unexpected VRP", vrp)
+		}
+	}
+}
+
+func TestCacheClientVersionDowngrade(t *testing.T) {
+	cache := newStubCache(t)
+	defer cache.close()
+	client, updateCh := startCacheClient(t, cache)
+	defer client.Stop()
+
+	cache.accept()
+	query := cache.expectPDU(rpki.RTRPDUTypeResetQuery, rpki.RTRVersion1)
+	pkt, _ := query.Encode()
+	cache.send(rpki.NewRTRErrorReportPDU(rpki.RTRVersion0, rpki.RTRErrUnsupportedVersion, pkt,
+		"version 1 is not supported"))
+	cache.conn.Close()
+
+	v := rpki.RTRVersion0
+	cache.accept()
+	cache.expectPDU(rpki.RTRPDUTypeResetQuery, v)
+	cache.send(rpki.NewRTRCacheResponsePDU(v, 3),
+		rpki.NewRTRPrefixPDU(v, true, net.ParseIP("10.1.0.0"), 16, 24, 65001),
+		rpki.NewRTREndOfDataPDU(v, 3, 1, 0, 0, 0))
+
+	snapshot := waitForSnapshot(t, updateCh)
+	if len(snapshot.VRPs) != 1 || snapshot.VRPs[0] != rpki.NewVRP(net.ParseIP("10.1.0.0"), 16, 24, 65001) {
+		t.Fatal("RPKI cache client snapshot expected 1 VRP, got", snapshot.VRPs)
+	}
+}
@@ -0,0 +1,167 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// rtr_test.go
+package rpkitest
+
+import (
+	"bytes"
+	"l3/bgp/rpki"
+	"net"
+	"testing"
+)
+
+func encodeAndDecodePDU(t *testing.T, pdu *rpki.RTRPDU) *rpki.RTRPDU {
+	pkt, err := pdu.Encode()
+	if err != nil {
+		t.Fatal("RTR PDU type", pdu.Header.Type, "encode failed with error", err)
+	}
+
+	decoded, err := rpki.ReadRTRPDU(bytes.NewReader(pkt))
+	if err != nil {
+		t.Fatal("RTR PDU type", pdu.Header.Type, "decode failed with error", err)
+	}
+	return decoded
+}
+
+func TestRTRPrefixPDU(t *testing.T) {
+	pdu := rpki.NewRTRPrefixPDU(rpki.RTRVersion1, true, net.ParseIP("10.1.0.0"), 16, 24, 65001)
+	pkt, err := pdu.Encode()
+	if err != nil {
+		t.Fatal("RTR IPv4 Prefix PDU encode failed with error", err)
+	}
+
+	expected := []byte{0x01, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x14, 0x01, 0x10, 0x18, 0x00, 0x0A, 0x01, 0x00,
+		0x00, 0x00, 0x00, 0xFD, 0xE9}
+	if !bytes.Equal(pkt, expected) {
+		t.Fatal("RTR IPv4 Prefix PDU expected", expected, "got", pkt)
+	}
+
+	decoded := encodeAndDecodePDU(t, rpki.NewRTRPrefixPDU(rpki.RTRVersion1, false, net.ParseIP("2001:db8::"), 32,
+		48, 65002))
+	prefix, ok := decoded.Body.(*rpki.RTRPrefix)
+	if !ok || decoded.Header.Type != rpki.RTRPDUTypeIPv6Prefix || decoded.Header.Length != rpki.RTRIPv6PrefixPDULen {
+		t.Fatal("RTR IPv6 Prefix PDU decoded as", decoded.Header, decoded.Body)
+	}
+	if prefix.IsAnnouncement() || !prefix.Prefix.Equal(net.ParseIP("2001:db8::")) || prefix.PrefixLen != 32 ||
+		prefix.MaxLen != 48 || prefix.AS != 65002 {
+		t.Error("RTR IPv6 Prefix PDU decoded as", prefix)
+	}
+}
+
+func TestRTREndOfDataPDU(t *testing.T) {
+	decoded := encodeAndDecodePDU(t, rpki.NewRTREndOfDataPDU(rpki.RTRVersion1, 7, 42, 300, 60, 900))
+	endOfData, ok := decoded.Body.(*rpki.RTREndOfData)
+	if !ok || decoded.Header.SessionId != 7 || decoded.Header.Length != rpki.RTREndOfDataV1Len {
+		t.Fatal("RTR End of Data PDU decoded as", decoded.Header, decoded.Body)
+	}
+	if endOfData.Serial != 42 || endOfData.RefreshInterval != 300 || endOfData.RetryInterval != 60 ||
+		endOfData.ExpireInterval != 900 {
+		t.Error("RTR End of Data PDU decoded as", endOfData)
+	}
+
+	decoded = encodeAndDecodePDU(t, rpki.NewRTREndOfDataPDU(rpki.RTRVersion0, 7, 43, 300, 60, 900))
+	endOfData, ok = decoded.Body.(*rpki.RTREndOfData)
+	if !ok || decoded.Header.Length != rpki.RTREndOfDataV0Len {
+		t.Fatal("RTR version 0 End of Data PDU decoded as", decoded.Header, decoded.Body)
+	}
+	if endOfData.Serial != 43 || endOfData.RefreshInterval != rpki.RTRRefreshIntervalDefault {
+		t.Error("RTR version 0 End of Data PDU decoded as", endOfData)
+	}
+}
+
+func TestRTRErrorReportPDU(t *testing.T) {
+	query, _ := rpki.NewRTRResetQueryPDU(rpki.RTRVersion1).Encode()
+	decoded := encodeAndDecodePDU(t, rpki.NewRTRErrorReportPDU(rpki.RTRVersion0, rpki.RTRErrUnsupportedVersion,
+		query, "version 1 is not supported"))
+	errReport, ok := decoded.Body.(*rpki.RTRErrorReport)
+	if !ok || decoded.Header.SessionId != rpki.RTRErrUnsupportedVersion {
+		t.Fatal("RTR Error Report PDU decoded as", decoded.Header, decoded.Body)
+	}
+	if !bytes.Equal(errReport.PDU, query) || errReport.Text != "version 1 is not supported" {
+		t.Error("RTR Error Report PDU decoded as", errReport)
+	}
+
+	_, err := rpki.ReadRTRPDU(bytes.NewReader([]byte{0x01, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10, 0x01, 0x10,
+		0x18, 0x00, 0x0A, 0x01, 0x00, 0x00}))
+	if err == nil {
+		t.Error("RTR IPv4 Prefix PDU with a wrong length decoded without an error")
+	}
+
+	// The encapsulated PDU and text lengths that wrap around in uint32 are rejected
+	for _, body := range [][]byte{
+		{0xFF, 0xFF, 0xFF, 0xFC, 0x00, 0x00, 0x00, 0x00},
+		{0x00, 0x00, 0x00, 0x00, 0xFF, 0xFF, 0xFF, 0xF8},
+	} {
+		pkt := append([]byte{0x01, 0x0A, 0x00, 0x02, 0x00, 0x00, 0x00, 0x10}, body...)
+		if _, err = rpki.ReadRTRPDU(bytes.NewReader(pkt)); err == nil {
+			t.Error("RTR Error Report PDU", pkt, "with wrong lengths decoded without an error")
+		}
+	}
+}
+
+func TestVRPTableValidate(t *testing.T) {
+	table := rpki.NewVRPTable()
+	changed := table.SetCacheVRPs("cache1", []rpki.VRP{
+		rpki.NewVRP(net.ParseIP("10.0.0.0"), 8, 16, 65001),
+		rpki.NewVRP(net.ParseIP("10.1.0.0"), 16, 24, 65002),
+		rpki.NewVRP(net.ParseIP("192.168.0.0"), 16, 16, 0),
+	})
+	if len(changed) != 3 {
+		t.Fatal("VRP table expected 3 changed VRPs, got", changed)
+	}
+
+	tests := []struct {
+		prefix    string
+		prefixLen uint8
+		originAS  uint32
+		hasOrigin bool
+		state     rpki.ValidationState
+	}{
+		{"10.2.0.0", 16, 65001, true, rpki.ValidationStateValid},
+		{"10.2.1.0", 24, 65001, true, rpki.ValidationStateInvalid},
+		{"10.1.1.0", 24, 65002, true, rpki.ValidationStateValid},
+		{"10.1.1.0", 24, 65003, true, rpki.ValidationStateInvalid},
+		{"10.1.0.0", 16, 65001, true, rpki.ValidationStateValid},
+		{"10.1.0.0", 16, 65001, false, rpki.ValidationStateInvalid},
+		{"192.168.0.0", 16, 0, true, rpki.ValidationStateInvalid},
+		{"172.16.0.0", 16, 65001, true, rpki.ValidationStateNotFound},
+		{"0.0.0.0", 0, 65001, true, rpki.ValidationStateNotFound},
+	}
+	for _, test := range tests {
+		state := table.Validate(net.ParseIP(test.prefix), test.prefixLen, test.originAS, test.hasOrigin)
+		if state != test.state {
+			t.Error("Prefix", test.prefix, "length", test.prefixLen, "origin AS", test.originAS,
+				"validation state expected", test.state, "got", state)
+		}
+	}
+
+	table.SetCacheVRPs("cache2", []rpki.VRP{rpki.NewVRP(net.ParseIP("10.0.0.0"), 8, 16, 65001)})
+	changed = table.RemoveCache("cache1")
+	if len(changed) != 2 || table.Len() != 1 {
+		t.Fatal("VRP table expected 2 changed VRPs and 1 VRP left, got", changed, table.Len())
+	}
+	if state := table.Validate(net.ParseIP("10.2.0.0"), 16, 65001, true); state != rpki.ValidationStateValid {
+		t.Error("VRP announced by the second cache was removed with the first cache, state", state)
+	}
+}
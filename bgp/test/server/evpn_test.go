@@ -0,0 +1,216 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+
+// evpn_test.go
+package servertest
+
+import (
+	"fmt"
+	"l3/bgp/api"
+	"l3/bgp/config"
+	"l3/bgp/packet"
+	"net"
+	"sort"
+	"testing"
+	"time"
+)
+
+var testEVPNFamilies = []uint32{
+	packet.GetProtocolFamily(packet.AfiIP, packet.SafiUnicast),
+	packet.GetProtocolFamily(packet.AfiL2VPN, packet.SafiEVPN),
+}
+
+func newTestEVPNNeighborConfig(address string, peerAS uint32) config.NeighborConfig {
+	nConf := newTestNeighborConfig(address, peerAS)
+	nConf.AfiSafis = []config.AfiSafiConfig{
+		{AfiSafiName: "ipv4-unicast", AfiSafiEnabled: true},
+		{AfiSafiName: "l2vpn-evpn", AfiSafiEnabled: true},
+	}
+	return nConf
+}
+
+func newTestMac(t *testing.T, mac string) net.HardwareAddr {
+	hwAddr, err := net.ParseMAC(mac)
+	if err != nil {
+		t.Fatal("Failed to parse MAC", mac, "error:", err)
+	}
+	return hwAddr
+}
+
+// Advertises the EVPN route of a remote VTEP with the route target
+func (s *testSpeaker) advertiseEVPN(vtepIP string, routeTarget string, nlri *packet.EVPNNLRI) {
+	rt, err := packet.ParseRouteTarget(routeTarget)
+	if err != nil {
+		s.t.Fatal("Failed to parse route target", routeTarget, "error:", err)
+	}
+
+	pathAttrs := packet.ConstructPathAttrForConnRoutes(net.ParseIP(vtepIP).To4(), 0)
+	pathAttrs = packet.ConstructPathAttrsForFamily(pathAttrs, packet.AfiL2VPN, packet.SafiEVPN)
+	pathAttrs = packet.AddExtCommunities(pathAttrs, []uint64{rt})
+	if nlri.RouteType == packet.EVPNRouteTypeInclusiveMulticast {
+		pathAttrs = packet.AddPMSITunnel(pathAttrs, packet.PMSITunnelTypeIngressReplication, nlri.GetVNI(),
+			net.ParseIP(vtepIP).To4())
+	}
+	s.sendMP(packet.NewBGPUpdateMessage(make([]packet.NLRI, 0), pathAttrs, []packet.NLRI{nlri}))
+}
+
+func (s *testSpeaker) withdrawEVPN(nlri *packet.EVPNNLRI) {
+	pathAttrs := []packet.BGPPathAttr{packet.NewBGPPathAttrMPUnreachNLRI(packet.AfiL2VPN, packet.SafiEVPN)}
+	s.sendMP(packet.NewBGPUpdateMessage([]packet.NLRI{nlri}, pathAttrs, make([]packet.NLRI, 0)))
+}
+
+/*  Reads the EVPN routes sent to the speaker until no message is received for the idle time.
+ *  The MACs of the MAC/IP advertisement routes that are advertised and withdrawn are returned.
+ */
+func (s *testSpeaker) readEvpnMacs() (advertised []string, withdrawn []string) {
+	macs := make(map[string]bool)
+	for {
+		msg, err := s.read(testIdleTime)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				break
+			}
+			s.t.Fatal("Speaker", s.address, "failed to read the message, error:", err)
+		}
+		if msg.Header.Type != packet.BGPMsgTypeUpdate {
+			continue
+		}
+
+		for _, pathAttr := range msg.Body.(*packet.BGPUpdate).PathAttributes {
+			var nlriList []packet.NLRI
+			advertise := false
+			if mpReach, ok := pathAttr.(*packet.BGPPathAttrMPReachNLRI); ok {
+				nlriList, advertise = mpReach.NLRI, true
+			} else if mpUnreach, ok := pathAttr.(*packet.BGPPathAttrMPUnreachNLRI); ok {
+				nlriList = mpUnreach.NLRI
+			}
+			for _, nlri := range nlriList {
+				if evpnNLRI, ok := nlri.(*packet.EVPNNLRI); ok &&
+					evpnNLRI.RouteType == packet.EVPNRouteTypeMACIPAdvertisement {
+					macs[evpnNLRI.MAC.String()] = advertise
+				}
+			}
+		}
+	}
+
+	advertised, withdrawn = make([]string, 0), make([]string, 0)
+	for mac, advertise := range macs {
+		if advertise {
+			advertised = append(advertised, mac)
+		} else {
+			withdrawn = append(withdrawn, mac)
+		}
+	}
+	sort.Strings(advertised)
+	sort.Strings(withdrawn)
+	return advertised, withdrawn
+}
+
+func waitForTestVtepEntries(t *testing.T, name string, get func() []string, entries ...string) {
+	expected := fmt.Sprint(entries)
+	var programmed []string
+	for start := time.Now(); time.Since(start) < testTimeout; time.Sleep(50 * time.Millisecond) {
+		if programmed = get(); fmt.Sprint(programmed) == expected {
+			return
+		}
+	}
+	t.Fatal(name, programmed, "expected", expected)
+}
+
+func getTestVteps() []string      { return testVtepMgrs.get(testVtepMgrs.vteps) }
+func getTestRemoteMacs() []string { return testVtepMgrs.get(testVtepMgrs.remoteMacs) }
+
+/*  The MACs learnt before the EVPN instance of their VNI is added are advertised when the
+ *  instance is added, and withdrawn when they age out.
+ */
+func TestEvpnLocalMacs(t *testing.T) {
+	srv := startTestServer(t)
+	peerAddr := "127.0.17.1"
+	addTestNeighbor(srv, newTestEVPNNeighborConfig(peerAddr, testLocalAS))
+	defer removeTestNeighbor(srv, peerAddr)
+	peer := connectTestSpeaker(t, peerAddr, testLocalAS, testEVPNFamilies...)
+	defer peer.close()
+	peer.readEvpnMacs()
+
+	mac1 := &config.EvpnMacInfo{VNI: 1701, MAC: newTestMac(t, "02:00:00:00:17:01")}
+	mac2 := &config.EvpnMacInfo{VNI: 1701, MAC: newTestMac(t, "02:00:00:00:17:02")}
+	otherMac := &config.EvpnMacInfo{VNI: 1702, MAC: newTestMac(t, "02:00:00:00:17:03")}
+	api.SendEvpnMacNotification([]*config.EvpnMacInfo{mac1, otherMac}, nil)
+
+	evpn := config.EvpnConfig{VNI: 1701, RouteDistinguisher: "65000:1701", ExportRouteTargets: []string{"65000:1701"}}
+	srv.AddEvpnCh <- evpn
+	defer func() { srv.RemEvpnCh <- evpn.VNI }()
+	if advertised, _ := peer.readEvpnMacs(); fmt.Sprint(advertised) != fmt.Sprint([]string{mac1.MAC.String()}) {
+		t.Fatal("Advertised MACs", advertised, "when the EVPN instance is added, expected", mac1.MAC)
+	}
+
+	api.SendEvpnMacNotification([]*config.EvpnMacInfo{mac2}, []*config.EvpnMacInfo{mac1})
+	advertised, withdrawn := peer.readEvpnMacs()
+	if fmt.Sprint(advertised) != fmt.Sprint([]string{mac2.MAC.String()}) {
+		t.Error("Advertised MACs", advertised, "expected", mac2.MAC)
+	}
+	if fmt.Sprint(withdrawn) != fmt.Sprint([]string{mac1.MAC.String()}) {
+		t.Error("Withdrawn MACs", withdrawn, "expected", mac1.MAC)
+	}
+	api.SendEvpnMacNotification(nil, []*config.EvpnMacInfo{mac2, otherMac})
+}
+
+/*  The remote VTEP is created by the inclusive multicast route and the remote MACs are programmed
+ *  by the MAC/IP advertisement routes with an import route target of the instance.
+ */
+func TestEvpnRemoteMacs(t *testing.T) {
+	srv := startTestServer(t)
+	evpn := config.EvpnConfig{VNI: 1703, RouteDistinguisher: "65000:1703", ImportRouteTargets: []string{"65000:1703"}}
+	srv.AddEvpnCh <- evpn
+	defer func() { srv.RemEvpnCh <- evpn.VNI }()
+
+	peerAddr := "127.0.17.2"
+	addTestNeighbor(srv, newTestEVPNNeighborConfig(peerAddr, testLocalAS))
+	defer removeTestNeighbor(srv, peerAddr)
+	peer := connectTestSpeaker(t, peerAddr, testLocalAS, testEVPNFamilies...)
+	defer peer.close()
+
+	vtepIP := "127.0.17.12"
+	rd, _ := packet.ParseRouteDistinguisher("65000:12")
+	imet := packet.NewEVPNInclusiveMulticast(rd, 0, net.ParseIP(vtepIP).To4())
+	mac1 := packet.NewEVPNMACIPAdvertisement(rd, 0, newTestMac(t, "02:00:00:00:17:11"), nil, []uint32{evpn.VNI})
+	mac2 := packet.NewEVPNMACIPAdvertisement(rd, 0, newTestMac(t, "02:00:00:00:17:12"), nil, []uint32{evpn.VNI})
+	peer.advertiseEVPN(vtepIP, "65000:1703", imet)
+	peer.advertiseEVPN(vtepIP, "65000:1703", mac1)
+	peer.advertiseEVPN(vtepIP, "65000:1703", mac2)
+	waitForTestVtepEntries(t, "VTEPs", getTestVteps, "1703 "+vtepIP)
+	waitForTestVtepEntries(t, "Remote MACs", getTestRemoteMacs, "1703 02:00:00:00:17:11 via "+vtepIP,
+		"1703 02:00:00:00:17:12 via "+vtepIP)
+
+	peer.withdrawEVPN(mac1)
+	waitForTestVtepEntries(t, "Remote MACs", getTestRemoteMacs, "1703 02:00:00:00:17:12 via "+vtepIP)
+
+	// The MAC is not programmed without the import route target of the instance
+	mac3 := packet.NewEVPNMACIPAdvertisement(rd, 0, newTestMac(t, "02:00:00:00:17:13"), nil, []uint32{evpn.VNI})
+	peer.advertiseEVPN(vtepIP, "65000:99", mac3)
+	peer.withdrawEVPN(imet)
+	peer.withdrawEVPN(mac2)
+	waitForTestVtepEntries(t, "Remote MACs", getTestRemoteMacs)
+	waitForTestVtepEntries(t, "VTEPs", getTestVteps)
+}
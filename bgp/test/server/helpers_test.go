@@ -0,0 +1,537 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// helpers_test.go
+package servertest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"l3/bgp/config"
+	"l3/bgp/packet"
+	bgppolicy "l3/bgp/policy"
+	"l3/bgp/server"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"utils/logging"
+)
+
+/*  The tests run the BGP server with the managers below and establish the sessions with
+ *  test speakers that connect to the server over the loopback addresses. The server is
+ *  started once and shared by the tests, every test uses its own neighbor addresses.
+ */
+
+const (
+	testLocalAS  uint32 = 65000
+	testRouterId string = "127.0.0.1"
+	testHoldTime uint16 = 90
+	testTimeout         = 5 * time.Second
+	testIdleTime        = 500 * time.Millisecond
+)
+
+type testIntfMgr struct{}
+
+func (m *testIntfMgr) Start()                                    {}
+func (m *testIntfMgr) PortStateChange()                          {}
+func (m *testIntfMgr) GetIPv4Intfs() []*config.IntfStateInfo     { return nil }
+func (m *testIntfMgr) GetIfIndex(ifIndex int, ifType int) int32  { return int32(ifIndex) }
+func (m *testIntfMgr) GetIntfName(ifIndex int32) (string, error) { return "lo", nil }
+func (m *testIntfMgr) GetIPv4Information(ifIndex int32) (string, error) {
+	return "", errors.New("Not supported")
+}
+
+/*  All the next hops are reachable with the IGP metric set for the next hop. The routes
+ *  installed in the VRFs are kept by VRF as the network and the next hop of the route.
+ */
+type testRouteMgr struct {
+	mutex     sync.RWMutex
+	metrics   map[string]int32
+	vrfRoutes map[string]map[string]bool
+}
+
+func (m *testRouteMgr) Start() {}
+
+func (m *testRouteMgr) GetNextHopInfo(ipAddr string) (*config.NextHopInfo, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return &config.NextHopInfo{IPAddr: ipAddr, NextHopIp: ipAddr, Metric: m.metrics[ipAddr], IsReachable: true}, nil
+}
+
+func (m *testRouteMgr) setMetric(ipAddr string, metric int32) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.metrics[ipAddr] = metric
+}
+
+func (m *testRouteMgr) setVrfRoute(cfg *config.RouteConfig, installed bool) {
+	if cfg.Vrf == "" {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.vrfRoutes[cfg.Vrf]; !ok {
+		m.vrfRoutes[cfg.Vrf] = make(map[string]bool)
+	}
+	route := cfg.DestinationNw + " via " + cfg.NextHopIp
+	if installed {
+		m.vrfRoutes[cfg.Vrf][route] = true
+	} else {
+		delete(m.vrfRoutes[cfg.Vrf], route)
+	}
+}
+
+func (m *testRouteMgr) getVrfRoutes(vrf string) []string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	routes := make([]string, 0, len(m.vrfRoutes[vrf]))
+	for route, _ := range m.vrfRoutes[vrf] {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	return routes
+}
+
+func (m *testRouteMgr) CreateRoute(cfg *config.RouteConfig) { m.setVrfRoute(cfg, true) }
+func (m *testRouteMgr) DeleteRoute(cfg *config.RouteConfig) { m.setVrfRoute(cfg, false) }
+func (m *testRouteMgr) UpdateRoute(cfg *config.RouteConfig, op string) {
+	m.setVrfRoute(cfg, op != "remove")
+}
+func (m *testRouteMgr) ApplyPolicy(protocol string, policy string, action string,
+	conditions []*config.ConditionInfo) {
+}
+func (m *testRouteMgr) GetRoutes() ([]*config.RouteInfo, []*config.RouteInfo) { return nil, nil }
+func (m *testRouteMgr) GetInstalledRoutes() []*config.RouteConfig             { return nil }
+
+type testPolicyMgr struct{}
+
+func (m *testPolicyMgr) Start() {}
+
+type testBfdMgr struct{}
+
+func (m *testBfdMgr) Start() {}
+func (m *testBfdMgr) CreateBfdSession(ipAddr string, sessionParam string) (bool, error) {
+	return true, nil
+}
+func (m *testBfdMgr) DeleteBfdSession(ipAddr string) (bool, error) { return true, nil }
+
+/*  The VTEPs are kept as the VNI and the VTEP IP, the remote MACs as the VNI, the MAC and
+ *  the VTEP IP of the MAC.
+ */
+type testVtepMgr struct {
+	mutex      sync.RWMutex
+	vteps      map[string]bool
+	remoteMacs map[string]bool
+}
+
+func (m *testVtepMgr) Start() {}
+
+func (m *testVtepMgr) set(entries map[string]bool, key string, added bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if added {
+		entries[key] = true
+	} else {
+		delete(entries, key)
+	}
+}
+
+func (m *testVtepMgr) get(entries map[string]bool) []string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	keys := make([]string, 0, len(entries))
+	for key, _ := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func getTestVtepKey(vtep *config.VtepInfo) string {
+	return fmt.Sprintf("%d %s", vtep.VNI, vtep.VtepIP)
+}
+
+func getTestRemoteMacKey(mac *config.EvpnMacInfo) string {
+	return fmt.Sprintf("%d %s via %s", mac.VNI, mac.MAC, mac.VtepIP)
+}
+
+func (m *testVtepMgr) CreateVtep(vtep *config.VtepInfo) (bool, error) {
+	m.set(m.vteps, getTestVtepKey(vtep), true)
+	return true, nil
+}
+
+func (m *testVtepMgr) DeleteVtep(vtep *config.VtepInfo) (bool, error) {
+	m.set(m.vteps, getTestVtepKey(vtep), false)
+	return true, nil
+}
+
+func (m *testVtepMgr) AddRemoteMac(mac *config.EvpnMacInfo) (bool, error) {
+	m.set(m.remoteMacs, getTestRemoteMacKey(mac), true)
+	return true, nil
+}
+
+func (m *testVtepMgr) RemoveRemoteMac(mac *config.EvpnMacInfo) (bool, error) {
+	m.set(m.remoteMacs, getTestRemoteMacKey(mac), false)
+	return true, nil
+}
+
+var (
+	testServerOnce sync.Once
+	testServer     *server.BGPServer
+	testPolicyEng  *bgppolicy.BGPPolicyEngine
+	testRouteMgrs  = &testRouteMgr{metrics: make(map[string]int32), vrfRoutes: make(map[string]map[string]bool)}
+	testVtepMgrs   = &testVtepMgr{vteps: make(map[string]bool), remoteMacs: make(map[string]bool)}
+	testServerErr  error
+)
+
+// Starts the BGP server listening on the BGP port of the loopback address
+func startTestServer(t *testing.T) *server.BGPServer {
+	testServerOnce.Do(func() {
+		logger, err := logging.NewLogger("bgpd", "BGP", true)
+		if err != nil {
+			testServerErr = err
+			return
+		}
+
+		testPolicyEng = bgppolicy.NewBGPPolicyEngine(logger, &testPolicyMgr{})
+		go testPolicyEng.StartPolicyEngine()
+		testServer = server.NewBGPServer(logger, testPolicyEng, &testIntfMgr{}, testRouteMgrs, &testBfdMgr{},
+			testVtepMgrs)
+		go testServer.StartServer()
+		testServer.GlobalConfigCh <- config.GlobalConfig{AS: testLocalAS, RouterId: net.ParseIP(testRouterId),
+			GracefulShutdownTime: config.BGPGracefulShutdownTimeDefault}
+
+		for i := 0; i < 50; i++ {
+			var conn net.Conn
+			if conn, err = net.Dial("tcp", net.JoinHostPort(testRouterId, config.BGPPort)); err == nil {
+				conn.Close()
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		testServerErr = err
+	})
+
+	if testServerErr != nil {
+		t.Skip("Failed to start the BGP server, error:", testServerErr)
+	}
+	return testServer
+}
+
+func newTestNeighborConfig(address string, peerAS uint32) config.NeighborConfig {
+	return config.NeighborConfig{
+		BaseConfig: config.BaseConfig{
+			PeerAS:           peerAS,
+			LocalAS:          testLocalAS,
+			ConnectRetryTime: 60,
+			HoldTime:         uint32(testHoldTime),
+			KeepaliveTime:    uint32(testHoldTime) / 3,
+		},
+		NeighborAddress: net.ParseIP(address),
+	}
+}
+
+func addTestNeighbor(bgpServer *server.BGPServer, nConf config.NeighborConfig) {
+	bgpServer.AddPeerCh <- server.PeerUpdate{NewPeer: nConf, AttrSet: make([]bool, 0)}
+}
+
+func updateTestNeighbor(bgpServer *server.BGPServer, oldConf config.NeighborConfig, nConf config.NeighborConfig) {
+	bgpServer.AddPeerCh <- server.PeerUpdate{OldPeer: oldConf, NewPeer: nConf, AttrSet: make([]bool, 0)}
+}
+
+func removeTestNeighbor(bgpServer *server.BGPServer, address string) {
+	bgpServer.RemPeerCh <- address
+}
+
+// Speaker on a loopback address that connects to the BGP server
+type testSpeaker struct {
+	t        *testing.T
+	address  string
+	as       uint32
+	families []uint32
+	conn     net.Conn
+}
+
+/*  Connects to the server from the address and completes the OPEN exchange. The speaker
+ *  advertises the multiprotocol capability for the families, IPv4 unicast by default.
+ */
+func connectTestSpeaker(t *testing.T, address string, as uint32, families ...uint32) *testSpeaker {
+	return connectTestSpeakerWithDialer(t, address, as,
+		net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(address)}, Timeout: testTimeout}, families...)
+}
+
+func connectTestSpeakerWithDialer(t *testing.T, address string, as uint32, dialer net.Dialer,
+	families ...uint32) *testSpeaker {
+	if len(families) == 0 {
+		families = []uint32{packet.GetProtocolFamily(packet.AfiIP, packet.SafiUnicast)}
+	}
+
+	var conn net.Conn
+	var err error
+	for i := 0; i < 50; i++ {
+		// The server closes the connections of the neighbors it did not create yet
+		if conn, err = dialer.Dial("tcp", net.JoinHostPort(testRouterId, config.BGPPort)); err == nil {
+			s := &testSpeaker{t: t, address: address, as: as, families: families, conn: conn}
+			if err = s.open(); err == nil {
+				return s
+			}
+			conn.Close()
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	t.Fatal("Speaker", address, "failed to establish the session, error:", err)
+	return nil
+}
+
+func (s *testSpeaker) open() error {
+	capabilities := make([]packet.BGPCapability, 0, len(s.families)+1)
+	for _, protoFamily := range s.families {
+		afi, safi := packet.GetAfiSafi(protoFamily)
+		capabilities = append(capabilities, packet.NewBGPCapMPExt(afi, safi))
+	}
+	capabilities = append(capabilities, packet.NewBGPCap4ByteASPath(s.as))
+	optParams := []packet.BGPOptParam{packet.NewBGPOptParamCapability(capabilities)}
+	if err := s.send(packet.NewBGPOpenMessage(s.as, testHoldTime, s.address, optParams)); err != nil {
+		return err
+	}
+
+	for _, msgType := range []uint8{packet.BGPMsgTypeOpen, packet.BGPMsgTypeKeepAlive} {
+		msg, err := s.read(testTimeout)
+		if err != nil {
+			return err
+		}
+		if msg.Header.Type != msgType {
+			return errors.New(fmt.Sprintf("Expected message type %d, got %d", msgType, msg.Header.Type))
+		}
+		if msgType == packet.BGPMsgTypeOpen {
+			if err = s.send(packet.NewBGPKeepAliveMessage()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *testSpeaker) close() {
+	s.conn.Close()
+}
+
+func (s *testSpeaker) send(msg *packet.BGPMessage) error {
+	pkt, err := msg.Encode()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.conn.Write(pkt)
+	return err
+}
+
+func (s *testSpeaker) read(timeout time.Duration) (*packet.BGPMessage, error) {
+	s.conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, packet.BGPMsgHeaderLen)
+	if _, err := io.ReadFull(s.conn, buf); err != nil {
+		return nil, err
+	}
+
+	header := packet.NewBGPHeader()
+	if err := header.Decode(buf); err != nil {
+		return nil, err
+	}
+
+	body := make([]byte, header.Len()-packet.BGPMsgHeaderLen)
+	if _, err := io.ReadFull(s.conn, body); err != nil {
+		return nil, err
+	}
+
+	msg := packet.NewBGPMessage()
+	peerAttrs := packet.BGPPeerAttrs{ASSize: 4, AddPathFamily: make(map[packet.AFI]map[packet.SAFI]uint8)}
+	if err := msg.Decode(header, body, peerAttrs); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// Sends the prefixes with the AS path that starts with the AS of an external speaker
+func (s *testSpeaker) advertise(nextHop string, med uint32, asPath []uint32, prefixes ...string) {
+	pathAttrs := packet.ConstructPathAttrForConnRoutes(net.ParseIP(nextHop).To4(), 0)
+	if med != 0 {
+		medAttr := packet.NewBGPPathAttrMultiExitDisc()
+		medAttr.Value = med
+		pathAttrs = append(pathAttrs, medAttr)
+	}
+
+	msg := packet.NewBGPUpdateMessage(nil, pathAttrs, newTestNLRIList(s.t, prefixes))
+	for idx := len(asPath) - 1; idx >= 0; idx-- {
+		packet.PrependAS(msg, asPath[idx], 4)
+	}
+	if s.as != testLocalAS {
+		packet.PrependAS(msg, s.as, 4)
+	}
+	if err := s.send(msg); err != nil {
+		s.t.Fatal("Speaker", s.address, "failed to send the update, error:", err)
+	}
+}
+
+func (s *testSpeaker) withdraw(prefixes ...string) {
+	msg := packet.NewBGPUpdateMessage(newTestNLRIList(s.t, prefixes), nil, nil)
+	if err := s.send(msg); err != nil {
+		s.t.Fatal("Speaker", s.address, "failed to send the withdraw, error:", err)
+	}
+}
+
+func newTestNLRIList(t *testing.T, prefixes []string) []packet.NLRI {
+	nlriList := make([]packet.NLRI, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		ip, ipNet, err := net.ParseCIDR(prefix)
+		if err != nil {
+			t.Fatal("Failed to parse prefix", prefix, "error:", err)
+		}
+		ones, _ := ipNet.Mask.Size()
+		nlriList = append(nlriList, packet.NewIPPrefix(ip.Mask(ipNet.Mask), uint8(ones)))
+	}
+	return nlriList
+}
+
+// Routes received by a speaker, the path attrs of the advertised prefixes are kept by prefix
+type testRoutes struct {
+	advertised map[string][]packet.BGPPathAttr
+	withdrawn  map[string]bool
+}
+
+/*  Reads the Update messages until no message is received for the idle time. The messages
+ *  are read for at least wait, so that the updates that are sent late are not missed.
+ */
+func (s *testSpeaker) readRoutes(wait time.Duration) *testRoutes {
+	routes := &testRoutes{
+		advertised: make(map[string][]packet.BGPPathAttr),
+		withdrawn:  make(map[string]bool),
+	}
+
+	start := time.Now()
+	for {
+		timeout := testIdleTime
+		if remaining := wait - time.Since(start); remaining > timeout {
+			timeout = remaining
+		}
+
+		msg, err := s.read(timeout)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				return routes
+			}
+			s.t.Fatal("Speaker", s.address, "failed to read the message, error:", err)
+		}
+
+		if msg.Header.Type == packet.BGPMsgTypeNotification {
+			s.t.Fatal("Speaker", s.address, "received NOTIFICATION", msg.Body)
+		}
+		if msg.Header.Type != packet.BGPMsgTypeUpdate {
+			continue
+		}
+
+		update := msg.Body.(*packet.BGPUpdate)
+		for _, nlri := range update.WithdrawnRoutes {
+			prefix := fmt.Sprintf("%s/%d", nlri.GetPrefix().Prefix, nlri.GetPrefix().Length)
+			delete(routes.advertised, prefix)
+			routes.withdrawn[prefix] = true
+		}
+		for _, nlri := range update.NLRI {
+			prefix := fmt.Sprintf("%s/%d", nlri.GetPrefix().Prefix, nlri.GetPrefix().Length)
+			delete(routes.withdrawn, prefix)
+			routes.advertised[prefix] = update.PathAttributes
+		}
+	}
+}
+
+func getTestNextHop(pathAttrs []packet.BGPPathAttr) string {
+	return packet.GetNextHop(pathAttrs).String()
+}
+
+func getTestASPath(pathAttrs []packet.BGPPathAttr) string {
+	return strings.Join(packet.GetASList(pathAttrs), " ")
+}
+
+// Returns the members of the update group of the neighbor, the leader of the group is the first member
+func getTestUpdateGroup(bgpServer *server.BGPServer, address string) []string {
+	for _, group := range bgpServer.GetBGPUpdateGroups() {
+		for _, member := range group.Members {
+			if member == address {
+				return group.Members
+			}
+		}
+	}
+	return nil
+}
+
+// Waits for the members of the update group of the neighbor to match the members
+func waitForTestUpdateGroup(t *testing.T, bgpServer *server.BGPServer, address string, members ...string) {
+	var group []string
+	for start := time.Now(); time.Since(start) < testTimeout; time.Sleep(50 * time.Millisecond) {
+		group = getTestUpdateGroup(bgpServer, address)
+		if fmt.Sprint(group) == fmt.Sprint(members) {
+			return
+		}
+	}
+	t.Fatal("Update group of neighbor", address, "is", group, "expected", members)
+}
+
+func expectTestRoutes(t *testing.T, s *testSpeaker, routes *testRoutes, prefixes ...string) {
+	if len(routes.advertised) != len(prefixes) {
+		t.Error("Speaker", s.address, "received routes", routes.advertised, "expected", prefixes)
+	}
+	for _, prefix := range prefixes {
+		if _, ok := routes.advertised[prefix]; !ok {
+			t.Error("Speaker", s.address, "did not receive route", prefix)
+		}
+	}
+}
+
+/*  Creates the route policy with a statement per condition, the statements have the result.
+ *  Returns once the policy engine created the policy.
+ */
+func createTestPolicy(t *testing.T, name string, result config.RoutePolicyResult,
+	conditions ...config.RoutePolicyConditionConfig) {
+	statements := make([]string, 0, len(conditions))
+	for _, condition := range conditions {
+		testPolicyEng.RouteConditionCfgCh <- condition
+		stmt := config.RoutePolicyStmtConfig{Name: name + "-" + condition.Name, Conditions: []string{condition.Name},
+			Result: result}
+		testPolicyEng.RouteStmtCfgCh <- stmt
+		statements = append(statements, stmt.Name)
+	}
+	testPolicyEng.RoutePolicyCfgCh <- config.RoutePolicyConfig{Name: name, Statements: statements,
+		DefaultResult: config.RoutePolicyResultAccept}
+
+	for start := time.Now(); time.Since(start) < testTimeout; time.Sleep(50 * time.Millisecond) {
+		if testPolicyEng.RoutePolicyDB.HasPolicy(name) {
+			return
+		}
+	}
+	t.Fatal("Route policy", name, "was not created")
+}
@@ -0,0 +1,157 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+
+// routeServer_test.go
+package servertest
+
+import (
+	"l3/bgp/config"
+	"l3/bgp/rpki"
+	"l3/bgp/server"
+	"net"
+	"testing"
+	"time"
+)
+
+// Route server client that gets the routes selected from its view
+func newTestRouteServerClientConfig(address string, peerAS uint32) config.NeighborConfig {
+	nConf := newTestNeighborConfig(address, peerAS)
+	nConf.RouteServerClient = true
+	return nConf
+}
+
+// The routes over the prefix limit of the neighbor are not added to the views of the clients
+func TestRouteServerPrefixLimit(t *testing.T) {
+	bgpServer := startTestServer(t)
+	source, client := "127.0.25.1", "127.0.25.2"
+	nConf := newTestNeighborConfig(source, 65250)
+	nConf.MaxPrefixes = 1
+	addTestNeighbor(bgpServer, nConf)
+	addTestNeighbor(bgpServer, newTestRouteServerClientConfig(client, 65251))
+
+	sourceSpeaker := connectTestSpeaker(t, source, 65250)
+	clientSpeaker := connectTestSpeaker(t, client, 65251)
+	defer cleanupUpdateGroupTest(bgpServer, sourceSpeaker, clientSpeaker)
+	waitForTestUpdateGroup(t, bgpServer, client, client)
+
+	sourceSpeaker.advertise(source, 0, nil, "10.25.1.0/24")
+	routes := clientSpeaker.readRoutes(testIdleTime)
+	expectTestRoutes(t, clientSpeaker, routes, "10.25.1.0/24")
+	if asPath := getTestASPath(routes.advertised["10.25.1.0/24"]); asPath != "65250" {
+		t.Error("Client", client, "received route 10.25.1.0/24 with AS path", asPath, "expected 65250")
+	}
+
+	sourceSpeaker.advertise(source, 0, nil, "10.25.2.0/24")
+	expectTestRoutes(t, clientSpeaker, clientSpeaker.readRoutes(testIdleTime))
+}
+
+// Stub RPKI cache that sends the VRPs to the cache client of the server
+type testRpkiCache struct {
+	t        *testing.T
+	listener net.Listener
+	conn     net.Conn
+}
+
+// Starts the stub RPKI cache on the address and adds it to the RPKI caches of the server
+func startTestRpkiCache(t *testing.T, bgpServer *server.BGPServer, address string) *testRpkiCache {
+	listener, err := net.Listen("tcp", net.JoinHostPort(address, "0"))
+	if err != nil {
+		t.Fatal("Failed to start the stub RPKI cache, error:", err)
+	}
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	bgpServer.AddRpkiCacheCh <- config.RpkiCacheConfig{Address: address, Port: uint16(port), RetryInterval: 1}
+	return &testRpkiCache{t: t, listener: listener}
+}
+
+func (c *testRpkiCache) sendVRPs(vrps ...*rpki.RTRPDU) {
+	c.listener.(*net.TCPListener).SetDeadline(time.Now().Add(testTimeout))
+	conn, err := c.listener.Accept()
+	if err != nil {
+		c.t.Fatal("Stub RPKI cache failed to accept the connection, error:", err)
+	}
+	c.conn = conn
+
+	conn.SetReadDeadline(time.Now().Add(testTimeout))
+	if _, err = rpki.ReadRTRPDU(conn); err != nil {
+		c.t.Fatal("Stub RPKI cache failed to read the Reset Query, error:", err)
+	}
+
+	pdus := append([]*rpki.RTRPDU{rpki.NewRTRCacheResponsePDU(rpki.RTRVersion1, 25)}, vrps...)
+	pdus = append(pdus, rpki.NewRTREndOfDataPDU(rpki.RTRVersion1, 25, 1, 3600, 600, 7200))
+	for _, pdu := range pdus {
+		pkt, err := pdu.Encode()
+		if err != nil {
+			c.t.Fatal("Stub RPKI cache failed to encode the PDU, error:", err)
+		}
+		if _, err = conn.Write(pkt); err != nil {
+			c.t.Fatal("Stub RPKI cache failed to send the PDU, error:", err)
+		}
+	}
+}
+
+func (c *testRpkiCache) close() {
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.listener.Close()
+}
+
+/*  The export policy of a client that matches on the validation state gets the validation state
+ *  of the routes, the view of the client is built again when the VRPs change.
+ */
+func TestRouteServerRpkiExportPolicy(t *testing.T) {
+	bgpServer := startTestServer(t)
+	source, client, cacheAddress := "127.0.26.1", "127.0.26.2", "127.0.26.9"
+	createTestPolicy(t, "export-26", config.RoutePolicyResultReject, config.RoutePolicyConditionConfig{
+		Name:             "rpki-invalid-26",
+		ConditionType:    config.RoutePolicyConditionTypeRpkiValidation,
+		ValidationStates: []string{"invalid"},
+	})
+
+	addTestNeighbor(bgpServer, newTestNeighborConfig(source, 65260))
+	nConf := newTestRouteServerClientConfig(client, 65261)
+	nConf.ExportPolicy = "export-26"
+	addTestNeighbor(bgpServer, nConf)
+
+	sourceSpeaker := connectTestSpeaker(t, source, 65260)
+	clientSpeaker := connectTestSpeaker(t, client, 65261)
+	defer cleanupUpdateGroupTest(bgpServer, sourceSpeaker, clientSpeaker)
+	waitForTestUpdateGroup(t, bgpServer, client, client)
+
+	sourceSpeaker.advertise(source, 0, nil, "10.26.0.0/16")
+	expectTestRoutes(t, clientSpeaker, clientSpeaker.readRoutes(testIdleTime), "10.26.0.0/16")
+
+	// The route becomes invalid when the VRP of another origin AS covers it
+	cache := startTestRpkiCache(t, bgpServer, cacheAddress)
+	defer cache.close()
+	defer func() { bgpServer.RemRpkiCacheCh <- cacheAddress }()
+	cache.sendVRPs(rpki.NewRTRPrefixPDU(rpki.RTRVersion1, true, net.ParseIP("10.26.0.0"), 16, 16, 65269))
+	if routes := clientSpeaker.readRoutes(testIdleTime); !routes.withdrawn["10.26.0.0/16"] {
+		t.Fatal("Client", client, "did not receive the withdraw of the invalid route 10.26.0.0/16")
+	}
+
+	sourceSpeaker.advertise(source, 0, nil, "10.26.1.0/24", "10.27.0.0/16")
+	expectTestRoutes(t, clientSpeaker, clientSpeaker.readRoutes(testIdleTime), "10.27.0.0/16")
+}
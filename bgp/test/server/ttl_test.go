@@ -0,0 +1,61 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+
+// ttl_test.go
+package servertest
+
+import (
+	"l3/bgp/config"
+	"l3/bgp/utils"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+/*  The speaker drops the packets with a TTL below 255 like a neighbor with TTL security. The
+ *  connection is established only if the server sends the SYN-ACK with TTL 255.
+ */
+func TestTtlSecurityIncomingConnection(t *testing.T) {
+	srv := startTestServer(t)
+	addr := "127.0.15.1"
+	nConf := newTestNeighborConfig(addr, 65015)
+	nConf.TtlSecurityHops = 1
+	addTestNeighbor(srv, nConf)
+	defer removeTestNeighbor(srv, addr)
+
+	dialer := net.Dialer{
+		LocalAddr: &net.TCPAddr{IP: net.ParseIP(addr)},
+		Timeout:   time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			var err error
+			c.Control(func(fd uintptr) {
+				err = utils.SetSocketTTL(int(fd), false, config.BGPMaxTTL, config.BGPMaxTTL)
+			})
+			return err
+		},
+	}
+	speaker := connectTestSpeakerWithDialer(t, addr, 65015, dialer)
+	speaker.close()
+}
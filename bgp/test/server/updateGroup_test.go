@@ -0,0 +1,191 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// updateGroup_test.go
+package servertest
+
+import (
+	"l3/bgp/config"
+	"l3/bgp/packet"
+	"l3/bgp/server"
+	"net"
+	"testing"
+)
+
+/*  Sets up an internal source of routes and two external neighbors with the same config,
+ *  the external neighbors share an update group with the first one to establish as leader.
+ */
+func setupUpdateGroupTest(t *testing.T, source string, leader string, member string) (*server.BGPServer,
+	*testSpeaker, *testSpeaker, *testSpeaker) {
+	bgpServer := startTestServer(t)
+	addTestNeighbor(bgpServer, newTestNeighborConfig(source, testLocalAS))
+	addTestNeighbor(bgpServer, newTestNeighborConfig(leader, 65201))
+	addTestNeighbor(bgpServer, newTestNeighborConfig(member, 65202))
+
+	sourceSpeaker := connectTestSpeaker(t, source, testLocalAS)
+	leaderSpeaker := connectTestSpeaker(t, leader, 65201)
+	waitForTestUpdateGroup(t, bgpServer, leader, leader)
+	memberSpeaker := connectTestSpeaker(t, member, 65202)
+	waitForTestUpdateGroup(t, bgpServer, leader, leader, member)
+	return bgpServer, sourceSpeaker, leaderSpeaker, memberSpeaker
+}
+
+func cleanupUpdateGroupTest(bgpServer *server.BGPServer, speakers ...*testSpeaker) {
+	for _, speaker := range speakers {
+		speaker.close()
+		removeTestNeighbor(bgpServer, speaker.address)
+	}
+}
+
+func TestUpdateGroupFanOut(t *testing.T) {
+	bgpServer, source, leader, member := setupUpdateGroupTest(t, "127.0.2.1", "127.0.2.2", "127.0.2.3")
+	defer cleanupUpdateGroupTest(bgpServer, source, leader, member)
+
+	if group := getTestUpdateGroup(bgpServer, source.address); len(group) != 1 {
+		t.Error("Internal neighbor", source.address, "shares the update group", group, "with external neighbors")
+	}
+
+	source.advertise("127.0.2.1", 0, nil, "10.21.0.0/16", "10.22.0.0/16")
+	for _, speaker := range []*testSpeaker{leader, member} {
+		routes := speaker.readRoutes(testIdleTime)
+		expectTestRoutes(t, speaker, routes, "10.21.0.0/16", "10.22.0.0/16")
+		for prefix, pathAttrs := range routes.advertised {
+			if nextHop := getTestNextHop(pathAttrs); nextHop != testRouterId {
+				t.Error("Speaker", speaker.address, "received route", prefix, "with next hop", nextHop,
+					"expected", testRouterId)
+			}
+			if asPath := getTestASPath(pathAttrs); asPath != "65000" {
+				t.Error("Speaker", speaker.address, "received route", prefix, "with AS path", asPath,
+					"expected 65000")
+			}
+		}
+	}
+
+	source.withdraw("10.21.0.0/16")
+	for _, speaker := range []*testSpeaker{leader, member} {
+		if routes := speaker.readRoutes(testIdleTime); !routes.withdrawn["10.21.0.0/16"] {
+			t.Error("Speaker", speaker.address, "did not receive the withdraw of 10.21.0.0/16")
+		}
+	}
+}
+
+func TestUpdateGroupLeaderChange(t *testing.T) {
+	bgpServer, source, leader, member := setupUpdateGroupTest(t, "127.0.3.1", "127.0.3.2", "127.0.3.3")
+	defer cleanupUpdateGroupTest(bgpServer, source, member)
+
+	// The next member becomes the leader when the session of the leader goes down
+	leader.close()
+	waitForTestUpdateGroup(t, bgpServer, member.address, member.address)
+	removeTestNeighbor(bgpServer, leader.address)
+
+	source.advertise("127.0.3.1", 0, nil, "10.31.0.0/16")
+	routes := member.readRoutes(testIdleTime)
+	expectTestRoutes(t, member, routes, "10.31.0.0/16")
+	if asPath := getTestASPath(routes.advertised["10.31.0.0/16"]); asPath != "65000" {
+		t.Error("Speaker", member.address, "received route 10.31.0.0/16 with AS path", asPath, "expected 65000")
+	}
+}
+
+func TestUpdateGroupRibOutReplay(t *testing.T) {
+	bgpServer, source, leader, member := setupUpdateGroupTest(t, "127.0.4.1", "127.0.4.2", "127.0.4.3")
+	defer cleanupUpdateGroupTest(bgpServer, source, leader)
+
+	source.advertise("127.0.4.1", 0, nil, "10.41.0.0/16", "10.42.0.0/16")
+	expectTestRoutes(t, member, member.readRoutes(testIdleTime), "10.41.0.0/16", "10.42.0.0/16")
+	leader.readRoutes(testIdleTime)
+
+	// The member that joins the group again gets the rib out of the group, the leader gets nothing
+	member.close()
+	waitForTestUpdateGroup(t, bgpServer, leader.address, leader.address)
+	member = connectTestSpeaker(t, member.address, 65202)
+	defer cleanupUpdateGroupTest(bgpServer, member)
+	waitForTestUpdateGroup(t, bgpServer, leader.address, leader.address, member.address)
+
+	expectTestRoutes(t, member, member.readRoutes(testIdleTime), "10.41.0.0/16", "10.42.0.0/16")
+	if routes := leader.readRoutes(testIdleTime); len(routes.advertised) != 0 || len(routes.withdrawn) != 0 {
+		t.Error("Leader", leader.address, "received routes", routes.advertised, "withdraws", routes.withdrawn,
+			"when a member joined the group")
+	}
+}
+
+func TestUpdateGroupRegroup(t *testing.T) {
+	bgpServer, source, leader, member := setupUpdateGroupTest(t, "127.0.5.1", "127.0.5.2", "127.0.5.3")
+	defer cleanupUpdateGroupTest(bgpServer, source, leader, member)
+
+	source.advertise("127.0.5.1", 0, nil, "10.51.0.0/16")
+	expectTestRoutes(t, member, member.readRoutes(testIdleTime), "10.51.0.0/16")
+	leader.readRoutes(testIdleTime)
+
+	// A draining member moves to its own group and gets the routes with the GRACEFUL_SHUTDOWN community
+	bgpServer.PeerCommandCh <- config.PeerCommand{IP: net.ParseIP(member.address), Command: config.PeerCommandDrain}
+	waitForTestUpdateGroup(t, bgpServer, member.address, member.address)
+	waitForTestUpdateGroup(t, bgpServer, leader.address, leader.address)
+	routes := member.readRoutes(testIdleTime)
+	expectTestRoutes(t, member, routes, "10.51.0.0/16")
+	if !packet.HasCommunity(routes.advertised["10.51.0.0/16"], packet.BGPCommunityGracefulShutdown) {
+		t.Error("Draining member", member.address, "received the route without the GRACEFUL_SHUTDOWN community")
+	}
+	if routes = leader.readRoutes(testIdleTime); len(routes.advertised) != 0 {
+		t.Error("Leader", leader.address, "received routes", routes.advertised, "when a member was regrouped")
+	}
+
+	// The member joins the group of the leader again when it stops draining
+	bgpServer.PeerCommandCh <- config.PeerCommand{IP: net.ParseIP(member.address), Command: config.PeerCommandUndrain}
+	waitForTestUpdateGroup(t, bgpServer, leader.address, leader.address, member.address)
+	routes = member.readRoutes(testIdleTime)
+	expectTestRoutes(t, member, routes, "10.51.0.0/16")
+	if packet.HasCommunity(routes.advertised["10.51.0.0/16"], packet.BGPCommunityGracefulShutdown) {
+		t.Error("Member", member.address, "received the route with the GRACEFUL_SHUTDOWN community after undrain")
+	}
+}
+
+/*  The export policy that matches on the neighbor is evaluated for every neighbor, the neighbors
+ *  with the policy are not grouped with a leader that evaluates the policy for them.
+ */
+func TestUpdateGroupNeighborSetExportPolicy(t *testing.T) {
+	bgpServer := startTestServer(t)
+	source, first, second := "127.0.24.1", "127.0.24.2", "127.0.24.3"
+	createTestPolicy(t, "export-24", config.RoutePolicyResultReject, config.RoutePolicyConditionConfig{
+		Name:          "neighbor-24",
+		ConditionType: config.RoutePolicyConditionTypeNeighborSet,
+		Neighbors:     []string{first},
+	})
+
+	addTestNeighbor(bgpServer, newTestNeighborConfig(source, testLocalAS))
+	for _, address := range []string{first, second} {
+		nConf := newTestNeighborConfig(address, 65240)
+		nConf.ExportPolicy = "export-24"
+		addTestNeighbor(bgpServer, nConf)
+	}
+
+	sourceSpeaker := connectTestSpeaker(t, source, testLocalAS)
+	firstSpeaker := connectTestSpeaker(t, first, 65240)
+	waitForTestUpdateGroup(t, bgpServer, first, first)
+	secondSpeaker := connectTestSpeaker(t, second, 65240)
+	defer cleanupUpdateGroupTest(bgpServer, sourceSpeaker, firstSpeaker, secondSpeaker)
+	waitForTestUpdateGroup(t, bgpServer, second, second)
+
+	sourceSpeaker.advertise(source, 0, nil, "10.24.0.0/16")
+	expectTestRoutes(t, firstSpeaker, firstSpeaker.readRoutes(testIdleTime))
+	expectTestRoutes(t, secondSpeaker, secondSpeaker.readRoutes(testIdleTime), "10.24.0.0/16")
+}
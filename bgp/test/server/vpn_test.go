@@ -0,0 +1,223 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+
+// vpn_test.go
+package servertest
+
+import (
+	"fmt"
+	"l3/bgp/config"
+	"l3/bgp/packet"
+	"net"
+	"testing"
+	"time"
+)
+
+var testVPNFamilies = []uint32{
+	packet.GetProtocolFamily(packet.AfiIP, packet.SafiUnicast),
+	packet.GetProtocolFamily(packet.AfiIP, packet.SafiMPLSVPN),
+}
+
+func newTestVPNNeighborConfig(address string, peerAS uint32) config.NeighborConfig {
+	nConf := newTestNeighborConfig(address, peerAS)
+	nConf.AfiSafis = []config.AfiSafiConfig{
+		{AfiSafiName: "ipv4-unicast", AfiSafiEnabled: true},
+		{AfiSafiName: "l3vpn-ipv4-unicast", AfiSafiEnabled: true},
+	}
+	return nConf
+}
+
+func newTestVPNPrefix(t *testing.T, rd string, label uint32, prefix string) packet.NLRI {
+	routeDistinguisher, err := packet.ParseRouteDistinguisher(rd)
+	if err != nil {
+		t.Fatal("Failed to parse route distinguisher", rd, "error:", err)
+	}
+	ipPrefix := newTestNLRIList(t, []string{prefix})[0].(*packet.IPPrefix)
+	return packet.NewVPNPrefix(routeDistinguisher, *ipPrefix, []uint32{label})
+}
+
+func (s *testSpeaker) sendMP(msg *packet.BGPMessage) {
+	for _, updateMsg := range packet.ConstructMaxSizedUpdatePackets(msg) {
+		if err := s.send(updateMsg); err != nil {
+			s.t.Fatal("Speaker", s.address, "failed to send the update, error:", err)
+		}
+	}
+}
+
+// Advertises the VPN route of another PE with the route target
+func (s *testSpeaker) advertiseVPN(nextHop string, routeTarget string, nlri packet.NLRI) {
+	s.advertiseVPNWithLocalPref(nextHop, routeTarget, 0, nlri)
+}
+
+// The route is advertised without a LOCAL_PREF when localPref is 0
+func (s *testSpeaker) advertiseVPNWithLocalPref(nextHop string, routeTarget string, localPref uint32,
+	nlri packet.NLRI) {
+	rt, err := packet.ParseRouteTarget(routeTarget)
+	if err != nil {
+		s.t.Fatal("Failed to parse route target", routeTarget, "error:", err)
+	}
+
+	pathAttrs := packet.ConstructPathAttrForConnRoutes(net.ParseIP(nextHop).To4(), 0)
+	if localPref != 0 {
+		pathAttrs = packet.SetLocalPrefInPathAttrs(pathAttrs, localPref)
+	}
+	pathAttrs = packet.ConstructPathAttrsForFamily(pathAttrs, packet.AfiIP, packet.SafiMPLSVPN)
+	pathAttrs = packet.AddExtCommunities(pathAttrs, []uint64{rt})
+	s.sendMP(packet.NewBGPUpdateMessage(make([]packet.NLRI, 0), pathAttrs, []packet.NLRI{nlri}))
+}
+
+func (s *testSpeaker) withdrawVPN(nlri packet.NLRI) {
+	pathAttrs := []packet.BGPPathAttr{packet.NewBGPPathAttrMPUnreachNLRI(packet.AfiIP, packet.SafiMPLSVPN)}
+	s.sendMP(packet.NewBGPUpdateMessage([]packet.NLRI{nlri}, pathAttrs, make([]packet.NLRI, 0)))
+}
+
+func waitForTestVrfRoutes(t *testing.T, vrf string, routes ...string) {
+	expected := fmt.Sprint(routes)
+	var installed []string
+	for start := time.Now(); time.Since(start) < testTimeout; time.Sleep(50 * time.Millisecond) {
+		if installed = testRouteMgrs.getVrfRoutes(vrf); fmt.Sprint(installed) == expected {
+			return
+		}
+	}
+	t.Fatal("VRF", vrf, "routes", installed, "expected", expected)
+}
+
+/*  The route reflector sends the routes of two PEs for the same prefix with different route
+ *  distinguishers. The routes are paths of the same prefix in the VRF, the best path is
+ *  installed and withdrawing it installs the other path.
+ */
+func TestVrfRoutesWithDifferentRouteDistinguishers(t *testing.T) {
+	srv := startTestServer(t)
+	vrf := config.VrfConfig{
+		Name:               "vrf16",
+		RouteDistinguisher: "65000:16",
+		ImportRouteTargets: []string{"65000:16"},
+		ExportRouteTargets: []string{"65000:16"},
+		Label:              1600,
+	}
+	srv.AddVrfCh <- vrf
+	defer func() { srv.RemVrfCh <- vrf.Name }()
+
+	rrAddr := "127.0.16.1"
+	addTestNeighbor(srv, newTestVPNNeighborConfig(rrAddr, testLocalAS))
+	defer removeTestNeighbor(srv, rrAddr)
+	rr := connectTestSpeaker(t, rrAddr, testLocalAS, testVPNFamilies...)
+	defer rr.close()
+
+	pe1Route := newTestVPNPrefix(t, "65000:1", 100, "20.16.1.0/24")
+	pe2Route := newTestVPNPrefix(t, "65000:2", 200, "20.16.1.0/24")
+	rr.advertiseVPNWithLocalPref("127.0.16.11", "65000:16", 200, pe1Route)
+	rr.advertiseVPN("127.0.16.12", "65000:16", pe2Route)
+	waitForTestVrfRoutes(t, vrf.Name, "20.16.1.0 via 127.0.16.11")
+
+	rr.withdrawVPN(pe1Route)
+	waitForTestVrfRoutes(t, vrf.Name, "20.16.1.0 via 127.0.16.12")
+
+	// The route is not imported without the import route target of the VRF
+	rr.advertiseVPN("127.0.16.12", "65000:99", pe2Route)
+	waitForTestVrfRoutes(t, vrf.Name)
+}
+
+// The import policy of the neighbor applies to the VPN routes before they are imported into the VRFs
+func TestVrfRoutesImportPolicy(t *testing.T) {
+	srv := startTestServer(t)
+	vrf := config.VrfConfig{
+		Name:               "vrf18",
+		RouteDistinguisher: "65000:18",
+		ImportRouteTargets: []string{"65000:18"},
+		ExportRouteTargets: []string{"65000:18"},
+		Label:              1800,
+	}
+	srv.AddVrfCh <- vrf
+	defer func() { srv.RemVrfCh <- vrf.Name }()
+
+	rrAddr, rejectedNextHop := "127.0.18.1", "127.0.18.12"
+	createTestPolicy(t, "import-18", config.RoutePolicyResultReject, config.RoutePolicyConditionConfig{
+		Name:          "next-hop-18",
+		ConditionType: config.RoutePolicyConditionTypeNextHopSet,
+		NextHops:      []string{rejectedNextHop},
+	})
+	nConf := newTestVPNNeighborConfig(rrAddr, testLocalAS)
+	nConf.ImportPolicy = "import-18"
+	addTestNeighbor(srv, nConf)
+	defer removeTestNeighbor(srv, rrAddr)
+	rr := connectTestSpeaker(t, rrAddr, testLocalAS, testVPNFamilies...)
+	defer rr.close()
+
+	rr.advertiseVPN("127.0.18.11", "65000:18", newTestVPNPrefix(t, "65000:1", 100, "20.18.1.0/24"))
+	rr.advertiseVPN(rejectedNextHop, "65000:18", newTestVPNPrefix(t, "65000:2", 200, "20.18.2.0/24"))
+	waitForTestVrfRoutes(t, vrf.Name, "20.18.1.0 via 127.0.18.11")
+
+	// The route rejected by the import policy is withdrawn from the VRF
+	rr.advertiseVPN(rejectedNextHop, "65000:18", newTestVPNPrefix(t, "65000:1", 100, "20.18.1.0/24"))
+	waitForTestVrfRoutes(t, vrf.Name)
+}
+
+/*  The VPN routes are counted in the prefix limit of the neighbor once per received route, the
+ *  route imported into two VRFs counts once and the route imported into no VRF counts too.
+ */
+func TestVrfRoutesPrefixLimit(t *testing.T) {
+	srv := startTestServer(t)
+	vrfs := []config.VrfConfig{
+		{Name: "vrf19a", RouteDistinguisher: "65000:191", ImportRouteTargets: []string{"65000:19"}, Label: 1910},
+		{Name: "vrf19b", RouteDistinguisher: "65000:192", ImportRouteTargets: []string{"65000:19"}, Label: 1920},
+	}
+	for _, vrf := range vrfs {
+		srv.AddVrfCh <- vrf
+		defer func(name string) { srv.RemVrfCh <- name }(vrf.Name)
+	}
+	waitForVrfRoutes := func(routes ...string) {
+		for _, vrf := range vrfs {
+			waitForTestVrfRoutes(t, vrf.Name, routes...)
+		}
+	}
+
+	rrAddr, nextHop := "127.0.19.1", "127.0.19.11"
+	nConf := newTestVPNNeighborConfig(rrAddr, testLocalAS)
+	nConf.MaxPrefixes = 3
+	addTestNeighbor(srv, nConf)
+	defer removeTestNeighbor(srv, rrAddr)
+	rr := connectTestSpeaker(t, rrAddr, testLocalAS, testVPNFamilies...)
+	defer rr.close()
+
+	imported := newTestVPNPrefix(t, "65000:1", 100, "20.19.1.0/24")
+	notImported := newTestVPNPrefix(t, "65000:1", 100, "20.19.2.0/24")
+	last := newTestVPNPrefix(t, "65000:1", 100, "20.19.3.0/24")
+	overLimit := newTestVPNPrefix(t, "65000:1", 100, "20.19.4.0/24")
+	rr.advertiseVPN(nextHop, "65000:19", imported)
+	rr.advertiseVPN(nextHop, "65000:99", notImported)
+	rr.advertiseVPN(nextHop, "65000:19", last)
+	waitForVrfRoutes("20.19.1.0 via 127.0.19.11", "20.19.3.0 via 127.0.19.11")
+
+	rr.advertiseVPN(nextHop, "65000:19", overLimit)
+	time.Sleep(testIdleTime)
+	waitForVrfRoutes("20.19.1.0 via 127.0.19.11", "20.19.3.0 via 127.0.19.11")
+
+	// The route over the limit is imported after a withdraw makes room for it
+	rr.withdrawVPN(imported)
+	waitForVrfRoutes("20.19.3.0 via 127.0.19.11")
+
+	rr.advertiseVPN(nextHop, "65000:19", overLimit)
+	waitForVrfRoutes("20.19.3.0 via 127.0.19.11", "20.19.4.0 via 127.0.19.11")
+}
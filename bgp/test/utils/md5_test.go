@@ -0,0 +1,102 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+
+// md5_test.go
+package utilstest
+
+import (
+	"l3/bgp/utils"
+	"net"
+	"syscall"
+	"testing"
+)
+
+// The listener bound to the unspecified address is a dual stack AF_INET6 socket
+func newDualStackListener(t *testing.T) *net.TCPListener {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv6unspecified})
+	if err != nil {
+		t.Skip("Dual stack listener is not supported, error:", err)
+	}
+	return listener
+}
+
+func skipIfMD5NotSupported(t *testing.T, err error) {
+	if err == syscall.ENOPROTOOPT || err == syscall.EPERM {
+		t.Skip("TCP MD5 signature is not supported by the kernel, error:", err)
+	}
+}
+
+func TestTCPListenerMD5PrefixDualStack(t *testing.T) {
+	listener := newDualStackListener(t)
+	defer listener.Close()
+
+	prefixes := []string{"10.1.0.0/16", "2001:db8::/32", "192.168.1.1/32"}
+	for _, prefix := range prefixes {
+		err := utils.SetTCPListenerMD5Prefix(listener, prefix, "secret")
+		skipIfMD5NotSupported(t, err)
+		if err != nil {
+			t.Error("Failed to set MD5 key for prefix", prefix, "on dual stack listener, error:", err)
+			continue
+		}
+
+		err = utils.SetTCPListenerMD5Prefix(listener, prefix, "")
+		if err != nil {
+			t.Error("Failed to remove MD5 key for prefix", prefix, "on dual stack listener, error:", err)
+		}
+	}
+}
+
+func TestTCPConnMD5IPv4PeerOnDualStackListener(t *testing.T) {
+	listener := newDualStackListener(t)
+	defer listener.Close()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	client, err := net.DialTCP("tcp4", nil, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		t.Skip("Failed to connect to the dual stack listener over IPv4, error:", err)
+	}
+	defer client.Close()
+
+	conn, err := listener.AcceptTCP()
+	if err != nil {
+		t.Fatal("Failed to accept the IPv4 connection, error:", err)
+	}
+	defer conn.Close()
+
+	err = utils.SetTCPConnMD5(conn, "127.0.0.1", "secret")
+	skipIfMD5NotSupported(t, err)
+	if err != nil {
+		t.Error("Failed to set MD5 key for IPv4 peer on the AF_INET6 socket, error:", err)
+	}
+
+	// The socket of the client is an AF_INET socket
+	err = utils.SetTCPConnMD5(client, "127.0.0.1", "secret")
+	if err != nil {
+		t.Error("Failed to set MD5 key for IPv4 peer on the AF_INET socket, error:", err)
+	}
+	err = utils.SetTCPConnMD5(client, "2001:db8::1", "secret")
+	if err == nil {
+		t.Error("MD5 key for IPv6 peer set on the AF_INET socket, expected an error")
+	}
+}
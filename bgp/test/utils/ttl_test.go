@@ -0,0 +1,90 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+
+// ttl_test.go
+package utilstest
+
+import (
+	"l3/bgp/utils"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func getSocketOpt(t *testing.T, conn syscall.Conn, level, opt int) int {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		t.Fatal("Failed to get the raw conn, error:", err)
+	}
+
+	var value int
+	var sockErr error
+	rawConn.Control(func(fd uintptr) {
+		value, sockErr = syscall.GetsockoptInt(int(fd), level, opt)
+	})
+	if sockErr != nil {
+		t.Fatal("Failed to get socket option", opt, "error:", sockErr)
+	}
+	return value
+}
+
+func TestTCPListenerTTLDualStack(t *testing.T) {
+	listener := newDualStackListener(t)
+	defer listener.Close()
+	defaultTTL := getSocketOpt(t, listener, syscall.IPPROTO_IP, syscall.IP_TTL)
+
+	if err := utils.SetTCPListenerTTL(listener, 255); err != nil {
+		t.Fatal("Failed to set TTL 255 on the dual stack listener, error:", err)
+	}
+	if ttl := getSocketOpt(t, listener, syscall.IPPROTO_IP, syscall.IP_TTL); ttl != 255 {
+		t.Error("Listener TTL is", ttl, "expected 255")
+	}
+	if hops := getSocketOpt(t, listener, syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS); hops != 255 {
+		t.Error("Listener hop limit is", hops, "expected 255")
+	}
+
+	// The connections from the IPv4 peers are accepted with the TTL of the listener
+	port := listener.Addr().(*net.TCPAddr).Port
+	client, err := net.DialTCP("tcp4", nil, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		t.Skip("Failed to connect to the dual stack listener over IPv4, error:", err)
+	}
+	defer client.Close()
+
+	conn, err := listener.AcceptTCP()
+	if err != nil {
+		t.Fatal("Failed to accept the IPv4 connection, error:", err)
+	}
+	defer conn.Close()
+	if ttl := getSocketOpt(t, conn, syscall.IPPROTO_IP, syscall.IP_TTL); ttl != 255 {
+		t.Error("Accepted connection TTL is", ttl, "expected 255")
+	}
+
+	if err := utils.SetTCPListenerTTL(listener, utils.DefaultTTL); err != nil {
+		t.Fatal("Failed to reset the TTL of the dual stack listener, error:", err)
+	}
+	if ttl := getSocketOpt(t, listener, syscall.IPPROTO_IP, syscall.IP_TTL); ttl != defaultTTL {
+		t.Error("Listener TTL is", ttl, "after the reset, expected", defaultTTL)
+	}
+}
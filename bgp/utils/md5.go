@@ -0,0 +1,143 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// md5.go
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	tcpMD5Sig           = 14 // TCP_MD5SIG
+	tcpMD5SigExt        = 32 // TCP_MD5SIG_EXT
+	tcpMD5SigFlagPrefix = 0x1
+	tcpMD5SigMaxKeyLen  = 80
+)
+
+// Layout of struct tcp_md5sig from linux/tcp.h
+type tcpMD5SigOpt struct {
+	addr      [128]byte
+	flags     uint8
+	prefixLen uint8
+	keyLen    uint16
+	ifIndex   int32
+	key       [tcpMD5SigMaxKeyLen]byte
+}
+
+/*  An IPv4 address is set as an IPv4-mapped IPv6 address on an AF_INET6 socket. The listener
+ *  bound to the unspecified address is an AF_INET6 socket that accepts the IPv4 connections
+ *  too, the kernel matches the IPv4 peers with the mapped address then. The kernel stores the
+ *  mapped address as an IPv4 key, so the prefix length of an IPv4 prefix stays at most 32.
+ */
+func newTCPMD5SigOpt(family int, ip net.IP, key string) (*tcpMD5SigOpt, error) {
+	if len(key) > tcpMD5SigMaxKeyLen {
+		return nil, errors.New(fmt.Sprintf("MD5 key length %d is more than the max key length %d", len(key),
+			tcpMD5SigMaxKeyLen))
+	}
+
+	sig := &tcpMD5SigOpt{}
+	if ip4 := ip.To4(); ip4 != nil && family == syscall.AF_INET {
+		*(*uint16)(unsafe.Pointer(&sig.addr[0])) = syscall.AF_INET
+		copy(sig.addr[4:8], ip4)
+	} else if ip16 := ip.To16(); ip16 != nil && family == syscall.AF_INET6 {
+		*(*uint16)(unsafe.Pointer(&sig.addr[0])) = syscall.AF_INET6
+		copy(sig.addr[8:24], ip16)
+	} else {
+		return nil, errors.New(fmt.Sprintf("Address %s is not a valid IP address for address family %d", ip,
+			family))
+	}
+	sig.keyLen = uint16(len(key))
+	copy(sig.key[:], key)
+	return sig, nil
+}
+
+/*  Sets the MD5 key for the address, or for all the addresses in the prefix when prefixLen is
+ *  not negative. The key is built for the address family of the socket.
+ */
+func setsockoptTCPMD5(conn syscall.Conn, ip net.IP, prefixLen int, key string) error {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		family, err := syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_DOMAIN)
+		if err != nil {
+			sockErr = err
+			return
+		}
+
+		sig, err := newTCPMD5SigOpt(family, ip, key)
+		if err != nil {
+			sockErr = err
+			return
+		}
+
+		opt := tcpMD5Sig
+		if prefixLen >= 0 {
+			opt = tcpMD5SigExt
+			sig.flags = tcpMD5SigFlagPrefix
+			sig.prefixLen = uint8(prefixLen)
+		}
+		buf := (*[unsafe.Sizeof(*sig)]byte)(unsafe.Pointer(sig))[:]
+		sockErr = syscall.SetsockoptString(int(fd), syscall.IPPROTO_TCP, opt, string(buf))
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+/*  SetTCPConnMD5 sets the MD5 key for the peer address on a connected socket. Linux allows
+ *  the key to be replaced on an established session, the segments sent after this call are
+ *  signed with the new key. An empty key removes the key for the address.
+ */
+func SetTCPConnMD5(conn net.Conn, addr string, key string) error {
+	sysConn, ok := conn.(syscall.Conn)
+	if !ok {
+		return errors.New(fmt.Sprintf("Connection to %s does not support socket options", addr))
+	}
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return errors.New(fmt.Sprintf("Address %s is not a valid IP address", addr))
+	}
+	return setsockoptTCPMD5(sysConn, ip, -1, key)
+}
+
+// SetTCPListenerMD5Prefix sets the MD5 key for all the addresses in the prefix on the listener.
+func SetTCPListenerMD5Prefix(listener *net.TCPListener, prefix string, key string) error {
+	_, ipNet, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return err
+	}
+
+	ones, _ := ipNet.Mask.Size()
+	return setsockoptTCPMD5(listener, ipNet.IP, ones, key)
+}
@@ -0,0 +1,114 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __  
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  | 
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  | 
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   | 
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  | 
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__| 
+//                                                                                                           
+
+// ttl.go
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+const (
+	ipv6MinHopCount     = 73 // IPV6_MINHOPCOUNT
+	DefaultTTL      int = -1 // Resets the TTL of a socket to the default TTL of the system
+)
+
+/*  SetSocketTTL sets the TTL (hop limit for IPv6) of the packets sent on the socket. When
+ *  minTTL is not 0 the kernel drops the received packets with a lower TTL (RFC 5082).
+ */
+func SetSocketTTL(fd int, ipv6 bool, ttl uint8, minTTL uint8) error {
+	level, ttlOpt, minTTLOpt := syscall.IPPROTO_IP, syscall.IP_TTL, syscall.IP_MINTTL
+	if ipv6 {
+		level, ttlOpt, minTTLOpt = syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, ipv6MinHopCount
+	}
+
+	if err := syscall.SetsockoptInt(fd, level, ttlOpt, int(ttl)); err != nil {
+		return errors.New(fmt.Sprintf("Failed to set TTL %d with error %s", ttl, err))
+	}
+
+	if err := syscall.SetsockoptInt(fd, level, minTTLOpt, int(minTTL)); err != nil {
+		return errors.New(fmt.Sprintf("Failed to set min TTL %d with error %s", minTTL, err))
+	}
+	return nil
+}
+
+// SetTCPConnTTL sets the TTL and the min TTL on a connected or an accepted socket.
+func SetTCPConnTTL(conn *net.TCPConn, ttl uint8, minTTL uint8) error {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	ipv6 := false
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok && addr.IP.To4() == nil {
+		ipv6 = true
+	}
+
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		sockErr = SetSocketTTL(int(fd), ipv6, ttl, minTTL)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+/*  SetTCPListenerTTL sets the TTL (and the hop limit) of the packets sent on the listener
+ *  socket. The SYN-ACKs of the incoming connections are sent with this TTL, before the TTL
+ *  of the neighbor is set on the accepted socket. The IPv4 TTL is set on the AF_INET6
+ *  sockets too, it's used for the connections from the IPv4 peers.
+ */
+func SetTCPListenerTTL(listener *net.TCPListener, ttl int) error {
+	rawConn, err := listener.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		family, err := syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_DOMAIN)
+		if err != nil {
+			sockErr = err
+			return
+		}
+
+		if family == syscall.AF_INET6 {
+			if err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS,
+				ttl); err != nil {
+				sockErr = errors.New(fmt.Sprintf("Failed to set hop limit %d with error %s", ttl, err))
+				return
+			}
+		}
+		if err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl); err != nil {
+			sockErr = errors.New(fmt.Sprintf("Failed to set TTL %d with error %s", ttl, err))
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}